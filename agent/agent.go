@@ -2,45 +2,38 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"slices"
 	"strings"
-	"time"
 
 	"telegram-bot/tools"
 )
 
 const maxToolCalls = 20 // Allow enough iterations for test-fix cycles
 
-const systemPrompt = `You are a helpful AI assistant with access to tools.
+// promptIntro and promptGuide go around the list of tools in the main
+// model's instructions; see systemPrompt.
+const promptIntro = `You are a helpful AI assistant with access to tools.`
 
-TOOLS:
-- python: For Python code (simple scripts or code with tests)
-- bash: For shell commands and file operations  
-- oci: For container registry operations (inspect images, manifests, copy, annotate, etc.)
-- scrape: Fetch and summarize web pages
-- get_current_time: Get current time
-- get_calendar_events: Check calendar
-
-OCI TOOL (for container images):
+const promptGuide = `OCI TOOL (for container images):
 Use the oci tool for Docker/OCI image operations:
 - oci(operation="inspect", image="alpine:latest") - examine image metadata
 - oci(operation="manifest", image="ghcr.io/org/app:v1") - get raw manifest
 - oci(operation="list-tags", image="docker.io/library/nginx") - list all tags
 - oci(operation="copy", source="src:tag", dest="dst:tag") - copy between registries
 - oci(operation="annotate", image="myimage:v1", annotations='{"key":"value"}')
+- oci(operation="promote", image="app:1.2", from="staging") - promote to the next environment
+- oci(operation="watch", image="alpine", pattern="3.*") - notify this chat about new tags
 
 PYTHON TOOL OPERATIONS:
 1. run: Quick scripts - provide 'code' param, prints result immediately
 2. develop: Code with tests - provide name, implementation, tests. Runs tests automatically.
 
 SIMPLE TASKS (use python run):
-For "format as JSON", "calculate X":
+For "format as JSON":
   python(operation="run", code="import json; print(json.dumps({'key': 'value'}))")
 Return the output to user immediately.
 
@@ -52,18 +45,83 @@ If tests fail, you get errors. Fix with:
   python(operation="develop", name="mymodule", fix_implementation="def... # fixed")
 
 CRITICAL:
+- Never make up details only the user knows (a flight number, where they're starting from); leave them out of the tool call and the user will be asked for them
+- Use 'math' for any arithmetic or math question instead of computing it yourself; report its exact result
 - Use 'oci' tool for container/Docker image operations - NOT bash
-- Use 'scrape' for summarizing web pages
+- Use 'files' for reading and changing workspace files - NOT bash cat/echo/mv/rm
+- Use 'scrape' for summarizing web pages, and scrape(operation="watch", url=...) to be told when a page changes
+- Use 'reading_list' when the user wants to save a link for later or asks what they saved
+- Use 'places' for "near me" questions; it knows the location the user shared
+- For "when do I need to leave for my 3pm?", get the event from get_calendar_events, then call directions with to=<its location> and arrive_by=<its start>
+- Use 'tracking' for "is LH400 on time?" or "where is my package?"; tracking(operation="watch") reports changes to the chat
+- Use 'dictionary' for word meanings, pronunciations, origins, and synonyms instead of answering from memory; keep its IPA exactly as returned
+- Use 'media' for "is Dune any good?", "where can I stream Severance?", or "add it to my list"; media(operation="recommend") for what to read or watch next
+- Use 'health' for "how far did I run this week?"; health(operation="chart") sends a chart of trends
+- Use 'shopping_list' for "add milk to the list" or "what's on the shopping list?"
+- Use 'poll' for "poll the group for a dinner time": pick the question and options from the request, with close_in or close_at if they say when to decide
+- Use 'chat_admin' for "pin that docker command": pin with text=<the command itself>, not a summary of it
+- Use 'recipes' for cooking: search, then get with servings; recipes(operation="shop") adds a recipe's ingredients to the list
+- Use 'review' when asked to review a diff or an uploaded .patch/.diff file; pass file=<its workspace path> rather than copying it into diff
+- Use 'repo' (operation=ask) for questions about a cloned repository's code ("where is retry logic implemented?"); keep the file:line citations and Sources it returns
+- Use 'snippets' when the user wants to keep a command or code from the conversation ("save that ffmpeg command"), or asks for one they saved ("that ffmpeg command from last month" is snippets(operation="search", query="ffmpeg", range="last 30 days"))
+- Use 'sandbox' to run code when 'python' isn't available, or for code from someone else that shouldn't touch the workspace
 - Use 'run' for simple one-off scripts
 - Use 'develop' when tests are needed
 - When you get output, STOP and respond to user`
 
+// systemPrompt returns the main model's instructions, listing the tools
+// available for this request with the first sentence of each one's
+// description.
+func (a *Agent) systemPrompt(ctx context.Context) string {
+	available := a.registry.All()
+	available = slices.DeleteFunc(available, func(tool tools.Tool) bool {
+		return !a.registry.Available(ctx, tool.Name())
+	})
+	slices.SortFunc(available, func(x, y tools.Tool) int { return strings.Compare(x.Name(), y.Name()) })
+
+	var sb strings.Builder
+	sb.WriteString(promptIntro + "\n\nTOOLS:\n")
+	for _, tool := range available {
+		fmt.Fprintf(&sb, "- %s: %s\n", tool.Name(), toolSummary(tool.Description()))
+	}
+	sb.WriteString("\n" + promptGuide)
+	return sb.String()
+}
+
+// toolSummary returns the first sentence of a tool's description, leaving
+// out any list or details after it.
+func toolSummary(description string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(description), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-") {
+			break
+		}
+		lines = append(lines, line)
+	}
+	summary := strings.Join(lines, " ")
+	for i := 0; i < len(summary); {
+		end := strings.Index(summary[i:], ". ")
+		if end < 0 {
+			break
+		}
+		end += i
+		if !strings.HasSuffix(summary[:end], "e.g") && !strings.HasSuffix(summary[:end], "i.e") {
+			summary = summary[:end+1]
+			break
+		}
+		i = end + 2
+	}
+	return strings.TrimSuffix(summary, ":")
+}
+
 // Agent handles conversations with the LLM and executes tool calls.
 type Agent struct {
-	model    string
-	url      string
-	registry *tools.Registry
-	client   *http.Client
+	model      string
+	smallModel string // Tried first for trivial messages; empty to always use model
+	registry   *tools.Registry
+	client     LLMClient
+	hooks      hooks
 }
 
 // Message represents a chat message in the conversation.
@@ -87,41 +145,169 @@ type FunctionCall struct {
 	Arguments json.RawMessage `json:"arguments"`
 }
 
-type chatRequest struct {
+// ChatRequest is a single model call with the conversation so far.
+type ChatRequest struct {
 	Model    string           `json:"model"`
 	Messages []Message        `json:"messages"`
 	Tools    []map[string]any `json:"tools,omitempty"`
 	Stream   bool             `json:"stream"`
 }
 
-type chatResponse struct {
+// ChatResponse is the model's reply to a ChatRequest.
+type ChatResponse struct {
 	Message Message `json:"message"`
 }
 
-// New creates a new Agent with the given model, URL, and tool registry.
+// LLMClient sends chat requests to a language model backend.
+type LLMClient interface {
+	Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+
+	// Ping checks that the backend is reachable and the model is available.
+	Ping(ctx context.Context, model string) error
+}
+
+// StreamingClient is implemented by clients that can deliver the model's
+// answer as it's generated. ChatStream calls onChunk with each new piece
+// of text and returns the complete response at the end.
+type StreamingClient interface {
+	ChatStream(ctx context.Context, req ChatRequest, onChunk func(text string)) (*ChatResponse, error)
+}
+
+// New creates a new Agent that talks to Ollama at the given URL.
 func New(model, url string, registry *tools.Registry) *Agent {
+	return NewWithClient(model, NewOllamaClient(url), registry)
+}
+
+// NewWithClient creates a new Agent that uses the given LLM client.
+func NewWithClient(model string, client LLMClient, registry *tools.Registry) *Agent {
 	return &Agent{
 		model:    model,
-		url:      url,
 		registry: registry,
-		client: &http.Client{
-			Timeout: 120 * time.Second, // LLM responses can be slow
-		},
+		client:   client,
+	}
+}
+
+// Response is the agent's final answer plus any files tools produced along the way.
+type Response struct {
+	Text        string
+	Attachments []tools.Attachment
+
+	// Steps are the tool calls the model made on the way to Text and
+	// their results, for the caller to keep with the exchange so later
+	// messages can refer back to them.
+	Steps []Message
+
+	// Form is set, and Text empty, when the run stopped at a tool call
+	// that needs the user to fill in missing arguments.
+	Form *tools.Form
+}
+
+type historyKey struct{}
+
+// WithHistory returns a context carrying earlier messages of the
+// conversation, oldest first, for Respond to continue from.
+func WithHistory(ctx context.Context, history []Message) context.Context {
+	return context.WithValue(ctx, historyKey{}, history)
+}
+
+func historyFrom(ctx context.Context) []Message {
+	history, _ := ctx.Value(historyKey{}).([]Message)
+	return history
+}
+
+// StreamFunc receives the text of the model's answer so far, each time it
+// grows. A model call that ends in tool calls is followed by another, so
+// the text may start over; Response.Text is the final answer.
+type StreamFunc func(text string)
+
+type streamKey struct{}
+
+// WithStream returns a context whose model calls stream their answer to
+// fn, if the client can stream (see StreamingClient). fn is called from
+// the goroutine running Respond.
+func WithStream(ctx context.Context, fn StreamFunc) context.Context {
+	return context.WithValue(ctx, streamKey{}, fn)
+}
+
+func streamFrom(ctx context.Context) StreamFunc {
+	fn, _ := ctx.Value(streamKey{}).(StreamFunc)
+	return fn
+}
+
+// Document is text pinned to a conversation, such as a project README.
+type Document struct {
+	Name string
+	Text string
+}
+
+type pinnedKey struct{}
+
+// WithPinned returns a context carrying documents the model should keep in
+// view for every request, whatever the conversation history holds.
+func WithPinned(ctx context.Context, docs []Document) context.Context {
+	return context.WithValue(ctx, pinnedKey{}, docs)
+}
+
+// pinnedMessage returns the context's pinned documents as a system
+// message, or false if there are none.
+func pinnedMessage(ctx context.Context) (Message, bool) {
+	docs, _ := ctx.Value(pinnedKey{}).([]Document)
+	if len(docs) == 0 {
+		return Message{}, false
+	}
+	var sb strings.Builder
+	sb.WriteString("The user pinned these documents to the conversation. Treat them as standing context for every request:")
+	for _, d := range docs {
+		fmt.Fprintf(&sb, "\n\n--- %s ---\n%s", d.Name, strings.TrimSpace(d.Text))
 	}
+	return Message{Role: "system", Content: sb.String()}, true
 }
 
-// Chat sends a message and handles any tool calls in a loop.
-// The context is used for cancellation and passed to tool executions.
+// Chat sends a message and returns the text of the agent's answer.
 func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
-	messages := []Message{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userMessage},
+	resp, err := a.Respond(ctx, userMessage)
+	if err != nil {
+		return "", err
 	}
+	return resp.Text, nil
+}
+
+// Respond sends a message and handles any tool calls in a loop.
+// The context is used for cancellation and passed to tool executions, and
+// may carry earlier messages of the conversation (see WithHistory) and
+// pinned documents (see WithPinned).
+// Trivial messages are tried on the small model first, if one is set (see
+// UseSmallModel).
+func (a *Agent) Respond(ctx context.Context, userMessage string) (*Response, error) {
+	history := historyFrom(ctx)
+
+	if a.smallModel != "" && isTrivial(userMessage) {
+		resp, err := a.respondQuickly(ctx, history, userMessage)
+		if err == nil {
+			log.Printf("[agent] answered by %s", a.smallModel)
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		log.Printf("[agent] escalating to %s: %v", a.model, err)
+	}
+
+	messages := make([]Message, 0, len(history)+3)
+	messages = append(messages, Message{Role: "system", Content: a.systemPrompt(ctx)})
+	if pinned, ok := pinnedMessage(ctx); ok {
+		messages = append(messages, pinned)
+	}
+	messages = append(messages, history...)
+	messages = append(messages, Message{Role: "user", Content: userMessage})
+	start := len(messages)
+
+	var attachments []tools.Attachment
 
 	for i := 0; i < maxToolCalls; i++ {
-		resp, err := a.sendRequest(ctx, messages)
+		resp, err := a.sendRequest(ctx, a.model, messages, a.registry.ToOllamaFormat(ctx))
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		// If no tool calls, check if model output XML-style tool call as text
@@ -129,13 +315,14 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 			// Try to parse XML-style tool calls
 			if toolName, args, ok := parseXMLToolCall(resp.Message.Content); ok {
 				// Execute the parsed tool call
-				tool, exists := a.registry.Get(toolName)
-				if exists {
+				if _, exists := a.registry.Get(toolName); exists {
 					log.Printf("[agent] executing parsed tool: %s", toolName)
-					result, err := tool.Execute(ctx, args)
-					if err != nil {
-						result = fmt.Sprintf("Error: %v", err)
+					res, err := a.callTool(ctx, toolName, args)
+					if err == nil && res.Form != nil {
+						return &Response{Attachments: attachments, Form: res.Form}, nil
 					}
+					result, files := toolOutput(res, err)
+					attachments = append(attachments, files...)
 
 					// Add this exchange to messages and continue the loop
 					messages = append(messages, Message{Role: "assistant", Content: resp.Message.Content})
@@ -146,7 +333,7 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 
 			// No tool calls and no parseable XML - return the response
 			content := cleanResponse(resp.Message.Content)
-			return content, nil
+			return &Response{Text: content, Attachments: attachments, Steps: messages[start:]}, nil
 		}
 
 		// Add assistant message with tool calls
@@ -154,10 +341,13 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 
 		// Execute each tool call and add results
 		for _, tc := range resp.Message.ToolCalls {
-			result, err := a.executeTool(ctx, tc)
-			if err != nil {
-				result = fmt.Sprintf("Error: %v", err)
+			res, err := a.executeTool(ctx, tc)
+			if err == nil && res.Form != nil {
+				// The user answers the form; the tool runs when they're done
+				return &Response{Attachments: attachments, Form: res.Form}, nil
 			}
+			result, files := toolOutput(res, err)
+			attachments = append(attachments, files...)
 
 			messages = append(messages, Message{
 				Role:       "tool",
@@ -167,79 +357,57 @@ func (a *Agent) Chat(ctx context.Context, userMessage string) (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("exceeded maximum tool calls (%d)", maxToolCalls)
+	return nil, fmt.Errorf("exceeded maximum tool calls (%d)", maxToolCalls)
 }
 
-func (a *Agent) sendRequest(ctx context.Context, messages []Message) (*chatResponse, error) {
-	reqBody := chatRequest{
-		Model:    a.model,
-		Messages: messages,
-		Tools:    a.registry.ToOllamaFormat(),
-		Stream:   false,
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling request: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := a.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("calling Ollama: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+// toolOutput turns a tool result into the text the model sees, noting any
+// attached files so it can mention them, and returns the attachments.
+func toolOutput(res *tools.Result, err error) (string, []tools.Attachment) {
 	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
+		return fmt.Sprintf("Error: %v", err), nil
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(body))
+	text := res.Text
+	if len(res.Attachments) > 0 {
+		names := make([]string, len(res.Attachments))
+		for i, att := range res.Attachments {
+			names[i] = att.Name
+		}
+		text += fmt.Sprintf("\n\n[Files sent to the user as attachments: %s]", strings.Join(names, ", "))
 	}
+	return text, res.Attachments
+}
 
-	var chatResp chatResponse
-	if err := json.Unmarshal(body, &chatResp); err != nil {
-		return nil, fmt.Errorf("parsing response: %w", err)
-	}
+func (a *Agent) sendRequest(ctx context.Context, model string, messages []Message, tools []map[string]any) (*ChatResponse, error) {
+	return a.callModel(ctx, ChatRequest{
+		Model:    model,
+		Messages: messages,
+		Tools:    tools,
+		Stream:   false,
+	})
+}
 
-	// Debug logging
-	log.Printf("[agent] response: role=%s content_len=%d tool_calls=%d",
-		chatResp.Message.Role,
-		len(chatResp.Message.Content),
-		len(chatResp.Message.ToolCalls))
-	if len(chatResp.Message.Content) > 0 && len(chatResp.Message.Content) < 500 {
-		log.Printf("[agent] content: %s", chatResp.Message.Content)
-	} else if len(chatResp.Message.Content) >= 500 {
-		log.Printf("[agent] content (truncated): %s...", chatResp.Message.Content[:500])
+// Ping checks that the model backend is reachable and the configured
+// models are installed.
+func (a *Agent) Ping(ctx context.Context) error {
+	if err := a.client.Ping(ctx, a.model); err != nil {
+		return err
 	}
-	for i, tc := range chatResp.Message.ToolCalls {
-		log.Printf("[agent] tool_call[%d]: %s(%s)", i, tc.Function.Name, string(tc.Function.Arguments))
+	if a.smallModel != "" {
+		return a.client.Ping(ctx, a.smallModel)
 	}
-
-	return &chatResp, nil
+	return nil
 }
 
-func (a *Agent) executeTool(ctx context.Context, tc ToolCall) (string, error) {
-	tool, ok := a.registry.Get(tc.Function.Name)
-	if !ok {
-		return "", fmt.Errorf("unknown tool: %s", tc.Function.Name)
-	}
-
+func (a *Agent) executeTool(ctx context.Context, tc ToolCall) (*tools.Result, error) {
 	var args map[string]any
 	if len(tc.Function.Arguments) > 0 {
 		if err := json.Unmarshal(tc.Function.Arguments, &args); err != nil {
-			return "", fmt.Errorf("parsing tool arguments: %w", err)
+			return nil, fmt.Errorf("parsing tool arguments: %w", err)
 		}
 	}
 
-	return tool.Execute(ctx, args)
+	return a.callTool(ctx, tc.Function.Name, args)
 }
 
 // parseXMLToolCall attempts to parse XML-style tool calls that some models output as text
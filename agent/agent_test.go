@@ -0,0 +1,238 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"telegram-bot/agent"
+	"telegram-bot/agent/agenttest"
+	"telegram-bot/tools"
+)
+
+// echoTool returns its text argument and records each call.
+type echoTool struct {
+	calls []string
+}
+
+func (e *echoTool) Name() string               { return "echo" }
+func (e *echoTool) Description() string        { return "Repeats its text" }
+func (e *echoTool) Parameters() map[string]any { return map[string]any{"type": "object"} }
+
+func (e *echoTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	text, _ := args["text"].(string)
+	e.calls = append(e.calls, text)
+	return "echo: " + text, nil
+}
+
+// weatherTool needs a city only the user can give, so calls without one
+// become a form.
+type weatherTool struct {
+	ran bool
+}
+
+func (w *weatherTool) Name() string               { return "weather" }
+func (w *weatherTool) Description() string        { return "The weather in a city" }
+func (w *weatherTool) Parameters() map[string]any { return map[string]any{"type": "object"} }
+
+func (w *weatherTool) FormFields(ctx context.Context, args map[string]any) []tools.FormField {
+	if _, ok := args["city"]; ok {
+		return nil
+	}
+	return []tools.FormField{{Name: "city", Prompt: "Which city?"}}
+}
+
+func (w *weatherTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	w.ran = true
+	return "sunny", nil
+}
+
+func TestRespond(t *testing.T) {
+	loop := make([]agent.ChatResponse, 50)
+	for i := range loop {
+		loop[i] = agenttest.ToolCall("echo", map[string]any{"text": "again"})
+	}
+
+	tests := []struct {
+		name      string
+		responses []agent.ChatResponse
+		err       error
+		wantText  string
+		wantErr   string
+		wantEcho  []string // Texts the echo tool was called with
+		wantForm  string   // Tool the run stopped at for a form
+		wantCalls int      // Model calls made; zero skips the check
+	}{
+		{
+			name:      "plain answer",
+			responses: []agent.ChatResponse{agenttest.Text("Hello!")},
+			wantText:  "Hello!",
+			wantCalls: 1,
+		},
+		{
+			name: "native tool call",
+			responses: []agent.ChatResponse{
+				agenttest.ToolCall("echo", map[string]any{"text": "hi"}),
+				agenttest.Text("It said hi."),
+			},
+			wantText:  "It said hi.",
+			wantEcho:  []string{"hi"},
+			wantCalls: 2,
+		},
+		{
+			name: "XML tool call",
+			responses: []agent.ChatResponse{
+				agenttest.Text("<function=echo>\n<parameter=text>\nhi\n</parameter>\n</function>"),
+				agenttest.Text("It said hi."),
+			},
+			wantText:  "It said hi.",
+			wantEcho:  []string{"hi"},
+			wantCalls: 2,
+		},
+		{
+			name: "unknown tool",
+			responses: []agent.ChatResponse{
+				agenttest.ToolCall("nope", nil),
+				agenttest.Text("That didn't work."),
+			},
+			wantText:  "That didn't work.",
+			wantCalls: 2,
+		},
+		{
+			name:      "tool call limit",
+			responses: loop,
+			wantErr:   "exceeded maximum tool calls",
+		},
+		{
+			name:      "LLM error",
+			err:       errors.New("connection refused"),
+			wantErr:   "connection refused",
+			wantCalls: 1,
+		},
+		{
+			name: "form",
+			responses: []agent.ChatResponse{
+				agenttest.ToolCall("weather", map[string]any{}),
+			},
+			wantForm:  "weather",
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := &echoTool{}
+			weather := &weatherTool{}
+			registry := tools.NewRegistry()
+			registry.Register(echo)
+			registry.Register(weather)
+
+			llm := &agenttest.FakeLLM{Responses: tt.responses, Err: tt.err}
+			resp, err := agent.NewWithClient("test", llm, registry).Respond(context.Background(), "hi")
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Respond: %v", err)
+			}
+			if tt.wantCalls > 0 && len(llm.Requests()) != tt.wantCalls {
+				t.Errorf("model called %d times, want %d", len(llm.Requests()), tt.wantCalls)
+			}
+			if err != nil {
+				return
+			}
+
+			if strings.Join(echo.calls, ",") != strings.Join(tt.wantEcho, ",") {
+				t.Errorf("echo called with %q, want %q", echo.calls, tt.wantEcho)
+			}
+
+			if resp.Text != tt.wantText {
+				t.Errorf("Text = %q, want %q", resp.Text, tt.wantText)
+			}
+			switch {
+			case tt.wantForm == "" && resp.Form != nil:
+				t.Errorf("unexpected form for %s", resp.Form.Tool)
+			case tt.wantForm != "" && (resp.Form == nil || resp.Form.Tool != tt.wantForm):
+				t.Errorf("Form = %+v, want one for %s", resp.Form, tt.wantForm)
+			}
+			if weather.ran {
+				t.Error("weather ran without its city")
+			}
+		})
+	}
+}
+
+// TestRespondToolResult checks the model is given each tool's result,
+// answering the call it made.
+func TestRespondToolResult(t *testing.T) {
+	registry := tools.NewRegistry()
+	registry.Register(&echoTool{})
+	llm := &agenttest.FakeLLM{Responses: []agent.ChatResponse{
+		agenttest.ToolCall("echo", map[string]any{"text": "hi"}),
+		agenttest.Text("done"),
+	}}
+
+	resp, err := agent.NewWithClient("test", llm, registry).Respond(context.Background(), "say hi")
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+
+	reqs := llm.Requests()
+	if len(reqs[0].Tools) != 1 {
+		t.Errorf("offered %d tools, want 1", len(reqs[0].Tools))
+	}
+	last := reqs[1].Messages[len(reqs[1].Messages)-1]
+	if last.Role != "tool" || last.Content != "echo: hi" || last.ToolCallID != "call_echo" {
+		t.Errorf("last message = %+v, want the echo result for call_echo", last)
+	}
+	if len(resp.Steps) != 2 {
+		t.Errorf("kept %d steps, want the call and its result", len(resp.Steps))
+	}
+}
+
+// describedTool is a tool that only has a description.
+type describedTool struct {
+	name, description string
+}
+
+func (d *describedTool) Name() string               { return d.name }
+func (d *describedTool) Description() string        { return d.description }
+func (d *describedTool) Parameters() map[string]any { return map[string]any{"type": "object"} }
+
+func (d *describedTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	return "", nil
+}
+
+// TestSystemPrompt checks the instructions list the tools offered for the
+// request, each with the first sentence of its description.
+func TestSystemPrompt(t *testing.T) {
+	registry := tools.NewRegistry()
+	registry.Register(&describedTool{"dns", "Look up the DNS records of any domain: A, AAAA, and MX by\ndefault. Changes need approval."})
+	registry.Register(&describedTool{"poll", `Telegram polls, e.g. "poll the group". Posts and closes them.`})
+	registry.Register(&describedTool{"cloud", "Query the user's cloud accounts:\n- instances\n- buckets"})
+	registry.Register(&describedTool{"deps", "Check dependencies.\n\noperation=check looks them up."})
+	registry.Register(&describedTool{"bash", "Run shell commands."})
+	llm := &agenttest.FakeLLM{Responses: []agent.ChatResponse{agenttest.Text("ok")}}
+
+	ctx := tools.WithToolset(context.Background(), "dns", "poll", "cloud", "deps")
+	if _, err := agent.NewWithClient("test", llm, registry).Respond(ctx, "hi"); err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+
+	prompt := llm.Requests()[0].Messages[0].Content
+	want := `TOOLS:
+- cloud: Query the user's cloud accounts
+- deps: Check dependencies.
+- dns: Look up the DNS records of any domain: A, AAAA, and MX by default.
+- poll: Telegram polls, e.g. "poll the group".
+`
+	if !strings.Contains(prompt, want) {
+		t.Errorf("system prompt = %q, want it to list\n%s", prompt, want)
+	}
+	if strings.Contains(prompt, "- bash:") {
+		t.Error("the system prompt lists bash, which wasn't offered")
+	}
+}
@@ -0,0 +1,80 @@
+// Package agenttest provides test doubles for the agent package.
+package agenttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"telegram-bot/agent"
+)
+
+// FakeLLM is an agent.LLMClient that replays scripted responses and records
+// the requests it received.
+type FakeLLM struct {
+	// Responses are returned in order, one per Chat call.
+	Responses []agent.ChatResponse
+
+	// Err, if set, is returned by every Chat call.
+	Err error
+
+	// PingErr is returned by Ping.
+	PingErr error
+
+	mu       sync.Mutex
+	requests []agent.ChatRequest
+}
+
+func (f *FakeLLM) Chat(_ context.Context, req agent.ChatRequest) (*agent.ChatResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, req)
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	n := len(f.requests) - 1
+	if n >= len(f.Responses) {
+		return nil, fmt.Errorf("unexpected call %d: only %d responses scripted", n+1, len(f.Responses))
+	}
+	resp := f.Responses[n]
+	return &resp, nil
+}
+
+func (f *FakeLLM) Ping(_ context.Context, _ string) error {
+	return f.PingErr
+}
+
+// Requests returns the requests received so far.
+func (f *FakeLLM) Requests() []agent.ChatRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]agent.ChatRequest(nil), f.requests...)
+}
+
+// Text returns a response with plain assistant content.
+func Text(content string) agent.ChatResponse {
+	return agent.ChatResponse{
+		Message: agent.Message{Role: "assistant", Content: content},
+	}
+}
+
+// ToolCall returns a response in which the model calls a single tool.
+func ToolCall(name string, args map[string]any) agent.ChatResponse {
+	raw, err := json.Marshal(args)
+	if err != nil {
+		panic(err)
+	}
+	return agent.ChatResponse{
+		Message: agent.Message{
+			Role: "assistant",
+			ToolCalls: []agent.ToolCall{{
+				ID:       "call_" + name,
+				Type:     "function",
+				Function: agent.FunctionCall{Name: name, Arguments: raw},
+			}},
+		},
+	}
+}
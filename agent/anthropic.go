@@ -0,0 +1,297 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultAnthropicURL = "https://api.anthropic.com"
+	anthropicVersion    = "2023-06-01"
+	anthropicMaxTokens  = 8192
+)
+
+// anthropicToolID is what the API accepts as a tool call ID.
+var anthropicToolID = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// AnthropicClient calls Anthropic's Messages API.
+type AnthropicClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// NewAnthropicClient creates a client for the Messages API at baseURL, or
+// Anthropic's if it's empty.
+func NewAnthropicClient(baseURL, apiKey string) *AnthropicClient {
+	if baseURL == "" {
+		baseURL = defaultAnthropicURL
+	}
+	return &AnthropicClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: hostedLLMTimeout},
+	}
+}
+
+// anthropicBlock is one block of a message's content: text, a tool call
+// (tool_use), or a tool's result.
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+// anthropicRequestFor converts a request to the Messages API's format.
+// System messages become the system prompt, tool results become user
+// messages, and consecutive messages from the same side are merged, since
+// the API wants user and assistant to take turns. Tool calls without
+// usable IDs get numbered ones, matched to their results in order.
+func anthropicRequestFor(req ChatRequest) anthropicRequest {
+	out := anthropicRequest{Model: req.Model, MaxTokens: anthropicMaxTokens}
+	var system []string
+	var pending []string // IDs of the latest calls, for results that lack one
+	add := func(role string, blocks ...anthropicBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == role {
+			out.Messages[n-1].Content = append(out.Messages[n-1].Content, blocks...)
+			return
+		}
+		out.Messages = append(out.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	text := func(s string) []anthropicBlock {
+		if strings.TrimSpace(s) == "" {
+			return nil // The API rejects empty text
+		}
+		return []anthropicBlock{{Type: "text", Text: s}}
+	}
+
+	for i, m := range req.Messages {
+		switch m.Role {
+		case "system":
+			system = append(system, m.Content)
+		case "assistant":
+			blocks := text(m.Content)
+			pending = pending[:0]
+			for j, tc := range m.ToolCalls {
+				id := tc.ID
+				if !anthropicToolID.MatchString(id) {
+					id = fmt.Sprintf("call_%d_%d", i, j)
+				}
+				pending = append(pending, id)
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    id,
+					Name:  tc.Function.Name,
+					Input: json.RawMessage(argumentsText(tc.Function.Arguments)),
+				})
+			}
+			add("assistant", blocks...)
+		case "tool":
+			if len(pending) == 0 {
+				add("user", text(m.Content)...) // A result whose call was trimmed away
+				continue
+			}
+			id := m.ToolCallID
+			k := slices.Index(pending, id)
+			if k < 0 {
+				k, id = 0, pending[0]
+			}
+			pending = slices.Delete(pending, k, k+1)
+			content := m.Content
+			if content == "" {
+				content = "(no output)"
+			}
+			add("user", anthropicBlock{Type: "tool_result", ToolUseID: id, Content: content})
+		default:
+			add("user", text(m.Content)...)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		fn, _ := tool["function"].(map[string]any)
+		name, _ := fn["name"].(string)
+		description, _ := fn["description"].(string)
+		out.Tools = append(out.Tools, anthropicTool{Name: name, Description: description, InputSchema: fn["parameters"]})
+	}
+	return out
+}
+
+// fromAnthropic converts a reply's content blocks to the agent's format.
+func fromAnthropic(blocks []anthropicBlock) Message {
+	m := Message{Role: "assistant"}
+	var text strings.Builder
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			text.WriteString(b.Text)
+		case "tool_use":
+			m.ToolCalls = append(m.ToolCalls, ToolCall{
+				ID:       b.ID,
+				Type:     "function",
+				Function: FunctionCall{Name: b.Name, Arguments: toolArguments(string(b.Input))},
+			})
+		}
+	}
+	m.Content = text.String()
+	return m
+}
+
+func (a *AnthropicClient) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	resp, err := a.post(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var message struct {
+		Content []anthropicBlock `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	chatResp := &ChatResponse{Message: fromAnthropic(message.Content)}
+	logResponse(chatResp)
+	return chatResp, nil
+}
+
+// ChatStream streams the answer as server-sent events: each content block
+// starts, grows by deltas (text, or a tool call's input as partial JSON),
+// and stops.
+func (a *AnthropicClient) ChatStream(ctx context.Context, req ChatRequest, onChunk func(text string)) (*ChatResponse, error) {
+	resp, err := a.post(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var blocks []anthropicBlock
+	var inputs []string // Partial JSON of each tool_use block
+	err = readEvents(resp.Body, func(event, data string) error {
+		var e struct {
+			Index        int            `json:"index"`
+			ContentBlock anthropicBlock `json:"content_block"`
+			Delta        struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+			} `json:"delta"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return fmt.Errorf("parsing stream: %w", err)
+		}
+		switch event {
+		case "content_block_start":
+			for len(blocks) <= e.Index {
+				blocks = append(blocks, anthropicBlock{})
+				inputs = append(inputs, "")
+			}
+			blocks[e.Index] = e.ContentBlock
+		case "content_block_delta":
+			if e.Index >= len(blocks) {
+				return nil
+			}
+			switch e.Delta.Type {
+			case "text_delta":
+				blocks[e.Index].Text += e.Delta.Text
+				onChunk(e.Delta.Text)
+			case "input_json_delta":
+				inputs[e.Index] += e.Delta.PartialJSON
+			}
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			return fmt.Errorf("the model API: %s", e.Error.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range blocks {
+		if blocks[i].Type == "tool_use" && inputs[i] != "" {
+			blocks[i].Input = json.RawMessage(inputs[i])
+		}
+	}
+	chatResp := &ChatResponse{Message: fromAnthropic(blocks)}
+	logResponse(chatResp)
+	return chatResp, nil
+}
+
+// post sends a Messages API request and checks its status.
+func (a *AnthropicClient) post(ctx context.Context, req ChatRequest, stream bool) (*http.Response, error) {
+	payload := anthropicRequestFor(req)
+	payload.Stream = stream
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	a.authorize(httpReq)
+	return checkStatus(a.client.Do(httpReq))
+}
+
+func (a *AnthropicClient) authorize(req *http.Request) {
+	req.Header.Set("x-api-key", a.apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+}
+
+// Ping checks the key works and the model exists.
+func (a *AnthropicClient) Ping(ctx context.Context, model string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.baseURL+"/v1/models/"+model, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	a.authorize(req)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling the model API: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("model %s is not available", model)
+	}
+	_, err = checkStatus(resp, nil)
+	return err
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"telegram-bot/tools"
+)
+
+// ModelCall is one request to the model. Before hooks see only Request and
+// may change it; after hooks also see the outcome and may change Response.
+type ModelCall struct {
+	Request  *ChatRequest
+	Response *ChatResponse
+	Err      error
+	Duration time.Duration
+}
+
+// ToolInvocation is one tool call requested by the model. Before hooks see
+// only Name and Args and may change Args; after hooks also see the outcome
+// and may change Result.
+type ToolInvocation struct {
+	Name     string
+	Args     map[string]any
+	Result   *tools.Result
+	Err      error
+	Duration time.Duration
+}
+
+// hooks are the callbacks layered around the agentic loop, each run in the
+// order it was registered.
+type hooks struct {
+	beforeModel []func(context.Context, *ModelCall) error
+	afterModel  []func(context.Context, *ModelCall)
+	beforeTool  []func(context.Context, *ToolInvocation) error
+	afterTool   []func(context.Context, *ToolInvocation)
+}
+
+// OnBeforeModelCall registers a hook that runs before each model request.
+// Returning an error aborts the request, and Respond returns the error.
+// Hooks must be registered before the agent is used.
+func (a *Agent) OnBeforeModelCall(fn func(ctx context.Context, call *ModelCall) error) {
+	a.hooks.beforeModel = append(a.hooks.beforeModel, fn)
+}
+
+// OnAfterModelCall registers a hook that runs after each model request,
+// including failed ones.
+func (a *Agent) OnAfterModelCall(fn func(ctx context.Context, call *ModelCall)) {
+	a.hooks.afterModel = append(a.hooks.afterModel, fn)
+}
+
+// OnBeforeToolCall registers a hook that runs before each tool call.
+// Returning an error skips the tool; the model sees the error as the tool's
+// result, so guardrails and approvals can refuse a call and let the model
+// adjust.
+func (a *Agent) OnBeforeToolCall(fn func(ctx context.Context, call *ToolInvocation) error) {
+	a.hooks.beforeTool = append(a.hooks.beforeTool, fn)
+}
+
+// OnAfterToolCall registers a hook that runs after each tool call,
+// including failed and refused ones.
+func (a *Agent) OnAfterToolCall(fn func(ctx context.Context, call *ToolInvocation)) {
+	a.hooks.afterTool = append(a.hooks.afterTool, fn)
+}
+
+// callModel sends a request to the model through the model hooks.
+func (a *Agent) callModel(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	call := &ModelCall{Request: &req}
+	for _, fn := range a.hooks.beforeModel {
+		if err := fn(ctx, call); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	if streamer, ok := a.client.(StreamingClient); ok && streamFrom(ctx) != nil {
+		call.Response, call.Err = streamer.ChatStream(ctx, *call.Request, streamText(streamFrom(ctx)))
+	} else {
+		call.Response, call.Err = a.client.Chat(ctx, *call.Request)
+	}
+	call.Duration = time.Since(start)
+
+	for _, fn := range a.hooks.afterModel {
+		fn(ctx, call)
+	}
+	return call.Response, call.Err
+}
+
+// streamText collects streamed pieces of an answer and passes the text so
+// far to fn. Once the text turns out to be a tool call written as XML,
+// which isn't meant for the user, it stops passing it on.
+func streamText(fn StreamFunc) func(string) {
+	var text strings.Builder
+	return func(chunk string) {
+		text.WriteString(chunk)
+		if !strings.Contains(text.String(), "<function=") {
+			fn(text.String())
+		}
+	}
+}
+
+// callTool runs a tool through the tool hooks.
+func (a *Agent) callTool(ctx context.Context, name string, args map[string]any) (*tools.Result, error) {
+	call := &ToolInvocation{Name: name, Args: args}
+
+	start := time.Now()
+	call.Err = a.beforeTool(ctx, call)
+	if call.Err == nil {
+		if tool, ok := a.registry.Get(name); ok {
+			if form := tools.FormFor(ctx, tool, call.Args); form != nil {
+				call.Result = &tools.Result{Text: "Waiting for the user to fill in the missing details", Form: form}
+			} else {
+				call.Result, call.Err = tools.Run(ctx, tool, call.Args)
+			}
+		} else {
+			call.Err = fmt.Errorf("unknown tool: %s", name)
+		}
+	}
+	call.Duration = time.Since(start)
+
+	for _, fn := range a.hooks.afterTool {
+		fn(ctx, call)
+	}
+	return call.Result, call.Err
+}
+
+func (a *Agent) beforeTool(ctx context.Context, call *ToolInvocation) error {
+	for _, fn := range a.hooks.beforeTool {
+		if err := fn(ctx, call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"telegram-bot/balance"
+)
+
+// OllamaClient calls Ollama's /api/chat endpoint, on whichever instance in
+// its pool is least busy.
+type OllamaClient struct {
+	pool   *balance.Pool
+	client *http.Client
+}
+
+// NewOllamaClient creates a client for the Ollama chat endpoint at url.
+func NewOllamaClient(url string) *OllamaClient {
+	return NewPooledOllamaClient(balance.New([]string{url}))
+}
+
+// NewPooledOllamaClient creates a client that spreads requests across the
+// pool's instances.
+func NewPooledOllamaClient(pool *balance.Pool) *OllamaClient {
+	return &OllamaClient{
+		pool:   pool,
+		client: pool.Client(120 * time.Second), // LLM responses can be slow
+	}
+}
+
+func (o *OllamaClient) Chat(ctx context.Context, reqBody ChatRequest) (*ChatResponse, error) {
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	var body []byte
+	err = o.pool.Do(ctx, func(url string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonBody))
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := o.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("calling Ollama: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("reading response: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(body))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var chatResp ChatResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	logResponse(&chatResp)
+	return &chatResp, nil
+}
+
+// ChatStream asks Ollama to stream its answer, calling onChunk with each
+// piece of text as it arrives, and returns the whole answer once it's done.
+// Tool calls come in the chunks alongside the text.
+func (o *OllamaClient) ChatStream(ctx context.Context, reqBody ChatRequest, onChunk func(text string)) (*ChatResponse, error) {
+	reqBody.Stream = true
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	var chatResp ChatResponse
+	err = o.pool.Do(ctx, func(url string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonBody))
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := o.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("calling Ollama: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(body))
+		}
+
+		// Each line is a chunk of the answer; the last one has done set
+		chatResp = ChatResponse{Message: Message{Role: "assistant"}}
+		var content strings.Builder
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var chunk struct {
+				Message Message `json:"message"`
+				Done    bool    `json:"done"`
+				Error   string  `json:"error"`
+			}
+			if err := decoder.Decode(&chunk); err == io.EOF {
+				return fmt.Errorf("Ollama ended the stream early")
+			} else if err != nil {
+				return fmt.Errorf("reading stream: %w", err)
+			}
+			if chunk.Error != "" {
+				return fmt.Errorf("Ollama: %s", chunk.Error)
+			}
+			if chunk.Message.Content != "" {
+				content.WriteString(chunk.Message.Content)
+				onChunk(chunk.Message.Content)
+			}
+			chatResp.Message.ToolCalls = append(chatResp.Message.ToolCalls, chunk.Message.ToolCalls...)
+			if chunk.Done {
+				chatResp.Message.Content = content.String()
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	logResponse(&chatResp)
+	return &chatResp, nil
+}
+
+// logResponse logs the model's answer for debugging.
+func logResponse(chatResp *ChatResponse) {
+	log.Printf("[agent] response: role=%s content_len=%d tool_calls=%d",
+		chatResp.Message.Role,
+		len(chatResp.Message.Content),
+		len(chatResp.Message.ToolCalls))
+	if len(chatResp.Message.Content) > 0 && len(chatResp.Message.Content) < 500 {
+		log.Printf("[agent] content: %s", chatResp.Message.Content)
+	} else if len(chatResp.Message.Content) >= 500 {
+		log.Printf("[agent] content (truncated): %s...", chatResp.Message.Content[:500])
+	}
+	for i, tc := range chatResp.Message.ToolCalls {
+		log.Printf("[agent] tool_call[%d]: %s(%s)", i, tc.Function.Name, string(tc.Function.Arguments))
+	}
+}
+
+// Ping succeeds if at least one instance in the pool is reachable and has
+// the model installed.
+func (o *OllamaClient) Ping(ctx context.Context, model string) error {
+	var errs []error
+	for _, url := range o.pool.URLs() {
+		err := o.ping(ctx, url, model)
+		if err == nil {
+			return nil
+		}
+		if len(o.pool.URLs()) > 1 {
+			err = fmt.Errorf("%s: %w", url, err)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (o *OllamaClient) ping(ctx context.Context, url, model string) error {
+	tagsURL := strings.Replace(url, "/api/chat", "/api/tags", 1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tagsURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Ollama returned status %d", resp.StatusCode)
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+
+	for _, m := range tags.Models {
+		if m.Name == model {
+			return nil
+		}
+	}
+	return fmt.Errorf("model %s is not installed", model)
+}
@@ -0,0 +1,332 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOpenAIURL = "https://api.openai.com/v1"
+	hostedLLMTimeout = 120 * time.Second // LLM responses can be slow
+)
+
+// OpenAIClient calls an OpenAI-compatible chat completions API: OpenAI
+// itself, or servers such as vLLM, llama.cpp, LM Studio, and OpenRouter.
+type OpenAIClient struct {
+	baseURL string // Up to and including /v1
+	apiKey  string // Empty for servers without authentication
+	client  *http.Client
+}
+
+// NewOpenAIClient creates a client for the API at baseURL, such as
+// https://api.openai.com/v1, or OpenAI's if it's empty.
+func NewOpenAIClient(baseURL, apiKey string) *OpenAIClient {
+	if baseURL == "" {
+		baseURL = defaultOpenAIURL
+	}
+	return &OpenAIClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: hostedLLMTimeout},
+	}
+}
+
+// openAIMessage is a chat message in OpenAI's format, where tool call
+// arguments are a JSON string rather than an object.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    *string          `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIRequest struct {
+	Model    string           `json:"model"`
+	Messages []openAIMessage  `json:"messages"`
+	Tools    []map[string]any `json:"tools,omitempty"`
+	Stream   bool             `json:"stream,omitempty"`
+}
+
+// openAIMessages converts messages to OpenAI's format. Tool calls from
+// models that don't give them IDs are numbered, and their results matched
+// to them in order, since the API pairs the two by ID.
+func openAIMessages(messages []Message) []openAIMessage {
+	out := make([]openAIMessage, 0, len(messages))
+	var pending []string // IDs of the latest calls, for results that lack one
+	for i, m := range messages {
+		om := openAIMessage{Role: m.Role, Content: &m.Content, ToolCallID: m.ToolCallID}
+		if len(m.ToolCalls) > 0 {
+			pending = pending[:0]
+			for j, tc := range m.ToolCalls {
+				call := openAIToolCall{ID: tc.ID, Type: "function"}
+				if call.ID == "" {
+					call.ID = fmt.Sprintf("call_%d_%d", i, j)
+				}
+				call.Function.Name = tc.Function.Name
+				call.Function.Arguments = argumentsText(tc.Function.Arguments)
+				om.ToolCalls = append(om.ToolCalls, call)
+				pending = append(pending, call.ID)
+			}
+		}
+		if m.Role == "tool" && len(pending) > 0 {
+			i := slices.Index(pending, om.ToolCallID)
+			if i < 0 {
+				i, om.ToolCallID = 0, pending[0]
+			}
+			pending = slices.Delete(pending, i, i+1)
+		}
+		out = append(out, om)
+	}
+	return out
+}
+
+// argumentsText returns tool call arguments as the JSON object text APIs
+// expect, "{}" when there are none.
+func argumentsText(args json.RawMessage) string {
+	if len(bytes.TrimSpace(args)) == 0 || string(args) == "null" {
+		return "{}"
+	}
+	return string(args)
+}
+
+// toolArguments turns the arguments a hosted model wrote back into JSON,
+// wrapping text that isn't so the tool reports the problem.
+func toolArguments(args string) json.RawMessage {
+	if strings.TrimSpace(args) == "" {
+		return json.RawMessage("{}")
+	}
+	if !json.Valid([]byte(args)) {
+		quoted, _ := json.Marshal(args)
+		return json.RawMessage(`{"invalid_arguments":` + string(quoted) + `}`)
+	}
+	return json.RawMessage(args)
+}
+
+// fromOpenAI converts a reply message back to the agent's format.
+func fromOpenAI(om openAIMessage) Message {
+	m := Message{Role: "assistant"}
+	if om.Content != nil {
+		m.Content = *om.Content
+	}
+	for _, tc := range om.ToolCalls {
+		m.ToolCalls = append(m.ToolCalls, ToolCall{
+			ID:       tc.ID,
+			Type:     "function",
+			Function: FunctionCall{Name: tc.Function.Name, Arguments: toolArguments(tc.Function.Arguments)},
+		})
+	}
+	return m
+}
+
+func (o *OpenAIClient) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	resp, err := o.post(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var completion struct {
+		Choices []struct {
+			Message openAIMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, fmt.Errorf("the API returned no choices")
+	}
+	chatResp := &ChatResponse{Message: fromOpenAI(completion.Choices[0].Message)}
+	logResponse(chatResp)
+	return chatResp, nil
+}
+
+// ChatStream streams the answer as server-sent events. Tool calls arrive
+// in fragments, numbered by index, that are joined at the end.
+func (o *OpenAIClient) ChatStream(ctx context.Context, req ChatRequest, onChunk func(text string)) (*ChatResponse, error) {
+	resp, err := o.post(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var calls []openAIToolCall
+	err = readEvents(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			return errStreamDone
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						openAIToolCall
+						Index int `json:"index"` // Which call the fragment belongs to
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("parsing stream: %w", err)
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			onChunk(delta.Content)
+		}
+		for _, fragment := range delta.ToolCalls {
+			for len(calls) <= fragment.Index {
+				calls = append(calls, openAIToolCall{})
+			}
+			call := &calls[fragment.Index]
+			if fragment.ID != "" {
+				call.ID = fragment.ID
+			}
+			if fragment.Function.Name != "" {
+				call.Function.Name = fragment.Function.Name
+			}
+			call.Function.Arguments += fragment.Function.Arguments
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	text := content.String()
+	chatResp := &ChatResponse{Message: fromOpenAI(openAIMessage{Content: &text, ToolCalls: calls})}
+	logResponse(chatResp)
+	return chatResp, nil
+}
+
+// post sends a chat completion request and checks its status.
+func (o *OpenAIClient) post(ctx context.Context, req ChatRequest, stream bool) (*http.Response, error) {
+	body, err := json.Marshal(openAIRequest{
+		Model:    req.Model,
+		Messages: openAIMessages(req.Messages),
+		Tools:    req.Tools,
+		Stream:   stream,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	o.authorize(httpReq)
+	return checkStatus(o.client.Do(httpReq))
+}
+
+func (o *OpenAIClient) authorize(req *http.Request) {
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+}
+
+// Ping checks the API answers and, if it lists its models, has the model.
+func (o *OpenAIClient) Ping(ctx context.Context, model string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	o.authorize(req)
+	resp, err := checkStatus(o.client.Do(req))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var models struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	if len(models.Data) == 0 {
+		return nil
+	}
+	for _, m := range models.Data {
+		if m.ID == model {
+			return nil
+		}
+	}
+	return fmt.Errorf("model %s is not available", model)
+}
+
+// checkStatus passes on a successful response, and turns any other into
+// an error carrying the start of its body, which says what went wrong.
+func checkStatus(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, fmt.Errorf("calling the model API: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return nil, fmt.Errorf("the model API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// errStreamDone ends readEvents early, without an error.
+var errStreamDone = fmt.Errorf("stream done")
+
+// readEvents reads server-sent events, calling fn with each one's type
+// and data until the stream ends or fn returns an error.
+func readEvents(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if err := fn(event, strings.Join(data, "\n")); err == errStreamDone {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stream: %w", err)
+	}
+	if len(data) > 0 {
+		if err := fn(event, strings.Join(data, "\n")); err != nil && err != errStreamDone {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"telegram-bot/priority"
+	"telegram-bot/tools"
+)
+
+const (
+	maxTrivialLength  = 160 // Longer messages always go to the main model
+	maxQuickToolCalls = 3
+
+	// What the small model answers when a message is beyond it
+	escalateMarker = "ESCALATE"
+)
+
+const quickPrompt = `You are a helpful AI assistant. Answer briefly and directly.
+
+You only handle simple requests: greetings and small talk, unit conversions, simple arithmetic, and questions about the current date or time (use get_current_time for those).
+
+If a request needs anything else - writing or running code, files, web pages, calendars, container images, or a long or careful explanation - reply with exactly ` + escalateMarker + ` and nothing else.`
+
+var errEscalate = errors.New("needs the main model")
+
+// UseSmallModel makes the agent try trivial messages (greetings, unit
+// conversions, time questions) on a small, fast model first. The small
+// model may only use quick read-only tools; if it reaches for any other
+// tool, says the request is beyond it, or fails, the message goes to the
+// main model as usual. Call it before the agent is used.
+func (a *Agent) UseSmallModel(model string) {
+	a.smallModel = model
+}
+
+// isTrivial reports whether a message is short and simple enough to try
+// on the small model.
+func isTrivial(message string) bool {
+	message = strings.TrimSpace(message)
+	return message != "" &&
+		len(message) <= maxTrivialLength &&
+		!strings.Contains(message, "\n") &&
+		priority.Classify(message) == priority.Light
+}
+
+// respondQuickly answers a message with the small model, or returns an
+// error saying why the main model is needed. Only quick tools are offered,
+// and none of them change anything, so an escalated attempt leaves nothing
+// behind.
+func (a *Agent) respondQuickly(ctx context.Context, history []Message, userMessage string) (*Response, error) {
+	ctx = WithStream(ctx, nil) // Its answers are short, and it may escalate rather than answer
+	quick := a.quickTools()
+	offered := make([]map[string]any, 0, len(quick))
+	for _, tool := range a.registry.ToOllamaFormat(ctx) {
+		if fn, _ := tool["function"].(map[string]any); quick[fmt.Sprint(fn["name"])] {
+			offered = append(offered, tool)
+		}
+	}
+
+	messages := make([]Message, 0, len(history)+3)
+	messages = append(messages, Message{Role: "system", Content: quickPrompt})
+	if pinned, ok := pinnedMessage(ctx); ok {
+		messages = append(messages, pinned)
+	}
+	messages = append(messages, history...)
+	messages = append(messages, Message{Role: "user", Content: userMessage})
+	start := len(messages)
+
+	var attachments []tools.Attachment
+
+	for i := 0; i < maxQuickToolCalls; i++ {
+		resp, err := a.sendRequest(ctx, a.smallModel, messages, offered)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(resp.Message.ToolCalls) == 0 {
+			content := strings.TrimSpace(resp.Message.Content)
+			if content == "" || strings.Contains(content, escalateMarker) {
+				return nil, errEscalate
+			}
+			if _, _, ok := parseXMLToolCall(content); ok {
+				return nil, fmt.Errorf("%w: tool call in text", errEscalate)
+			}
+			return &Response{Text: content, Attachments: attachments, Steps: messages[start:]}, nil
+		}
+
+		messages = append(messages, resp.Message)
+		for _, tc := range resp.Message.ToolCalls {
+			if !quick[tc.Function.Name] {
+				return nil, fmt.Errorf("%w: wants %s", errEscalate, tc.Function.Name)
+			}
+			res, err := a.executeTool(ctx, tc)
+			if err == nil && res.Form != nil {
+				return &Response{Attachments: attachments, Form: res.Form}, nil
+			}
+			result, files := toolOutput(res, err)
+			attachments = append(attachments, files...)
+			messages = append(messages, Message{Role: "tool", Content: result, ToolCallID: tc.ID})
+		}
+	}
+	return nil, fmt.Errorf("%w: too many tool calls", errEscalate)
+}
+
+// quickTools returns the names of the tools the small model may use:
+// cheap ones that don't change anything.
+func (a *Agent) quickTools() map[string]bool {
+	quick := make(map[string]bool)
+	for _, tool := range a.registry.All() {
+		meta := tools.MetadataOf(tool)
+		if meta.ReadOnly && meta.Cost == tools.CostLow {
+			quick[tool.Name()] = true
+		}
+	}
+	return quick
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const summaryPrompt = `You write short recaps of conversations between a user and an AI assistant.
+
+Recap the conversation you are given under these headings, leaving out any heading with nothing under it:
+Decisions: what was decided or settled, and the approach chosen
+Files: files created or changed, and what each is for
+Open questions: what is unresolved, failed, or was left for later
+
+Use short bullet points. Be specific: name functions, commands, versions, and numbers. Don't describe the back-and-forth or repeat failed attempts that were later fixed. Stay under 200 words.`
+
+const condensePrompt = `You condense documents a user wants an AI assistant to keep in mind while helping them.
+
+Rewrite the document you are given in at most %d words, keeping what the assistant would need to answer questions and do work in line with it: purpose, names, commands, paths, versions, settings, conventions, and rules. Drop examples, history, and boilerplate. Use short bullet points under the document's own headings. Write only the condensed document.`
+
+const maxCondenseInput = 60000 // Characters of a document sent to be condensed
+
+// Condense shortens a document to at most about maxWords words, keeping
+// the facts an assistant needs to work with it. Documents over 60,000
+// characters are cut before condensing.
+func (a *Agent) Condense(ctx context.Context, name, text string, maxWords int) (string, error) {
+	if len(text) > maxCondenseInput {
+		text = strings.ToValidUTF8(text[:maxCondenseInput], "")
+	}
+	resp, err := a.sendRequest(ctx, a.model, []Message{
+		{Role: "system", Content: fmt.Sprintf(condensePrompt, maxWords)},
+		{Role: "user", Content: fmt.Sprintf("Condense this document (%s):\n\n%s", name, text)},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	condensed := strings.TrimSpace(cleanResponse(resp.Message.Content))
+	if condensed == "" {
+		return "", fmt.Errorf("the model returned an empty document")
+	}
+	return condensed, nil
+}
+
+// Complete answers a single prompt with the main model, without tools,
+// history, or streaming, for tools that summarize or explain what they
+// fetched.
+func (a *Agent) Complete(ctx context.Context, prompt string) (string, error) {
+	ctx = WithStream(ctx, nil) // The answer is the tool's to use, not the user's to watch
+	resp, err := a.sendRequest(ctx, a.model, []Message{{Role: "user", Content: prompt}}, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Message.Content), nil
+}
+
+// Summarize recaps a conversation: what was decided, which files were
+// created or changed, and what is still open. Notes add context the
+// messages don't show, such as files the tools changed. It makes one model
+// call without tools, so the recap can also stand in for the messages when
+// a conversation grows too long.
+func (a *Agent) Summarize(ctx context.Context, history []Message, notes string) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range history {
+		switch msg.Role {
+		case "user":
+			transcript.WriteString("User: ")
+		case "assistant":
+			transcript.WriteString("Assistant: ")
+		default:
+			continue
+		}
+		transcript.WriteString(strings.TrimSpace(msg.Content) + "\n\n")
+	}
+	if notes != "" {
+		transcript.WriteString("Notes:\n" + notes + "\n")
+	}
+
+	resp, err := a.sendRequest(ctx, a.model, []Message{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: "Recap this conversation:\n\n" + transcript.String()},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	summary := strings.TrimSpace(cleanResponse(resp.Message.Content))
+	if summary == "" {
+		return "", fmt.Errorf("the model returned an empty summary")
+	}
+	return summary, nil
+}
+
+const eventPrompt = `You turn webhook payloads that other services post to a chat bot into short chat notifications.
+
+Say what happened in one to three sentences, most important first: what changed or broke, where, and who did it, with names, numbers, and statuses from the payload. Add the single most useful link in the payload, if there is one, on its own line. Leave out IDs, timestamps, and fields that don't help a reader decide whether to act. Write only the notification.`
+
+const maxEventInput = 20000 // Characters of an event payload sent to be described
+
+// DescribeEvent writes a short chat notification for a JSON event that a
+// service posted, such as a monitoring alert. Payloads over 20,000
+// characters are cut first.
+func (a *Agent) DescribeEvent(ctx context.Context, source, payload string) (string, error) {
+	if len(payload) > maxEventInput {
+		payload = strings.ToValidUTF8(payload[:maxEventInput], "")
+	}
+	resp, err := a.sendRequest(ctx, a.model, []Message{
+		{Role: "system", Content: eventPrompt},
+		{Role: "user", Content: fmt.Sprintf("Event from %s:\n\n%s", source, payload)},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	description := strings.TrimSpace(cleanResponse(resp.Message.Content))
+	if description == "" {
+		return "", fmt.Errorf("the model returned an empty notification")
+	}
+	return description, nil
+}
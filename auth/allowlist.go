@@ -0,0 +1,32 @@
+package auth
+
+// Allowlist limits who the bot answers at all, before roles decide what
+// they may do. Owners and trusted users always get through.
+type Allowlist struct {
+	users map[int64]bool
+	chats map[int64]bool
+}
+
+// NewAllowlist creates an allowlist of users, and of group chats whose
+// members are all let in. With neither, everyone is let in as a guest.
+func NewAllowlist(userIDs, chatIDs []int64) *Allowlist {
+	a := &Allowlist{users: make(map[int64]bool), chats: make(map[int64]bool)}
+	for _, id := range userIDs {
+		a.users[id] = true
+	}
+	for _, id := range chatIDs {
+		a.chats[id] = true
+	}
+	return a
+}
+
+// Open reports whether the allowlist lets everyone in.
+func (a *Allowlist) Open() bool {
+	return len(a.users) == 0 && len(a.chats) == 0
+}
+
+// Allows reports whether a user with the given role may talk to the bot in
+// the chat.
+func (a *Allowlist) Allows(userID, chatID int64, role Role) bool {
+	return a.Open() || role >= Trusted || a.users[userID] || a.chats[chatID]
+}
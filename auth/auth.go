@@ -0,0 +1,110 @@
+// Package auth maps Telegram users to roles and carries their identity through request contexts.
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// Role is a permission tier. Higher roles include everything lower roles can do.
+type Role int
+
+const (
+	Guest Role = iota
+	Trusted
+	Owner
+)
+
+func (r Role) String() string {
+	switch r {
+	case Owner:
+		return "owner"
+	case Trusted:
+		return "trusted"
+	default:
+		return "guest"
+	}
+}
+
+// User identifies who a request is being handled for.
+type User struct {
+	ID       int64
+	UserName string
+	Role     Role
+}
+
+type userKey struct{}
+
+// WithUser returns a context carrying the given user.
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the user stored in the context, if any.
+func UserFrom(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userKey{}).(User)
+	return user, ok
+}
+
+// RoleFrom returns the role of the user in the context.
+// Requests without a user are treated as guests.
+func RoleFrom(ctx context.Context) Role {
+	if user, ok := UserFrom(ctx); ok {
+		return user.Role
+	}
+	return Guest
+}
+
+// Roles maps Telegram user IDs to roles.
+type Roles struct {
+	mu      sync.RWMutex
+	owners  map[int64]bool
+	trusted map[int64]bool
+}
+
+// NewRoles creates a role mapping. Users not listed are guests.
+func NewRoles(ownerIDs, trustedIDs []int64) *Roles {
+	r := &Roles{
+		owners:  make(map[int64]bool),
+		trusted: make(map[int64]bool),
+	}
+	for _, id := range ownerIDs {
+		r.owners[id] = true
+	}
+	for _, id := range trustedIDs {
+		r.trusted[id] = true
+	}
+	return r
+}
+
+// RoleFor returns the role of the given Telegram user ID.
+func (r *Roles) RoleFor(userID int64) Role {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	switch {
+	case r.owners[userID]:
+		return Owner
+	case r.trusted[userID]:
+		return Trusted
+	default:
+		return Guest
+	}
+}
+
+// AddOwner makes the user an owner, as when they pair with a new bot.
+func (r *Roles) AddOwner(userID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.owners[userID] = true
+}
+
+// Owners returns the owner user IDs.
+func (r *Roles) Owners() []int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]int64, 0, len(r.owners))
+	for id := range r.owners {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,47 @@
+package auth
+
+import "slices"
+
+// Permissions lists the tools each role may use. Each role also inherits
+// the tools of the roles below it; a nil list grants every tool.
+type Permissions map[Role][]string
+
+// DefaultPermissions gives guests read-only lookups, trusted users tools
+// that run code or keep their own data, and owners everything.
+var DefaultPermissions = Permissions{
+	Guest:   {"get_current_time", "scrape", "places", "directions", "recipes", "dictionary", "math", "shopping_list"},
+	Trusted: {"python", "sandbox", "review", "repo", "files", "snippets", "reading_list", "tracking", "media", "chat_admin", "poll", "dns", "deps", "certs", "get_calendar_events"},
+	Owner:   nil,
+}
+
+// With returns a copy of the permissions that also lets role use tools.
+func (p Permissions) With(role Role, tools ...string) Permissions {
+	with := make(Permissions, len(p))
+	for r, names := range p {
+		with[r] = names
+	}
+	// A nil list already grants every tool
+	if names, ok := with[role]; len(tools) > 0 && (!ok || names != nil) {
+		with[role] = append(slices.Clip(names), tools...)
+	}
+	return with
+}
+
+// Allows reports whether the role may use the named tool.
+func (p Permissions) Allows(role Role, tool string) bool {
+	for r := role; r >= Guest; r-- {
+		names, ok := p[r]
+		if !ok {
+			continue
+		}
+		if names == nil {
+			return true
+		}
+		for _, name := range names {
+			if name == tool {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package auth
+
+import "testing"
+
+func TestPermissionsAllows(t *testing.T) {
+	perms := Permissions{
+		Guest:   {"time"},
+		Trusted: {"python"},
+		Owner:   nil,
+	}
+	guestBash := perms.With(Guest, "bash")
+
+	tests := []struct {
+		name  string
+		perms Permissions
+		role  Role
+		tool  string
+		want  bool
+	}{
+		{name: "guest's own tool", perms: perms, role: Guest, tool: "time", want: true},
+		{name: "guest, trusted tool", perms: perms, role: Guest, tool: "python", want: false},
+		{name: "trusted inherits guest's", perms: perms, role: Trusted, tool: "time", want: true},
+		{name: "trusted's own tool", perms: perms, role: Trusted, tool: "python", want: true},
+		{name: "trusted, owner tool", perms: perms, role: Trusted, tool: "bash", want: false},
+		{name: "owner gets everything", perms: perms, role: Owner, tool: "bash", want: true},
+		{name: "opened to guests", perms: guestBash, role: Guest, tool: "bash", want: true},
+		{name: "opened to guests, so trusted too", perms: guestBash, role: Trusted, tool: "bash", want: true},
+		{name: "role without a list", perms: Permissions{Guest: {"time"}}, role: Trusted, tool: "time", want: true},
+		{name: "no permissions", perms: Permissions{}, role: Owner, tool: "time", want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.perms.Allows(tt.role, tt.tool); got != tt.want {
+			t.Errorf("%s: Allows(%s, %q) = %v, want %v", tt.name, tt.role, tt.tool, got, tt.want)
+		}
+	}
+}
+
+// TestPermissionsWith checks With leaves the permissions it copies alone,
+// and keeps a nil list meaning every tool.
+func TestPermissionsWith(t *testing.T) {
+	base := Permissions{Guest: make([]string, 1, 4), Owner: nil}
+	base[Guest][0] = "time"
+
+	a := base.With(Guest, "bash")
+	b := base.With(Guest, "python")
+	if base.Allows(Guest, "bash") || base.Allows(Guest, "python") {
+		t.Error("With changed the permissions it copied")
+	}
+	if !a.Allows(Guest, "bash") || a.Allows(Guest, "python") {
+		t.Errorf("a = %v, want time and bash for guests", a[Guest])
+	}
+	if !b.Allows(Guest, "python") || b.Allows(Guest, "bash") {
+		t.Errorf("b = %v, want time and python for guests", b[Guest])
+	}
+	if owner := base.With(Owner, "bash"); owner[Owner] != nil {
+		t.Errorf("With(Owner) = %v, want nil, which allows everything", owner[Owner])
+	}
+}
@@ -0,0 +1,220 @@
+// Package balance spreads model requests across several Ollama instances,
+// sending each to the healthy instance with the fewest requests in flight
+// and routing around instances that go down.
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const checkTimeout = 5 * time.Second
+
+// Pool is a set of Ollama instances serving the same models. Instances are
+// identified by their chat endpoint URL (".../api/chat"); callers derive
+// other endpoints from it.
+type Pool struct {
+	transport *http.Transport // Shared, so connections to each instance are reused
+
+	mu        sync.Mutex
+	instances []*instance
+}
+
+type instance struct {
+	url      string
+	inFlight int
+	down     bool
+	lastErr  error
+}
+
+// Status describes one instance.
+type Status struct {
+	URL      string
+	InFlight int
+	Healthy  bool
+	Err      error // Why the instance is down; nil when healthy
+}
+
+// New creates a pool of the instances at the given chat endpoint URLs. All
+// instances start out healthy.
+func New(urls []string) *Pool {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 16
+
+	p := &Pool{transport: transport}
+	for _, u := range urls {
+		p.instances = append(p.instances, &instance{url: u})
+	}
+	return p
+}
+
+// Client returns an HTTP client that shares the pool's connections.
+func (p *Pool) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: p.transport, Timeout: timeout}
+}
+
+// URLs returns the chat endpoint URL of every instance.
+func (p *Pool) URLs() []string {
+	urls := make([]string, len(p.instances))
+	for i, inst := range p.instances {
+		urls[i] = inst.url
+	}
+	return urls
+}
+
+// Do calls fn with the URL of the least-loaded healthy instance. If fn
+// fails because the instance can't be reached, the instance is marked down
+// and fn is retried on the next one, until every instance has been tried.
+// When every instance is down, they are still tried, since one may have
+// come back since it was last checked.
+func (p *Pool) Do(ctx context.Context, fn func(url string) error) error {
+	tried := make(map[*instance]bool)
+	var err error
+	for {
+		inst := p.pick(tried)
+		if inst == nil {
+			return err
+		}
+		tried[inst] = true
+
+		err = fn(inst.url)
+		unreachable := err != nil && ctx.Err() == nil && isUnreachable(err)
+		p.finish(inst, err, unreachable)
+		if !unreachable {
+			return err
+		}
+	}
+}
+
+// Check asks every instance for its model list and updates its health.
+func (p *Pool) Check(ctx context.Context) {
+	client := p.Client(checkTimeout)
+
+	var wg sync.WaitGroup
+	for _, inst := range p.instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ping(ctx, client, inst.url)
+			if ctx.Err() != nil {
+				return
+			}
+			p.mu.Lock()
+			p.setHealth(inst, err)
+			p.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// Run checks the instances' health every interval until the context is
+// cancelled.
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	p.Check(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Check(ctx)
+		}
+	}
+}
+
+// Status returns the state of every instance, in configuration order.
+func (p *Pool) Status() []Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]Status, len(p.instances))
+	for i, inst := range p.instances {
+		statuses[i] = Status{URL: inst.url, InFlight: inst.inFlight, Healthy: !inst.down, Err: inst.lastErr}
+	}
+	return statuses
+}
+
+// pick returns the instance to try next, counting the request against it,
+// or nil if every instance has been tried.
+func (p *Pool) pick(tried map[*instance]bool) *instance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *instance
+	for _, inst := range p.instances {
+		if tried[inst] {
+			continue
+		}
+		if best == nil ||
+			(best.down && !inst.down) ||
+			(best.down == inst.down && inst.inFlight < best.inFlight) {
+			best = inst
+		}
+	}
+	if best != nil {
+		best.inFlight++
+	}
+	return best
+}
+
+func (p *Pool) finish(inst *instance, err error, unreachable bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	inst.inFlight--
+	if unreachable {
+		p.setHealth(inst, err)
+	} else if err == nil {
+		p.setHealth(inst, nil)
+	}
+}
+
+// setHealth records whether an instance is up, logging changes. The caller
+// must hold p.mu.
+func (p *Pool) setHealth(inst *instance, err error) {
+	inst.lastErr = err
+	switch {
+	case err != nil && !inst.down:
+		log.Printf("[balance] %s is down: %v", inst.url, err)
+		inst.down = true
+	case err == nil && inst.down:
+		log.Printf("[balance] %s is back up", inst.url)
+		inst.down = false
+	}
+}
+
+// ping checks that an instance answers its model list endpoint.
+func ping(ctx context.Context, client *http.Client, chatURL string) error {
+	tagsURL := strings.Replace(chatURL, "/api/chat", "/api/tags", 1)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tagsURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// isUnreachable reports whether err means the request never got an answer
+// from the instance, as opposed to the instance answering with an error.
+// Timeouts don't count: the instance may just be busy with a long reply,
+// and retrying elsewhere would double the wait.
+func isUnreachable(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !urlErr.Timeout()
+}
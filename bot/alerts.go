@@ -0,0 +1,307 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/auth"
+	"telegram-bot/events"
+	"telegram-bot/store"
+)
+
+const (
+	alertStoreKey     = "alert_groups"
+	alertButtonPrefix = "alert:"
+	alertGroupTTL     = 7 * 24 * time.Hour // Groups not heard of for this long are forgotten
+)
+
+// alertSilences are the silence buttons' durations.
+var alertSilences = []struct {
+	Label string
+	For   time.Duration
+}{
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+}
+
+// alertGroup is a group of Alertmanager alerts and the messages showing
+// it, which are edited as the group changes and as people act on it.
+type alertGroup struct {
+	ID       string           `json:"id"` // For buttons
+	Source   string           `json:"source"`
+	Key      string           `json:"key"` // Alertmanager's groupKey
+	Matchers []events.Matcher `json:"matchers"`
+	Messages map[int64]int    `json:"messages"` // Message ID by chat; 0 if held for quiet hours
+	Text     string           `json:"text"`
+	Firing   bool             `json:"firing"`
+	Updated  time.Time        `json:"updated"`
+
+	AckedBy       string    `json:"acked_by,omitempty"`
+	SilenceID     string    `json:"silence_id,omitempty"`
+	SilencedBy    string    `json:"silenced_by,omitempty"`
+	SilencedUntil time.Time `json:"silenced_until,omitempty"`
+}
+
+// view returns the group's message and buttons, with silence buttons if
+// its source can silence alerts.
+func (g *alertGroup) view(canSilence bool) (string, *tgbotapi.InlineKeyboardMarkup) {
+	var sb strings.Builder
+	sb.WriteString(g.Text)
+	if g.AckedBy != "" {
+		fmt.Fprintf(&sb, "\n\n👀 Acknowledged by %s", g.AckedBy)
+	}
+	silenced := g.SilenceID != "" && time.Now().Before(g.SilencedUntil)
+	if silenced {
+		fmt.Fprintf(&sb, "\n🔕 Silenced until %s by %s", g.SilencedUntil.Local().Format("Jan 2 15:04"), g.SilencedBy)
+	}
+	if !g.Firing {
+		return sb.String(), nil
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if g.AckedBy == "" {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("👀 Ack", alertButtonPrefix+g.ID+":ack"))
+	}
+	if canSilence {
+		if silenced {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("🔔 Unsilence", alertButtonPrefix+g.ID+":unsilence"))
+		} else {
+			for _, s := range alertSilences {
+				row = append(row, tgbotapi.NewInlineKeyboardButtonData("🔕 "+s.Label, alertButtonPrefix+g.ID+":silence"+s.Label))
+			}
+		}
+	}
+	if len(row) == 0 {
+		return sb.String(), nil
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return sb.String(), &keyboard
+}
+
+// alertGroups keeps the alert groups the bot has posted.
+type alertGroups struct {
+	store *store.Store
+
+	mu     sync.Mutex
+	groups []*alertGroup
+}
+
+func newAlertGroups(st *store.Store) *alertGroups {
+	a := &alertGroups{store: st}
+	if _, err := st.Get(alertStoreKey, &a.groups); err != nil {
+		log.Printf("Loading alert groups: %v", err)
+	}
+	return a
+}
+
+// save prunes groups not heard of in a week and stores the rest. The
+// caller must hold a.mu.
+func (a *alertGroups) save() {
+	cutoff := time.Now().Add(-alertGroupTTL)
+	kept := a.groups[:0]
+	for _, g := range a.groups {
+		if g.Updated.After(cutoff) {
+			kept = append(kept, g)
+		}
+	}
+	a.groups = kept
+	if err := a.store.Save(alertStoreKey, a.groups); err != nil {
+		log.Printf("Saving alert groups: %v", err)
+	}
+}
+
+// find returns the group with the key from the source that's still firing.
+// The caller must hold a.mu.
+func (a *alertGroups) find(source, key string) *alertGroup {
+	for _, g := range a.groups {
+		if g.Source == source && g.Key == key && g.Firing {
+			return g
+		}
+	}
+	return nil
+}
+
+// byID returns the group a button is for. The caller must hold a.mu.
+func (a *alertGroups) byID(id string) *alertGroup {
+	for _, g := range a.groups {
+		if g.ID == id {
+			return g
+		}
+	}
+	return nil
+}
+
+// postAlerts shows an Alertmanager notification. A group's first firing
+// notification is posted to the chats its severity is routed to; later
+// ones edit those messages in place, and resolving also replies to them so
+// the chats hear about it.
+func (b *Bot) postAlerts(name string, source *events.Source, body []byte) error {
+	update, err := source.AlertGroup(body)
+	if err != nil {
+		return err
+	}
+
+	b.alertGroups.mu.Lock()
+	defer b.alertGroups.mu.Unlock()
+
+	g := b.alertGroups.find(name, update.Key)
+	if g == nil {
+		if !update.Firing {
+			return nil // Resolved before the bot saw it fire, or after a restart lost it
+		}
+		id := make([]byte, 4)
+		rand.Read(id)
+		g = &alertGroup{ID: hex.EncodeToString(id), Source: name, Key: update.Key, Messages: make(map[int64]int)}
+		b.alertGroups.groups = append(b.alertGroups.groups, g)
+	}
+	g.Text, g.Firing, g.Matchers, g.Updated = update.Text, update.Firing, update.Matchers, time.Now()
+	text, keyboard := g.view(source.CanSilence())
+	text = b.redactor.Redact(text)
+
+	if len(g.Messages) == 0 {
+		urgent := source.Urgent || update.Severity == "critical"
+		log.Printf("[alerts] posting %s group %s (%s) to %d chat(s)", name, g.ID, update.Severity, len(source.ChatsFor(update.Severity)))
+		for _, chatID := range source.ChatsFor(update.Severity) {
+			if b.quiet.hold(chatID, text, urgent) {
+				log.Printf("[quiet] holding an alert for chat %d", chatID)
+				g.Messages[chatID] = 0
+				continue
+			}
+			msg := tgbotapi.NewMessage(chatID, text)
+			if keyboard != nil {
+				msg.ReplyMarkup = *keyboard
+			}
+			sent, err := b.transport.Send(msg)
+			if err != nil {
+				log.Printf("[alerts] posting to chat %d: %v", chatID, err)
+				continue
+			}
+			g.Messages[chatID] = sent.MessageID
+			b.alerts.add(chatID, text)
+		}
+	} else {
+		b.redrawAlerts(g, text, keyboard)
+		if !g.Firing {
+			log.Printf("[alerts] %s group %s resolved", name, g.ID)
+			first, _, _ := strings.Cut(text, "\n")
+			resolved := "✅ Resolved: " + strings.TrimPrefix(first, "✅ RESOLVED: ")
+			for chatID, messageID := range g.Messages {
+				if messageID == 0 {
+					b.notify(chatID, resolved, false) // Follows the held alert
+					continue
+				}
+				if b.quiet.hold(chatID, resolved, false) {
+					continue
+				}
+				msg := tgbotapi.NewMessage(chatID, resolved)
+				msg.ReplyToMessageID = messageID
+				b.out.Send(chatID, msg)
+			}
+		}
+	}
+	b.alertGroups.save()
+	return nil
+}
+
+// redrawAlerts edits every message showing the group. The caller must
+// hold b.alertGroups.mu.
+func (b *Bot) redrawAlerts(g *alertGroup, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	for chatID, messageID := range g.Messages {
+		if messageID == 0 {
+			continue
+		}
+		edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+		if keyboard != nil {
+			edit.ReplyMarkup = keyboard
+		}
+		b.out.Send(chatID, edit)
+	}
+}
+
+// alertButton acknowledges, silences, or unsilences an alert group.
+// Anyone in the chat can acknowledge; silencing is for trusted users.
+func (b *Bot) alertButton(ctx context.Context, req *Request, data string) {
+	answer := ""
+	defer func() {
+		b.out.Send(req.ChatID, tgbotapi.NewCallback(req.Button.ID, answer))
+	}()
+
+	id, action, _ := strings.Cut(data, ":")
+	b.alertGroups.mu.Lock()
+	defer b.alertGroups.mu.Unlock()
+	g := b.alertGroups.byID(id)
+	if g == nil || !g.Firing {
+		answer = "These alerts are resolved."
+		return
+	}
+	source := b.eventSources[g.Source]
+	who := "@" + req.UserName
+	if req.UserName == "" {
+		who = fmt.Sprintf("user %d", req.UserID)
+	}
+
+	switch {
+	case action == "ack":
+		if g.AckedBy == "" {
+			g.AckedBy = who
+		}
+		answer = "Acknowledged"
+		log.Printf("[alerts] %s acknowledged group %s", who, g.ID)
+	case action == "unsilence" || strings.HasPrefix(action, "silence"):
+		if auth.RoleFrom(ctx) < auth.Trusted {
+			answer = "⛔ Only trusted users can silence alerts."
+			return
+		}
+		if source == nil || !source.CanSilence() {
+			answer = "This button no longer works."
+			return
+		}
+		if action == "unsilence" {
+			if err := source.Unsilence(ctx, g.SilenceID); err != nil {
+				log.Printf("[alerts] unsilencing group %s: %v", g.ID, err)
+				answer = "⚠️ " + err.Error()
+				return
+			}
+			g.SilenceID, g.SilencedBy, g.SilencedUntil = "", "", time.Time{}
+			answer = "Unsilenced"
+			log.Printf("[alerts] %s unsilenced group %s", who, g.ID)
+			break
+		}
+		var d time.Duration
+		var label string
+		for _, s := range alertSilences {
+			if action == "silence"+s.Label {
+				d, label = s.For, s.Label
+			}
+		}
+		if d == 0 {
+			answer = "This button no longer works."
+			return
+		}
+		silenceID, err := source.Silence(ctx, g.Matchers, d, who)
+		if err != nil {
+			log.Printf("[alerts] silencing group %s: %v", g.ID, err)
+			answer = "⚠️ " + err.Error()
+			return
+		}
+		g.SilenceID, g.SilencedBy, g.SilencedUntil = silenceID, who, time.Now().Add(d)
+		answer = "Silenced for " + label
+		log.Printf("[alerts] %s silenced group %s for %v (silence %s)", who, g.ID, d, silenceID)
+	default:
+		answer = "This button no longer works."
+		return
+	}
+
+	g.Updated = time.Now()
+	text, keyboard := g.view(source != nil && source.CanSilence())
+	b.redrawAlerts(g, b.redactor.Redact(text), keyboard)
+	b.alertGroups.save()
+}
@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	backupStoreKey      = "backups"
+	backupCheckInterval = 10 * time.Minute
+)
+
+// backupState remembers the day of the last scheduled backup, so a
+// restart doesn't make another.
+type backupState struct {
+	LastRun string `json:"last_run"` // YYYY-MM-DD in local time
+}
+
+// scheduleBackups registers the daily backup, which tells the owners
+// whether it worked.
+func (b *Bot) scheduleBackups() {
+	if b.backup == nil || b.cfg.BackupTime == "" {
+		return
+	}
+	at, err := time.Parse("15:04", b.cfg.BackupTime)
+	if err != nil {
+		log.Printf("Scheduled backups disabled: BACKUP_TIME must be HH:MM, got %q", b.cfg.BackupTime)
+		return
+	}
+
+	b.scheduler.Every("backups", backupCheckInterval, func(ctx context.Context) error {
+		now := time.Now()
+		today := now.Format("2006-01-02")
+		due := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+		if now.Before(due) {
+			return nil
+		}
+
+		var state backupState
+		if _, err := b.store.Get(backupStoreKey, &state); err != nil {
+			return err
+		}
+		if state.LastRun == today {
+			return nil
+		}
+		state.LastRun = today
+		if err := b.store.Save(backupStoreKey, state); err != nil {
+			return err
+		}
+
+		result := b.backup.Run(ctx)
+		if ctx.Err() != nil {
+			return nil // Interrupted by shutdown; not worth a message
+		}
+		for _, chatID := range b.roles.Owners() {
+			b.notify(chatID, result.String(), false)
+		}
+		return nil
+	})
+	log.Printf("Backing up daily at %s", b.cfg.BackupTime)
+}
@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"telegram-bot/tools"
+)
+
+const benchTimeout = 90 * time.Second
+
+// runBench executes every benchmarkable tool with its canned payload and
+// reports latency and success, plus a health check of the chat model.
+func runBench(ctx context.Context, chatAgent Agent, registry *tools.Registry) string {
+	var sb strings.Builder
+	sb.WriteString("🏁 Benchmark results:\n")
+
+	start := time.Now()
+	err := chatAgent.Ping(ctx)
+	sb.WriteString(benchLine("model", time.Since(start), err))
+
+	all := registry.All()
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name() < all[j].Name()
+	})
+
+	for _, tool := range all {
+		bench, ok := tools.As[tools.Benchmarkable](tool)
+		if !ok {
+			sb.WriteString(fmt.Sprintf("⏭️ %s: no benchmark payload\n", tool.Name()))
+			continue
+		}
+
+		args, expect := bench.BenchArgs()
+		toolCtx, cancel := context.WithTimeout(ctx, benchTimeout)
+		start := time.Now()
+		output, err := tool.Execute(toolCtx, args)
+		elapsed := time.Since(start)
+		cancel()
+
+		if err == nil && expect != "" && !strings.Contains(output, expect) {
+			err = fmt.Errorf("unexpected output: %s", truncate(output, 100))
+		}
+		sb.WriteString(benchLine(tool.Name(), elapsed, err))
+	}
+
+	return sb.String()
+}
+
+func benchLine(name string, elapsed time.Duration, err error) string {
+	elapsed = elapsed.Round(time.Millisecond)
+	if err != nil {
+		return fmt.Sprintf("❌ %s (%v): %s\n", name, elapsed, truncate(err.Error(), 150))
+	}
+	return fmt.Sprintf("✅ %s (%v)\n", name, elapsed)
+}
+
+func truncate(s string, maxLen int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen] + "..."
+}
@@ -0,0 +1,518 @@
+// Package bot runs the agent behind Telegram (or another Transport), handling
+// access control, quotas, delivery, and shutdown around it.
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/agent"
+	"telegram-bot/auth"
+	"telegram-bot/balance"
+	"telegram-bot/config"
+	"telegram-bot/egress"
+	"telegram-bot/embed"
+	"telegram-bot/events"
+	"telegram-bot/notify"
+	"telegram-bot/outbox"
+	"telegram-bot/priority"
+	"telegram-bot/quota"
+	"telegram-bot/redact"
+	"telegram-bot/runs"
+	"telegram-bot/schedule"
+	"telegram-bot/snapshot"
+	"telegram-bot/store"
+	"telegram-bot/tenant"
+	"telegram-bot/tools"
+	"telegram-bot/transcript"
+)
+
+// Agent answers plain (non-command) messages. *agent.Agent implements it.
+type Agent interface {
+	Respond(ctx context.Context, message string) (*agent.Response, error)
+
+	// Ping checks that the model backend is reachable.
+	Ping(ctx context.Context) error
+}
+
+// Bot wires a transport, agent, and tool registry together.
+type Bot struct {
+	cfg          *config.Config
+	agent        Agent
+	registry     *tools.Registry
+	ollama       *balance.Pool
+	egress       *egress.Proxy // nil when commands' traffic isn't limited
+	calendar     *tools.CalendarTool
+	shopping     *tools.ShoppingListTool
+	spotify      *tools.SpotifyTool
+	polls        *tools.PollTool
+	github       *tools.GitHubTool
+	backup       *tools.BackupTool
+	eventSources map[string]*events.Source
+	transport    Transport
+	messenger    Messenger
+	webhook      *Webhook
+	cliMode      bool
+	botName      string // The bot's Telegram username, for links to it
+
+	store         *store.Store
+	roles         *auth.Roles
+	allowlist     *auth.Allowlist
+	pairing       *pairing        // nil in CLI mode
+	tenants       *tenant.Manager // nil unless users are isolated from each other
+	tenantConvs   tenantConversations
+	quota         *quota.Tracker
+	redactor      *redact.Redactor
+	runs          *runs.Tracker
+	queue         *priority.Queue
+	conversations *conversations
+	pinned        *pinnedDocs
+	clips         *clipboard
+	coding        *codingSessions
+	fixes         *issueFixes
+	fixApprovals  fixApprovals
+	transcripts   *transcript.Index // nil if the index couldn't be opened
+	embedder      *embed.Client     // nil searches transcripts by words only
+	snapshots     *snapshot.Repo    // nil when workspace snapshots are off
+	breakers      *tools.Breakers
+	undo          *workspaceUndo
+	out           *outbox.Queue
+	notifier      *notify.Notifier
+	scheduler     *schedule.Scheduler
+	alerts        *alertLog
+	alertGroups   *alertGroups
+	locations     *locations
+	forms         *forms
+	quiet         *quietHours
+}
+
+// Option customizes a Bot.
+type Option func(*Bot)
+
+// WithTransport replaces the default Telegram transport.
+func WithTransport(transport Transport) Option {
+	return func(b *Bot) {
+		b.transport = transport
+	}
+}
+
+// WithMessenger uses the given Telegram client instead of connecting with
+// the configured token.
+func WithMessenger(messenger Messenger) Option {
+	return func(b *Bot) {
+		b.messenger = messenger
+	}
+}
+
+// WithEventSources accepts events from other services, such as GitHub and
+// Alertmanager, and posts them to the sources' chats.
+func WithEventSources(sources map[string]*events.Source) Option {
+	return func(b *Bot) {
+		b.eventSources = sources
+	}
+}
+
+// WithWebhook has Telegram post updates to the webhook instead of the bot
+// polling for them.
+func WithWebhook(webhook Webhook) Option {
+	return func(b *Bot) {
+		b.webhook = &webhook
+	}
+}
+
+// WithCLI reads messages from in and writes replies to out instead of using
+// Telegram. The CLI user is an owner so every tool can be exercised locally.
+func WithCLI(in io.Reader, out io.Writer) Option {
+	return func(b *Bot) {
+		b.transport = &cliTransport{in: in, out: out}
+		b.cliMode = true
+	}
+}
+
+// New creates a bot, registering the tools the configuration enables. A
+// nil agent chats with the configured model. Permission and quota
+// middleware are added to the registry, so register custom tools before
+// or after calling New; ones registered after replace standard tools of
+// the same name, but aren't checked for the commands they need.
+func New(cfg *config.Config, registry *tools.Registry, agent Agent, opts ...Option) (*Bot, error) {
+	b := &Bot{
+		cfg:       cfg,
+		agent:     agent,
+		registry:  registry,
+		ollama:    balance.New(cfg.OllamaURLs),
+		runs:      runs.NewTracker(),
+		queue:     priority.New(cfg.MaxConcurrentRuns),
+		alerts:    newAlertLog(),
+		locations: newLocations(),
+		forms:     newForms(),
+		scheduler: schedule.New(),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if len(cfg.OllamaURLs) > 1 {
+		log.Printf("Balancing across %d Ollama instances", len(cfg.OllamaURLs))
+	}
+
+	proxy, err := setUpIsolation(cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.egress = proxy
+
+	// Create the agent before the tools, so tools that summarize can use
+	// its model; it sees the tools registered below when it runs
+	llm, ok := agent.(tools.Completer)
+	if !ok {
+		chatAgent, err := newAgent(cfg, b.ollama, registry)
+		if err != nil {
+			return nil, err
+		}
+		if agent == nil {
+			b.agent = chatAgent
+		}
+		llm = chatAgent
+	}
+	if err := b.registerTools(llm); err != nil {
+		return nil, err
+	}
+
+	// Have Telegram post updates, e.g. through a reverse proxy, instead of polling
+	if b.webhook == nil && !b.cliMode && cfg.WebhookURL != "" {
+		b.webhook = &Webhook{
+			URL:      cfg.WebhookURL,
+			Listen:   cfg.WebhookListen,
+			CertFile: cfg.WebhookCert,
+			KeyFile:  cfg.WebhookKey,
+		}
+	}
+
+	// Accept events from other services, such as GitHub and Alertmanager
+	if b.eventSources == nil && cfg.EventSourcesFile != "" {
+		sources, err := events.LoadSources(cfg.EventSourcesFile)
+		if err != nil {
+			return nil, fmt.Errorf("EVENT_SOURCES_FILE: %w", err)
+		}
+		b.eventSources = sources
+	}
+
+	// Set up persistent state
+	st, err := store.Open(cfg.StateDir)
+	if err != nil {
+		return nil, err
+	}
+	b.store = st
+	if cfg.Tenants {
+		log.Printf("Transcript search is off with tenants; its index isn't encrypted")
+	} else if index, err := transcript.Open(filepath.Join(cfg.StateDir, "transcripts.db"), b.embedder); err != nil {
+		log.Printf("Transcript search disabled: %v", err)
+	} else {
+		b.transcripts = index
+	}
+	b.conversations = newConversations(st, b.transcripts, cfg.HistoryTokens)
+	b.pinned = newPinnedDocs(st)
+	b.clips = newClipboard(st, cfg.ClipTTL)
+	b.alertGroups = newAlertGroups(st)
+	b.coding = newCodingSessions(st)
+	b.fixes = newIssueFixes(st)
+	b.traceRuns()
+	b.gateFixCommands()
+	b.quiet = newQuietHours(st)
+
+	// Give each user their own workspace, encrypted state, and audit trail
+	if cfg.Tenants {
+		tenants, err := tenant.NewManager(cfg.StateDir, cfg.TenantKey)
+		if err != nil {
+			return nil, fmt.Errorf("setting up tenants: %w", err)
+		}
+		b.tenants = tenants
+		registry.Use(tools.Audit())
+		if cfg.WorkspaceUndo {
+			log.Printf("Workspace snapshots are off with tenants; undo would cross workspaces")
+		}
+	}
+
+	// Snapshot the workspace after tool runs and around agent runs, for
+	// /history and /undo
+	if cfg.WorkspaceUndo && cfg.PythonWorkspace != "" && !cfg.Tenants {
+		repo, err := snapshot.Open(context.Background(), filepath.Join(cfg.StateDir, "workspace.git"), cfg.PythonWorkspace)
+		if err != nil {
+			log.Printf("Workspace snapshots disabled: %v", err)
+		} else {
+			b.snapshots = repo
+			b.undo = newWorkspaceUndo(repo, st)
+		}
+	}
+
+	// Restrict tools by the requesting user's role. Without configured
+	// owners, the bot stays locked until someone pairs with it
+	ownerIDs := slices.Clone(cfg.OwnerIDs)
+	if b.cliMode {
+		ownerIDs = append(ownerIDs, cliUserID)
+	} else if cfg.Pairing {
+		b.pairing = newPairing(st, len(ownerIDs) > 0)
+		ownerIDs = append(ownerIDs, b.pairing.pairedOwners()...)
+	}
+	b.roles = auth.NewRoles(ownerIDs, cfg.TrustedIDs)
+	b.allowlist = auth.NewAllowlist(cfg.AllowedUserIDs, cfg.AllowedChatIDs)
+	registry.Use(tools.Permissions(auth.DefaultPermissions.With(auth.Guest, cfg.GuestTools...)))
+	if len(cfg.GuestTools) > 0 {
+		log.Printf("Guests may also use %s", strings.Join(cfg.GuestTools, ", "))
+	}
+	if len(ownerIDs) == 0 && b.pairing == nil {
+		log.Printf("No OWNER_USER_IDS configured; all users are guests")
+	}
+	if !b.allowlist.Open() {
+		log.Printf("Answering only owners, trusted users, %d allowed user(s), and members of %d allowed chat(s)",
+			len(cfg.AllowedUserIDs), len(cfg.AllowedChatIDs))
+	}
+
+	// Enforce daily per-user quotas
+	b.quota = quota.NewTracker(quota.Limits{
+		MaxRequests:    cfg.QuotaMaxRequests,
+		MaxToolSeconds: cfg.QuotaMaxToolSeconds,
+		MaxScrapeBytes: cfg.QuotaMaxScrapeBytes,
+	}, st)
+	registry.Use(tools.Quota(b.quota))
+
+	if b.snapshots != nil {
+		registry.Use(tools.Snapshots(b.snapshots))
+	}
+
+	// Answer repeated slow calls, like inspecting the same image, from a
+	// cache; inside the access checks, so hits are still checked
+	if len(cfg.ToolCache) > 0 {
+		ttls, err := tools.ParseCacheTTLs(cfg.ToolCache)
+		if err != nil {
+			return nil, fmt.Errorf("TOOL_CACHE: %w", err)
+		}
+		registry.Use(tools.Cache(tools.NewResultCache(ttls)))
+	}
+
+	// Take tools whose dependencies keep failing away from the model until
+	// they recover; innermost, so refused calls don't count
+	b.breakers = tools.NewBreakers()
+	registry.Use(tools.CircuitBreaker(b.breakers))
+
+	// Mask secrets in tool output before it reaches Telegram's servers
+	b.redactor = redact.New(cfg.TelegramToken, cfg.GoogleSecret, cfg.SpotifySecret, cfg.GoogleMapsKey, cfg.FlightAPIKey, cfg.ParcelAPIKey, cfg.TMDBAPIKey, cfg.MQTTPassword,
+		cfg.DebugToken, cfg.GitHubToken, cfg.CloudflareToken, cfg.LLMAPIKey, cfg.TenantKey, cfg.OCISignKey)
+
+	if b.transport == nil {
+		if b.messenger == nil {
+			if cfg.TelegramToken == "" {
+				return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN environment variable is required")
+			}
+			api, err := tgbotapi.NewBotAPI(cfg.TelegramToken)
+			if err != nil {
+				return nil, fmt.Errorf("connecting to Telegram: %w", err)
+			}
+			log.Printf("Authorized on account %s", api.Self.UserName)
+			b.messenger = api
+			b.botName = api.Self.UserName
+		}
+
+		b.transport = &telegramTransport{
+			bot:     b.messenger,
+			token:   cfg.TelegramToken,
+			handled: newUpdateTracker(st),
+			webhook: b.webhook,
+		}
+	}
+
+	// Replies go through a queue that retries and respects flood limits
+	b.out = outbox.New(b.transport)
+
+	// Report failures that would otherwise only be logged to the owners
+	b.notifier = notify.New(b.out, b.roles.Owners(), b.redactor)
+	b.out.OnFailure(b.notifier.DeliveryFailed)
+	b.breakers.OnChange(func(tool string, open bool, err error) {
+		if open {
+			b.notifier.Notify(fmt.Sprintf("🔌 %s keeps failing and is offline until it recovers:\n%v", tool, err))
+		} else {
+			b.notifier.Notify(fmt.Sprintf("🔌 %s is working again.", tool))
+		}
+	})
+
+	// Remember which message answered which request, for branching replies
+	b.out.OnSent(func(chatID int64, msg tgbotapi.Chattable, sent tgbotapi.Message) {
+		if m, ok := msg.(tgbotapi.MessageConfig); ok && m.ReplyToMessageID != 0 && sent.MessageID != 0 {
+			b.replied(chatID, m.ReplyToMessageID, sent.MessageID)
+		}
+	})
+
+	return b, nil
+}
+
+// Run handles messages until the context is cancelled, then drains
+// in-flight requests, delivers queued replies, and flushes state.
+func (b *Bot) Run(ctx context.Context) error {
+	// Requests run on their own context so cancelling ctx stops intake
+	// without killing work that is still in progress.
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	defer func() {
+		if err := b.store.Flush(); err != nil {
+			log.Printf("Error flushing state: %v", err)
+		}
+	}()
+
+	go b.ollama.Run(ctx, b.cfg.OllamaHealthEvery)
+	if b.egress != nil {
+		go func() {
+			if err := b.egress.Run(ctx); err != nil {
+				log.Printf("Egress proxy error: %v", err)
+			}
+		}()
+	}
+
+	go b.store.Run(ctx, 30*time.Second, func(err error) {
+		b.notifier.JobFailed("state flush", err)
+	})
+	if b.tenants != nil {
+		defer func() {
+			if err := b.tenants.Flush(); err != nil {
+				log.Printf("Error flushing tenant state: %v", err)
+			}
+		}()
+		go b.tenants.Run(ctx, 30*time.Second, func(err error) {
+			b.notifier.JobFailed("tenant state flush", err)
+		})
+	}
+
+	if b.cfg.DebugAddr != "" {
+		startPprof(b.cfg.DebugAddr, b.cfg.DebugToken)
+	}
+	b.startClipServer(ctx)
+	b.startEventServer(ctx)
+
+	log.Printf("Registered tools: %d", len(b.registry.All()))
+	if b.pairing != nil && b.pairing.locked() {
+		log.Printf("No owner yet; the bot turns everyone away until you open this one-time link in Telegram to become its owner:\n\n    %s\n", b.pairing.link(b.botName))
+	}
+
+	// Start tools that poll or notify in the background, then their jobs
+	b.startBackground()
+	b.scheduleBriefing()
+	b.scheduleCertChecks()
+	b.scheduleBackups()
+	b.scheduler.Every("clip expiry", clipCleanupInterval, b.clips.expire)
+	b.scheduleQuietHours()
+	b.scheduleWatchdog()
+	b.scheduler.Every("tool probes", time.Minute, b.breakers.Probe)
+	go b.scheduler.Run(ctx, b.notifier.JobFailed)
+
+	var inFlight sync.WaitGroup
+	handle := func(req *Request) {
+		if b.cliMode {
+			// Print the reply before prompting for the next line
+			b.handle(workCtx, req)
+			b.out.Flush(workCtx)
+			return
+		}
+
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			b.handle(workCtx, req)
+		}()
+	}
+
+	err := b.transport.Run(ctx, handle)
+
+	drain(&inFlight, cancelWork, b.cfg.ShutdownTimeout)
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+	b.out.Flush(flushCtx)
+	cancelFlush()
+
+	return err
+}
+
+// poller is implemented by transports that can post native polls.
+type poller interface {
+	SendPoll(chatID int64, poll tools.NewPoll) (int, string, error)
+	StopPoll(chatID int64, messageID int) (tools.PollCounts, error)
+}
+
+// redactingAdmin masks secrets in messages the chat admin tool posts.
+type redactingAdmin struct {
+	tools.ChatAdmin
+	redactor *redact.Redactor
+}
+
+func (r redactingAdmin) Post(chatID int64, text string) (int, error) {
+	return r.ChatAdmin.Post(chatID, r.redactor.Redact(text))
+}
+
+// startBackground gives tools with background work access to the store,
+// scheduler, and outbox.
+func (b *Bot) startBackground() {
+	host := tools.Host{
+		Store:     b.store,
+		Scheduler: b.scheduler,
+		Tenants:   b.tenants,
+		Send: func(chatID int64, text string) {
+			b.notify(chatID, text, false)
+		},
+		SendUrgent: func(chatID int64, text string) {
+			b.notify(chatID, text, true)
+		},
+	}
+	if members, ok := b.transport.(memberChecker); ok {
+		host.IsMember = members.IsMember
+	}
+	if p, ok := b.transport.(poller); ok {
+		host.SendPoll = p.SendPoll
+		host.StopPoll = p.StopPoll
+	}
+	if admin, ok := b.transport.(tools.ChatAdmin); ok {
+		host.ChatAdmin = redactingAdmin{admin, b.redactor}
+	}
+	for _, tool := range b.registry.All() {
+		bg, ok := tools.As[tools.Background](tool)
+		if !ok {
+			continue
+		}
+		if err := bg.Start(host); err != nil {
+			log.Printf("Starting %s background work: %v", tool.Name(), err)
+		}
+	}
+}
+
+// drain waits for in-flight requests to finish. If they are still running
+// after the timeout, their context is cancelled, which kills any tool
+// subprocesses, and they get a short grace period to send their replies.
+func drain(inFlight *sync.WaitGroup, cancelWork context.CancelFunc, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	log.Printf("Waiting up to %v for in-flight requests...", timeout)
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	log.Println("Drain timed out, cancelling in-flight requests")
+	cancelWork()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		log.Println("Some requests did not stop in time")
+	}
+}
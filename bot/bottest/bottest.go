@@ -0,0 +1,121 @@
+// Package bottest provides test doubles for the bot package.
+package bottest
+
+import (
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// FakeMessenger is a bot.Messenger that delivers updates pushed to Updates
+// and records every message sent.
+type FakeMessenger struct {
+	Updates chan tgbotapi.Update
+
+	// SendErr, if set, decides the error returned for each sent message.
+	SendErr func(c tgbotapi.Chattable) error
+
+	mu       sync.Mutex
+	sent     []tgbotapi.Chattable
+	requests []Request
+	stopped  bool
+}
+
+// NewFakeMessenger creates a messenger with a buffered updates channel.
+func NewFakeMessenger() *FakeMessenger {
+	return &FakeMessenger{Updates: make(chan tgbotapi.Update, 100)}
+}
+
+func (f *FakeMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if f.SendErr != nil {
+		if err := f.SendErr(c); err != nil {
+			return tgbotapi.Message{}, err
+		}
+	}
+
+	f.mu.Lock()
+	f.sent = append(f.sent, c)
+	f.mu.Unlock()
+	return tgbotapi.Message{}, nil
+}
+
+// Request records the call like a sent message and reports success.
+func (f *FakeMessenger) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	if _, err := f.Send(c); err != nil {
+		return nil, err
+	}
+	return &tgbotapi.APIResponse{Ok: true, Result: []byte("true")}, nil
+}
+
+// MakeRequest records the call's parameters and reports success.
+func (f *FakeMessenger) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, Request{Endpoint: endpoint, Params: params})
+	f.mu.Unlock()
+	return &tgbotapi.APIResponse{Ok: true, Result: []byte("true")}, nil
+}
+
+// Request is a raw API call made with MakeRequest.
+type Request struct {
+	Endpoint string
+	Params   tgbotapi.Params
+}
+
+// Requests returns the raw API calls made so far.
+func (f *FakeMessenger) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
+
+func (f *FakeMessenger) GetUpdatesChan(_ tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	return f.Updates
+}
+
+func (f *FakeMessenger) StopReceivingUpdates() {
+	f.mu.Lock()
+	f.stopped = true
+	f.mu.Unlock()
+}
+
+// Sent returns the messages sent so far.
+func (f *FakeMessenger) Sent() []tgbotapi.Chattable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]tgbotapi.Chattable(nil), f.sent...)
+}
+
+// SentTexts returns the text of every plain message sent so far.
+func (f *FakeMessenger) SentTexts() []string {
+	var texts []string
+	for _, c := range f.Sent() {
+		if m, ok := c.(tgbotapi.MessageConfig); ok {
+			texts = append(texts, m.Text)
+		}
+	}
+	return texts
+}
+
+// Stopped reports whether StopReceivingUpdates was called.
+func (f *FakeMessenger) Stopped() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stopped
+}
+
+// TextUpdate builds an update carrying a private text message, marking a
+// leading /command the way Telegram does.
+func TextUpdate(updateID int, userID int64, text string) tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		MessageID: updateID,
+		From:      &tgbotapi.User{ID: userID, UserName: "test"},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	return tgbotapi.Update{UpdateID: updateID, Message: msg}
+}
@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+	"telegram-bot/priority"
+	"telegram-bot/tools"
+)
+
+const (
+	briefingStoreKey      = "briefing"
+	briefingCheckInterval = time.Minute
+	briefingTimeout       = 5 * time.Minute
+	weatherTimeout        = 10 * time.Second
+	alertWindow           = 24 * time.Hour // Background messages the briefing recaps
+	maxAlertsPerChat      = 50
+	maxAlertChars         = 500
+)
+
+// weatherURL is wttr.in's one-line forecast for a location, filled in with
+// fmt. See https://wttr.in/:help for the format codes.
+const weatherURL = "https://wttr.in/%s?format=%%l:+%%C+%%t+(feels+like+%%f),+wind+%%w,+%%p+precipitation"
+
+// briefingState remembers the day the last briefing went out, so a restart
+// doesn't send a second one.
+type briefingState struct {
+	LastSent string `json:"last_sent"` // YYYY-MM-DD in local time
+}
+
+// alert is a message that background work sent to a chat, such as a
+// watched image getting a new tag.
+type alert struct {
+	Text string
+	Time time.Time
+}
+
+// alertLog keeps the last day of each chat's background messages, so the
+// morning briefing can recap what happened overnight.
+type alertLog struct {
+	mu    sync.Mutex
+	chats map[int64][]alert
+}
+
+func newAlertLog() *alertLog {
+	return &alertLog{chats: make(map[int64][]alert)}
+}
+
+func (l *alertLog) add(chatID int64, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	alerts := append(l.recent(chatID), alert{Text: truncate(text, maxAlertChars), Time: time.Now()})
+	if len(alerts) > maxAlertsPerChat {
+		alerts = alerts[len(alerts)-maxAlertsPerChat:]
+	}
+	l.chats[chatID] = alerts
+}
+
+// since returns the chat's alerts from the last day, oldest first.
+func (l *alertLog) since(chatID int64) []alert {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]alert(nil), l.recent(chatID)...)
+}
+
+// recent drops alerts older than the window. The caller must hold l.mu.
+func (l *alertLog) recent(chatID int64) []alert {
+	alerts := l.chats[chatID]
+	for len(alerts) > 0 && time.Since(alerts[0].Time) > alertWindow {
+		alerts = alerts[1:]
+	}
+	l.chats[chatID] = alerts
+	return alerts
+}
+
+// scheduleBriefing registers the daily briefing job, if a time is
+// configured and there is an owner to send it to.
+func (b *Bot) scheduleBriefing() {
+	if b.cfg.BriefingTime == "" {
+		return
+	}
+	at, err := time.Parse("15:04", b.cfg.BriefingTime)
+	if err != nil {
+		log.Printf("Daily briefing disabled: BRIEFING_TIME must be HH:MM, got %q", b.cfg.BriefingTime)
+		return
+	}
+	chatID, ok := b.briefingChat()
+	if !ok {
+		log.Printf("Daily briefing disabled: no owner to send it to")
+		return
+	}
+
+	b.scheduler.Every("daily briefing", briefingCheckInterval, func(ctx context.Context) error {
+		now := time.Now()
+		today := now.Format("2006-01-02")
+		due := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+		if now.Before(due) {
+			return nil
+		}
+
+		var state briefingState
+		if _, err := b.store.Get(briefingStoreKey, &state); err != nil {
+			return err
+		}
+		if state.LastSent == today {
+			return nil
+		}
+		// Record the day first, so a failing briefing isn't retried every minute
+		state.LastSent = today
+		if err := b.store.Save(briefingStoreKey, state); err != nil {
+			return err
+		}
+
+		text, err := b.briefing(ctx, chatID)
+		if err != nil {
+			return fmt.Errorf("composing briefing: %w", err)
+		}
+		b.notify(chatID, text, false)
+		return nil
+	})
+	log.Printf("Daily briefing at %s to chat %d", b.cfg.BriefingTime, chatID)
+}
+
+// briefingChat returns the chat the briefing goes to: the configured one,
+// or else the first owner's private chat.
+func (b *Bot) briefingChat() (int64, bool) {
+	if b.cfg.BriefingChatID != 0 {
+		return b.cfg.BriefingChatID, true
+	}
+	return b.firstOwner()
+}
+
+// firstOwner returns the first configured or paired owner, or the CLI
+// user, who is always an owner.
+func (b *Bot) firstOwner() (int64, bool) {
+	if len(b.cfg.OwnerIDs) > 0 {
+		return b.cfg.OwnerIDs[0], true
+	}
+	if b.pairing != nil {
+		if paired := b.pairing.pairedOwners(); len(paired) > 0 {
+			return paired[0], true
+		}
+	}
+	return cliUserID, b.cliMode
+}
+
+// briefing has the agent compose the morning briefing for a chat, acting
+// for the first owner.
+func (b *Bot) briefing(ctx context.Context, chatID int64) (string, error) {
+	owner, ok := b.firstOwner()
+	if !ok {
+		return "", fmt.Errorf("no owner configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, briefingTimeout)
+	defer cancel()
+
+	ctx = auth.WithUser(ctx, auth.User{ID: owner, UserName: "briefing", Role: auth.Owner})
+	ctx, err := b.withTenant(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+	ctx = tools.WithChat(ctx, chatID)
+
+	prompt := b.briefingPrompt(ctx, chatID)
+	ctx = tools.WithRequest(ctx, "daily briefing")
+
+	release, err := b.queue.Acquire(ctx, owner, priority.Heavy)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	done := b.runs.Start(chatID, "briefing", "daily briefing")
+	defer done()
+
+	response, err := b.agent.Respond(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return response.Text, nil
+}
+
+// briefingPrompt asks for the sections the bot can fill in: tool lookups
+// for the tools that are registered, plus the weather and overnight alerts
+// gathered up front.
+func (b *Bot) briefingPrompt(ctx context.Context, chatID int64) string {
+	var sections []string
+	if _, ok := b.registry.Get("get_calendar_events"); ok {
+		sections = append(sections, "Today's calendar: call get_calendar_events for today's events.")
+	}
+	if b.cfg.BriefingLocation != "" {
+		if weather, err := fetchWeather(ctx, b.cfg.BriefingLocation); err != nil {
+			log.Printf("Briefing weather: %v", err)
+		} else {
+			sections = append(sections, "Weather: "+weather)
+		}
+	}
+	if _, ok := b.registry.Get("reminders"); ok {
+		sections = append(sections, "Reminders due today: call the reminders tool.")
+	}
+	if _, ok := b.registry.Get("reading_list"); ok {
+		sections = append(sections, `Reading list: call reading_list with operation="list"; say how many articles are unread and name up to three.`)
+	}
+	if alerts := b.alerts.since(chatID); len(alerts) > 0 {
+		var sb strings.Builder
+		sb.WriteString("Changes since yesterday (watched images, pages, and other alerts), to recap in a few bullets:")
+		for _, a := range alerts {
+			fmt.Fprintf(&sb, "\n  [%s] %s", a.Time.Format("Jan 2 15:04"), strings.ReplaceAll(a.Text, "\n", " "))
+		}
+		sections = append(sections, sb.String())
+	}
+
+	var p strings.Builder
+	fmt.Fprintf(&p, "Write my morning briefing for %s. Keep it short and friendly, with a one-line heading per section, and leave out sections with nothing in them.\n\nSections:\n", time.Now().Format("Monday, January 2"))
+	for _, s := range sections {
+		p.WriteString("- " + s + "\n")
+	}
+	return p.String()
+}
+
+// fetchWeather returns a one-line forecast for the location.
+func fetchWeather(ctx context.Context, location string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, weatherTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(weatherURL, url.PathEscape(location)), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "curl") // wttr.in serves plain text to command-line clients
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("wttr.in returned status %d", resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"telegram-bot/tools"
+)
+
+const (
+	certStoreKey      = "cert_checks"
+	certCheckInterval = 10 * time.Minute
+)
+
+// certState remembers the day certificates were last checked, so a
+// restart doesn't check and alert twice.
+type certState struct {
+	LastChecked string `json:"last_checked"` // YYYY-MM-DD in local time
+}
+
+// scheduleCertChecks registers the daily check of the monitored domains'
+// certificates, which alerts the owners about any expiring soon.
+func (b *Bot) scheduleCertChecks() {
+	if len(b.cfg.CertDomains) == 0 {
+		return
+	}
+	at, err := time.Parse("15:04", b.cfg.CertCheckTime)
+	if err != nil {
+		log.Printf("Certificate checks disabled: CERT_CHECK_TIME must be HH:MM, got %q", b.cfg.CertCheckTime)
+		return
+	}
+
+	b.scheduler.Every("certificate checks", certCheckInterval, func(ctx context.Context) error {
+		now := time.Now()
+		today := now.Format("2006-01-02")
+		due := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+		if now.Before(due) {
+			return nil
+		}
+
+		var state certState
+		if _, err := b.store.Get(certStoreKey, &state); err != nil {
+			return err
+		}
+		if state.LastChecked == today {
+			return nil
+		}
+		state.LastChecked = today
+		if err := b.store.Save(certStoreKey, state); err != nil {
+			return err
+		}
+
+		text := tools.ExpiringCertificates(ctx, b.cfg.CertDomains, b.cfg.CertWarnDays)
+		if text == "" {
+			log.Printf("[certs] %d certificate(s) fine", len(b.cfg.CertDomains))
+			return nil
+		}
+		for _, chatID := range b.roles.Owners() {
+			b.notify(chatID, text, false)
+		}
+		return nil
+	})
+	log.Printf("Checking %d certificate(s) daily at %s", len(b.cfg.CertDomains), b.cfg.CertCheckTime)
+}
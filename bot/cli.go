@@ -0,0 +1,112 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The CLI user is always an owner so every tool can be exercised locally.
+const (
+	cliUserID int64 = 0
+	cliChatID int64 = 0
+)
+
+// cliTransport reads messages from stdin and prints replies to stdout,
+// running them through the same handler, agent, and tools as Telegram.
+type cliTransport struct {
+	in  io.Reader
+	out io.Writer
+}
+
+func (c *cliTransport) Send(msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	switch m := msg.(type) {
+	case tgbotapi.MessageConfig:
+		if m.ParseMode == tgbotapi.ModeHTML {
+			m.Text = plainCode(m.Text)
+		}
+		fmt.Fprintf(c.out, "\n%s\n\n", m.Text)
+	case tgbotapi.EditMessageTextConfig:
+		fmt.Fprintf(c.out, "\n(edited) %s\n\n", m.Text)
+	case tgbotapi.CallbackConfig:
+		// Buttons can't be pressed in the terminal, so there is nothing to answer
+	case tgbotapi.DocumentConfig:
+		fmt.Fprintf(c.out, "\n📎 %s\n\n", c.saveFile(m.File))
+	case tgbotapi.PhotoConfig:
+		fmt.Fprintf(c.out, "\n🖼 %s\n\n", c.saveFile(m.File))
+	default:
+		fmt.Fprintf(c.out, "\n(unsupported message type %T)\n\n", msg)
+	}
+	return tgbotapi.Message{}, nil
+}
+
+// saveFile writes an attachment to a temp directory and describes where it went.
+func (c *cliTransport) saveFile(file tgbotapi.RequestFileData) string {
+	fb, ok := file.(tgbotapi.FileBytes)
+	if !ok {
+		return "(attachment)"
+	}
+	path := filepath.Join(os.TempDir(), fb.Name)
+	if err := os.WriteFile(path, fb.Bytes, 0644); err != nil {
+		return fmt.Sprintf("%s (could not save: %v)", fb.Name, err)
+	}
+	return fmt.Sprintf("%s saved to %s", fb.Name, path)
+}
+
+func (c *cliTransport) Run(ctx context.Context, handle func(*Request)) error {
+	lines := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(c.in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		errs <- scanner.Err()
+	}()
+
+	messageID := 0
+	for {
+		fmt.Fprint(c.out, "> ")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case line := <-lines:
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			messageID++
+			command, args := parseCommand(line)
+			handle(&Request{
+				ChatID:    cliChatID,
+				MessageID: messageID,
+				UserID:    cliUserID,
+				UserName:  "cli",
+				Text:      line,
+				Command:   command,
+				Args:      args,
+			})
+		}
+	}
+}
+
+// parseCommand splits "/cmd@bot args" into its command and arguments,
+// matching how Telegram reports bot commands.
+func parseCommand(text string) (command, args string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	command, args, _ = strings.Cut(text[1:], " ")
+	command, _, _ = strings.Cut(command, "@")
+	return command, strings.TrimSpace(args)
+}
@@ -0,0 +1,408 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/auth"
+	"telegram-bot/store"
+)
+
+const (
+	clipStoreKey        = "clips"
+	clipLinkPrefix      = "clip_" // Of /start arguments from share links
+	clipCodeAlphabet    = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	clipCodeLength      = 6
+	maxClipChars        = 4000 // Fits in one message
+	maxClipsPerUser     = 20
+	clipCleanupInterval = 10 * time.Minute
+
+	// A user can try this many codes that don't match per window, so codes
+	// can't be found by guessing
+	maxClipMisses  = 5
+	clipMissWindow = 15 * time.Minute
+)
+
+var (
+	errNoClip       = errors.New("no such clip or it has expired")
+	errClipGuessing = errors.New("too many clip codes that didn't match")
+)
+
+// clip is text or a file kept for a while, to fetch on another device.
+type clip struct {
+	Code     string    `json:"code"`  // Typed to fetch it, e.g. K7QX2M
+	Token    string    `json:"token"` // Unguessable, for web links
+	UserID   int64     `json:"user_id"`
+	Text     string    `json:"text,omitempty"`
+	FileID   string    `json:"file_id,omitempty"` // Telegram keeps the file; the bot resends it by ID
+	FileName string    `json:"file_name,omitempty"`
+	FileSize int       `json:"file_size,omitempty"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+}
+
+func (c *clip) describe() string {
+	if c.FileID != "" {
+		return fmt.Sprintf("📎 %s (%s)", c.FileName, formatBytes(uint64(c.FileSize)))
+	}
+	first, _, _ := strings.Cut(c.Text, "\n")
+	return "📝 " + truncate(first, 40)
+}
+
+// clipboard keeps everyone's clips in the store until they expire.
+type clipboard struct {
+	store *store.Store
+	ttl   time.Duration
+
+	mu     sync.Mutex
+	clips  []clip
+	misses map[int64][]time.Time // Recent failed lookups, by user
+}
+
+func newClipboard(st *store.Store, ttl time.Duration) *clipboard {
+	c := &clipboard{store: st, ttl: ttl, misses: make(map[int64][]time.Time)}
+	if _, err := st.Get(clipStoreKey, &c.clips); err != nil {
+		log.Printf("Loading clips: %v", err)
+	}
+	return c
+}
+
+// save stores the clips. The caller must hold c.mu.
+func (c *clipboard) save() {
+	if err := c.store.Save(clipStoreKey, c.clips); err != nil {
+		log.Printf("Saving clips: %v", err)
+	}
+}
+
+// add keeps a clip for the clipboard's time to live, giving it a code and
+// token. It fails if the user already has the most clips allowed.
+func (c *clipboard) add(cl clip) (clip, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, existing := range c.clips {
+		if existing.UserID == cl.UserID && now.Before(existing.Expires) {
+			count++
+		}
+	}
+	if count >= maxClipsPerUser {
+		return clip{}, fmt.Errorf("you already have %d clips; delete one with /clip delete <code> first", count)
+	}
+
+	for {
+		cl.Code = clipCode()
+		if !slices.ContainsFunc(c.clips, func(existing clip) bool { return existing.Code == cl.Code }) {
+			break
+		}
+	}
+	token := make([]byte, 16)
+	rand.Read(token)
+	cl.Token = hex.EncodeToString(token)
+	cl.Created, cl.Expires = now, now.Add(c.ttl)
+	c.clips = append(c.clips, cl)
+	c.save()
+	return cl, nil
+}
+
+// clipCode returns a random code without look-alike characters such as 0
+// and O, so it can be read off one screen and typed on another.
+func clipCode() string {
+	b := make([]byte, clipCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = clipCodeAlphabet[int(b[i])%len(clipCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// isClipCode reports whether s has the form of a clip code, in any case.
+func isClipCode(s string) bool {
+	return len(s) == clipCodeLength && !strings.ContainsFunc(strings.ToUpper(s), func(r rune) bool {
+		return !strings.ContainsRune(clipCodeAlphabet, r)
+	})
+}
+
+// get returns the unexpired clip with the code, in any case, for the
+// user. A user who has missed maxClipMisses times in clipMissWindow gets
+// errClipGuessing, even for a code that exists, until the misses age out.
+func (c *clipboard) get(userID int64, code string) (clip, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	misses := slices.DeleteFunc(c.misses[userID], func(t time.Time) bool { return now.Sub(t) > clipMissWindow })
+	if len(misses) >= maxClipMisses {
+		c.misses[userID] = misses
+		return clip{}, errClipGuessing
+	}
+	for _, cl := range c.clips {
+		if cl.Code == code && now.Before(cl.Expires) {
+			return cl, nil
+		}
+	}
+	c.misses[userID] = append(misses, now)
+	return clip{}, errNoClip
+}
+
+// byToken returns the unexpired clip with the web link token.
+func (c *clipboard) byToken(token string) (clip, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cl := range c.clips {
+		if subtle.ConstantTimeCompare([]byte(cl.Token), []byte(token)) == 1 && time.Now().Before(cl.Expires) {
+			return cl, true
+		}
+	}
+	return clip{}, false
+}
+
+// list returns the user's unexpired clips, newest first.
+func (c *clipboard) list(userID int64) []clip {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var clips []clip
+	now := time.Now()
+	for _, cl := range slices.Backward(c.clips) {
+		if cl.UserID == userID && now.Before(cl.Expires) {
+			clips = append(clips, cl)
+		}
+	}
+	return clips
+}
+
+// remove deletes one of the user's clips.
+func (c *clipboard) remove(userID int64, code string) bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.IndexFunc(c.clips, func(cl clip) bool { return cl.Code == code && cl.UserID == userID })
+	if i < 0 {
+		return false
+	}
+	c.clips = slices.Delete(c.clips, i, i+1)
+	c.save()
+	return true
+}
+
+// expire forgets the clips whose time is up, and old failed lookups.
+func (c *clipboard) expire(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for userID, misses := range c.misses {
+		if len(misses) == 0 || now.Sub(misses[len(misses)-1]) > clipMissWindow {
+			delete(c.misses, userID)
+		}
+	}
+	n := len(c.clips)
+	c.clips = slices.DeleteFunc(c.clips, func(cl clip) bool { return !now.Before(cl.Expires) })
+	if len(c.clips) < n {
+		c.save()
+	}
+	return nil
+}
+
+// clipCommand handles /clip, a relay between the user's devices:
+//
+//	/clip                  list your clips
+//	/clip <text>           keep text, replying with its code and links
+//	/clip <code>           fetch a clip
+//	/clip delete <code>    delete one of your clips
+//
+// A file sent with the caption /clip is kept too. Clips expire after
+// CLIP_TTL. Anyone with a code can fetch its clip, so it can be shared.
+// A single word shaped like a code is always a lookup, never kept, and
+// each user may only miss a few times before lookups are refused for a
+// while. verbatim reports whether the reply is a clip's own text, which
+// goes out as it was kept, like its web link, rather than redacted.
+func (b *Bot) clipCommand(ctx context.Context, req *Request) (reply string, verbatim bool) {
+	args := strings.TrimSpace(req.Args)
+	op, rest, _ := strings.Cut(args, " ")
+	switch {
+	case args == "" || strings.EqualFold(args, "list"):
+		return b.listClips(req.UserID), false
+	case strings.EqualFold(op, "delete") || strings.EqualFold(op, "rm"):
+		if !b.clips.remove(req.UserID, rest) {
+			return fmt.Sprintf("You have no clip %q. See /clip.", strings.TrimSpace(rest)), false
+		}
+		return "🗑 Deleted.", false
+	case isClipCode(args):
+		cl, err := b.clips.get(req.UserID, args)
+		switch {
+		case errors.Is(err, errClipGuessing):
+			log.Printf("[clip] refused a lookup by %s: %v", req.UserName, err)
+			return "⛔ Too many codes that didn't match a clip; try again in a few minutes.", false
+		case err != nil:
+			return "No such clip or it has expired.", false
+		}
+		return b.sendClip(req, cl)
+	}
+
+	if auth.RoleFrom(ctx) < auth.Trusted {
+		return "⛔ Only trusted users can keep clips, but you can fetch one with /clip <code>.", false
+	}
+	if len(args) > maxClipChars {
+		return fmt.Sprintf("That's %d characters; clips of text can have up to %d. Send it as a file with the caption /clip instead.", len(args), maxClipChars), false
+	}
+	cl, err := b.clips.add(clip{UserID: req.UserID, Text: args})
+	if err != nil {
+		return "⚠️ " + err.Error(), false
+	}
+	log.Printf("[clip] %s kept text clip %s", req.UserName, cl.Code)
+	return b.clipSaved(cl), false
+}
+
+// isClipCaption reports whether a file's caption asks for it to be kept
+// as a clip.
+func isClipCaption(caption string) bool {
+	fields := strings.Fields(caption)
+	if len(fields) != 1 {
+		return false
+	}
+	command, _, _ := strings.Cut(fields[0], "@")
+	return command == "/clip"
+}
+
+// clipUpload keeps a file sent with the caption /clip.
+func (b *Bot) clipUpload(ctx context.Context, req *Request) {
+	reply := "⛔ Only trusted users can keep clips."
+	if auth.RoleFrom(ctx) >= auth.Trusted {
+		cl, err := b.clips.add(clip{
+			UserID:   req.UserID,
+			FileID:   req.Document.FileID,
+			FileName: req.Document.Name,
+			FileSize: req.Document.Size,
+		})
+		if err != nil {
+			reply = "⚠️ " + err.Error()
+		} else {
+			log.Printf("[clip] %s kept file clip %s: %s", req.UserName, cl.Code, cl.FileName)
+			reply = b.clipSaved(cl)
+		}
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, reply)
+	msg.ReplyToMessageID = req.MessageID
+	b.out.Send(req.ChatID, msg)
+}
+
+// clipSaved tells the user how to fetch a clip they kept.
+func (b *Bot) clipSaved(cl clip) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📋 Kept as %s until %s.\n\nFetch it with /clip %s", cl.Code, cl.Expires.Local().Format("Jan 2 15:04"), cl.Code)
+	if b.botName != "" {
+		fmt.Fprintf(&sb, ", or open https://t.me/%s?start=%s%s", b.botName, clipLinkPrefix, cl.Code)
+	}
+	if link := b.clipWebLink(cl); link != "" {
+		fmt.Fprintf(&sb, "\nIn a browser: %s", link)
+	}
+	return sb.String()
+}
+
+// clipWebLink returns the clip's link on the web server, if there is one.
+func (b *Bot) clipWebLink(cl clip) string {
+	if b.cfg.ClipAddr == "" || b.cfg.ClipURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(b.cfg.ClipURL, "/") + "/clip/" + cl.Token
+}
+
+// sendClip delivers a clip to the chat: text as the reply, and a file by
+// resending it. It reports whether the reply is the clip's text.
+func (b *Bot) sendClip(req *Request, cl clip) (string, bool) {
+	log.Printf("[clip] %s fetched %s", req.UserName, cl.Code)
+	if cl.FileID == "" {
+		return cl.Text, true
+	}
+	doc := tgbotapi.NewDocument(req.ChatID, tgbotapi.FileID(cl.FileID))
+	doc.ReplyToMessageID = req.MessageID
+	b.out.Send(req.ChatID, doc)
+	return fmt.Sprintf("📎 %s, kept until %s", cl.FileName, cl.Expires.Local().Format("Jan 2 15:04")), false
+}
+
+func (b *Bot) listClips(userID int64) string {
+	clips := b.clips.list(userID)
+	if len(clips) == 0 {
+		return "You have no clips. Keep one with /clip <text>, or send a file with the caption /clip."
+	}
+	var sb strings.Builder
+	sb.WriteString("📋 Your clips:\n")
+	for _, cl := range clips {
+		fmt.Fprintf(&sb, "\n%s %s, until %s", cl.Code, cl.describe(), cl.Expires.Local().Format("Jan 2 15:04"))
+	}
+	return sb.String()
+}
+
+// startClipServer serves clips at /clip/<token>, for fetching them in a
+// browser, until ctx is done. Tokens are unguessable and expire with their
+// clips.
+func (b *Bot) startClipServer(ctx context.Context) {
+	if b.cfg.ClipAddr == "" {
+		return
+	}
+	if b.cfg.ClipURL == "" {
+		log.Printf("CLIP_ADDR is set but CLIP_URL is empty; clip web links disabled")
+		return
+	}
+	downloader, _ := b.transport.(fileDownloader)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /clip/{token}", func(w http.ResponseWriter, r *http.Request) {
+		cl, ok := b.clips.byToken(r.PathValue("token"))
+		if !ok {
+			http.Error(w, "This clip doesn't exist or has expired.", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if cl.FileID == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, cl.Text)
+			return
+		}
+		if downloader == nil {
+			http.Error(w, "Files can't be fetched here.", http.StatusNotImplemented)
+			return
+		}
+		body, err := downloader.DownloadFile(r.Context(), cl.FileID)
+		if err != nil {
+			log.Printf("[clip] downloading %s: %v", cl.Code, err)
+			http.Error(w, "The file couldn't be fetched from Telegram; files over 20 MB can only be fetched in the chat.", http.StatusBadGateway)
+			return
+		}
+		defer body.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(cl.FileName)}))
+		io.Copy(w, body)
+	})
+
+	srv := &http.Server{Addr: b.cfg.ClipAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		log.Printf("Clips served on %s", b.cfg.ClipAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Clip server error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,208 @@
+package bot
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// languageAliases maps the tags models put on code fences to the names
+// Telegram's syntax highlighting knows.
+var languageAliases = map[string]string{
+	"py": "python", "python3": "python", "sh": "bash", "shell": "bash", "zsh": "bash",
+	"console": "bash", "js": "javascript", "node": "javascript", "ts": "typescript",
+	"yml": "yaml", "golang": "go", "rs": "rust", "rb": "ruby", "c++": "cpp",
+	"cs": "csharp", "c#": "csharp", "kt": "kotlin", "docker": "dockerfile",
+	"patch": "diff", "htm": "html", "jsonc": "json", "tf": "hcl", "ps1": "powershell",
+}
+
+var (
+	inlineCode = regexp.MustCompile("`([^`\n]+)`")
+
+	// Lines that mark a language when a block has no tag, most specific
+	// first; the first that matches any line wins
+	languageHints = []struct {
+		lang    string
+		pattern *regexp.Regexp
+	}{
+		{"diff", regexp.MustCompile(`^(@@ -\d|\+\+\+ |--- a/|diff --git )`)},
+		{"go", regexp.MustCompile(`^(package \w+$|func (\(\w+ \*?\w+\) )?\w+\(.*\{$|import \($)`)},
+		{"rust", regexp.MustCompile(`^\s*(fn \w+\(.*\{$|let mut |use \w+::|impl\b.*\{$|#\[derive)`)},
+		{"java", regexp.MustCompile(`^\s*(public (static |final )*(class|void|interface)\b|System\.out\.print)`)},
+		{"cpp", regexp.MustCompile(`^\s*(#include\s*<\w+>|std::|using namespace std)`)},
+		{"c", regexp.MustCompile(`^\s*(#include\s*<\w+\.h>|int main\()`)},
+		{"php", regexp.MustCompile(`^\s*<\?php`)},
+		{"html", regexp.MustCompile(`(?i)^\s*(<!doctype html|<html|<head>|<body|<div\b)`)},
+		{"dockerfile", regexp.MustCompile(`^(FROM \S+|RUN |ENTRYPOINT \[|WORKDIR /)`)},
+		{"sql", regexp.MustCompile(`(?i)^\s*(SELECT\s.+\sFROM\s|CREATE (TABLE|INDEX)|INSERT INTO|UPDATE \w+ SET|DELETE FROM)`)},
+		{"typescript", regexp.MustCompile(`^\s*(interface \w+ \{|(export )?type \w+ = |(const|let) \w+: \w+)`)},
+		{"javascript", regexp.MustCompile(`^\s*((const|let|var) \w+ = |function \w*\(|console\.log\(|module\.exports|.*=> \{$|import .+ from ['"])`)},
+		{"python", regexp.MustCompile(`^\s*(def \w+\(.*\):$|class \w+.*:$|from [\w.]+ import |import \w+$|print\(|if __name__ == |elif .+:$|for \w+ in .+:$)`)},
+		{"yaml", regexp.MustCompile(`^(apiVersion: |kind: |\w[\w-]*:\s*$|- name: |services:$|steps:$|jobs:$)`)},
+		{"toml", regexp.MustCompile(`^\[[\w.-]+\]$`)},
+		{"bash", regexp.MustCompile(`^\s*(\$ |sudo |apt(-get)? |brew |pip3? install |npm |go (run|build|test|get|install) |git |cd |echo |export \w+=|curl |docker |kubectl |chmod |mkdir |if \[|for \w+ in .*; do)`)},
+	}
+)
+
+// shebangs maps the interpreter in a #! line to its language.
+var shebangs = map[string]string{
+	"python": "python", "python3": "python", "bash": "bash", "sh": "bash", "zsh": "bash",
+	"node": "javascript", "ruby": "ruby", "perl": "perl",
+}
+
+// detectLanguage guesses the language of a block of code, or returns ""
+// if nothing marks it.
+func detectLanguage(code string) string {
+	trimmed := strings.TrimSpace(code)
+	if trimmed == "" {
+		return ""
+	}
+	if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	if first, _, _ := strings.Cut(trimmed, "\n"); strings.HasPrefix(first, "#!") {
+		fields := strings.Fields(first[2:])
+		if len(fields) > 0 {
+			interpreter := fields[0][strings.LastIndex(fields[0], "/")+1:]
+			if interpreter == "env" && len(fields) > 1 {
+				interpreter = fields[1]
+			}
+			if lang, ok := shebangs[interpreter]; ok {
+				return lang
+			}
+		}
+	}
+	lines := strings.Split(trimmed, "\n")
+	for _, hint := range languageHints {
+		for _, line := range lines {
+			if hint.pattern.MatchString(line) {
+				return hint.lang
+			}
+		}
+	}
+	return ""
+}
+
+// normalizeLanguage turns a fence's tag into the name Telegram knows.
+func normalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if lang, ok := languageAliases[tag]; ok {
+		return lang
+	}
+	return tag
+}
+
+// codeBlock renders code as a Telegram HTML block, tagged with its
+// language so apps highlight it.
+func codeBlock(code, lang string) string {
+	code = strings.Trim(code, "\n")
+	if lang == "" {
+		return "<pre>" + html.EscapeString(code) + "</pre>"
+	}
+	return `<pre><code class="language-` + html.EscapeString(lang) + `">` + html.EscapeString(code) + "</code></pre>"
+}
+
+// formatCode renders a reply containing code as Telegram HTML, with each
+// fenced block tagged by language (detected when the fence has none) and
+// inline `code` kept monospace. JSON printed without a fence, as tools
+// print manifests, becomes a block too. Replies without code return false
+// and are sent as plain text.
+func formatCode(text string) (string, bool) {
+	var parts []string // Rendered runs of lines, rejoined by newlines
+	var prose []string
+	found := false
+	flushProse := func() {
+		if len(prose) == 0 {
+			return
+		}
+		before, jsonText := splitTrailingJSON(strings.Join(prose, "\n"))
+		prose = prose[:0]
+		escaped := html.EscapeString(before)
+		if inlineCode.MatchString(escaped) {
+			found = true
+			escaped = inlineCode.ReplaceAllString(escaped, "<code>$1</code>")
+		}
+		if jsonText != "" {
+			found = true
+			escaped += codeBlock(jsonText, "json")
+		}
+		parts = append(parts, escaped)
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		fence := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(fence, "```") {
+			prose = append(prose, lines[i])
+			continue
+		}
+		flushProse()
+		found = true
+
+		// The block runs to the closing fence, or to the end of a reply
+		// that was cut off inside it
+		end := i + 1
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "```" {
+			end++
+		}
+		code := strings.Join(lines[i+1:end], "\n")
+		var lang string
+		if tag := strings.Fields(strings.TrimPrefix(fence, "```")); len(tag) > 0 {
+			lang = normalizeLanguage(tag[0])
+		}
+		if lang == "" {
+			lang = detectLanguage(code)
+		}
+		parts = append(parts, codeBlock(code, lang))
+		i = end
+	}
+	flushProse()
+
+	if !found {
+		return "", false
+	}
+	return strings.Join(parts, "\n"), true
+}
+
+// splitTrailingJSON finds JSON that ends a stretch of prose and starts on
+// a line of its own, and returns the prose before it and the JSON.
+func splitTrailingJSON(s string) (before, jsonText string) {
+	for i := 0; i < len(s); {
+		line := s[i:]
+		if j := strings.IndexByte(line, '\n'); j >= 0 {
+			line = line[:j]
+		}
+		if t := strings.TrimSpace(line); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+			if rest := strings.TrimSpace(s[i:]); len(rest) > 2 && json.Valid([]byte(rest)) {
+				return s[:i], rest
+			}
+		}
+		next := strings.IndexByte(s[i:], '\n')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return s, ""
+}
+
+var htmlTags = regexp.MustCompile(`<pre><code class="language-([\w+#-]+)">|<pre>|</code></pre>|</pre>|</?code>`)
+
+// plainCode turns the HTML formatCode makes back into text with fences,
+// for transports that print replies as they are.
+func plainCode(s string) string {
+	s = htmlTags.ReplaceAllStringFunc(s, func(tag string) string {
+		switch {
+		case strings.HasPrefix(tag, `<pre><code class="language-`):
+			return "```" + htmlTags.FindStringSubmatch(tag)[1] + "\n"
+		case tag == "<pre>":
+			return "```\n"
+		case tag == "</code></pre>" || tag == "</pre>":
+			return "\n```"
+		default:
+			return "`"
+		}
+	})
+	return html.UnescapeString(s)
+}
@@ -0,0 +1,267 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/agent"
+	"telegram-bot/auth"
+	"telegram-bot/store"
+	"telegram-bot/tenant"
+	"telegram-bot/tools"
+)
+
+const (
+	codingStoreKey     = "coding_sessions"
+	codingHistoryTurns = 30 // Earlier exchanges sent with each message in a coding session
+	maxTestOutputLines = 25
+)
+
+// codingTools are the tools offered in a coding session; git runs through
+// bash.
+var codingTools = []string{"python", "bash", "files"}
+
+// testCommands are the commands that run a project's tests, by the file
+// that marks the kind of project, in order of preference.
+var testCommands = []struct {
+	marker  string
+	command string
+}{
+	{"go.mod", "go test ./... 2>&1"},
+	{"Cargo.toml", "cargo test 2>&1"},
+	{"package.json", "npm test --silent 2>&1"},
+	{"pytest.ini", "python3 -m pytest -q 2>&1"},
+	{"pyproject.toml", "python3 -m pytest -q 2>&1"},
+	{"setup.py", "python3 -m pytest -q 2>&1"},
+	{"tests", "python3 -m pytest -q 2>&1"},
+	{"Makefile", "make test 2>&1"},
+}
+
+// codingSession is a chat's /code mode: a focused loop on one project.
+type codingSession struct {
+	Project string    `json:"project,omitempty"` // Relative to the workspace; empty for its root
+	Since   time.Time `json:"since"`
+}
+
+// codingSessions keeps which chats are in /code mode.
+type codingSessions struct {
+	mu    sync.Mutex
+	store *store.Store
+	chats map[int64]codingSession
+}
+
+func newCodingSessions(st *store.Store) *codingSessions {
+	c := &codingSessions{store: st, chats: make(map[int64]codingSession)}
+	if _, err := st.Get(codingStoreKey, &c.chats); err != nil {
+		log.Printf("Loading coding sessions: %v", err)
+	}
+	if c.chats == nil {
+		c.chats = make(map[int64]codingSession)
+	}
+	return c
+}
+
+func (c *codingSessions) get(chatID int64) (codingSession, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.chats[chatID]
+	return s, ok
+}
+
+func (c *codingSessions) set(chatID int64, s codingSession) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chats[chatID] = s
+	c.save()
+}
+
+func (c *codingSessions) end(chatID int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.chats[chatID]; !ok {
+		return false
+	}
+	delete(c.chats, chatID)
+	c.save()
+	return true
+}
+
+// save stores the sessions. The caller must hold c.mu.
+func (c *codingSessions) save() {
+	if err := c.store.Set(codingStoreKey, c.chats); err != nil {
+		log.Printf("Saving coding sessions: %v", err)
+	}
+}
+
+// codeCommand handles /code, which switches the chat into a coding session:
+//
+//	/code          start one in the workspace root, or show the current one
+//	/code myapp    start one, or switch project, in the workspace's myapp/
+//	/code off      go back to the usual assistant
+func (b *Bot) codeCommand(ctx context.Context, req *Request) string {
+	arg := strings.TrimSpace(req.Args)
+	if strings.EqualFold(arg, "off") {
+		if !b.coding.end(req.ChatID) {
+			return "This chat isn't in a coding session."
+		}
+		return "👋 Coding session ended; all tools are back."
+	}
+	if auth.RoleFrom(ctx) < auth.Trusted {
+		return "⛔ Only trusted users can start a coding session."
+	}
+	if b.cfg.PythonWorkspace == "" {
+		return "Coding sessions need a workspace (PYTHON_WORKSPACE)."
+	}
+
+	current, on := b.coding.get(req.ChatID)
+	if arg == "" && on {
+		return codingStatus(current, b.undo != nil)
+	}
+	project, err := b.projectDir(ctx, arg)
+	if err != nil {
+		return "⚠️ " + err.Error()
+	}
+	s := codingSession{Project: project, Since: time.Now().UTC()}
+	if on {
+		s.Since = current.Since
+	}
+	b.coding.set(req.ChatID, s)
+	return codingStatus(s, b.undo != nil)
+}
+
+func codingStatus(s codingSession, diffs bool) string {
+	project := "the workspace root"
+	if s.Project != "" {
+		project = s.Project + "/"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "💻 Coding session in %s.\n\n", project)
+	fmt.Fprintf(&sb, "• Tools: %s (git through bash)\n", strings.Join(codingTools, ", "))
+	fmt.Fprintf(&sb, "• Remembers the last %d exchanges\n", codingHistoryTurns)
+	if diffs {
+		sb.WriteString("• Edits are shown as diffs you can revert\n")
+	}
+	sb.WriteString("• Tests run after each request that changes files\n\n")
+	sb.WriteString("/code <dir> switches project; /code off ends the session.")
+	return sb.String()
+}
+
+// projectDir checks that a project directory exists in the user's
+// workspace and returns it cleaned, relative to the workspace.
+func (b *Bot) projectDir(ctx context.Context, dir string) (string, error) {
+	if dir == "" || dir == "." {
+		return "", nil
+	}
+	rel := filepath.Clean(dir)
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the project must be a directory in the workspace, like myapp or repos/api")
+	}
+	info, err := os.Stat(filepath.Join(tenant.Workspace(ctx, b.cfg.PythonWorkspace), rel))
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("there is no %s/ directory in the workspace", rel)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// codingContext narrows a request in a coding session to the session's
+// tools and project, and tells the model how the session works.
+func codingContext(ctx context.Context, s codingSession, docs []agent.Document) context.Context {
+	ctx = tools.WithToolset(ctx, codingTools...)
+	where := "the workspace root"
+	if s.Project != "" {
+		ctx = tools.WithProject(ctx, s.Project)
+		where = s.Project + "/ in the workspace"
+	}
+	note := agent.Document{
+		Name: "Coding session",
+		Text: "The user is in a focused coding session on the project in " + where + ". " +
+			"Bash commands run there unless you set cwd; give file paths to other tools relative to the workspace. " +
+			"Make the changes asked for by editing files, and run git through bash. " +
+			"Reply briefly with what you changed and why: the user is shown a diff of your edits and the project's tests are run after you finish, " +
+			"so don't repeat whole files or run the full test suite yourself.",
+	}
+	return agent.WithPinned(ctx, append([]agent.Document{note}, docs...))
+}
+
+// projectStamp summarizes a project's files, to tell whether a request
+// changed any: the number of files and the latest modification time.
+type projectStamp struct {
+	files  int
+	latest int64 // Unix nanoseconds
+}
+
+// skippedDirs are left out of project stamps: version control, and
+// dependencies and caches that change without the project changing.
+var skippedDirs = map[string]bool{
+	".git": true, "node_modules": true, ".venv": true, "venv": true,
+	"__pycache__": true, ".pytest_cache": true, "target": true,
+}
+
+func (b *Bot) projectStamp(ctx context.Context, s codingSession) projectStamp {
+	var stamp projectStamp
+	root := filepath.Join(tenant.Workspace(ctx, b.cfg.PythonWorkspace), s.Project)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && skippedDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			stamp.files++
+			stamp.latest = max(stamp.latest, info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return stamp
+}
+
+// runProjectTests runs the session project's tests, if it has any the bot
+// knows how to run, and returns a summary to add to the reply.
+func (b *Bot) runProjectTests(ctx context.Context, s codingSession) string {
+	root := filepath.Join(tenant.Workspace(ctx, b.cfg.PythonWorkspace), s.Project)
+	command := ""
+	for _, tc := range testCommands {
+		if _, err := os.Stat(filepath.Join(root, tc.marker)); err == nil {
+			command = tc.command
+			break
+		}
+	}
+	if command == "" {
+		return ""
+	}
+
+	tool, ok := b.registry.Get("bash")
+	if !ok {
+		return ""
+	}
+	args := map[string]any{"command": command}
+	if s.Project != "" {
+		args["cwd"] = s.Project
+	}
+	name := strings.TrimSuffix(command, " 2>&1")
+	result, err := tools.Run(ctx, tool, args)
+	if err != nil {
+		return fmt.Sprintf("🧪 Couldn't run `%s`: %v", name, err)
+	}
+	output := result.Text
+	passed := !strings.Contains(output, "\n\nExit code: ") && !strings.Contains(output, "Command timed out")
+	if passed {
+		return fmt.Sprintf("🧪 `%s` passed.", name)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > maxTestOutputLines {
+		lines = lines[len(lines)-maxTestOutputLines:]
+	}
+	return fmt.Sprintf("🧪 `%s` failed:\n```\n%s\n```", name, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,288 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"telegram-bot/agent"
+	"telegram-bot/store"
+	"telegram-bot/transcript"
+)
+
+const (
+	maxStoredTurns   = 200  // Older turns are dropped, which ends branches reaching back to them
+	maxTurnTextChars = 4000 // Per message, so one huge reply can't crowd out the rest
+	maxStepChars     = 2000 // Per tool result kept with a turn
+	maxTurnSteps     = 20   // Tool calls and results kept per turn, the latest ones
+	charsPerToken    = 4    // Rough size of a token, for the history budget
+)
+
+// turn is one exchange: a user message and the bot's answer. Turns form a
+// tree through Parent, so replying to an older message starts a branch.
+type turn struct {
+	ID        int       `json:"id"`                 // Telegram message ID of the user's message
+	Parent    int       `json:"parent,omitempty"`   // Turn this one follows; 0 starts a conversation
+	ReplyID   int       `json:"reply_id,omitempty"` // Telegram message ID of the bot's answer
+	User      string    `json:"user"`
+	Assistant string    `json:"assistant"`
+	Time      time.Time `json:"time"`
+
+	// Steps are the tool calls made while answering and their results,
+	// so follow-ups like "run it again with 100 iterations" can see them.
+	Steps []agent.Message `json:"steps,omitempty"`
+}
+
+// conversation is a chat's turns, oldest first, and the turn the next
+// plain message continues from.
+type conversation struct {
+	Head  int    `json:"head"`
+	Turns []turn `json:"turns"`
+}
+
+func (c *conversation) find(match func(*turn) bool) *turn {
+	for i := len(c.Turns) - 1; i >= 0; i-- {
+		if match(&c.Turns[i]) {
+			return &c.Turns[i]
+		}
+	}
+	return nil
+}
+
+// conversations keeps each chat's history in the store, and in the
+// transcript index for /search.
+type conversations struct {
+	store  *store.Store
+	index  *transcript.Index // nil when transcript search is off
+	budget int               // Tokens of history sent to the model; 0 for no limit
+
+	mu         sync.Mutex
+	chats      map[int64]*conversation
+	backfilled map[int64]bool // Chats whose stored turns have been indexed
+}
+
+func newConversations(st *store.Store, index *transcript.Index, budget int) *conversations {
+	return &conversations{store: st, index: index, budget: budget, chats: make(map[int64]*conversation), backfilled: make(map[int64]bool)}
+}
+
+func conversationKey(chatID int64) string {
+	return fmt.Sprintf("conversation_%d", chatID)
+}
+
+// load returns the chat's conversation, reading it from the store on first
+// use. The caller must hold c.mu.
+func (c *conversations) load(chatID int64) *conversation {
+	if conv, ok := c.chats[chatID]; ok {
+		return conv
+	}
+	conv := &conversation{}
+	if _, err := c.store.Get(conversationKey(chatID), conv); err != nil {
+		log.Printf("Loading conversation for chat %d: %v", chatID, err)
+	}
+	c.chats[chatID] = conv
+	return conv
+}
+
+// parent decides which turn a new message follows. A reply to one of the
+// bot's earlier answers branches from that answer; a reply to one of the
+// user's own earlier messages branches from just before it, like editing
+// the question. Anything else continues from the latest turn.
+func (c *conversations) parent(chatID int64, replyTo int) (parent int, branched bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv := c.load(chatID)
+	if replyTo != 0 {
+		if t := conv.find(func(t *turn) bool { return t.ReplyID == replyTo }); t != nil {
+			return t.ID, t.ID != conv.Head
+		}
+		if t := conv.find(func(t *turn) bool { return t.ID == replyTo }); t != nil {
+			return t.Parent, true
+		}
+	}
+	return conv.Head, false
+}
+
+// history returns up to limit exchanges leading up to and including the
+// given turn, oldest first, as model messages. Within the token budget,
+// recent exchanges come with their tool calls; when those don't fit, the
+// exchange is sent without them, and older ones are left out altogether.
+func (c *conversations) history(chatID int64, from, limit int) []agent.Message {
+	turns := c.thread(chatID, from, limit)
+	if c.budget <= 0 {
+		return turnMessages(turns)
+	}
+	used := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		cost := messageTokens(turnMessages(turns[i : i+1]))
+		if used+cost > c.budget {
+			turns[i].Steps = nil
+			cost = messageTokens(turnMessages(turns[i : i+1]))
+		}
+		if used+cost > c.budget {
+			turns = turns[i+1:]
+			break
+		}
+		used += cost
+	}
+	return turnMessages(turns)
+}
+
+// messageTokens estimates how many tokens the messages take up.
+func messageTokens(messages []agent.Message) int {
+	chars := 0
+	for _, m := range messages {
+		chars += len(m.Content)
+		for _, tc := range m.ToolCalls {
+			chars += len(tc.Function.Name) + len(tc.Function.Arguments)
+		}
+	}
+	return chars / charsPerToken
+}
+
+// thread returns up to limit turns leading up to and including the given
+// turn, oldest first.
+func (c *conversations) thread(chatID int64, from, limit int) []turn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv := c.load(chatID)
+	var path []turn
+	for id := from; id != 0 && len(path) < limit; {
+		t := conv.find(func(t *turn) bool { return t.ID == id })
+		if t == nil {
+			break // Dropped as too old
+		}
+		path = append(path, *t)
+		if t.Parent >= t.ID {
+			break // Message IDs only grow, so this is a leftover from an earlier CLI session
+		}
+		id = t.Parent
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// turnMessages converts turns to model messages, with each turn's tool
+// calls between the question and the answer.
+func turnMessages(turns []turn) []agent.Message {
+	messages := make([]agent.Message, 0, 2*len(turns))
+	for _, t := range turns {
+		messages = append(messages, agent.Message{Role: "user", Content: t.User})
+		messages = append(messages, t.Steps...)
+		messages = append(messages, agent.Message{Role: "assistant", Content: t.Assistant})
+	}
+	return messages
+}
+
+// trimSteps keeps a turn's latest tool calls, starting with a call rather
+// than a result, and cuts long results and arguments down to size.
+func trimSteps(steps []agent.Message) []agent.Message {
+	if len(steps) > maxTurnSteps {
+		steps = steps[len(steps)-maxTurnSteps:]
+	}
+	for len(steps) > 0 && steps[0].Role == "tool" {
+		steps = steps[1:]
+	}
+	trimmed := make([]agent.Message, len(steps))
+	for i, m := range steps {
+		m.Content = truncate(m.Content, maxStepChars)
+		if len(m.ToolCalls) > 0 {
+			m.ToolCalls = slices.Clone(m.ToolCalls)
+			for j, tc := range m.ToolCalls {
+				if len(tc.Function.Arguments) > maxTurnTextChars {
+					m.ToolCalls[j].Function.Arguments = json.RawMessage(fmt.Sprintf(`{"omitted":"%d characters of arguments"}`, len(tc.Function.Arguments)))
+				}
+			}
+		}
+		trimmed[i] = m
+	}
+	return trimmed
+}
+
+// add records an exchange and makes it the chat's latest turn.
+func (c *conversations) add(chatID int64, t turn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.User = truncate(t.User, maxTurnTextChars)
+	t.Assistant = truncate(t.Assistant, maxTurnTextChars)
+	t.Steps = trimSteps(t.Steps)
+	t.Time = time.Now().UTC()
+
+	conv := c.load(chatID)
+	conv.Turns = append(conv.Turns, t)
+	if len(conv.Turns) > maxStoredTurns {
+		conv.Turns = conv.Turns[len(conv.Turns)-maxStoredTurns:]
+	}
+	conv.Head = t.ID
+	c.save(chatID, conv)
+
+	// Indexing can wait on the embedding model, so it doesn't hold up the reply
+	if c.index != nil {
+		go func() {
+			if err := c.index.Add(context.Background(), chatID, transcriptTurn(t)); err != nil {
+				log.Printf("Indexing turn for chat %d: %v", chatID, err)
+			}
+		}()
+	}
+}
+
+// backfill adds the chat's stored turns to the transcript index, once per
+// run. Turns already indexed are skipped.
+func (c *conversations) backfill(ctx context.Context, chatID int64) {
+	c.mu.Lock()
+	if c.index == nil || c.backfilled[chatID] {
+		c.mu.Unlock()
+		return
+	}
+	c.backfilled[chatID] = true
+	stored := slices.Clone(c.load(chatID).Turns)
+	c.mu.Unlock()
+
+	turns := make([]transcript.Turn, len(stored))
+	for i, t := range stored {
+		turns[i] = transcriptTurn(t)
+	}
+	if err := c.index.Add(ctx, chatID, turns...); err != nil {
+		log.Printf("Indexing stored turns for chat %d: %v", chatID, err)
+	}
+}
+
+// replied records the message ID Telegram gave the bot's answer to the
+// user message requestID, so later replies to it can be traced back.
+func (c *conversations) replied(chatID int64, requestID, replyID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv := c.load(chatID)
+	t := conv.find(func(t *turn) bool { return t.ID == requestID })
+	if t == nil || t.ReplyID != 0 {
+		return
+	}
+	t.ReplyID = replyID
+	c.save(chatID, conv)
+}
+
+// reset starts a new conversation. Earlier turns are kept so replies to
+// them can still branch.
+func (c *conversations) reset(chatID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv := c.load(chatID)
+	conv.Head = 0
+	c.save(chatID, conv)
+}
+
+// save stores the conversation; it is written on the next flush. The
+// caller must hold c.mu.
+func (c *conversations) save(chatID int64, conv *conversation) {
+	if err := c.store.Set(conversationKey(chatID), conv); err != nil {
+		log.Printf("Saving conversation for chat %d: %v", chatID, err)
+	}
+}
@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/outbox"
+	"telegram-bot/priority"
+	"telegram-bot/runs"
+	"telegram-bot/tools"
+)
+
+// startPprof serves the pprof endpoints on addr. Every request must carry
+// the token as "Authorization: Bearer <token>" or a ?token= query parameter.
+func startPprof(addr, token string) {
+	if token == "" {
+		log.Printf("DEBUG_ADDR is set but DEBUG_TOKEN is empty; pprof disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if given == "" {
+			given = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	go func() {
+		log.Printf("pprof listening on %s", addr)
+		if err := http.ListenAndServe(addr, handler); err != nil {
+			log.Printf("pprof server error: %v", err)
+		}
+	}()
+}
+
+// debugCommand handles the owner-only /debug command and returns the reply.
+// Goroutine dumps are too long for a message and are sent as a document.
+func debugCommand(args string, chatID int64, out *outbox.Queue, tracker *runs.Tracker, queue *priority.Queue, breakers *tools.Breakers, stateDir string) string {
+	switch strings.TrimSpace(args) {
+	case "", "status":
+		return debugMemory() + "\n\n" + debugRuns(tracker) + "\n\n" + debugQueues(out, queue) + "\n\n" + debugBreakers(breakers)
+	case "goroutines":
+		var buf bytes.Buffer
+		if err := runtimepprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+			return "⚠️ " + err.Error()
+		}
+		out.Send(chatID, tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+			Name:  fmt.Sprintf("goroutines-%s.txt", time.Now().Format("20060102-150405")),
+			Bytes: buf.Bytes(),
+		}))
+		return fmt.Sprintf("🧵 %d goroutines, dump attached.", runtime.NumGoroutine())
+	case "mem":
+		return debugMemory()
+	case "runs":
+		return debugRuns(tracker)
+	case "queues":
+		return debugQueues(out, queue)
+	case "stuck":
+		return debugStuck(stateDir)
+	case "tools":
+		return debugBreakers(breakers)
+	default:
+		return "Usage: /debug [status|goroutines|mem|runs|queues|stuck|tools]"
+	}
+}
+
+func debugMemory() string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return fmt.Sprintf("🧠 Memory\nHeap: %s in use, %s sys\nTotal alloc: %s\nGC cycles: %d\nGoroutines: %d",
+		formatBytes(m.HeapInuse), formatBytes(m.Sys), formatBytes(m.TotalAlloc), m.NumGC, runtime.NumGoroutine())
+}
+
+func debugRuns(tracker *runs.Tracker) string {
+	active := tracker.Active()
+	if len(active) == 0 {
+		return "🤖 No active agent runs"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🤖 %d active agent runs:", len(active)))
+	for _, run := range active {
+		text := run.Text
+		if len(text) > 60 {
+			text = text[:60] + "..."
+		}
+		sb.WriteString(fmt.Sprintf("\n• #%d @%s in chat %d for %v: %s",
+			run.ID, run.UserName, run.ChatID, time.Since(run.Started).Round(time.Second), text))
+	}
+	return sb.String()
+}
+
+func debugQueues(out *outbox.Queue, queue *priority.Queue) string {
+	s := queue.Stats()
+	slots := fmt.Sprintf("%d running (no limit)", s.Running)
+	if s.Slots > 0 {
+		slots = fmt.Sprintf("%d/%d in use", s.Running, s.Slots)
+	}
+	return fmt.Sprintf("📬 Outgoing queue: %d pending\n⏳ Run slots: %s, %d light and %d heavy waiting",
+		out.Depth(), slots, s.WaitingLight, s.WaitingHeavy)
+}
+
+func debugBreakers(breakers *tools.Breakers) string {
+	open := breakers.Open()
+	if len(open) == 0 {
+		return "🔌 All tools online"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔌 %d tools offline:", len(open)))
+	for _, b := range open {
+		retry := "now"
+		if wait := time.Until(b.Retry); wait > 0 {
+			retry = "in " + wait.Round(time.Second).String()
+		}
+		sb.WriteString(fmt.Sprintf("\n• %s, retrying %s: %v", b.Tool, retry, b.LastErr))
+	}
+	return sb.String()
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
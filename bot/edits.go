@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/auth"
+)
+
+const (
+	editButtonPrefix  = "edit:"
+	maxDiffChars      = 3000 // Of the diff shown in a reply; the rest is summarized
+	minWholeFileLines = 5    // Shorter code blocks in a reply are left alone
+	wholeFileOverlap  = 0.8  // Share of a block's lines found in an edited file to count as a copy of it
+)
+
+// editReply shows the edits a run made to existing workspace files as a
+// unified diff, in place of any copies of the edited files in the reply,
+// with buttons to revert the run's changes and apply them again. Runs that
+// only added or removed files keep their reply as it is.
+func (u *workspaceUndo) editReply(ctx context.Context, workspace string, rec *undoRecord, reply string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	changes, err := u.repo.Changes(ctx, rec.Before, rec.After)
+	if err != nil {
+		log.Printf("Listing workspace changes: %v", err)
+		return reply, nil
+	}
+	var edited []string
+	for _, c := range changes {
+		if c.Status == "modified" {
+			edited = append(edited, c.Path)
+		}
+	}
+	if len(edited) == 0 {
+		return reply, nil
+	}
+	diff, err := u.repo.Diff(ctx, rec.Before, rec.After, edited...)
+	if err != nil || strings.TrimSpace(diff) == "" {
+		if err != nil {
+			log.Printf("Diffing workspace changes: %v", err)
+		}
+		return reply, nil
+	}
+
+	var contents []string
+	for _, path := range edited {
+		if data, err := os.ReadFile(filepath.Join(workspace, path)); err == nil {
+			contents = append(contents, string(data))
+		}
+	}
+	reply = strings.TrimSpace(dropFileCopies(reply, contents))
+	if reply != "" {
+		reply += "\n\n"
+	}
+	reply += fmt.Sprintf("📝 Changes to %s:\n```diff\n%s\n```", strings.Join(edited, ", "), shortenDiff(diff))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Apply", editButtonPrefix+"apply:"+rec.After),
+		tgbotapi.NewInlineKeyboardButtonData("↩️ Revert", editButtonPrefix+"revert:"+rec.After),
+	))
+	return reply, &keyboard
+}
+
+// dropFileCopies removes fenced code blocks from a reply that repeat most
+// of one of the given file contents, since the diff shows what changed.
+func dropFileCopies(reply string, contents []string) string {
+	fileLines := make([]map[string]bool, len(contents))
+	for i, c := range contents {
+		fileLines[i] = make(map[string]bool)
+		for _, line := range strings.Split(c, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				fileLines[i][line] = true
+			}
+		}
+	}
+	isCopy := func(block []string) bool {
+		var code []string
+		for _, line := range block {
+			if line = strings.TrimSpace(line); line != "" {
+				code = append(code, line)
+			}
+		}
+		if len(code) < minWholeFileLines {
+			return false
+		}
+		for _, lines := range fileLines {
+			found := 0
+			for _, line := range code {
+				if lines[line] {
+					found++
+				}
+			}
+			if float64(found) >= wholeFileOverlap*float64(len(code)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	lines := strings.Split(reply, "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+			kept = append(kept, lines[i])
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && strings.TrimSpace(lines[end]) != "```" {
+			end++
+		}
+		if !isCopy(lines[i+1 : end]) {
+			kept = append(kept, lines[i:min(end+1, len(lines))]...)
+		}
+		i = end
+	}
+	return strings.Join(kept, "\n")
+}
+
+// shortenDiff cuts a diff that's too long to read in a chat at a line
+// boundary, noting how much was left out.
+func shortenDiff(diff string) string {
+	if len(diff) <= maxDiffChars {
+		return diff
+	}
+	cut := strings.LastIndexByte(diff[:maxDiffChars], '\n')
+	if cut < 0 {
+		cut = maxDiffChars
+	}
+	left := strings.Count(diff[cut:], "\n")
+	return diff[:cut] + fmt.Sprintf("\n… %d more line(s); /history shows the files' versions", left)
+}
+
+// editButton handles the Apply and Revert buttons on a reply that edited
+// workspace files.
+func (b *Bot) editButton(ctx context.Context, req *Request, data string) {
+	answer := ""
+	defer func() {
+		b.out.Send(req.ChatID, tgbotapi.NewCallback(req.Button.ID, answer))
+	}()
+
+	action, after, _ := strings.Cut(data, ":")
+	switch {
+	case b.undo == nil:
+		answer = "This button no longer works."
+	case auth.RoleFrom(ctx) < auth.Trusted:
+		answer = "⛔ Only trusted users can change the workspace."
+	default:
+		answer = b.undo.toggle(ctx, req.ChatID, after, action == "revert")
+	}
+}
+
+// toggle reverts a run's changes, or applies them again after a revert,
+// and returns a short note on the outcome.
+func (u *workspaceUndo) toggle(ctx context.Context, chatID int64, after string, revert bool) string {
+	u.mu.Lock()
+	var rec *undoRecord
+	for i := range u.stacks[chatID] {
+		if u.stacks[chatID][i].After == after {
+			rec = &u.stacks[chatID][i]
+		}
+	}
+	if rec == nil {
+		u.mu.Unlock()
+		return "These changes are too old to revert or apply from here."
+	}
+	if rec.Reverted == revert {
+		u.mu.Unlock()
+		if revert {
+			return "Already reverted."
+		}
+		return "Already applied."
+	}
+	before, title := rec.Before, snapshotTitle(rec.Request)
+	u.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	// Reverting restores the files as they were before the run; applying
+	// is the same with the snapshots swapped
+	from, to, message := before, after, "Revert: "+title
+	if !revert {
+		from, to, message = after, before, "Apply: "+title
+	}
+	changes, err := u.repo.Revert(ctx, from, to, message)
+	if err != nil {
+		return "⚠️ " + err.Error()
+	}
+
+	u.mu.Lock()
+	for i := range u.stacks[chatID] {
+		if u.stacks[chatID][i].After == after {
+			u.stacks[chatID][i].Reverted = revert
+		}
+	}
+	u.save()
+	u.mu.Unlock()
+
+	if revert {
+		return fmt.Sprintf("↩️ Reverted %d file change(s)", len(changes))
+	}
+	return fmt.Sprintf("✅ Applied %d file change(s) again", len(changes))
+}
@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"telegram-bot/events"
+	"telegram-bot/priority"
+)
+
+const (
+	maxEventBody     = 1 << 20
+	eventDescribeFor = 2 * time.Minute
+)
+
+// EventDescriber is implemented by agents that can write a notification
+// for an event another service posted. *agent.Agent implements it.
+type EventDescriber interface {
+	DescribeEvent(ctx context.Context, source, payload string) (string, error)
+}
+
+// startEventServer accepts events at POST /events/<source> from the
+// configured sources and posts them to the sources' chats.
+func (b *Bot) startEventServer(ctx context.Context) {
+	if len(b.eventSources) == 0 {
+		return
+	}
+	if b.cfg.EventsAddr == "" {
+		log.Printf("EVENT_SOURCES_FILE is set but EVENTS_ADDR is empty; events disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /events/{source}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("source")
+		source, ok := b.eventSources[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxEventBody))
+		if err != nil {
+			http.Error(w, "reading event", http.StatusBadRequest)
+			return
+		}
+		if !source.Authorized(r, body) {
+			log.Printf("[events] rejected an event for %s from %s: bad token", name, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if source.Format == "alertmanager" {
+			if err := b.postAlerts(name, source, body); err != nil {
+				log.Printf("[events] %s: %v", name, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		msg, err := source.Message(name, r.Header, body)
+		if err != nil {
+			log.Printf("[events] %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if msg == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// Answer now; senders like GitHub give up after a few seconds,
+		// sooner than the model may take
+		w.WriteHeader(http.StatusAccepted)
+		go b.postEvent(ctx, name, source, msg, body)
+	})
+
+	srv := &http.Server{Addr: b.cfg.EventsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Events accepted on %s from %d source(s)", b.cfg.EventsAddr, len(b.eventSources))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Event server error: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+}
+
+// postEvent sends an event's message to the source's chats, written by
+// the model if the source asks for that and it can.
+func (b *Bot) postEvent(ctx context.Context, name string, source *events.Source, msg string, body []byte) {
+	if describer, ok := b.agent.(EventDescriber); ok && source.Summarize {
+		if description, err := b.describeEvent(ctx, describer, name, body); err != nil {
+			log.Printf("[events] describing %s event: %v", name, err)
+		} else {
+			msg = description
+		}
+	}
+	log.Printf("[events] posting a %s event to %d chat(s)", name, len(source.Chats))
+	for _, chatID := range source.Chats {
+		b.notify(chatID, msg, source.Urgent)
+	}
+}
+
+func (b *Bot) describeEvent(ctx context.Context, describer EventDescriber, name string, body []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, eventDescribeFor)
+	defer cancel()
+	release, err := b.queue.Acquire(ctx, 0, priority.Light)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return describer.DescribeEvent(ctx, name, string(body))
+}
@@ -0,0 +1,309 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/agent"
+	"telegram-bot/priority"
+	"telegram-bot/tools"
+)
+
+const (
+	formButtonPrefix = "form:"
+	formTimeout      = 15 * time.Minute // Unanswered forms are dropped after this
+)
+
+// formSession is a form one user is filling in, in one chat, to finish a
+// request the agent couldn't complete without their answers.
+type formSession struct {
+	mu        sync.Mutex // Held while an answer is handled
+	id        int
+	form      *tools.Form
+	request   string // The message that started it
+	messageID int    // That message's ID, for the conversation
+	parent    int    // The turn it continued from
+	asked     time.Time
+}
+
+type formKey struct {
+	chatID, userID int64
+}
+
+// forms holds the forms being filled in. A user has at most one open per
+// chat; starting another replaces it.
+type forms struct {
+	mu     sync.Mutex
+	nextID int
+	open   map[formKey]*formSession
+}
+
+func newForms() *forms {
+	return &forms{open: make(map[formKey]*formSession)}
+}
+
+func (f *forms) start(key formKey, s *formSession) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	s.id = f.nextID
+	s.asked = time.Now()
+	f.open[key] = s
+}
+
+// get returns the user's open form, dropping it if it has gone unanswered
+// too long.
+func (f *forms) get(key formKey) (*formSession, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.open[key]
+	if ok && time.Since(s.asked) > formTimeout {
+		delete(f.open, key)
+		return nil, false
+	}
+	return s, ok
+}
+
+// end closes the form if it's still the user's open one.
+func (f *forms) end(key formKey, s *formSession) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.open[key] == s {
+		delete(f.open, key)
+	}
+}
+
+// startForm opens a form for the user and returns its first question.
+func (b *Bot) startForm(req *Request, parent int, form *tools.Form) (string, any) {
+	s := &formSession{form: form, request: req.Text, messageID: req.MessageID, parent: parent}
+	b.forms.start(formKey{req.ChatID, req.UserID}, s)
+	log.Printf("[form] %s: asking %s for %d field(s)", form.Tool, req.UserName, len(form.Fields))
+	return formQuestion(s)
+}
+
+// formQuestion renders the current field as a message: choices as buttons,
+// anything else as a forced reply so the answer comes back to the bot. An
+// approval with nothing to approve only offers to cancel.
+func formQuestion(s *formSession) (string, any) {
+	field, _ := s.form.Field()
+	n, total := s.form.Step()
+	text := "📝 " + field.Prompt
+	if total > 1 {
+		text = fmt.Sprintf("📝 (%d/%d) %s", n, total, field.Prompt)
+	}
+
+	if len(field.Choices) == 0 && !field.Approval {
+		hint := "Reply with your answer"
+		if field.Optional {
+			hint += ", \"skip\" to leave it out,"
+		}
+		text += "\n\n" + hint + " or \"cancel\" to stop."
+		return text, tgbotapi.ForceReply{ForceReply: true, Selective: true, InputFieldPlaceholder: truncate(field.Prompt, 60)}
+	}
+
+	data := func(action string) string {
+		return fmt.Sprintf("%s%d:%d:%s", formButtonPrefix, s.id, n, action)
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, choice := range field.Choices {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(truncate(choice, 60), data(strconv.Itoa(i))),
+		))
+	}
+	last := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("✖️ Cancel", data("cancel"))}
+	if field.Optional {
+		last = append([]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("⏭ Skip", data("skip"))}, last...)
+	}
+	rows = append(rows, last)
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// answerForm takes a typed message as the answer to the user's open form.
+func (b *Bot) answerForm(ctx context.Context, req *Request, s *formSession) {
+	send := func(text string, markup any) {
+		msg := tgbotapi.NewMessage(req.ChatID, b.redactor.Redact(text))
+		msg.ReplyToMessageID = req.MessageID
+		if markup != nil {
+			msg.ReplyMarkup = markup
+		}
+		b.out.Send(req.ChatID, msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if open, ok := b.forms.get(formKey{req.ChatID, req.UserID}); !ok || open != s {
+		send("That form was already finished.", nil)
+		return
+	}
+
+	text := strings.TrimSpace(req.Text)
+	switch {
+	case req.Command == "cancel" || strings.EqualFold(text, "cancel"):
+		b.forms.end(formKey{req.ChatID, req.UserID}, s)
+		send("Cancelled.", nil)
+		return
+	case strings.EqualFold(text, "skip"):
+		if err := s.form.Skip(); err != nil {
+			send("⚠️ "+err.Error(), nil)
+			return
+		}
+	default:
+		if err := s.form.Answer(text); err != nil {
+			question, markup := formQuestion(s)
+			send("⚠️ "+err.Error()+"\n\n"+question, markup)
+			return
+		}
+	}
+
+	if _, ok := s.form.Field(); ok {
+		s.asked = time.Now()
+		send(formQuestion(s))
+		return
+	}
+	reply, attachments := b.finishForm(ctx, req, s)
+	send(reply, nil)
+	for _, att := range attachments {
+		b.out.Send(req.ChatID, b.attachmentMessage(req.ChatID, att))
+	}
+}
+
+// formButton handles a press of one of a form question's buttons. The
+// question is edited to show the answer, and the next one is sent below.
+func (b *Bot) formButton(ctx context.Context, req *Request, data string) {
+	answer := func(text string) {
+		b.out.Send(req.ChatID, tgbotapi.NewCallback(req.Button.ID, text))
+	}
+
+	key := formKey{req.ChatID, req.UserID}
+	s, ok := b.forms.get(key)
+	parts := strings.SplitN(data, ":", 3)
+	if !ok || len(parts) != 3 || parts[0] != strconv.Itoa(s.id) {
+		answer("This form is closed or belongs to someone else.")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if open, ok := b.forms.get(key); !ok || open != s {
+		answer("This form is closed.")
+		return
+	}
+	if n, _ := s.form.Step(); parts[1] != strconv.Itoa(n) {
+		answer("That question was already answered.")
+		return
+	}
+
+	field, _ := s.form.Field()
+	var chosen string
+	switch action := parts[2]; action {
+	case "cancel":
+		b.forms.end(key, s)
+		b.editForm(req, "Cancelled.")
+		answer("Cancelled")
+		return
+	case "skip":
+		if err := s.form.Skip(); err != nil {
+			answer(err.Error())
+			return
+		}
+		chosen = "skipped"
+	default:
+		i, err := strconv.Atoi(action)
+		if err != nil || i < 0 || i >= len(field.Choices) {
+			answer("This button no longer works.")
+			return
+		}
+		if err := s.form.Answer(field.Choices[i]); err != nil {
+			answer(err.Error())
+			return
+		}
+		chosen = field.Choices[i]
+	}
+	answer(chosen)
+	b.editForm(req, fmt.Sprintf("📝 %s %s", field.Prompt, chosen))
+
+	var reply string
+	var markup any
+	var attachments []tools.Attachment
+	if _, ok := s.form.Field(); ok {
+		s.asked = time.Now()
+		reply, markup = formQuestion(s)
+	} else {
+		reply, attachments = b.finishForm(ctx, req, s)
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, b.redactor.Redact(reply))
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	b.out.Send(req.ChatID, msg)
+	for _, att := range attachments {
+		b.out.Send(req.ChatID, b.attachmentMessage(req.ChatID, att))
+	}
+}
+
+// editForm replaces a question's text and removes its buttons.
+func (b *Bot) editForm(req *Request, text string) {
+	b.out.Send(req.ChatID, tgbotapi.NewEditMessageText(req.ChatID, req.MessageID, b.redactor.Redact(text)))
+}
+
+// finishForm runs the tool with the completed arguments and records the
+// exchange in the conversation, as if the agent had answered.
+func (b *Bot) finishForm(ctx context.Context, req *Request, s *formSession) (string, []tools.Attachment) {
+	b.forms.end(formKey{req.ChatID, req.UserID}, s)
+
+	release, err := b.waitTurn(ctx, req, priority.Classify(s.request))
+	if err != nil {
+		return "⚠️ The bot is shutting down; please try again shortly.", nil
+	}
+	defer release()
+
+	log.Printf("[form] %s: running for %s", s.form.Tool, req.UserName)
+	done := b.runs.Start(req.ChatID, req.UserName, s.request)
+	var reply string
+	var attachments []tools.Attachment
+	if tool, ok := b.registry.Get(s.form.Tool); !ok {
+		reply = "⚠️ " + s.form.Tool + " is no longer available."
+	} else if result, err := tools.Run(s.form.Context(ctx), tool, s.form.Args); err != nil {
+		reply = "⚠️ " + err.Error()
+	} else {
+		reply, attachments = result.Text, result.Attachments
+	}
+	done()
+
+	b.conversationsFor(ctx).add(req.ChatID, turn{ID: s.messageID, Parent: s.parent, User: s.request, Assistant: reply, Steps: formSteps(s.form, reply)})
+	return reply, attachments
+}
+
+// formSteps records the call a form completed as the turn's tool call, so
+// the model can repeat it with different arguments later. Approvals are
+// left out: a repeat has to be approved again.
+func formSteps(form *tools.Form, result string) []agent.Message {
+	recorded := make(map[string]any, len(form.Args))
+	for k, v := range form.Args {
+		recorded[k] = v
+	}
+	for _, field := range form.Fields {
+		if field.Approval {
+			delete(recorded, field.Name)
+		}
+	}
+	args, err := json.Marshal(recorded)
+	if err != nil {
+		return nil
+	}
+	return []agent.Message{
+		{Role: "assistant", ToolCalls: []agent.ToolCall{{
+			ID:       "form",
+			Type:     "function",
+			Function: agent.FunctionCall{Name: form.Tool, Arguments: args},
+		}}},
+		{Role: "tool", Content: result, ToolCallID: "form"},
+	}
+}
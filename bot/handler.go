@@ -0,0 +1,546 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/agent"
+	"telegram-bot/auth"
+	"telegram-bot/outbox"
+	"telegram-bot/priority"
+	"telegram-bot/quota"
+	"telegram-bot/runs"
+	"telegram-bot/tools"
+)
+
+// Request is an incoming message, independent of the transport it arrived on.
+type Request struct {
+	ChatID    int64
+	MessageID int
+	UserID    int64
+	UserName  string
+	Text      string
+	Command   string // without the leading slash; empty for plain messages
+	Args      string
+	ReplyTo   int // ID of the message this one replies to; 0 if none
+
+	// Location is set when the message is a shared location.
+	Location *tools.Location
+
+	// Document is set when the message is a file; Text is its caption.
+	Document *Document
+
+	// Button is set when the request is a press of an inline keyboard
+	// button rather than a message; MessageID is the message it is on.
+	Button *ButtonPress
+
+	// Poll is set when the request is new vote counts for a poll the bot
+	// posted rather than a message; no other field is set.
+	Poll *tools.PollCounts
+}
+
+// ButtonPress is a press of an inline keyboard button.
+type ButtonPress struct {
+	ID   string // Answered to stop the button's loading spinner
+	Data string
+}
+
+// Transport feeds incoming requests to the bot and delivers its replies.
+type Transport interface {
+	// Send delivers one outgoing message; the outbox calls it with retries.
+	outbox.Sender
+
+	// Run calls handle for each incoming request, from a single goroutine,
+	// until the context is cancelled or input ends. handle returns quickly;
+	// the bot decides whether requests run concurrently.
+	Run(ctx context.Context, handle func(*Request)) error
+}
+
+// handle processes a request and queues the reply.
+func (b *Bot) handle(ctx context.Context, req *Request) {
+	if req.Poll != nil {
+		if b.polls != nil {
+			b.polls.Update(*req.Poll)
+		}
+		return
+	}
+	log.Printf("[%s] %s", req.UserName, req.Text)
+	if b.pairing != nil && b.handleLocked(req) {
+		return
+	}
+
+	user := auth.User{
+		ID:       req.UserID,
+		UserName: req.UserName,
+		Role:     b.roles.RoleFor(req.UserID),
+	}
+	if !b.allowlist.Allows(user.ID, req.ChatID, user.Role) {
+		b.turnAway(req)
+		return
+	}
+	ctx = auth.WithUser(ctx, user)
+	ctx, err := b.withTenant(ctx, req.UserID)
+	if err != nil {
+		log.Printf("[tenant] refusing %s: %v", req.UserName, err)
+		b.tenantUnavailable(req)
+		return
+	}
+	ctx = tools.WithChat(ctx, req.ChatID)
+	ctx = tools.WithRequest(ctx, req.Text)
+
+	if req.Button != nil {
+		b.handleButton(ctx, req)
+		return
+	}
+	if req.Location != nil {
+		b.shareLocation(req)
+		return
+	}
+	if req.Document != nil && isClipCaption(req.Text) {
+		b.clipUpload(ctx, req)
+		return
+	}
+	if req.Document != nil && isContextCaption(req.Text) {
+		b.pinUpload(ctx, req)
+		return
+	}
+	if req.Document != nil {
+		b.saveUpload(ctx, req)
+		return
+	}
+	if loc, ok := b.locations.get(req.UserID); ok {
+		ctx = tools.WithLocation(ctx, loc)
+	}
+
+	// While a form is open, the user's messages answer it
+	if s, ok := b.forms.get(formKey{req.ChatID, req.UserID}); ok && (req.Command == "" || req.Command == "cancel") {
+		b.answerForm(ctx, req, s)
+		return
+	}
+
+	var reply string
+	var attachments []tools.Attachment
+	var markup any          // An inline keyboard or forced reply
+	var stream *replyStream // Set when the answer was shown as it was written
+	var verbatim bool       // The reply is a user's clip, sent as they kept it
+
+	switch req.Command {
+	case "start":
+		if code, ok := strings.CutPrefix(req.Args, clipLinkPrefix); ok {
+			req.Args = code
+			reply, verbatim = b.clipCommand(ctx, req)
+			break
+		}
+		reply = "👋 Hello! I'm an AI assistant powered by " + b.cfg.ChatModel() + ".\n\n" +
+			"I can:\n• Tell you the time\n• Check your Google Calendar\n• Write and execute Python/Bash code\n• Scrape and summarize websites\n• Interact with container registries (OCI)\n\n" +
+			"Use /auth to connect your Google Calendar."
+
+	case "help":
+		reply = "Available commands:\n" +
+			"/start - Start the bot\n" +
+			"/help - Show this help message\n" +
+			"/auth - Connect Google Calendar\n" +
+			"/authcode <code> - Complete Google auth\n" +
+			"/spotify - Connect Spotify\n" +
+			"/spotifycode <address> - Complete Spotify auth\n" +
+			"/save <url> - Save an article to read later\n" +
+			"/repo [url|name] - Clone a repository to ask about its code, or list them\n" +
+			"/new - Start a new conversation\n" +
+			"/summary - Recap this conversation\n" +
+			"/search <query> - Find earlier messages in this chat\n" +
+			"/context [add <text>|remove <n>] - Documents kept in view for every request\n" +
+			"/code [dir|off] - Focused coding session on a workspace project\n" +
+			"/fix <issue url> - Reproduce and fix a GitHub issue, up to a draft PR\n" +
+			"/cancel - Stop filling in a form the bot asked you to\n" +
+			"/shopping - The shared shopping list, with check-off buttons\n" +
+			"/status - Whether the monitored services are up\n" +
+			"/clip [text|code] - Keep text or a file to fetch on another device, or fetch one\n" +
+			"/quiet [22:00-07:00|2h|off] - Hold notifications during quiet hours\n" +
+			"/undo - Revert workspace changes from the last request\n" +
+			"/history [file] - Recent workspace changes, or a file's versions\n" +
+			"/audit [count] - The tools recently run for you\n\n" +
+			"Or just ask me things like:\n" +
+			"• \"What's on my calendar today?\"\n" +
+			"• \"What tools do I have available?\"\n" +
+			"• \"Write a Python script to calculate pi\"\n" +
+			"• \"Summarize https://example.com\""
+
+	case "auth":
+		if b.calendar != nil && !b.registry.Available(ctx, b.calendar.Name()) {
+			reply = "⛔ Your role can't use the calendar."
+			break
+		}
+		if b.calendar == nil {
+			reply = "Google Calendar is not configured."
+			break
+		}
+		authURL, err := b.calendar.Init(ctx)
+		if err != nil {
+			reply = "⚠️ " + err.Error()
+		} else if authURL == "" {
+			reply = "✅ Google Calendar is already connected!"
+		} else {
+			reply = "🔐 To connect Google Calendar:\n\n" +
+				"1. Click this link:\n" + authURL + "\n\n" +
+				"2. Sign in and authorize access\n\n" +
+				"3. Copy the code you receive\n\n" +
+				"4. Send: /authcode YOUR_CODE"
+		}
+
+	case "authcode":
+		code := strings.TrimSpace(req.Args)
+		if b.calendar == nil {
+			reply = "Google Calendar is not configured."
+		} else if !b.registry.Available(ctx, b.calendar.Name()) {
+			reply = "⛔ Your role can't use the calendar."
+		} else if code == "" {
+			reply = "Please provide the authorization code: /authcode YOUR_CODE"
+		} else {
+			if err := b.calendar.CompleteAuth(ctx, code); err != nil {
+				reply = "❌ Authentication failed: " + err.Error()
+			} else {
+				reply = "✅ Google Calendar connected! Try asking \"What's on my calendar?\""
+			}
+		}
+
+	case "spotify":
+		reply = b.spotifyCommand(ctx, user)
+
+	case "spotifycode":
+		reply = b.spotifyCodeCommand(ctx, user, req.Args)
+
+	case "new":
+		b.conversationsFor(ctx).reset(req.ChatID)
+		reply = "🆕 Started a new conversation. Reply to an earlier answer to pick up from there."
+
+	case "undo":
+		if user.Role < auth.Trusted {
+			reply = "⛔ Only trusted users can change the workspace."
+			break
+		}
+		if b.undo == nil {
+			reply = "Workspace undo is not enabled."
+			break
+		}
+		reply = b.undo.undo(ctx, req.ChatID)
+
+	case "history":
+		if user.Role < auth.Trusted {
+			reply = "⛔ Only trusted users can see the workspace history."
+			break
+		}
+		if b.snapshots == nil {
+			reply = "Workspace history is not enabled."
+			break
+		}
+		reply = historyCommand(ctx, b.snapshots, req.Args)
+
+	case "save":
+		url := strings.TrimSpace(req.Args)
+		if url == "" {
+			reply = "Please provide a link: /save https://..."
+			break
+		}
+		release, err := b.waitTurn(ctx, req, priority.Heavy)
+		if err != nil {
+			reply = "⚠️ The bot is shutting down; please try again shortly."
+			break
+		}
+		done := b.runs.Start(req.ChatID, req.UserName, req.Text)
+		reply = b.runTool(ctx, "reading_list", map[string]any{"operation": "save", "url": url})
+		done()
+		release()
+
+	case "repo":
+		release, err := b.waitTurn(ctx, req, priority.Heavy)
+		if err != nil {
+			reply = "⚠️ The bot is shutting down; please try again shortly."
+			break
+		}
+		done := b.runs.Start(req.ChatID, req.UserName, req.Text)
+		reply = b.runTool(ctx, "repo", repoCommandArgs(req.Args))
+		done()
+		release()
+
+	case "debug":
+		if user.Role != auth.Owner {
+			reply = "Unknown command. Try /help"
+			break
+		}
+		reply = debugCommand(req.Args, req.ChatID, b.out, b.runs, b.queue, b.breakers, b.cfg.StateDir)
+
+	case "briefing":
+		if user.Role != auth.Owner {
+			reply = "Unknown command. Try /help"
+			break
+		}
+		text, err := b.briefing(ctx, req.ChatID)
+		if err != nil {
+			log.Printf("Briefing error: %v", err)
+			reply = "Sorry, I couldn't put the briefing together. Please try again in a moment."
+			break
+		}
+		reply = text
+
+	case "bench":
+		if user.Role != auth.Owner {
+			reply = "Unknown command. Try /help"
+			break
+		}
+		reply = runBench(ctx, b.agent, b.registry)
+
+	case "shopping":
+		var keyboard *tgbotapi.InlineKeyboardMarkup
+		if reply, keyboard = b.shoppingCommand(ctx); keyboard != nil {
+			markup = *keyboard
+		}
+
+	case "clip":
+		reply, verbatim = b.clipCommand(ctx, req)
+
+	case "status":
+		reply = b.runTool(ctx, "uptime", map[string]any{"operation": "status"})
+
+	case "cancel":
+		reply = "Nothing to cancel."
+
+	case "quiet":
+		reply = b.quietCommand(req.ChatID, req.Args)
+
+	case "audit":
+		reply = b.auditCommand(ctx, req.Args)
+
+	case "search":
+		reply = b.searchCommand(ctx, req)
+
+	case "context":
+		reply = b.contextCommand(ctx, req)
+
+	case "code":
+		reply = b.codeCommand(ctx, req)
+
+	case "fix":
+		var keyboard *tgbotapi.InlineKeyboardMarkup
+		if reply, keyboard = b.fixCommand(ctx, req); keyboard != nil {
+			markup = *keyboard
+		}
+
+	case "summary":
+		if reply = b.useQuota(ctx); reply != "" {
+			break
+		}
+		release, err := b.waitTurn(ctx, req, priority.Heavy)
+		if err != nil {
+			reply = "⚠️ The bot is shutting down; please try again shortly."
+			break
+		}
+		done := b.runs.Start(req.ChatID, req.UserName, req.Text)
+		reply = b.summaryCommand(ctx, req)
+		done()
+		release()
+
+	case "":
+		// A pasted issue link starts a fix, which asks before doing anything
+		if user.Role == auth.Owner && b.isIssueLink(req.Text) {
+			req.Args = strings.TrimSpace(req.Text)
+			var keyboard *tgbotapi.InlineKeyboardMarkup
+			if reply, keyboard = b.fixCommand(ctx, req); keyboard != nil {
+				markup = *keyboard
+			}
+			break
+		}
+
+		// Not a command, send to agent
+		if reply = b.useQuota(ctx); reply != "" {
+			break
+		}
+
+		// Wait for a turn with the model; quick questions go ahead of long runs
+		release, err := b.waitTurn(ctx, req, priority.Classify(req.Text))
+		if err != nil {
+			reply = "⚠️ The bot is shutting down; please try again shortly."
+			break
+		}
+
+		// Pasted diffs go straight to code review
+		if intro, ok := tools.LooksLikeDiff(req.Text); ok && b.registry.Available(ctx, "review") {
+			reply = b.reviewDiff(ctx, req, map[string]any{"diff": req.Text, "focus": intro})
+			release()
+			break
+		}
+
+		// Continue from the latest exchange, or branch from the one replied to
+		parent, branched := b.conversationsFor(ctx).parent(req.ChatID, req.ReplyTo)
+		if branched {
+			log.Printf("Branching chat %d from message %d", req.ChatID, parent)
+		}
+		// A coding session sees more history and only the coding tools
+		session, coding := b.coding.get(req.ChatID)
+		historyTurns := b.cfg.HistoryTurns
+		if coding {
+			historyTurns = codingHistoryTurns
+		}
+		agentCtx := agent.WithHistory(ctx, b.conversationsFor(ctx).history(req.ChatID, parent, historyTurns))
+		var stamp projectStamp
+		if coding {
+			agentCtx = codingContext(agentCtx, session, b.pinned.documents(req.ChatID))
+			stamp = b.projectStamp(ctx, session)
+		} else {
+			agentCtx = agent.WithPinned(agentCtx, b.pinned.documents(req.ChatID))
+		}
+
+		// Snapshot the workspace around the run; tools may change files even if it fails
+		var before string
+		if b.undo != nil {
+			before = b.undo.begin(ctx, req.ChatID, req.Text)
+		}
+
+		// Long answers appear as they're written, edited in place
+		if b.cfg.StreamEditTokens > 0 && !b.cliMode {
+			stream = b.newReplyStream(req)
+			agentCtx = agent.WithStream(agentCtx, stream.update)
+		}
+
+		// The watchdog cancels runs that go on too long
+		runCtx, done := b.runs.Watch(agentCtx, req.ChatID, req.MessageID, req.UserName, req.Text)
+		response, err := b.agent.Respond(runCtx, req.Text)
+		stopped := errors.Is(context.Cause(runCtx), runs.ErrOverBudget)
+		done()
+
+		var edit *undoRecord
+		if b.undo != nil {
+			edit = b.undo.finish(ctx, req.ChatID, before, req.Text)
+		}
+		var tests string
+		if coding && !stopped && err == nil && response.Form == nil && b.projectStamp(ctx, session) != stamp {
+			tests = b.runProjectTests(ctx, session)
+		}
+		release()
+		if stopped {
+			// The watchdog has already told the user how far it got
+			if stream != nil {
+				stream.settle()
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("Agent error: %v", err)
+			reply = "Sorry, I couldn't process that. Please try again in a moment."
+		} else if response.Form != nil {
+			// A tool needs details only the user can give; ask for them
+			reply, markup = b.startForm(req, parent, response.Form)
+			attachments = response.Attachments
+		} else {
+			reply = response.Text
+			attachments = response.Attachments
+			b.conversationsFor(ctx).add(req.ChatID, turn{ID: req.MessageID, Parent: parent, User: req.Text, Assistant: reply, Steps: response.Steps})
+
+			// Edits to existing files are shown as a diff that can be reverted
+			if edit != nil {
+				var keyboard *tgbotapi.InlineKeyboardMarkup
+				if reply, keyboard = b.undo.editReply(ctx, b.cfg.PythonWorkspace, edit, reply); keyboard != nil {
+					markup = *keyboard
+				}
+			}
+			if tests != "" {
+				reply = strings.TrimSpace(reply) + "\n\n" + tests
+			}
+		}
+
+	default:
+		reply = "Unknown command. Try /help"
+	}
+
+	if !verbatim {
+		reply = b.redactor.Redact(reply)
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, reply)
+	msg.ReplyToMessageID = req.MessageID
+	if formatted, ok := formatCode(msg.Text); ok {
+		msg.Text, msg.ParseMode = formatted, tgbotapi.ModeHTML
+	}
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+
+	if stream == nil || !stream.finish(msg) {
+		b.out.Send(req.ChatID, msg)
+	}
+
+	for _, att := range attachments {
+		b.out.Send(req.ChatID, b.attachmentMessage(req.ChatID, att))
+	}
+}
+
+// turnAway answers a user the allowlist doesn't let in. They're told so in
+// a private chat, where their user ID is the chat's; groups aren't
+// answered at all, so the bot doesn't talk over strangers.
+func (b *Bot) turnAway(req *Request) {
+	log.Printf("[auth] not allowed: ignoring %s (%d) in chat %d", req.UserName, req.UserID, req.ChatID)
+	if req.Button != nil || req.ChatID != req.UserID {
+		return
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, fmt.Sprintf("⛔ This is a private bot. If you know its owner, send them your user ID: %d", req.UserID))
+	msg.ReplyToMessageID = req.MessageID
+	b.out.Send(req.ChatID, msg)
+}
+
+// useQuota counts a model request against the user's daily quota. If the
+// quota is used up it returns the reply explaining why instead.
+func (b *Bot) useQuota(ctx context.Context) string {
+	if err := b.quota.Check(ctx); err != nil {
+		var exhausted *quota.ExhaustedError
+		if errors.As(err, &exhausted) {
+			return exhausted.Message()
+		}
+		return "⚠️ " + err.Error()
+	}
+	b.quota.AddRequest(ctx)
+	return ""
+}
+
+// waitTurn waits for one of the bot's run slots and returns the function
+// that frees it. It fails only if the bot is shutting down.
+func (b *Bot) waitTurn(ctx context.Context, req *Request, class priority.Class) (release func(), err error) {
+	start := time.Now()
+	release, err = b.queue.Acquire(ctx, req.UserID, class)
+	if waited := time.Since(start); waited >= time.Second {
+		log.Printf("[queue] %s request from %s waited %v", class, req.UserName, waited.Round(time.Second))
+	}
+	return release, err
+}
+
+// runTool executes a tool on behalf of a command, through the registry's
+// permission and quota checks, and returns its output or error as the reply.
+func (b *Bot) runTool(ctx context.Context, name string, args map[string]any) string {
+	tool, ok := b.registry.Get(name)
+	if !ok {
+		return "Unknown command. Try /help"
+	}
+	result, err := tools.Run(ctx, tool, args)
+	if err != nil {
+		return "⚠️ " + err.Error()
+	}
+	return result.Text
+}
+
+// attachmentMessage builds a photo or document upload for a tool attachment.
+// Text files pass through the redactor like any other reply.
+func (b *Bot) attachmentMessage(chatID int64, att tools.Attachment) tgbotapi.Chattable {
+	if att.Kind == tools.AttachPhoto {
+		return tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: att.Name, Bytes: att.Data})
+	}
+
+	data := att.Data
+	if utf8.Valid(data) {
+		data = []byte(b.redactor.Redact(string(data)))
+	}
+	return tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: att.Name, Bytes: data})
+}
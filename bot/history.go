@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"telegram-bot/snapshot"
+)
+
+const (
+	historyEntries = 15
+	historyFiles   = 3 // Files named per entry; the rest are counted
+)
+
+// historyCommand handles /history, which lists recent workspace snapshots
+// or the versions of one file, and restores a file from a snapshot.
+func historyCommand(ctx context.Context, repo *snapshot.Repo, args string) string {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	fields := strings.Fields(args)
+	if len(fields) > 0 && fields[0] == "restore" {
+		if len(fields) != 3 {
+			return "Usage: /history restore <id> <file>"
+		}
+		id, path := fields[1], fields[2]
+		if err := repo.RestoreFile(ctx, id, path, fmt.Sprintf("Restore %s from %s", path, id)); err != nil {
+			return "⚠️ " + err.Error()
+		}
+		return fmt.Sprintf("⏪ Restored %s to its version in %s. The version it replaced is still in /history.", path, id)
+	}
+
+	path := strings.TrimSpace(args)
+	entries, err := repo.Log(ctx, path, historyEntries)
+	if err != nil {
+		return "⚠️ " + err.Error()
+	}
+	if len(entries) == 0 {
+		if path != "" {
+			return fmt.Sprintf("No recorded changes to %s.", path)
+		}
+		return "No workspace changes recorded yet."
+	}
+
+	var b strings.Builder
+	if path != "" {
+		fmt.Fprintf(&b, "🕘 Versions of %s:\n", path)
+	} else {
+		b.WriteString("🕘 Recent workspace changes:\n")
+	}
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\n%s  %s  %s", e.ID, e.Time.Format("Jan 2 15:04"), e.Message)
+		if path == "" {
+			files := e.Files
+			more := ""
+			if len(files) > historyFiles {
+				more = fmt.Sprintf(" +%d more", len(files)-historyFiles)
+				files = files[:historyFiles]
+			}
+			fmt.Fprintf(&b, "\n    %s%s", strings.Join(files, ", "), more)
+		}
+	}
+	b.WriteString("\n\nRestore a file with /history restore <id> <file>")
+	if path == "" {
+		b.WriteString(", or list its versions with /history <file>")
+	}
+	return b.String()
+}
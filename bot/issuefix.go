@@ -0,0 +1,650 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/agent"
+	"telegram-bot/auth"
+	"telegram-bot/priority"
+	"telegram-bot/runs"
+	"telegram-bot/store"
+	"telegram-bot/tenant"
+	"telegram-bot/tools"
+)
+
+const (
+	fixButtonPrefix    = "fix:"
+	fixCommandPrefix   = "fixcmd:"
+	fixStoreKey        = "issue_fixes"
+	maxIssuePreview    = 600 // Chars of the issue's description shown before starting
+	maxFixReplyChars   = 1500
+	maxFixCommandChars = 3000 // Of a command shown for approval; longer ones are refused
+	fixApprovalTimeout = 15 * time.Minute
+)
+
+// fixRunTools are the tools a fix stage's commands go through, each shown
+// to the owner before it runs.
+var fixRunTools = map[string]bool{"python": true, "bash": true}
+
+// fixStage is how far an issue fix has got; each stage waits for the
+// owner's approval before the next runs.
+type fixStage string
+
+const (
+	fixProposed   fixStage = "proposed"   // The issue is fetched; nothing is cloned yet
+	fixReproduced fixStage = "reproduced" // Cloned, and the model has tried to reproduce it
+	fixDrafted    fixStage = "drafted"    // A fix with tests is in the clone, not yet pushed
+)
+
+// issueFix is a chat's /fix workflow, from a GitHub issue to a draft pull
+// request.
+type issueFix struct {
+	ID           int64       `json:"id"` // Ties buttons to this fix
+	Issue        tools.Issue `json:"issue"`
+	Project      string      `json:"project"` // The clone, relative to the workspace
+	Stage        fixStage    `json:"stage"`
+	Reproduction string      `json:"reproduction,omitempty"`
+	Summary      string      `json:"summary,omitempty"` // The model's account of the fix, for the pull request
+	Tests        string      `json:"tests,omitempty"`
+	Started      time.Time   `json:"started"`
+	busy         bool        // A stage is running
+}
+
+func (f *issueFix) ref() string {
+	return fmt.Sprintf("%s/%s#%d", f.Issue.Owner, f.Issue.Repo, f.Issue.Number)
+}
+
+func (f *issueFix) branch() string {
+	return fmt.Sprintf("fix/issue-%d", f.Issue.Number)
+}
+
+// issueFixes keeps each chat's fix in progress.
+type issueFixes struct {
+	mu    sync.Mutex
+	store *store.Store
+	chats map[int64]*issueFix
+}
+
+func newIssueFixes(st *store.Store) *issueFixes {
+	f := &issueFixes{store: st, chats: make(map[int64]*issueFix)}
+	if _, err := st.Get(fixStoreKey, &f.chats); err != nil {
+		log.Printf("Loading issue fixes: %v", err)
+	}
+	if f.chats == nil {
+		f.chats = make(map[int64]*issueFix)
+	}
+	return f
+}
+
+func (f *issueFixes) get(chatID int64) (issueFix, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fix, ok := f.chats[chatID]
+	if !ok {
+		return issueFix{}, false
+	}
+	return *fix, true
+}
+
+func (f *issueFixes) set(chatID int64, fix issueFix) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chats[chatID] = &fix
+	f.save()
+}
+
+func (f *issueFixes) end(chatID int64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fix, ok := f.chats[chatID]; !ok || fix.busy {
+		return false
+	}
+	delete(f.chats, chatID)
+	f.save()
+	return true
+}
+
+// claim marks the chat's fix busy for a stage, if it's the one a button
+// belongs to and the stage can run from where it is. Otherwise it returns
+// why not, to answer the button with.
+func (f *issueFixes) claim(chatID, id int64, from ...fixStage) (issueFix, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fix, ok := f.chats[chatID]
+	switch {
+	case !ok || fix.ID != id:
+		return issueFix{}, "This fix is closed."
+	case fix.busy:
+		return issueFix{}, "Still working on the last step…"
+	}
+	for _, stage := range from {
+		if fix.Stage == stage {
+			fix.busy = true
+			return *fix, ""
+		}
+	}
+	return issueFix{}, "That step was already done."
+}
+
+// release ends a stage, storing its outcome if it succeeded.
+func (f *issueFixes) release(chatID int64, fix issueFix, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, open := f.chats[chatID]
+	if !open || current.ID != fix.ID {
+		return
+	}
+	if !ok {
+		current.busy = false
+		return
+	}
+	fix.busy = false
+	f.chats[chatID] = &fix
+	f.save()
+}
+
+// save stores the fixes. The caller must hold f.mu.
+func (f *issueFixes) save() {
+	if err := f.store.Set(fixStoreKey, f.chats); err != nil {
+		log.Printf("Saving issue fixes: %v", err)
+	}
+}
+
+// isIssueLink reports whether a message is nothing but a link to a GitHub
+// issue, which starts a fix as /fix would.
+func (b *Bot) isIssueLink(text string) bool {
+	text = strings.TrimSpace(text)
+	if b.github == nil || !strings.HasPrefix(text, "https://") || strings.ContainsAny(text, " \n") {
+		return false
+	}
+	_, _, _, ok := b.github.ParseIssue(text)
+	return ok
+}
+
+// fixCommand handles /fix, which works a GitHub issue through to a draft
+// pull request, asking before each stage:
+//
+//	/fix <issue url>   fetch the issue and offer to reproduce it
+//	/fix               show the fix in progress
+//	/fix cancel        drop it, leaving the clone as it is
+func (b *Bot) fixCommand(ctx context.Context, req *Request) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if b.github == nil {
+		return "Issue fixes need a GitHub token (GITHUB_TOKEN).", nil
+	}
+	if auth.RoleFrom(ctx) < auth.Owner {
+		return "⛔ Only the bot owner can push fixes to GitHub.", nil
+	}
+	if b.cfg.PythonWorkspace == "" {
+		return "Issue fixes need a workspace (PYTHON_WORKSPACE).", nil
+	}
+	if b.cfg.Isolation == "" && b.cfg.ContainerRuntime == "" {
+		return "Issue fixes run code from someone else's repository, so they need ISOLATION or CONTAINER_RUNTIME to confine it.", nil
+	}
+
+	arg := strings.TrimSpace(req.Args)
+	current, open := b.fixes.get(req.ChatID)
+	switch {
+	case strings.EqualFold(arg, "cancel"):
+		if !open {
+			return "There's no fix in progress in this chat.", nil
+		}
+		if !b.fixes.end(req.ChatID) {
+			return "Wait for the current step to finish, then cancel.", nil
+		}
+		return fmt.Sprintf("✖️ Stopped working on %s. Its clone is left in %s/.", current.ref(), current.Project), nil
+	case arg == "" && open:
+		return fixStatus(current), fixKeyboard(current)
+	case arg == "":
+		return "Usage: /fix <GitHub issue URL>, or paste the link on its own", nil
+	}
+	if open && current.busy {
+		return fmt.Sprintf("Still working on %s; /fix cancel once this step finishes to start another.", current.ref()), nil
+	}
+
+	issue, err := b.github.Issue(ctx, arg)
+	if err != nil {
+		return "⚠️ " + err.Error(), nil
+	}
+	fix := issueFix{
+		ID:      time.Now().UnixNano(),
+		Issue:   *issue,
+		Project: tools.RepoFolder(issue.RepoURL()),
+		Stage:   fixProposed,
+		Started: time.Now().UTC(),
+	}
+	b.fixes.set(req.ChatID, fix)
+
+	body := strings.TrimSpace(issue.Body)
+	if len(body) > maxIssuePreview {
+		body = strings.ToValidUTF8(body[:maxIssuePreview], "") + "…"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🐛 %s: %s (%s)\n", fix.ref(), issue.Title, issue.State)
+	if body != "" {
+		sb.WriteString("\n" + body + "\n")
+	}
+	fmt.Fprintf(&sb, "\nNext: clone %s into %s/ and try to reproduce the problem, without changing anything.", issue.RepoURL(), fix.Project)
+	return sb.String(), fixKeyboard(fix)
+}
+
+func fixStatus(fix issueFix) string {
+	var next string
+	switch fix.Stage {
+	case fixProposed:
+		next = "clone the repository and reproduce the problem"
+	case fixReproduced:
+		next = "draft a fix with tests"
+	case fixDrafted:
+		next = fmt.Sprintf("push %s and open a draft pull request", fix.branch())
+	}
+	return fmt.Sprintf("🐛 Working on %s: %s\nStarted %s, in %s/.\n\nNext: %s. /fix cancel stops.",
+		fix.ref(), fix.Issue.Title, fix.Started.Local().Format("Jan 2 15:04"), fix.Project, next)
+}
+
+// fixKeyboard offers the next stage of a fix.
+func fixKeyboard(fix issueFix) *tgbotapi.InlineKeyboardMarkup {
+	button := func(label, action string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(label, fixButtonPrefix+strconv.FormatInt(fix.ID, 10)+":"+action)
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	switch fix.Stage {
+	case fixProposed:
+		row = append(row, button("🔬 Clone and reproduce", "reproduce"))
+	case fixReproduced:
+		row = append(row, button("🛠 Draft a fix", "draft"))
+	case fixDrafted:
+		row = append(row, button("🚀 Push and open draft PR", "push"), button("🔁 Redraft", "draft"))
+	}
+	row = append(row, button("✖️ Cancel", "cancel"))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &keyboard
+}
+
+// fixButton handles the approval buttons between the stages of a fix. The
+// pressed buttons are removed, the stage runs, and its outcome is posted
+// with the buttons for the next.
+func (b *Bot) fixButton(ctx context.Context, req *Request, data string) {
+	answer := func(text string) {
+		b.out.Send(req.ChatID, tgbotapi.NewCallback(req.Button.ID, text))
+	}
+	idText, action, _ := strings.Cut(data, ":")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil || b.github == nil {
+		answer("This button no longer works.")
+		return
+	}
+	if auth.RoleFrom(ctx) < auth.Owner {
+		answer("⛔ Only the bot owner can push fixes to GitHub.")
+		return
+	}
+
+	if action == "cancel" {
+		fix, ok := b.fixes.get(req.ChatID)
+		if !ok || fix.ID != id {
+			answer("This fix is closed.")
+			return
+		}
+		if !b.fixes.end(req.ChatID) {
+			answer("Wait for the current step to finish.")
+			return
+		}
+		answer("Cancelled")
+		b.removeButtons(req)
+		b.sendFix(req, fmt.Sprintf("✖️ Stopped working on %s. Its clone is left in %s/.", fix.ref(), fix.Project), nil)
+		return
+	}
+
+	stages := map[string][]fixStage{
+		"reproduce": {fixProposed},
+		"draft":     {fixReproduced, fixDrafted},
+		"push":      {fixDrafted},
+	}
+	from, ok := stages[action]
+	if !ok {
+		answer("This button no longer works.")
+		return
+	}
+	fix, refused := b.fixes.claim(req.ChatID, id, from...)
+	if refused != "" {
+		answer(refused)
+		return
+	}
+	if reply := b.useQuota(ctx); reply != "" {
+		b.fixes.release(req.ChatID, fix, false)
+		answer(reply)
+		return
+	}
+	answer(map[string]string{"reproduce": "Reproducing…", "draft": "Drafting a fix…", "push": "Pushing…"}[action])
+	b.removeButtons(req)
+
+	release, err := b.waitTurn(ctx, req, priority.Heavy)
+	if err != nil {
+		b.fixes.release(req.ChatID, fix, false)
+		b.sendFix(req, "⚠️ The bot is shutting down; please try again shortly.", nil)
+		return
+	}
+	var reply string
+	var next bool
+	switch action {
+	case "reproduce":
+		reply, next = b.reproduceIssue(ctx, req, &fix)
+	case "draft":
+		reply, next = b.draftFix(ctx, req, &fix)
+	case "push":
+		reply, next = b.pushFix(ctx, &fix)
+	}
+	release()
+
+	b.fixes.release(req.ChatID, fix, next)
+	if action == "push" && next {
+		b.fixes.end(req.ChatID)
+		b.sendFix(req, reply, nil)
+		return
+	}
+	current, _ := b.fixes.get(req.ChatID)
+	b.sendFix(req, reply, fixKeyboard(current))
+}
+
+// reproduceIssue clones the issue's repository and has the model try to
+// reproduce the problem there without fixing it.
+func (b *Bot) reproduceIssue(ctx context.Context, req *Request, fix *issueFix) (string, bool) {
+	done := b.runs.Start(req.ChatID, req.UserName, "clone "+fix.Issue.RepoURL())
+	cloned := b.runTool(ctx, "repo", map[string]any{"operation": "clone", "url": fix.Issue.RepoURL()})
+	done()
+	if strings.HasPrefix(cloned, "⚠️") {
+		return cloned, false
+	}
+	if _, err := b.projectDir(ctx, fix.Project); err != nil {
+		return "⚠️ " + err.Error(), false
+	}
+
+	response, ok := b.fixRun(ctx, req, fix, "reproduce", "Reproduce the problem described in the GitHub issue, in this project. "+
+		"Read the code involved, then write a small script or a failing test that shows the problem, and run it. "+
+		"Don't fix it yet. Reply with what you ran, what happened, and whether it matches the issue; "+
+		"say plainly if you couldn't reproduce it.")
+	if !ok {
+		return response, false
+	}
+	fix.Stage, fix.Reproduction = fixReproduced, response
+	return fmt.Sprintf("🔬 %s\n\n%s\n\nNext: draft a fix with tests.", fix.ref(), shortenReply(response)), true
+}
+
+// draftFix has the model fix the issue with tests, runs the project's
+// tests, and shows the changes.
+func (b *Bot) draftFix(ctx context.Context, req *Request, fix *issueFix) (string, bool) {
+	prompt := "Fix the problem described in the GitHub issue. Keep the change small and in the project's style, " +
+		"and add or update tests that fail without the fix and pass with it. " +
+		"Delete any throwaway scripts you wrote to reproduce it that aren't tests. " +
+		"Reply with a short summary of the cause and the fix, written for the pull request's description."
+	if fix.Stage == fixDrafted {
+		prompt = "Your earlier fix is in the project; review it and its tests, and improve it. " + prompt
+	}
+	if fix.Reproduction != "" {
+		prompt += "\n\nWhat you found reproducing it:\n" + fix.Reproduction
+	}
+	response, ok := b.fixRun(ctx, req, fix, "fix", prompt)
+	if !ok {
+		return response, false
+	}
+
+	// The tests, and git reading the clone's config, run the repository's code
+	untrusted := tools.WithUntrustedCode(ctx)
+	done := b.runs.Start(req.ChatID, req.UserName, "test "+fix.Project)
+	tests := b.runProjectTests(untrusted, codingSession{Project: fix.Project})
+	diff := b.runTool(untrusted, "bash", map[string]any{
+		"command": "git add --intent-to-add --all && git --no-pager diff --no-color --no-ext-diff",
+		"cwd":     fix.Project,
+	})
+	done()
+	if strings.Contains(diff, "\n\nExit code: ") || strings.HasPrefix(diff, "⚠️") {
+		log.Printf("Diffing %s: %s", fix.Project, diff)
+		diff = ""
+	}
+	if diff = strings.TrimSpace(strings.TrimPrefix(diff, "(no output)")); diff == "" {
+		return fmt.Sprintf("🛠 %s\n\n%s\n\nNo files were changed; redraft or cancel.", fix.ref(), shortenReply(response)), false
+	}
+
+	fix.Stage, fix.Summary, fix.Tests = fixDrafted, response, tests
+	reply := fmt.Sprintf("🛠 %s\n\n%s\n\n```diff\n%s\n```", fix.ref(), shortenReply(response), shortenDiff(diff))
+	if tests != "" {
+		reply += "\n\n" + tests
+	}
+	return reply + fmt.Sprintf("\n\nNext: push %s and open a draft pull request.", fix.branch()), true
+}
+
+// pushFix commits the fix to a branch, pushes it, and opens a draft pull
+// request for it.
+func (b *Bot) pushFix(ctx context.Context, fix *issueFix) (string, bool) {
+	dir := filepath.Join(tenant.Workspace(ctx, b.cfg.PythonWorkspace), fix.Project)
+	title := fmt.Sprintf("Fix #%d: %s", fix.Issue.Number, fix.Issue.Title)
+	head, err := b.github.Push(ctx, dir, fix.branch(), title+"\n\nFixes "+fix.Issue.URL)
+	if err != nil {
+		return "⚠️ " + err.Error(), false
+	}
+
+	body := fmt.Sprintf("Fixes %s\n\n%s", fix.Issue.URL, strings.TrimSpace(fix.Summary))
+	if fix.Tests != "" {
+		body += "\n\n" + fix.Tests
+	}
+	number, link, err := b.github.CreatePullRequest(ctx, tools.PullRequest{
+		Owner: fix.Issue.Owner, Repo: fix.Issue.Repo, Head: head,
+		Title: title, Body: body, Draft: true,
+	})
+	if err != nil {
+		return fmt.Sprintf("⚠️ Pushed %s, but %v", head, err), false
+	}
+	return fmt.Sprintf("🚀 Opened draft pull request #%d for %s:\n%s", number, fix.ref(), link), true
+}
+
+// fixRun runs the model on one stage of a fix, in a coding session on the
+// clone with the issue in view. Anyone can write an issue, so the model
+// may be following its instructions rather than the owner's: it runs as a
+// trusted user rather than an owner, its commands have no credentials and
+// no network, and each one waits for the owner's approval.
+func (b *Bot) fixRun(ctx context.Context, req *Request, fix *issueFix, step, prompt string) (string, bool) {
+	issue := agent.Document{Name: "GitHub issue " + fix.ref(), Text: fix.Issue.String()}
+	sandbox := agent.Document{
+		Name: "Fix sandbox",
+		Text: "Commands run offline and without credentials, and the owner approves each one before it runs, so keep them few and focused. " +
+			"Run shell commands such as git through python's subprocess module.",
+	}
+	agentCtx := codingContext(ctx, codingSession{Project: fix.Project}, []agent.Document{issue, sandbox})
+	if user, ok := auth.UserFrom(ctx); ok {
+		user.Role = min(user.Role, auth.Trusted)
+		agentCtx = auth.WithUser(agentCtx, user)
+	}
+	agentCtx = tools.WithUntrustedCode(agentCtx)
+	agentCtx = context.WithValue(agentCtx, fixGateKey{}, req)
+
+	runCtx, done := b.runs.Watch(agentCtx, req.ChatID, req.MessageID, req.UserName, step+" "+fix.ref())
+	response, err := b.agent.Respond(runCtx, prompt)
+	stopped := errors.Is(context.Cause(runCtx), runs.ErrOverBudget)
+	done()
+	switch {
+	case stopped:
+		return "⏱ That step ran too long and was stopped; try it again or /fix cancel.", false
+	case err != nil:
+		log.Printf("Agent error fixing %s: %v", fix.ref(), err)
+		return "⚠️ The model couldn't finish that step; try it again or /fix cancel.", false
+	case response.Form != nil:
+		return "⚠️ The model asked for details a fix can't give; try the step again.", false
+	}
+	return strings.TrimSpace(response.Text), true
+}
+
+type fixGateKey struct{}
+
+// fixApprovals are the commands of fix stages waiting for the owner, by
+// the ID their buttons carry.
+type fixApprovals struct {
+	mu      sync.Mutex
+	nextID  int64
+	waiting map[int64]chan bool
+}
+
+func (a *fixApprovals) open() (int64, chan bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.waiting == nil {
+		a.waiting = make(map[int64]chan bool)
+	}
+	a.nextID++
+	answer := make(chan bool, 1)
+	a.waiting[a.nextID] = answer
+	return a.nextID, answer
+}
+
+func (a *fixApprovals) close(id int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.waiting, id)
+}
+
+// answer passes the owner's decision to the waiting command, reporting
+// whether one was still waiting.
+func (a *fixApprovals) answer(id int64, run bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	answer, ok := a.waiting[id]
+	if !ok {
+		return false
+	}
+	delete(a.waiting, id)
+	answer <- run
+	return true
+}
+
+// gater is implemented by agents whose tool calls can be held back.
+type gater interface {
+	OnBeforeToolCall(fn func(ctx context.Context, call *agent.ToolInvocation) error)
+}
+
+// gateFixCommands makes the commands of fix stages wait for the owner.
+func (b *Bot) gateFixCommands() {
+	if g, ok := b.agent.(gater); ok {
+		g.OnBeforeToolCall(b.approveFixCommand)
+	}
+}
+
+// approveFixCommand shows the owner a command a fix stage wants to run and
+// waits for them to run or refuse it. A refusal is the tool's result, so
+// the model can try another way.
+func (b *Bot) approveFixCommand(ctx context.Context, call *agent.ToolInvocation) error {
+	req, ok := ctx.Value(fixGateKey{}).(*Request)
+	if !ok || !fixRunTools[call.Name] {
+		return nil
+	}
+	shown := fixCommandText(call)
+	if len(shown) > maxFixCommandChars {
+		return fmt.Errorf("that is too long to show the owner for approval; split it into smaller steps")
+	}
+
+	id, answer := b.fixApprovals.open()
+	defer b.fixApprovals.close(id)
+	button := func(label, action string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("%s%d:%s", fixCommandPrefix, id, action))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(button("▶️ Run", "run"), button("⛔ Refuse", "refuse")))
+	b.sendFix(req, fmt.Sprintf("🔎 The fix wants to run (%s):\n\n```\n%s\n```", call.Name, shown), &keyboard)
+
+	select {
+	case run := <-answer:
+		if !run {
+			return fmt.Errorf("the owner refused to run this; try another way, or explain in your reply what you needed it for")
+		}
+		return nil
+	case <-time.After(fixApprovalTimeout):
+		return fmt.Errorf("the owner didn't approve this in time")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fixCommandText is what the owner is shown of a command: a bash command
+// as it is, and for python its code after its other arguments.
+func fixCommandText(call *agent.ToolInvocation) string {
+	if command, ok := call.Args["command"].(string); ok && call.Name == "bash" {
+		if cwd, ok := call.Args["cwd"].(string); ok && cwd != "" {
+			return fmt.Sprintf("cd %s && %s", cwd, command)
+		}
+		return command
+	}
+	var sb strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(call.Args)) {
+		if name == "code" {
+			continue
+		}
+		value, _ := json.Marshal(call.Args[name])
+		fmt.Fprintf(&sb, "# %s: %s\n", name, value)
+	}
+	if code, ok := call.Args["code"].(string); ok {
+		sb.WriteString(code)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// fixCommandButton passes on the owner's answer to a command a fix stage
+// is waiting to run.
+func (b *Bot) fixCommandButton(ctx context.Context, req *Request, data string) {
+	answer := func(text string) {
+		b.out.Send(req.ChatID, tgbotapi.NewCallback(req.Button.ID, text))
+	}
+	if auth.RoleFrom(ctx) < auth.Owner {
+		answer("⛔ Only the bot owner can approve a fix's commands.")
+		return
+	}
+	idText, action, _ := strings.Cut(data, ":")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil || (action != "run" && action != "refuse") {
+		answer("This button no longer works.")
+		return
+	}
+	if !b.fixApprovals.answer(id, action == "run") {
+		answer("This command is no longer waiting.")
+		b.removeButtons(req)
+		return
+	}
+	answer(map[string]string{"run": "Running", "refuse": "Refused"}[action])
+	b.removeButtons(req)
+}
+
+// shortenReply cuts a long model reply shown between stages.
+func shortenReply(s string) string {
+	if len(s) <= maxFixReplyChars {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxFixReplyChars], "") + "…"
+}
+
+// removeButtons takes the buttons off the message a button was pressed on,
+// so a stage can't be approved twice.
+func (b *Bot) removeButtons(req *Request) {
+	empty := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	b.out.Send(req.ChatID, tgbotapi.NewEditMessageReplyMarkup(req.ChatID, req.MessageID, empty))
+}
+
+// sendFix posts the outcome of a stage, with buttons for the next.
+func (b *Bot) sendFix(req *Request, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	msg := tgbotapi.NewMessage(req.ChatID, b.redactor.Redact(text))
+	msg.ReplyToMessageID = req.MessageID
+	if formatted, ok := formatCode(msg.Text); ok {
+		msg.Text, msg.ParseMode = formatted, tgbotapi.ModeHTML
+	}
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+	b.out.Send(req.ChatID, msg)
+}
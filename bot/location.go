@@ -0,0 +1,62 @@
+package bot
+
+import (
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/tools"
+)
+
+// locationTTL is how long a shared location is used for nearby searches.
+// After that the user has probably moved on.
+const locationTTL = 12 * time.Hour
+
+type sharedLocation struct {
+	loc    tools.Location
+	shared time.Time
+}
+
+// locations remembers the last location each user shared. It is kept in
+// memory only, so a restart forgets where everyone was.
+type locations struct {
+	mu    sync.Mutex
+	users map[int64]sharedLocation
+}
+
+func newLocations() *locations {
+	return &locations{users: make(map[int64]sharedLocation)}
+}
+
+func (l *locations) set(userID int64, loc tools.Location) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.users[userID] = sharedLocation{loc: loc, shared: time.Now()}
+}
+
+// get returns the user's location if they shared one recently.
+func (l *locations) get(userID int64) (tools.Location, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.users[userID]
+	if !ok {
+		return tools.Location{}, false
+	}
+	if time.Since(s.shared) > locationTTL {
+		delete(l.users, userID)
+		return tools.Location{}, false
+	}
+	return s.loc, true
+}
+
+// shareLocation records a location the user sent, for tools that search
+// nearby, and confirms it.
+func (b *Bot) shareLocation(req *Request) {
+	b.locations.set(req.UserID, *req.Location)
+
+	msg := tgbotapi.NewMessage(req.ChatID, "📍 Got your location. I'll use it for the next 12 hours when you ask what's nearby, like \"coffee shops open now near me\".")
+	msg.ReplyToMessageID = req.MessageID
+	b.out.Send(req.ChatID, msg)
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/store"
+)
+
+const pairingStoreKey = "paired_owners"
+
+// pairing makes the first user to open a one-time deep link the owner of a
+// bot deployed without OWNER_USER_IDS. Until then the bot is locked: it
+// turns everyone away, so a freshly deployed bot isn't open to whoever finds it.
+type pairing struct {
+	mu     sync.Mutex
+	store  *store.Store
+	owners []int64 // Paired owners, kept across restarts
+	token  string  // The link's token while locked; empty once paired
+}
+
+// newPairing loads the owners paired before. If there are none and no
+// owners are configured, it locks the bot behind a new token.
+func newPairing(st *store.Store, configured bool) *pairing {
+	p := &pairing{store: st}
+	if _, err := st.Get(pairingStoreKey, &p.owners); err != nil {
+		log.Printf("Error loading paired owners: %v", err)
+	}
+	if !configured && len(p.owners) == 0 {
+		b := make([]byte, 16)
+		rand.Read(b)
+		p.token = hex.EncodeToString(b)
+	}
+	return p
+}
+
+// locked reports whether the bot is waiting for its owner to pair.
+func (p *pairing) locked() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.token != ""
+}
+
+// pairedOwners returns the owners who paired, first to pair first.
+func (p *pairing) pairedOwners() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int64(nil), p.owners...)
+}
+
+// claim makes the user the owner if token is the link's, unlocking the
+// bot. The token works once.
+func (p *pairing) claim(userID int64, token string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) != 1 {
+		return false
+	}
+	p.token = ""
+	p.owners = append(p.owners, userID)
+	if err := p.store.Save(pairingStoreKey, p.owners); err != nil {
+		log.Printf("Error saving paired owners: %v", err)
+	}
+	return true
+}
+
+// link returns the deep link that pairs the bot, for the console.
+func (p *pairing) link(botName string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if botName == "" {
+		return "/start " + p.token
+	}
+	return "https://t.me/" + botName + "?start=" + p.token
+}
+
+// handleLocked answers requests while the bot is locked, pairing the user
+// who sends the link's /start. It reports whether it handled the request.
+func (b *Bot) handleLocked(req *Request) bool {
+	if !b.pairing.locked() {
+		return false
+	}
+	var reply string
+	switch {
+	case req.Command == "start" && b.pairing.claim(req.UserID, req.Args):
+		b.roles.AddOwner(req.UserID)
+		b.notifier.AddChat(req.UserID)
+		log.Printf("Paired: %s (%d) is now the owner", req.UserName, req.UserID)
+		reply = fmt.Sprintf("🔑 You're now this bot's owner. To keep it that way if its state directory is ever lost, set OWNER_USER_IDS=%d.\n\nSend /help to see what I can do.", req.UserID)
+	case req.Button != nil:
+		return true
+	default:
+		log.Printf("Locked: ignoring %s (%d) until the bot is paired", req.UserName, req.UserID)
+		reply = "🔒 This bot hasn't been set up yet."
+	}
+
+	msg := tgbotapi.NewMessage(req.ChatID, reply)
+	msg.ReplyToMessageID = req.MessageID
+	b.out.Send(req.ChatID, msg)
+	return true
+}
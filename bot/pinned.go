@@ -0,0 +1,301 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/agent"
+	"telegram-bot/priority"
+	"telegram-bot/store"
+)
+
+const (
+	maxPinnedDocs      = 5
+	maxPinnedChars     = 3000      // Longer documents are condensed to fit
+	condensedWords     = 400       // About maxPinnedChars of prose
+	maxPinnedFileBytes = 512 << 10 // Text files bigger than this are refused
+	maxPinnedNameChars = 40
+)
+
+// Condenser is implemented by agents that can shorten a long document
+// pinned with /context. *agent.Agent implements it.
+type Condenser interface {
+	Condense(ctx context.Context, name, text string, maxWords int) (string, error)
+}
+
+// pinnedDoc is a document kept in view for every request in a chat.
+type pinnedDoc struct {
+	Name      string    `json:"name"`
+	Text      string    `json:"text"`  // What the model sees, condensed if the original was long
+	Chars     int       `json:"chars"` // Length of the original
+	Condensed bool      `json:"condensed,omitempty"`
+	AddedBy   string    `json:"added_by,omitempty"`
+	Added     time.Time `json:"added"`
+}
+
+// pinnedDocs keeps each chat's pinned documents in the store.
+type pinnedDocs struct {
+	store *store.Store
+
+	mu    sync.Mutex
+	chats map[int64][]pinnedDoc
+}
+
+func newPinnedDocs(st *store.Store) *pinnedDocs {
+	return &pinnedDocs{store: st, chats: make(map[int64][]pinnedDoc)}
+}
+
+func pinnedKey(chatID int64) string {
+	return fmt.Sprintf("pinned_%d", chatID)
+}
+
+// load returns the chat's documents, reading them from the store on first
+// use. The caller must hold p.mu.
+func (p *pinnedDocs) load(chatID int64) []pinnedDoc {
+	if docs, ok := p.chats[chatID]; ok {
+		return docs
+	}
+	var docs []pinnedDoc
+	if _, err := p.store.Get(pinnedKey(chatID), &docs); err != nil {
+		log.Printf("Loading pinned documents for chat %d: %v", chatID, err)
+	}
+	p.chats[chatID] = docs
+	return docs
+}
+
+// save stores the chat's documents. The caller must hold p.mu.
+func (p *pinnedDocs) save(chatID int64, docs []pinnedDoc) {
+	p.chats[chatID] = docs
+	if err := p.store.Set(pinnedKey(chatID), docs); err != nil {
+		log.Printf("Saving pinned documents for chat %d: %v", chatID, err)
+	}
+}
+
+// list returns a copy of the chat's documents, oldest first.
+func (p *pinnedDocs) list(chatID int64) []pinnedDoc {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]pinnedDoc(nil), p.load(chatID)...)
+}
+
+// add pins a document, replacing one of the same name. It fails if the
+// chat already has the most documents allowed.
+func (p *pinnedDocs) add(chatID int64, doc pinnedDoc) (replaced bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	docs := append([]pinnedDoc(nil), p.load(chatID)...)
+	for i := range docs {
+		if strings.EqualFold(docs[i].Name, doc.Name) {
+			docs[i] = doc
+			p.save(chatID, docs)
+			return true, nil
+		}
+	}
+	if len(docs) >= maxPinnedDocs {
+		return false, fmt.Errorf("this chat already has %d pinned documents; remove one first", maxPinnedDocs)
+	}
+	p.save(chatID, append(docs, doc))
+	return false, nil
+}
+
+// remove unpins the nth document, counting from 1, and returns it.
+func (p *pinnedDocs) remove(chatID int64, n int) (pinnedDoc, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	docs := p.load(chatID)
+	if n < 1 || n > len(docs) {
+		return pinnedDoc{}, false
+	}
+	doc := docs[n-1]
+	p.save(chatID, append(append([]pinnedDoc(nil), docs[:n-1]...), docs[n:]...))
+	return doc, true
+}
+
+// documents returns the chat's pinned documents for the agent.
+func (p *pinnedDocs) documents(chatID int64) []agent.Document {
+	docs := p.list(chatID)
+	out := make([]agent.Document, len(docs))
+	for i, d := range docs {
+		out[i] = agent.Document{Name: d.Name, Text: d.Text}
+	}
+	return out
+}
+
+// contextCommand handles /context, which pins documents as standing
+// context for every request in the chat:
+//
+//	/context                 list the pinned documents
+//	/context add <text>      pin text, named after its first line
+//	/context remove <n>      unpin the nth document
+//
+// A text file sent with the caption "/context add" is pinned under its
+// file name.
+func (b *Bot) contextCommand(ctx context.Context, req *Request) string {
+	op, rest, _ := strings.Cut(strings.TrimSpace(req.Args), " ")
+	switch strings.ToLower(op) {
+	case "", "list":
+		return b.listPinned(req.ChatID)
+	case "add":
+		text := strings.TrimSpace(rest)
+		if text == "" {
+			return "Usage: /context add <text>, or send a text file with the caption /context add"
+		}
+		name, _, _ := strings.Cut(text, "\n")
+		return b.pin(ctx, req, pinnedName(name), text)
+	case "remove", "rm":
+		n, err := strconv.Atoi(strings.TrimSpace(rest))
+		if err != nil {
+			return "Usage: /context remove <number from /context list>"
+		}
+		doc, ok := b.pinned.remove(req.ChatID, n)
+		if !ok {
+			return fmt.Sprintf("There is no pinned document %d. See /context list.", n)
+		}
+		return fmt.Sprintf("📌 Unpinned %q.", doc.Name)
+	default:
+		return "Usage: /context [list|add <text>|remove <n>]"
+	}
+}
+
+func (b *Bot) listPinned(chatID int64) string {
+	docs := b.pinned.list(chatID)
+	if len(docs) == 0 {
+		return "No documents are pinned to this chat. Pin one with /context add <text>, or send a text file with the caption /context add."
+	}
+	var sb strings.Builder
+	sb.WriteString("📌 Pinned to every request in this chat:\n")
+	for i, d := range docs {
+		fmt.Fprintf(&sb, "\n%d. %s (%d chars", i+1, d.Name, d.Chars)
+		if d.Condensed {
+			fmt.Fprintf(&sb, ", condensed to %d", len(d.Text))
+		}
+		fmt.Fprintf(&sb, ", %s)", d.Added.Local().Format("Jan 2"))
+	}
+	return sb.String()
+}
+
+// isContextCaption reports whether a file's caption asks for it to be
+// pinned. Telegram doesn't mark commands in captions, so they're parsed
+// here.
+func isContextCaption(caption string) bool {
+	fields := strings.Fields(caption)
+	if len(fields) < 2 || !strings.EqualFold(fields[1], "add") {
+		return false
+	}
+	command, _, _ := strings.Cut(fields[0], "@")
+	return command == "/context"
+}
+
+// pinUpload pins a text file sent with the caption "/context add".
+func (b *Bot) pinUpload(ctx context.Context, req *Request) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(req.ChatID, text)
+		msg.ReplyToMessageID = req.MessageID
+		b.out.Send(req.ChatID, msg)
+	}
+
+	downloader, ok := b.transport.(fileDownloader)
+	if !ok {
+		reply("Pinning files is not available here.")
+		return
+	}
+	if req.Document.Size > maxPinnedFileBytes {
+		reply(fmt.Sprintf("That file is over %d KB; pin a shorter text file, or the part that matters.", maxPinnedFileBytes>>10))
+		return
+	}
+	body, err := downloader.DownloadFile(ctx, req.Document.FileID)
+	if err != nil {
+		log.Printf("Downloading %s to pin: %v", req.Document.Name, err)
+		reply("❌ Could not download the file.")
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxPinnedFileBytes+1))
+	body.Close()
+	switch {
+	case err != nil:
+		log.Printf("Downloading %s to pin: %v", req.Document.Name, err)
+		reply("❌ Could not download the file.")
+		return
+	case len(data) > maxPinnedFileBytes:
+		reply(fmt.Sprintf("That file is over %d KB; pin a shorter text file, or the part that matters.", maxPinnedFileBytes>>10))
+		return
+	case !utf8.Valid(data) || strings.TrimSpace(string(data)) == "":
+		reply("Only text files can be pinned, such as a README or notes.")
+		return
+	}
+	reply(b.pin(ctx, req, pinnedName(req.Document.Name), string(data)))
+}
+
+// pin condenses a long document with the model, then pins it. Condensing
+// counts against the user's quota like any request; if it isn't possible,
+// the start of the document is pinned instead.
+func (b *Bot) pin(ctx context.Context, req *Request, name, text string) string {
+	doc := pinnedDoc{Name: name, Text: text, Chars: len(text), AddedBy: req.UserName, Added: time.Now().UTC()}
+	if len(text) > maxPinnedChars {
+		doc.Text, doc.Condensed = b.condense(ctx, req, name, text)
+	}
+
+	replaced, err := b.pinned.add(req.ChatID, doc)
+	if err != nil {
+		return "⚠️ " + err.Error()
+	}
+	verb := "Pinned"
+	if replaced {
+		verb = "Replaced"
+	}
+	switch {
+	case doc.Condensed:
+		return fmt.Sprintf("📌 %s %q, condensed from %d to %d characters. It's included with every request in this chat.", verb, name, doc.Chars, len(doc.Text))
+	case len(doc.Text) < doc.Chars:
+		return fmt.Sprintf("📌 %s the first %d of %d characters of %q; it couldn't be condensed. It's included with every request in this chat.", verb, len(doc.Text), doc.Chars, name)
+	default:
+		return fmt.Sprintf("📌 %s %q. It's included with every request in this chat.", verb, name)
+	}
+}
+
+// condense shortens a long document, or cuts it to fit if the agent can't.
+func (b *Bot) condense(ctx context.Context, req *Request, name, text string) (string, bool) {
+	cut := strings.ToValidUTF8(text[:maxPinnedChars], "")
+	condenser, ok := b.agent.(Condenser)
+	if !ok || b.useQuota(ctx) != "" {
+		return cut, false
+	}
+	release, err := b.waitTurn(ctx, req, priority.Heavy)
+	if err != nil {
+		return cut, false
+	}
+	defer release()
+	condensed, err := condenser.Condense(ctx, name, text, condensedWords)
+	if err != nil {
+		log.Printf("Condensing %q: %v", name, err)
+		return cut, false
+	}
+	if len(condensed) > maxPinnedChars*2 {
+		condensed = strings.ToValidUTF8(condensed[:maxPinnedChars*2], "")
+	}
+	return condensed, true
+}
+
+// pinnedName shortens a document's first line or file name to a name for
+// /context list.
+func pinnedName(s string) string {
+	s = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(s), "#"))
+	if utf8.RuneCountInString(s) > maxPinnedNameChars {
+		s = string([]rune(s)[:maxPinnedNameChars]) + "…"
+	}
+	if s == "" {
+		return "Untitled"
+	}
+	return s
+}
@@ -0,0 +1,296 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/store"
+)
+
+const (
+	quietStoreKey      = "quiet_hours"
+	quietCheckInterval = time.Minute
+	maxHeldPerChat     = 50 // The oldest held notifications are dropped past this
+	maxQuietFor        = 7 * 24 * time.Hour
+)
+
+// quietClockFormats are the times of day /quiet accepts.
+var quietClockFormats = []string{"15:04", "15", "3pm", "3PM", "3:04pm", "3:04PM", "3 pm", "3:04 pm"}
+
+// quietChat is one chat's do-not-disturb settings and the notifications
+// held back by them.
+type quietChat struct {
+	Start      string       `json:"start,omitempty"` // Nightly window, HH:MM local time; empty for none
+	End        string       `json:"end,omitempty"`
+	Until      time.Time    `json:"until,omitempty"`       // Quiet until then, from /quiet 2h
+	HoldUrgent bool         `json:"hold_urgent,omitempty"` // Hold urgent notifications too
+	Held       []heldNotice `json:"held,omitempty"`
+}
+
+// heldNotice is a notification waiting for quiet hours to end.
+type heldNotice struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// quietAt reports whether the chat is in a quiet period at t.
+func (q *quietChat) quietAt(t time.Time) bool {
+	if t.Before(q.Until) {
+		return true
+	}
+	if q.Start == "" || q.End == "" {
+		return false
+	}
+	now := t.Format("15:04")
+	if q.Start <= q.End {
+		return now >= q.Start && now < q.End
+	}
+	// The window wraps past midnight, e.g. 22:00-07:00
+	return now >= q.Start || now < q.End
+}
+
+func (q *quietChat) empty() bool {
+	return q.Start == "" && q.Until.IsZero() && !q.HoldUrgent && len(q.Held) == 0
+}
+
+// quietHours holds back proactive notifications, such as watch alerts and
+// briefings, while a chat doesn't want to be disturbed, and delivers them
+// when its quiet period ends. Replies to the chat's own messages are never
+// held.
+type quietHours struct {
+	mu    sync.Mutex
+	store *store.Store
+	chats map[int64]*quietChat
+}
+
+func newQuietHours(st *store.Store) *quietHours {
+	q := &quietHours{store: st, chats: make(map[int64]*quietChat)}
+	if _, err := st.Get(quietStoreKey, &q.chats); err != nil {
+		log.Printf("[quiet] loading settings: %v", err)
+	}
+	if q.chats == nil {
+		q.chats = make(map[int64]*quietChat)
+	}
+	return q
+}
+
+// save persists the settings. The caller must hold q.mu.
+func (q *quietHours) save() {
+	for chatID, c := range q.chats {
+		if c.empty() {
+			delete(q.chats, chatID)
+		}
+	}
+	if err := q.store.Save(quietStoreKey, q.chats); err != nil {
+		log.Printf("[quiet] saving settings: %v", err)
+	}
+}
+
+// chat returns the chat's settings, creating them. The caller must hold q.mu.
+func (q *quietHours) chat(chatID int64) *quietChat {
+	c, ok := q.chats[chatID]
+	if !ok {
+		c = &quietChat{}
+		q.chats[chatID] = c
+	}
+	return c
+}
+
+// hold keeps a notification back if the chat is quiet, reporting whether
+// it did. Urgent ones go through unless the chat asked to hold them too.
+func (q *quietHours) hold(chatID int64, text string, urgent bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, ok := q.chats[chatID]
+	if !ok || !c.quietAt(time.Now()) || (urgent && !c.HoldUrgent) {
+		return false
+	}
+	c.Held = append(c.Held, heldNotice{Text: text, Time: time.Now()})
+	if len(c.Held) > maxHeldPerChat {
+		c.Held = c.Held[len(c.Held)-maxHeldPerChat:]
+	}
+	q.save()
+	return true
+}
+
+// release takes the held notifications of chats whose quiet period has
+// ended.
+func (q *quietHours) release(now time.Time) map[int64][]heldNotice {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	released := make(map[int64][]heldNotice)
+	for id, c := range q.chats {
+		if len(c.Held) == 0 || c.quietAt(now) {
+			continue
+		}
+		released[id] = c.Held
+		c.Held = nil
+	}
+	if len(released) > 0 {
+		q.save()
+	}
+	return released
+}
+
+// notify sends a proactive message to a chat, unless it's in quiet hours,
+// in which case it's held until they end.
+func (b *Bot) notify(chatID int64, text string, urgent bool) {
+	text = b.redactor.Redact(text)
+	b.alerts.add(chatID, text)
+	if b.quiet.hold(chatID, text, urgent) {
+		log.Printf("[quiet] holding a notification for chat %d", chatID)
+		return
+	}
+	b.out.Send(chatID, tgbotapi.NewMessage(chatID, text))
+}
+
+// scheduleQuietHours registers the job that delivers held notifications
+// when quiet periods end.
+func (b *Bot) scheduleQuietHours() {
+	b.scheduler.Every("quiet hours", quietCheckInterval, func(ctx context.Context) error {
+		b.deliverHeld(b.quiet.release(time.Now()))
+		return nil
+	})
+}
+
+// deliverHeld sends held notifications, each marked with when it arrived.
+func (b *Bot) deliverHeld(released map[int64][]heldNotice) {
+	for chatID, held := range released {
+		log.Printf("[quiet] delivering %d held notification(s) to chat %d", len(held), chatID)
+		for _, n := range held {
+			text := fmt.Sprintf("🌙 Held since %s\n\n%s", n.Time.Local().Format("Mon 15:04"), n.Text)
+			b.out.Send(chatID, tgbotapi.NewMessage(chatID, text))
+		}
+	}
+}
+
+// quietCommand handles /quiet, which shows or changes the chat's quiet
+// hours:
+//
+//	/quiet                 show the settings
+//	/quiet 22:00-07:00     hold notifications every night
+//	/quiet 2h              hold them for the next two hours
+//	/quiet urgent hold     hold urgent ones too (allow to let them through)
+//	/quiet off             stop holding, and deliver what's held
+func (b *Bot) quietCommand(chatID int64, args string) string {
+	fields := strings.Fields(strings.ToLower(args))
+	now := time.Now()
+
+	q := b.quiet
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c := q.chat(chatID)
+	defer q.save()
+
+	switch {
+	case len(fields) == 0:
+		return describeQuiet(c, now)
+
+	case fields[0] == "off":
+		c.Start, c.End, c.Until = "", "", time.Time{}
+		held := c.Held
+		c.Held = nil
+		b.deliverHeld(map[int64][]heldNotice{chatID: held})
+		return strings.TrimSpace("🔔 Quiet hours are off. " + heldCount(len(held), "Delivered"))
+
+	case fields[0] == "urgent":
+		if len(fields) != 2 || (fields[1] != "hold" && fields[1] != "allow") {
+			return "Usage: /quiet urgent hold|allow"
+		}
+		c.HoldUrgent = fields[1] == "hold"
+		if c.HoldUrgent {
+			return "🌙 Urgent notifications, like flight changes, will be held during quiet hours too."
+		}
+		return "🔔 Urgent notifications, like flight changes, will come through during quiet hours."
+
+	case strings.Contains(args, "-"):
+		start, end, err := parseQuietWindow(strings.Join(fields, " "))
+		if err != nil {
+			return "⚠️ " + err.Error()
+		}
+		c.Start, c.End = start, end
+		return fmt.Sprintf("🌙 Quiet hours set to %s–%s every day. Notifications that arrive then are held until %s.", start, end, end)
+
+	default:
+		d, err := time.ParseDuration(fields[0])
+		if err != nil || d <= 0 {
+			return "Usage: /quiet [22:00-07:00 | 2h | urgent hold|allow | off]"
+		}
+		if d > maxQuietFor {
+			return fmt.Sprintf("⚠️ /quiet can hold notifications for at most %d days.", int(maxQuietFor.Hours()/24))
+		}
+		c.Until = now.Add(d)
+		return fmt.Sprintf("🌙 Quiet until %s. Notifications will be held until then.", c.Until.Format("Mon 15:04"))
+	}
+}
+
+// describeQuiet summarizes a chat's quiet hours for /quiet.
+func describeQuiet(c *quietChat, now time.Time) string {
+	var lines []string
+	if c.Start != "" {
+		lines = append(lines, fmt.Sprintf("🌙 Quiet hours: %s–%s every day", c.Start, c.End))
+	}
+	if now.Before(c.Until) {
+		lines = append(lines, "🌙 Quiet until "+c.Until.Format("Mon 15:04"))
+	}
+	if len(lines) == 0 {
+		return "🔔 No quiet hours set. Try /quiet 22:00-07:00 to hold notifications overnight, or /quiet 2h for a break."
+	}
+	if c.HoldUrgent {
+		lines = append(lines, "Urgent notifications are held too.")
+	} else {
+		lines = append(lines, "Urgent notifications, like flight changes, still come through.")
+	}
+	if len(c.Held) > 0 {
+		lines = append(lines, heldCount(len(c.Held), "Holding"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func heldCount(n int, verb string) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return verb + " 1 notification."
+	default:
+		return fmt.Sprintf("%s %d notifications.", verb, n)
+	}
+}
+
+// parseQuietWindow reads a daily window such as "22:00-07:00" or
+// "10pm-7am" into HH:MM start and end times.
+func parseQuietWindow(s string) (start, end string, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return "", "", fmt.Errorf("give quiet hours as a range, e.g. 22:00-07:00")
+	}
+	if start, err = parseQuietClock(from); err != nil {
+		return "", "", err
+	}
+	if end, err = parseQuietClock(to); err != nil {
+		return "", "", err
+	}
+	if start == end {
+		return "", "", fmt.Errorf("quiet hours must start and end at different times")
+	}
+	return start, end, nil
+}
+
+func parseQuietClock(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range quietClockFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("15:04"), nil
+		}
+	}
+	return "", fmt.Errorf("unrecognized time %q (use e.g. 22:00 or 10pm)", s)
+}
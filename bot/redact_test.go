@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"telegram-bot/events"
+	"telegram-bot/redact"
+	"telegram-bot/tools"
+)
+
+// TestLoadedCredentialsRedacted loads each file that holds credentials and
+// checks a redactor masks a secret from it.
+func TestLoadedCredentialsRedacted(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		file   string
+		load   func(path string) error
+		secret string
+	}{
+		{
+			name:   "site auth header",
+			file:   `{"wiki.example.com": {"headers": {"Authorization": "Bearer wiki-token-1234"}, "cookies": {"session": "wiki-cookie-5678"}}}`,
+			load:   func(path string) error { _, err := tools.LoadSiteAuth(path); return err },
+			secret: "wiki-token-1234",
+		},
+		{
+			name:   "site auth cookie",
+			file:   `{"wiki.example.com": {"cookies": {"session": "wiki-cookie-5678"}}}`,
+			load:   func(path string) error { _, err := tools.LoadSiteAuth(path); return err },
+			secret: "wiki-cookie-5678",
+		},
+		{
+			name:   "carrier key",
+			file:   `{"dhl": {"url": "https://api.dhl.example/track?n={number}", "status": "status", "headers": {"DHL-API-Key": "dhl-key-abcdef", "Accept": "application/json"}}}`,
+			load:   func(path string) error { _, err := tools.LoadCarriers(path); return err },
+			secret: "dhl-key-abcdef",
+		},
+		{
+			name:   "event source token",
+			file:   `{"github": {"token": "event-token-9876", "format": "github", "chats": [1]}}`,
+			load:   func(path string) error { _, err := events.LoadSources(path); return err },
+			secret: "event-token-9876",
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".json")
+		if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.load(path); err != nil {
+			t.Fatalf("%s: loading: %v", tt.name, err)
+		}
+		text := "the value is " + tt.secret + " here"
+		if got := redact.New().Redact(text); strings.Contains(got, tt.secret) {
+			t.Errorf("%s: Redact(%q) = %q, want the secret masked", tt.name, text, got)
+		}
+	}
+
+	// Headers that aren't credentials stay readable
+	if got := redact.New().Redact("Accept: application/json"); !strings.Contains(got, "application/json") {
+		t.Errorf("Redact masked an ordinary header: %q", got)
+	}
+}
@@ -0,0 +1,17 @@
+package bot
+
+import "strings"
+
+// repoCommandArgs turns /repo's argument into a repo tool call: a URL
+// clones, a bare name switches to that clone, and nothing lists them.
+func repoCommandArgs(arg string) map[string]any {
+	arg = strings.TrimSpace(arg)
+	switch {
+	case arg == "":
+		return map[string]any{"operation": "list"}
+	case strings.ContainsAny(arg, "/:"):
+		return map[string]any{"operation": "clone", "url": arg}
+	default:
+		return map[string]any{"operation": "use", "repo": arg}
+	}
+}
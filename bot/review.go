@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/priority"
+)
+
+// reviewDiff runs the review tool directly rather than letting the model
+// decide, so a long diff isn't copied through a tool call. The review is
+// recorded in the conversation so follow-ups ("fix the first one") work.
+func (b *Bot) reviewDiff(ctx context.Context, req *Request, args map[string]any) string {
+	done := b.runs.Start(req.ChatID, req.UserName, req.Text)
+	reply := b.runTool(ctx, "review", args)
+	done()
+
+	user := req.Text
+	if file, ok := args["file"].(string); ok {
+		user = strings.TrimSpace("Review " + file + ". " + req.Text)
+	}
+	parent, _ := b.conversationsFor(ctx).parent(req.ChatID, req.ReplyTo)
+	b.conversationsFor(ctx).add(req.ChatID, turn{ID: req.MessageID, Parent: parent, User: user, Assistant: reply})
+	return reply
+}
+
+// isPatchFile reports whether an upload is a diff to review.
+func isPatchFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".patch" || ext == ".diff"
+}
+
+// reviewUpload reviews a .patch or .diff file the user just sent, using
+// its caption as what to focus on.
+func (b *Bot) reviewUpload(ctx context.Context, req *Request, rel string) {
+	send := func(text string) {
+		msg := tgbotapi.NewMessage(req.ChatID, b.redactor.Redact(text))
+		msg.ReplyToMessageID = req.MessageID
+		b.out.Send(req.ChatID, msg)
+	}
+
+	if text := b.useQuota(ctx); text != "" {
+		send(text)
+		return
+	}
+	release, err := b.waitTurn(ctx, req, priority.Heavy)
+	if err != nil {
+		send("⚠️ The bot is shutting down; please try again shortly.")
+		return
+	}
+	log.Printf("[upload] reviewing %s for %s", rel, req.UserName)
+	reply := b.reviewDiff(ctx, req, map[string]any{"file": rel, "focus": req.Text})
+	release()
+	send(reply)
+}
@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-bot/transcript"
+)
+
+const maxSearchHits = 5
+
+// transcriptTurn converts a stored turn for the transcript index.
+func transcriptTurn(t turn) transcript.Turn {
+	return transcript.Turn{MessageID: t.ID, Time: t.Time, User: t.User, Assistant: t.Assistant}
+}
+
+// searchCommand handles /search, which finds earlier exchanges in the
+// chat by their words, and by meaning if embeddings are on:
+//
+//	/search backup script
+//	/search what did we decide about the backup script?
+func (b *Bot) searchCommand(ctx context.Context, req *Request) string {
+	query := strings.TrimSpace(req.Args)
+	if query == "" {
+		return "Usage: /search <words or question>"
+	}
+	if b.transcripts == nil {
+		return "Transcript search is not available."
+	}
+
+	// Turns from before the index existed, or that failed to index, are
+	// added the first time the chat is searched
+	b.conversationsFor(ctx).backfill(ctx, req.ChatID)
+
+	hits, err := b.transcripts.Search(ctx, req.ChatID, query, maxSearchHits)
+	if err != nil {
+		log.Printf("Transcript search error: %v", err)
+		return "❌ Could not search this chat's history."
+	}
+	if len(hits) == 0 {
+		return "🔍 Nothing in this chat's history matches that."
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔍 %d match(es) in this chat:\n", len(hits))
+	for _, h := range hits {
+		fmt.Fprintf(&sb, "\n📅 %s\n%s\n", h.Time.Local().Format("Jan 2, 2006 15:04"), h.Excerpt)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
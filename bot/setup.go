@@ -0,0 +1,428 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"telegram-bot/agent"
+	"telegram-bot/balance"
+	"telegram-bot/config"
+	"telegram-bot/egress"
+	"telegram-bot/embed"
+	"telegram-bot/mqtt"
+	"telegram-bot/tenant"
+	"telegram-bot/tools"
+)
+
+// Run creates a bot with the configured agent and tools, and runs it until
+// the context is cancelled.
+func Run(ctx context.Context, cfg *config.Config, opts ...Option) error {
+	b, err := New(cfg, tools.NewRegistry(), nil, opts...)
+	if err != nil {
+		return err
+	}
+	return b.Run(ctx)
+}
+
+// newAgent creates the agent for the configured model provider.
+func newAgent(cfg *config.Config, ollama *balance.Pool, registry *tools.Registry) (*agent.Agent, error) {
+	var llm agent.LLMClient
+	switch cfg.LLMProvider {
+	case "ollama":
+		llm = agent.NewPooledOllamaClient(ollama)
+	case "openai":
+		llm = agent.NewOpenAIClient(cfg.LLMAPIURL, cfg.LLMAPIKey)
+	case "anthropic":
+		llm = agent.NewAnthropicClient(cfg.LLMAPIURL, cfg.LLMAPIKey)
+	default:
+		return nil, fmt.Errorf("LLM_PROVIDER must be ollama, openai, or anthropic, got %q", cfg.LLMProvider)
+	}
+	if cfg.ChatModel() == "" {
+		return nil, fmt.Errorf("LLM_MODEL is required with LLM_PROVIDER=%s", cfg.LLMProvider)
+	}
+	chatAgent := agent.NewWithClient(cfg.ChatModel(), llm, registry)
+	log.Printf("Chatting with %s via %s", cfg.ChatModel(), cfg.LLMProvider)
+	if small := cfg.SmallModel(); small != "" {
+		chatAgent.UseSmallModel(small)
+		log.Printf("Trying trivial messages on %s first", small)
+	}
+	return chatAgent, nil
+}
+
+// setUpIsolation runs the commands tools start under firejail, gVisor, or
+// containers, if configured, hiding the bot's state and tokens from them.
+// It returns the egress proxy their traffic should go through, if any.
+func setUpIsolation(cfg *config.Config) (*egress.Proxy, error) {
+	profiles, err := tools.ParseIsolationProfiles(cfg.IsolationProfiles)
+	if err != nil {
+		return nil, fmt.Errorf("ISOLATION_PROFILES: %w", err)
+	}
+	var hidden []string
+	for _, path := range []string{cfg.StateDir, cfg.GoogleTokenFile, cfg.SpotifyTokenFile} {
+		if abs, err := filepath.Abs(path); err == nil {
+			hidden = append(hidden, abs)
+		}
+	}
+	var tenants string
+	if cfg.Tenants {
+		// Tenants share one host, so the commands one of them runs mustn't
+		// see the others' workspaces or the state directory
+		if cfg.TenantKey == "" {
+			return nil, fmt.Errorf("TENANTS needs TENANT_KEY, so the key to tenants' state isn't kept beside it")
+		}
+		if cfg.Isolation != "firejail" && cfg.ContainerRuntime == "" {
+			return nil, fmt.Errorf("TENANTS needs ISOLATION=firejail or CONTAINER_RUNTIME, so tenants' commands can't read each other's files")
+		}
+		if cfg.Isolation == "firejail" {
+			for name, p := range profiles {
+				if p.Off {
+					return nil, fmt.Errorf("ISOLATION_PROFILES: %s can't be off with TENANTS", name)
+				}
+			}
+		}
+		// Keep the master key out of the environment commands inherit
+		os.Unsetenv("TENANT_KEY")
+		if abs, err := filepath.Abs(tenant.Dir(cfg.PythonWorkspace, "")); err == nil {
+			tenants = abs
+		}
+	}
+	if err := tools.SetIsolation(tools.Isolation{Backend: cfg.Isolation, Profiles: profiles, Hidden: hidden, Tenants: tenants}); err != nil {
+		return nil, fmt.Errorf("ISOLATION: %w", err)
+	}
+
+	// Limit the hosts those commands can reach, through a local proxy
+	var proxy *egress.Proxy
+	if cfg.EgressPolicyFile != "" {
+		policies, err := egress.LoadPolicies(cfg.EgressPolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("EGRESS_POLICY_FILE: %w", err)
+		}
+		var addr string
+		if cfg.EgressBridge != "" {
+			if addr, err = egress.BridgeAddr(cfg.EgressBridge); err != nil {
+				return nil, fmt.Errorf("EGRESS_BRIDGE: %w", err)
+			}
+		} else {
+			log.Printf("Egress proxy: commands that ignore HTTP_PROXY can go around it; set EGRESS_BRIDGE to confine them")
+		}
+		if proxy, err = egress.New(addr, policies); err != nil {
+			return nil, fmt.Errorf("egress proxy: %w", err)
+		}
+		if err := tools.SetEgress(proxy, cfg.EgressBridge); err != nil {
+			return nil, fmt.Errorf("egress proxy: %w", err)
+		}
+	} else if cfg.EgressBridge != "" || cfg.EgressNetwork != "" {
+		return nil, fmt.Errorf("EGRESS_BRIDGE and EGRESS_NETWORK need EGRESS_POLICY_FILE")
+	}
+
+	// Run python code and bash commands in containers, if configured
+	if cfg.ContainerRuntime != "" {
+		executor, err := tools.NewContainerExecutor(cfg.ContainerRuntime, cfg.ContainerImage, cfg.ContainerMemory, cfg.ContainerCPUs, cfg.ContainerNetwork)
+		if err != nil {
+			return nil, fmt.Errorf("CONTAINER_RUNTIME: %w", err)
+		}
+		if cfg.EgressNetwork != "" && cfg.EgressBridge == "" {
+			return nil, fmt.Errorf("EGRESS_NETWORK: set EGRESS_BRIDGE to the network's bridge, for the proxy to listen on")
+		}
+		executor.EgressNetwork = cfg.EgressNetwork
+		tools.SetExecutor(executor)
+	} else if cfg.EgressNetwork != "" {
+		return nil, fmt.Errorf("EGRESS_NETWORK needs CONTAINER_RUNTIME")
+	}
+
+	return proxy, nil
+}
+
+// registerTools registers the tools the configuration enables, and keeps
+// the ones the bot's commands and jobs use. Page summaries and the tools
+// that prompt through the scrape tool use llm.
+func (b *Bot) registerTools(llm tools.Completer) error {
+	cfg, registry := b.cfg, b.registry
+
+	registry.Register(&tools.TimeTool{})
+
+	// Set up Python, Bash, Files, math, and health tools (share the same workspace)
+	pythonTool := tools.NewPythonTool(cfg.PythonWorkspace, cfg.PythonPackages...)
+	if err := pythonTool.Init(); err != nil {
+		log.Printf("Workspace warning: %v", err)
+	} else {
+		log.Printf("Workspace: %s", cfg.PythonWorkspace)
+	}
+	registry.Register(pythonTool)
+	registry.Register(tools.NewBashTool(cfg.PythonWorkspace, cfg.BashAllowedDirs...))
+	registry.Register(tools.NewFilesTool(cfg.PythonWorkspace))
+	registry.Register(tools.NewMathTool(pythonTool))
+	registry.Register(tools.NewHealthTool(pythonTool, cfg.HealthDataDir, cfg.HealthUnits == "imperial"))
+
+	// Set up the WebAssembly sandbox for untrusted code, if an interpreter is configured
+	if cfg.SandboxPython != "" || cfg.SandboxJS != "" {
+		registry.Register(tools.NewSandboxTool(tools.SandboxConfig{
+			PythonWasm: cfg.SandboxPython,
+			PythonHome: cfg.SandboxPythonHome,
+			JSWasm:     cfg.SandboxJS,
+			MemoryMB:   cfg.SandboxMemoryMB,
+			Timeout:    cfg.SandboxTimeout,
+			CacheDir:   filepath.Join(cfg.StateDir, "wasm-cache"),
+		}))
+	}
+
+	// Set up scrape tool (uses the chat model for summarization), with credentials for private sites
+	scrapeOpts := []tools.ScrapeOption{
+		tools.WithPageWatchInterval(cfg.ScrapeWatchEvery),
+		tools.WithWorkspace(cfg.PythonWorkspace),
+	}
+	if cfg.ScrapeBrowser != "" {
+		scrapeOpts = append(scrapeOpts, tools.WithBrowser(cfg.ScrapeBrowser))
+	}
+	if cfg.ScrapeAuthFile != "" {
+		if sites, err := tools.LoadSiteAuth(cfg.ScrapeAuthFile); err != nil {
+			log.Printf("Scrape auth warning: %v", err)
+		} else {
+			scrapeOpts = append(scrapeOpts, tools.WithSiteAuth(sites))
+			log.Printf("Scrape credentials configured for %d sites", len(sites))
+		}
+	}
+	scrapeTool := tools.NewScrapeTool(llm, scrapeOpts...)
+	registry.Register(scrapeTool)
+
+	// Set up the read-later list, which summarizes and tags articles with the
+	// scrape tool, and searches them (and cloned repositories, snippets, and
+	// chat transcripts) by meaning if an embedding model is set
+	var readingOpts []tools.ReadingOption
+	var repoOpts []tools.RepoOption
+	var snippetOpts []tools.SnippetOption
+	if cfg.OllamaEmbedModel != "" {
+		b.embedder = embed.New(b.ollama, cfg.OllamaEmbedModel, embed.WithCache(filepath.Join(cfg.StateDir, "embeddings")))
+		readingOpts = append(readingOpts, tools.WithReadingEmbeddings(b.embedder))
+		repoOpts = append(repoOpts, tools.WithRepoEmbeddings(b.embedder))
+		snippetOpts = append(snippetOpts, tools.WithSnippetEmbeddings(b.embedder))
+	}
+	registry.Register(tools.NewReadingListTool(scrapeTool, readingOpts...))
+
+	// Set up code review, which prompts the model through the scrape tool and
+	// runs linters and tests on patched copies of workspace projects
+	registry.Register(tools.NewReviewTool(scrapeTool, pythonTool))
+
+	// Set up repository Q&A, which clones into the workspace and answers
+	// from an index of definitions and passages
+	registry.Register(tools.NewRepoTool(scrapeTool, cfg.PythonWorkspace, repoOpts...))
+
+	// Set up dependency checks, which look up newer versions and advisories
+	registry.Register(tools.NewDepsTool(cfg.PythonWorkspace, tools.WithAllowedLicenses(cfg.AllowedLicenses)))
+
+	// Set up GitHub issues and pull requests, if a token is configured; /fix
+	// works an issue through to a draft pull request with it
+	if cfg.GitHubToken != "" {
+		b.github = tools.NewGitHubTool(scrapeTool, cfg.GitHubToken, cfg.GitHubAPIURL, cfg.PythonWorkspace)
+		registry.Register(b.github)
+	}
+
+	// Set up Terraform plans, summaries, and approved applies, in the
+	// configured directories only
+	if len(cfg.TerraformDirs) > 0 {
+		if dirs, err := tools.ParseTerraformDirs(cfg.TerraformDirs); err != nil {
+			log.Printf("Terraform disabled: %v", err)
+		} else {
+			registry.Register(tools.NewTerraformTool(dirs, cfg.PythonWorkspace,
+				tools.WithTerraformAuditLog(filepath.Join(cfg.StateDir, "terraform_applies.jsonl"))))
+		}
+	}
+
+	// Set up cloud queries with the host's AWS and Google Cloud credentials
+	var cloudOpts []tools.CloudOption
+	for _, provider := range cfg.CloudProviders {
+		switch provider {
+		case "aws":
+			cloudOpts = append(cloudOpts, tools.WithAWS(cfg.AWSRegions))
+		case "gcp":
+			cloudOpts = append(cloudOpts, tools.WithGCP(cfg.GCPProject, cfg.GCPBillingTable))
+		default:
+			log.Printf("Unknown cloud provider %q in CLOUD_PROVIDERS (use aws or gcp)", provider)
+		}
+	}
+	if len(cloudOpts) > 0 {
+		if cfg.CloudWrites {
+			cloudOpts = append(cloudOpts, tools.WithCloudWrites())
+		}
+		registry.Register(tools.NewCloudTool(cloudOpts...))
+	}
+
+	// Set up DNS lookups, and record changes in Cloudflare zones
+	dnsOpts := []tools.DNSOption{
+		tools.WithDNSResolver(cfg.DNSResolverURL),
+		tools.WithDNSAuditLog(filepath.Join(cfg.StateDir, "dns_changes.jsonl")),
+	}
+	if cfg.CloudflareToken != "" {
+		dnsOpts = append(dnsOpts, tools.WithCloudflare(cfg.CloudflareToken))
+	}
+	registry.Register(tools.NewDNSTool(dnsOpts...))
+
+	// Set up certificate checks; the bot alerts the owners about expiring ones daily
+	registry.Register(tools.NewCertsTool(tools.WithMonitoredCerts(cfg.CertDomains, cfg.CertWarnDays)))
+
+	// Set up the snippet library, which inserts saved code into the workspace
+	registry.Register(tools.NewSnippetsTool(cfg.PythonWorkspace, snippetOpts...))
+
+	// Set up polls, which the transport posts and reports votes on
+	b.polls = tools.NewPollTool()
+	registry.Register(b.polls)
+
+	// Set up pinning and chat titles, in chats the bot administers
+	registry.Register(tools.NewChatAdminTool())
+
+	// Set up the family shopping list, with /shopping's check-off buttons,
+	// and recipes that can add to it
+	if cfg.ShoppingChatID != 0 {
+		b.shopping = tools.NewShoppingListTool(cfg.ShoppingChatID)
+		registry.Register(b.shopping)
+	}
+	recipeOpts := []tools.RecipeOption{tools.WithRecipeSites(cfg.RecipeSites)}
+	if cfg.RecipeAPIURL != "" {
+		recipeOpts = append(recipeOpts, tools.WithRecipeAPI(cfg.RecipeAPIURL))
+	}
+	if b.shopping != nil {
+		recipeOpts = append(recipeOpts, tools.WithRecipeShoppingList(b.shopping))
+	}
+	registry.Register(tools.NewRecipeTool(scrapeTool, recipeOpts...))
+
+	// Set up nearby search and directions around shared locations
+	geocoder := tools.NewGeocoder(cfg.NominatimURL)
+	registry.Register(tools.NewPlacesTool(geocoder, cfg.OverpassURL))
+	directions, err := tools.NewDirectionsTool(tools.DirectionsConfig{
+		Provider: cfg.RoutingProvider,
+		URL:      cfg.RoutingURL,
+		APIKey:   cfg.GoogleMapsKey,
+	}, geocoder)
+	if err != nil {
+		log.Printf("Directions disabled: %v", err)
+	} else {
+		registry.Register(directions)
+	}
+
+	// Set up flight and parcel tracking, with carriers' own APIs if configured
+	trackingCfg := tools.TrackingConfig{
+		FlightKey:     cfg.FlightAPIKey,
+		FlightURL:     cfg.FlightAPIURL,
+		ParcelKey:     cfg.ParcelAPIKey,
+		ParcelURL:     cfg.ParcelAPIURL,
+		WatchInterval: cfg.TrackingInterval,
+	}
+	if cfg.CarriersFile != "" {
+		if carriers, err := tools.LoadCarriers(cfg.CarriersFile); err != nil {
+			log.Printf("Tracking carriers warning: %v", err)
+		} else {
+			trackingCfg.Carriers = carriers
+			log.Printf("Tracking configured for %d carriers", len(carriers))
+		}
+	}
+	if tracking, err := tools.NewTrackingTool(trackingCfg); err != nil {
+		log.Printf("Tracking disabled: %v", err)
+	} else {
+		registry.Register(tracking)
+	}
+
+	// Set up uptime monitoring of personal services
+	registry.Register(tools.NewUptimeTool(cfg.UptimeInterval))
+
+	// Set up publishing to and subscribing to an MQTT broker's topics
+	if cfg.MQTTURL != "" {
+		registry.Register(tools.NewMQTTTool(mqtt.Options{URL: cfg.MQTTURL, Username: cfg.MQTTUsername, Password: cfg.MQTTPassword}))
+	}
+
+	// Set up presence detection of phones on the LAN
+	if len(cfg.PresenceDevices) > 0 {
+		devices, err := tools.ParsePresenceDevices(cfg.PresenceDevices)
+		if err != nil {
+			return fmt.Errorf("PRESENCE_DEVICES: %w", err)
+		}
+		presence, err := tools.NewPresenceTool(tools.PresenceConfig{
+			Devices:   devices,
+			Subnets:   cfg.PresenceSubnets,
+			Interval:  cfg.PresenceInterval,
+			AwayAfter: cfg.PresenceAwayAfter,
+		})
+		if err != nil {
+			log.Printf("Presence disabled: %v", err)
+		} else {
+			registry.Register(presence)
+		}
+	}
+
+	// Set up encrypted backups, of the workspace, state, and config files
+	// unless told what to back up; the bot makes them daily at BACKUP_TIME
+	if cfg.BackupDestination != "" {
+		paths := cfg.BackupPaths
+		if len(paths) == 0 {
+			for _, p := range []string{cfg.PythonWorkspace, cfg.StateDir, ".env", cfg.GoogleTokenFile, cfg.SpotifyTokenFile,
+				cfg.EgressPolicyFile, cfg.CarriersFile, cfg.ScrapeAuthFile} {
+				if _, err := os.Stat(p); p != "" && err == nil {
+					paths = append(paths, p)
+				}
+			}
+		}
+		backupTool, err := tools.NewBackupTool(tools.BackupConfig{
+			Paths:       paths,
+			Destination: cfg.BackupDestination,
+			Recipients:  cfg.BackupRecipients,
+			HistoryFile: filepath.Join(cfg.StateDir, "backups.jsonl"),
+		})
+		if err != nil {
+			log.Printf("Backups disabled: %v", err)
+		} else {
+			b.backup = backupTool
+			registry.Register(backupTool)
+		}
+	}
+
+	// Set up Wiktionary lookups
+	registry.Register(tools.NewDictionaryTool())
+
+	// Set up book lookups and the to read/watch list, with films and shows if TMDB is configured
+	registry.Register(tools.NewMediaTool(cfg.TMDBAPIKey, cfg.MediaRegion))
+
+	// Set up OCI registry tool, with promotions between environments if configured
+	ociOpts := []tools.OCIOption{tools.WithWatchInterval(cfg.OCIWatchInterval), tools.WithCLI(cfg.OCICLI)}
+	if envs, err := tools.ParseEnvironments(cfg.OCIEnvironments); err != nil {
+		log.Printf("OCI promotion disabled: %v", err)
+	} else if len(envs) > 0 {
+		ociOpts = append(ociOpts, tools.WithPromotion(tools.PromotionConfig{
+			Environments: envs,
+			SignKey:      cfg.OCISignKey,
+			AuditLog:     filepath.Join(cfg.StateDir, "oci_promotions.jsonl"),
+		}))
+	}
+	registry.Register(tools.NewOCITool(ociOpts...))
+
+	// Set up calendar tool, which /auth and /authcode connect accounts to
+	b.calendar = tools.NewCalendarTool(
+		cfg.GoogleClientID,
+		cfg.GoogleSecret,
+		cfg.GoogleRedirectURL,
+		cfg.GoogleTokenFile,
+	)
+	if cfg.GoogleClientID == "" || cfg.GoogleSecret == "" {
+		log.Printf("Calendar disabled: GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET are required")
+	}
+	registry.Register(b.calendar)
+
+	// Set up Spotify, if an app is configured, with /spotify and /spotifycode
+	if cfg.SpotifyClientID != "" {
+		b.spotify = tools.NewSpotifyTool(cfg.SpotifyClientID, cfg.SpotifySecret, cfg.SpotifyRedirect, cfg.SpotifyTokenFile)
+		if authURL, err := b.spotify.Init(context.Background()); err != nil {
+			log.Printf("Spotify init warning: %v", err)
+		} else if authURL != "" {
+			log.Printf("Spotify needs authentication. Use /spotify command in the bot.")
+		}
+		registry.Register(b.spotify)
+	}
+
+	// Leave out the tools and operations whose commands aren't installed,
+	// e.g. oci without skopeo, or python's develop without pytest
+	if degraded := registry.Discover(context.Background()); len(degraded) > 0 {
+		log.Printf("%d tools are missing commands they need; see [capabilities] above", len(degraded))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/tools"
+)
+
+const (
+	shoppingButtonPrefix = "shop:"
+	shoppingClearButton  = shoppingButtonPrefix + "clear"
+	maxShoppingButtons   = 40 // Telegram caps how large a keyboard can be
+)
+
+// memberChecker is implemented by transports that can tell whether a user
+// belongs to a group chat.
+type memberChecker interface {
+	IsMember(chatID, userID int64) (bool, error)
+}
+
+// shoppingCommand handles /shopping, which shows the shared list with a
+// button per item to check it off.
+func (b *Bot) shoppingCommand(ctx context.Context) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if b.shopping == nil {
+		return "The shopping list is not enabled.", nil
+	}
+	if !b.shopping.Allowed(ctx) {
+		return "⛔ The shopping list is only for members of the family group.", nil
+	}
+	return shoppingView(b.shopping.Items())
+}
+
+// handleButton answers an inline keyboard button press: a form's, or
+// otherwise the shopping list's.
+func (b *Bot) handleButton(ctx context.Context, req *Request) {
+	if data, ok := strings.CutPrefix(req.Button.Data, formButtonPrefix); ok {
+		b.formButton(ctx, req, data)
+		return
+	}
+	if data, ok := strings.CutPrefix(req.Button.Data, editButtonPrefix); ok {
+		b.editButton(ctx, req, data)
+		return
+	}
+	if data, ok := strings.CutPrefix(req.Button.Data, fixCommandPrefix); ok {
+		b.fixCommandButton(ctx, req, data)
+		return
+	}
+	if data, ok := strings.CutPrefix(req.Button.Data, fixButtonPrefix); ok {
+		b.fixButton(ctx, req, data)
+		return
+	}
+	if data, ok := strings.CutPrefix(req.Button.Data, alertButtonPrefix); ok {
+		b.alertButton(ctx, req, data)
+		return
+	}
+
+	answer := ""
+	defer func() {
+		b.out.Send(req.ChatID, tgbotapi.NewCallback(req.Button.ID, answer))
+	}()
+
+	data, ok := strings.CutPrefix(req.Button.Data, shoppingButtonPrefix)
+	if !ok || b.shopping == nil {
+		answer = "This button no longer works."
+		return
+	}
+	if !b.shopping.Allowed(ctx) {
+		answer = "⛔ The shopping list is only for members of the family group."
+		return
+	}
+
+	if data == "clear" {
+		n, err := b.shopping.ClearChecked()
+		if err != nil {
+			log.Printf("[shopping] clearing: %v", err)
+			answer = "Sorry, I couldn't clear the list."
+			return
+		}
+		answer = fmt.Sprintf("Cleared %d item(s)", n)
+	} else {
+		id, err := strconv.Atoi(data)
+		if err != nil {
+			answer = "This button no longer works."
+			return
+		}
+		item, err := b.shopping.Toggle(ctx, id)
+		if err != nil {
+			answer = err.Error()
+		} else {
+			answer = checkMark(item.Checked) + " " + item.Name
+		}
+	}
+
+	// Redraw the list in place, so everyone sees the same state
+	text, keyboard := shoppingView(b.shopping.Items())
+	var edit tgbotapi.EditMessageTextConfig
+	if keyboard != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(req.ChatID, req.MessageID, b.redactor.Redact(text), *keyboard)
+	} else {
+		edit = tgbotapi.NewEditMessageText(req.ChatID, req.MessageID, b.redactor.Redact(text))
+	}
+	b.out.Send(req.ChatID, edit)
+}
+
+// shoppingView renders the list as text plus one button per item. The
+// keyboard is nil when the list is empty.
+func shoppingView(items []tools.ShoppingItem) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if len(items) == 0 {
+		return "🛒 The shopping list is empty.", nil
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	toBuy, bought := 0, 0
+	for _, item := range items {
+		if item.Checked {
+			bought++
+		} else {
+			toBuy++
+		}
+		if len(rows) < maxShoppingButtons {
+			label := truncate(checkMark(item.Checked)+" "+item.Name, 60)
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(label, shoppingButtonPrefix+strconv.Itoa(item.ID)),
+			))
+		}
+	}
+	if bought > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🧹 Clear checked items", shoppingClearButton),
+		))
+	}
+
+	text := fmt.Sprintf("🛒 Shopping list: %d to buy, %d checked off. Tap an item to check it off.", toBuy, bought)
+	if len(items) > maxShoppingButtons {
+		text += fmt.Sprintf("\n(Showing the first %d items.)", maxShoppingButtons)
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return text, &keyboard
+}
+
+func checkMark(checked bool) string {
+	if checked {
+		return "✅"
+	}
+	return "⬜"
+}
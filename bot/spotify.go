@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"context"
+	"strings"
+
+	"telegram-bot/auth"
+)
+
+// spotifyCommand handles /spotify, which connects the owner's Spotify
+// account.
+func (b *Bot) spotifyCommand(ctx context.Context, user auth.User) string {
+	if user.Role != auth.Owner {
+		return "⛔ Only the bot owner can connect Spotify."
+	}
+	if b.spotify == nil {
+		return "Spotify is not configured."
+	}
+	authURL, err := b.spotify.Init(ctx)
+	if err != nil {
+		return "⚠️ " + err.Error()
+	}
+	if authURL == "" {
+		return "✅ Spotify is already connected!"
+	}
+	return "🔐 To connect Spotify:\n\n" +
+		"1. Click this link:\n" + authURL + "\n\n" +
+		"2. Sign in and agree\n\n" +
+		"3. Your browser is sent to a page that may not load; copy its address\n\n" +
+		"4. Send: /spotifycode <that address>"
+}
+
+// spotifyCodeCommand handles /spotifycode, which completes the connection
+// with the code from the redirect.
+func (b *Bot) spotifyCodeCommand(ctx context.Context, user auth.User, args string) string {
+	code := strings.TrimSpace(args)
+	switch {
+	case user.Role != auth.Owner:
+		return "⛔ Only the bot owner can connect Spotify."
+	case b.spotify == nil:
+		return "Spotify is not configured."
+	case code == "":
+		return "Please provide the address or code: /spotifycode <address>"
+	}
+	if err := b.spotify.CompleteAuth(ctx, code); err != nil {
+		return "❌ Authentication failed: " + err.Error()
+	}
+	return "✅ Spotify connected! Try asking \"What's playing?\""
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	minStreamEditInterval = time.Second // Telegram limits how often a chat's messages can change
+	streamCursor          = " ▌"
+)
+
+// replyStream shows an answer in the chat while the model writes it: the
+// first text is sent as a reply, and each further N tokens edit it in place.
+// When the answer is done, finish turns the message into the final reply.
+type replyStream struct {
+	b       *Bot
+	chatID  int64
+	replyTo int
+	every   int // Tokens between edits
+
+	tokens    int // Streamed so far
+	edited    int // Tokens at the last edit
+	lastEdit  time.Time
+	messageID int    // The streamed message; 0 until it's sent
+	shown     string // Its text, without the cursor
+	failed    bool   // Sending it failed, so the reply goes out normally
+}
+
+func (b *Bot) newReplyStream(req *Request) *replyStream {
+	return &replyStream{b: b, chatID: req.ChatID, replyTo: req.MessageID, every: b.cfg.StreamEditTokens}
+}
+
+// update receives the answer so far; it's an agent.StreamFunc. Ollama sends
+// about a token per chunk, so each call counts as one. The end of the text
+// is held back until it can't be the start of a secret; finish shows it.
+func (s *replyStream) update(text string) {
+	s.tokens++
+	if s.failed || s.tokens-s.edited < s.every || time.Since(s.lastEdit) < minStreamEditInterval {
+		return
+	}
+	text = strings.TrimSpace(s.b.redactor.RedactPartial(text))
+	if text == "" || text == s.shown {
+		return
+	}
+	s.edited, s.lastEdit, s.shown = s.tokens, time.Now(), text
+
+	if s.messageID != 0 {
+		s.b.out.Send(s.chatID, tgbotapi.NewEditMessageText(s.chatID, s.messageID, text+streamCursor))
+		return
+	}
+	// The first message is sent directly, since edits need its ID
+	msg := tgbotapi.NewMessage(s.chatID, text+streamCursor)
+	msg.ReplyToMessageID = s.replyTo
+	sent, err := s.b.transport.Send(msg)
+	if err != nil || sent.MessageID == 0 {
+		log.Printf("[stream] sending to chat %d: %v", s.chatID, err)
+		s.failed = true
+		return
+	}
+	s.messageID = sent.MessageID
+}
+
+// finish edits the streamed message into the final reply, and reports
+// whether it did. The reply is sent as usual instead when nothing was
+// streamed, or when its markup can't be added by an edit, such as a
+// form's forced reply; the streamed text is then left without its cursor.
+func (s *replyStream) finish(msg tgbotapi.MessageConfig) bool {
+	if s.messageID == 0 {
+		return false
+	}
+	keyboard, inline := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if msg.ReplyMarkup != nil && !inline {
+		s.settle()
+		return false
+	}
+
+	edit := tgbotapi.NewEditMessageText(s.chatID, s.messageID, msg.Text)
+	edit.ParseMode = msg.ParseMode
+	if inline {
+		edit.ReplyMarkup = &keyboard
+	}
+	s.b.out.Send(s.chatID, edit)
+	s.b.replied(s.chatID, s.replyTo, s.messageID)
+	return true
+}
+
+// settle removes the cursor from the streamed text, for runs that end
+// without a reply to put in its place.
+func (s *replyStream) settle() {
+	if s.messageID != 0 {
+		s.b.out.Send(s.chatID, tgbotapi.NewEditMessageText(s.chatID, s.messageID, s.shown))
+	}
+}
@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-bot/agent"
+)
+
+const maxSummaryTurns = 50 // Exchanges /summary recaps, counting back from the latest
+
+// Summarizer is implemented by agents that can recap a conversation for
+// /summary. *agent.Agent implements it.
+type Summarizer interface {
+	Summarize(ctx context.Context, history []agent.Message, notes string) (string, error)
+}
+
+// summaryCommand handles /summary, which recaps the chat's current
+// conversation: decisions made, files created, and open questions.
+func (b *Bot) summaryCommand(ctx context.Context, req *Request) string {
+	summarizer, ok := b.agent.(Summarizer)
+	if !ok {
+		return "Summaries are not available with this agent."
+	}
+
+	head, _ := b.conversationsFor(ctx).parent(req.ChatID, 0)
+	turns := b.conversationsFor(ctx).thread(req.ChatID, head, maxSummaryTurns)
+	if len(turns) == 0 {
+		return "Nothing to summarize yet: this conversation has no messages."
+	}
+
+	// Tell the model which files the conversation's requests changed, since
+	// the replies don't always say
+	var notes string
+	if b.undo != nil {
+		requests := make([]string, len(turns))
+		for i, t := range turns {
+			requests[i] = t.User
+		}
+		if changes := b.undo.changes(ctx, req.ChatID, requests); len(changes) > 0 {
+			var sb strings.Builder
+			sb.WriteString("Workspace files changed during the conversation:\n")
+			for _, c := range changes {
+				fmt.Fprintf(&sb, "- %s (%s)\n", c.Path, c.Status)
+			}
+			notes = sb.String()
+		}
+	}
+
+	summary, err := summarizer.Summarize(ctx, turnMessages(turns), notes)
+	if err != nil {
+		log.Printf("Summary error: %v", err)
+		return "Sorry, I couldn't summarize the conversation. Please try again in a moment."
+	}
+	return fmt.Sprintf("📝 Recap of the last %d exchange(s):\n\n%s", len(turns), summary)
+}
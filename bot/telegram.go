@@ -0,0 +1,285 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/tools"
+)
+
+// Messenger is the part of the Telegram Bot API the bot uses.
+// *tgbotapi.BotAPI implements it; tests can substitute a fake.
+type Messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	// Request calls API methods that don't return a message, such as
+	// answering a button press.
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	// MakeRequest calls an API method with raw parameters, for options
+	// the library doesn't have, such as setWebhook's secret_token.
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+	GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
+	StopReceivingUpdates()
+}
+
+// telegramTransport receives updates by long polling, or from Telegram
+// posting them to a webhook.
+type telegramTransport struct {
+	bot     Messenger
+	token   string // For downloading files, whose URLs include it
+	handled *updateTracker
+	webhook *Webhook // Nil to poll
+}
+
+func (t *telegramTransport) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if _, ok := c.(tgbotapi.CallbackConfig); ok {
+		// Answering a button press returns true rather than a message
+		_, err := t.bot.Request(c)
+		return tgbotapi.Message{}, err
+	}
+	return t.bot.Send(c)
+}
+
+// IsMember reports whether the user currently belongs to the group chat.
+func (t *telegramTransport) IsMember(chatID, userID int64) (bool, error) {
+	resp, err := t.bot.Request(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		return false, err
+	}
+	var member tgbotapi.ChatMember
+	if err := json.Unmarshal(resp.Result, &member); err != nil {
+		return false, fmt.Errorf("parsing chat member: %w", err)
+	}
+	if member.Status == "restricted" {
+		return member.IsMember, nil
+	}
+	return !member.HasLeft() && !member.WasKicked(), nil
+}
+
+// BotRights reports what the bot may do in a chat. Bots can pin messages
+// in private chats without being an admin.
+func (t *telegramTransport) BotRights(chatID int64) (tools.ChatRights, error) {
+	if chatID > 0 {
+		return tools.ChatRights{CanPin: true}, nil
+	}
+	// A bot token starts with the bot's user ID
+	id, _, _ := strings.Cut(t.token, ":")
+	botID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return tools.ChatRights{}, fmt.Errorf("the bot's user ID is unknown")
+	}
+	return t.Rights(chatID, botID)
+}
+
+// Rights reports what a user may do in a group chat.
+func (t *telegramTransport) Rights(chatID, userID int64) (tools.ChatRights, error) {
+	resp, err := t.bot.Request(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		return tools.ChatRights{}, err
+	}
+	var member tgbotapi.ChatMember
+	if err := json.Unmarshal(resp.Result, &member); err != nil {
+		return tools.ChatRights{}, fmt.Errorf("parsing chat member: %w", err)
+	}
+	switch member.Status {
+	case "creator":
+		return tools.ChatRights{Admin: true, CanPin: true, CanChangeInfo: true}, nil
+	case "administrator":
+		return tools.ChatRights{Admin: true, CanPin: member.CanPinMessages, CanChangeInfo: member.CanChangeInfo}, nil
+	default:
+		return tools.ChatRights{}, nil
+	}
+}
+
+// Post sends a message right away, rather than through the outbox, and
+// returns its ID.
+func (t *telegramTransport) Post(chatID int64, text string) (int, error) {
+	msg, err := t.bot.Send(tgbotapi.NewMessage(chatID, text))
+	if err != nil {
+		return 0, err
+	}
+	return msg.MessageID, nil
+}
+
+// Pin pins a message without notifying the chat's members.
+func (t *telegramTransport) Pin(chatID int64, messageID int) error {
+	_, err := t.bot.Request(tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: messageID, DisableNotification: true})
+	return err
+}
+
+func (t *telegramTransport) Unpin(chatID int64, messageID int) error {
+	_, err := t.bot.Request(tgbotapi.UnpinChatMessageConfig{ChatID: chatID, MessageID: messageID})
+	return err
+}
+
+func (t *telegramTransport) SetTitle(chatID int64, title string) error {
+	_, err := t.bot.Request(tgbotapi.SetChatTitleConfig{ChatID: chatID, Title: title})
+	return err
+}
+
+func (t *telegramTransport) SetDescription(chatID int64, description string) error {
+	_, err := t.bot.Request(tgbotapi.SetChatDescriptionConfig{ChatID: chatID, Description: description})
+	return err
+}
+
+// SendPoll posts a native poll.
+func (t *telegramTransport) SendPoll(chatID int64, poll tools.NewPoll) (int, string, error) {
+	config := tgbotapi.NewPoll(chatID, poll.Question, poll.Options...)
+	config.IsAnonymous = poll.Anonymous
+	config.AllowsMultipleAnswers = poll.Multiple
+	msg, err := t.bot.Send(config)
+	if err != nil {
+		return 0, "", err
+	}
+	if msg.Poll == nil {
+		return 0, "", fmt.Errorf("sending poll: no poll in the reply")
+	}
+	return msg.MessageID, msg.Poll.ID, nil
+}
+
+// StopPoll closes a poll the bot posted and returns its final counts.
+func (t *telegramTransport) StopPoll(chatID int64, messageID int) (tools.PollCounts, error) {
+	resp, err := t.bot.Request(tgbotapi.NewStopPoll(chatID, messageID))
+	if err != nil {
+		return tools.PollCounts{}, err
+	}
+	var poll tgbotapi.Poll
+	if err := json.Unmarshal(resp.Result, &poll); err != nil {
+		return tools.PollCounts{}, fmt.Errorf("parsing poll: %w", err)
+	}
+	return pollCounts(&poll), nil
+}
+
+// DownloadFile fetches a file a user sent. Errors leave out the file URL,
+// which contains the bot token.
+func (t *telegramTransport) DownloadFile(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	resp, err := t.bot.Request(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("looking up file: %w", err)
+	}
+	var file tgbotapi.File
+	if err := json.Unmarshal(resp.Result, &file); err != nil {
+		return nil, fmt.Errorf("parsing file: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.Link(t.token), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	download, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("downloading file: %w", err)
+	}
+	if download.StatusCode != http.StatusOK {
+		download.Body.Close()
+		return nil, fmt.Errorf("downloading file: %s", download.Status)
+	}
+	return download.Body, nil
+}
+
+func (t *telegramTransport) Run(ctx context.Context, handle func(*Request)) error {
+	var updates <-chan tgbotapi.Update
+	if t.webhook != nil {
+		var err error
+		if updates, err = t.serveWebhook(ctx); err != nil {
+			return err
+		}
+	} else {
+		// Telegram refuses to be polled while a webhook is set, as one may
+		// be from an earlier run in webhook mode
+		if _, err := t.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Printf("Deleting webhook: %v", err)
+		}
+		// Resume from the last handled update so restarts neither replay nor skip messages
+		u := tgbotapi.NewUpdate(t.handled.Offset())
+		u.Timeout = 60
+		updates = t.bot.GetUpdatesChan(u)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if t.webhook == nil {
+				t.bot.StopReceivingUpdates()
+			}
+			return nil
+		case update := <-updates:
+			if !t.handled.MarkHandled(update.UpdateID) {
+				log.Printf("Skipping duplicate update %d", update.UpdateID)
+				continue
+			}
+			switch {
+			case update.Message != nil:
+				handle(requestFromMessage(update.Message))
+			case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+				handle(requestFromCallback(update.CallbackQuery))
+			case update.Poll != nil:
+				// Vote counts changed on a poll the bot posted
+				counts := pollCounts(update.Poll)
+				handle(&Request{Poll: &counts})
+			}
+		}
+	}
+}
+
+// requestFromMessage converts a Telegram message into a Request.
+func requestFromMessage(m *tgbotapi.Message) *Request {
+	req := &Request{
+		ChatID:    m.Chat.ID,
+		MessageID: m.MessageID,
+		UserID:    m.From.ID,
+		UserName:  m.From.UserName,
+		Text:      m.Text,
+		Command:   m.Command(),
+		Args:      m.CommandArguments(),
+	}
+	if m.ReplyToMessage != nil {
+		req.ReplyTo = m.ReplyToMessage.MessageID
+	}
+	if m.Location != nil {
+		req.Location = &tools.Location{Lat: m.Location.Latitude, Lon: m.Location.Longitude}
+	}
+	if m.Document != nil {
+		req.Document = &Document{FileID: m.Document.FileID, Name: m.Document.FileName, Size: m.Document.FileSize}
+		req.Text = m.Caption
+	}
+	return req
+}
+
+// pollCounts reads the vote counts from a poll.
+func pollCounts(p *tgbotapi.Poll) tools.PollCounts {
+	counts := tools.PollCounts{PollID: p.ID, Voters: p.TotalVoterCount, Closed: p.IsClosed}
+	for _, option := range p.Options {
+		counts.Counts = append(counts.Counts, option.VoterCount)
+	}
+	return counts
+}
+
+// requestFromCallback converts an inline keyboard button press into a
+// Request about the message the button is on.
+func requestFromCallback(q *tgbotapi.CallbackQuery) *Request {
+	return &Request{
+		ChatID:    q.Message.Chat.ID,
+		MessageID: q.Message.MessageID,
+		UserID:    q.From.ID,
+		UserName:  q.From.UserName,
+		Button:    &ButtonPress{ID: q.ID, Data: q.Data},
+	}
+}
@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/tenant"
+)
+
+const (
+	defaultAuditShown = 20
+	maxAuditShown     = 100
+)
+
+// withTenant returns a context carrying the user's tenant, when users are
+// isolated from each other. If the tenant can't be opened the request must
+// be refused rather than run against shared state.
+func (b *Bot) withTenant(ctx context.Context, userID int64) (context.Context, error) {
+	if b.tenants == nil {
+		return ctx, nil
+	}
+	t, err := b.tenants.For(userID)
+	if err != nil {
+		return ctx, fmt.Errorf("opening tenant for %d: %w", userID, err)
+	}
+	convs, err := b.tenantConvs.of(t, b.cfg.HistoryTokens)
+	if err != nil {
+		return ctx, fmt.Errorf("opening tenant store for %d: %w", userID, err)
+	}
+	ctx = context.WithValue(ctx, conversationsKey{}, convs)
+	return tenant.WithTenant(ctx, t), nil
+}
+
+type conversationsKey struct{}
+
+// conversationsFor returns the conversations of the context's tenant, or
+// the bot's shared ones when users aren't isolated.
+func (b *Bot) conversationsFor(ctx context.Context) *conversations {
+	if convs, ok := ctx.Value(conversationsKey{}).(*conversations); ok {
+		return convs
+	}
+	return b.conversations
+}
+
+// replied records which message answered a request. The sender doesn't
+// know whose request it was, but message IDs are unique within a chat, so
+// only the conversation holding the request takes it.
+func (b *Bot) replied(chatID int64, requestID, replyID int) {
+	b.conversations.replied(chatID, requestID, replyID)
+	for _, convs := range b.tenantConvs.all() {
+		convs.replied(chatID, requestID, replyID)
+	}
+}
+
+// tenantConversations keeps each tenant's conversations in their own
+// encrypted store, so one tenant's history in a group chat isn't another's.
+type tenantConversations struct {
+	mu    sync.Mutex
+	convs map[string]*conversations
+}
+
+// of returns the tenant's conversations, opening their store on first use.
+func (tc *tenantConversations) of(t *tenant.Tenant, budget int) (*conversations, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if convs, ok := tc.convs[t.ID]; ok {
+		return convs, nil
+	}
+	st, err := t.Store()
+	if err != nil {
+		return nil, err
+	}
+	if tc.convs == nil {
+		tc.convs = make(map[string]*conversations)
+	}
+	convs := newConversations(st, nil, budget)
+	tc.convs[t.ID] = convs
+	return convs, nil
+}
+
+func (tc *tenantConversations) all() []*conversations {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	all := make([]*conversations, 0, len(tc.convs))
+	for _, convs := range tc.convs {
+		all = append(all, convs)
+	}
+	return all
+}
+
+// tenantUnavailable tells the user their request was refused because their
+// tenant couldn't be opened.
+func (b *Bot) tenantUnavailable(req *Request) {
+	const text = "⚠️ Your private storage isn't available right now, so I can't handle this. Please try again later."
+	if req.Button != nil {
+		b.out.Send(req.ChatID, tgbotapi.NewCallback(req.Button.ID, text))
+		return
+	}
+	msg := tgbotapi.NewMessage(req.ChatID, text)
+	msg.ReplyToMessageID = req.MessageID
+	b.out.Send(req.ChatID, msg)
+}
+
+// auditCommand lists the latest tool calls made for the user:
+//
+//	/audit       the last 20
+//	/audit 50    the last 50
+func (b *Bot) auditCommand(ctx context.Context, args string) string {
+	t, ok := tenant.From(ctx)
+	if !ok {
+		return "The audit trail is kept when users are isolated from each other (TENANTS=true)."
+	}
+	n := defaultAuditShown
+	if args = strings.TrimSpace(args); args != "" {
+		v, err := strconv.Atoi(args)
+		if err != nil || v <= 0 {
+			return "Usage: /audit [count]"
+		}
+		n = min(v, maxAuditShown)
+	}
+
+	events, err := t.AuditTrail(n)
+	if err != nil {
+		log.Printf("Reading audit trail of %s: %v", t.ID, err)
+		return "❌ Could not read your audit trail."
+	}
+	if len(events) == 0 {
+		return "No tool calls recorded for you yet."
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🧾 Your last %d tool calls:\n", len(events))
+	for _, e := range events {
+		status := "✅"
+		if e.Error != "" {
+			status = "❌"
+		}
+		fmt.Fprintf(&sb, "\n%s %s %s (%v)", status, e.Time.Local().Format("Jan 2 15:04"), e.Tool, e.Duration.Round(time.Millisecond))
+		if e.Args != "" {
+			fmt.Fprintf(&sb, "\n   %s", truncate(e.Args, 120))
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&sb, "\n   %s", truncate(e.Error, 120))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/snapshot"
+	"telegram-bot/store"
+)
+
+const (
+	undoStoreKey     = "workspace_undo"
+	maxUndoPerChat   = 20
+	maxUndoListed    = 15 // Files named in the /undo reply
+	snapshotTimeout  = 30 * time.Second
+	maxSnapshotTitle = 72
+)
+
+// undoRecord is one agent run that changed the workspace, identified by
+// the snapshots taken before and after it.
+type undoRecord struct {
+	Before   string    `json:"before"`
+	After    string    `json:"after"`
+	Request  string    `json:"request"`
+	Time     time.Time `json:"time"`
+	Reverted bool      `json:"reverted,omitempty"` // With the button on its reply
+}
+
+// workspaceUndo snapshots the workspace around agent runs and keeps a
+// stack of each chat's runs that changed it, so /undo can revert them.
+// Runs in different chats that overlap can't be told apart, so undoing
+// either reverts both.
+type workspaceUndo struct {
+	repo  *snapshot.Repo
+	store *store.Store
+
+	mu     sync.Mutex
+	stacks map[int64][]undoRecord
+}
+
+func newWorkspaceUndo(repo *snapshot.Repo, st *store.Store) *workspaceUndo {
+	u := &workspaceUndo{repo: repo, store: st, stacks: make(map[int64][]undoRecord)}
+	if _, err := st.Get(undoStoreKey, &u.stacks); err != nil {
+		log.Printf("Loading undo history: %v", err)
+	}
+	return u
+}
+
+// begin snapshots the workspace before a run and returns the snapshot ID,
+// or "" if it failed, in which case the run can't be undone.
+func (u *workspaceUndo) begin(ctx context.Context, chatID int64, request string) string {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	before, err := u.repo.Commit(ctx, fmt.Sprintf("Before run in chat %d: %s", chatID, snapshotTitle(request)))
+	if err != nil {
+		log.Printf("Workspace snapshot failed: %v", err)
+		return ""
+	}
+	return before
+}
+
+// finish snapshots the workspace after a run and remembers the run if it
+// changed anything, returning its record; otherwise it returns nil.
+func (u *workspaceUndo) finish(ctx context.Context, chatID int64, before, request string) *undoRecord {
+	if before == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	after, err := u.repo.Commit(ctx, fmt.Sprintf("Chat %d: %s", chatID, snapshotTitle(request)))
+	if err != nil {
+		log.Printf("Workspace snapshot failed: %v", err)
+		return nil
+	}
+	if after == before {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	rec := undoRecord{Before: before, After: after, Request: request, Time: time.Now().UTC()}
+	stack := append(u.stacks[chatID], rec)
+	if len(stack) > maxUndoPerChat {
+		stack = stack[len(stack)-maxUndoPerChat:]
+	}
+	u.stacks[chatID] = stack
+	u.save()
+	return &rec
+}
+
+// undo reverts the file changes of the chat's last run that changed the
+// workspace and returns the reply.
+func (u *workspaceUndo) undo(ctx context.Context, chatID int64) string {
+	u.mu.Lock()
+	// Runs already reverted with their reply's button are skipped
+	stack := u.stacks[chatID]
+	for len(stack) > 0 && stack[len(stack)-1].Reverted {
+		stack = stack[:len(stack)-1]
+	}
+	u.stacks[chatID] = stack
+	if len(stack) == 0 {
+		u.mu.Unlock()
+		return "Nothing to undo: no recent requests in this chat changed the workspace."
+	}
+	last := stack[len(stack)-1]
+	u.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	changes, err := u.repo.Revert(ctx, last.Before, last.After, "Undo: "+snapshotTitle(last.Request))
+	if err != nil {
+		return "⚠️ Undo failed: " + err.Error()
+	}
+
+	u.mu.Lock()
+	u.stacks[chatID] = u.stacks[chatID][:len(u.stacks[chatID])-1]
+	u.save()
+	remaining := len(u.stacks[chatID])
+	u.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "↩️ Undid %d file change(s) from %q:\n", len(changes), truncate(last.Request, 60))
+	for i, c := range changes {
+		if i == maxUndoListed {
+			fmt.Fprintf(&b, "...and %d more\n", len(changes)-maxUndoListed)
+			break
+		}
+		switch c.Status {
+		case "added":
+			fmt.Fprintf(&b, "• removed %s\n", c.Path)
+		case "deleted":
+			fmt.Fprintf(&b, "• restored %s\n", c.Path)
+		default:
+			fmt.Fprintf(&b, "• reverted %s\n", c.Path)
+		}
+	}
+	if remaining > 0 {
+		fmt.Fprintf(&b, "\n/undo again to revert the request before that.")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// changes returns the files changed by the chat's recorded runs for the
+// given requests, combined into one change per file.
+func (u *workspaceUndo) changes(ctx context.Context, chatID int64, requests []string) []snapshot.Change {
+	u.mu.Lock()
+	var records []undoRecord
+	for _, rec := range u.stacks[chatID] {
+		if slices.Contains(requests, truncate(rec.Request, maxTurnTextChars)) {
+			records = append(records, rec)
+		}
+	}
+	u.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	var order []string
+	listed := make(map[string]bool)
+	status := make(map[string]string)
+	for _, rec := range records {
+		changes, err := u.repo.Changes(ctx, rec.Before, rec.After)
+		if err != nil {
+			log.Printf("Listing workspace changes: %v", err)
+			continue
+		}
+		for _, c := range changes {
+			if !listed[c.Path] {
+				listed[c.Path] = true
+				order = append(order, c.Path)
+			}
+			prev := status[c.Path]
+			switch {
+			case prev == "added" && c.Status == "deleted":
+				delete(status, c.Path) // Created and removed again
+			case prev == "added":
+				// Still new as far as the conversation is concerned
+			default:
+				status[c.Path] = c.Status
+			}
+		}
+	}
+
+	var result []snapshot.Change
+	for _, path := range order {
+		if st, ok := status[path]; ok {
+			result = append(result, snapshot.Change{Path: path, Status: st})
+		}
+	}
+	return result
+}
+
+// save stores the undo stacks. The caller must hold u.mu.
+func (u *workspaceUndo) save() {
+	if err := u.store.Set(undoStoreKey, u.stacks); err != nil {
+		log.Printf("Saving undo history: %v", err)
+	}
+}
+
+// snapshotTitle shortens a request to one line for a snapshot message.
+func snapshotTitle(request string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(request), "\n")
+	return truncate(title, maxSnapshotTitle)
+}
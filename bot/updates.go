@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"log"
+	"sync"
+
+	"telegram-bot/store"
+)
+
+const (
+	updatesStoreKey = "telegram_updates"
+	maxSeenUpdates  = 1000
+)
+
+// updateLog is the persisted record of handled Telegram updates.
+type updateLog struct {
+	LastID int   `json:"last_id"`
+	Seen   []int `json:"seen"`
+}
+
+// updateTracker remembers which updates have been handled so a restart
+// resumes from the right offset and never handles an update twice.
+type updateTracker struct {
+	st *store.Store
+
+	mu   sync.Mutex
+	log  updateLog
+	seen map[int]bool
+}
+
+func newUpdateTracker(st *store.Store) *updateTracker {
+	t := &updateTracker{st: st, seen: make(map[int]bool)}
+	if _, err := st.Get(updatesStoreKey, &t.log); err != nil {
+		log.Printf("Loading update offset: %v", err)
+	}
+	for _, id := range t.log.Seen {
+		t.seen[id] = true
+	}
+	return t
+}
+
+// Offset returns the update offset to resume polling from.
+func (t *updateTracker) Offset() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.log.LastID == 0 {
+		return 0
+	}
+	return t.log.LastID + 1
+}
+
+// MarkHandled records an update, returning false if it was already handled.
+func (t *updateTracker) MarkHandled(id int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.seen[id] {
+		return false
+	}
+
+	t.seen[id] = true
+	t.log.Seen = append(t.log.Seen, id)
+	if len(t.log.Seen) > maxSeenUpdates {
+		delete(t.seen, t.log.Seen[0])
+		t.log.Seen = t.log.Seen[1:]
+	}
+	if id > t.log.LastID {
+		t.log.LastID = id
+	}
+
+	if err := t.st.Save(updatesStoreKey, t.log); err != nil {
+		log.Printf("Saving update offset: %v", err)
+	}
+	return true
+}
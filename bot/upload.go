@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/auth"
+	"telegram-bot/tenant"
+)
+
+const (
+	uploadDir      = "uploads"
+	maxUploadBytes = 20 << 20 // The most the Bot API lets bots download
+)
+
+var unsafeUploadChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Document is a file sent to the bot.
+type Document struct {
+	FileID string
+	Name   string
+	Size   int
+}
+
+// fileDownloader is implemented by transports that can fetch files users
+// send.
+type fileDownloader interface {
+	DownloadFile(ctx context.Context, fileID string) (io.ReadCloser, error)
+}
+
+// saveUpload stores a file the user sent in the workspace's uploads
+// folder, where tools such as python and health can read it.
+func (b *Bot) saveUpload(ctx context.Context, req *Request) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(req.ChatID, text)
+		msg.ReplyToMessageID = req.MessageID
+		b.out.Send(req.ChatID, msg)
+	}
+
+	downloader, ok := b.transport.(fileDownloader)
+	switch {
+	case auth.RoleFrom(ctx) < auth.Trusted:
+		reply("⛔ Only trusted users can upload files to the workspace.")
+		return
+	case !ok || b.cfg.PythonWorkspace == "":
+		reply("Uploading files is not available here.")
+		return
+	case req.Document.Size > maxUploadBytes:
+		reply(fmt.Sprintf("That file is %d MB; Telegram only lets bots download files up to %d MB. Copy it into the workspace directly instead.", req.Document.Size>>20, maxUploadBytes>>20))
+		return
+	}
+
+	name := strings.Trim(unsafeUploadChars.ReplaceAllString(filepath.Base(req.Document.Name), "_"), "_.")
+	if name == "" {
+		name = "upload"
+	}
+	rel := filepath.Join(uploadDir, name)
+	if err := b.download(ctx, downloader, req.Document.FileID, filepath.Join(tenant.Workspace(ctx, b.cfg.PythonWorkspace), rel)); err != nil {
+		log.Printf("[upload] saving %s: %v", rel, err)
+		reply("❌ Could not save the file: " + err.Error())
+		return
+	}
+	log.Printf("[upload] %s saved %s (%d bytes)", req.UserName, rel, req.Document.Size)
+	if isPatchFile(name) && b.registry.Available(ctx, "review") {
+		reply(fmt.Sprintf("📥 Saved %s to the workspace. Reviewing it...", rel))
+		b.reviewUpload(ctx, req, rel)
+		return
+	}
+	reply(fmt.Sprintf("📥 Saved %s to the workspace. Ask me about it.", rel))
+}
+
+func (b *Bot) download(ctx context.Context, downloader fileDownloader, fileID, path string) error {
+	body, err := downloader.DownloadFile(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write beside the target first so a failed download doesn't clobber an earlier upload
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, io.LimitReader(body, maxUploadBytes+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("downloading: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
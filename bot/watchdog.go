@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/agent"
+	"telegram-bot/runs"
+)
+
+const (
+	watchdogInterval = 10 * time.Second
+	stuckRunsFile    = "stuck_runs.jsonl" // In the state directory
+	maxProgressSteps = 8                  // Steps shown to the user; the file has them all
+	maxStuckShown    = 3                  // Runs /debug stuck shows
+)
+
+// tracer is implemented by agents that report their model and tool calls.
+// *agent.Agent implements it.
+type tracer interface {
+	OnAfterModelCall(fn func(ctx context.Context, call *agent.ModelCall))
+	OnAfterToolCall(fn func(ctx context.Context, call *agent.ToolInvocation))
+}
+
+// stuckRun is a run the watchdog stopped, as recorded for debugging.
+type stuckRun struct {
+	ID       int64       `json:"id"`
+	ChatID   int64       `json:"chat_id"`
+	UserName string      `json:"user"`
+	Text     string      `json:"text"`
+	Started  time.Time   `json:"started"`
+	Stopped  time.Time   `json:"stopped"`
+	Trace    []traceStep `json:"trace"`
+}
+
+type traceStep struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// traceRuns records the agent's model and tool calls in the trace of the
+// run they belong to, so a stopped run can report how far it got.
+func (b *Bot) traceRuns() {
+	t, ok := b.agent.(tracer)
+	if !ok {
+		return
+	}
+	t.OnAfterModelCall(func(ctx context.Context, call *agent.ModelCall) {
+		b.runs.Record(ctx, modelStep(call))
+	})
+	t.OnAfterToolCall(func(ctx context.Context, call *agent.ToolInvocation) {
+		b.runs.Record(ctx, toolStep(call))
+	})
+}
+
+func modelStep(call *agent.ModelCall) string {
+	took := call.Duration.Round(100 * time.Millisecond)
+	switch {
+	case call.Err != nil:
+		return fmt.Sprintf("model call failed after %v: %v", took, call.Err)
+	case call.Response != nil && len(call.Response.Message.ToolCalls) > 0:
+		var names []string
+		for _, tc := range call.Response.Message.ToolCalls {
+			names = append(names, tc.Function.Name)
+		}
+		return fmt.Sprintf("model asked for %s (%v)", strings.Join(names, ", "), took)
+	default:
+		return fmt.Sprintf("model answered (%v)", took)
+	}
+}
+
+func toolStep(call *agent.ToolInvocation) string {
+	args, _ := json.Marshal(call.Args)
+	step := fmt.Sprintf("%s %s", call.Name, truncate(string(args), 120))
+	took := call.Duration.Round(100 * time.Millisecond)
+	if call.Err != nil {
+		return fmt.Sprintf("%s failed after %v: %s", step, took, truncate(call.Err.Error(), 120))
+	}
+	return fmt.Sprintf("%s (%v)", step, took)
+}
+
+// scheduleWatchdog registers the job that stops agent runs going past
+// RUN_BUDGET. Cancelling a run's context stops its model calls and kills
+// the processes its tools started.
+func (b *Bot) scheduleWatchdog() {
+	if b.cfg.RunBudget <= 0 {
+		return
+	}
+	b.scheduler.Every("run watchdog", watchdogInterval, func(ctx context.Context) error {
+		for _, run := range b.runs.Overdue(b.cfg.RunBudget) {
+			b.stopRun(run)
+		}
+		return nil
+	})
+}
+
+// stopRun reports a run the watchdog cancelled: to the user, with what it
+// had done so far, and to the owners. Its trace is saved for debugging.
+func (b *Bot) stopRun(run runs.Run) {
+	took := time.Since(run.Started).Round(time.Second)
+	log.Printf("[watchdog] stopped run #%d from %s after %v: %s", run.ID, run.UserName, took, truncate(run.Text, 60))
+	if err := b.saveStuckRun(run); err != nil {
+		log.Printf("[watchdog] saving trace of run #%d: %v", run.ID, err)
+	}
+
+	msg := tgbotapi.NewMessage(run.ChatID, b.redactor.Redact(stoppedReply(run, took)))
+	msg.ReplyToMessageID = run.MessageID
+	b.out.Send(run.ChatID, msg)
+
+	b.notifier.Notify(fmt.Sprintf("⏱ Stopped a run from @%s after %v: %s\nIts trace is in %s; see /debug stuck.",
+		run.UserName, took, truncate(run.Text, 100), stuckRunsFile))
+}
+
+// stoppedReply tells the user their request was stopped and how far it got.
+func stoppedReply(run runs.Run, took time.Duration) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "⏱ This was taking too long, so I stopped after %v.", took)
+	if len(run.Trace) == 0 {
+		sb.WriteString(" I hadn't got anywhere yet; the model may be overloaded.")
+		return sb.String()
+	}
+
+	sb.WriteString("\n\nWhat I got through:")
+	steps := run.Trace
+	if len(steps) > maxProgressSteps {
+		fmt.Fprintf(&sb, "\n… (%d earlier steps)", len(steps)-maxProgressSteps)
+		steps = steps[len(steps)-maxProgressSteps:]
+	}
+	for _, step := range steps {
+		sb.WriteString("\n• " + step.Text)
+	}
+	sb.WriteString("\n\nTry asking for something smaller, or in steps.")
+	return sb.String()
+}
+
+// saveStuckRun appends the run's trace to the stuck runs file.
+func (b *Bot) saveStuckRun(run runs.Run) error {
+	record := stuckRun{
+		ID:       run.ID,
+		ChatID:   run.ChatID,
+		UserName: run.UserName,
+		Text:     run.Text,
+		Started:  run.Started,
+		Stopped:  time.Now(),
+	}
+	for _, step := range run.Trace {
+		record.Trace = append(record.Trace, traceStep{Time: step.Time, Text: step.Text})
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(b.cfg.StateDir, stuckRunsFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", stuckRunsFile, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append([]byte(b.redactor.Redact(string(line))), '\n')); err != nil {
+		return fmt.Errorf("writing %s: %w", stuckRunsFile, err)
+	}
+	return nil
+}
+
+// debugStuck shows the last runs the watchdog stopped, for /debug stuck.
+func debugStuck(stateDir string) string {
+	f, err := os.Open(filepath.Join(stateDir, stuckRunsFile))
+	if os.IsNotExist(err) {
+		return "⏱ No runs have been stopped"
+	}
+	if err != nil {
+		return "⚠️ " + err.Error()
+	}
+	defer f.Close()
+
+	var stuck []stuckRun
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var run stuckRun
+		if json.Unmarshal(scanner.Bytes(), &run) == nil {
+			stuck = append(stuck, run)
+		}
+	}
+	if len(stuck) == 0 {
+		return "⏱ No runs have been stopped"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "⏱ %d stopped runs; the latest:", len(stuck))
+	for _, run := range stuck[max(0, len(stuck)-maxStuckShown):] {
+		fmt.Fprintf(&sb, "\n\n#%d @%s, %s, after %v: %s", run.ID, run.UserName,
+			run.Stopped.Local().Format("Jan 2 15:04"), run.Stopped.Sub(run.Started).Round(time.Second), truncate(run.Text, 60))
+		steps := run.Trace
+		if len(steps) > maxProgressSteps {
+			fmt.Fprintf(&sb, "\n  … (%d earlier steps)", len(steps)-maxProgressSteps)
+			steps = steps[len(steps)-maxProgressSteps:]
+		}
+		for _, step := range steps {
+			fmt.Fprintf(&sb, "\n  %s %s", step.Time.Local().Format("15:04:05"), truncate(step.Text, 100))
+		}
+	}
+	return sb.String()
+}
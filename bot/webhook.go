@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxWebhookBody = 1 << 20 // Updates are a few KB; files aren't included
+
+// Webhook is where Telegram posts updates instead of being polled for them.
+type Webhook struct {
+	URL      string // Public HTTPS address Telegram posts to, e.g. https://bot.example.com/telegram
+	Listen   string // Address to serve on, e.g. :8443
+	CertFile string // TLS certificate and key, to serve HTTPS directly;
+	KeyFile  string // empty serves plain HTTP for a reverse proxy in front
+}
+
+// webhookSecret is the secret_token Telegram sends with every update, in
+// the X-Telegram-Bot-Api-Secret-Token header. It's derived from the token,
+// so only Telegram knows it, and it's the same across restarts.
+func webhookSecret(token string) string {
+	sum := sha256.Sum256([]byte("webhook:" + token))
+	return hex.EncodeToString(sum[:16])
+}
+
+// serveWebhook registers the webhook with Telegram and serves it until ctx
+// is done, delivering the updates posted to it on the returned channel.
+func (t *telegramTransport) serveWebhook(ctx context.Context) (<-chan tgbotapi.Update, error) {
+	secret := webhookSecret(t.token)
+	config, err := tgbotapi.NewWebhook(t.webhook.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing WEBHOOK_URL: %w", err)
+	}
+	if config.URL.Scheme != "https" {
+		return nil, fmt.Errorf("WEBHOOK_URL must be https, as Telegram requires")
+	}
+
+	if (t.webhook.CertFile == "") != (t.webhook.KeyFile == "") {
+		return nil, fmt.Errorf("WEBHOOK_TLS_CERT and WEBHOOK_TLS_KEY must be set together")
+	}
+
+	ln, err := net.Listen("tcp", t.webhook.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("listening for webhook: %w", err)
+	}
+	// The library's WebhookConfig has no secret_token, so the call is made
+	// by hand
+	params := tgbotapi.Params{"url": config.URL.String(), "secret_token": secret}
+	if _, err := t.bot.MakeRequest("setWebhook", params); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting webhook: %w", err)
+	}
+
+	updates := make(chan tgbotapi.Update, 100)
+	srv := &http.Server{
+		// Any path, in case a reverse proxy strips a prefix
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+			if r.Method != http.MethodPost || subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+				http.NotFound(w, r)
+				return
+			}
+			var update tgbotapi.Update
+			if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBody)).Decode(&update); err != nil {
+				http.Error(w, "invalid update", http.StatusBadRequest)
+				return
+			}
+			select {
+			case updates <- update:
+			case <-r.Context().Done():
+				// Telegram retries updates that aren't acknowledged
+			}
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		var err error
+		if t.webhook.CertFile != "" {
+			err = srv.ServeTLS(ln, t.webhook.CertFile, t.webhook.KeyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Webhook server error: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Receiving updates at %s on %s", t.webhook.URL, t.webhook.Listen)
+	return updates, nil
+}
@@ -2,33 +2,285 @@
 package config
 
 import (
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all application configuration.
 type Config struct {
 	TelegramToken     string
-	OllamaURL         string
+	WebhookURL        string // Public HTTPS address for Telegram to post updates to; empty polls instead
+	WebhookListen     string
+	WebhookCert       string   // TLS certificate and key to serve the webhook with;
+	WebhookKey        string   // empty serves plain HTTP behind a reverse proxy
+	OllamaURLs        []string // Chat endpoints of interchangeable Ollama instances
+	OllamaHealthEvery time.Duration
+	OllamaSmallModel  string // Tried first for trivial messages; empty disables
+	OllamaEmbedModel  string // For search by meaning; empty disables
 	OllamaModel       string
+	LLMProvider       string // ollama, openai, or anthropic, for chat
+	LLMAPIURL         string // The hosted API's endpoint; empty uses the provider's
+	LLMAPIKey         string
+	LLMModel          string // The hosted chat model
+	LLMSmallModel     string // Like OllamaSmallModel, on the hosted API
 	GoogleClientID    string
 	GoogleSecret      string
 	GoogleRedirectURL string
 	GoogleTokenFile   string
+	SpotifyClientID   string // Empty disables the spotify tool
+	SpotifySecret     string
+	SpotifyRedirect   string // Must be registered with the Spotify app
+	SpotifyTokenFile  string
 	PythonWorkspace   string
+	WorkspaceUndo     bool // Snapshot the workspace around agent runs for /undo
+	PythonPackages    []string
+	BashAllowedDirs   []string
+	Isolation         string   // firejail or runsc, to run tool commands under; empty runs them directly
+	IsolationProfiles []string // Per-tool overrides, e.g. bash=nonet
+	EgressPolicyFile  string   // JSON hosts each tool's commands may reach; empty leaves them unrestricted
+	EgressBridge      string   // Bridge the proxy listens on, and firejail puts networked commands on
+	EgressNetwork     string   // Internal container network, on EgressBridge, for networked containers
+	ContainerRuntime  string   // podman or docker, to run python and bash code in; empty runs it on the host
+	ContainerImage    string
+	ContainerMemory   string // e.g. 512m
+	ContainerCPUs     string
+	ContainerNetwork  bool
+	ToolCache         []string // How long to keep results by tool or tool.operation, e.g. scrape=10m
+	SandboxPython     string   // CPython built for WASI, for the sandbox tool; empty leaves Python out
+	SandboxPythonHome string   // The standard library prefix for SandboxPython
+	SandboxJS         string   // QuickJS built for WASI; empty leaves JavaScript out
+	SandboxMemoryMB   int
+	SandboxTimeout    time.Duration
+	OCIEnvironments   []string // name=registry/namespace pairs, in promotion order
+	OCISignKey        string
+	OCIWatchInterval  time.Duration
+	OCICLI            bool // Use skopeo and oras instead of the built-in registry client
+	ScrapeAuthFile    string
+	ScrapeWatchEvery  time.Duration
+	ScrapeBrowser     string
+	RecipeAPIURL      string // TheMealDB-compatible API; empty uses the public one
+	RecipeSites       []string
+	NominatimURL      string // OpenStreetMap geocoding, for the places tool
+	OverpassURL       string // OpenStreetMap queries, for the places tool
+	RoutingProvider   string // osrm, otp, or google, for the directions tool
+	RoutingURL        string // The routing provider's endpoint; empty uses its default
+	GoogleMapsKey     string
+	FlightAPIKey      string // aviationstack, for flight status
+	FlightAPIURL      string
+	ParcelAPIKey      string // 17TRACK, for parcel tracking
+	ParcelAPIURL      string
+	CarriersFile      string // Carriers' own tracking APIs, used instead of 17TRACK
+	TrackingInterval  time.Duration
+	HealthDataDir     string        // Workspace folder with fitness exports, for the health tool
+	HealthUnits       string        // metric or imperial
+	TMDBAPIKey        string        // Films and shows for the media tool; empty limits it to books
+	MediaRegion       string        // Country whose streaming services are listed
+	GitHubToken       string        // Empty disables the github tool and /fix
+	GitHubAPIURL      string        // For GitHub Enterprise; github.com by default
+	AllowedLicenses   []string      // SPDX IDs allowed in SBOMs; empty uses the deps tool's permissive list
+	TerraformDirs     []string      // name=path of configurations the terraform tool may plan and apply; empty disables it
+	CloudProviders    []string      // aws and/or gcp, queried with the host's credentials; empty disables the cloud tool
+	AWSRegions        []string      // Empty uses the aws CLI's default region
+	GCPProject        string        // Empty uses the credentials' project
+	GCPBillingTable   string        // BigQuery billing export, for Google Cloud spend
+	CloudWrites       bool          // Let the cloud tool start and stop instances, with approval
+	CloudflareToken   string        // Lets the dns tool list and change records in Cloudflare zones
+	DNSResolverURL    string        // DNS-over-HTTPS JSON endpoint for lookups
+	CertDomains       []string      // Domains whose TLS certificates are checked daily
+	CertWarnDays      int           // Alert the owners when a certificate expires within this many days
+	CertCheckTime     string        // HH:MM local time for the daily certificate check
+	UptimeInterval    time.Duration // How often the uptime monitors are checked
+	MQTTURL           string        // mqtt:// or mqtts:// broker for the mqtt tool; empty leaves it out
+	MQTTUsername      string        // If the broker asks for a login
+	MQTTPassword      string        // Masked in replies like other secrets
+	PresenceDevices   []string      // name=MAC pairs of phones whose owners are home while they're on the LAN
+	PresenceSubnets   []string      // Subnets swept for them; empty sweeps the host's private networks
+	PresenceInterval  time.Duration // How often the LAN is swept
+	PresenceAwayAfter time.Duration // How long someone's devices must be gone before they've left
+	BackupPaths       []string      // Empty backs up the workspace, state, and config files
+	BackupDestination string        // s3://bucket/prefix, rclone remote:path, or a directory; empty disables backups
+	BackupRecipients  []string      // age public keys backups are encrypted to
+	BackupTime        string        // HH:MM local time for the daily backup; empty makes them on request only
+	OwnerIDs          []int64
+	TrustedIDs        []int64
+	GuestTools        []string
+	AllowedUserIDs    []int64 // With AllowedChatIDs, the only users besides owners and trusted ones the bot answers
+	AllowedChatIDs    []int64 // Group chats whose members are all answered
+	Pairing           bool    // Without OwnerIDs, lock the bot until someone opens a one-time link
+	Tenants           bool    // Give each user their own workspace, encrypted state, and audit trail
+	TenantKey         string  // Master key tenants' keys are derived from; required with Tenants
+	StateDir          string
+	ShutdownTimeout   time.Duration
+	MaxConcurrentRuns int           // Agent runs allowed at once; zero means unlimited
+	HistoryTurns      int           // Earlier exchanges sent to the model with each message
+	HistoryTokens     int           // Rough cap on the tokens of that history; zero means unlimited
+	StreamEditTokens  int           // Show answers as they're written, editing the reply every this many tokens; zero disables
+	RunBudget         time.Duration // Agent runs are stopped after this; zero means never
+	BriefingTime      string        // HH:MM local time for the daily briefing; empty disables
+	BriefingLocation  string        // For the briefing's weather; empty leaves it out
+	BriefingChatID    int64         // Where the briefing goes; zero means the first owner
+	ShoppingChatID    int64         // Group whose members share the shopping list; zero disables it
+	ClipTTL           time.Duration // How long /clip keeps text and files
+	ClipAddr          string        // Serves clips for browsers here, e.g. :8090; empty disables web links
+	ClipURL           string        // Public URL of ClipAddr, for the links
+	EventSourcesFile  string        // JSON services allowed to post events, and their chats
+	EventsAddr        string
+	DebugAddr         string
+	DebugToken        string
+
+	// Daily per-user quotas; zero means unlimited
+	QuotaMaxRequests    int
+	QuotaMaxToolSeconds int
+	QuotaMaxScrapeBytes int64
 }
 
 // Load reads configuration from environment variables with sensible defaults.
 func Load() *Config {
 	return &Config{
 		TelegramToken:     os.Getenv("TELEGRAM_BOT_TOKEN"),
-		OllamaURL:         getEnvOrDefault("OLLAMA_URL", "http://localhost:11434/api/chat"),
+		WebhookURL:        os.Getenv("WEBHOOK_URL"),
+		WebhookListen:     getEnvOrDefault("WEBHOOK_LISTEN", ":8443"),
+		WebhookCert:       os.Getenv("WEBHOOK_TLS_CERT"),
+		WebhookKey:        os.Getenv("WEBHOOK_TLS_KEY"),
+		OllamaURLs:        getEnvListOrDefault("OLLAMA_URL", "http://localhost:11434/api/chat"),
+		OllamaHealthEvery: getEnvDuration("OLLAMA_HEALTH_INTERVAL", 30*time.Second),
+		OllamaSmallModel:  os.Getenv("OLLAMA_SMALL_MODEL"),
+		OllamaEmbedModel:  os.Getenv("OLLAMA_EMBED_MODEL"),
 		OllamaModel:       getEnvOrDefault("OLLAMA_MODEL", "qwen3-coder:30b"),
+		LLMProvider:       getEnvOrDefault("LLM_PROVIDER", "ollama"),
+		LLMAPIURL:         os.Getenv("LLM_API_URL"),
+		LLMAPIKey:         os.Getenv("LLM_API_KEY"),
+		LLMModel:          os.Getenv("LLM_MODEL"),
+		LLMSmallModel:     os.Getenv("LLM_SMALL_MODEL"),
 		GoogleClientID:    os.Getenv("GOOGLE_CLIENT_ID"),
 		GoogleSecret:      os.Getenv("GOOGLE_CLIENT_SECRET"),
 		GoogleRedirectURL: getEnvOrDefault("GOOGLE_REDIRECT_URL", "urn:ietf:wg:oauth:2.0:oob"),
 		GoogleTokenFile:   getEnvOrDefault("GOOGLE_TOKEN_FILE", "google_token.json"),
+		SpotifyClientID:   os.Getenv("SPOTIFY_CLIENT_ID"),
+		SpotifySecret:     os.Getenv("SPOTIFY_CLIENT_SECRET"),
+		SpotifyRedirect:   getEnvOrDefault("SPOTIFY_REDIRECT_URL", "http://127.0.0.1:8888/callback"),
+		SpotifyTokenFile:  getEnvOrDefault("SPOTIFY_TOKEN_FILE", "spotify_token.json"),
 		PythonWorkspace:   getEnvOrDefault("PYTHON_WORKSPACE", "workspace"),
+		WorkspaceUndo:     getEnvBool("WORKSPACE_SNAPSHOTS", true),
+		PythonPackages:    getEnvList("PYTHON_PACKAGES"),
+		BashAllowedDirs:   getEnvList("BASH_ALLOWED_DIRS"),
+		Isolation:         os.Getenv("ISOLATION"),
+		IsolationProfiles: getEnvList("ISOLATION_PROFILES"),
+		EgressPolicyFile:  os.Getenv("EGRESS_POLICY_FILE"),
+		EgressBridge:      os.Getenv("EGRESS_BRIDGE"),
+		EgressNetwork:     os.Getenv("EGRESS_NETWORK"),
+		ContainerRuntime:  os.Getenv("CONTAINER_RUNTIME"),
+		ContainerImage:    os.Getenv("CONTAINER_IMAGE"),
+		ContainerMemory:   getEnvOrDefault("CONTAINER_MEMORY", "512m"),
+		ContainerCPUs:     getEnvOrDefault("CONTAINER_CPUS", "1"),
+		ContainerNetwork:  getEnvBool("CONTAINER_NETWORK", false),
+		ToolCache:         getEnvList("TOOL_CACHE"),
+		SandboxPython:     os.Getenv("SANDBOX_PYTHON_WASM"),
+		SandboxPythonHome: os.Getenv("SANDBOX_PYTHON_HOME"),
+		SandboxJS:         os.Getenv("SANDBOX_JS_WASM"),
+		SandboxMemoryMB:   int(getEnvInt64("SANDBOX_MEMORY_MB", 128)),
+		SandboxTimeout:    getEnvDuration("SANDBOX_TIMEOUT", 10*time.Second),
+		OCIEnvironments:   getEnvList("OCI_ENVIRONMENTS"),
+		OCISignKey:        os.Getenv("OCI_SIGN_KEY"),
+		OCIWatchInterval:  getEnvDuration("OCI_WATCH_INTERVAL", time.Hour),
+		OCICLI:            getEnvBool("OCI_CLI", false),
+		ScrapeAuthFile:    os.Getenv("SCRAPE_AUTH_FILE"),
+		ScrapeWatchEvery:  getEnvDuration("SCRAPE_WATCH_INTERVAL", time.Hour),
+		ScrapeBrowser:     os.Getenv("SCRAPE_BROWSER"),
+		RecipeAPIURL:      os.Getenv("RECIPE_API_URL"),
+		RecipeSites:       getEnvList("RECIPE_SITES"),
+		NominatimURL:      getEnvOrDefault("NOMINATIM_URL", "https://nominatim.openstreetmap.org"),
+		OverpassURL:       getEnvOrDefault("OVERPASS_URL", "https://overpass-api.de/api/interpreter"),
+		RoutingProvider:   getEnvOrDefault("DIRECTIONS_PROVIDER", "osrm"),
+		RoutingURL:        os.Getenv("DIRECTIONS_URL"),
+		GoogleMapsKey:     os.Getenv("GOOGLE_MAPS_API_KEY"),
+		FlightAPIKey:      os.Getenv("FLIGHT_API_KEY"),
+		FlightAPIURL:      os.Getenv("FLIGHT_API_URL"),
+		ParcelAPIKey:      os.Getenv("PARCEL_API_KEY"),
+		ParcelAPIURL:      os.Getenv("PARCEL_API_URL"),
+		CarriersFile:      os.Getenv("TRACKING_CARRIERS_FILE"),
+		TrackingInterval:  getEnvDuration("TRACKING_WATCH_INTERVAL", 30*time.Minute),
+		HealthDataDir:     getEnvOrDefault("HEALTH_DATA_DIR", "uploads"),
+		HealthUnits:       getEnvOrDefault("HEALTH_UNITS", "metric"),
+		TMDBAPIKey:        os.Getenv("TMDB_API_KEY"),
+		MediaRegion:       getEnvOrDefault("MEDIA_REGION", "US"),
+		GitHubToken:       os.Getenv("GITHUB_TOKEN"),
+		GitHubAPIURL:      getEnvOrDefault("GITHUB_API_URL", "https://api.github.com"),
+		AllowedLicenses:   getEnvList("LICENSE_ALLOWLIST"),
+		TerraformDirs:     getEnvList("TERRAFORM_DIRS"),
+		CloudProviders:    getEnvList("CLOUD_PROVIDERS"),
+		AWSRegions:        getEnvList("AWS_REGIONS"),
+		GCPProject:        os.Getenv("GCP_PROJECT"),
+		GCPBillingTable:   os.Getenv("GCP_BILLING_TABLE"),
+		CloudWrites:       getEnvBool("CLOUD_WRITES", false),
+		CloudflareToken:   os.Getenv("CLOUDFLARE_API_TOKEN"),
+		DNSResolverURL:    getEnvOrDefault("DNS_RESOLVER_URL", "https://cloudflare-dns.com/dns-query"),
+		CertDomains:       getEnvList("CERT_DOMAINS"),
+		CertWarnDays:      int(getEnvInt64("CERT_WARN_DAYS", 14)),
+		CertCheckTime:     getEnvOrDefault("CERT_CHECK_TIME", "09:00"),
+		UptimeInterval:    getEnvDuration("UPTIME_INTERVAL", time.Minute),
+		MQTTURL:           os.Getenv("MQTT_URL"),
+		MQTTUsername:      os.Getenv("MQTT_USERNAME"),
+		MQTTPassword:      os.Getenv("MQTT_PASSWORD"),
+		PresenceDevices:   getEnvList("PRESENCE_DEVICES"),
+		PresenceSubnets:   getEnvList("PRESENCE_SUBNETS"),
+		PresenceInterval:  getEnvDuration("PRESENCE_INTERVAL", time.Minute),
+		PresenceAwayAfter: getEnvDuration("PRESENCE_AWAY_AFTER", 10*time.Minute),
+		BackupPaths:       getEnvList("BACKUP_PATHS"),
+		BackupDestination: os.Getenv("BACKUP_DESTINATION"),
+		BackupRecipients:  getEnvList("BACKUP_AGE_RECIPIENTS"),
+		BackupTime:        getEnvOrDefault("BACKUP_TIME", "03:00"),
+		OwnerIDs:          getEnvInt64List("OWNER_USER_IDS"),
+		TrustedIDs:        getEnvInt64List("TRUSTED_USER_IDS"),
+		GuestTools:        getEnvList("GUEST_TOOLS"),
+		AllowedUserIDs:    getEnvInt64List("ALLOWED_USER_IDS"),
+		AllowedChatIDs:    getEnvInt64List("ALLOWED_CHAT_IDS"),
+		Pairing:           getEnvBool("OWNER_PAIRING", true),
+		Tenants:           getEnvBool("TENANTS", false),
+		TenantKey:         os.Getenv("TENANT_KEY"),
+		StateDir:          getEnvOrDefault("STATE_DIR", "state"),
+		ShutdownTimeout:   getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		MaxConcurrentRuns: int(getEnvInt64("MAX_CONCURRENT_RUNS", 2)),
+		HistoryTurns:      int(getEnvInt64("HISTORY_TURNS", 10)),
+		HistoryTokens:     int(getEnvInt64("HISTORY_TOKENS", 8000)),
+		StreamEditTokens:  int(getEnvInt64("STREAM_EDIT_TOKENS", 30)),
+		RunBudget:         getEnvDuration("RUN_BUDGET", 5*time.Minute),
+		BriefingTime:      os.Getenv("BRIEFING_TIME"),
+		BriefingLocation:  os.Getenv("BRIEFING_LOCATION"),
+		BriefingChatID:    getEnvInt64("BRIEFING_CHAT_ID", 0),
+		ShoppingChatID:    getEnvInt64("SHOPPING_LIST_CHAT_ID", 0),
+		ClipTTL:           getEnvDuration("CLIP_TTL", 24*time.Hour),
+		ClipAddr:          os.Getenv("CLIP_ADDR"),
+		ClipURL:           os.Getenv("CLIP_URL"),
+		EventSourcesFile:  os.Getenv("EVENT_SOURCES_FILE"),
+		EventsAddr:        os.Getenv("EVENTS_ADDR"),
+		DebugAddr:         os.Getenv("DEBUG_ADDR"),
+		DebugToken:        os.Getenv("DEBUG_TOKEN"),
+
+		QuotaMaxRequests:    int(getEnvInt64("QUOTA_MAX_REQUESTS", 0)),
+		QuotaMaxToolSeconds: int(getEnvInt64("QUOTA_MAX_TOOL_SECONDS", 0)),
+		QuotaMaxScrapeBytes: getEnvInt64("QUOTA_MAX_SCRAPE_BYTES", 0),
+	}
+}
+
+// ChatModel returns the model answering messages: LLMModel on a hosted
+// API, OllamaModel otherwise.
+func (c *Config) ChatModel() string {
+	if c.LLMProvider != "ollama" {
+		return c.LLMModel
+	}
+	return c.OllamaModel
+}
+
+// SmallModel returns the model tried first for trivial messages, or "".
+func (c *Config) SmallModel() string {
+	if c.LLMProvider != "ollama" {
+		return c.LLMSmallModel
 	}
+	return c.OllamaSmallModel
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -37,3 +289,83 @@ func getEnvOrDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt64 parses an integer variable, falling back to the default if unset or invalid.
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q", key, value)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvBool parses a boolean such as "true" or "0", falling back to the default if unset or invalid.
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q", key, value)
+		return defaultValue
+	}
+	return b
+}
+
+// getEnvDuration parses a duration such as "30s", falling back to the default if unset or invalid.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s value %q", key, value)
+		return defaultValue
+	}
+	return d
+}
+
+// getEnvList parses a comma-separated list of strings, skipping empty entries.
+func getEnvList(key string) []string {
+	var result []string
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// getEnvListOrDefault parses a comma-separated list, falling back to a
+// one-item list of the default if unset or empty.
+func getEnvListOrDefault(key, defaultValue string) []string {
+	if list := getEnvList(key); len(list) > 0 {
+		return list
+	}
+	return []string{defaultValue}
+}
+
+// getEnvInt64List parses a comma-separated list of integers, skipping invalid entries.
+func getEnvInt64List(key string) []int64 {
+	var result []int64
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid %s entry %q", key, field)
+			continue
+		}
+		result = append(result, id)
+	}
+	return result
+}
@@ -0,0 +1,456 @@
+// Package egress limits where the programs tools run may connect to, with
+// a local HTTP(S) proxy that checks each destination against a per-tool
+// policy.
+package egress
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logPrefix   = "[egress]"
+	dialTimeout = 10 * time.Second
+	anyTool     = "*" // The policy of tools without their own
+)
+
+// Policy says which hosts a tool's programs may reach. Patterns are host
+// names, "*.example.com" for a domain and its subdomains, IP addresses, or
+// CIDR ranges. Deny wins over Allow; an empty Allow allows every host not
+// denied.
+type Policy struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Allows reports whether the policy lets programs reach host, which
+// resolves to addrs. Address patterns are checked against every address,
+// so a name pointing at a denied address is denied too, and a name is
+// allowed by address only if all of its addresses are.
+func (p Policy) Allows(host string, addrs ...net.IP) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range p.Deny {
+		if matchHost(pattern, host) {
+			return false
+		}
+		for _, ip := range addrs {
+			if matchIP(pattern, ip) {
+				return false
+			}
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	if len(addrs) == 0 {
+		return false
+	}
+	for _, ip := range addrs {
+		if !slices.ContainsFunc(p.Allow, func(pattern string) bool { return matchIP(pattern, ip) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+	return host == pattern
+}
+
+// matchIP reports whether ip is the pattern's address or in its range.
+func matchIP(pattern string, ip net.IP) bool {
+	pattern = strings.TrimSpace(pattern)
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		return network.Contains(ip)
+	}
+	want := net.ParseIP(pattern)
+	return want != nil && want.Equal(ip)
+}
+
+// LoadPolicies reads policies by tool from a JSON file, e.g.
+//
+//	{"python": {"allow": ["pypi.org", "*.pythonhosted.org"]}, "*": {"deny": ["169.254.169.254"]}}
+//
+// The "*" policy applies to tools without their own.
+func LoadPolicies(path string) (map[string]Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading egress policies: %w", err)
+	}
+	var policies map[string]Policy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("parsing egress policies: %w", err)
+	}
+	return policies, nil
+}
+
+// Proxy is a local HTTP proxy that forwards requests, and tunnels HTTPS,
+// only to the hosts the requesting tool's policy allows. Each tool gets
+// its own credentials, so one tool's programs can't claim another's policy,
+// and requests without valid ones are refused. Host names are resolved
+// before they're checked, and the connection goes to the address that was
+// checked, so DNS can't be used to slip past an address rule.
+type Proxy struct {
+	policies map[string]Policy
+	listener net.Listener
+
+	mu      sync.Mutex
+	secrets map[string]string       // Tool to its proxy password
+	ports   map[string]net.Listener // Tool to its port for programs that can't send credentials
+}
+
+// New creates a proxy enforcing the policies, listening on a free port of
+// addr, or of 127.0.0.1 if addr is empty.
+func New(addr string, policies map[string]Policy) (*Proxy, error) {
+	if addr == "" {
+		addr = "127.0.0.1"
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(addr, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("listening: %w", err)
+	}
+	return &Proxy{policies: policies, listener: listener, secrets: make(map[string]string), ports: make(map[string]net.Listener)}, nil
+}
+
+// Listen opens a port of the proxy's own address where every request gets
+// the tool's policy, for programs like Chromium that ignore credentials in
+// proxy URLs, and returns its address. Call it before Run.
+func (p *Proxy) Listen(tool string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if l, ok := p.ports[tool]; ok {
+		return l.Addr().String(), nil
+	}
+	host, _, _ := net.SplitHostPort(p.listener.Addr().String())
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return "", fmt.Errorf("listening for %s: %w", tool, err)
+	}
+	p.ports[tool] = l
+	return l.Addr().String(), nil
+}
+
+// Run serves until ctx is done.
+func (p *Proxy) Run(ctx context.Context) error {
+	p.mu.Lock()
+	ports := maps.Clone(p.ports)
+	p.mu.Unlock()
+
+	servers := []*http.Server{{Handler: p, ReadHeaderTimeout: dialTimeout}}
+	errs := make(chan error, len(ports)+1)
+	for tool, l := range ports {
+		srv := &http.Server{Handler: toolHandler{p, tool}, ReadHeaderTimeout: dialTimeout}
+		servers = append(servers, srv)
+		go func() { errs <- srv.Serve(l) }()
+	}
+	go func() { errs <- servers[0].Serve(p.listener) }()
+	log.Printf("%s proxy on %s, policies for %d tools", logPrefix, p.listener.Addr(), len(p.policies))
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	for _, srv := range servers {
+		srv.Close()
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
+
+// BridgeAddr returns the IPv4 address of a bridge interface, for the
+// proxy to listen on where commands in network namespaces attached to the
+// bridge can reach it.
+func BridgeAddr(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no IPv4 address", name)
+}
+
+// Netfilter returns firewall rules, in iptables-restore format, for a
+// network namespace of a tool's programs whose only way out is this proxy:
+// its main port, and the tool's own port if Listen opened one.
+func (p *Proxy) Netfilter(tool string) []byte {
+	addrs := []*net.TCPAddr{p.listener.Addr().(*net.TCPAddr)}
+	p.mu.Lock()
+	if l, ok := p.ports[tool]; ok {
+		addrs = append(addrs, l.Addr().(*net.TCPAddr))
+	}
+	p.mu.Unlock()
+
+	rules := []byte(`*filter
+:INPUT DROP [0:0]
+:FORWARD DROP [0:0]
+:OUTPUT DROP [0:0]
+-A INPUT -i lo -j ACCEPT
+-A OUTPUT -o lo -j ACCEPT
+`)
+	for _, addr := range addrs {
+		rules = fmt.Appendf(rules, "-A INPUT -p tcp -s %[1]s --sport %[2]d -m state --state ESTABLISHED -j ACCEPT\n-A OUTPUT -p tcp -d %[1]s --dport %[2]d -j ACCEPT\n", addr.IP, addr.Port)
+	}
+	return append(rules, "COMMIT\n"...)
+}
+
+// Env returns the environment variables that send a tool's programs
+// through the proxy.
+func (p *Proxy) Env(tool string) []string {
+	addr := p.URL(tool).String()
+	return []string{
+		"HTTP_PROXY=" + addr, "http_proxy=" + addr,
+		"HTTPS_PROXY=" + addr, "https_proxy=" + addr,
+		"ALL_PROXY=" + addr, "all_proxy=" + addr,
+		"NO_PROXY=", "no_proxy=",
+	}
+}
+
+// URL returns the proxy's address with a tool's credentials, for requests
+// the bot makes itself on the tool's behalf.
+func (p *Proxy) URL(tool string) *url.URL {
+	return &url.URL{Scheme: "http", User: url.UserPassword(tool, p.secret(tool)), Host: p.listener.Addr().String()}
+}
+
+func (p *Proxy) secret(tool string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.secrets[tool]
+	if !ok {
+		b := make([]byte, 16)
+		rand.Read(b)
+		s = hex.EncodeToString(b)
+		p.secrets[tool] = s
+	}
+	return s
+}
+
+// authenticate returns the tool the request's proxy credentials belong
+// to, or false if it has none or they're wrong.
+func (p *Proxy) authenticate(r *http.Request) (string, bool) {
+	tool, secret, ok := proxyAuth(r)
+	if !ok {
+		return "", false
+	}
+	p.mu.Lock()
+	want, known := p.secrets[tool]
+	p.mu.Unlock()
+	return tool, known && subtle.ConstantTimeCompare([]byte(secret), []byte(want)) == 1
+}
+
+// policy returns a tool's policy, or the "*" policy if it has none.
+func (p *Proxy) policy(tool string) Policy {
+	if policy, found := p.policies[tool]; found {
+		return policy
+	}
+	return p.policies[anyTool] // Unrestricted if there's none
+}
+
+func proxyAuth(r *http.Request) (user, password string, ok bool) {
+	basic, found := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	if !found {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(basic)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tool, ok := p.authenticate(r)
+	if !ok {
+		log.Printf("%s refused a request for %s without valid credentials", logPrefix, r.Host)
+		w.Header().Set("Proxy-Authenticate", `Basic realm="egress"`)
+		http.Error(w, "proxy credentials required", http.StatusProxyAuthRequired)
+		return
+	}
+	p.serve(w, r, tool)
+}
+
+// toolHandler serves a tool's own port, where requests need no credentials.
+type toolHandler struct {
+	p    *Proxy
+	tool string
+}
+
+func (h toolHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.p.serve(w, r, h.tool)
+}
+
+// serve relays a request of the tool's, if its policy allows the
+// destination.
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request, tool string) {
+	target, host, port := r.Host, r.Host, "443"
+	if r.Method != http.MethodConnect {
+		target, host, port = r.URL.Host, r.URL.Hostname(), "80"
+	}
+	if h, pt, err := net.SplitHostPort(target); err == nil {
+		host, port = h, pt
+	}
+
+	policy := p.policy(tool)
+	addrs, err := resolve(r.Context(), host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if !policy.Allows(host, addrs...) {
+		log.Printf("%s blocked %s from %s", logPrefix, host, tool)
+		http.Error(w, fmt.Sprintf("%s is blocked by the bot's egress policy for %s", host, tool), http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, addrs, port)
+		return
+	}
+	p.forward(w, r, addrs, port)
+}
+
+// resolve returns the addresses of host, which may be an address itself.
+func resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	found, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]net.IP, len(found))
+	for i, a := range found {
+		addrs[i] = a.IP
+	}
+	return addrs, nil
+}
+
+// dial connects to the first of addrs that answers, rather than resolving
+// the name again and maybe getting an address the policy didn't see.
+func dial(ctx context.Context, addrs []net.IP, port string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	var errs []error
+	for _, ip := range addrs {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// tunnel relays an HTTPS connection to the first of addrs that answers.
+func (p *Proxy) tunnel(w http.ResponseWriter, addrs []net.IP, port string) {
+	upstream, err := dial(context.Background(), addrs, port)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	go func() {
+		io.Copy(upstream, buf) // Including anything the client sent early
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+// hopHeaders are meant for the proxy, not the destination.
+var hopHeaders = []string{"Proxy-Authorization", "Proxy-Connection", "Connection", "Keep-Alive", "Te", "Trailer", "Upgrade"}
+
+// forward relays a plain HTTP request to the first of addrs that answers.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, addrs []net.IP, port string) {
+	ctx := context.WithValue(r.Context(), dialKey{}, dialTarget{addrs, port})
+	out := r.Clone(ctx)
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	resp, err := forwardTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+type dialKey struct{}
+
+// dialTarget is where a forwarded request's connection must go.
+type dialTarget struct {
+	addrs []net.IP
+	port  string
+}
+
+// forwardTransport ignores the bot's own proxy settings, and connects to
+// the addresses ServeHTTP checked. It keeps no idle connections, which it
+// would share between requests by host name alone.
+var forwardTransport = &http.Transport{
+	Proxy: nil,
+	DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		target, ok := ctx.Value(dialKey{}).(dialTarget)
+		if !ok {
+			return nil, errors.New("no checked address to dial")
+		}
+		return dial(ctx, target.addrs, target.port)
+	},
+	DisableKeepAlives:     true,
+	ResponseHeaderTimeout: time.Minute,
+}
@@ -0,0 +1,102 @@
+package egress
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		host   string
+		addrs  []string
+		want   bool
+	}{
+		{name: "empty allows anything", host: "example.com", want: true},
+		{name: "exact host", policy: Policy{Allow: []string{"pypi.org"}}, host: "pypi.org", want: true},
+		{name: "host case and trailing dot", policy: Policy{Allow: []string{"pypi.org"}}, host: "PyPI.org.", want: true},
+		{name: "other host", policy: Policy{Allow: []string{"pypi.org"}}, host: "evil.org", want: false},
+		{name: "wildcard subdomain", policy: Policy{Allow: []string{"*.github.com"}}, host: "api.github.com", want: true},
+		{name: "wildcard apex", policy: Policy{Allow: []string{"*.github.com"}}, host: "github.com", want: true},
+		{name: "wildcard lookalike", policy: Policy{Allow: []string{"*.github.com"}}, host: "evilgithub.com", want: false},
+		{name: "deny wins", policy: Policy{Allow: []string{"*.github.com"}, Deny: []string{"gist.github.com"}}, host: "gist.github.com", want: false},
+		{name: "denied address", policy: Policy{Deny: []string{"169.254.169.254"}}, host: "metadata.example", addrs: []string{"169.254.169.254"}, want: false},
+		{name: "denied range", policy: Policy{Deny: []string{"10.0.0.0/8"}}, host: "intranet", addrs: []string{"192.0.2.1", "10.1.2.3"}, want: false},
+		{name: "allowed range", policy: Policy{Allow: []string{"192.0.2.0/24"}}, host: "svc", addrs: []string{"192.0.2.7"}, want: true},
+		{name: "partly allowed range", policy: Policy{Allow: []string{"192.0.2.0/24"}}, host: "svc", addrs: []string{"192.0.2.7", "198.51.100.1"}, want: false},
+		{name: "address rule without addresses", policy: Policy{Allow: []string{"192.0.2.0/24"}}, host: "svc", want: false},
+	}
+	for _, tt := range tests {
+		var addrs []net.IP
+		for _, a := range tt.addrs {
+			addrs = append(addrs, net.ParseIP(a))
+		}
+		if got := tt.policy.Allows(tt.host, addrs...); got != tt.want {
+			t.Errorf("%s: Allows(%q, %v) = %v, want %v", tt.name, tt.host, tt.addrs, got, tt.want)
+		}
+	}
+}
+
+// TestProxy sends requests through the proxy to a local server, which
+// only the "open" tool's policy allows.
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer upstream.Close()
+
+	proxy, err := New("", map[string]Policy{
+		"open": {},
+		"*":    {Deny: []string{"127.0.0.0/8"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	openPort, err := proxy.Listen("open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort, err := proxy.Listen("closed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go proxy.Run(ctx)
+
+	wrongSecret := proxy.URL("open")
+	wrongSecret.User = url.UserPassword("open", "guess")
+	tests := []struct {
+		name  string
+		proxy *url.URL
+		want  int
+	}{
+		{name: "tool credentials", proxy: proxy.URL("open"), want: http.StatusOK},
+		{name: "other tool's policy", proxy: proxy.URL("closed"), want: http.StatusForbidden},
+		{name: "no credentials", proxy: &url.URL{Scheme: "http", Host: proxy.listener.Addr().String()}, want: http.StatusProxyAuthRequired},
+		{name: "wrong password", proxy: wrongSecret, want: http.StatusProxyAuthRequired},
+		{name: "unknown tool", proxy: &url.URL{Scheme: "http", User: url.UserPassword("nobody", "x"), Host: proxy.listener.Addr().String()}, want: http.StatusProxyAuthRequired},
+		{name: "tool's own port", proxy: &url.URL{Scheme: "http", Host: openPort}, want: http.StatusOK},
+		{name: "other tool's own port", proxy: &url.URL{Scheme: "http", Host: closedPort}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(tt.proxy)}}
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, resp.StatusCode, strings.TrimSpace(string(body)), tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package embed turns text into embedding vectors with Ollama. Texts are
+// sent in batches, results are cached on disk by content and model, and the
+// number of requests in flight is capped, so re-embedding a large set of
+// mostly unchanged texts is cheap and doesn't swamp the model server.
+package embed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/balance"
+)
+
+const (
+	defaultBatchSize   = 32
+	defaultConcurrency = 2
+	requestTimeout     = 120 * time.Second
+)
+
+// Client embeds texts with one model.
+type Client struct {
+	pool      *balance.Pool
+	model     string
+	http      *http.Client
+	cacheDir  string // Empty disables the cache
+	batchSize int
+	slots     chan struct{} // One token per request allowed in flight
+}
+
+// Option customizes a Client.
+type Option func(*Client)
+
+// WithCache stores embeddings under dir, one file per text and model.
+func WithCache(dir string) Option {
+	return func(c *Client) {
+		c.cacheDir = dir
+	}
+}
+
+// WithBatchSize sets how many texts are sent in one request.
+func WithBatchSize(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.batchSize = n
+		}
+	}
+}
+
+// WithConcurrency sets how many requests may be in flight at once, across
+// all callers of the client.
+func WithConcurrency(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// New creates a client that embeds with model on the pool's instances.
+func New(pool *balance.Pool, model string, opts ...Option) *Client {
+	c := &Client{
+		pool:      pool,
+		model:     model,
+		http:      pool.Client(requestTimeout),
+		batchSize: defaultBatchSize,
+		slots:     make(chan struct{}, defaultConcurrency),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Model returns the name of the embedding model.
+func (c *Client) Model() string {
+	return c.model
+}
+
+// Embed returns one vector per text, in the same order. Cached vectors are
+// reused; the rest are requested in batches, each distinct text once.
+func (c *Client) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	keys := make([]string, len(texts))
+	missing := make(map[string][]int) // Cache key to the indexes of texts with it
+	var order []string                // Missing keys in first-seen order
+
+	for i, text := range texts {
+		keys[i] = c.key(text)
+		if v, ok := c.load(keys[i]); ok {
+			vectors[i] = v
+			continue
+		}
+		if _, seen := missing[keys[i]]; !seen {
+			order = append(order, keys[i])
+		}
+		missing[keys[i]] = append(missing[keys[i]], i)
+	}
+	if len(order) == 0 {
+		return vectors, nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for start := 0; start < len(order); start += c.batchSize {
+		batch := order[start:min(start+c.batchSize, len(order))]
+		inputs := make([]string, len(batch))
+		for i, key := range batch {
+			inputs[i] = texts[missing[key][0]]
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			embedded, err := c.request(ctx, inputs)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for i, key := range batch {
+				c.store(key, embedded[i])
+				for _, idx := range missing[key] {
+					vectors[idx] = embedded[i]
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return vectors, nil
+}
+
+// request embeds one batch, waiting for a free slot first.
+func (c *Client) request(ctx context.Context, inputs []string) ([][]float32, error) {
+	select {
+	case c.slots <- struct{}{}:
+		defer func() { <-c.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	body, err := json.Marshal(map[string]any{"model": c.model, "input": inputs})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	err = c.pool.Do(ctx, func(chatURL string) error {
+		embedURL := strings.Replace(chatURL, "/api/chat", "/api/embed", 1)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, embedURL, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return fmt.Errorf("calling Ollama: %w", err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("reading response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, string(data))
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("parsing response: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("Ollama returned %d embeddings for %d texts", len(result.Embeddings), len(inputs))
+	}
+	return result.Embeddings, nil
+}
+
+// key identifies a text embedded with the client's model.
+func (c *Client) key(text string) string {
+	sum := sha256.Sum256([]byte(c.model + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// path returns where a key's vector is cached, spread over subdirectories
+// so none gets too large.
+func (c *Client) path(key string) string {
+	return filepath.Join(c.cacheDir, key[:2], key+".f32")
+}
+
+func (c *Client) load(key string) ([]float32, bool) {
+	if c.cacheDir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil || len(data) == 0 || len(data)%4 != 0 {
+		return nil, false
+	}
+	v := make([]float32, len(data)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return v, true
+}
+
+// store caches a vector. Failures only cost a recomputation later, so they
+// are logged rather than returned.
+func (c *Client) store(key string, v []float32) {
+	if c.cacheDir == "" {
+		return
+	}
+	data := make([]byte, len(v)*4)
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(f))
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("[embed] caching: %v", err)
+		return
+	}
+	// Write to a unique temporary file first, so concurrent writers and
+	// crashes never leave a partial vector behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		log.Printf("[embed] caching: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("[embed] caching: %v", err)
+	}
+}
+
+// Cosine returns the cosine similarity of two vectors, or 0 if they differ
+// in length or either is zero.
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,168 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const alertmanagerTimeout = 10 * time.Second
+
+// severityRank orders the usual severity labels, most severe first.
+var severityRank = map[string]int{"critical": 0, "page": 0, "error": 1, "warning": 2, "info": 3}
+
+// Matcher selects alerts by a label's exact value, as silences do.
+type Matcher struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// AlertGroup is one notification from Alertmanager about a group of
+// alerts. Alertmanager keeps sending notifications for the group, with the
+// same key, as its alerts change and when they resolve.
+type AlertGroup struct {
+	Key      string
+	Firing   bool
+	Severity string // The most severe of the firing alerts'
+	Text     string
+	Matchers []Matcher // The group's labels, to silence it by
+}
+
+// AlertGroup parses an Alertmanager notification.
+func (s *Source) AlertGroup(body []byte) (*AlertGroup, error) {
+	var n struct {
+		alertNotification
+		GroupKey     string            `json:"groupKey"`
+		GroupLabels  map[string]string `json:"groupLabels"`
+		CommonLabels map[string]string `json:"commonLabels"`
+	}
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("parsing Alertmanager notification: %w", err)
+	}
+	if len(n.Alerts) == 0 {
+		return nil, fmt.Errorf("the notification has no alerts")
+	}
+
+	g := &AlertGroup{Key: n.GroupKey, Firing: n.Status != "resolved"}
+	rank := len(severityRank) + 1
+	for _, a := range n.Alerts {
+		severity := a.Labels["severity"]
+		r, ok := severityRank[severity]
+		if !ok {
+			r = len(severityRank)
+		}
+		if a.Status != "resolved" && r < rank {
+			g.Severity, rank = severity, r
+		}
+	}
+	g.Text = truncate(formatAlerts(n.alertNotification, ""), maxMessageChars)
+
+	labels := n.GroupLabels
+	if len(labels) == 0 {
+		labels = n.CommonLabels // Grouped by nothing, or by everything
+	}
+	for name, value := range labels {
+		g.Matchers = append(g.Matchers, Matcher{Name: name, Value: value})
+	}
+	sort.Slice(g.Matchers, func(i, j int) bool { return g.Matchers[i].Name < g.Matchers[j].Name })
+	if g.Key == "" {
+		g.Key = fmt.Sprint(g.Matchers)
+	}
+	return g, nil
+}
+
+// ChatsFor returns the chats alerts of the severity go to: the severity's
+// route if there is one, or the source's chats.
+func (s *Source) ChatsFor(severity string) []int64 {
+	if chats, ok := s.Routes[severity]; ok {
+		return chats
+	}
+	return s.Chats
+}
+
+// CanSilence reports whether the source knows where its Alertmanager is.
+func (s *Source) CanSilence() bool {
+	return s.AlertmanagerURL != ""
+}
+
+// Silence creates a silence in Alertmanager for alerts matching all of
+// matchers, and returns its ID.
+func (s *Source) Silence(ctx context.Context, matchers []Matcher, d time.Duration, by string) (string, error) {
+	if len(matchers) == 0 {
+		return "", fmt.Errorf("the alerts have no labels to silence them by")
+	}
+	type apiMatcher struct {
+		Name    string `json:"name"`
+		Value   string `json:"value"`
+		IsRegex bool   `json:"isRegex"`
+		IsEqual bool   `json:"isEqual"`
+	}
+	silence := struct {
+		Matchers  []apiMatcher `json:"matchers"`
+		StartsAt  time.Time    `json:"startsAt"`
+		EndsAt    time.Time    `json:"endsAt"`
+		CreatedBy string       `json:"createdBy"`
+		Comment   string       `json:"comment"`
+	}{
+		StartsAt:  time.Now().UTC(),
+		EndsAt:    time.Now().Add(d).UTC(),
+		CreatedBy: by,
+		Comment:   "Silenced from Telegram by " + by,
+	}
+	for _, m := range matchers {
+		silence.Matchers = append(silence.Matchers, apiMatcher{Name: m.Name, Value: m.Value, IsEqual: true})
+	}
+	body, err := json.Marshal(silence)
+	if err != nil {
+		return "", fmt.Errorf("marshaling silence: %w", err)
+	}
+
+	data, err := s.alertmanager(ctx, http.MethodPost, "/api/v2/silences", body)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.Unmarshal(data, &created); err != nil {
+		return "", fmt.Errorf("parsing silence: %w", err)
+	}
+	return created.SilenceID, nil
+}
+
+// Unsilence expires a silence early.
+func (s *Source) Unsilence(ctx context.Context, id string) error {
+	_, err := s.alertmanager(ctx, http.MethodDelete, "/api/v2/silence/"+url.PathEscape(id), nil)
+	return err
+}
+
+// alertmanager calls Alertmanager's API and returns the response's body.
+func (s *Source) alertmanager(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, alertmanagerTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.AlertmanagerURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling Alertmanager: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading Alertmanager's response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("calling Alertmanager: %s: %s", resp.Status, truncate(strings.TrimSpace(string(data)), 300))
+	}
+	return data, nil
+}
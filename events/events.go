@@ -0,0 +1,174 @@
+// Package events turns webhooks from other services (GitHub, Grafana,
+// Alertmanager, or anything else that posts JSON) into chat messages.
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"telegram-bot/redact"
+)
+
+const (
+	maxMessageChars = 3500 // Leaves room in a Telegram message
+	maxGenericLines = 30
+)
+
+// Source is a service allowed to post events, and where they go.
+type Source struct {
+	Token     string  `json:"token"`               // Bearer token, ?token=, or GitHub's webhook secret
+	Format    string  `json:"format,omitempty"`    // github, grafana, or alertmanager; empty for any JSON
+	Template  string  `json:"template,omitempty"`  // text/template over the JSON, instead of Format
+	Summarize bool    `json:"summarize,omitempty"` // Have the model write the message
+	Chats     []int64 `json:"chats"`
+	Urgent    bool    `json:"urgent,omitempty"` // Deliver during quiet hours
+
+	// Alertmanager's: chats by severity label, overriding Chats, and the
+	// API to silence alerts through
+	Routes          map[string][]int64 `json:"routes,omitempty"`
+	AlertmanagerURL string             `json:"alertmanager_url,omitempty"`
+
+	tmpl *template.Template
+}
+
+// LoadSources reads sources by name from a JSON file, e.g.
+//
+//	{"github": {"token": "...", "format": "github", "chats": [123]},
+//	 "backups": {"token": "...", "template": "{{.host}}: {{.status}}", "chats": [123]}}
+//
+// Events are posted to /events/<name>. Tokens are registered with the
+// redactor.
+func LoadSources(path string) (map[string]*Source, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading event sources: %w", err)
+	}
+	var sources map[string]*Source
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("parsing event sources: %w", err)
+	}
+	for name, s := range sources {
+		if s.Token == "" {
+			return nil, fmt.Errorf("event source %s has no token", name)
+		}
+		redact.Register(s.Token)
+		if len(s.Chats) == 0 && len(s.Routes) == 0 {
+			return nil, fmt.Errorf("event source %s has no chats", name)
+		}
+		switch s.Format {
+		case "", "github", "grafana", "alertmanager":
+		default:
+			return nil, fmt.Errorf("event source %s has unknown format %q (use github, grafana, or alertmanager)", name, s.Format)
+		}
+		if s.Template != "" {
+			if s.tmpl, err = template.New(name).Parse(s.Template); err != nil {
+				return nil, fmt.Errorf("parsing template of event source %s: %w", name, err)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// Authorized reports whether a request carries the source's token, as
+// "Authorization: Bearer <token>", a ?token= parameter, or GitHub's
+// X-Hub-Signature-256 of the body made with it.
+func (s *Source) Authorized(r *http.Request, body []byte) bool {
+	if signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="); ok {
+		mac := hmac.New(sha256.New, []byte(s.Token))
+		mac.Write(body)
+		want := hex.EncodeToString(mac.Sum(nil))
+		return subtle.ConstantTimeCompare([]byte(signature), []byte(want)) == 1
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		given = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.Token)) == 1
+}
+
+// Message formats an event from the source. An empty message means the
+// event isn't worth posting, such as a label added to a pull request.
+func (s *Source) Message(name string, header http.Header, body []byte) (string, error) {
+	var msg string
+	var err error
+	switch {
+	case s.tmpl != nil:
+		msg, err = s.execute(body)
+	case s.Format == "github":
+		msg, err = githubMessage(header.Get("X-GitHub-Event"), body)
+	case s.Format == "grafana":
+		msg, err = grafanaMessage(body)
+	case s.Format == "alertmanager":
+		msg, err = alertmanagerMessage(body)
+	default:
+		msg, err = genericMessage(name, body)
+	}
+	if err != nil {
+		return "", err
+	}
+	return truncate(strings.TrimSpace(msg), maxMessageChars), nil
+}
+
+func (s *Source) execute(body []byte) (string, error) {
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", fmt.Errorf("parsing event: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := s.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("formatting event: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// genericMessage lists the event's fields, one per line, as path: value.
+func genericMessage(name string, body []byte) (string, error) {
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", fmt.Errorf("parsing event: %w", err)
+	}
+	var lines []string
+	flatten("", data, &lines)
+	sort.Strings(lines)
+	if len(lines) > maxGenericLines {
+		lines = append(lines[:maxGenericLines], fmt.Sprintf("… and %d more fields", len(lines)-maxGenericLines))
+	}
+	return fmt.Sprintf("📨 %s\n\n%s", name, strings.Join(lines, "\n")), nil
+}
+
+func flatten(path string, v any, lines *[]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			flatten(strings.TrimPrefix(path+"."+key, "."), value, lines)
+		}
+	case []any:
+		for i, value := range v {
+			flatten(fmt.Sprintf("%s[%d]", path, i), value, lines)
+		}
+	case nil:
+	default:
+		if path == "" {
+			path = "value"
+		}
+		*lines = append(*lines, fmt.Sprintf("%s: %v", path, truncate(fmt.Sprint(v), 200)))
+	}
+}
+
+// truncate cuts s to at most n bytes, on a rune boundary.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "…"
+}
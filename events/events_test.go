@@ -0,0 +1,192 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{name: "valid", json: `{"ci": {"token": "t0ken-for-ci", "format": "github", "chats": [1]}, "backups": {"token": "t0ken-for-backups", "template": "{{.host}}", "chats": [2]}}`},
+		{name: "routes instead of chats", json: `{"am": {"token": "t0ken-for-am", "format": "alertmanager", "routes": {"critical": [1]}}}`},
+		{name: "no token", json: `{"ci": {"chats": [1]}}`, wantErr: "has no token"},
+		{name: "no chats", json: `{"ci": {"token": "t0ken-for-ci"}}`, wantErr: "has no chats"},
+		{name: "unknown format", json: `{"ci": {"token": "t0ken-for-ci", "format": "gitlab", "chats": [1]}}`, wantErr: "unknown format"},
+		{name: "bad template", json: `{"ci": {"token": "t0ken-for-ci", "template": "{{.host", "chats": [1]}}`, wantErr: "parsing template"},
+		{name: "not JSON", json: `ci: {}`, wantErr: "parsing event sources"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "events.json")
+		if err := os.WriteFile(path, []byte(tt.json), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadSources(path)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: LoadSources = %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: LoadSources = %v, want an error saying %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	s := &Source{Token: "s3cret-token"}
+	body := []byte(`{"zen": "Keep it simple."}`)
+	sign := func(key string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   bool
+	}{
+		{name: "bearer", header: http.Header{"Authorization": {"Bearer s3cret-token"}}, want: true},
+		{name: "wrong bearer", header: http.Header{"Authorization": {"Bearer guess"}}},
+		{name: "query token", target: "/events/ci?token=s3cret-token", want: true},
+		{name: "wrong query token", target: "/events/ci?token=guess"},
+		{name: "github signature", header: http.Header{"X-Hub-Signature-256": {sign("s3cret-token")}}, want: true},
+		{name: "signed with another secret", header: http.Header{"X-Hub-Signature-256": {sign("guess")}}},
+		{name: "bad signature beside a good token", target: "/events/ci?token=s3cret-token", header: http.Header{"X-Hub-Signature-256": {sign("guess")}}},
+		{name: "nothing", target: "/events/ci"},
+	}
+	for _, tt := range tests {
+		target := tt.target
+		if target == "" {
+			target = "/events/ci"
+		}
+		r := httptest.NewRequest(http.MethodPost, target, nil)
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		if got := s.Authorized(r, body); got != tt.want {
+			t.Errorf("%s: Authorized = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		source *Source
+		event  string // X-GitHub-Event
+		body   string
+		want   string // Empty for no message
+	}{
+		{
+			name:   "github push",
+			source: &Source{Format: "github"},
+			event:  "push",
+			body:   `{"ref": "refs/heads/main", "compare": "https://example.com/c", "pusher": {"name": "sam"}, "repository": {"full_name": "o/r"}, "commits": [{"id": "0123456789", "message": "Fix it\n\nLonger"}]}`,
+			want:   "📦 sam pushed 1 commit(s) to o/r:main\n• Fix it (0123456)\nhttps://example.com/c",
+		},
+		{
+			name:   "github merged pull request",
+			source: &Source{Format: "github"},
+			event:  "pull_request",
+			body:   `{"action": "closed", "sender": {"login": "sam"}, "repository": {"full_name": "o/r"}, "pull_request": {"number": 4, "title": "Add", "html_url": "u", "merged": true}}`,
+			want:   "🔀 sam merged PR #4 in o/r: Add\nu",
+		},
+		{
+			name:   "github labelled pull request skipped",
+			source: &Source{Format: "github"},
+			event:  "pull_request",
+			body:   `{"action": "labeled", "pull_request": {"number": 4}}`,
+		},
+		{
+			name:   "github branch deletion skipped",
+			source: &Source{Format: "github"},
+			event:  "push",
+			body:   `{"ref": "refs/heads/old", "commits": []}`,
+		},
+		{
+			name:   "grafana legacy alert",
+			source: &Source{Format: "grafana"},
+			body:   `{"title": "Disk full", "state": "alerting", "message": "/ is at 99%"}`,
+			want:   "🔥 Disk full\n/ is at 99%",
+		},
+		{
+			name:   "alertmanager",
+			source: &Source{Format: "alertmanager"},
+			body:   `{"status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "Down", "severity": "critical", "instance": "db1"}}]}`,
+			want:   "🔥 FIRING: Down\n\n• Down [critical] on db1",
+		},
+		{
+			name:   "generic",
+			source: &Source{},
+			body:   `{"host": "nas", "backup": {"ok": true, "files": [3]}}`,
+			want:   "📨 backups\n\nbackup.files[0]: 3\nbackup.ok: true\nhost: nas",
+		},
+	}
+	for _, tt := range tests {
+		header := http.Header{"X-Github-Event": {tt.event}}
+		got, err := tt.source.Message("backups", header, []byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: Message = %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Message = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestTemplate checks a source's template formats its events.
+func TestTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	config := `{"backups": {"token": "t0ken-for-backups", "template": "{{.host}}: {{.status}}", "chats": [1]}}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := LoadSources(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := sources["backups"].Message("backups", nil, []byte(`{"host": "nas", "status": "done"}`))
+	if err != nil || got != "nas: done" {
+		t.Errorf("Message = %q, %v, want %q", got, err, "nas: done")
+	}
+}
+
+func TestAlertGroup(t *testing.T) {
+	s := &Source{Chats: []int64{1}, Routes: map[string][]int64{"critical": {2}}}
+	body := `{"status": "firing", "groupKey": "{}:{alertname=\"Down\"}", "groupLabels": {"alertname": "Down", "env": "prod"},
+		"alerts": [{"status": "firing", "labels": {"severity": "warning"}}, {"status": "firing", "labels": {"severity": "critical"}}, {"status": "resolved", "labels": {"severity": "page"}}]}`
+
+	g, err := s.AlertGroup([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Firing || g.Severity != "critical" {
+		t.Errorf("group firing %v at %q, want firing at critical", g.Firing, g.Severity)
+	}
+	if !slices.Equal(g.Matchers, []Matcher{{Name: "alertname", Value: "Down"}, {Name: "env", Value: "prod"}}) {
+		t.Errorf("Matchers = %v, want alertname then env", g.Matchers)
+	}
+	if chats := s.ChatsFor(g.Severity); !slices.Equal(chats, []int64{2}) {
+		t.Errorf("ChatsFor(critical) = %v, want the critical route", chats)
+	}
+	if chats := s.ChatsFor("info"); !slices.Equal(chats, []int64{1}) {
+		t.Errorf("ChatsFor(info) = %v, want the source's chats", chats)
+	}
+
+	if _, err := s.AlertGroup([]byte(`{"status": "firing", "alerts": []}`)); err == nil {
+		t.Error("AlertGroup accepted a notification without alerts")
+	}
+}
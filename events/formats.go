@@ -0,0 +1,237 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxCommits = 5
+	maxAlerts  = 10
+)
+
+// githubEvent has the fields of GitHub's webhook payloads that messages
+// use. Which are set depends on the event.
+type githubEvent struct {
+	Action  string `json:"action"`
+	Ref     string `json:"ref"`
+	Compare string `json:"compare"`
+	Zen     string `json:"zen"`
+	Pusher  struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Stars    int    `json:"stargazers_count"`
+	} `json:"repository"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"commits"`
+	PullRequest *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+	} `json:"pull_request"`
+	Issue *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue"`
+	Comment *struct {
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	} `json:"comment"`
+	Release *struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		Conclusion string `json:"conclusion"`
+		HeadBranch string `json:"head_branch"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+}
+
+// githubMessage formats the events people usually want to hear about:
+// pushes, pull requests and issues opening or closing, comments, releases,
+// finished workflow runs, and stars. Other actions are skipped.
+func githubMessage(event string, body []byte) (string, error) {
+	var e githubEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return "", fmt.Errorf("parsing GitHub event: %w", err)
+	}
+	repo := e.Repository.FullName
+
+	switch event {
+	case "ping":
+		return fmt.Sprintf("🔗 GitHub webhook connected for %s. %s", repo, e.Zen), nil
+	case "push":
+		if len(e.Commits) == 0 {
+			return "", nil // Branch deletions and tag pushes
+		}
+		branch := strings.TrimPrefix(e.Ref, "refs/heads/")
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "📦 %s pushed %d commit(s) to %s:%s", e.Pusher.Name, len(e.Commits), repo, branch)
+		for i, c := range e.Commits {
+			if i == maxCommits {
+				fmt.Fprintf(&sb, "\n…")
+				break
+			}
+			first, _, _ := strings.Cut(c.Message, "\n")
+			fmt.Fprintf(&sb, "\n• %s (%s)", first, c.ID[:min(7, len(c.ID))])
+		}
+		fmt.Fprintf(&sb, "\n%s", e.Compare)
+		return sb.String(), nil
+	case "pull_request":
+		pr := e.PullRequest
+		if pr == nil {
+			return "", nil
+		}
+		action := e.Action
+		switch {
+		case action == "closed" && pr.Merged:
+			action = "merged"
+		case action != "opened" && action != "closed" && action != "reopened" && action != "ready_for_review":
+			return "", nil
+		}
+		return fmt.Sprintf("🔀 %s %s PR #%d in %s: %s\n%s", e.Sender.Login, strings.ReplaceAll(action, "_", " "), pr.Number, repo, pr.Title, pr.HTMLURL), nil
+	case "issues":
+		if e.Issue == nil || (e.Action != "opened" && e.Action != "closed" && e.Action != "reopened") {
+			return "", nil
+		}
+		return fmt.Sprintf("🐛 %s %s issue #%d in %s: %s\n%s", e.Sender.Login, e.Action, e.Issue.Number, repo, e.Issue.Title, e.Issue.HTMLURL), nil
+	case "issue_comment":
+		if e.Issue == nil || e.Comment == nil || e.Action != "created" {
+			return "", nil
+		}
+		return fmt.Sprintf("💬 %s commented on #%d in %s: %s\n\n%s\n%s", e.Sender.Login, e.Issue.Number, repo, e.Issue.Title, truncate(e.Comment.Body, 500), e.Comment.HTMLURL), nil
+	case "release":
+		if e.Release == nil || e.Action != "published" {
+			return "", nil
+		}
+		return fmt.Sprintf("🏷 %s released %s: %s\n%s", repo, e.Release.TagName, e.Release.Name, e.Release.HTMLURL), nil
+	case "workflow_run":
+		run := e.WorkflowRun
+		if run == nil || e.Action != "completed" {
+			return "", nil
+		}
+		icon := "❌"
+		switch run.Conclusion {
+		case "success":
+			icon = "✅"
+		case "cancelled", "skipped":
+			icon = "⚪"
+		}
+		return fmt.Sprintf("%s %s %s on %s in %s\n%s", icon, run.Name, run.Conclusion, run.HeadBranch, repo, run.HTMLURL), nil
+	case "star":
+		if e.Action != "created" {
+			return "", nil
+		}
+		return fmt.Sprintf("⭐ %s starred %s (%d stars)", e.Sender.Login, repo, e.Repository.Stars), nil
+	default:
+		return fmt.Sprintf("GitHub %s event in %s from %s", event, repo, e.Sender.Login), nil
+	}
+}
+
+// alert is one alert of an Alertmanager notification; Grafana's unified
+// alerting sends the same shape.
+type alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+type alertNotification struct {
+	Status      string  `json:"status"`
+	Alerts      []alert `json:"alerts"`
+	ExternalURL string  `json:"externalURL"`
+
+	// Grafana's
+	Title   string `json:"title"`
+	State   string `json:"state"` // Legacy alerting: alerting, ok, no_data, paused
+	Message string `json:"message"`
+	RuleURL string `json:"ruleUrl"`
+}
+
+func alertmanagerMessage(body []byte) (string, error) {
+	var n alertNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return "", fmt.Errorf("parsing Alertmanager notification: %w", err)
+	}
+	return formatAlerts(n, ""), nil
+}
+
+// grafanaMessage formats notifications from Grafana's unified alerting,
+// which lists alerts like Alertmanager, or from its legacy alerting, which
+// has one rule's state.
+func grafanaMessage(body []byte) (string, error) {
+	var n alertNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return "", fmt.Errorf("parsing Grafana notification: %w", err)
+	}
+	if len(n.Alerts) > 0 {
+		return formatAlerts(n, n.Title), nil
+	}
+	icon := "🔥"
+	switch n.State {
+	case "ok":
+		icon = "✅"
+	case "no_data", "paused", "pending":
+		icon = "⚠️"
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s\n%s\n%s", icon, n.Title, n.Message, n.RuleURL)), nil
+}
+
+// formatAlerts lists the alerts of a notification under a heading saying
+// whether they're firing or resolved.
+func formatAlerts(n alertNotification, title string) string {
+	icon := "🔥 FIRING"
+	if n.Status == "resolved" {
+		icon = "✅ RESOLVED"
+	}
+	if title == "" {
+		title = n.Alerts[0].Labels["alertname"]
+		if len(n.Alerts) > 1 {
+			title = fmt.Sprintf("%d alerts", len(n.Alerts))
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s", icon, title)
+	for i, a := range n.Alerts {
+		if i == maxAlerts {
+			fmt.Fprintf(&sb, "\n… and %d more", len(n.Alerts)-maxAlerts)
+			break
+		}
+		name := a.Annotations["summary"]
+		if name == "" {
+			name = a.Labels["alertname"]
+		}
+		fmt.Fprintf(&sb, "\n\n• %s", name)
+		if a.Status == "resolved" && n.Status != "resolved" {
+			sb.WriteString(" (resolved)")
+		}
+		if severity := a.Labels["severity"]; severity != "" {
+			fmt.Fprintf(&sb, " [%s]", severity)
+		}
+		if instance := a.Labels["instance"]; instance != "" {
+			fmt.Fprintf(&sb, " on %s", instance)
+		}
+		if description := a.Annotations["description"]; description != "" {
+			fmt.Fprintf(&sb, "\n%s", truncate(description, 300))
+		}
+	}
+	if n.ExternalURL != "" {
+		fmt.Fprintf(&sb, "\n\n%s", n.ExternalURL)
+	}
+	return sb.String()
+}
@@ -0,0 +1,515 @@
+// Package mqtt is a small MQTT 3.1.1 client, enough to publish to and
+// subscribe to topics on a broker such as Mosquitto.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeepAlive = time.Minute
+	dialTimeout      = 10 * time.Second
+	maxPacket        = 1 << 20
+)
+
+// Packet types, in the high nibble of a packet's first byte.
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// connackErrors are the brokers' reasons for refusing a connection.
+var connackErrors = map[byte]string{
+	1: "unsupported protocol version",
+	2: "client ID rejected",
+	3: "server unavailable",
+	4: "bad username or password",
+	5: "not authorized",
+}
+
+// ErrClosed is returned by calls on a client whose connection has ended.
+var ErrClosed = errors.New("connection closed")
+
+// Options says which broker to connect to and how.
+type Options struct {
+	URL       string // mqtt://host:1883, or mqtts:// for TLS
+	Username  string
+	Password  string
+	ClientID  string        // Random if empty
+	KeepAlive time.Duration // A minute if zero
+}
+
+// Message is a message published to a topic the client subscribed to.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool // Stored by the broker before the client subscribed
+}
+
+// Client is a connection to a broker. Messages for its subscriptions are
+// delivered on Messages until the connection ends.
+type Client struct {
+	conn     net.Conn
+	writeMu  sync.Mutex
+	messages chan Message
+	done     chan struct{}
+
+	mu     sync.Mutex
+	nextID uint16
+	acks   map[uint16]chan []byte // Waiting for PUBACK, SUBACK, or UNSUBACK
+	err    error
+}
+
+// Dial connects to the broker.
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid broker URL %q", opts.URL)
+	}
+	addr := u.Host
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+		dialer = &net.Dialer{Timeout: dialTimeout}
+	case "mqtts", "ssl", "tls":
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "8883")
+		}
+		dialer = &tls.Dialer{NetDialer: &net.Dialer{Timeout: dialTimeout}}
+	default:
+		return nil, fmt.Errorf("broker URL %q should start with mqtt:// or mqtts://", opts.URL)
+	}
+	if opts.Username == "" && u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if opts.ClientID == "" {
+		id := make([]byte, 6)
+		rand.Read(id)
+		opts.ClientID = "telegram-bot-" + hex.EncodeToString(id)
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(dialTimeout))
+	}
+	r := bufio.NewReader(conn)
+	if err := connect(conn, r, opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{
+		conn:     conn,
+		messages: make(chan Message, 64),
+		done:     make(chan struct{}),
+		acks:     make(map[uint16]chan []byte),
+	}
+	go c.read(r, opts.KeepAlive)
+	go c.ping(opts.KeepAlive)
+	return c, nil
+}
+
+// connect sends CONNECT and waits for the broker to accept it.
+func connect(w io.Writer, r *bufio.Reader, opts Options) error {
+	flags := byte(0x02) // Clean session
+	payload := appendString(nil, opts.ClientID)
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+		if opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, opts.Password)
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = append(body, payload...)
+	if err := writePacket(w, typeConnect<<4, body); err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+
+	header, body, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("waiting for the broker: %w", err)
+	}
+	if header>>4 != typeConnack || len(body) != 2 {
+		return fmt.Errorf("unexpected reply to connecting (packet type %d)", header>>4)
+	}
+	if code := body[1]; code != 0 {
+		reason, ok := connackErrors[code]
+		if !ok {
+			reason = fmt.Sprintf("code %d", code)
+		}
+		return fmt.Errorf("broker refused the connection: %s", reason)
+	}
+	return nil
+}
+
+// Messages returns the channel messages for the client's subscriptions
+// arrive on. It is closed when the connection ends; Err says why.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Err returns why the connection ended, or nil while it's open or if it
+// was closed with Close.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errors.Is(c.err, ErrClosed) {
+		return nil
+	}
+	return c.err
+}
+
+// Publish sends a message with QoS 1 and waits for the broker to
+// acknowledge it. Retained messages are kept by the broker and sent to
+// clients that subscribe later.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, retain bool) error {
+	if err := ValidTopic(topic); err != nil {
+		return err
+	}
+	id, ack := c.await()
+	header := byte(typePublish<<4 | 1<<1) // QoS 1
+	if retain {
+		header |= 1
+	}
+	body := appendString(nil, topic)
+	body = binary.BigEndian.AppendUint16(body, id)
+	body = append(body, payload...)
+	if _, err := c.send(ctx, id, ack, header, body); err != nil {
+		return fmt.Errorf("publishing to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Subscribe subscribes to topic filters with QoS 0 and waits for the
+// broker to accept them.
+func (c *Client) Subscribe(ctx context.Context, filters ...string) error {
+	id, ack := c.await()
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, f := range filters {
+		if err := ValidFilter(f); err != nil {
+			c.forget(id)
+			return err
+		}
+		body = appendString(body, f)
+		body = append(body, 0)
+	}
+	codes, err := c.send(ctx, id, ack, typeSubscribe<<4|0x02, body)
+	if err != nil {
+		return fmt.Errorf("subscribing: %w", err)
+	}
+	for i, code := range codes {
+		if code == 0x80 && i < len(filters) {
+			return fmt.Errorf("the broker refused the subscription to %s", filters[i])
+		}
+	}
+	return nil
+}
+
+// Unsubscribe removes subscriptions to topic filters.
+func (c *Client) Unsubscribe(ctx context.Context, filters ...string) error {
+	id, ack := c.await()
+	body := binary.BigEndian.AppendUint16(nil, id)
+	for _, f := range filters {
+		body = appendString(body, f)
+	}
+	if _, err := c.send(ctx, id, ack, typeUnsubscribe<<4|0x02, body); err != nil {
+		return fmt.Errorf("unsubscribing: %w", err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	c.write(typeDisconnect<<4, nil)
+	c.fail(ErrClosed)
+	return nil
+}
+
+// await reserves a packet ID and the channel its acknowledgement arrives on.
+func (c *Client) await() (uint16, chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	ack := make(chan []byte, 1)
+	c.acks[c.nextID] = ack
+	return c.nextID, ack
+}
+
+func (c *Client) forget(id uint16) {
+	c.mu.Lock()
+	delete(c.acks, id)
+	c.mu.Unlock()
+}
+
+// send writes a packet and waits for its acknowledgement, returning what
+// follows the packet ID in it.
+func (c *Client) send(ctx context.Context, id uint16, ack chan []byte, header byte, body []byte) ([]byte, error) {
+	defer c.forget(id)
+	if err := c.write(header, body); err != nil {
+		return nil, err
+	}
+	select {
+	case payload := <-ack:
+		return payload, nil
+	case <-c.done:
+		return nil, c.closedErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	if err := writePacket(c.conn, header, body); err != nil {
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+// read handles the packets the broker sends until the connection ends.
+// The broker pings back at least every keepAlive, so a longer silence
+// means the connection is dead.
+func (c *Client) read(r *bufio.Reader, keepAlive time.Duration) {
+	defer close(c.messages)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		header, body, err := readPacket(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch header >> 4 {
+		case typePublish:
+			msg, id, err := parsePublish(header, body)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			if header&0x06 != 0 {
+				c.write(typePuback<<4, binary.BigEndian.AppendUint16(nil, id))
+			}
+			select {
+			case c.messages <- msg:
+			case <-c.done:
+				return
+			}
+		case typePuback, typeSuback, typeUnsuback:
+			if len(body) < 2 {
+				continue
+			}
+			id := binary.BigEndian.Uint16(body)
+			c.mu.Lock()
+			ack, ok := c.acks[id]
+			c.mu.Unlock()
+			if ok {
+				ack <- body[2:]
+			}
+		}
+	}
+}
+
+func (c *Client) ping(keepAlive time.Duration) {
+	ticker := time.NewTicker(keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if c.write(typePingreq<<4, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// fail ends the connection with err, unless it already ended.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+func (c *Client) closedErr() error {
+	if err := c.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrClosed, err)
+	}
+	return ErrClosed
+}
+
+func parsePublish(header byte, body []byte) (Message, uint16, error) {
+	topic, rest, err := readString(body)
+	if err != nil {
+		return Message{}, 0, fmt.Errorf("reading message: %w", err)
+	}
+	var id uint16
+	if header&0x06 != 0 {
+		if len(rest) < 2 {
+			return Message{}, 0, fmt.Errorf("reading message: no packet ID")
+		}
+		id, rest = binary.BigEndian.Uint16(rest), rest[2:]
+	}
+	return Message{Topic: topic, Payload: rest, Retained: header&1 != 0}, id, nil
+}
+
+func writePacket(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, fmt.Errorf("malformed packet length")
+		}
+	}
+	if n > maxPacket {
+		return 0, nil, fmt.Errorf("packet of %d bytes is too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// ValidTopic checks a topic to publish to, which can't have wildcards.
+func ValidTopic(topic string) error {
+	if topic == "" || len(topic) > 65535 {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("topic %q can't contain wildcards when publishing", topic)
+	}
+	return nil
+}
+
+// ValidFilter checks a topic filter to subscribe to: + matches one level
+// and # the rest, as in home/+/temperature or home/#.
+func ValidFilter(filter string) error {
+	if filter == "" || len(filter) > 65535 {
+		return fmt.Errorf("invalid topic filter %q", filter)
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("topic filter %q can only have # as its last level", filter)
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("topic filter %q can only have + as a whole level", filter)
+		}
+	}
+	return nil
+}
+
+// Match reports whether a topic matches a topic filter.
+func Match(filter, topic string) bool {
+	// Wildcards at the start don't match the brokers' own $SYS topics
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	fl, tl := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
@@ -0,0 +1,104 @@
+// Package notify alerts the bot owner about failures that would otherwise only be logged.
+package notify
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"telegram-bot/outbox"
+	"telegram-bot/redact"
+)
+
+// repeatInterval suppresses identical notifications so a persistent failure
+// doesn't flood the owner chat.
+const repeatInterval = 10 * time.Minute
+
+// Notifier sends failure reports to the owner chats.
+type Notifier struct {
+	out      *outbox.Queue
+	redactor *redact.Redactor
+
+	mu       sync.Mutex
+	chatIDs  []int64
+	lastSent map[string]time.Time
+}
+
+// New creates a notifier that reports to the given chats (normally the
+// owners' private chats, whose IDs equal their user IDs).
+func New(out *outbox.Queue, chatIDs []int64, redactor *redact.Redactor) *Notifier {
+	return &Notifier{
+		out:      out,
+		chatIDs:  chatIDs,
+		redactor: redactor,
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+// AddChat reports to another chat from now on, as when an owner pairs
+// with the bot.
+func (n *Notifier) AddChat(chatID int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.chatIDs = append(n.chatIDs, chatID)
+}
+
+// Notify sends a message to every owner chat.
+func (n *Notifier) Notify(text string) {
+	n.mu.Lock()
+	chatIDs := n.chatIDs
+	if len(chatIDs) == 0 {
+		n.mu.Unlock()
+		log.Printf("[notify] no owner chat configured: %s", text)
+		return
+	}
+
+	for sent, at := range n.lastSent {
+		if time.Since(at) >= repeatInterval {
+			delete(n.lastSent, sent)
+		}
+	}
+	if _, ok := n.lastSent[text]; ok {
+		n.mu.Unlock()
+		return
+	}
+	n.lastSent[text] = time.Now()
+	n.mu.Unlock()
+
+	text = n.redactor.Redact(text)
+	for _, chatID := range chatIDs {
+		n.out.Send(chatID, tgbotapi.NewMessage(chatID, text))
+	}
+}
+
+// JobFailed reports an error from a background job.
+func (n *Notifier) JobFailed(job string, err error) {
+	n.Notify(fmt.Sprintf("⚠️ Background job %q failed:\n%v", job, err))
+}
+
+// DeliveryFailed reports a reply that could not be sent. It is meant to be
+// registered with outbox.Queue.OnFailure.
+func (n *Notifier) DeliveryFailed(chatID int64, msg tgbotapi.Chattable, err error) {
+	// A failure delivering to an owner chat can't be reported there
+	n.mu.Lock()
+	chatIDs := n.chatIDs
+	n.mu.Unlock()
+	for _, id := range chatIDs {
+		if id == chatID {
+			return
+		}
+	}
+
+	text := fmt.Sprintf("⚠️ Failed to deliver a message to chat %d:\n%v", chatID, err)
+	if m, ok := msg.(tgbotapi.MessageConfig); ok {
+		preview := m.Text
+		if len(preview) > 200 {
+			preview = preview[:200] + "..."
+		}
+		text += "\n\nMessage:\n" + preview
+	}
+	n.Notify(text)
+}
@@ -0,0 +1,100 @@
+package oci
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// DefaultAuthFiles lists where podman, skopeo, and docker keep the logins
+// of podman login and docker login, in the order they look.
+func DefaultAuthFiles() []string {
+	var files []string
+	if f := os.Getenv("REGISTRY_AUTH_FILE"); f != "" {
+		files = append(files, f)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		files = append(files, filepath.Join(dir, "containers", "auth.json"))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "containers", "auth.json"))
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		files = append(files, filepath.Join(dir, "config.json"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".docker", "config.json"))
+	}
+	return files
+}
+
+// keychain finds logins in auth files for go-containerregistry, which
+// asks for a repository's or a registry's.
+type keychain struct {
+	files []string
+}
+
+func (k keychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	name := authKey(target.RegistryStr())
+	if _, repo, ok := strings.Cut(target.String(), "/"); ok {
+		name += "/" + repo
+	}
+	if user, password, ok := k.credentials(name); ok {
+		return &authn.Basic{Username: user, Password: password}, nil
+	}
+	return authn.Anonymous, nil
+}
+
+// credentials returns the login for a registry or repository, such as
+// docker.io/library/alpine, from the first auth file that has one. A login
+// may be for a whole registry or, as podman allows, a namespace in it; the
+// most specific one wins. Credential helpers (credsStore) aren't supported.
+func (k keychain) credentials(name string) (user, password string, ok bool) {
+	name += "/"
+	for _, file := range k.files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var config struct {
+			Auths map[string]struct {
+				Auth string `json:"auth"`
+			} `json:"auths"`
+		}
+		if json.Unmarshal(data, &config) != nil {
+			continue
+		}
+		best := -1
+		for key, entry := range config.Auths {
+			key = authKey(key)
+			if !strings.HasPrefix(name, key+"/") || len(key) <= best {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			u, p, found := strings.Cut(string(decoded), ":")
+			if err != nil || !found {
+				continue
+			}
+			user, password, ok, best = u, p, true, len(key)
+		}
+		if ok {
+			return user, password, true
+		}
+	}
+	return "", "", false
+}
+
+// authKey normalizes a key of an auth file, such as
+// https://index.docker.io/v1/, to a registry and optional namespace.
+func authKey(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key = strings.TrimSuffix(key, "/")
+	key = strings.TrimSuffix(strings.TrimSuffix(key, "/v1"), "/v2")
+	if key == "index.docker.io" || key == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return key
+}
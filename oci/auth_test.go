@@ -0,0 +1,42 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentials(t *testing.T) {
+	dir := t.TempDir()
+	podman := filepath.Join(dir, "auth.json")
+	docker := filepath.Join(dir, "config.json")
+	os.WriteFile(podman, []byte(`{"auths": {
+		"ghcr.io": {"auth": "cmVnaXN0cnk6b25l"},
+		"ghcr.io/org": {"auth": "b3JnOnR3bw=="},
+		"quay.io": {"auth": "bm90LWJhc2U2NA"}
+	}}`), 0o600)
+	os.WriteFile(docker, []byte(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOnRocmVl"},
+		"quay.io": {"auth": "cXVheTpmb3Vy"}
+	}}`), 0o600)
+	k := keychain{files: []string{filepath.Join(dir, "missing.json"), podman, docker}}
+
+	tests := []struct {
+		name     string
+		wantUser string
+	}{
+		{name: "ghcr.io/other/app", wantUser: "registry"},
+		{name: "ghcr.io/org/app", wantUser: "org"},
+		{name: "ghcr.io/organization/app", wantUser: "registry"},
+		{name: "ghcr.io", wantUser: "registry"},
+		{name: "docker.io/library/alpine", wantUser: "hub"},
+		{name: "quay.io/team/app", wantUser: "quay"}, // The first file's login doesn't decode
+		{name: "gcr.io/project/app", wantUser: ""},
+	}
+	for _, tt := range tests {
+		user, _, ok := k.credentials(tt.name)
+		if user != tt.wantUser || ok != (tt.wantUser != "") {
+			t.Errorf("credentials(%q) = %q, %v, want %q", tt.name, user, ok, tt.wantUser)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Package oci reads, copies, annotates, and deletes images in registries
+// such as Docker Hub, GHCR, or Quay, with go-containerregistry and the
+// logins docker login and podman login saved, without skopeo or oras.
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Media types of the manifests the client understands.
+const (
+	MediaTypeOCIManifest    = string(types.OCIManifestSchema1)
+	MediaTypeOCIIndex       = string(types.OCIImageIndex)
+	MediaTypeDockerManifest = string(types.DockerManifestSchema2)
+	MediaTypeDockerList     = string(types.DockerManifestList)
+)
+
+// repositoryName is the distribution spec's grammar for repository names.
+var repositoryName = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+// Reference names a manifest in a registry by tag, digest, or both, in
+// which case the digest wins.
+type Reference struct {
+	Registry   string // e.g. docker.io or ghcr.io
+	Repository string // e.g. library/alpine
+	Tag        string
+	Digest     string // sha256:...
+}
+
+// ParseReference reads a fully qualified reference such as
+// ghcr.io/org/app:v1 or docker.io/library/alpine@sha256:... A reference
+// without a tag or digest means the latest tag.
+func ParseReference(s string) (Reference, error) {
+	var ref Reference
+	registry, rest, ok := strings.Cut(s, "/")
+	if !ok || registry == "" {
+		return ref, fmt.Errorf("invalid reference %q: no registry", s)
+	}
+	ref.Registry = registry
+	rest, ref.Digest, _ = strings.Cut(rest, "@")
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+	}
+	ref.Repository = rest
+
+	if !repositoryName.MatchString(ref.Repository) {
+		return ref, fmt.Errorf("invalid reference %q: bad repository name %q", s, ref.Repository)
+	}
+	if ref.Digest != "" && !validDigest(ref.Digest) {
+		return ref, fmt.Errorf("invalid reference %q: bad digest", s)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// Name is the reference without its tag or digest.
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// WithDigest returns the reference to another manifest of the repository.
+func (r Reference) WithDigest(digest string) Reference {
+	return Reference{Registry: r.Registry, Repository: r.Repository, Digest: digest}
+}
+
+// name converts the reference for go-containerregistry: by digest if it
+// has one, otherwise by tag.
+func (r Reference) name() (name.Reference, error) {
+	if r.Digest != "" {
+		return name.NewDigest(r.Name()+"@"+r.Digest, name.StrictValidation)
+	}
+	return name.NewTag(r.Name()+":"+r.Tag, name.StrictValidation)
+}
+
+func validDigest(d string) bool {
+	algo, hexSum, ok := strings.Cut(d, ":")
+	if !ok || algo != "sha256" || len(hexSum) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(hexSum)
+	return err == nil
+}
+
+// Digest returns the sha256 digest of content, such as a manifest.
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Error is an error response from a registry, such as a missing manifest
+// (MANIFEST_UNKNOWN) or a denied push (DENIED).
+type Error struct {
+	StatusCode int
+	Code       string // The registry's error code, if it sent one
+	Message    string
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	return fmt.Sprintf("registry returned %d: %s", e.StatusCode, msg)
+}
+
+// NotFound reports whether the repository, manifest, or blob doesn't exist.
+func (e *Error) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// Denied reports whether the registry refused the credentials, or their
+// absence.
+func (e *Error) Denied() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// Temporary reports whether the registry is rate limiting or failing, so
+// the same call may work later.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// registryError turns go-containerregistry's error for an unsuccessful
+// response into an *Error, and leaves other errors as they are.
+func registryError(err error) error {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return err
+	}
+	e := &Error{StatusCode: terr.StatusCode, Message: strings.ToLower(http.StatusText(terr.StatusCode))}
+	if len(terr.Errors) > 0 {
+		e.Code = string(terr.Errors[0].Code)
+		if terr.Errors[0].Message != "" {
+			e.Message = terr.Errors[0].Message
+		}
+	}
+	return e
+}
+
+// Options configures a client.
+type Options struct {
+	Transport http.RoundTripper // http.DefaultTransport if nil
+	AuthFiles []string          // Login files to read credentials from; DefaultAuthFiles if nil
+	UserAgent string
+}
+
+// Client talks to registries, logging in with the credentials that
+// docker login or podman login saved. Credentials are only sent to the
+// registry they're for, never to hosts a registry redirects to. It is
+// safe for concurrent use.
+type Client struct {
+	transport http.RoundTripper
+	keychain  keychain
+	userAgent string
+}
+
+// NewClient creates a client.
+func NewClient(opts Options) *Client {
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	authFiles := opts.AuthFiles
+	if authFiles == nil {
+		authFiles = DefaultAuthFiles()
+	}
+	return &Client{transport: transport, keychain: keychain{files: authFiles}, userAgent: opts.UserAgent}
+}
+
+func (c *Client) options(ctx context.Context) []remote.Option {
+	opts := []remote.Option{remote.WithContext(ctx), remote.WithTransport(c.transport), remote.WithAuthFromKeychain(c.keychain)}
+	if c.userAgent != "" {
+		opts = append(opts, remote.WithUserAgent(c.userAgent))
+	}
+	return opts
+}
+
+// Manifest fetches the manifest ref names, exactly as the registry serves
+// it, with its media type. A manifest fetched by digest is verified.
+func (c *Client) Manifest(ctx context.Context, ref Reference) ([]byte, string, error) {
+	desc, err := c.get(ctx, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	return desc.Manifest, string(desc.MediaType), nil
+}
+
+func (c *Client) get(ctx context.Context, ref Reference) (*remote.Descriptor, error) {
+	n, err := ref.name()
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Get(n, c.options(ctx)...)
+	if err != nil {
+		return nil, registryError(err)
+	}
+	return desc, nil
+}
+
+// rawManifest is a manifest to push byte for byte.
+type rawManifest struct {
+	data      []byte
+	mediaType string
+}
+
+func (m rawManifest) RawManifest() ([]byte, error)        { return m.data, nil }
+func (m rawManifest) MediaType() (types.MediaType, error) { return types.MediaType(m.mediaType), nil }
+
+// PutManifest uploads a manifest under ref's tag or digest and returns its
+// digest. The blobs and manifests it refers to must already be in the
+// repository.
+func (c *Client) PutManifest(ctx context.Context, ref Reference, mediaType string, data []byte) (string, error) {
+	n, err := ref.name()
+	if err != nil {
+		return "", err
+	}
+	if err := remote.Put(n, rawManifest{data, mediaType}, c.options(ctx)...); err != nil {
+		return "", registryError(err)
+	}
+	return Digest(data), nil
+}
+
+// Tags lists every tag of ref's repository, following the registry's
+// pagination.
+func (c *Client) Tags(ctx context.Context, ref Reference) ([]string, error) {
+	repo, err := name.NewRepository(ref.Name(), name.StrictValidation)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := remote.List(repo, c.options(ctx)...)
+	if err != nil {
+		return nil, registryError(err)
+	}
+	return tags, nil
+}
+
+// Blob opens a blob for reading. Reading it to the end fails if its
+// content doesn't match the digest.
+func (c *Client) Blob(ctx context.Context, ref Reference, digest string) (io.ReadCloser, error) {
+	if !validDigest(digest) {
+		return nil, fmt.Errorf("invalid digest %q (expected sha256:<64 hex chars>)", digest)
+	}
+	n, err := name.NewDigest(ref.Name()+"@"+digest, name.StrictValidation)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := remote.Layer(n, c.options(ctx)...)
+	if err != nil {
+		return nil, registryError(err)
+	}
+	body, err := layer.Compressed()
+	if err != nil {
+		return nil, registryError(err)
+	}
+	return body, nil
+}
+
+// Delete deletes the manifest ref names. A tag is resolved first, since
+// registries only delete manifests by digest; every tag pointing to the
+// manifest goes with it.
+func (c *Client) Delete(ctx context.Context, ref Reference) (string, error) {
+	digest := ref.Digest
+	if digest == "" {
+		n, err := ref.name()
+		if err != nil {
+			return "", err
+		}
+		desc, err := remote.Head(n, c.options(ctx)...)
+		if err != nil {
+			return "", registryError(err)
+		}
+		digest = desc.Digest.String()
+	}
+	n, err := ref.WithDigest(digest).name()
+	if err != nil {
+		return "", err
+	}
+	if err := remote.Delete(n, c.options(ctx)...); err != nil {
+		return "", registryError(err)
+	}
+	return digest, nil
+}
+
+// Annotate adds annotations to the manifest ref names, keeping the ones it
+// has, and pushes it back under ref's tag. The manifest gets a new digest,
+// which is returned.
+func (c *Client) Annotate(ctx context.Context, ref Reference, annotations map[string]string) (string, error) {
+	if ref.Tag == "" {
+		return "", errors.New("annotating changes the digest, so the reference needs a tag")
+	}
+	data, mediaType, err := c.Manifest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep every other field as it is
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("parsing manifest: %w", err)
+	}
+	merged := make(map[string]string)
+	if existing, ok := fields["annotations"]; ok {
+		if err := json.Unmarshal(existing, &merged); err != nil {
+			return "", fmt.Errorf("parsing annotations: %w", err)
+		}
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	if fields["annotations"], err = json.Marshal(merged); err != nil {
+		return "", err
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return "", err
+	}
+	return c.PutManifest(ctx, Reference{Registry: ref.Registry, Repository: ref.Repository, Tag: ref.Tag}, mediaType, data)
+}
+
+// Copy copies the manifest src names to dst, with every blob and, for an
+// index, every platform's manifest, and returns its digest. The manifest
+// is copied byte for byte, so the digest is the same in both places.
+// Blobs the destination already has are skipped, and blobs within one
+// registry are mounted rather than uploaded.
+func (c *Client) Copy(ctx context.Context, src, dst Reference) (string, error) {
+	desc, err := c.get(ctx, src)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %w", src, err)
+	}
+	target, err := dst.name()
+	if err != nil {
+		return "", err
+	}
+
+	if desc.MediaType.IsIndex() {
+		var index v1.ImageIndex
+		if index, err = desc.ImageIndex(); err == nil {
+			err = remote.WriteIndex(target, index, c.options(ctx)...)
+		}
+	} else {
+		var image v1.Image
+		if image, err = desc.Image(); err == nil {
+			err = remote.Write(target, image, c.options(ctx)...)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("copying to %s: %w", dst, registryError(err))
+	}
+	return desc.Digest.String(), nil
+}
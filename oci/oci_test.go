@@ -0,0 +1,344 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Reference
+		wantErr bool
+	}{
+		{in: "ghcr.io/org/app:v1", want: Reference{Registry: "ghcr.io", Repository: "org/app", Tag: "v1"}},
+		{in: "docker.io/library/alpine", want: Reference{Registry: "docker.io", Repository: "library/alpine", Tag: "latest"}},
+		{in: "docker.io/library/alpine@" + testDigest, want: Reference{Registry: "docker.io", Repository: "library/alpine", Digest: testDigest}},
+		{in: "localhost:5000/app:1.0@" + testDigest, want: Reference{Registry: "localhost:5000", Repository: "app", Tag: "1.0", Digest: testDigest}},
+		{in: "localhost:5000/team/app", want: Reference{Registry: "localhost:5000", Repository: "team/app", Tag: "latest"}},
+		{in: "alpine", wantErr: true},
+		{in: "/alpine", wantErr: true},
+		{in: "ghcr.io/Org/App:v1", wantErr: true},
+		{in: "ghcr.io/org/app@sha256:abc", wantErr: true},
+		{in: "ghcr.io/org/app@md5:" + strings.Repeat("0", 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseReference(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// fakeRegistry is an in-memory registry serving the parts of the
+// distribution API the client uses.
+type fakeRegistry struct {
+	noMount    bool   // Answer mount requests with an upload, as registries may
+	login      string // user:password the registry asks for, if any
+	uploadHost string // Where uploads are sent, if not this registry
+
+	mu        sync.Mutex
+	manifests map[string]fakeManifest // By repository and tag or digest
+	blobs     map[string][]byte       // By repository and digest
+	pending   map[string][]byte       // Uploads in progress, by ID
+	mounts    int
+	uploads   int
+	nextID    int
+}
+
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type fakeManifest struct {
+	mediaType string
+	data      []byte
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{manifests: make(map[string]fakeManifest), blobs: make(map[string][]byte), pending: make(map[string][]byte)}
+}
+
+// putBlob stores a blob in repo and returns its descriptor.
+func (f *fakeRegistry) putBlob(repo, mediaType string, data []byte) descriptor {
+	d := descriptor{MediaType: mediaType, Digest: Digest(data), Size: int64(len(data))}
+	f.blobs[repo+"@"+d.Digest] = data
+	return d
+}
+
+// putManifest stores a manifest in repo under its digest, and under tag
+// unless it's empty, and returns its descriptor.
+func (f *fakeRegistry) putManifest(repo, tag, mediaType string, v any) descriptor {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	d := descriptor{MediaType: mediaType, Digest: Digest(data), Size: int64(len(data))}
+	f.manifests[repo+"@"+d.Digest] = fakeManifest{mediaType, data}
+	if tag != "" {
+		f.manifests[repo+":"+tag] = fakeManifest{mediaType, data}
+	}
+	return d
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, password, _ := r.BasicAuth(); f.login != "" && user+":"+password != f.login {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		fail(w, http.StatusUnauthorized, "UNAUTHORIZED")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == "" {
+		return
+	}
+	if repo, ref, ok := strings.Cut(path, "/manifests/"); ok {
+		key := repo + ":" + ref
+		if strings.HasPrefix(ref, "sha256:") {
+			key = repo + "@" + ref
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			m, found := f.manifests[key]
+			if !found {
+				fail(w, http.StatusNotFound, "MANIFEST_UNKNOWN")
+				return
+			}
+			w.Header().Set("Content-Type", m.mediaType)
+			w.Header().Set("Content-Length", fmt.Sprint(len(m.data)))
+			w.Header().Set("Docker-Content-Digest", Digest(m.data))
+			if r.Method == http.MethodGet {
+				w.Write(m.data)
+			}
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			m := fakeManifest{r.Header.Get("Content-Type"), data}
+			f.manifests[key] = m
+			f.manifests[repo+"@"+Digest(data)] = m
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+	if repo, id, ok := strings.Cut(path, "/blobs/uploads/"); ok {
+		f.upload(w, r, repo, id)
+		return
+	}
+	if repo, digest, ok := strings.Cut(path, "/blobs/"); ok {
+		data, found := f.blobs[repo+"@"+digest]
+		if !found {
+			fail(w, http.StatusNotFound, "BLOB_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+		return
+	}
+	fail(w, http.StatusNotFound, "NAME_UNKNOWN")
+}
+
+// upload starts an upload, or mounts the blob if asked to and it can, or
+// adds a chunk to one, or finishes one with the rest of its content.
+func (f *fakeRegistry) upload(w http.ResponseWriter, r *http.Request, repo, id string) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodPost:
+		digest, from := query.Get("mount"), query.Get("from")
+		if data, found := f.blobs[from+"@"+digest]; found && !f.noMount {
+			f.blobs[repo+"@"+digest] = data
+			f.mounts++
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		f.nextID++
+		w.Header().Set("Location", fmt.Sprintf("%s/v2/%s/blobs/uploads/%d?state=x", f.uploadHost, repo, f.nextID))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case http.MethodPatch:
+		data, _ := io.ReadAll(r.Body)
+		f.pending[id] = append(f.pending[id], data...)
+		w.Header().Set("Location", r.URL.String())
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	rest, _ := io.ReadAll(r.Body)
+	data := append(f.pending[id], rest...)
+	delete(f.pending, id)
+	digest := query.Get("digest")
+	if id == "" || query.Get("state") != "x" || digest != Digest(data) {
+		fail(w, http.StatusBadRequest, "DIGEST_INVALID")
+		return
+	}
+	f.blobs[repo+"@"+digest] = data
+	f.uploads++
+	w.WriteHeader(http.StatusCreated)
+}
+
+func fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":"fake"}]}`, code)
+}
+
+// pushIndex stores a single-platform index of app:v1 in the registry and
+// returns it and its image manifest.
+func pushIndex(f *fakeRegistry) (index, image descriptor) {
+	config := f.putBlob("app", "application/vnd.oci.image.config.v1+json", []byte(`{"architecture":"amd64","os":"linux"}`))
+	layer := f.putBlob("app", "application/vnd.oci.image.layer.v1.tar+gzip", []byte("layer contents"))
+	image = f.putManifest("app", "", MediaTypeOCIManifest, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeOCIManifest,
+		"config":        config,
+		"layers":        []descriptor{layer},
+	})
+	index = f.putManifest("app", "v1", MediaTypeOCIIndex, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeOCIIndex,
+		"manifests":     []descriptor{image},
+	})
+	return index, image
+}
+
+func TestCopy(t *testing.T) {
+	tests := []struct {
+		name        string
+		noMount     bool
+		corrupt     bool // Serve the image manifest with other content than its digest
+		wantErr     string
+		wantMounts  int
+		wantUploads int
+	}{
+		{name: "mounts blobs", wantMounts: 2},
+		{name: "uploads when the registry won't mount", noMount: true, wantUploads: 2},
+		{name: "digest mismatch", corrupt: true, wantErr: "does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newFakeRegistry()
+			registry.noMount = tt.noMount
+			index, image := pushIndex(registry)
+			if tt.corrupt {
+				m := registry.manifests["app@"+image.Digest]
+				m.data = append(m.data, ' ')
+				registry.manifests["app@"+image.Digest] = m
+			}
+			srv := httptest.NewServer(registry)
+			defer srv.Close()
+
+			host := strings.TrimPrefix(srv.URL, "http://")
+			src, _ := ParseReference(host + "/app:v1")
+			dst, _ := ParseReference(host + "/copy:v1")
+			client := NewClient(Options{AuthFiles: []string{}})
+
+			digest, err := client.Copy(context.Background(), src, dst)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Copy: err = %v, want %q", err, tt.wantErr)
+				}
+				if _, found := registry.manifests["copy:v1"]; found {
+					t.Error("tag pushed despite the failed copy")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Copy: %v", err)
+			}
+
+			if digest != index.Digest {
+				t.Errorf("Copy = %s, want the source's digest %s", digest, index.Digest)
+			}
+			if m := registry.manifests["copy:v1"]; Digest(m.data) != index.Digest || m.mediaType != MediaTypeOCIIndex {
+				t.Errorf("copy:v1 is %s (%s), want the index", Digest(m.data), m.mediaType)
+			}
+			if _, found := registry.manifests["copy@"+image.Digest]; !found {
+				t.Error("the platform's manifest wasn't copied")
+			}
+			for key := range registry.blobs {
+				if repo, digest, _ := strings.Cut(key, "@"); repo == "app" {
+					if _, found := registry.blobs["copy@"+digest]; !found {
+						t.Errorf("blob %s wasn't copied", digest)
+					}
+				}
+			}
+			if registry.mounts != tt.wantMounts || registry.uploads != tt.wantUploads {
+				t.Errorf("%d mounts and %d uploads, want %d and %d", registry.mounts, registry.uploads, tt.wantMounts, tt.wantUploads)
+			}
+
+			// Everything is there now, so copying again sends no blobs
+			if _, err := client.Copy(context.Background(), src, dst); err != nil {
+				t.Fatalf("Copy again: %v", err)
+			}
+			if registry.mounts != tt.wantMounts || registry.uploads != tt.wantUploads {
+				t.Error("copying again sent blobs the destination had")
+			}
+		})
+	}
+}
+
+// TestCopyCredentials checks the destination's login goes only to its
+// registry, and not to a host the registry sends uploads to.
+func TestCopyCredentials(t *testing.T) {
+	var reached int
+	var leaked []string
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			leaked = append(leaked, auth)
+		}
+		fail(w, http.StatusForbidden, "DENIED")
+	}))
+	defer elsewhere.Close()
+
+	registry := newFakeRegistry()
+	registry.noMount = true
+	registry.login = "bot:hunter22"
+	registry.uploadHost = elsewhere.URL
+	pushIndex(registry)
+	srv := httptest.NewServer(registry)
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	login := fmt.Sprintf(`{"auths":{%q:{"auth":"Ym90Omh1bnRlcjIy"}}}`, host)
+	if err := os.WriteFile(authFile, []byte(login), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := ParseReference(host + "/app:v1")
+	dst, _ := ParseReference(host + "/copy:v1")
+	client := NewClient(Options{AuthFiles: []string{authFile}})
+
+	if _, _, err := client.Manifest(context.Background(), src); err != nil {
+		t.Fatalf("Manifest with the login: %v", err)
+	}
+	if _, err := client.Copy(context.Background(), src, dst); err == nil {
+		t.Error("Copy succeeded though uploads were refused")
+	}
+	if reached == 0 {
+		t.Error("the upload wasn't sent to the upload host")
+	}
+	if len(leaked) > 0 {
+		t.Errorf("the upload host was sent %q", leaked)
+	}
+}
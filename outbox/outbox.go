@@ -0,0 +1,188 @@
+// Package outbox delivers outgoing Telegram messages with retries,
+// flood-limit handling, and per-chat ordering.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxAttempts    = 5
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+	logPrefix      = "[outbox]"
+)
+
+// Sender sends a single message to Telegram. *tgbotapi.BotAPI implements it.
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+}
+
+// FailureFunc is called when a message could not be delivered after all retries.
+type FailureFunc func(chatID int64, msg tgbotapi.Chattable, err error)
+
+// SentFunc is called with each message once Telegram has accepted it.
+type SentFunc func(chatID int64, msg tgbotapi.Chattable, sent tgbotapi.Message)
+
+// Queue sends messages in the order they were enqueued for each chat,
+// while different chats are delivered independently.
+type Queue struct {
+	sender    Sender
+	onFailure FailureFunc
+	onSent    SentFunc
+
+	mu      sync.Mutex
+	pending map[int64][]tgbotapi.Chattable
+	active  sync.WaitGroup
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+// New creates a queue that delivers messages through the given sender.
+func New(sender Sender) *Queue {
+	return &Queue{
+		sender:  sender,
+		pending: make(map[int64][]tgbotapi.Chattable),
+		stop:    make(chan struct{}),
+	}
+}
+
+// OnFailure registers a callback for messages that permanently failed to send.
+// It must be called before any messages are enqueued.
+func (q *Queue) OnFailure(fn FailureFunc) {
+	q.onFailure = fn
+}
+
+// OnSent registers a callback for delivered messages, e.g. to learn the IDs
+// Telegram assigned to them. It must be called before any messages are enqueued.
+func (q *Queue) OnSent(fn SentFunc) {
+	q.onSent = fn
+}
+
+// Send enqueues a message for the chat. It returns immediately; delivery
+// happens in the background.
+func (q *Queue) Send(chatID int64, msg tgbotapi.Chattable) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending[chatID] = append(q.pending[chatID], msg)
+	if len(q.pending[chatID]) == 1 {
+		// No worker is running for this chat yet
+		q.active.Add(1)
+		go q.deliver(chatID)
+	}
+}
+
+// Depth returns the number of messages waiting to be delivered.
+func (q *Queue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for _, msgs := range q.pending {
+		n += len(msgs)
+	}
+	return n
+}
+
+// Flush waits for queued messages to be delivered. If the context ends
+// first, remaining retries are abandoned.
+func (q *Queue) Flush(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		q.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.stopped.Do(func() { close(q.stop) })
+		<-done
+	}
+}
+
+// deliver sends the chat's messages one at a time until its queue is empty.
+func (q *Queue) deliver(chatID int64) {
+	defer q.active.Done()
+
+	for {
+		q.mu.Lock()
+		msg := q.pending[chatID][0]
+		q.mu.Unlock()
+
+		sent, err := q.sendWithRetry(msg)
+		if err != nil {
+			log.Printf("%s giving up on message to chat %d: %v", logPrefix, chatID, err)
+			if q.onFailure != nil {
+				q.onFailure(chatID, msg, err)
+			}
+		} else if q.onSent != nil {
+			q.onSent(chatID, msg, sent)
+		}
+
+		q.mu.Lock()
+		q.pending[chatID] = q.pending[chatID][1:]
+		if len(q.pending[chatID]) == 0 {
+			delete(q.pending, chatID)
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (q *Queue) sendWithRetry(msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	backoff := initialBackoff
+
+	var sent tgbotapi.Message
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if sent, err = q.sender.Send(msg); err == nil {
+			return sent, nil
+		}
+
+		wait, retry := retryDelay(err, backoff)
+		if !retry || attempt == maxAttempts {
+			return sent, err
+		}
+
+		log.Printf("%s send failed (attempt %d/%d), retrying in %v: %v", logPrefix, attempt, maxAttempts, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-q.stop:
+			return sent, err
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return sent, err
+}
+
+// retryDelay decides whether a send error is worth retrying and how long to wait.
+// Flood-control errors carry the exact wait; other API errors are permanent
+// except for server-side failures. Network errors are retried with backoff.
+func retryDelay(err error, backoff time.Duration) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return backoff, true
+	}
+
+	switch {
+	case apiErr.RetryAfter > 0:
+		return time.Duration(apiErr.RetryAfter) * time.Second, true
+	case apiErr.Code >= 500:
+		return backoff, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,116 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantWait  time.Duration
+		wantRetry bool
+	}{
+		{name: "network error", err: errors.New("connection reset"), wantWait: 4 * time.Second, wantRetry: true},
+		{name: "flood control", err: &tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}}, wantWait: 7 * time.Second, wantRetry: true},
+		{name: "server error", err: &tgbotapi.Error{Code: 502}, wantWait: 4 * time.Second, wantRetry: true},
+		{name: "bad request", err: &tgbotapi.Error{Code: 400}, wantRetry: false},
+		{name: "blocked by the user", err: &tgbotapi.Error{Code: 403}, wantRetry: false},
+	}
+	for _, tt := range tests {
+		wait, retry := retryDelay(tt.err, 4*time.Second)
+		if wait != tt.wantWait || retry != tt.wantRetry {
+			t.Errorf("%s: retryDelay = %v, %v, want %v, %v", tt.name, wait, retry, tt.wantWait, tt.wantRetry)
+		}
+	}
+}
+
+// fakeSender records the text of each message, failing those listed in fail.
+type fakeSender struct {
+	fail map[string]error
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func (f *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	text := c.(tgbotapi.MessageConfig).Text
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, text)
+	if err := f.fail[text]; err != nil {
+		return tgbotapi.Message{}, err
+	}
+	return tgbotapi.Message{MessageID: len(f.sent), Text: text}, nil
+}
+
+// TestQueue checks each chat's messages go out in order, and a permanent
+// failure is reported without holding up the messages behind it.
+func TestQueue(t *testing.T) {
+	sender := &fakeSender{fail: map[string]error{"b": &tgbotapi.Error{Code: 400, Message: "bad request"}}}
+	q := New(sender)
+
+	var mu sync.Mutex
+	var failed, delivered []string
+	q.OnFailure(func(chatID int64, msg tgbotapi.Chattable, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, msg.(tgbotapi.MessageConfig).Text)
+	})
+	q.OnSent(func(chatID int64, msg tgbotapi.Chattable, sent tgbotapi.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		if chatID == 1 {
+			delivered = append(delivered, sent.Text)
+		}
+	})
+
+	for _, text := range []string{"a", "b", "c", "d"} {
+		q.Send(1, tgbotapi.NewMessage(1, text))
+	}
+	q.Send(2, tgbotapi.NewMessage(2, "other chat"))
+	q.Flush(context.Background())
+
+	if q.Depth() != 0 {
+		t.Errorf("Depth = %d after Flush, want 0", q.Depth())
+	}
+	if len(sender.sent) != 5 {
+		t.Errorf("sent %q, want each message once", sender.sent)
+	}
+	if !slices.Equal(failed, []string{"b"}) {
+		t.Errorf("failed = %q, want b", failed)
+	}
+	if want := []string{"a", "c", "d"}; !slices.Equal(delivered, want) {
+		t.Errorf("delivered %q to chat 1, want %q in order", delivered, want)
+	}
+}
+
+// TestFlushCancelled checks a cancelled Flush abandons retries.
+func TestFlushCancelled(t *testing.T) {
+	sender := &fakeSender{fail: map[string]error{"down": errors.New("connection refused")}}
+	q := New(sender)
+	failed := make(chan error, 1)
+	q.OnFailure(func(chatID int64, msg tgbotapi.Chattable, err error) { failed <- err })
+	q.Send(1, tgbotapi.NewMessage(1, "down"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	q.Flush(ctx)
+
+	if elapsed := time.Since(start); elapsed > initialBackoff {
+		t.Errorf("Flush took %v, want it to stop retrying when the context ends", elapsed)
+	}
+	select {
+	case <-failed:
+	default:
+		t.Error("the abandoned message wasn't reported as failed")
+	}
+}
@@ -0,0 +1,233 @@
+// Package priority limits how many agent runs share the model backend at
+// once and decides which waiting request goes next, so quick questions
+// aren't stuck behind long tool runs and no one user can hog the bot.
+package priority
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Class is a guess at how long a request will keep the model busy.
+type Class int
+
+const (
+	Light Class = iota // Answered directly or with one quick tool call
+	Heavy              // Likely to run code, fetch pages, or loop over many tool calls
+)
+
+func (c Class) String() string {
+	if c == Light {
+		return "light"
+	}
+	return "heavy"
+}
+
+// A heavy request that has waited this long is scheduled as if it were
+// light, so a steady stream of quick questions can't starve it.
+const promoteAfter = 2 * time.Minute
+
+// Requests longer than this are treated as heavy whatever they ask for.
+const maxLightLength = 300
+
+// Words that suggest a request will run code, fetch content, or otherwise
+// need long or repeated tool calls.
+var heavyWords = map[string]bool{
+	"python": true, "script": true, "code": true, "program": true,
+	"run": true, "execute": true, "bash": true, "shell": true,
+	"install": true, "pip": true, "package": true, "build": true,
+	"compile": true, "test": true, "tests": true, "debug": true,
+	"refactor": true, "implement": true, "write": true, "generate": true,
+	"scrape": true, "crawl": true, "summarize": true, "summarise": true,
+	"analyze": true, "analyse": true, "article": true, "website": true,
+	"image": true, "images": true, "container": true, "registry": true,
+	"promote": true, "deploy": true, "repo": true, "repository": true,
+}
+
+// Classify guesses from a request's text whether it is light or heavy.
+// Links, code blocks, long messages, and words like "python" or
+// "summarize" make a request heavy; everything else (the time, the
+// weather, a calendar lookup, small talk) is light.
+func Classify(text string) Class {
+	lower := strings.ToLower(text)
+	if len(lower) > maxLightLength ||
+		strings.Contains(lower, "```") ||
+		strings.Contains(lower, "http://") ||
+		strings.Contains(lower, "https://") ||
+		strings.Contains(lower, "www.") {
+		return Heavy
+	}
+
+	words := strings.FieldsFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if heavyWords[word] {
+			return Heavy
+		}
+	}
+	return Light
+}
+
+// Stats describes the queue's current load.
+type Stats struct {
+	Slots        int // Zero means unlimited
+	Running      int
+	WaitingLight int
+	WaitingHeavy int
+}
+
+// Queue hands out a fixed number of run slots. When a slot frees up it goes
+// to the waiting request with the best claim: light before heavy, then the
+// user with the fewest runs in progress, then the user served longest ago,
+// then the request that has waited longest.
+type Queue struct {
+	slots int
+
+	mu         sync.Mutex
+	running    int
+	perUser    map[int64]int   // Runs in progress per user
+	lastServed map[int64]int64 // When each user last got a slot, as a turn number
+	turn       int64
+	waiting    []*waiter
+}
+
+type waiter struct {
+	userID int64
+	class  Class
+	queued time.Time
+	ready  chan struct{} // Closed once the waiter holds a slot
+}
+
+// New creates a queue that lets up to slots runs proceed at once. Zero or
+// less means no limit.
+func New(slots int) *Queue {
+	return &Queue{
+		slots:      slots,
+		perUser:    make(map[int64]int),
+		lastServed: make(map[int64]int64),
+	}
+}
+
+// Acquire waits for a slot for one of the user's requests and returns a
+// function that gives it back, which must be called when the run finishes.
+// It returns an error only if ctx is done before a slot is free.
+func (q *Queue) Acquire(ctx context.Context, userID int64, class Class) (release func(), err error) {
+	release = func() { q.release(userID) }
+
+	q.mu.Lock()
+	if q.slots <= 0 || (q.running < q.slots && len(q.waiting) == 0) {
+		q.start(userID)
+		q.mu.Unlock()
+		return release, nil
+	}
+	w := &waiter{userID: userID, class: class, queued: time.Now(), ready: make(chan struct{})}
+	q.waiting = append(q.waiting, w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	select {
+	case <-w.ready:
+		// Granted a slot while giving up; pass it on
+		q.mu.Unlock()
+		release()
+		return nil, ctx.Err()
+	default:
+	}
+	for i, other := range q.waiting {
+		if other == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+// Stats returns the queue's current load.
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := Stats{Slots: max(q.slots, 0), Running: q.running}
+	for _, w := range q.waiting {
+		if w.class == Light {
+			s.WaitingLight++
+		} else {
+			s.WaitingHeavy++
+		}
+	}
+	return s
+}
+
+// start gives the user a slot. The caller must hold q.mu.
+func (q *Queue) start(userID int64) {
+	q.running++
+	q.perUser[userID]++
+	q.turn++
+	q.lastServed[userID] = q.turn
+}
+
+func (q *Queue) release(userID int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running--
+	if q.perUser[userID]--; q.perUser[userID] <= 0 {
+		delete(q.perUser, userID)
+	}
+
+	for q.running < q.slots && len(q.waiting) > 0 {
+		i := q.next()
+		w := q.waiting[i]
+		q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+		q.start(w.userID)
+		close(w.ready)
+	}
+}
+
+// next returns the index of the waiter that should get the next slot. The
+// caller must hold q.mu.
+func (q *Queue) next() int {
+	now := time.Now()
+	class := func(w *waiter) Class {
+		if now.Sub(w.queued) >= promoteAfter {
+			return Light
+		}
+		return w.class
+	}
+
+	best := 0
+	for i := 1; i < len(q.waiting); i++ {
+		a, b := q.waiting[i], q.waiting[best]
+		if ca, cb := class(a), class(b); ca != cb {
+			if ca < cb {
+				best = i
+			}
+			continue
+		}
+		if ra, rb := q.perUser[a.userID], q.perUser[b.userID]; ra != rb {
+			if ra < rb {
+				best = i
+			}
+			continue
+		}
+		if sa, sb := q.lastServed[a.userID], q.lastServed[b.userID]; sa != sb {
+			if sa < sb {
+				best = i
+			}
+			continue
+		}
+		// Waiters are appended in arrival order, so the earlier index wins ties
+	}
+	return best
+}
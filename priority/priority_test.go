@@ -0,0 +1,117 @@
+package priority
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		text string
+		want Class
+	}{
+		{text: "What time is it in Tokyo?", want: Light},
+		{text: "hi!", want: Light},
+		{text: "Write a Python script to rename my photos", want: Heavy},
+		{text: "summarise https://example.com/post", want: Heavy},
+		{text: "look at www.example.com", want: Heavy},
+		{text: "what does this do?\n```\nx := 1\n```", want: Heavy},
+		{text: strings.Repeat("tell me more ", 30), want: Heavy},
+		{text: "Running late, remind me at 5", want: Light},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.text); got != tt.want {
+			t.Errorf("Classify(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestNext checks which waiter gets a freed slot.
+func TestNext(t *testing.T) {
+	now := time.Now()
+	stale := now.Add(-promoteAfter)
+	tests := []struct {
+		name       string
+		waiting    []*waiter
+		perUser    map[int64]int
+		lastServed map[int64]int64
+		want       int
+	}{
+		{
+			name:    "light before heavy",
+			waiting: []*waiter{{userID: 1, class: Heavy, queued: now}, {userID: 2, class: Light, queued: now}},
+			want:    1,
+		},
+		{
+			name:    "heavy waiting long enough counts as light",
+			waiting: []*waiter{{userID: 1, class: Heavy, queued: stale}, {userID: 2, class: Light, queued: now}},
+			want:    0,
+		},
+		{
+			name:    "fewest runs in progress",
+			waiting: []*waiter{{userID: 1, class: Light, queued: now}, {userID: 2, class: Light, queued: now}},
+			perUser: map[int64]int{1: 2, 2: 1},
+			want:    1,
+		},
+		{
+			name:       "served longest ago",
+			waiting:    []*waiter{{userID: 1, class: Light, queued: now}, {userID: 2, class: Light, queued: now}},
+			lastServed: map[int64]int64{1: 5, 2: 3},
+			want:       1,
+		},
+		{
+			name:    "first to arrive",
+			waiting: []*waiter{{userID: 1, class: Light, queued: now}, {userID: 1, class: Light, queued: now}},
+			want:    0,
+		},
+	}
+	for _, tt := range tests {
+		q := New(1)
+		q.waiting = tt.waiting
+		if tt.perUser != nil {
+			q.perUser = tt.perUser
+		}
+		if tt.lastServed != nil {
+			q.lastServed = tt.lastServed
+		}
+		if got := q.next(); got != tt.want {
+			t.Errorf("%s: next = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestAcquire checks a waiter gets the slot when it is released, and one
+// that gives up leaves the queue.
+func TestAcquire(t *testing.T) {
+	q := New(1)
+	release, err := q.Acquire(context.Background(), 1, Heavy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := q.Acquire(ctx, 2, Light); err == nil {
+		t.Error("Acquire with a cancelled context got a slot that was taken")
+	}
+
+	granted := make(chan func())
+	go func() {
+		r, _ := q.Acquire(context.Background(), 3, Light)
+		granted <- r
+	}()
+	for q.Stats().WaitingLight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if s := q.Stats(); s != (Stats{Slots: 1, Running: 1, WaitingLight: 1}) {
+		t.Errorf("Stats = %+v, want one running and one waiting", s)
+	}
+
+	release()
+	(<-granted)()
+	if s := q.Stats(); s != (Stats{Slots: 1}) {
+		t.Errorf("Stats = %+v after every run finished, want an idle queue", s)
+	}
+}
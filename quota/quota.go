@@ -0,0 +1,180 @@
+// Package quota enforces per-user daily usage limits.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+	"telegram-bot/store"
+)
+
+const storeKey = "quota"
+
+// Limits are the daily allowances per user. Zero means unlimited.
+type Limits struct {
+	MaxRequests    int
+	MaxToolSeconds int
+	MaxScrapeBytes int64
+}
+
+// Usage is what a user has consumed today.
+type Usage struct {
+	Requests    int     `json:"requests"`
+	ToolSeconds float64 `json:"tool_seconds"`
+	ScrapeBytes int64   `json:"scrape_bytes"`
+}
+
+// ExhaustedError reports which daily limit a user has reached.
+type ExhaustedError struct {
+	Limit   string
+	ResetAt time.Time
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("daily %s quota exhausted, resets at midnight", e.Limit)
+}
+
+// Message returns a polite explanation suitable for sending to the user.
+func (e *ExhaustedError) Message() string {
+	remaining := time.Until(e.ResetAt).Round(time.Minute)
+	return fmt.Sprintf("⏳ You've used up today's %s quota. It resets at midnight (in %s).", e.Limit, remaining)
+}
+
+type persisted struct {
+	Day   string           `json:"day"`
+	Usage map[int64]*Usage `json:"usage"`
+}
+
+// Tracker counts usage per user per day and persists it in the state store.
+// Owners are never limited.
+type Tracker struct {
+	limits Limits
+	store  *store.Store
+
+	mu    sync.Mutex
+	day   string
+	usage map[int64]*Usage
+}
+
+// NewTracker creates a tracker, restoring today's counters from the store.
+func NewTracker(limits Limits, st *store.Store) *Tracker {
+	t := &Tracker{
+		limits: limits,
+		store:  st,
+		day:    today(),
+		usage:  make(map[int64]*Usage),
+	}
+
+	var saved persisted
+	if ok, err := st.Get(storeKey, &saved); err != nil {
+		log.Printf("[quota] loading counters: %v", err)
+	} else if ok && saved.Day == t.day && saved.Usage != nil {
+		t.usage = saved.Usage
+	}
+
+	return t
+}
+
+// Check returns an *ExhaustedError if the user in the context has reached any daily limit.
+func (t *Tracker) Check(ctx context.Context) error {
+	user, ok := auth.UserFrom(ctx)
+	if !ok || user.Role == auth.Owner {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.get(user.ID)
+	switch {
+	case t.limits.MaxRequests > 0 && u.Requests >= t.limits.MaxRequests:
+		return t.exhausted("request")
+	case t.limits.MaxToolSeconds > 0 && u.ToolSeconds >= float64(t.limits.MaxToolSeconds):
+		return t.exhausted("compute")
+	case t.limits.MaxScrapeBytes > 0 && u.ScrapeBytes >= t.limits.MaxScrapeBytes:
+		return t.exhausted("scraping")
+	}
+	return nil
+}
+
+// AddRequest records one agent request for the user in the context.
+func (t *Tracker) AddRequest(ctx context.Context) {
+	t.update(ctx, func(u *Usage) { u.Requests++ })
+}
+
+// AddToolTime records time spent executing tools.
+func (t *Tracker) AddToolTime(ctx context.Context, d time.Duration) {
+	t.update(ctx, func(u *Usage) { u.ToolSeconds += d.Seconds() })
+}
+
+// AddScrapeBytes records bytes fetched by the scrape tool.
+func (t *Tracker) AddScrapeBytes(ctx context.Context, n int64) {
+	t.update(ctx, func(u *Usage) { u.ScrapeBytes += n })
+}
+
+// UsageFor returns today's usage for a user.
+func (t *Tracker) UsageFor(userID int64) Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *t.get(userID)
+}
+
+func (t *Tracker) update(ctx context.Context, fn func(*Usage)) {
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	fn(t.get(user.ID))
+	saved := persisted{Day: t.day, Usage: t.usage}
+	err := t.store.Set(storeKey, saved)
+	t.mu.Unlock()
+
+	if err != nil {
+		log.Printf("[quota] saving counters: %v", err)
+	}
+}
+
+// get returns the user's counters, resetting everything when the day changes.
+// Must be called with t.mu held.
+func (t *Tracker) get(userID int64) *Usage {
+	if day := today(); day != t.day {
+		t.day = day
+		t.usage = make(map[int64]*Usage)
+	}
+	u, ok := t.usage[userID]
+	if !ok {
+		u = &Usage{}
+		t.usage[userID] = u
+	}
+	return u
+}
+
+func (t *Tracker) exhausted(limit string) *ExhaustedError {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	return &ExhaustedError{Limit: limit, ResetAt: midnight}
+}
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+type trackerKey struct{}
+
+// WithTracker returns a context that lets tools record usage.
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+// RecordScrapeBytes records scraped bytes against the tracker in the context, if any.
+func RecordScrapeBytes(ctx context.Context, n int64) {
+	if t, ok := ctx.Value(trackerKey{}).(*Tracker); ok {
+		t.AddScrapeBytes(ctx, n)
+	}
+}
@@ -0,0 +1,84 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"telegram-bot/auth"
+	"telegram-bot/store"
+)
+
+func openStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestCheck(t *testing.T) {
+	guest := auth.WithUser(context.Background(), auth.User{ID: 1, Role: auth.Guest})
+	owner := auth.WithUser(context.Background(), auth.User{ID: 2, Role: auth.Owner})
+
+	tests := []struct {
+		name   string
+		limits Limits
+		ctx    context.Context
+		use    func(*Tracker, context.Context)
+		want   string // The exhausted limit, or "" for none
+	}{
+		{name: "under every limit", limits: Limits{MaxRequests: 2}, ctx: guest,
+			use: func(tr *Tracker, ctx context.Context) { tr.AddRequest(ctx) }},
+		{name: "requests", limits: Limits{MaxRequests: 1}, ctx: guest,
+			use: func(tr *Tracker, ctx context.Context) { tr.AddRequest(ctx) }, want: "request"},
+		{name: "compute", limits: Limits{MaxToolSeconds: 60}, ctx: guest,
+			use: func(tr *Tracker, ctx context.Context) { tr.AddToolTime(ctx, time.Minute) }, want: "compute"},
+		{name: "scraping", limits: Limits{MaxScrapeBytes: 100}, ctx: guest,
+			use: func(tr *Tracker, ctx context.Context) { RecordScrapeBytes(WithTracker(ctx, tr), 100) }, want: "scraping"},
+		{name: "zero means unlimited", limits: Limits{}, ctx: guest,
+			use: func(tr *Tracker, ctx context.Context) { tr.AddRequest(ctx) }},
+		{name: "owners aren't limited", limits: Limits{MaxRequests: 1}, ctx: owner,
+			use: func(tr *Tracker, ctx context.Context) { tr.AddRequest(ctx) }},
+		{name: "no user", limits: Limits{MaxRequests: 1}, ctx: context.Background(),
+			use: func(tr *Tracker, ctx context.Context) { tr.AddRequest(ctx) }},
+	}
+	for _, tt := range tests {
+		tr := NewTracker(tt.limits, openStore(t))
+		tt.use(tr, tt.ctx)
+
+		err := tr.Check(tt.ctx)
+		var exhausted *ExhaustedError
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: Check = %v, want nil", tt.name, err)
+		case tt.want != "" && !errors.As(err, &exhausted):
+			t.Errorf("%s: Check = %v, want the %s quota exhausted", tt.name, err, tt.want)
+		case tt.want != "" && exhausted.Limit != tt.want:
+			t.Errorf("%s: exhausted %s quota, want %s", tt.name, exhausted.Limit, tt.want)
+		}
+	}
+}
+
+// TestPersistence checks today's counters survive a restart and earlier
+// days' don't.
+func TestPersistence(t *testing.T) {
+	st := openStore(t)
+	ctx := auth.WithUser(context.Background(), auth.User{ID: 1, Role: auth.Trusted})
+	tr := NewTracker(Limits{}, st)
+	tr.AddRequest(ctx)
+	tr.AddScrapeBytes(ctx, 10)
+
+	if got := NewTracker(Limits{}, st).UsageFor(1); got.Requests != 1 || got.ScrapeBytes != 10 {
+		t.Errorf("restored usage = %+v, want 1 request and 10 bytes", got)
+	}
+
+	if err := st.Set(storeKey, persisted{Day: "2001-01-01", Usage: map[int64]*Usage{1: {Requests: 5}}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := NewTracker(Limits{}, st).UsageFor(1); got.Requests != 0 {
+		t.Errorf("usage from an earlier day = %+v, want it reset", got)
+	}
+}
@@ -0,0 +1,146 @@
+// Package redact masks secrets in text before it leaves the bot.
+package redact
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Mask replaces every redacted value.
+const Mask = "[REDACTED]"
+
+// minSecretLen avoids masking short configured values that would match ordinary text.
+const minSecretLen = 8
+
+// privateKeyPattern matches a PEM private key from its header to its footer.
+var privateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+
+// secretPatterns match well-known credential formats.
+var secretPatterns = []*regexp.Regexp{
+	privateKeyPattern,
+	regexp.MustCompile(`\b\d{8,10}:[A-Za-z0-9_-]{35}\b`),         // Telegram bot token
+	regexp.MustCompile(`\bGOCSPX-[A-Za-z0-9_-]{20,}`),            // Google OAuth client secret
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`),              // Google API key
+	regexp.MustCompile(`\bya29\.[0-9A-Za-z_-]{20,}`),             // Google access token
+	regexp.MustCompile(`\b1//[0-9A-Za-z_-]{30,}`),                // Google refresh token
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),          // AWS access key ID
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),         // GitHub token
+	regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{40,}\b`),       // GitHub fine-grained token
+	regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`),        // Slack token
+	regexp.MustCompile(`\bsk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}`), // OpenAI/Anthropic API key
+	// JWT
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`),
+}
+
+// assignmentPattern matches secret-looking assignments such as API_KEY=value
+// or "password": "value", capturing the value so only it is masked.
+var assignmentPattern = regexp.MustCompile(
+	`(?i)([A-Za-z0-9_.-]*(?:token|secret|passwd|password|api[_-]?key|private[_-]?key|access[_-]?key|credential)[A-Za-z0-9_.-]*"?\s*[=:]\s*"?)([^\s"',;]{6,})`,
+)
+
+// credentialHeader matches the names of headers that carry credentials.
+var credentialHeader = regexp.MustCompile(`(?i)auth|token|secret|key|passw|cookie|session`)
+
+var (
+	registeredMu sync.RWMutex
+	registered   []string // Secrets loaders reported, which every Redactor masks
+)
+
+// Register adds secrets every Redactor masks, for credentials loaded from
+// files rather than the environment, such as the scrape tool's site
+// logins. Empty and very short values are ignored.
+func Register(secrets ...string) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	registered = addSecrets(registered, secrets)
+}
+
+// RegisterHeader registers the value of a header that carries credentials,
+// such as Authorization or X-Api-Key, and the credential after its scheme
+// (Bearer ...). Other headers aren't secrets and are left alone.
+func RegisterHeader(name, value string) {
+	if !credentialHeader.MatchString(name) {
+		return
+	}
+	Register(value)
+	if _, credential, ok := strings.Cut(strings.TrimSpace(value), " "); ok {
+		Register(credential)
+	}
+}
+
+// Redactor masks known secret patterns and explicitly configured values.
+type Redactor struct {
+	secrets []string
+}
+
+// New creates a Redactor that additionally masks the given literal values,
+// typically the bot's own configured credentials. Empty and very short values are ignored.
+func New(secrets ...string) *Redactor {
+	return &Redactor{secrets: addSecrets(nil, secrets)}
+}
+
+// addSecrets adds the values long enough to mask to secrets, longest first
+// so a secret containing another is fully masked.
+func addSecrets(secrets, values []string) []string {
+	for _, s := range values {
+		s = strings.TrimSpace(s)
+		if len(s) >= minSecretLen && !slices.Contains(secrets, s) {
+			secrets = append(secrets, s)
+		}
+	}
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	return secrets
+}
+
+// literals returns the Redactor's secrets and the registered ones.
+func (r *Redactor) literals() []string {
+	registeredMu.RLock()
+	defer registeredMu.RUnlock()
+	if len(registered) == 0 {
+		return r.secrets
+	}
+	return addSecrets(slices.Clone(r.secrets), registered)
+}
+
+// Redact returns text with all detected secrets replaced by Mask.
+func (r *Redactor) Redact(text string) string {
+	for _, s := range r.literals() {
+		text = strings.ReplaceAll(text, s, Mask)
+	}
+	for _, re := range secretPatterns {
+		text = re.ReplaceAllString(text, Mask)
+	}
+	return assignmentPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := assignmentPattern.FindStringSubmatch(match)
+		if parts[2] == Mask {
+			return match
+		}
+		return parts[1] + Mask
+	})
+}
+
+// RedactPartial redacts the part of text still being written that no more
+// text can turn into a secret. It holds back the last word, which may be
+// the start of one, an end that starts a configured secret, and a private
+// key whose end hasn't arrived yet.
+func (r *Redactor) RedactPartial(text string) string {
+	text = strings.TrimRightFunc(text, func(c rune) bool { return !unicode.IsSpace(c) })
+	for _, s := range r.literals() {
+		for n := min(len(s)-1, len(text)); n > 0; n-- {
+			if strings.HasSuffix(text, s[:n]) {
+				text = text[:len(text)-n]
+				break
+			}
+		}
+	}
+	if i := strings.LastIndex(text, "-----BEGIN "); i >= 0 && !privateKeyPattern.MatchString(text[i:]) {
+		text = text[:i]
+	}
+	return r.Redact(text)
+}
@@ -0,0 +1,161 @@
+// Package runs tracks agent runs that are currently in progress.
+package runs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxTraceSteps = 100 // Older steps are dropped past this
+
+// ErrOverBudget is the cause of a watched run's cancellation when it ran
+// past its time budget.
+var ErrOverBudget = errors.New("run exceeded its time budget")
+
+// Run describes an in-progress agent run.
+type Run struct {
+	ID        int64
+	ChatID    int64
+	MessageID int // The request's message; 0 for runs started without one
+	UserName  string
+	Text      string
+	Started   time.Time
+	Trace     []Step // What the run has done so far, for watched runs
+
+	cancel  context.CancelCauseFunc // Set for watched runs
+	stopped bool
+}
+
+// Step is one thing a run did, such as a model or tool call.
+type Step struct {
+	Time time.Time
+	Text string
+}
+
+// Tracker records which agent runs are active.
+type Tracker struct {
+	mu     sync.Mutex
+	nextID int64
+	active map[int64]Run
+}
+
+// NewTracker creates an empty run tracker.
+func NewTracker() *Tracker {
+	return &Tracker{active: make(map[int64]Run)}
+}
+
+// Start records a new run and returns a function that marks it finished.
+func (t *Tracker) Start(chatID int64, userName, text string) (done func()) {
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.active[id] = Run{
+		ID:       id,
+		ChatID:   chatID,
+		UserName: userName,
+		Text:     text,
+		Started:  time.Now(),
+	}
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		delete(t.active, id)
+		t.mu.Unlock()
+	}
+}
+
+type runKey struct{}
+
+// Watch records a new run like Start, and returns a context for it that
+// Overdue can cancel. Steps recorded with that context make up its trace.
+func (t *Tracker) Watch(ctx context.Context, chatID int64, messageID int, userName, text string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.active[id] = Run{
+		ID:        id,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserName:  userName,
+		Text:      text,
+		Started:   time.Now(),
+		cancel:    cancel,
+	}
+	t.mu.Unlock()
+
+	return context.WithValue(ctx, runKey{}, id), func() {
+		t.mu.Lock()
+		delete(t.active, id)
+		t.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// Record adds a step to the trace of the watched run ctx belongs to.
+func (t *Tracker) Record(ctx context.Context, text string) {
+	id, ok := ctx.Value(runKey{}).(int64)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	run, ok := t.active[id]
+	if !ok {
+		return
+	}
+	run.Trace = append(run.Trace, Step{Time: time.Now(), Text: text})
+	if len(run.Trace) > maxTraceSteps {
+		run.Trace = run.Trace[len(run.Trace)-maxTraceSteps:]
+	}
+	t.active[id] = run
+}
+
+// Overdue cancels the watched runs that have been going longer than
+// budget, with ErrOverBudget as the cause, and returns them. Each run is
+// returned only once.
+func (t *Tracker) Overdue(budget time.Duration) []Run {
+	t.mu.Lock()
+	var overdue []Run
+	for id, run := range t.active {
+		if run.cancel == nil || run.stopped || time.Since(run.Started) <= budget {
+			continue
+		}
+		run.cancel(ErrOverBudget)
+		run.stopped = true
+		t.active[id] = run
+		overdue = append(overdue, run.copy())
+	}
+	t.mu.Unlock()
+
+	sort.Slice(overdue, func(i, j int) bool {
+		return overdue[i].Started.Before(overdue[j].Started)
+	})
+	return overdue
+}
+
+// copy returns the run with its own copy of the trace.
+func (r Run) copy() Run {
+	r.Trace = append([]Step(nil), r.Trace...)
+	return r
+}
+
+// Active returns the runs in progress, oldest first.
+func (t *Tracker) Active() []Run {
+	t.mu.Lock()
+	result := make([]Run, 0, len(t.active))
+	for _, run := range t.active {
+		result = append(result, run.copy())
+	}
+	t.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started.Before(result[j].Started)
+	})
+	return result
+}
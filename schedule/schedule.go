@@ -0,0 +1,99 @@
+// Package schedule runs background jobs at fixed intervals.
+package schedule
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Func is the work a job does on each run.
+type Func func(ctx context.Context) error
+
+type job struct {
+	name     string
+	interval time.Duration
+	fn       Func
+}
+
+// Scheduler runs registered jobs periodically. Each job has its own
+// goroutine, so a slow job never delays the others, and a job never
+// overlaps with itself.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []job
+	ctx     context.Context // Set once Run starts; later jobs start immediately
+	onError func(name string, err error)
+	running sync.WaitGroup
+}
+
+// New creates an empty scheduler.
+func New() *Scheduler {
+	return &Scheduler{}
+}
+
+// Every registers a job that runs every interval, first one interval after
+// the scheduler starts. Jobs may be added before or after Run is called.
+func (s *Scheduler) Every(name string, interval time.Duration, fn Func) {
+	j := job{name: name, interval: interval, fn: fn}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = append(s.jobs, j)
+	if s.ctx != nil {
+		s.start(s.ctx, j)
+	}
+}
+
+// Run starts all jobs and blocks until the context is cancelled and every
+// job has returned. Job errors are logged and passed to onError if it is non-nil.
+func (s *Scheduler) Run(ctx context.Context, onError func(name string, err error)) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.onError = onError
+	for _, j := range s.jobs {
+		s.start(ctx, j)
+	}
+	s.mu.Unlock()
+
+	<-ctx.Done()
+	s.running.Wait()
+}
+
+// start launches the job's loop. The caller must hold s.mu.
+func (s *Scheduler) start(ctx context.Context, j job) {
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.run(ctx, j)
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) run(ctx context.Context, j job) {
+	start := time.Now()
+	err := j.fn(ctx)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	log.Printf("[schedule] %s failed after %v: %v", j.name, time.Since(start).Round(time.Millisecond), err)
+	s.mu.Lock()
+	onError := s.onError
+	s.mu.Unlock()
+	if onError != nil {
+		onError(j.name, err)
+	}
+}
@@ -0,0 +1,303 @@
+// Package snapshot records versions of a directory in a separate git
+// repository, so changes to it can be listed and reverted.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var validID = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// Paths never recorded: package installs and caches that are large,
+// rebuilt on demand, and not worth reverting.
+var excludes = []string{
+	".venv/",
+	"venv/",
+	"node_modules/",
+	"__pycache__/",
+	"*.pyc",
+	".pytest_cache/",
+	".mypy_cache/",
+}
+
+// Repo snapshots a work tree into a git directory kept outside it, so the
+// history is invisible to (and can't be changed by) anything working in the
+// tree. Operations are serialized.
+type Repo struct {
+	gitDir   string
+	workTree string
+
+	mu sync.Mutex
+}
+
+// Entry is one snapshot in the history.
+type Entry struct {
+	ID      string // Abbreviated; accepted wherever a snapshot ID is
+	Time    time.Time
+	Message string
+	Files   []string // Paths that changed in this snapshot
+}
+
+// Change is a file that differs between two snapshots.
+type Change struct {
+	Path   string
+	Status string // "added", "modified", or "deleted"
+}
+
+// Open creates or opens the snapshot repository for workTree in gitDir.
+// It fails if git is not installed.
+func Open(ctx context.Context, gitDir, workTree string) (*Repo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is not installed")
+	}
+	gitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	workTree, err = filepath.Abs(workTree)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(workTree, 0755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", workTree, err)
+	}
+
+	r := &Repo{gitDir: gitDir, workTree: workTree}
+	if _, err := os.Stat(filepath.Join(gitDir, "HEAD")); os.IsNotExist(err) {
+		if _, err := r.git(ctx, "init", "--quiet"); err != nil {
+			return nil, fmt.Errorf("initializing snapshots: %w", err)
+		}
+	}
+
+	exclude := filepath.Join(gitDir, "info", "exclude")
+	if err := os.MkdirAll(filepath.Dir(exclude), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(exclude, []byte(strings.Join(excludes, "\n")+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("writing excludes: %w", err)
+	}
+	return r, nil
+}
+
+// Commit records the current state of the work tree with the given message
+// and returns the snapshot's ID. If nothing changed since the last
+// snapshot, no new one is made and the last one's ID is returned.
+func (r *Repo) Commit(ctx context.Context, message string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.commit(ctx, message)
+}
+
+func (r *Repo) commit(ctx context.Context, message string) (string, error) {
+	if _, err := r.git(ctx, "add", "--all", "."); err != nil {
+		return "", err
+	}
+
+	// The first snapshot is made even when the tree is empty, so there is
+	// always a HEAD to compare against
+	_, headErr := r.git(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+	_, diffErr := r.git(ctx, "diff", "--cached", "--quiet")
+	if headErr != nil || diffErr != nil {
+		if _, err := r.git(ctx, "commit", "--quiet", "--allow-empty", "--no-verify", "-m", message); err != nil {
+			return "", err
+		}
+	}
+	return r.git(ctx, "rev-parse", "HEAD")
+}
+
+// Changes lists the files that differ between two snapshots.
+func (r *Repo) Changes(ctx context.Context, from, to string) ([]Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changes(ctx, from, to)
+}
+
+func (r *Repo) changes(ctx context.Context, from, to string) ([]Change, error) {
+	out, err := r.git(ctx, "diff", "--name-status", "--no-renames", "-z", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	// With -z, each entry is a status field followed by a path field
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	var changes []Change
+	for i := 0; i+1 < len(fields); i += 2 {
+		status := "modified"
+		switch fields[i] {
+		case "A":
+			status = "added"
+		case "D":
+			status = "deleted"
+		}
+		changes = append(changes, Change{Path: fields[i+1], Status: status})
+	}
+	return changes, nil
+}
+
+// Diff returns the unified diff of the given files between two snapshots,
+// without git's "diff --git" and "index" lines, which say nothing a reader
+// on a phone needs.
+func (r *Repo) Diff(ctx context.Context, from, to string, paths ...string) (string, error) {
+	if !validID.MatchString(from) || !validID.MatchString(to) {
+		return "", fmt.Errorf("invalid snapshot ID")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	args := append([]string{"diff", "--no-color", "--no-ext-diff", "--no-renames", from, to, "--"}, paths...)
+	out, err := r.git(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, "diff --git ") && !strings.HasPrefix(line, "index ") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Log returns up to n snapshots that changed something, newest first. If
+// path is set, only snapshots that changed that file are returned.
+func (r *Repo) Log(ctx context.Context, path string, n int) ([]Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Each entry starts with a record separator, then the header fields
+	// separated by unit separators, then the changed paths one per line
+	args := []string{"log", fmt.Sprintf("-n%d", n), "--format=%x1e%h%x1f%ct%x1f%s", "--name-only"}
+	if path != "" {
+		args = append(args, "--", path)
+	}
+	out, err := r.git(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, record := range strings.Split(out, "\x1e") {
+		lines := strings.Split(strings.TrimSpace(record), "\n")
+		header := strings.SplitN(lines[0], "\x1f", 3)
+		if len(header) < 3 {
+			continue
+		}
+		seconds, _ := strconv.ParseInt(header[1], 10, 64)
+		entry := Entry{ID: header[0], Time: time.Unix(seconds, 0), Message: header[2]}
+		for _, line := range lines[1:] {
+			if line = strings.TrimSpace(line); line != "" {
+				entry.Files = append(entry.Files, line)
+			}
+		}
+		if len(entry.Files) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// RestoreFile puts one file back the way it was in the given snapshot and
+// records the result as a new snapshot with the given message.
+func (r *Repo) RestoreFile(ctx context.Context, id, path, message string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("invalid snapshot ID %q", id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.git(ctx, "cat-file", "-e", id+":"+path); err != nil {
+		return fmt.Errorf("%s did not exist in snapshot %s", path, id)
+	}
+
+	// Record any changes made since the last snapshot first, so restoring
+	// can itself be undone
+	if _, err := r.commit(ctx, "Before restoring "+path); err != nil {
+		return err
+	}
+	if _, err := r.git(ctx, "checkout", id, "--", path); err != nil {
+		return err
+	}
+	_, err := r.commit(ctx, message)
+	return err
+}
+
+// Revert puts the files changed between from and to back the way they were
+// in from: modified and deleted files are restored and added files are
+// removed. Other files are left alone. The result is recorded as a new
+// snapshot with the given message.
+func (r *Repo) Revert(ctx context.Context, from, to, message string) ([]Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes, err := r.changes(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var restore []string
+	for _, c := range changes {
+		if c.Status == "added" {
+			path := filepath.Join(r.workTree, c.Path)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("removing %s: %w", c.Path, err)
+			}
+			// Also remove directories the file was the only thing in
+			for dir := filepath.Dir(path); dir != r.workTree && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+			}
+			continue
+		}
+		restore = append(restore, c.Path)
+	}
+	if len(restore) > 0 {
+		args := append([]string{"checkout", from, "--"}, restore...)
+		if _, err := r.git(ctx, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := r.commit(ctx, message); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// git runs a git command against the snapshot repository and returns its
+// trimmed output.
+func (r *Repo) git(ctx context.Context, args ...string) (string, error) {
+	base := []string{
+		"--git-dir", r.gitDir,
+		"--work-tree", r.workTree,
+		// Don't let the user's global git config sign, rewrite, or hook into snapshots
+		"-c", "user.name=telegram-bot",
+		"-c", "user.email=telegram-bot@localhost",
+		"-c", "commit.gpgsign=false",
+		"-c", "core.autocrlf=false",
+		"-c", "core.hooksPath=/dev/null",
+		"-c", "core.quotePath=false",
+		// Paths come from users, so never treat them as patterns or magic
+		"--literal-pathspecs",
+	}
+	cmd := exec.CommandContext(ctx, "git", append(base, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,193 @@
+// Package store persists small pieces of bot state as JSON files.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var validKey = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Store keeps values in memory and writes changed ones to disk on Flush.
+// Each key is stored in its own file under the state directory.
+type Store struct {
+	dir    string
+	sealer Sealer // nil stores plain JSON
+
+	mu    sync.Mutex
+	data  map[string]json.RawMessage
+	dirty map[string]bool
+}
+
+// Open creates a store backed by the given directory, creating it if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	return &Store{
+		dir:   dir,
+		data:  make(map[string]json.RawMessage),
+		dirty: make(map[string]bool),
+	}, nil
+}
+
+// Sealer encrypts values on their way to disk and decrypts them on their
+// way back.
+type Sealer interface {
+	Seal(plaintext []byte) ([]byte, error)
+	Open(sealed []byte) ([]byte, error)
+}
+
+// OpenSealed creates a store like Open whose files are encrypted by sealer.
+func OpenSealed(dir string, sealer Sealer) (*Store, error) {
+	s, err := Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	s.sealer = sealer
+	return s, nil
+}
+
+// Get decodes the value stored under key into v.
+// Returns false if nothing has been stored under the key yet.
+func (s *Store) Get(key string, v any) (bool, error) {
+	if !validKey.MatchString(key) {
+		return false, fmt.Errorf("invalid store key: %q", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.data[key]
+	if !ok {
+		content, err := os.ReadFile(s.path(key))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("reading %s: %w", key, err)
+		}
+		if s.sealer != nil {
+			if content, err = s.sealer.Open(content); err != nil {
+				return false, fmt.Errorf("reading %s: %w", key, err)
+			}
+		}
+		raw = content
+		s.data[key] = raw
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("decoding %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Set stores v under key. The value is written to disk on the next Flush.
+func (s *Store) Set(key string, v any) error {
+	if !validKey.MatchString(key) {
+		return fmt.Errorf("invalid store key: %q", key)
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	s.data[key] = raw
+	s.dirty[key] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Save stores v under key and writes it to disk immediately, for state
+// that must survive a crash between flushes.
+func (s *Store) Save(key string, v any) error {
+	if err := s.Set(key, v); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writeFile(key, s.data[key]); err != nil {
+		return err
+	}
+	delete(s.dirty, key)
+	return nil
+}
+
+// Flush writes all changed values to disk.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.dirty {
+		if err := s.writeFile(key, s.data[key]); err != nil {
+			return err
+		}
+		delete(s.dirty, key)
+	}
+	return nil
+}
+
+// Run flushes the store periodically until the context is cancelled.
+// Flush errors are logged and passed to onError if it is non-nil.
+func (s *Store) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("[store] flush failed: %v", err)
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}
+}
+
+// writeFile replaces the key's file atomically so a crash never leaves partial JSON.
+func (s *Store) writeFile(key string, raw json.RawMessage) error {
+	content := []byte(raw)
+	if s.sealer != nil {
+		var err error
+		if content, err = s.sealer.Seal(raw); err != nil {
+			return fmt.Errorf("writing %s: %w", key, err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
@@ -0,0 +1,350 @@
+// Package tenant gives each user of a shared deployment a private space:
+// a workspace, a state directory whose files are encrypted with a key of
+// their own, such as their conversations, snippets, and reading list, and
+// an audit trail of the tools run for them.
+package tenant
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"telegram-bot/store"
+)
+
+const (
+	tenantsDir  = "tenants"
+	storeDir    = "state" // The tenant's store, inside its directory
+	auditFile   = "audit.log"
+	keySize     = 32 // AES-256
+	maxAuditArg = 500
+)
+
+// Tenant is one user's private space.
+type Tenant struct {
+	ID  string // The user's Telegram ID
+	dir string // Encrypted state
+	key []byte
+
+	mu sync.Mutex // Serializes audit appends
+
+	storeMu sync.Mutex
+	store   *store.Store // Opened on first use
+}
+
+// Manager hands out tenants, creating their state directories on first
+// use and deriving their keys from a master key.
+type Manager struct {
+	root   string // STATE_DIR/tenants
+	master []byte
+
+	mu      sync.Mutex
+	tenants map[int64]*Tenant
+}
+
+// NewManager creates a manager keeping tenants' state under stateDir,
+// with keys derived from masterKey (hex or base64, at least 32 bytes). The
+// key is never kept in the state directory, so neither a copy of that
+// directory nor a command that can read it can decrypt tenants' state.
+func NewManager(stateDir, masterKey string) (*Manager, error) {
+	master, err := parseMasterKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(stateDir, tenantsDir)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("creating tenants directory: %w", err)
+	}
+	return &Manager{root: root, master: master, tenants: make(map[int64]*Tenant)}, nil
+}
+
+func parseMasterKey(configured string) ([]byte, error) {
+	if configured == "" {
+		return nil, errors.New("a tenant key is required")
+	}
+	key, err := hex.DecodeString(configured)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(configured)
+	}
+	if err != nil || len(key) < keySize {
+		return nil, fmt.Errorf("the tenant key must be at least %d bytes, hex or base64", keySize)
+	}
+	return key, nil
+}
+
+// For returns the user's tenant.
+func (m *Manager) For(userID int64) (*Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.tenants[userID]; ok {
+		return t, nil
+	}
+
+	id := strconv.FormatInt(userID, 10)
+	dir := filepath.Join(m.root, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating tenant directory: %w", err)
+	}
+	key, err := hkdf.Key(sha256.New, m.master, nil, "telegram-bot tenant "+id, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("deriving tenant key: %w", err)
+	}
+	t := &Tenant{ID: id, dir: dir, key: key}
+	m.tenants[userID] = t
+	return t, nil
+}
+
+// All returns every tenant that has state, such as for background jobs
+// that run for each of them.
+func (m *Manager) All() ([]*Tenant, error) {
+	entries, err := os.ReadDir(m.root)
+	if err != nil {
+		return nil, fmt.Errorf("listing tenants: %w", err)
+	}
+	var all []*Tenant
+	for _, e := range entries {
+		userID, err := strconv.ParseInt(e.Name(), 10, 64)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		t, err := m.For(userID)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, t)
+	}
+	return all, nil
+}
+
+// Flush writes the changed state of every tenant whose store is open.
+func (m *Manager) Flush() error {
+	m.mu.Lock()
+	tenants := make([]*Tenant, 0, len(m.tenants))
+	for _, t := range m.tenants {
+		tenants = append(tenants, t)
+	}
+	m.mu.Unlock()
+
+	for _, t := range tenants {
+		t.storeMu.Lock()
+		st := t.store
+		t.storeMu.Unlock()
+		if st == nil {
+			continue
+		}
+		if err := st.Flush(); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// Run flushes tenants' stores periodically until the context is cancelled.
+// Flush errors are logged and passed to onError if it is non-nil.
+func (m *Manager) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				log.Printf("[tenant] flush failed: %v", err)
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}
+}
+
+// Dir returns a tenant's part of a workspace root.
+func Dir(root, id string) string {
+	return filepath.Join(root, tenantsDir, id)
+}
+
+// Store returns the tenant's store, whose values are encrypted with the
+// tenant's key.
+func (t *Tenant) Store() (*store.Store, error) {
+	t.storeMu.Lock()
+	defer t.storeMu.Unlock()
+	if t.store == nil {
+		st, err := store.OpenSealed(filepath.Join(t.dir, storeDir), t)
+		if err != nil {
+			return nil, err
+		}
+		t.store = st
+	}
+	return t.store, nil
+}
+
+// ReadFile reads and decrypts one of the tenant's files.
+func (t *Tenant) ReadFile(name string) ([]byte, error) {
+	sealed, err := os.ReadFile(filepath.Join(t.dir, filepath.Base(name)))
+	if err != nil {
+		return nil, err
+	}
+	return t.Open(sealed)
+}
+
+// WriteFile encrypts data into one of the tenant's files.
+func (t *Tenant) WriteFile(name string, data []byte) error {
+	sealed, err := t.Seal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(t.dir, filepath.Base(name)), sealed, 0600)
+}
+
+// Seal encrypts data with the tenant's key.
+func (t *Tenant) Seal(plaintext []byte) ([]byte, error) {
+	gcm, err := t.cipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	return gcm.Seal(nonce, nonce, plaintext, []byte(t.ID)), nil
+}
+
+// Open decrypts data sealed with the tenant's key.
+func (t *Tenant) Open(sealed []byte) ([]byte, error) {
+	gcm, err := t.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("decrypting: too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(t.ID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+	return plaintext, nil
+}
+
+func (t *Tenant) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(t.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Event is one entry in a tenant's audit trail.
+type Event struct {
+	Time     time.Time     `json:"time"`
+	Tool     string        `json:"tool"`
+	Args     string        `json:"args,omitempty"` // JSON, shortened
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Audit appends an event to the tenant's trail. Each line is encrypted on
+// its own, so the trail can grow without rewriting it.
+func (t *Tenant) Audit(e Event) error {
+	if len(e.Args) > maxAuditArg {
+		e.Args = e.Args[:maxAuditArg] + "..."
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sealed, err := t.Seal(line)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.OpenFile(filepath.Join(t.dir, auditFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("opening audit trail: %w", err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(sealed) + "\n")
+	return err
+}
+
+// AuditTrail returns the tenant's last n events, oldest first.
+func (t *Tenant) AuditTrail(n int) ([]Event, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.Open(filepath.Join(t.dir, auditFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening audit trail: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading audit trail: %w", err)
+	}
+
+	events := make([]Event, 0, len(lines))
+	for _, line := range lines {
+		sealed, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			continue
+		}
+		plaintext, err := t.Open(sealed)
+		if err != nil {
+			return nil, err
+		}
+		var e Event
+		if json.Unmarshal(plaintext, &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context carrying the tenant a request is handled for.
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// From returns the tenant stored in the context, if any.
+func From(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(*Tenant)
+	return t, ok
+}
+
+// Workspace returns the tenant's part of a workspace root, or the root
+// itself if the context has no tenant.
+func Workspace(ctx context.Context, root string) string {
+	if t, ok := From(ctx); ok {
+		return Dir(root, t.ID)
+	}
+	return root
+}
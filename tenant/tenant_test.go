@@ -0,0 +1,147 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testKey = strings.Repeat("ab", 32)
+
+func TestParseMasterKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "hex", key: testKey},
+		{name: "base64", key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+		{name: "empty", key: "", wantErr: true},
+		{name: "short hex", key: strings.Repeat("ab", 16), wantErr: true},
+		{name: "neither", key: "not a key at all, just words", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := parseMasterKey(tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseMasterKey err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// TestSeal checks each tenant can open only what was sealed for it.
+func TestSeal(t *testing.T) {
+	m, err := NewManager(t.TempDir(), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := m.For(1)
+	bob, _ := m.For(2)
+	if again, _ := m.For(1); again != alice {
+		t.Error("For returned a second tenant for the same user")
+	}
+	other, err := NewManager(t.TempDir(), strings.Repeat("cd", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceElsewhere, _ := other.For(1)
+
+	sealed, err := alice.Seal([]byte("alice's notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		tenant *Tenant
+		sealed []byte
+		ok     bool
+	}{
+		{name: "own data", tenant: alice, sealed: sealed, ok: true},
+		{name: "another tenant's", tenant: bob, sealed: sealed},
+		{name: "another master key", tenant: aliceElsewhere, sealed: sealed},
+		{name: "tampered", tenant: alice, sealed: tampered},
+		{name: "too short", tenant: alice, sealed: []byte("x")},
+	}
+	for _, tt := range tests {
+		plaintext, err := tt.tenant.Open(tt.sealed)
+		if tt.ok && (err != nil || string(plaintext) != "alice's notes") {
+			t.Errorf("%s: Open = %q, %v, want the notes", tt.name, plaintext, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: Open succeeded", tt.name)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tn, _ := m.For(42)
+	if err := tn.WriteFile("notes.json", []byte(`{"secret":true}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, tenantsDir, "42", "notes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Error("the file on disk isn't encrypted")
+	}
+	got, err := tn.ReadFile("../42/notes.json")
+	if err != nil || string(got) != `{"secret":true}` {
+		t.Errorf("ReadFile = %q, %v", got, err)
+	}
+
+	all, err := m.All()
+	if err != nil || len(all) != 1 || all[0].ID != "42" {
+		t.Errorf("All = %v, %v, want tenant 42", all, err)
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	m, err := NewManager(t.TempDir(), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tn, _ := m.For(7)
+	for _, tool := range []string{"python", "bash", "files"} {
+		if err := tn.Audit(Event{Tool: tool, Args: strings.Repeat("x", maxAuditArg+10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := tn.AuditTrail(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Tool != "bash" || events[1].Tool != "files" {
+		t.Fatalf("AuditTrail(2) = %+v, want bash then files", events)
+	}
+	if len(events[0].Args) != maxAuditArg+len("...") {
+		t.Errorf("args kept at %d characters, want them shortened to %d", len(events[0].Args), maxAuditArg)
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	m, err := NewManager(t.TempDir(), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tn, _ := m.For(9)
+	if got := Workspace(context.Background(), "/ws"); got != "/ws" {
+		t.Errorf("Workspace without a tenant = %q, want the root", got)
+	}
+	if got := Workspace(WithTenant(context.Background(), tn), "/ws"); got != filepath.Join("/ws", tenantsDir, "9") {
+		t.Errorf("Workspace with tenant 9 = %q", got)
+	}
+}
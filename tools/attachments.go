@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	maxAttachments     = 10
+	maxAttachmentBytes = 20 << 20 // Stay well under Telegram's upload limits
+)
+
+// attachableExts are the file types sent to the user when a run creates them.
+var attachableExts = map[string]AttachmentKind{
+	".png":  AttachPhoto,
+	".jpg":  AttachPhoto,
+	".jpeg": AttachPhoto,
+	".gif":  AttachDocument,
+	".svg":  AttachDocument,
+	".pdf":  AttachDocument,
+	".csv":  AttachDocument,
+	".xlsx": AttachDocument,
+	".html": AttachDocument,
+	".zip":  AttachDocument,
+}
+
+// snapshotWorkspace records file modification times so files created or
+// changed by a run can be found afterwards.
+func snapshotWorkspace(dir string) map[string]time.Time {
+	snapshot := make(map[string]time.Time)
+	walkWorkspace(dir, func(path string, info os.FileInfo) {
+		snapshot[path] = info.ModTime()
+	})
+	return snapshot
+}
+
+// changedAttachments returns attachable files that are new or modified since the snapshot.
+func changedAttachments(dir string, before map[string]time.Time) []Attachment {
+	var result []Attachment
+	walkWorkspace(dir, func(path string, info os.FileInfo) {
+		if len(result) >= maxAttachments {
+			return
+		}
+		if prev, ok := before[path]; ok && !info.ModTime().After(prev) {
+			return
+		}
+		kind, ok := attachableExts[strings.ToLower(filepath.Ext(path))]
+		if !ok {
+			return
+		}
+		if info.Size() > maxAttachmentBytes {
+			log.Printf("[attachments] skipping %s: %d bytes is too large", path, info.Size())
+			return
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[attachments] reading %s: %v", path, err)
+			return
+		}
+		result = append(result, Attachment{Name: filepath.Base(path), Data: data, Kind: kind})
+	})
+	return result
+}
+
+// walkWorkspace visits regular files, skipping hidden directories and caches.
+func walkWorkspace(dir string, fn func(path string, info os.FileInfo)) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (strings.HasPrefix(name, ".") || name == "__pycache__" || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			fn(path, info)
+		}
+		return nil
+	})
+}
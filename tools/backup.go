@@ -0,0 +1,445 @@
+package tools
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupLogPrefix  = "[backup]"
+	backupTimeout    = time.Hour
+	maxBackupHistory = 10
+)
+
+// BackupConfig says what is backed up and where it goes.
+type BackupConfig struct {
+	Paths []string // Files and directories to archive
+
+	// Destination is where archives are uploaded: s3://bucket/prefix with
+	// the aws CLI, remote:path with rclone (Google Drive, B2, SFTP, ...),
+	// or a local directory such as a mounted NAS share.
+	Destination string
+
+	// Recipients are the age public keys archives are encrypted to, so
+	// only the holders of the matching private keys can restore them.
+	Recipients []string
+
+	HistoryFile string // JSON-lines file of past backups; empty disables history
+}
+
+// BackupResult is one backup run, and a line of the history file.
+type BackupResult struct {
+	Time        time.Time     `json:"time"`
+	Name        string        `json:"name"`
+	Destination string        `json:"destination"`
+	Files       int           `json:"files"`
+	Bytes       int64         `json:"bytes"` // Of the encrypted archive
+	Took        time.Duration `json:"took"`
+	Error       string        `json:"error,omitempty"`
+}
+
+func (r *BackupResult) String() string {
+	if r.Error != "" {
+		return fmt.Sprintf("❌ Backup %s failed: %s", r.Name, r.Error)
+	}
+	return fmt.Sprintf("💾 Backed up %d files (%s encrypted) to %s as %s in %v",
+		r.Files, formatSize(r.Bytes), r.Destination, r.Name, r.Took.Round(time.Second))
+}
+
+// BackupTool archives the bot's data, encrypts it with age, and uploads it.
+type BackupTool struct {
+	cfg BackupConfig
+
+	runMu     sync.Mutex // One backup at a time
+	historyMu sync.Mutex
+	flush     func() error // Writes the state store out before archiving it; set by Start
+}
+
+// NewBackupTool creates a backup tool. It fails unless there is something
+// to back up, somewhere to put it, and someone to encrypt it to, since
+// the archive holds tokens and keys that must not be uploaded in the clear.
+func NewBackupTool(cfg BackupConfig) (*BackupTool, error) {
+	if cfg.Destination == "" {
+		return nil, fmt.Errorf("no backup destination is configured")
+	}
+	if len(cfg.Recipients) == 0 {
+		return nil, fmt.Errorf("backups need at least one age recipient to encrypt to")
+	}
+	if len(cfg.Paths) == 0 {
+		return nil, fmt.Errorf("there is nothing to back up")
+	}
+	return &BackupTool{cfg: cfg}, nil
+}
+
+func (b *BackupTool) Name() string {
+	return "backup"
+}
+
+func (b *BackupTool) Description() string {
+	return fmt.Sprintf(`Back up the bot's data (%s) as an encrypted archive uploaded to %s.
+operation=run makes a backup now; operation=history lists recent backups and whether they worked.`,
+		strings.Join(b.cfg.Paths, ", "), b.cfg.Destination)
+}
+
+func (b *BackupTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"run", "history"},
+				"description": "What to do",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (b *BackupTool) Metadata() Metadata {
+	return Metadata{Cost: CostHigh}
+}
+
+func (b *BackupTool) Requirements() []Requirement {
+	reqs := []Requirement{{Name: "age", Command: "age", Operations: []string{"run"}}}
+	switch backupUploader(b.cfg.Destination) {
+	case "aws":
+		reqs = append(reqs, Requirement{Name: "aws CLI", Command: "aws", Operations: []string{"run"}})
+	case "rclone":
+		reqs = append(reqs, Requirement{Name: "rclone", Command: "rclone", Operations: []string{"run"}})
+	}
+	return reqs
+}
+
+// Start lets backups flush the state store first, so they have its latest
+// contents.
+func (b *BackupTool) Start(host Host) error {
+	b.flush = host.Store.Flush
+	return nil
+}
+
+func (b *BackupTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "run":
+		result := b.Run(ctx)
+		if result.Error != "" {
+			return "", fmt.Errorf("backup failed: %s", result.Error)
+		}
+		return result.String(), nil
+	case "history":
+		return b.history()
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// Run makes a backup and records it in the history. Failures are reported
+// in the result rather than as an error, so they're recorded too.
+func (b *BackupTool) Run(ctx context.Context) *BackupResult {
+	b.runMu.Lock()
+	defer b.runMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, backupTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result := &BackupResult{
+		Time:        start.UTC(),
+		Name:        "backup-" + start.Format("20060102-150405") + ".tar.gz.age",
+		Destination: b.cfg.Destination,
+	}
+	if err := b.run(ctx, result); err != nil {
+		result.Error = err.Error()
+		log.Printf("%s %s: %v", backupLogPrefix, result.Name, err)
+	} else {
+		log.Printf("%s %s: %d files, %d bytes", backupLogPrefix, result.Name, result.Files, result.Bytes)
+	}
+	result.Took = time.Since(start)
+
+	if err := b.writeHistory(result); err != nil {
+		log.Printf("%s history: %v", backupLogPrefix, err)
+	}
+	return result
+}
+
+func (b *BackupTool) run(ctx context.Context, result *BackupResult) error {
+	if b.flush != nil {
+		if err := b.flush(); err != nil {
+			return fmt.Errorf("flushing the state store: %w", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "backup-")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, result.Name)
+
+	if result.Files, err = b.encryptArchive(ctx, archive); err != nil {
+		return err
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		return fmt.Errorf("reading archive: %w", err)
+	}
+	result.Bytes = info.Size()
+	return b.upload(ctx, archive, result.Name)
+}
+
+// encryptArchive writes the paths as a gzipped tarball straight into age,
+// so the unencrypted archive never touches the disk. It returns the
+// number of files archived.
+func (b *BackupTool) encryptArchive(ctx context.Context, out string) (int, error) {
+	args := []string{"--output", out}
+	for _, r := range b.cfg.Recipients {
+		args = append(args, "--recipient", r)
+	}
+	cmd := exec.CommandContext(ctx, "age", args...)
+	isolate(ctx, cmd, "backup")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 0, fmt.Errorf("starting age: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("starting age: %w", err)
+	}
+
+	files, archiveErr := writeArchive(stdin, b.cfg.Paths)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return 0, fmt.Errorf("encrypting: %s", truncateText(msg, 300))
+		}
+		return 0, fmt.Errorf("encrypting: %w", err)
+	}
+	if archiveErr != nil {
+		return 0, archiveErr
+	}
+	return files, nil
+}
+
+// writeArchive writes the paths to w as a gzipped tarball. Entries are
+// named by their path, made relative, so workspace/notes.md restores to
+// workspace/notes.md. Sockets and other special files are skipped.
+func writeArchive(w io.Writer, paths []string) (int, error) {
+	buf := bufio.NewWriterSize(w, 256*1024)
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	files := 0
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() && !info.IsDir() && info.Mode()&fs.ModeSymlink == 0 {
+				return nil
+			}
+			link := ""
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if link, err = os.Readlink(p); err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name = archiveName(p)
+			if info.IsDir() {
+				header.Name += "/"
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			// A file that grows while it's read is cut off at its header's size
+			if _, err := io.Copy(tw, io.LimitReader(f, header.Size)); err != nil {
+				return err
+			}
+			files++
+			return nil
+		})
+		if err != nil {
+			return files, fmt.Errorf("archiving %s: %w", root, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return files, fmt.Errorf("writing archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return files, fmt.Errorf("writing archive: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return files, fmt.Errorf("writing archive: %w", err)
+	}
+	return files, nil
+}
+
+// archiveName turns a file's path into its name in the archive: relative,
+// with forward slashes and without leading ../ or /.
+func archiveName(p string) string {
+	name := path.Clean(filepath.ToSlash(p))
+	for {
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(name, "../"), "/")
+		if trimmed == name {
+			return name
+		}
+		name = trimmed
+	}
+}
+
+// backupUploader returns what uploads to the destination: aws, rclone, or
+// "" for a local directory.
+func backupUploader(destination string) string {
+	switch {
+	case strings.HasPrefix(destination, "s3://"):
+		return "aws"
+	case filepath.IsAbs(destination), strings.HasPrefix(destination, "."):
+		return ""
+	default:
+		return "rclone"
+	}
+}
+
+// upload puts the archive at the destination under name.
+func (b *BackupTool) upload(ctx context.Context, archive, name string) error {
+	dest := strings.TrimSuffix(b.cfg.Destination, "/") + "/" + name
+	var cmd *exec.Cmd
+	switch backupUploader(b.cfg.Destination) {
+	case "aws":
+		cmd = exec.CommandContext(ctx, "aws", "s3", "cp", "--no-progress", archive, dest)
+	case "rclone":
+		cmd = exec.CommandContext(ctx, "rclone", "copyto", archive, dest)
+	default:
+		return copyBackup(archive, filepath.Join(b.cfg.Destination, name))
+	}
+
+	log.Printf("%s uploading to %s", backupLogPrefix, dest)
+	isolate(ctx, cmd, "backup")
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("uploading: %s", truncateText(msg, 300))
+		}
+		return fmt.Errorf("uploading: %w", err)
+	}
+	return nil
+}
+
+// copyBackup copies the archive into a local destination directory.
+func copyBackup(archive, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return fmt.Errorf("creating destination: %w", err)
+	}
+	in, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("reading archive: %w", err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("copying to destination: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return fmt.Errorf("copying to destination: %w", err)
+	}
+	return out.Close()
+}
+
+// writeHistory appends the result to the history file.
+func (b *BackupTool) writeHistory(result *BackupResult) error {
+	if b.cfg.HistoryFile == "" {
+		return nil
+	}
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	b.historyMu.Lock()
+	defer b.historyMu.Unlock()
+
+	f, err := os.OpenFile(b.cfg.HistoryFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// history lists the most recent backups, newest first.
+func (b *BackupTool) history() (string, error) {
+	if b.cfg.HistoryFile == "" {
+		return "", fmt.Errorf("backup history is not kept")
+	}
+	b.historyMu.Lock()
+	data, err := os.ReadFile(b.cfg.HistoryFile)
+	b.historyMu.Unlock()
+	if os.IsNotExist(err) {
+		return "No backups yet.", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading backup history: %w", err)
+	}
+
+	var results []BackupResult
+	for line := range strings.Lines(string(data)) {
+		var r BackupResult
+		if json.Unmarshal([]byte(line), &r) == nil {
+			results = append(results, r)
+		}
+	}
+	if len(results) == 0 {
+		return "No backups yet.", nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Recent backups to %s:\n", b.cfg.Destination)
+	for i := len(results) - 1; i >= 0 && i >= len(results)-maxBackupHistory; i-- {
+		r := results[i]
+		when := r.Time.Local().Format("Jan 2 15:04")
+		if r.Error != "" {
+			fmt.Fprintf(&sb, "❌ %s: %s\n", when, r.Error)
+		} else {
+			fmt.Fprintf(&sb, "✅ %s: %s, %d files, %s (%v)\n", when, r.Name, r.Files, formatSize(r.Bytes), r.Took.Round(time.Second))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
@@ -3,12 +3,17 @@ package tools
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
+
+	"telegram-bot/tenant"
 )
 
 const bashTimeout = 60 * time.Second
@@ -16,14 +21,27 @@ const bashTimeout = 60 * time.Second
 // BashTool executes bash commands and scripts.
 type BashTool struct {
 	workspaceDir string
+	allowedDirs  []string // Extra directories cwd may point into
+	sessions     bashSessions
+
+	tenants *tenantCopies[*BashTool] // Nil on the copies themselves
 }
 
-// NewBashTool creates a new Bash tool that runs commands in the given workspace.
-func NewBashTool(workspaceDir string) *BashTool {
+// NewBashTool creates a new Bash tool that runs commands in the given
+// workspace. Commands may also set cwd to a directory under allowedDirs.
+func NewBashTool(workspaceDir string, allowedDirs ...string) *BashTool {
 	if workspaceDir == "" {
 		workspaceDir = defaultWorkspace
 	}
-	return &BashTool{workspaceDir: workspaceDir}
+	return &BashTool{workspaceDir: workspaceDir, allowedDirs: allowedDirs, tenants: newTenantCopies[*BashTool]()}
+}
+
+// forTenant returns the bash tool for the request's tenant, with its own
+// workspace and shell sessions.
+func (b *BashTool) forTenant(ctx context.Context) *BashTool {
+	return b.tenants.get(ctx, b, func(t *tenant.Tenant) *BashTool {
+		return &BashTool{workspaceDir: tenant.Dir(b.workspaceDir, t.ID), allowedDirs: b.allowedDirs}
+	})
 }
 
 func (b *BashTool) Name() string {
@@ -34,11 +52,13 @@ func (b *BashTool) Description() string {
 	return `Execute bash commands or scripts.
 
 Use bash for:
-- File operations (ls, cat, mv, cp, rm, find, grep)
 - System info (df, du, ps, top, uname)
 - Running CLI tools (curl, jq, git, docker)
 - Quick one-liners and pipelines
-- Directory navigation and file management
+- Copying files and archives (cp, tar, unzip)
+
+Use the files tool instead for reading, writing, appending, moving,
+deleting, searching (grep), and listing (tree) workspace files.
 
 Use python instead for:
 - Data analysis and processing
@@ -46,7 +66,35 @@ Use python instead for:
 - Working with APIs that need parsing
 - Anything requiring libraries (pandas, requests, etc.)
 
-Commands run in the workspace directory. The workspace persists between runs.`
+Commands run in the workspace directory. The workspace persists between runs.
+
+SESSIONS:
+Each call is a fresh shell unless session=true, which runs the command in a
+persistent shell for this chat (a terminal): cd, exported variables, activated
+virtualenvs, and shell functions carry over to the next session=true call.
+Use reset=true to start the session over. Idle sessions end after 30 minutes.
+
+WORKING DIRECTORY AND ENVIRONMENT:
+- cwd: directory to run in, relative to the workspace (e.g. "myrepo/src").
+  Prefer this over "cd x && ..." chains.
+- env: extra environment variables, e.g. {"GOFLAGS": "-mod=mod"}.
+In a session, cwd changes the session's directory and env is exported for it.` + shellNote()
+}
+
+// shellNote tells the model when commands don't run in bash on this host.
+func shellNote() string {
+	if !currentExecutor().OnHost() {
+		return "" // Containers have bash
+	}
+	switch sh := hostShell(); sh.Name {
+	case "bash":
+		return ""
+	case "sh":
+		return "\n\nThis host has no bash: commands run in sh, so avoid bash-only syntax."
+	default:
+		return fmt.Sprintf("\n\nThis host runs Windows: commands run in %s, so use its syntax "+
+			"(e.g. Get-ChildItem, $env:NAME) instead of bash's. Sessions are not available.", sh.Name)
+	}
 }
 
 func (b *BashTool) Parameters() map[string]any {
@@ -57,16 +105,93 @@ func (b *BashTool) Parameters() map[string]any {
 				"type":        "string",
 				"description": "The bash command or script to execute",
 			},
+			"session": map[string]any{
+				"type":        "boolean",
+				"description": "Run in this chat's persistent shell session so cd and exports carry over",
+			},
+			"reset": map[string]any{
+				"type":        "boolean",
+				"description": "Restart the persistent shell session before running the command (command may be omitted)",
+			},
+			"cwd": map[string]any{
+				"type":        "string",
+				"description": "Working directory, relative to the workspace",
+			},
+			"env": map[string]any{
+				"type":                 "object",
+				"description":          "Extra environment variables (name -> value)",
+				"additionalProperties": map[string]any{"type": "string"},
+			},
 		},
-		"required": []string{"command"},
+	}
+}
+
+func (b *BashTool) Metadata() Metadata {
+	return Metadata{Dangerous: true, Cost: CostHigh}
+}
+
+func (b *BashTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"command": "echo bench-ok"}, "bench-ok"
+}
+
+func (b *BashTool) Requirements() []Requirement {
+	return []Requirement{
+		{Name: hostShell().Name, Command: hostShell().Path},
+		{Name: "jq", Command: "jq", Optional: true},
 	}
 }
 
 func (b *BashTool) Execute(ctx context.Context, args map[string]any) (string, error) {
-	command, ok := args["command"].(string)
-	if !ok || command == "" {
+	return b.run(ctx, args, nil)
+}
+
+// ExecuteRich runs the command and attaches any images, PDFs, or other
+// output files it created in the workspace.
+func (b *BashTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	return b.ExecuteStream(ctx, args, nil)
+}
+
+// ExecuteStream runs the command, sending stdout and stderr as they are written.
+func (b *BashTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	b = b.forTenant(ctx)
+	before := snapshotWorkspace(b.workspaceDir)
+	output, err := b.run(ctx, args, chunks)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Text: output, Attachments: changedAttachments(b.workspaceDir, before)}, nil
+}
+
+func (b *BashTool) run(ctx context.Context, args map[string]any, chunks chan<- string) (string, error) {
+	b = b.forTenant(ctx)
+	if project := ProjectFrom(ctx); project != "" {
+		if _, ok := args["cwd"]; !ok {
+			// In a coding session, commands run in the project unless told otherwise
+			args = maps.Clone(args)
+			args["cwd"] = project
+		}
+	}
+	command, _ := args["command"].(string)
+	session, _ := args["session"].(bool)
+	reset, _ := args["reset"].(bool)
+
+	chatID, _ := ChatFrom(ctx)
+	if reset {
+		existed := b.sessions.reset(chatID)
+		if command == "" {
+			if existed {
+				return "Shell session reset.", nil
+			}
+			return "No shell session was running.", nil
+		}
+		session = true
+	}
+	if command == "" {
 		return "", fmt.Errorf("command is required")
 	}
+	if session && untrusted(ctx) {
+		return "", fmt.Errorf("shell sessions aren't available here; run each command on its own")
+	}
 
 	// Ensure workspace exists
 	if err := os.MkdirAll(b.workspaceDir, 0755); err != nil {
@@ -79,21 +204,49 @@ func (b *BashTool) Execute(ctx context.Context, args map[string]any) (string, er
 		return "", fmt.Errorf("resolving workspace path: %w", err)
 	}
 
+	dir, err := b.resolveDir(args, absWorkspace)
+	if err != nil {
+		return "", err
+	}
+	env, err := parseEnv(args)
+	if err != nil {
+		return "", err
+	}
+
+	if session {
+		// Apply cwd and env inside the shell so they persist like a typed cd/export
+		var prefix strings.Builder
+		if _, ok := args["cwd"].(string); ok && dir != absWorkspace {
+			fmt.Fprintf(&prefix, "cd %s && ", shellQuote(dir))
+		}
+		for _, kv := range env {
+			name, value, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(&prefix, "export %s=%s; ", name, shellQuote(value))
+		}
+		return b.runSession(ctx, chatID, prefix.String()+command, absWorkspace)
+	}
+
 	// Execute with timeout
 	ctx, cancel := context.WithTimeout(ctx, bashTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "bash", "-c", command)
-	cmd.Dir = absWorkspace
-
-	// Set a clean environment with essential variables
-	cmd.Env = append(os.Environ(),
-		"WORKSPACE="+absWorkspace,
-	)
+	e := currentExecutor()
+	shellArgs := []string{containerShell, "-c", command}
+	if e.OnHost() {
+		sh := hostShell()
+		shellArgs = append(append([]string{sh.Path}, sh.Args...), command)
+	}
+	cmd := e.Command(ctx, ExecRequest{
+		Profile:   "bash",
+		Workspace: absWorkspace,
+		Dir:       dir,
+		Env:       append([]string{"WORKSPACE=" + absWorkspace}, env...),
+		Args:      shellArgs,
+	})
 
 	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	cmd.Stdout = &chunkWriter{ctx: ctx, buf: &stdout, chunks: chunks}
+	cmd.Stderr = &chunkWriter{ctx: ctx, buf: &stderr, chunks: chunks}
 
 	err = cmd.Run()
 
@@ -138,3 +291,128 @@ func (b *BashTool) Execute(ctx context.Context, args map[string]any) (string, er
 
 	return strings.TrimSpace(result.String()), nil
 }
+
+// resolveDir returns the absolute directory for the cwd argument, which must
+// stay within the workspace or one of the allowed directories.
+func (b *BashTool) resolveDir(args map[string]any, absWorkspace string) (string, error) {
+	cwd, _ := args["cwd"].(string)
+	if cwd == "" {
+		return absWorkspace, nil
+	}
+
+	// Relative paths and absolute paths inside the workspace; otherwise an allowed directory
+	dir, err := safePath(absWorkspace, cwd)
+	if err != nil && filepath.IsAbs(cwd) {
+		for _, root := range b.allowedDirs {
+			if allowed, rootErr := safePath(root, cwd); rootErr == nil {
+				dir, err = allowed, nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("cwd %s is outside the workspace", cwd)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("cwd %s: %w", cwd, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("cwd %s is not a directory", cwd)
+	}
+	return dir, nil
+}
+
+// envName matches valid environment variable names.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnv turns the env argument into sorted NAME=value pairs.
+func parseEnv(args map[string]any) ([]string, error) {
+	raw, ok := args["env"].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+
+	env := make([]string, 0, len(raw))
+	for name, v := range raw {
+		if !envName.MatchString(name) {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+		var value string
+		switch v := v.(type) {
+		case string:
+			value = v
+		case float64, bool:
+			value = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("environment variable %s must be a string", name)
+		}
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// shellQuote single-quotes s for bash.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runSession runs a command in the chat's persistent shell.
+func (b *BashTool) runSession(ctx context.Context, chatID int64, command, absWorkspace string) (string, error) {
+	s, err := b.sessions.get(chatID, absWorkspace, append(os.Environ(), "WORKSPACE="+absWorkspace))
+	if err != nil {
+		return "", fmt.Errorf("starting shell session: %w", err)
+	}
+
+	output, code, cwd, err := s.exec(ctx, command, bashTimeout)
+	if len(output) > maxOutputBytes {
+		output = output[:maxOutputBytes] + "\n... (output truncated)"
+	}
+	output = strings.TrimSpace(output)
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return output + "\n\nCommand timed out after " + bashTimeout.String() + " and was interrupted; the session is still running.", nil
+	case errors.Is(err, errSessionEnded):
+		b.sessions.reset(chatID)
+		return output + "\n\nThe shell session ended (exit was called or the shell crashed). The next session call starts a new one.", nil
+	case err != nil:
+		b.sessions.reset(chatID)
+		return "", fmt.Errorf("shell session: %w", err)
+	}
+
+	var result strings.Builder
+	if output == "" {
+		result.WriteString("(no output)")
+	} else {
+		result.WriteString(output)
+	}
+	if code != 0 {
+		fmt.Fprintf(&result, "\n\nExit code: %d", code)
+	}
+	if cwd != "" && cwd != absWorkspace {
+		fmt.Fprintf(&result, "\n[session cwd: %s]", cwd)
+	}
+	return result.String(), nil
+}
+
+// chunkWriter buffers everything written to it and, if chunks is non-nil,
+// also forwards each write to the channel.
+type chunkWriter struct {
+	ctx    context.Context
+	buf    *bytes.Buffer
+	chunks chan<- string
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if w.chunks != nil {
+		select {
+		case w.chunks <- string(p):
+		case <-w.ctx.Done():
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,228 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionIdleTimeout = 30 * time.Minute
+	maxBashSessions    = 10
+	interruptGrace     = 3 * time.Second
+)
+
+var errSessionEnded = errors.New("shell session ended")
+
+// bashSession is a long-lived interactive bash on a pseudo-terminal, so cd,
+// exported variables, and functions persist between commands.
+type bashSession struct {
+	cmd  *exec.Cmd
+	pty  *os.File
+	out  chan []byte   // Output read from the terminal
+	done chan struct{} // Closed when the shell exits
+
+	mu       sync.Mutex // One command at a time
+	lastUsed time.Time
+}
+
+// startBashSession starts a shell in dir with echo and prompts turned off.
+func startBashSession(dir string, env []string) (*bashSession, error) {
+	cmd := exec.Command("bash", "--noprofile", "--norc", "--noediting", "-i")
+	cmd.Dir = dir
+	cmd.Env = append(env, "TERM=dumb", "PS1=", "PS2=")
+	// Sessions are never started for untrusted code, so the shell is
+	// isolated like any other bash command
+	isolate(context.Background(), cmd, "bash")
+
+	pty, err := startPTY(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &bashSession{
+		cmd:      cmd,
+		pty:      pty,
+		out:      make(chan []byte, 64),
+		done:     make(chan struct{}),
+		lastUsed: time.Now(),
+	}
+	go s.read()
+	go cmd.Wait()
+
+	// Plain output: no echo of our input, no \r\n translation, no prompts
+	fmt.Fprintln(pty, "stty -echo -onlcr; unset PROMPT_COMMAND; PS1=''; PS2=''")
+	if _, _, _, err := s.exec(context.Background(), "true", 10*time.Second); err != nil {
+		s.close()
+		return nil, fmt.Errorf("starting shell: %w", err)
+	}
+	return s, nil
+}
+
+func (s *bashSession) read() {
+	defer close(s.done)
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.pty.Read(buf)
+		if n > 0 {
+			s.out <- bytes.Clone(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// exec runs a command and waits for it to finish, returning its combined
+// output, exit code, and the shell's working directory afterwards. On
+// timeout the command is interrupted with Ctrl-C.
+func (s *bashSession) exec(ctx context.Context, command string, timeout time.Duration) (string, int, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+
+	marker := newMarker()
+	sentinel := fmt.Sprintf("printf '\\n%s:%%d:%%s\\n' \"$?\" \"$PWD\"\n", marker)
+	if _, err := fmt.Fprintf(s.pty, "%s\n%s", command, sentinel); err != nil {
+		return "", 0, "", fmt.Errorf("writing to shell: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var buf bytes.Buffer
+	interrupted := false
+	for {
+		select {
+		case data := <-s.out:
+			buf.Write(data)
+			if output, code, cwd, ok := parseSentinel(buf.Bytes(), marker); ok {
+				if interrupted {
+					return output, code, cwd, context.DeadlineExceeded
+				}
+				return output, code, cwd, nil
+			}
+		case <-s.done:
+			return buf.String(), 0, "", errSessionEnded
+		case <-ctx.Done():
+			s.pty.Write([]byte{3})
+			return buf.String(), 0, "", ctx.Err()
+		case <-timer.C:
+			if interrupted {
+				return buf.String(), 0, "", fmt.Errorf("shell did not respond after interrupt")
+			}
+			// Ctrl-C flushes pending input, so ask for the sentinel again
+			interrupted = true
+			s.pty.Write([]byte{3})
+			fmt.Fprint(s.pty, sentinel)
+			timer.Reset(interruptGrace)
+		}
+	}
+}
+
+// parseSentinel looks for the marker line and splits off the output before it.
+func parseSentinel(buf []byte, marker string) (string, int, string, bool) {
+	idx := bytes.Index(buf, []byte("\n"+marker+":"))
+	if idx < 0 {
+		return "", 0, "", false
+	}
+	rest := buf[idx+len(marker)+2:]
+	end := bytes.IndexByte(rest, '\n')
+	if end < 0 {
+		return "", 0, "", false
+	}
+
+	code, cwd, _ := strings.Cut(string(rest[:end]), ":")
+	exit, _ := strconv.Atoi(code)
+	return string(buf[:idx]), exit, cwd, true
+}
+
+func (s *bashSession) alive() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (s *bashSession) close() {
+	killGroup(s.cmd)
+	s.pty.Close()
+}
+
+func newMarker() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "__BASH_DONE_" + hex.EncodeToString(b)
+}
+
+// bashSessions holds one shell per chat.
+type bashSessions struct {
+	mu       sync.Mutex
+	sessions map[int64]*bashSession
+}
+
+// get returns the chat's session, starting one if needed and closing any
+// that have been idle too long.
+func (m *bashSessions) get(chatID int64, dir string, env []string) (*bashSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = make(map[int64]*bashSession)
+	}
+	for id, s := range m.sessions {
+		if !s.alive() || (id != chatID && s.idle() > sessionIdleTimeout) {
+			s.close()
+			delete(m.sessions, id)
+		}
+	}
+
+	if s, ok := m.sessions[chatID]; ok {
+		return s, nil
+	}
+	if len(m.sessions) >= maxBashSessions {
+		return nil, fmt.Errorf("too many active shell sessions (%d); try again later", maxBashSessions)
+	}
+
+	s, err := startBashSession(dir, env)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[bash] started session for chat %d", chatID)
+	m.sessions[chatID] = s
+	return s, nil
+}
+
+// reset closes the chat's session, if any.
+func (m *bashSessions) reset(chatID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[chatID]
+	if ok {
+		s.close()
+		delete(m.sessions, chatID)
+		log.Printf("[bash] reset session for chat %d", chatID)
+	}
+	return ok
+}
+
+func (s *bashSession) idle() time.Duration {
+	if !s.mu.TryLock() {
+		return 0 // Running a command
+	}
+	defer s.mu.Unlock()
+	return time.Since(s.lastUsed)
+}
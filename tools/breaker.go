@@ -0,0 +1,286 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	breakerThreshold    = 3 // Dependency failures in a row that trip a breaker
+	breakerCooldown     = time.Minute
+	breakerMaxCooldown  = 30 * time.Minute // The cooldown doubles after each failed retry, up to this
+	breakerProbeTimeout = 30 * time.Second
+	breakerLogPrefix    = "[breaker]"
+)
+
+// unavailableError marks an error as coming from something a tool depends
+// on rather than from the call itself.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string { return e.err.Error() }
+func (e *unavailableError) Unwrap() error { return e.err }
+
+// Unavailable marks err as a failure of something the tool depends on,
+// such as a backend service that is down, so repeated ones trip the tool's
+// circuit breaker. Errors about the call itself, like a bad argument or a
+// page that doesn't exist, should not be marked.
+func Unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &unavailableError{err: err}
+}
+
+// IsUnavailable reports whether err is a dependency failure: one marked
+// with Unavailable, or a command that isn't installed.
+func IsUnavailable(err error) bool {
+	var unavailable *unavailableError
+	var execErr *exec.Error
+	return errors.As(err, &unavailable) || errors.As(err, &execErr)
+}
+
+// breaker is one tool's circuit breaker. It opens after repeated dependency
+// failures and closes again once the tool works.
+type breaker struct {
+	failures int
+	open     bool
+	lastErr  error
+	retry    time.Time // When an open breaker lets a call, or a probe, through
+	cooldown time.Duration
+	tool     Tool // The tool below the middleware, for probing
+}
+
+// BreakerStatus describes an open circuit breaker.
+type BreakerStatus struct {
+	Tool    string
+	LastErr error
+	Retry   time.Time
+}
+
+// Breakers holds the circuit breakers of every tool. A tool whose
+// dependency keeps failing is taken out of the schemas sent to the model
+// and fails fast, until a probe or a retry after a cooldown succeeds.
+type Breakers struct {
+	mu       sync.Mutex
+	tools    map[string]*breaker
+	onChange func(tool string, open bool, err error)
+}
+
+// NewBreakers creates a set of circuit breakers, all closed.
+func NewBreakers() *Breakers {
+	return &Breakers{tools: make(map[string]*breaker)}
+}
+
+// OnChange registers a function called when a breaker opens or closes,
+// with the failure that opened it.
+func (b *Breakers) OnChange(fn func(tool string, open bool, err error)) {
+	b.onChange = fn
+}
+
+// CircuitBreaker returns a middleware that trips a tool's breaker after
+// repeated dependency failures. Add it last, so it wraps the tool itself
+// and failures refused by other middleware don't count.
+func CircuitBreaker(b *Breakers) Middleware {
+	return func(next Tool) Tool {
+		b.mu.Lock()
+		br, ok := b.tools[next.Name()]
+		if !ok {
+			br = &breaker{}
+			b.tools[next.Name()] = br
+		}
+		br.tool = next
+		b.mu.Unlock()
+		return &breakerTool{Tool: next, breakers: b}
+	}
+}
+
+type breakerTool struct {
+	Tool
+	breakers *Breakers
+}
+
+func (t *breakerTool) Unwrap() Tool {
+	return t.Tool
+}
+
+// Available hides a tool whose breaker is open from the model.
+func (t *breakerTool) Available(ctx context.Context) bool {
+	if t.breakers.check(t.Name()) != nil {
+		return false
+	}
+	return isAvailable(ctx, t.Tool)
+}
+
+func (t *breakerTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if err := t.breakers.check(t.Name()); err != nil {
+		return "", err
+	}
+	out, err := t.Tool.Execute(ctx, args)
+	t.breakers.record(ctx, t.Name(), err)
+	return out, err
+}
+
+func (t *breakerTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	if err := t.breakers.check(t.Name()); err != nil {
+		return nil, err
+	}
+	result, err := Run(ctx, t.Tool, args)
+	t.breakers.record(ctx, t.Name(), err)
+	return result, err
+}
+
+func (t *breakerTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	if err := t.breakers.check(t.Name()); err != nil {
+		return nil, err
+	}
+	result, err := Stream(ctx, t.Tool, args, chunks)
+	t.breakers.record(ctx, t.Name(), err)
+	return result, err
+}
+
+// check returns the error to fail fast with if the tool's breaker is open.
+// After the cooldown, a tool that can't be probed gets a trial call.
+func (b *Breakers) check(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br, ok := b.tools[name]
+	if !ok || !br.open {
+		return nil
+	}
+	if _, probed := As[Benchmarkable](br.tool); !probed && !time.Now().Before(br.retry) {
+		return nil
+	}
+	retry := "shortly"
+	if wait := time.Until(br.retry); wait > 0 {
+		retry = "in " + wait.Round(time.Second).String()
+	}
+	return fmt.Errorf("%s is temporarily unavailable after repeated failures (last error: %v); it will be retried %s",
+		name, br.lastErr, retry)
+}
+
+// record counts a call's outcome: dependency failures in a row open the
+// breaker, and a success closes it. Other errors, and calls cut short by
+// their context, leave it as it is.
+func (b *Breakers) record(ctx context.Context, name string, err error) {
+	if err == nil {
+		b.close(name)
+		return
+	}
+	if ctx.Err() != nil || !IsUnavailable(err) {
+		return
+	}
+	b.fail(name, err)
+}
+
+func (b *Breakers) close(name string) {
+	b.mu.Lock()
+	br, ok := b.tools[name]
+	if !ok || (br.failures == 0 && !br.open) {
+		b.mu.Unlock()
+		return
+	}
+	wasOpen := br.open
+	br.failures, br.open, br.lastErr, br.cooldown = 0, false, nil, 0
+	b.mu.Unlock()
+
+	if wasOpen {
+		log.Printf("%s %s is working again", breakerLogPrefix, name)
+		if b.onChange != nil {
+			b.onChange(name, false, nil)
+		}
+	}
+}
+
+func (b *Breakers) fail(name string, err error) {
+	b.mu.Lock()
+	br, ok := b.tools[name]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	br.failures++
+	br.lastErr = err
+	opened := false
+	switch {
+	case br.open:
+		// A retry failed; wait longer before the next
+		br.cooldown = min(2*br.cooldown, breakerMaxCooldown)
+		br.retry = time.Now().Add(br.cooldown)
+	case br.failures >= breakerThreshold:
+		br.open, opened = true, true
+		br.cooldown = breakerCooldown
+		br.retry = time.Now().Add(br.cooldown)
+	}
+	b.mu.Unlock()
+
+	if opened {
+		log.Printf("%s %s failed %d times in a row, taking it offline: %v", breakerLogPrefix, name, breakerThreshold, err)
+		if b.onChange != nil {
+			b.onChange(name, true, err)
+		}
+	}
+}
+
+// Probe runs the canned benchmark of each tool whose breaker is open and
+// due for a retry, closing the breaker if it passes. Schedule it to
+// restore tools once their dependencies are back.
+func (b *Breakers) Probe(ctx context.Context) error {
+	b.mu.Lock()
+	type probe struct {
+		name   string
+		bench  Benchmarkable
+		target Tool
+	}
+	var due []probe
+	for name, br := range b.tools {
+		bench, ok := As[Benchmarkable](br.tool)
+		if br.open && ok && !time.Now().Before(br.retry) {
+			due = append(due, probe{name, bench, br.tool})
+		}
+	}
+	b.mu.Unlock()
+
+	for _, p := range due {
+		args, expect := p.bench.BenchArgs()
+		probeCtx, cancel := context.WithTimeout(ctx, breakerProbeTimeout)
+		result, err := Run(probeCtx, p.target, args)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil && expect != "" && !strings.Contains(result.Text, expect) {
+			err = fmt.Errorf("probe output doesn't contain %q", expect)
+		}
+		if err != nil {
+			log.Printf("%s probing %s: %v", breakerLogPrefix, p.name, err)
+			b.fail(p.name, err)
+			continue
+		}
+		b.close(p.name)
+	}
+	return nil
+}
+
+// Open returns the tools whose breakers are open, by name.
+func (b *Breakers) Open() []BreakerStatus {
+	b.mu.Lock()
+	var open []BreakerStatus
+	for name, br := range b.tools {
+		if br.open {
+			open = append(open, BreakerStatus{Tool: name, LastErr: br.lastErr, Retry: br.retry})
+		}
+	}
+	b.mu.Unlock()
+
+	sort.Slice(open, func(i, j int) bool { return open[i].Tool < open[j].Tool })
+	return open
+}
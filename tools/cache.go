@@ -0,0 +1,243 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+	"telegram-bot/tenant"
+)
+
+const (
+	maxCacheEntries = 500
+	maxCachedBytes  = 1 << 20 // Larger results are run again rather than kept
+	cacheLogPrefix  = "[cache]"
+)
+
+// ResultCache keeps the results of slow tool calls for a while, so asking
+// again, such as inspecting the same image or scraping the same page a
+// few minutes later, answers at once instead of running the tool again.
+type ResultCache struct {
+	ttls map[string]time.Duration // By tool, or tool.operation
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	warned  map[string]bool
+}
+
+type cacheEntry struct {
+	result  *Result
+	expires time.Time
+}
+
+// ParseCacheTTLs reads how long to keep each tool's results, such as
+// "scrape=10m,oci.inspect=5m,oci.manifest=5m". An entry for tool.operation
+// covers only calls with that operation, for tools that also change things.
+func ParseCacheTTLs(specs []string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("cache entry %q should be tool=duration", spec)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("cache entry %q has an invalid duration", spec)
+		}
+		ttls[name] = ttl
+	}
+	return ttls, nil
+}
+
+// NewResultCache creates an empty cache keeping results for ttls.
+func NewResultCache(ttls map[string]time.Duration) *ResultCache {
+	return &ResultCache{ttls: ttls, entries: make(map[string]*cacheEntry), warned: make(map[string]bool)}
+}
+
+// Cache returns a middleware that answers repeated calls from c. Calls are
+// the same if they're to the same tool with the same arguments for the
+// same tenant or user. Only successful results are kept. A tool that isn't
+// read-only is only cached by operation, since caching all its calls would
+// skip changes. Add it inside the access checks, so cached results are
+// still only given to users allowed to run the tool.
+func Cache(c *ResultCache) Middleware {
+	return func(next Tool) Tool {
+		if !c.caches(next) {
+			return next
+		}
+		return &cacheTool{Tool: next, cache: c}
+	}
+}
+
+// caches reports whether any calls of the tool are cached.
+func (c *ResultCache) caches(tool Tool) bool {
+	name := tool.Name()
+	if _, ok := c.ttls[name]; ok {
+		if MetadataOf(tool).ReadOnly {
+			return true
+		}
+		c.mu.Lock()
+		if !c.warned[name] {
+			c.warned[name] = true
+			log.Printf("%s not caching %s: it isn't read-only; list its read-only operations instead, e.g. %s.inspect", cacheLogPrefix, name, name)
+		}
+		c.mu.Unlock()
+	}
+	for key := range c.ttls {
+		if strings.HasPrefix(key, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+type cacheTool struct {
+	Tool
+	cache *ResultCache
+}
+
+func (t *cacheTool) Unwrap() Tool {
+	return t.Tool
+}
+
+func (t *cacheTool) Available(ctx context.Context) bool {
+	return isAvailable(ctx, t.Tool)
+}
+
+// lookup returns the call's cache key and TTL, and its cached result if
+// there is one. An empty key means the call isn't cached.
+func (t *cacheTool) lookup(ctx context.Context, args map[string]any) (string, time.Duration, *Result) {
+	name := t.Name()
+	ttl, ok := t.cache.ttls[name]
+	if !ok || !MetadataOf(t.Tool).ReadOnly {
+		operation, _ := args["operation"].(string)
+		if ttl, ok = t.cache.ttls[name+"."+operation]; !ok || operation == "" {
+			return "", 0, nil
+		}
+	}
+	key, err := cacheKey(ctx, name, args)
+	if err != nil {
+		return "", 0, nil
+	}
+
+	t.cache.mu.Lock()
+	defer t.cache.mu.Unlock()
+	entry, ok := t.cache.entries[key]
+	if !ok {
+		return key, ttl, nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(t.cache.entries, key)
+		return key, ttl, nil
+	}
+	log.Printf("%s %s answered from cache, %v left", cacheLogPrefix, name, time.Until(entry.expires).Round(time.Second))
+	return key, ttl, entry.result
+}
+
+// store keeps a successful result. When the cache is full, expired entries
+// are dropped first, then the ones closest to expiring.
+func (c *ResultCache) store(key string, ttl time.Duration, result *Result) {
+	if key == "" || result == nil || result.Form != nil {
+		return
+	}
+	size := len(result.Text)
+	for _, a := range result.Attachments {
+		size += len(a.Data)
+	}
+	if size > maxCachedBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		var oldest string
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = &cacheEntry{result: result, expires: now.Add(ttl)}
+}
+
+// cacheKey identifies a call by tool, tenant or user, role, and arguments. Arguments are
+// encoded with sorted keys, leaving out empty ones, so the model spelling
+// the same call differently still hits.
+func cacheKey(ctx context.Context, name string, args map[string]any) (string, error) {
+	canonical := make(map[string]any, len(args))
+	for k, v := range args {
+		if v == nil || v == "" {
+			continue
+		}
+		canonical[k] = v
+	}
+	encoded, err := json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+	// Tools like the calendar answer each user from their own account, and
+	// scrape only sends site credentials for trusted users, so a result is
+	// never given to a user with a lower role than the one it was made for
+	scope := ""
+	if t, ok := tenant.From(ctx); ok {
+		scope = t.ID
+	} else if user, ok := auth.UserFrom(ctx); ok {
+		scope = strconv.FormatInt(user.ID, 10)
+	}
+	scope += "/" + auth.RoleFrom(ctx).String()
+	return name + "\x00" + scope + "\x00" + string(encoded), nil
+}
+
+func (t *cacheTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	key, ttl, cached := t.lookup(ctx, args)
+	if cached != nil {
+		return cached.Text, nil
+	}
+	out, err := t.Tool.Execute(ctx, args)
+	if err == nil {
+		t.cache.store(key, ttl, &Result{Text: out})
+	}
+	return out, err
+}
+
+func (t *cacheTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	key, ttl, cached := t.lookup(ctx, args)
+	if cached != nil {
+		return cached, nil
+	}
+	result, err := Run(ctx, t.Tool, args)
+	if err == nil {
+		t.cache.store(key, ttl, result)
+	}
+	return result, err
+}
+
+func (t *cacheTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	key, ttl, cached := t.lookup(ctx, args)
+	if cached != nil {
+		select {
+		case chunks <- cached.Text:
+		case <-ctx.Done():
+		}
+		return cached, nil
+	}
+	result, err := Stream(ctx, t.Tool, args, chunks)
+	if err == nil {
+		t.cache.store(key, ttl, result)
+	}
+	return result, err
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
+	"log"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,15 +17,35 @@ import (
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
+
+	"telegram-bot/auth"
+	"telegram-bot/store"
+	"telegram-bot/tenant"
+)
+
+const (
+	googleTokenFile  = "google-token.json" // Where a tenant's Google token is kept, encrypted
+	calendarStoreKey = "google_tokens"
 )
 
-// CalendarTool provides access to Google Calendar.
+// CalendarTool provides access to Google Calendar. Each user connects
+// their own account and only sees their own calendar.
 type CalendarTool struct {
 	config    *oauth2.Config
-	tokenFile string
+	tokenFile string // The single token from before users had their own
+
+	mu       sync.RWMutex
+	accounts map[string]*calendarAccount // By tenant or user
+	tokens   map[string]*oauth2.Token    // By user, without tenants
+	store    *store.Store                // Set by Start
+}
 
-	mu      sync.RWMutex
+// calendarAccount is a connection to one Google account's calendar.
+type calendarAccount struct {
 	service *calendar.Service
+
+	mu       sync.Mutex
+	location *time.Location // The calendar's time zone, looked up on first use
 }
 
 // NewCalendarTool creates a new calendar tool with OAuth credentials.
@@ -35,33 +59,38 @@ func NewCalendarTool(clientID, clientSecret, redirectURL, tokenFile string) *Cal
 			Endpoint:     google.Endpoint,
 		},
 		tokenFile: tokenFile,
+		accounts:  make(map[string]*calendarAccount),
+		tokens:    make(map[string]*oauth2.Token),
 	}
 }
 
-// Init initializes the Google Calendar service.
+// Start loads the users' tokens.
+func (c *CalendarTool) Start(host Host) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store = host.Store
+	if _, err := host.Store.Get(calendarStoreKey, &c.tokens); err != nil {
+		return fmt.Errorf("loading Google tokens: %w", err)
+	}
+	log.Printf("[calendar] %d users have connected their calendar", len(c.tokens))
+	return nil
+}
+
+// Init initializes the Google Calendar service for the context's tenant
+// or user.
 // Returns an auth URL if user needs to authenticate, empty string if already authenticated.
 func (c *CalendarTool) Init(ctx context.Context) (authURL string, err error) {
 	if c.config.ClientID == "" || c.config.ClientSecret == "" {
 		return "", fmt.Errorf("GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET are required")
 	}
 
-	token, err := c.tokenFromFile()
+	token, err := c.loadToken(ctx)
 	if err != nil {
 		// No token, need to authenticate
 		return c.config.AuthCodeURL("state-token", oauth2.AccessTypeOffline), nil
 	}
-
-	client := c.config.Client(ctx, token)
-	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		return "", fmt.Errorf("creating calendar service: %w", err)
-	}
-
-	c.mu.Lock()
-	c.service = service
-	c.mu.Unlock()
-
-	return "", nil
+	return "", c.connect(ctx, token)
 }
 
 // CompleteAuth finishes the OAuth flow with the authorization code.
@@ -71,35 +100,86 @@ func (c *CalendarTool) CompleteAuth(ctx context.Context, authCode string) error
 		return fmt.Errorf("exchanging auth code: %w", err)
 	}
 
-	if err := c.saveToken(token); err != nil {
+	if err := c.saveToken(ctx, token); err != nil {
 		return fmt.Errorf("saving token: %w", err)
 	}
+	return c.connect(ctx, token)
+}
 
-	client := c.config.Client(ctx, token)
+// connect creates the calendar service for the context's account.
+func (c *CalendarTool) connect(ctx context.Context, token *oauth2.Token) error {
+	client := c.config.Client(context.WithoutCancel(ctx), token)
 	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
 		return fmt.Errorf("creating calendar service: %w", err)
 	}
 
 	c.mu.Lock()
-	c.service = service
+	c.accounts[accountKey(ctx)] = &calendarAccount{service: service}
 	c.mu.Unlock()
-
 	return nil
 }
 
+// account returns the context's connected account. A tenant's account is
+// connected from its saved token on first use after a restart.
+func (c *CalendarTool) account(ctx context.Context) *calendarAccount {
+	key := accountKey(ctx)
+	c.mu.RLock()
+	acct := c.accounts[key]
+	c.mu.RUnlock()
+	if acct != nil || key == "" {
+		return acct
+	}
+
+	token, err := c.loadToken(ctx)
+	if err != nil {
+		return nil
+	}
+	if err := c.connect(ctx, token); err != nil {
+		log.Printf("[calendar] connecting account %s: %v", key, err)
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.accounts[key]
+}
+
+// accountKey names the context's account: its tenant, or else its user.
+// It's empty without either, which has no account.
+func accountKey(ctx context.Context) string {
+	if t, ok := tenant.From(ctx); ok {
+		return t.ID
+	}
+	if user, ok := auth.UserFrom(ctx); ok {
+		return strconv.FormatInt(user.ID, 10)
+	}
+	return ""
+}
+
 func (c *CalendarTool) Name() string {
 	return "get_calendar_events"
 }
 
 func (c *CalendarTool) Description() string {
-	return "Get upcoming events from the user's Google Calendar. Can specify how many events to retrieve (default 10) and how many days ahead to look (default 7)."
+	return `Get events from the user's Google Calendar, past or future, with attendees, meeting links, and event IDs. Without a range, returns upcoming events for the next days_ahead days (default 7).
+Pass range as a phrase resolved in the user's time zone: "today", "tomorrow", "yesterday", "next week", "last month", "this weekend", "friday", "last friday", "last 3 days", "2026-03-02", or spans like "last monday to friday".
+Or pass explicit time_min/time_max (YYYY-MM-DD or RFC3339; a date time_max includes that whole day).
+Use operation=get_event with event_id for an event's full detail: organizer, every attendee and their response, all conferencing links and dial-ins, and the full description.`
 }
 
 func (c *CalendarTool) Parameters() map[string]any {
 	return map[string]any{
 		"type": "object",
 		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"list", "get_event"},
+				"description": "list events (default) or get_event for one event's full detail",
+			},
+			"event_id": map[string]any{
+				"type":        "string",
+				"description": "For get_event: the event ID shown in the list",
+			},
 			"max_results": map[string]any{
 				"type":        "integer",
 				"description": "Maximum number of events to return (default 10, max 50)",
@@ -108,20 +188,49 @@ func (c *CalendarTool) Parameters() map[string]any {
 				"type":        "integer",
 				"description": "How many days ahead to look for events (default 7)",
 			},
+			"range": map[string]any{
+				"type":        "string",
+				"description": "Date range phrase, e.g. tomorrow, next week, last monday to friday",
+			},
+			"time_min": map[string]any{
+				"type":        "string",
+				"description": "Start of the range (YYYY-MM-DD or RFC3339)",
+			},
+			"time_max": map[string]any{
+				"type":        "string",
+				"description": "End of the range (YYYY-MM-DD or RFC3339)",
+			},
 		},
 		"required": []string{},
 	}
 }
 
-func (c *CalendarTool) Execute(ctx context.Context, args map[string]any) (string, error) {
-	c.mu.RLock()
-	service := c.service
-	c.mu.RUnlock()
+func (c *CalendarTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+func (c *CalendarTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"max_results": float64(1), "days_ahead": float64(1)}, ""
+}
 
-	if service == nil {
+func (c *CalendarTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	acct := c.account(ctx)
+	if acct == nil {
 		return "Calendar not authenticated. Please use /auth to connect your Google Calendar.", nil
 	}
 
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "", "list":
+		return c.listEvents(ctx, acct, args)
+	case "get_event":
+		return c.getEvent(ctx, acct, args)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+func (c *CalendarTool) listEvents(ctx context.Context, acct *calendarAccount, args map[string]any) (string, error) {
 	maxResults := int64(10)
 	if v, ok := args["max_results"].(float64); ok {
 		maxResults = int64(v)
@@ -130,21 +239,18 @@ func (c *CalendarTool) Execute(ctx context.Context, args map[string]any) (string
 		}
 	}
 
-	daysAhead := 7
-	if v, ok := args["days_ahead"].(float64); ok {
-		daysAhead = int(v)
+	loc := acct.timeZone(ctx)
+	span, err := eventRange(args, time.Now().In(loc))
+	if err != nil {
+		return "", err
 	}
 
-	now := time.Now()
-	timeMin := now.Format(time.RFC3339)
-	timeMax := now.AddDate(0, 0, daysAhead).Format(time.RFC3339)
-
-	events, err := service.Events.List("primary").
+	events, err := acct.service.Events.List("primary").
 		Context(ctx).
 		ShowDeleted(false).
 		SingleEvents(true).
-		TimeMin(timeMin).
-		TimeMax(timeMax).
+		TimeMin(span.Start.Format(time.RFC3339)).
+		TimeMax(span.End.Format(time.RFC3339)).
 		MaxResults(maxResults).
 		OrderBy("startTime").
 		Do()
@@ -152,52 +258,307 @@ func (c *CalendarTool) Execute(ctx context.Context, args map[string]any) (string
 		return "", fmt.Errorf("retrieving events: %w", err)
 	}
 
+	period := fmt.Sprintf("%s – %s", span.Start.Format("Mon Jan 2 3:04 PM"), span.End.Format("Mon Jan 2 3:04 PM"))
 	if len(events.Items) == 0 {
-		return "No upcoming events found.", nil
+		return fmt.Sprintf("No events found (%s %s).", period, loc), nil
 	}
 
 	var result strings.Builder
-	result.WriteString(fmt.Sprintf("Found %d upcoming events:\n\n", len(events.Items)))
+	result.WriteString(fmt.Sprintf("Found %d events (%s %s):\n\n", len(events.Items), period, loc))
 
 	for _, item := range events.Items {
-		start := item.Start.DateTime
-		if start == "" {
-			start = item.Start.Date // All-day event
-		}
-
-		var timeStr string
-		if t, err := time.Parse(time.RFC3339, start); err == nil {
-			timeStr = t.Format("Mon Jan 2, 3:04 PM")
-		} else {
-			timeStr = start
-		}
-
-		result.WriteString(fmt.Sprintf("• %s - %s\n", timeStr, item.Summary))
+		result.WriteString(fmt.Sprintf("• %s - %s\n", eventTime(item.Start, loc), item.Summary))
 		if item.Location != "" {
 			result.WriteString(fmt.Sprintf("  📍 %s\n", item.Location))
 		}
+		if link := meetingLink(item); link != "" {
+			result.WriteString(fmt.Sprintf("  🔗 %s\n", link))
+		}
+		if len(item.Attendees) > 0 {
+			result.WriteString(fmt.Sprintf("  👥 %s\n", attendeeSummary(item.Attendees)))
+		}
+		if desc := plainText(item.Description); desc != "" {
+			result.WriteString(fmt.Sprintf("  📝 %s\n", truncateText(desc, maxDescriptionSnippet)))
+		}
+		result.WriteString(fmt.Sprintf("  🆔 %s\n", item.Id))
 	}
 
 	return result.String(), nil
 }
 
-func (c *CalendarTool) tokenFromFile() (*oauth2.Token, error) {
-	f, err := os.Open(c.tokenFile)
+// getEvent returns everything useful about one event.
+func (c *CalendarTool) getEvent(ctx context.Context, acct *calendarAccount, args map[string]any) (string, error) {
+	id, _ := args["event_id"].(string)
+	if id == "" {
+		return "", fmt.Errorf("event_id is required for get_event (listed as 🆔 in event lists)")
+	}
+
+	item, err := acct.service.Events.Get("primary", id).Context(ctx).Do()
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("retrieving event: %w", err)
+	}
+	loc := acct.timeZone(ctx)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", item.Summary)
+	fmt.Fprintf(&b, "🕒 %s – %s\n", eventTime(item.Start, loc), eventTime(item.End, loc))
+	if item.Location != "" {
+		fmt.Fprintf(&b, "📍 %s\n", item.Location)
+	}
+	if item.Organizer != nil {
+		fmt.Fprintf(&b, "👤 Organizer: %s\n", personName(item.Organizer.DisplayName, item.Organizer.Email))
+	}
+
+	if item.HangoutLink != "" {
+		fmt.Fprintf(&b, "🔗 Meet: %s\n", item.HangoutLink)
+	}
+	if item.ConferenceData != nil {
+		for _, ep := range item.ConferenceData.EntryPoints {
+			if ep.Uri == item.HangoutLink {
+				continue
+			}
+			line := fmt.Sprintf("🔗 %s: %s", ep.EntryPointType, ep.Uri)
+			if ep.Pin != "" {
+				line += " (PIN " + ep.Pin + ")"
+			}
+			b.WriteString(line + "\n")
+		}
 	}
-	defer f.Close()
 
-	token := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(token)
-	return token, err
+	if len(item.Attendees) > 0 {
+		fmt.Fprintf(&b, "\n👥 Attendees (%d):\n", len(item.Attendees))
+		for _, a := range item.Attendees {
+			line := "- " + personName(a.DisplayName, a.Email)
+			if a.Organizer {
+				line += " (organizer)"
+			}
+			if a.Optional {
+				line += " (optional)"
+			}
+			if status := responseStatus[a.ResponseStatus]; status != "" {
+				line += " - " + status
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+
+	if desc := plainText(item.Description); desc != "" {
+		fmt.Fprintf(&b, "\n📝 %s\n", truncateText(desc, maxDescription))
+	}
+	if item.HtmlLink != "" {
+		fmt.Fprintf(&b, "\n%s\n", item.HtmlLink)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
 }
 
-func (c *CalendarTool) saveToken(token *oauth2.Token) error {
-	f, err := os.Create(c.tokenFile)
+var (
+	htmlTag        = regexp.MustCompile(`<[^>]*>`)
+	responseStatus = map[string]string{
+		"accepted":    "accepted",
+		"declined":    "declined",
+		"tentative":   "maybe",
+		"needsAction": "no response",
+	}
+)
+
+const (
+	maxDescriptionSnippet = 120
+	maxDescription        = 2000
+	maxListedAttendees    = 5
+)
+
+// eventTime formats an event's start or end; all-day events have only a date.
+func eventTime(t *calendar.EventDateTime, loc *time.Location) string {
+	if t == nil {
+		return ""
+	}
+	if parsed, err := time.Parse(time.RFC3339, t.DateTime); err == nil {
+		return parsed.In(loc).Format("Mon Jan 2, 3:04 PM")
+	}
+	if parsed, err := time.Parse("2006-01-02", t.Date); err == nil {
+		return parsed.Format("Mon Jan 2") + " (all day)"
+	}
+	return t.Date
+}
+
+// meetingLink returns the event's video call link, if any.
+func meetingLink(item *calendar.Event) string {
+	if item.HangoutLink != "" {
+		return item.HangoutLink
+	}
+	if item.ConferenceData != nil {
+		for _, ep := range item.ConferenceData.EntryPoints {
+			if ep.EntryPointType == "video" {
+				return ep.Uri
+			}
+		}
+	}
+	return ""
+}
+
+// attendeeSummary lists the first few attendees and how many accepted.
+func attendeeSummary(attendees []*calendar.EventAttendee) string {
+	var names []string
+	accepted := 0
+	for _, a := range attendees {
+		if a.ResponseStatus == "accepted" {
+			accepted++
+		}
+		if len(names) < maxListedAttendees {
+			names = append(names, personName(a.DisplayName, a.Email))
+		}
+	}
+	summary := strings.Join(names, ", ")
+	if extra := len(attendees) - len(names); extra > 0 {
+		summary += fmt.Sprintf(" and %d more", extra)
+	}
+	return fmt.Sprintf("%s (%d/%d accepted)", summary, accepted, len(attendees))
+}
+
+func personName(displayName, email string) string {
+	switch {
+	case displayName != "" && email != "":
+		return fmt.Sprintf("%s <%s>", displayName, email)
+	case displayName != "":
+		return displayName
+	default:
+		return email
+	}
+}
+
+// plainText strips the HTML Google Calendar uses in descriptions and
+// collapses whitespace.
+func plainText(s string) string {
+	s = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n").Replace(s)
+	s = html.UnescapeString(htmlTag.ReplaceAllString(s, ""))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// eventRange picks the time range to list from the range phrase, explicit
+// bounds, or days_ahead, in that order of preference.
+func eventRange(args map[string]any, now time.Time) (dateRange, error) {
+	if phrase, _ := args["range"].(string); phrase != "" {
+		return parseDateRange(phrase, now)
+	}
+
+	timeMin, _ := args["time_min"].(string)
+	timeMax, _ := args["time_max"].(string)
+	if timeMin != "" || timeMax != "" {
+		span := dateRange{Start: now, End: now.AddDate(0, 0, 7)}
+		var err error
+		if timeMin != "" {
+			if span.Start, err = parseTimeBound(timeMin, now.Location(), false); err != nil {
+				return dateRange{}, err
+			}
+			if timeMax == "" {
+				span.End = span.Start.AddDate(0, 0, 7)
+			}
+		}
+		if timeMax != "" {
+			if span.End, err = parseTimeBound(timeMax, now.Location(), true); err != nil {
+				return dateRange{}, err
+			}
+		}
+		if !span.End.After(span.Start) {
+			return dateRange{}, fmt.Errorf("time_max must be after time_min")
+		}
+		return span, nil
+	}
+
+	daysAhead := 7
+	if v, ok := args["days_ahead"].(float64); ok {
+		daysAhead = int(v)
+	}
+	return dateRange{Start: now, End: now.AddDate(0, 0, daysAhead)}, nil
+}
+
+// timeZone returns the primary calendar's time zone, falling back to the
+// server's local zone if it can't be looked up.
+func (a *calendarAccount) timeZone(ctx context.Context) *time.Location {
+	a.mu.Lock()
+	loc := a.location
+	a.mu.Unlock()
+	if loc != nil {
+		return loc
+	}
+
+	loc = time.Local
+	if cal, err := a.service.Calendars.Get("primary").Context(ctx).Do(); err != nil {
+		log.Printf("[calendar] looking up time zone: %v", err)
+		return loc
+	} else if tz, err := time.LoadLocation(cal.TimeZone); err == nil {
+		loc = tz
+	}
+
+	a.mu.Lock()
+	a.location = loc
+	a.mu.Unlock()
+	return loc
+}
+
+// loadToken reads the token of the context's tenant or user. An owner
+// without one takes over the token file from before each user had their
+// own, so upgrading doesn't disconnect the bot's owner.
+func (c *CalendarTool) loadToken(ctx context.Context) (*oauth2.Token, error) {
+	if t, ok := tenant.From(ctx); ok {
+		data, err := t.ReadFile(googleTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token := &oauth2.Token{}
+		return token, json.Unmarshal(data, token)
+	}
+
+	key := accountKey(ctx)
+	if key == "" {
+		return nil, fmt.Errorf("no user to connect a calendar for")
+	}
+	c.mu.RLock()
+	token, ok := c.tokens[key]
+	c.mu.RUnlock()
+	if ok {
+		return token, nil
+	}
+	if auth.RoleFrom(ctx) != auth.Owner || c.tokenFile == "" {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(c.tokenFile)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	token = &oauth2.Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, err
+	}
+	if err := c.saveToken(ctx, token); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(c.tokenFile, c.tokenFile+".migrated"); err != nil {
+		log.Printf("[calendar] renaming %s: %v", c.tokenFile, err)
+	}
+	log.Printf("[calendar] moved the token in %s to user %s", c.tokenFile, key)
+	return token, nil
+}
+
+func (c *CalendarTool) saveToken(ctx context.Context, token *oauth2.Token) error {
+	if t, ok := tenant.From(ctx); ok {
+		data, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		return t.WriteFile(googleTokenFile, data)
+	}
+
+	key := accountKey(ctx)
+	if key == "" {
+		return fmt.Errorf("no user to connect a calendar for")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store == nil {
+		return fmt.Errorf("calendar accounts are not available in this mode")
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(token)
+	c.tokens[key] = token
+	return c.store.Save(calendarStoreKey, c.tokens)
 }
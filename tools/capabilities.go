@@ -0,0 +1,224 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	capabilityTimeout   = 10 * time.Second // Per check
+	capabilityLogPrefix = "[capabilities]"
+)
+
+// Requirement is a command or service a tool needs to work.
+type Requirement struct {
+	// Name is what's missing, as told to the model and logged, e.g. "pytest".
+	Name string
+	// Command must be on PATH, if set.
+	Command string
+	// Check reports whether the requirement is usable, for modules and
+	// endpoints. It runs after Command is found.
+	Check func(ctx context.Context) error
+	// Operations are the values of the tool's operation argument that need
+	// it. Without any, the whole tool does and isn't offered without it.
+	Operations []string
+	// Optional requirements disable nothing; the model is only told
+	// they're missing, e.g. jq for bash.
+	Optional bool
+}
+
+// Requirer is implemented by tools that depend on commands or services
+// that may not be installed.
+type Requirer interface {
+	Requirements() []Requirement
+}
+
+// Capability is what discovery found for one tool.
+type Capability struct {
+	Tool     string
+	Missing  []string // Requirements that aren't usable
+	Disabled []string // Operations turned off because of them
+	Removed  bool     // The tool can't work at all
+}
+
+func (c Capability) String() string {
+	switch {
+	case c.Removed:
+		return fmt.Sprintf("%s: unavailable (%s missing)", c.Tool, strings.Join(c.Missing, ", "))
+	case len(c.Disabled) > 0:
+		return fmt.Sprintf("%s: %s disabled (%s missing)", c.Tool, strings.Join(c.Disabled, ", "), strings.Join(c.Missing, ", "))
+	default:
+		return fmt.Sprintf("%s: %s missing", c.Tool, strings.Join(c.Missing, ", "))
+	}
+}
+
+// Discover checks the requirements of every registered tool, removing the
+// tools that can't work and turning off the operations that can't, so the
+// model isn't offered them. Call it once at startup, after registering
+// tools. It returns what was missing, by tool.
+func (r *Registry) Discover(ctx context.Context) []Capability {
+	var found []Capability
+	for name, tool := range r.tools {
+		req, ok := As[Requirer](tool)
+		if !ok {
+			continue
+		}
+
+		c := Capability{Tool: name}
+		disabled := make(map[string]string)
+		var notes []string
+		for _, need := range req.Requirements() {
+			err := checkRequirement(ctx, need)
+			if err == nil {
+				continue
+			}
+			log.Printf("%s %s: %s unavailable: %v", capabilityLogPrefix, name, need.Name, err)
+			c.Missing = append(c.Missing, need.Name)
+			switch {
+			case need.Optional:
+				notes = append(notes, need.Name+" is not installed.")
+			case len(need.Operations) == 0:
+				c.Removed = true
+			default:
+				for _, op := range need.Operations {
+					if _, ok := disabled[op]; !ok {
+						disabled[op] = need.Name
+					}
+				}
+				notes = append(notes, fmt.Sprintf("%s unavailable: %s disabled.", need.Name, strings.Join(need.Operations, ", ")))
+			}
+		}
+		if len(c.Missing) == 0 {
+			continue
+		}
+
+		if c.Removed {
+			delete(r.tools, name)
+		} else {
+			for op := range disabled {
+				c.Disabled = append(c.Disabled, op)
+			}
+			sort.Strings(c.Disabled)
+			r.tools[name] = &degradedTool{Tool: tool, disabled: disabled, notes: notes}
+		}
+		log.Printf("%s %s", capabilityLogPrefix, c)
+		found = append(found, c)
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Tool < found[j].Tool })
+	return found
+}
+
+func checkRequirement(ctx context.Context, need Requirement) error {
+	if need.Command != "" {
+		if _, err := exec.LookPath(need.Command); err != nil {
+			return err
+		}
+	}
+	if need.Check == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, capabilityTimeout)
+	defer cancel()
+	return need.Check(ctx)
+}
+
+// degradedTool is a tool with some operations turned off because what they
+// need is missing.
+type degradedTool struct {
+	Tool
+	disabled map[string]string // Operation to the missing requirement
+	notes    []string          // Told to the model
+}
+
+func (d *degradedTool) Unwrap() Tool {
+	return d.Tool
+}
+
+func (d *degradedTool) Description() string {
+	return d.Tool.Description() + "\n\nNOT AVAILABLE HERE:\n" + strings.Join(d.notes, "\n")
+}
+
+// Parameters leaves the disabled operations out of the operation enum.
+func (d *degradedTool) Parameters() map[string]any {
+	params := d.Tool.Parameters()
+	props, _ := params["properties"].(map[string]any)
+	op, _ := props["operation"].(map[string]any)
+	enum, _ := op["enum"].([]string)
+	if enum == nil {
+		return params
+	}
+
+	filtered := slices.DeleteFunc(slices.Clone(enum), func(o string) bool {
+		_, off := d.disabled[o]
+		return off
+	})
+	newOp := make(map[string]any, len(op))
+	for k, v := range op {
+		newOp[k] = v
+	}
+	newOp["enum"] = filtered
+	newProps := make(map[string]any, len(props))
+	for k, v := range props {
+		newProps[k] = v
+	}
+	newProps["operation"] = newOp
+	newParams := make(map[string]any, len(params))
+	for k, v := range params {
+		newParams[k] = v
+	}
+	newParams["properties"] = newProps
+	return newParams
+}
+
+// check refuses disabled operations.
+func (d *degradedTool) check(args map[string]any) error {
+	operation, _ := args["operation"].(string)
+	if missing, off := d.disabled[operation]; off {
+		return fmt.Errorf("%s is disabled here: %s is not installed", operation, missing)
+	}
+	return nil
+}
+
+func (d *degradedTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if err := d.check(args); err != nil {
+		return "", err
+	}
+	return d.Tool.Execute(ctx, args)
+}
+
+func (d *degradedTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	if err := d.check(args); err != nil {
+		return nil, err
+	}
+	return Run(ctx, d.Tool, args)
+}
+
+func (d *degradedTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	if err := d.check(args); err != nil {
+		return nil, err
+	}
+	return Stream(ctx, d.Tool, args, chunks)
+}
+
+// Available forwards to the tool, which may be conditional itself.
+func (d *degradedTool) Available(ctx context.Context) bool {
+	return isAvailable(ctx, d.Tool)
+}
+
+// pythonModule returns a check that the interpreter can import a module.
+func pythonModule(python func() string, module string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		out, err := exec.CommandContext(ctx, python(), "-c", "import "+module).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("importing %s: %v: %s", module, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,325 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+
+	"telegram-bot/auth"
+)
+
+const (
+	// DefaultCertWarnDays is how close to expiring a certificate is
+	// reported, unless configured otherwise.
+	DefaultCertWarnDays = 14
+
+	certTimeout = 10 * time.Second
+	certWorkers = 8
+)
+
+// CertInfo is what a server's TLS certificate says about itself.
+type CertInfo struct {
+	Target    string // host:port as checked
+	Subject   string
+	Issuer    string
+	DNSNames  []string
+	NotBefore time.Time
+	NotAfter  time.Time
+	Chain     int   // Certificates the server sent
+	VerifyErr error // Why the chain doesn't verify for the host; nil if it does
+}
+
+// DaysLeft returns the whole days until the certificate expires, negative
+// once it has.
+func (c *CertInfo) DaysLeft(now time.Time) int {
+	return int(c.NotAfter.Sub(now).Hours() / 24)
+}
+
+// certTarget turns a domain, host:port, or URL into host and port, with
+// international names in punycode.
+func certTarget(target string) (host, port string, err error) {
+	target = strings.ToLower(strings.TrimSpace(target))
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		target = u.Host
+	}
+	host, port = target, "443"
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		host, port = h, p
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		return "", "", fmt.Errorf("a domain is required")
+	}
+	if net.ParseIP(host) == nil {
+		if host, err = idna.Lookup.ToASCII(host); err != nil {
+			return "", "", fmt.Errorf("%q isn't a valid domain name: %w", target, err)
+		}
+	}
+	return host, port, nil
+}
+
+// CheckCertificate connects to a server and reads its certificate. A
+// certificate that doesn't verify is still returned, with VerifyErr set,
+// so expired and misconfigured ones can be reported.
+func CheckCertificate(ctx context.Context, target string) (*CertInfo, error) {
+	host, port, err := certTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, certTimeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s sent no certificate", host)
+	}
+	leaf := certs[0]
+	info := &CertInfo{
+		Target:    net.JoinHostPort(host, port),
+		Subject:   leaf.Subject.CommonName,
+		Issuer:    cmp.Or(leaf.Issuer.CommonName, strings.Join(leaf.Issuer.Organization, ", ")),
+		DNSNames:  leaf.DNSNames,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		Chain:     len(certs),
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, info.VerifyErr = leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+	return info, nil
+}
+
+// certResult is one domain's check, for reports on several.
+type certResult struct {
+	Domain string
+	Info   *CertInfo
+	Err    error
+}
+
+// checkCertificates checks the domains at once, returning results in the
+// same order.
+func checkCertificates(ctx context.Context, domains []string) []certResult {
+	results := make([]certResult, len(domains))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(certWorkers, len(domains)) {
+		wg.Go(func() {
+			for i := range jobs {
+				results[i].Domain = domains[i]
+				results[i].Info, results[i].Err = CheckCertificate(ctx, domains[i])
+			}
+		})
+	}
+	for i := range domains {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// ExpiringCertificates checks the domains' certificates and describes those
+// expiring within warnDays, and those that fail to verify or can't be
+// checked. It returns "" when all is well.
+func ExpiringCertificates(ctx context.Context, domains []string, warnDays int) string {
+	now := time.Now()
+	var problems []string
+	for _, r := range checkCertificates(ctx, domains) {
+		switch {
+		case r.Err != nil:
+			problems = append(problems, fmt.Sprintf("❓ %s: %v", r.Domain, r.Err))
+		case r.Info.DaysLeft(now) <= warnDays:
+			problems = append(problems, "• "+certLine(r.Domain, r.Info, now))
+		case r.Info.VerifyErr != nil:
+			problems = append(problems, fmt.Sprintf("⚠️ %s: %v", r.Domain, r.Info.VerifyErr))
+		}
+	}
+	if len(problems) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("🔒 Certificates needing attention (warning %d days ahead):\n%s", warnDays, strings.Join(problems, "\n"))
+}
+
+// certLine summarizes a certificate's expiry in one line.
+func certLine(domain string, info *CertInfo, now time.Time) string {
+	days := info.DaysLeft(now)
+	expiry := info.NotAfter.Local().Format("Jan 2, 2006")
+	switch {
+	case now.After(info.NotAfter):
+		return fmt.Sprintf("%s expired %s (%d days ago)", domain, expiry, -days)
+	case days == 0:
+		return fmt.Sprintf("%s expires today, %s", domain, info.NotAfter.Local().Format("15:04"))
+	case days == 1:
+		return fmt.Sprintf("%s expires tomorrow, %s", domain, expiry)
+	}
+	return fmt.Sprintf("%s expires %s (in %d days)", domain, expiry, days)
+}
+
+// CertsTool checks TLS certificates: any server's on request, and the
+// monitored domains' together.
+type CertsTool struct {
+	domains  []string
+	warnDays int
+}
+
+// CertsOption configures a CertsTool.
+type CertsOption func(*CertsTool)
+
+// WithMonitoredCerts sets the domains checked daily, which the tool can
+// report on together, and how many days ahead expiry is flagged.
+func WithMonitoredCerts(domains []string, warnDays int) CertsOption {
+	return func(c *CertsTool) {
+		c.domains = domains
+		c.warnDays = warnDays
+	}
+}
+
+// NewCertsTool creates a certificate checking tool.
+func NewCertsTool(opts ...CertsOption) *CertsTool {
+	c := &CertsTool{warnDays: DefaultCertWarnDays}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *CertsTool) Name() string {
+	return "certs"
+}
+
+func (c *CertsTool) Description() string {
+	desc := "Check a server's TLS certificate: when it expires, who issued it, the names it covers, and whether it verifies. " +
+		"Use operation=check with domain, e.g. example.com or example.com:8443."
+	if len(c.domains) > 0 {
+		desc += " operation=monitored checks all the domains whose certificates are monitored, soonest to expire first."
+	}
+	return desc
+}
+
+func (c *CertsTool) Parameters() map[string]any {
+	operations := []string{"check"}
+	if len(c.domains) > 0 {
+		operations = append(operations, "monitored")
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        operations,
+				"description": "What to do (default check)",
+			},
+			"domain": map[string]any{
+				"type":        "string",
+				"description": "For check: the domain, host:port, or URL whose certificate to check",
+			},
+		},
+	}
+}
+
+func (c *CertsTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostLow}
+}
+
+func (c *CertsTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "", "check":
+		domain, _ := args["domain"].(string)
+		return c.check(ctx, domain)
+	case "monitored":
+		if len(c.domains) == 0 {
+			return "", fmt.Errorf("no certificates are monitored; set CERT_DOMAINS")
+		}
+		if auth.RoleFrom(ctx) < auth.Trusted {
+			return "", fmt.Errorf("only trusted users can see the monitored domains")
+		}
+		return c.monitored(ctx), nil
+	}
+	return "", fmt.Errorf("unknown operation: %s", operation)
+}
+
+func (c *CertsTool) check(ctx context.Context, domain string) (string, error) {
+	info, err := CheckCertificate(ctx, domain)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	var sb strings.Builder
+	icon := "🔒"
+	if info.DaysLeft(now) <= c.warnDays || info.VerifyErr != nil {
+		icon = "⚠️"
+	}
+	fmt.Fprintf(&sb, "%s %s\n", icon, certLine(info.Target, info, now))
+	fmt.Fprintf(&sb, "Issued by %s on %s\n", info.Issuer, info.NotBefore.Local().Format("Jan 2, 2006"))
+	if info.Subject != "" {
+		fmt.Fprintf(&sb, "Subject: %s\n", info.Subject)
+	}
+	if len(info.DNSNames) > 0 {
+		names := info.DNSNames
+		more := ""
+		if len(names) > 10 {
+			names, more = names[:10], fmt.Sprintf(" and %d more", len(info.DNSNames)-10)
+		}
+		fmt.Fprintf(&sb, "Covers: %s%s\n", strings.Join(names, ", "), more)
+	}
+	if info.VerifyErr != nil {
+		fmt.Fprintf(&sb, "Doesn't verify: %v\n", info.VerifyErr)
+	} else {
+		fmt.Fprintf(&sb, "Chain verifies (%d certificates sent)\n", info.Chain)
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// monitored reports every monitored domain, soonest to expire first, with
+// ones that couldn't be checked at the end.
+func (c *CertsTool) monitored(ctx context.Context) string {
+	results := checkCertificates(ctx, c.domains)
+	slices.SortStableFunc(results, func(a, b certResult) int {
+		switch {
+		case a.Err != nil && b.Err != nil:
+			return 0
+		case a.Err != nil:
+			return 1
+		case b.Err != nil:
+			return -1
+		}
+		return a.Info.NotAfter.Compare(b.Info.NotAfter)
+	})
+
+	now := time.Now()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔒 %d monitored certificate(s), warning %d days ahead:\n", len(results), c.warnDays)
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(&sb, "❓ %s: %v\n", r.Domain, r.Err)
+		case r.Info.DaysLeft(now) <= c.warnDays:
+			fmt.Fprintf(&sb, "⚠️ %s\n", certLine(r.Domain, r.Info, now))
+		case r.Info.VerifyErr != nil:
+			fmt.Fprintf(&sb, "⚠️ %s; doesn't verify: %v\n", certLine(r.Domain, r.Info, now), r.Info.VerifyErr)
+		default:
+			fmt.Fprintf(&sb, "✅ %s\n", certLine(r.Domain, r.Info, now))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
@@ -0,0 +1,347 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+)
+
+const (
+	pinsStoreKey    = "pins"
+	chatAdminPrefix = "[chat_admin]"
+	maxPinsPerChat  = 50
+	maxChatTitle    = 128 // Telegram's limits
+	maxChatDesc     = 255
+	maxPinText      = 4000
+	chatRightsTTL   = 10 * time.Minute
+)
+
+// ChatRights are what a user, or the bot itself, may do in a chat.
+type ChatRights struct {
+	Admin         bool // An administrator or the creator
+	CanPin        bool
+	CanChangeInfo bool // Title, description, and photo
+}
+
+// ChatAdmin manages chats on the transport.
+type ChatAdmin interface {
+	// BotRights reports what the bot may do in a chat. In private chats it
+	// may pin but not change info.
+	BotRights(chatID int64) (ChatRights, error)
+	// Rights reports what a user may do in a group chat.
+	Rights(chatID, userID int64) (ChatRights, error)
+	// Post sends a message and returns its ID.
+	Post(chatID int64, text string) (int, error)
+	Pin(chatID int64, messageID int) error
+	Unpin(chatID int64, messageID int) error
+	SetTitle(chatID int64, title string) error
+	SetDescription(chatID int64, description string) error
+}
+
+// pinRecord is a message the bot pinned.
+type pinRecord struct {
+	MessageID int       `json:"message_id"`
+	Text      string    `json:"text"`
+	By        string    `json:"by,omitempty"`
+	Pinned    time.Time `json:"pinned"`
+}
+
+type cachedRights struct {
+	rights  ChatRights
+	checked time.Time
+}
+
+// ChatAdminTool pins messages and sets titles and descriptions in chats
+// where the bot has the rights to. It keeps its own list of pins, since
+// Telegram only reports the latest one.
+type ChatAdminTool struct {
+	host   *Host // Set by Start; nil when background work is unavailable
+	mu     sync.Mutex
+	pins   map[int64][]pinRecord // By chat
+	rights map[int64]cachedRights
+}
+
+// NewChatAdminTool creates a chat admin tool.
+func NewChatAdminTool() *ChatAdminTool {
+	return &ChatAdminTool{pins: make(map[int64][]pinRecord), rights: make(map[int64]cachedRights)}
+}
+
+func (c *ChatAdminTool) Name() string {
+	return "chat_admin"
+}
+
+func (c *ChatAdminTool) Description() string {
+	return `Pins and chat settings, in chats where the bot is an admin (or a private chat).
+
+operation=pin with text posts it and pins it, e.g. "pin that docker command" is
+pin with text=<the command>. operation=pins lists what the bot has pinned here.
+operation=unpin with pin (its number from pins) unpins one.
+operation=title or description with text sets the group's title or description;
+the user must be allowed to change them too.`
+}
+
+func (c *ChatAdminTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"pin", "pins", "unpin", "title", "description"},
+				"description": "What to do",
+			},
+			"text": map[string]any{
+				"type":        "string",
+				"description": "For pin: what to pin. For title and description: the new value",
+			},
+			"pin": map[string]any{
+				"type":        "number",
+				"description": "For unpin: the pin's number from pins",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (c *ChatAdminTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+// Available offers the tool only in chats where the bot can pin or change
+// the chat's info.
+func (c *ChatAdminTool) Available(ctx context.Context) bool {
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return false
+	}
+	rights, err := c.botRights(chatID)
+	return err == nil && (rights.CanPin || rights.CanChangeInfo)
+}
+
+// Start loads the pins.
+func (c *ChatAdminTool) Start(host Host) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.host = &host
+	if _, err := host.Store.Get(pinsStoreKey, &c.pins); err != nil {
+		return fmt.Errorf("loading pins: %w", err)
+	}
+	if c.pins == nil {
+		c.pins = make(map[int64][]pinRecord)
+	}
+	n := 0
+	for _, pins := range c.pins {
+		n += len(pins)
+	}
+	log.Printf("%s %d pins in %d chats", chatAdminPrefix, n, len(c.pins))
+	return nil
+}
+
+// botRights asks the transport what the bot may do in the chat, caching
+// the answer for a while since every request checks it.
+func (c *ChatAdminTool) botRights(chatID int64) (ChatRights, error) {
+	c.mu.Lock()
+	cached, ok := c.rights[chatID]
+	host := c.host
+	c.mu.Unlock()
+	if ok && time.Since(cached.checked) < chatRightsTTL {
+		return cached.rights, nil
+	}
+	if host == nil || host.ChatAdmin == nil {
+		return ChatRights{}, fmt.Errorf("chat admin is not available in this mode")
+	}
+
+	rights, err := host.ChatAdmin.BotRights(chatID)
+	if err != nil {
+		log.Printf("%s checking rights in %d: %v", chatAdminPrefix, chatID, err)
+		return ChatRights{}, err
+	}
+	c.mu.Lock()
+	c.rights[chatID] = cachedRights{rights: rights, checked: time.Now()}
+	c.mu.Unlock()
+	return rights, nil
+}
+
+func (c *ChatAdminTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if c.host == nil || c.host.ChatAdmin == nil {
+		return "", fmt.Errorf("chat admin is not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("chat admin needs a chat")
+	}
+	rights, err := c.botRights(chatID)
+	if err != nil {
+		return "", fmt.Errorf("checking the bot's rights: %w", err)
+	}
+
+	operation, _ := args["operation"].(string)
+	text, _ := args["text"].(string)
+	text = strings.TrimSpace(text)
+	switch operation {
+	case "pin":
+		if !rights.CanPin {
+			return "", fmt.Errorf("the bot isn't allowed to pin messages here; make it an admin with the right to pin")
+		}
+		return c.pin(ctx, chatID, text)
+	case "pins":
+		return c.list(chatID), nil
+	case "unpin":
+		if !rights.CanPin {
+			return "", fmt.Errorf("the bot isn't allowed to pin messages here")
+		}
+		n, _ := args["pin"].(float64)
+		return c.unpin(chatID, int(n))
+	case "title", "description":
+		if !rights.CanChangeInfo {
+			return "", fmt.Errorf("the bot can't change this chat's info; it must be a group admin with the right to change it")
+		}
+		if err := c.checkUser(ctx, chatID); err != nil {
+			return "", err
+		}
+		return c.setInfo(chatID, operation, text)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// checkUser makes sure the requester could change the chat's info
+// themselves, so the bot doesn't extend anyone's rights. Owners may
+// always.
+func (c *ChatAdminTool) checkUser(ctx context.Context, chatID int64) error {
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		return fmt.Errorf("unknown user")
+	}
+	if user.Role == auth.Owner {
+		return nil
+	}
+	rights, err := c.host.ChatAdmin.Rights(chatID, user.ID)
+	if err != nil {
+		return fmt.Errorf("checking your rights: %w", err)
+	}
+	if !rights.CanChangeInfo {
+		return fmt.Errorf("only the group's admins can change its info")
+	}
+	return nil
+}
+
+func (c *ChatAdminTool) pin(ctx context.Context, chatID int64, text string) (string, error) {
+	if text == "" {
+		return "", fmt.Errorf("text is required for pin")
+	}
+	if len(text) > maxPinText {
+		return "", fmt.Errorf("that's too long to pin (at most %d characters)", maxPinText)
+	}
+
+	messageID, err := c.host.ChatAdmin.Post(chatID, "📌 "+text)
+	if err != nil {
+		return "", fmt.Errorf("posting message: %w", err)
+	}
+	if err := c.host.ChatAdmin.Pin(chatID, messageID); err != nil {
+		return "", fmt.Errorf("pinning message: %w", err)
+	}
+
+	var by string
+	if user, ok := auth.UserFrom(ctx); ok {
+		by = user.UserName
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pins := append(c.pins[chatID], pinRecord{MessageID: messageID, Text: text, By: by, Pinned: time.Now().UTC()})
+	if len(pins) > maxPinsPerChat {
+		pins = pins[len(pins)-maxPinsPerChat:]
+	}
+	c.pins[chatID] = pins
+	if err := c.host.Store.Save(pinsStoreKey, c.pins); err != nil {
+		return "", err
+	}
+	log.Printf("%s pinned message %d in chat %d", chatAdminPrefix, messageID, chatID)
+	return fmt.Sprintf("📌 Pinned (#%d).", len(pins)), nil
+}
+
+func (c *ChatAdminTool) list(chatID int64) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pins := c.pins[chatID]
+	if len(pins) == 0 {
+		return "Nothing pinned here yet."
+	}
+	var b strings.Builder
+	b.WriteString("📌 Pinned:\n")
+	for i, pin := range pins {
+		fmt.Fprintf(&b, "\n%d. %s", i+1, truncateText(strings.ReplaceAll(pin.Text, "\n", " "), 100))
+		if pin.By != "" {
+			fmt.Fprintf(&b, " (@%s, %s)", pin.By, pin.Pinned.Local().Format("Jan 2"))
+		} else {
+			fmt.Fprintf(&b, " (%s)", pin.Pinned.Local().Format("Jan 2"))
+		}
+	}
+	return b.String()
+}
+
+func (c *ChatAdminTool) unpin(chatID int64, n int) (string, error) {
+	c.mu.Lock()
+	pins := c.pins[chatID]
+	c.mu.Unlock()
+	if n < 1 || n > len(pins) {
+		return "", fmt.Errorf("no pin #%d here; pins lists them", n)
+	}
+	pin := pins[n-1]
+	if err := c.host.ChatAdmin.Unpin(chatID, pin.MessageID); err != nil {
+		// It may have been unpinned by hand; forget it either way
+		log.Printf("%s unpinning message %d in chat %d: %v", chatAdminPrefix, pin.MessageID, chatID, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pins = c.pins[chatID]
+	for i := range pins {
+		if pins[i].MessageID == pin.MessageID {
+			c.pins[chatID] = append(pins[:i:i], pins[i+1:]...)
+			break
+		}
+	}
+	if len(c.pins[chatID]) == 0 {
+		delete(c.pins, chatID)
+	}
+	if err := c.host.Store.Save(pinsStoreKey, c.pins); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Unpinned: %s", truncateText(pin.Text, 100)), nil
+}
+
+func (c *ChatAdminTool) setInfo(chatID int64, operation, text string) (string, error) {
+	switch operation {
+	case "title":
+		if text == "" {
+			return "", fmt.Errorf("text is required for title")
+		}
+		if len([]rune(text)) > maxChatTitle {
+			return "", fmt.Errorf("the title is too long (at most %d characters)", maxChatTitle)
+		}
+		if err := c.host.ChatAdmin.SetTitle(chatID, text); err != nil {
+			return "", fmt.Errorf("setting title: %w", err)
+		}
+		log.Printf("%s set the title of chat %d", chatAdminPrefix, chatID)
+		return fmt.Sprintf("Renamed the chat to %q.", text), nil
+	default:
+		if len([]rune(text)) > maxChatDesc {
+			return "", fmt.Errorf("the description is too long (at most %d characters)", maxChatDesc)
+		}
+		if err := c.host.ChatAdmin.SetDescription(chatID, text); err != nil {
+			return "", fmt.Errorf("setting description: %w", err)
+		}
+		log.Printf("%s set the description of chat %d", chatAdminPrefix, chatID)
+		if text == "" {
+			return "Cleared the chat's description.", nil
+		}
+		return "Updated the chat's description.", nil
+	}
+}
@@ -0,0 +1,496 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	cloudLogPrefix     = "[cloud]"
+	cloudTimeout       = 60 * time.Second
+	maxCloudListed     = 40 // Instances, buckets, or alarms per reply
+	maxBucketsMeasured = 50
+	maxCostsListed     = 15
+	cloudWorkers       = 8
+	cloudApproval      = "Yes"
+)
+
+// cloudInstance is a virtual machine.
+type cloudInstance struct {
+	ID        string
+	Name      string
+	Type      string
+	Zone      string
+	State     string
+	PublicIP  string
+	PrivateIP string
+	Launched  time.Time
+}
+
+// cloudBucket is an object storage bucket. Size is -1 when unknown.
+type cloudBucket struct {
+	Name    string
+	Region  string
+	Size    int64
+	Created time.Time
+}
+
+// cloudCosts are spend by service over a period.
+type cloudCosts struct {
+	Period   string // e.g. "October 1–18"
+	Currency string
+	Services map[string]float64
+	Previous float64 // The whole previous period, for comparison; 0 if unknown
+}
+
+// cloudAlarm is a monitoring alarm or alert policy.
+type cloudAlarm struct {
+	Name   string
+	State  string // ALARM, OK, or INSUFFICIENT_DATA; "enabled" or "disabled" for policies
+	Reason string
+	Since  time.Time
+}
+
+// cloudProvider answers the tool's queries for one cloud.
+type cloudProvider interface {
+	name() string
+	instances(ctx context.Context) ([]cloudInstance, error)
+	buckets(ctx context.Context) ([]cloudBucket, error)
+	costs(ctx context.Context) (*cloudCosts, error)
+	alarms(ctx context.Context) ([]cloudAlarm, error)
+	// setRunning starts or stops an instance in a zone or region.
+	setRunning(ctx context.Context, id, zone string, running bool) error
+}
+
+// CloudTool answers quick questions about AWS and Google Cloud accounts
+// with the credentials the host is set up with: running instances, bucket
+// sizes, recent spend, and alarms. Starting and stopping instances is off
+// unless enabled.
+type CloudTool struct {
+	providers []cloudProvider
+	writes    bool
+}
+
+// CloudOption configures a CloudTool.
+type CloudOption func(*CloudTool)
+
+// WithAWS queries AWS through the aws CLI, in regions, or the CLI's
+// default region if none are given.
+func WithAWS(regions []string) CloudOption {
+	return func(c *CloudTool) {
+		c.providers = append(c.providers, &awsCloud{regions: regions})
+	}
+}
+
+// WithGCP queries a Google Cloud project with application default
+// credentials. billingTable is the BigQuery billing export, as
+// project.dataset.table; empty leaves out spend.
+func WithGCP(project, billingTable string) CloudOption {
+	return func(c *CloudTool) {
+		c.providers = append(c.providers, newGCPCloud(project, billingTable))
+	}
+}
+
+// WithCloudWrites enables starting and stopping instances, each after the
+// user approves it.
+func WithCloudWrites() CloudOption {
+	return func(c *CloudTool) {
+		c.writes = true
+	}
+}
+
+// NewCloudTool creates a cloud tool for the clouds its options enable.
+func NewCloudTool(opts ...CloudOption) *CloudTool {
+	c := &CloudTool{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, p := range c.providers {
+		if g, ok := p.(*gcpCloud); ok {
+			g.writes = c.writes
+		}
+	}
+	return c
+}
+
+func (c *CloudTool) Name() string {
+	return "cloud"
+}
+
+func (c *CloudTool) Description() string {
+	desc := `Query the user's cloud accounts (` + c.providerNames() + `):
+- instances: running virtual machines, with type, zone, IPs, and uptime
+- buckets: storage buckets and how much they hold
+- billing: spend so far this month by service, against last month
+- alarms: CloudWatch alarms that are firing (AWS), or alert policies (Google Cloud)`
+	if c.writes {
+		desc += `
+- start, stop: start or stop the instance id in region (AWS) or zone (Google Cloud). The user is asked
+  to approve it; don't ask them yourself.`
+	}
+	return desc
+}
+
+func (c *CloudTool) operations() []string {
+	ops := []string{"instances", "buckets", "billing", "alarms"}
+	if c.writes {
+		ops = append(ops, "start", "stop")
+	}
+	return ops
+}
+
+func (c *CloudTool) Parameters() map[string]any {
+	names := make([]string, len(c.providers))
+	for i, p := range c.providers {
+		names[i] = p.name()
+	}
+	properties := map[string]any{
+		"operation": map[string]any{
+			"type":        "string",
+			"enum":        c.operations(),
+			"description": "What to look up",
+		},
+		"provider": map[string]any{
+			"type":        "string",
+			"enum":        names,
+			"description": "Which cloud (default: every configured one)",
+		},
+	}
+	if c.writes {
+		properties["id"] = map[string]any{
+			"type":        "string",
+			"description": "For start and stop: the instance ID (AWS) or name (Google Cloud)",
+		}
+		properties["zone"] = map[string]any{
+			"type":        "string",
+			"description": "For start and stop: the instance's region (AWS) or zone (Google Cloud)",
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   []string{"operation"},
+	}
+}
+
+func (c *CloudTool) Metadata() Metadata {
+	if c.writes {
+		return Metadata{Dangerous: true, Cost: CostMedium}
+	}
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+func (c *CloudTool) Requirements() []Requirement {
+	var reqs []Requirement
+	for _, p := range c.providers {
+		if p.name() == "aws" {
+			reqs = append(reqs, Requirement{Name: "aws CLI", Command: "aws", Optional: len(c.providers) > 1})
+		}
+	}
+	return reqs
+}
+
+// FormFields asks the user to approve every start and stop, whatever the
+// model passed.
+func (c *CloudTool) FormFields(ctx context.Context, args map[string]any) []FormField {
+	operation, _ := args["operation"].(string)
+	if !c.writes || (operation != "start" && operation != "stop") {
+		return nil
+	}
+	id, _ := args["id"].(string)
+	zone, _ := args["zone"].(string)
+	p, err := c.provider(args)
+	if err != nil || id == "" || zone == "" {
+		return nil // Running the call reports why
+	}
+	prompt := fmt.Sprintf("%s %s instance %s in %s?", capitalize(operation), p.name(), id, zone)
+	return []FormField{approvalField(prompt, cloudApproval)}
+}
+
+func (c *CloudTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	if !slices.Contains(c.operations(), operation) {
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+	if operation == "start" || operation == "stop" {
+		return c.setRunning(ctx, args, operation == "start")
+	}
+
+	providers := c.providers
+	if name, _ := args["provider"].(string); name != "" {
+		p, err := c.provider(args)
+		if err != nil {
+			return "", err
+		}
+		providers = []cloudProvider{p}
+	}
+	var sections []string
+	for _, p := range providers {
+		ctx, cancel := context.WithTimeout(ctx, cloudTimeout)
+		text, err := c.query(ctx, p, operation)
+		cancel()
+		if err != nil {
+			if len(providers) == 1 {
+				return "", err
+			}
+			text = fmt.Sprintf("⚠️ %s: %v", p.name(), err)
+		}
+		sections = append(sections, text)
+	}
+	return strings.Join(sections, "\n\n"), nil
+}
+
+// query runs a read-only operation against one cloud.
+func (c *CloudTool) query(ctx context.Context, p cloudProvider, operation string) (string, error) {
+	switch operation {
+	case "instances":
+		instances, err := p.instances(ctx)
+		if err != nil {
+			return "", err
+		}
+		return instanceReport(p.name(), instances), nil
+	case "buckets":
+		buckets, err := p.buckets(ctx)
+		if err != nil {
+			return "", err
+		}
+		return bucketReport(p.name(), buckets), nil
+	case "billing":
+		costs, err := p.costs(ctx)
+		if err != nil {
+			return "", err
+		}
+		return costReport(p.name(), costs), nil
+	default:
+		alarms, err := p.alarms(ctx)
+		if err != nil {
+			return "", err
+		}
+		return alarmReport(p.name(), alarms), nil
+	}
+}
+
+// setRunning starts or stops an instance the user approved.
+func (c *CloudTool) setRunning(ctx context.Context, args map[string]any, running bool) (string, error) {
+	p, err := c.provider(args)
+	if err != nil {
+		return "", err
+	}
+	id, _ := args["id"].(string)
+	zone, _ := args["zone"].(string)
+	if id == "" || zone == "" {
+		return "", fmt.Errorf("id and zone are required")
+	}
+	if !approved(ctx, c.Name()) {
+		return "", fmt.Errorf("starting or stopping an instance needs the user's approval, which the call asks for")
+	}
+	ctx, cancel := context.WithTimeout(ctx, cloudTimeout)
+	defer cancel()
+	if err := p.setRunning(ctx, id, zone, running); err != nil {
+		return "", err
+	}
+	if running {
+		return fmt.Sprintf("▶️ Starting %s in %s.", id, zone), nil
+	}
+	return fmt.Sprintf("⏹ Stopping %s in %s.", id, zone), nil
+}
+
+// provider returns the cloud named by the provider argument, or the only
+// one.
+func (c *CloudTool) provider(args map[string]any) (cloudProvider, error) {
+	name, _ := args["provider"].(string)
+	if name == "" && len(c.providers) == 1 {
+		return c.providers[0], nil
+	}
+	for _, p := range c.providers {
+		if p.name() == name {
+			return p, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("provider is required (%s)", c.providerNames())
+	}
+	return nil, fmt.Errorf("%s isn't configured (use %s)", name, c.providerNames())
+}
+
+func (c *CloudTool) providerNames() string {
+	names := make([]string, len(c.providers))
+	for i, p := range c.providers {
+		names[i] = p.name()
+	}
+	return strings.Join(names, ", ")
+}
+
+func instanceReport(provider string, instances []cloudInstance) string {
+	if len(instances) == 0 {
+		return fmt.Sprintf("☁️ %s: no running instances.", provider)
+	}
+	slices.SortFunc(instances, func(a, b cloudInstance) int {
+		return strings.Compare(a.Zone+a.Name+a.ID, b.Zone+b.Name+b.ID)
+	})
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "☁️ %s: %d running instance(s)\n", provider, len(instances))
+	for i, in := range instances {
+		if i == maxCloudListed {
+			fmt.Fprintf(&sb, "… and %d more\n", len(instances)-i)
+			break
+		}
+		label := in.ID
+		if in.Name != "" && in.Name != in.ID {
+			label = fmt.Sprintf("%s (%s)", in.Name, in.ID)
+		}
+		fmt.Fprintf(&sb, "• %s — %s, %s", label, in.Type, in.Zone)
+		if ip := cmp.Or(in.PublicIP, in.PrivateIP); ip != "" {
+			fmt.Fprintf(&sb, ", %s", ip)
+		}
+		if !in.Launched.IsZero() {
+			fmt.Fprintf(&sb, ", up %s", formatUptime(time.Since(in.Launched)))
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func formatUptime(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
+
+func bucketReport(provider string, buckets []cloudBucket) string {
+	if len(buckets) == 0 {
+		return fmt.Sprintf("🪣 %s: no buckets.", provider)
+	}
+	slices.SortFunc(buckets, func(a, b cloudBucket) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Name, b.Name))
+	})
+	var total int64
+	unknown := 0
+	for _, b := range buckets {
+		if b.Size >= 0 {
+			total += b.Size
+		} else {
+			unknown++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🪣 %s: %d bucket(s), %s in all\n", provider, len(buckets), formatSize(total))
+	for i, b := range buckets {
+		if i == maxCloudListed {
+			fmt.Fprintf(&sb, "… and %d more\n", len(buckets)-i)
+			break
+		}
+		size := "size unknown"
+		if b.Size >= 0 {
+			size = formatSize(b.Size)
+		}
+		fmt.Fprintf(&sb, "• %s — %s, %s\n", b.Name, size, b.Region)
+	}
+	if unknown > 0 {
+		sb.WriteString("\nSizes come from daily storage metrics, so new and empty buckets have none yet.")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func costReport(provider string, costs *cloudCosts) string {
+	type service struct {
+		name   string
+		amount float64
+	}
+	var services []service
+	var total float64
+	for name, amount := range costs.Services {
+		total += amount
+		services = append(services, service{name, amount})
+	}
+	slices.SortFunc(services, func(a, b service) int {
+		return cmp.Or(cmp.Compare(b.amount, a.amount), strings.Compare(a.name, b.name))
+	})
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "💰 %s, %s: %.2f %s\n", provider, costs.Period, total, costs.Currency)
+	if costs.Previous > 0 {
+		fmt.Fprintf(&sb, "Last month: %.2f %s\n", costs.Previous, costs.Currency)
+	}
+	listed := 0
+	for _, s := range services {
+		if s.amount < 0.005 {
+			continue
+		}
+		if listed == maxCostsListed {
+			fmt.Fprintf(&sb, "… and %d more services\n", len(services)-listed)
+			break
+		}
+		listed++
+		fmt.Fprintf(&sb, "• %s: %.2f\n", s.name, s.amount)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func alarmReport(provider string, alarms []cloudAlarm) string {
+	if len(alarms) == 0 {
+		return fmt.Sprintf("🔔 %s: no alarms.", provider)
+	}
+	states := map[string]int{}
+	var firing []cloudAlarm
+	for _, a := range alarms {
+		states[a.State]++
+		if a.State == "ALARM" {
+			firing = append(firing, a)
+		}
+	}
+
+	var sb strings.Builder
+	if _, ok := states["ALARM"]; !ok && (states["OK"] > 0 || states["INSUFFICIENT_DATA"] > 0) {
+		fmt.Fprintf(&sb, "✅ %s: no alarms firing (%s)", provider, countList(states, 0))
+		return sb.String()
+	}
+	if len(firing) > 0 {
+		fmt.Fprintf(&sb, "🚨 %s: %d alarm(s) firing (%s)\n", provider, len(firing), countList(states, 0))
+		slices.SortFunc(firing, func(a, b cloudAlarm) int { return b.Since.Compare(a.Since) })
+		for i, a := range firing {
+			if i == maxCloudListed {
+				fmt.Fprintf(&sb, "… and %d more\n", len(firing)-i)
+				break
+			}
+			fmt.Fprintf(&sb, "• %s", a.Name)
+			if !a.Since.IsZero() {
+				fmt.Fprintf(&sb, " (for %s)", formatUptime(time.Since(a.Since)))
+			}
+			if a.Reason != "" {
+				fmt.Fprintf(&sb, ": %s", truncateText(a.Reason, 200))
+			}
+			sb.WriteString("\n")
+		}
+		return strings.TrimRight(sb.String(), "\n")
+	}
+
+	// Alert policies, which have no firing state to report
+	noun := "alert policies"
+	if len(alarms) == 1 {
+		noun = "alert policy"
+	}
+	fmt.Fprintf(&sb, "🔔 %s: %d %s (%s)\n", provider, len(alarms), noun, countList(states, 0))
+	for i, a := range alarms {
+		if i == maxCloudListed {
+			fmt.Fprintf(&sb, "… and %d more\n", len(alarms)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "• %s (%s)", a.Name, a.State)
+		if a.Reason != "" {
+			fmt.Fprintf(&sb, ": %s", a.Reason)
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
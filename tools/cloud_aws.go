@@ -0,0 +1,285 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	awsInstanceID = regexp.MustCompile(`^i-[0-9a-f]{8,17}$`)
+	awsRegion     = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+)
+
+// awsCloud queries AWS through the aws CLI, which finds credentials the
+// way the SDKs do: environment, profiles, SSO, or the instance role.
+type awsCloud struct {
+	regions []string // Empty uses the CLI's default region
+}
+
+func (a *awsCloud) name() string {
+	return "aws"
+}
+
+// eachRegion returns the configured regions, or the default one as "".
+func (a *awsCloud) eachRegion() []string {
+	if len(a.regions) == 0 {
+		return []string{""}
+	}
+	return a.regions
+}
+
+func (a *awsCloud) instances(ctx context.Context) ([]cloudInstance, error) {
+	var instances []cloudInstance
+	for _, region := range a.eachRegion() {
+		var out struct {
+			Reservations []struct {
+				Instances []struct {
+					InstanceID       string    `json:"InstanceId"`
+					InstanceType     string    `json:"InstanceType"`
+					LaunchTime       time.Time `json:"LaunchTime"`
+					PublicIPAddress  string    `json:"PublicIpAddress"`
+					PrivateIPAddress string    `json:"PrivateIpAddress"`
+					Placement        struct {
+						AvailabilityZone string `json:"AvailabilityZone"`
+					} `json:"Placement"`
+					State struct {
+						Name string `json:"Name"`
+					} `json:"State"`
+					Tags []struct {
+						Key   string `json:"Key"`
+						Value string `json:"Value"`
+					} `json:"Tags"`
+				} `json:"Instances"`
+			} `json:"Reservations"`
+		}
+		if err := runAWS(ctx, region, &out, "ec2", "describe-instances",
+			"--filters", "Name=instance-state-name,Values=running"); err != nil {
+			return nil, err
+		}
+		for _, r := range out.Reservations {
+			for _, in := range r.Instances {
+				instance := cloudInstance{
+					ID:        in.InstanceID,
+					Type:      in.InstanceType,
+					Zone:      in.Placement.AvailabilityZone,
+					State:     in.State.Name,
+					PublicIP:  in.PublicIPAddress,
+					PrivateIP: in.PrivateIPAddress,
+					Launched:  in.LaunchTime,
+				}
+				for _, tag := range in.Tags {
+					if tag.Key == "Name" {
+						instance.Name = tag.Value
+					}
+				}
+				instances = append(instances, instance)
+			}
+		}
+	}
+	return instances, nil
+}
+
+// buckets lists the account's buckets with their size from CloudWatch's
+// daily BucketSizeBytes metric, in standard storage.
+func (a *awsCloud) buckets(ctx context.Context) ([]cloudBucket, error) {
+	var out struct {
+		Buckets []struct {
+			Name         string    `json:"Name"`
+			CreationDate time.Time `json:"CreationDate"`
+			BucketRegion string    `json:"BucketRegion"`
+		} `json:"Buckets"`
+	}
+	if err := runAWS(ctx, "", &out, "s3api", "list-buckets"); err != nil {
+		return nil, err
+	}
+	buckets := make([]cloudBucket, len(out.Buckets))
+	for i, b := range out.Buckets {
+		buckets[i] = cloudBucket{Name: b.Name, Region: b.BucketRegion, Size: -1, Created: b.CreationDate}
+	}
+
+	jobs := make(chan *cloudBucket)
+	var wg sync.WaitGroup
+	for range cloudWorkers {
+		wg.Go(func() {
+			for b := range jobs {
+				a.measure(ctx, b)
+			}
+		})
+	}
+	for i := range buckets[:min(len(buckets), maxBucketsMeasured)] {
+		jobs <- &buckets[i]
+	}
+	close(jobs)
+	wg.Wait()
+	return buckets, nil
+}
+
+// measure fills in a bucket's region, if the listing lacked it, and size.
+// Failures leave them unknown.
+func (a *awsCloud) measure(ctx context.Context, b *cloudBucket) {
+	if b.Region == "" {
+		var location struct {
+			LocationConstraint string `json:"LocationConstraint"`
+		}
+		if err := runAWS(ctx, "", &location, "s3api", "get-bucket-location", "--bucket", b.Name); err != nil {
+			return
+		}
+		switch location.LocationConstraint {
+		case "":
+			b.Region = "us-east-1"
+		case "EU":
+			b.Region = "eu-west-1"
+		default:
+			b.Region = location.LocationConstraint
+		}
+	}
+
+	now := time.Now().UTC()
+	var stats struct {
+		Datapoints []struct {
+			Timestamp time.Time `json:"Timestamp"`
+			Average   float64   `json:"Average"`
+		} `json:"Datapoints"`
+	}
+	err := runAWS(ctx, b.Region, &stats, "cloudwatch", "get-metric-statistics",
+		"--namespace", "AWS/S3", "--metric-name", "BucketSizeBytes",
+		"--dimensions", "Name=BucketName,Value="+b.Name, "Name=StorageType,Value=StandardStorage",
+		"--start-time", now.Add(-72*time.Hour).Format(time.RFC3339), "--end-time", now.Format(time.RFC3339),
+		"--period", "86400", "--statistics", "Average")
+	if err != nil {
+		return
+	}
+	var latest time.Time
+	for _, p := range stats.Datapoints {
+		if p.Timestamp.After(latest) {
+			latest, b.Size = p.Timestamp, int64(p.Average)
+		}
+	}
+}
+
+// costs asks Cost Explorer for this month's spend by service and last
+// month's total. Each request costs a cent.
+func (a *awsCloud) costs(ctx context.Context) (*cloudCosts, error) {
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var out struct {
+		ResultsByTime []struct {
+			TimePeriod struct {
+				Start string `json:"Start"`
+			} `json:"TimePeriod"`
+			Groups []struct {
+				Keys    []string `json:"Keys"`
+				Metrics map[string]struct {
+					Amount string `json:"Amount"`
+					Unit   string `json:"Unit"`
+				} `json:"Metrics"`
+			} `json:"Groups"`
+		} `json:"ResultsByTime"`
+	}
+	period := fmt.Sprintf("Start=%s,End=%s", month.AddDate(0, -1, 0).Format(time.DateOnly), now.AddDate(0, 0, 1).Format(time.DateOnly))
+	if err := runAWS(ctx, "us-east-1", &out, "ce", "get-cost-and-usage", "--time-period", period,
+		"--granularity", "MONTHLY", "--metrics", "UnblendedCost", "--group-by", "Type=DIMENSION,Key=SERVICE"); err != nil {
+		return nil, err
+	}
+
+	costs := &cloudCosts{
+		Period:   fmt.Sprintf("%s 1–%d", now.Month(), now.Day()),
+		Currency: "USD",
+		Services: make(map[string]float64),
+	}
+	for _, result := range out.ResultsByTime {
+		current := result.TimePeriod.Start == month.Format(time.DateOnly)
+		for _, g := range result.Groups {
+			metric := g.Metrics["UnblendedCost"]
+			amount, _ := strconv.ParseFloat(metric.Amount, 64)
+			if metric.Unit != "" {
+				costs.Currency = metric.Unit
+			}
+			switch {
+			case !current:
+				costs.Previous += amount
+			case len(g.Keys) > 0:
+				costs.Services[g.Keys[0]] += amount
+			}
+		}
+	}
+	return costs, nil
+}
+
+func (a *awsCloud) alarms(ctx context.Context) ([]cloudAlarm, error) {
+	type alarm struct {
+		AlarmName             string    `json:"AlarmName"`
+		StateValue            string    `json:"StateValue"`
+		StateReason           string    `json:"StateReason"`
+		StateUpdatedTimestamp time.Time `json:"StateUpdatedTimestamp"`
+	}
+	var alarms []cloudAlarm
+	for _, region := range a.eachRegion() {
+		var out struct {
+			MetricAlarms    []alarm `json:"MetricAlarms"`
+			CompositeAlarms []alarm `json:"CompositeAlarms"`
+		}
+		if err := runAWS(ctx, region, &out, "cloudwatch", "describe-alarms", "--alarm-types", "MetricAlarm", "CompositeAlarm"); err != nil {
+			return nil, err
+		}
+		for _, al := range append(out.MetricAlarms, out.CompositeAlarms...) {
+			name := al.AlarmName
+			if len(a.regions) > 1 {
+				name += " (" + region + ")"
+			}
+			alarms = append(alarms, cloudAlarm{Name: name, State: al.StateValue, Reason: al.StateReason, Since: al.StateUpdatedTimestamp})
+		}
+	}
+	return alarms, nil
+}
+
+func (a *awsCloud) setRunning(ctx context.Context, id, zone string, running bool) error {
+	if !awsInstanceID.MatchString(id) {
+		return fmt.Errorf("%q isn't an EC2 instance ID", id)
+	}
+	if !awsRegion.MatchString(zone) {
+		return fmt.Errorf("%q isn't an AWS region", zone)
+	}
+	action := "stop-instances"
+	if running {
+		action = "start-instances"
+	}
+	var out map[string]any
+	return runAWS(ctx, zone, &out, "ec2", action, "--instance-ids", id)
+}
+
+// runAWS runs an aws CLI command in region, or the default one, and
+// decodes its JSON output into v.
+func runAWS(ctx context.Context, region string, v any, args ...string) error {
+	args = append(args, "--output", "json", "--no-cli-pager")
+	if region != "" {
+		args = append(args, "--region", region)
+	}
+	log.Printf("%s aws %s", cloudLogPrefix, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, "aws", args...)
+	isolate(ctx, cmd, "cloud")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("aws %s %s: %s", args[0], args[1], truncateText(msg, 300))
+		}
+		return fmt.Errorf("aws %s %s: %w", args[0], args[1], err)
+	}
+	if stdout.Len() == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("parsing aws %s output: %w", args[1], err)
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package tools
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcpReadScope    = "https://www.googleapis.com/auth/cloud-platform.read-only"
+	gcpComputeScope = "https://www.googleapis.com/auth/compute" // Starting and stopping instances
+)
+
+var (
+	billingTable = regexp.MustCompile(`^[a-z][a-z0-9-]*\.\w+\.\w+$`)
+	gcpName      = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// gcpCloud queries a Google Cloud project over the REST APIs, with the
+// host's application default credentials: GOOGLE_APPLICATION_CREDENTIALS,
+// gcloud auth application-default login, or the metadata server.
+type gcpCloud struct {
+	project      string // Empty uses the credentials' project
+	billingTable string
+	writes       bool // Ask for the scope to start and stop instances
+
+	computeURL    string
+	storageURL    string
+	monitoringURL string
+	bigqueryURL   string
+
+	mu     sync.Mutex
+	client *http.Client // Authorized on first use
+}
+
+func newGCPCloud(project, billingTable string) *gcpCloud {
+	return &gcpCloud{
+		project:       project,
+		billingTable:  billingTable,
+		computeURL:    "https://compute.googleapis.com/compute/v1",
+		storageURL:    "https://storage.googleapis.com/storage/v1",
+		monitoringURL: "https://monitoring.googleapis.com/v3",
+		bigqueryURL:   "https://bigquery.googleapis.com/bigquery/v2",
+	}
+}
+
+func (g *gcpCloud) name() string {
+	return "gcp"
+}
+
+// connect finds the host's credentials, and the project if none is set.
+func (g *gcpCloud) connect(ctx context.Context) (*http.Client, string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client != nil {
+		return g.client, g.project, nil
+	}
+	scopes := []string{gcpReadScope}
+	if g.writes {
+		scopes = append(scopes, gcpComputeScope)
+	}
+	creds, err := google.FindDefaultCredentials(context.WithoutCancel(ctx), scopes...)
+	if err != nil {
+		return nil, "", fmt.Errorf("finding Google Cloud credentials (run gcloud auth application-default login on the host or set GOOGLE_APPLICATION_CREDENTIALS): %w", err)
+	}
+	if g.project == "" {
+		if g.project = creds.ProjectID; g.project == "" {
+			return nil, "", fmt.Errorf("the credentials don't name a project; set GCP_PROJECT")
+		}
+	}
+	client, err := google.DefaultClient(context.WithoutCancel(ctx), scopes...)
+	if err != nil {
+		return nil, "", fmt.Errorf("authorizing with Google Cloud: %w", err)
+	}
+	client.Timeout = cloudTimeout
+	g.client = client
+	return g.client, g.project, nil
+}
+
+// call sends a request to a Google API and decodes the JSON response into
+// v. Errors carry the API's message.
+func (g *gcpCloud) call(ctx context.Context, method, u string, body, v any) error {
+	client, _, err := g.connect(ctx)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("status %d: %s", resp.StatusCode, truncateText(string(data), 200))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	return nil
+}
+
+func (g *gcpCloud) instances(ctx context.Context) ([]cloudInstance, error) {
+	_, project, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var instances []cloudInstance
+	page := ""
+	for {
+		q := url.Values{"filter": {`status = "RUNNING"`}, "returnPartialSuccess": {"true"}}
+		if page != "" {
+			q.Set("pageToken", page)
+		}
+		var out struct {
+			Items map[string]struct {
+				Instances []struct {
+					ID                 string    `json:"id"`
+					Name               string    `json:"name"`
+					MachineType        string    `json:"machineType"`
+					Zone               string    `json:"zone"`
+					Status             string    `json:"status"`
+					LastStartTimestamp time.Time `json:"lastStartTimestamp"`
+					NetworkInterfaces  []struct {
+						NetworkIP     string `json:"networkIP"`
+						AccessConfigs []struct {
+							NatIP string `json:"natIP"`
+						} `json:"accessConfigs"`
+					} `json:"networkInterfaces"`
+				} `json:"instances"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		u := fmt.Sprintf("%s/projects/%s/aggregated/instances?%s", g.computeURL, url.PathEscape(project), q.Encode())
+		if err := g.call(ctx, http.MethodGet, u, nil, &out); err != nil {
+			return nil, fmt.Errorf("listing instances: %w", err)
+		}
+		for _, scope := range out.Items {
+			for _, in := range scope.Instances {
+				instance := cloudInstance{
+					ID:       in.ID,
+					Name:     in.Name,
+					Type:     lastSegment(in.MachineType),
+					Zone:     lastSegment(in.Zone),
+					State:    in.Status,
+					Launched: in.LastStartTimestamp,
+				}
+				for _, nic := range in.NetworkInterfaces {
+					instance.PrivateIP = cmp.Or(instance.PrivateIP, nic.NetworkIP)
+					for _, ac := range nic.AccessConfigs {
+						instance.PublicIP = cmp.Or(instance.PublicIP, ac.NatIP)
+					}
+				}
+				instances = append(instances, instance)
+			}
+		}
+		if page = out.NextPageToken; page == "" {
+			return instances, nil
+		}
+	}
+}
+
+// buckets lists the project's buckets with their size from Cloud
+// Monitoring's daily storage/total_bytes metric.
+func (g *gcpCloud) buckets(ctx context.Context) ([]cloudBucket, error) {
+	_, project, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Items []struct {
+			Name        string    `json:"name"`
+			Location    string    `json:"location"`
+			TimeCreated time.Time `json:"timeCreated"`
+		} `json:"items"`
+	}
+	u := fmt.Sprintf("%s/b?project=%s&maxResults=1000", g.storageURL, url.QueryEscape(project))
+	if err := g.call(ctx, http.MethodGet, u, nil, &out); err != nil {
+		return nil, fmt.Errorf("listing buckets: %w", err)
+	}
+	buckets := make([]cloudBucket, len(out.Items))
+	index := make(map[string]int, len(out.Items))
+	for i, b := range out.Items {
+		buckets[i] = cloudBucket{Name: b.Name, Region: strings.ToLower(b.Location), Size: -1, Created: b.TimeCreated}
+		index[b.Name] = i
+	}
+	if len(buckets) == 0 {
+		return buckets, nil
+	}
+
+	// Sizes are a best effort: buckets are still worth listing without them
+	now := time.Now().UTC()
+	q := url.Values{
+		"filter":                       {`metric.type = "storage.googleapis.com/storage/total_bytes"`},
+		"interval.startTime":           {now.Add(-72 * time.Hour).Format(time.RFC3339)},
+		"interval.endTime":             {now.Format(time.RFC3339)},
+		"aggregation.alignmentPeriod":  {"86400s"},
+		"aggregation.perSeriesAligner": {"ALIGN_MEAN"},
+	}
+	var series struct {
+		TimeSeries []struct {
+			Resource struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"resource"`
+			Points []struct {
+				Value struct {
+					DoubleValue float64 `json:"doubleValue"`
+				} `json:"value"`
+			} `json:"points"`
+		} `json:"timeSeries"`
+	}
+	u = fmt.Sprintf("%s/projects/%s/timeSeries?%s", g.monitoringURL, url.PathEscape(project), q.Encode())
+	if err := g.call(ctx, http.MethodGet, u, nil, &series); err != nil {
+		return buckets, nil
+	}
+	for _, ts := range series.TimeSeries {
+		i, ok := index[ts.Resource.Labels["bucket_name"]]
+		if !ok || len(ts.Points) == 0 {
+			continue
+		}
+		// One series per storage class; points are newest first
+		buckets[i].Size = max(buckets[i].Size, 0) + int64(ts.Points[0].Value.DoubleValue)
+	}
+	return buckets, nil
+}
+
+// costs queries the BigQuery billing export for this month's spend by
+// service, net of credits, and last month's total.
+func (g *gcpCloud) costs(ctx context.Context) (*cloudCosts, error) {
+	if g.billingTable == "" {
+		return nil, fmt.Errorf("spend needs the BigQuery billing export; set GCP_BILLING_TABLE")
+	}
+	if !billingTable.MatchString(g.billingTable) {
+		return nil, fmt.Errorf("GCP_BILLING_TABLE should be project.dataset.table, not %q", g.billingTable)
+	}
+	_, project, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	month := now.Format("200601")
+	previous := now.AddDate(0, 0, -now.Day()).Format("200601")
+	query := fmt.Sprintf("SELECT invoice.month, service.description, currency, "+
+		"SUM(cost) + SUM(IFNULL((SELECT SUM(c.amount) FROM UNNEST(credits) c), 0)) AS total "+
+		"FROM `%s` WHERE invoice.month IN ('%s', '%s') GROUP BY 1, 2, 3", g.billingTable, month, previous)
+	var out struct {
+		JobComplete bool `json:"jobComplete"`
+		Rows        []struct {
+			F []struct {
+				V any `json:"v"`
+			} `json:"f"`
+		} `json:"rows"`
+	}
+	body := map[string]any{"query": query, "useLegacySql": false, "timeoutMs": 30000}
+	u := fmt.Sprintf("%s/projects/%s/queries", g.bigqueryURL, url.PathEscape(project))
+	if err := g.call(ctx, http.MethodPost, u, body, &out); err != nil {
+		return nil, fmt.Errorf("querying the billing export: %w", err)
+	}
+	if !out.JobComplete {
+		return nil, fmt.Errorf("the billing query didn't finish in time")
+	}
+
+	costs := &cloudCosts{
+		Period:   fmt.Sprintf("%s 1–%d", now.Month(), now.Day()),
+		Services: make(map[string]float64),
+	}
+	for _, row := range out.Rows {
+		if len(row.F) < 4 {
+			continue
+		}
+		invoiceMonth, _ := row.F[0].V.(string)
+		service, _ := row.F[1].V.(string)
+		currency, _ := row.F[2].V.(string)
+		total, _ := row.F[3].V.(string)
+		amount, _ := strconv.ParseFloat(total, 64)
+		costs.Currency = currency
+		if invoiceMonth == month {
+			costs.Services[service] += amount
+		} else {
+			costs.Previous += amount
+		}
+	}
+	return costs, nil
+}
+
+// alarms lists the project's alert policies. The Monitoring API doesn't
+// say which are firing.
+func (g *gcpCloud) alarms(ctx context.Context) ([]cloudAlarm, error) {
+	_, project, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		AlertPolicies []struct {
+			DisplayName string `json:"displayName"`
+			Enabled     bool   `json:"enabled"`
+			Conditions  []struct {
+				DisplayName string `json:"displayName"`
+			} `json:"conditions"`
+		} `json:"alertPolicies"`
+	}
+	u := fmt.Sprintf("%s/projects/%s/alertPolicies?pageSize=200", g.monitoringURL, url.PathEscape(project))
+	if err := g.call(ctx, http.MethodGet, u, nil, &out); err != nil {
+		return nil, fmt.Errorf("listing alert policies: %w", err)
+	}
+	alarms := make([]cloudAlarm, len(out.AlertPolicies))
+	for i, p := range out.AlertPolicies {
+		state := "disabled"
+		if p.Enabled {
+			state = "enabled"
+		}
+		var conditions []string
+		for _, c := range p.Conditions {
+			conditions = append(conditions, c.DisplayName)
+		}
+		alarms[i] = cloudAlarm{Name: p.DisplayName, State: state, Reason: strings.Join(conditions, "; ")}
+	}
+	return alarms, nil
+}
+
+func (g *gcpCloud) setRunning(ctx context.Context, name, zone string, running bool) error {
+	if !gcpName.MatchString(name) {
+		return fmt.Errorf("%q isn't a Compute Engine instance name", name)
+	}
+	if !gcpName.MatchString(zone) {
+		return fmt.Errorf("%q isn't a Compute Engine zone", zone)
+	}
+	_, project, err := g.connect(ctx)
+	if err != nil {
+		return err
+	}
+	action := "stop"
+	if running {
+		action = "start"
+	}
+	u := fmt.Sprintf("%s/projects/%s/zones/%s/instances/%s/%s", g.computeURL, url.PathEscape(project), zone, name, action)
+	var op map[string]any
+	if err := g.call(ctx, http.MethodPost, u, nil, &op); err != nil {
+		return fmt.Errorf("%s %s: %w", action, name, err)
+	}
+	return nil
+}
+
+// lastSegment returns the last part of a resource URL, such as the machine
+// type in .../machineTypes/e2-small.
+func lastSegment(u string) string {
+	return u[strings.LastIndex(u, "/")+1:]
+}
@@ -0,0 +1,46 @@
+package tools
+
+import (
+	"context"
+	"testing"
+)
+
+// fakeCloud records the instances it was asked to start or stop.
+type fakeCloud struct {
+	changed []string
+}
+
+func (f *fakeCloud) name() string                                           { return "fake" }
+func (f *fakeCloud) instances(ctx context.Context) ([]cloudInstance, error) { return nil, nil }
+func (f *fakeCloud) buckets(ctx context.Context) ([]cloudBucket, error)     { return nil, nil }
+func (f *fakeCloud) costs(ctx context.Context) (*cloudCosts, error)         { return nil, nil }
+func (f *fakeCloud) alarms(ctx context.Context) ([]cloudAlarm, error)       { return nil, nil }
+
+func (f *fakeCloud) setRunning(ctx context.Context, id, zone string, running bool) error {
+	f.changed = append(f.changed, id)
+	return nil
+}
+
+func TestCloudStartNeedsFormApproval(t *testing.T) {
+	cloud := &fakeCloud{}
+	tool := &CloudTool{providers: []cloudProvider{cloud}, writes: true}
+	args := map[string]any{"operation": "start", "id": "i-1", "zone": "eu-west-1", "approval": cloudApproval}
+
+	if _, err := tool.Execute(context.Background(), args); err == nil || len(cloud.changed) > 0 {
+		t.Fatal("instance started on the model's own approval")
+	}
+
+	form := FormFor(context.Background(), tool, args)
+	if form == nil {
+		t.Fatal("no approval form for a start")
+	}
+	if err := form.Answer(cloudApproval); err != nil {
+		t.Fatalf("Answer: %v", err)
+	}
+	if _, err := tool.Execute(form.Context(context.Background()), form.Args); err != nil {
+		t.Fatalf("approved start: %v", err)
+	}
+	if len(cloud.changed) != 1 || cloud.changed[0] != "i-1" {
+		t.Errorf("started %v, want [i-1]", cloud.changed)
+	}
+}
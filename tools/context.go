@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"context"
+	"slices"
+)
+
+type chatKey struct{}
+
+// WithChat returns a context that records the chat a tool call serves, so
+// tools can keep per-chat state such as shell sessions.
+func WithChat(ctx context.Context, chatID int64) context.Context {
+	return context.WithValue(ctx, chatKey{}, chatID)
+}
+
+// ChatFrom returns the chat recorded by WithChat.
+func ChatFrom(ctx context.Context) (int64, bool) {
+	chatID, ok := ctx.Value(chatKey{}).(int64)
+	return chatID, ok
+}
+
+type requestKey struct{}
+
+// WithRequest returns a context that records the user message a tool call
+// serves, for labeling work done on its behalf.
+func WithRequest(ctx context.Context, text string) context.Context {
+	return context.WithValue(ctx, requestKey{}, text)
+}
+
+// RequestFrom returns the message recorded by WithRequest.
+func RequestFrom(ctx context.Context) string {
+	text, _ := ctx.Value(requestKey{}).(string)
+	return text
+}
+
+// Location is a point on the map, in degrees.
+type Location struct {
+	Lat float64
+	Lon float64
+}
+
+type locationKey struct{}
+
+// WithLocation returns a context that records where the user is, from a
+// location they shared, for tools that search nearby.
+func WithLocation(ctx context.Context, loc Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, loc)
+}
+
+// LocationFrom returns the location recorded by WithLocation.
+func LocationFrom(ctx context.Context) (Location, bool) {
+	loc, ok := ctx.Value(locationKey{}).(Location)
+	return loc, ok
+}
+
+type toolsetKey struct{}
+
+// WithToolset returns a context that offers only the named tools for a
+// request, for focused modes such as coding sessions.
+func WithToolset(ctx context.Context, names ...string) context.Context {
+	return context.WithValue(ctx, toolsetKey{}, names)
+}
+
+// inToolset reports whether the context's toolset, if it has one, includes
+// the named tool.
+func inToolset(ctx context.Context, name string) bool {
+	names, ok := ctx.Value(toolsetKey{}).([]string)
+	return !ok || slices.Contains(names, name)
+}
+
+type projectKey struct{}
+
+// WithProject returns a context that records the project directory,
+// relative to the workspace, that a coding session works in. Commands run
+// there unless they say otherwise.
+func WithProject(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, projectKey{}, dir)
+}
+
+// ProjectFrom returns the directory recorded by WithProject, or "".
+func ProjectFrom(ctx context.Context) string {
+	dir, _ := ctx.Value(projectKey{}).(string)
+	return dir
+}
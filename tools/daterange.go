@@ -0,0 +1,197 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateRange is a half-open interval [Start, End).
+type dateRange struct {
+	Start, End time.Time
+}
+
+var (
+	rangeSeparator = regexp.MustCompile(`\s+(?:to|until|through|thru|and|-|–)\s+`)
+	relativeSpan   = regexp.MustCompile(`^(next|last|past|previous)\s+(\d+)\s+(day|week|month|year)s?$`)
+)
+
+// parseDateRange resolves a phrase such as "tomorrow", "next week", "last 3
+// days", "last 6 months", "2026-03-02", or "last Monday to Friday" to a
+// range of whole days in now's location. In "X to Y", a bare weekday Y means
+// the first such day on or after X.
+func parseDateRange(phrase string, now time.Time) (dateRange, error) {
+	phrase = strings.ToLower(strings.TrimSpace(phrase))
+	phrase = strings.TrimPrefix(phrase, "from ")
+	phrase = strings.TrimPrefix(phrase, "between ")
+	if phrase == "" {
+		return dateRange{}, fmt.Errorf("empty date range")
+	}
+
+	if parts := rangeSeparator.Split(phrase, 2); len(parts) == 2 {
+		from, err := parseDatePhrase(parts[0], now)
+		if err != nil {
+			return dateRange{}, err
+		}
+		var to dateRange
+		if day, ok := weekday(parts[1]); ok {
+			to = oneDay(onOrAfter(from.Start, day))
+		} else if to, err = parseDatePhrase(parts[1], now); err != nil {
+			return dateRange{}, err
+		}
+		if !to.End.After(from.Start) {
+			return dateRange{}, fmt.Errorf("%q ends before it starts", phrase)
+		}
+		return dateRange{Start: from.Start, End: to.End}, nil
+	}
+	return parseDatePhrase(phrase, now)
+}
+
+// parseDatePhrase resolves a single day, week, month, or relative span.
+func parseDatePhrase(phrase string, now time.Time) (dateRange, error) {
+	phrase = strings.TrimPrefix(strings.TrimSpace(phrase), "on ")
+	today := startOfDay(now)
+
+	switch phrase {
+	case "today":
+		return oneDay(today), nil
+	case "tomorrow":
+		return oneDay(today.AddDate(0, 0, 1)), nil
+	case "yesterday":
+		return oneDay(today.AddDate(0, 0, -1)), nil
+	case "this week":
+		return week(today, 0), nil
+	case "next week":
+		return week(today, 1), nil
+	case "last week", "previous week":
+		return week(today, -1), nil
+	case "weekend", "this weekend":
+		return weekend(today, 0), nil
+	case "next weekend":
+		return weekend(today, 1), nil
+	case "last weekend":
+		return weekend(today, -1), nil
+	case "this month":
+		return month(today, 0), nil
+	case "next month":
+		return month(today, 1), nil
+	case "last month", "previous month":
+		return month(today, -1), nil
+	case "this year":
+		first := time.Date(today.Year(), 1, 1, 0, 0, 0, 0, today.Location())
+		return dateRange{Start: first, End: first.AddDate(1, 0, 0)}, nil
+	case "last year", "previous year":
+		first := time.Date(today.Year()-1, 1, 1, 0, 0, 0, 0, today.Location())
+		return dateRange{Start: first, End: first.AddDate(1, 0, 0)}, nil
+	}
+
+	if m := relativeSpan.FindStringSubmatch(phrase); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		years, months, days := 0, 0, 0
+		switch m[3] {
+		case "day":
+			days = n
+		case "week":
+			days = 7 * n
+		case "month":
+			months = n
+		case "year":
+			years = n
+		}
+		if m[1] == "next" {
+			return dateRange{Start: now, End: today.AddDate(years, months, days+1)}, nil
+		}
+		return dateRange{Start: today.AddDate(-years, -months, -days), End: now}, nil
+	}
+
+	// Weekdays: "friday" and "this friday" are the next one (or today),
+	// "next friday" the one in the following week, "last friday" the most recent past one
+	modifier, name, found := strings.Cut(phrase, " ")
+	if !found {
+		modifier, name = "", phrase
+	}
+	if day, ok := weekday(name); ok {
+		switch modifier {
+		case "", "this":
+			return oneDay(onOrAfter(today, day)), nil
+		case "next":
+			return oneDay(week(today, 1).Start.AddDate(0, 0, (int(day)+6)%7)), nil
+		case "last", "previous":
+			return oneDay(onOrAfter(today.AddDate(0, 0, -7), day)), nil
+		}
+	}
+
+	for _, layout := range []string{"2006-01-02", "Jan 2 2006", "January 2 2006", "2 Jan 2006", "2 January 2006"} {
+		if t, err := time.ParseInLocation(layout, strings.ReplaceAll(phrase, ",", ""), now.Location()); err == nil {
+			return oneDay(t), nil
+		}
+	}
+	for _, layout := range []string{"Jan 2", "January 2", "2 Jan", "2 January"} {
+		if t, err := time.ParseInLocation(layout, phrase, now.Location()); err == nil {
+			return oneDay(time.Date(now.Year(), t.Month(), t.Day(), 0, 0, 0, 0, now.Location())), nil
+		}
+	}
+
+	return dateRange{}, fmt.Errorf("unrecognized date %q (try today, tomorrow, next week, last 7 days, friday, 2026-03-02, or \"monday to friday\")", phrase)
+}
+
+// parseTimeBound accepts RFC3339 or a bare date. A bare date used as an
+// end bound includes that whole day.
+func parseTimeBound(s string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (use YYYY-MM-DD or RFC3339)", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// weekday parses a weekday name or an abbreviation of at least three letters.
+func weekday(name string) (time.Weekday, bool) {
+	if len(name) < 3 {
+		return 0, false
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.HasPrefix(strings.ToLower(day.String()), name) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func oneDay(day time.Time) dateRange {
+	return dateRange{Start: day, End: day.AddDate(0, 0, 1)}
+}
+
+// onOrAfter returns the first day on or after day that falls on the weekday.
+func onOrAfter(day time.Time, weekday time.Weekday) time.Time {
+	return day.AddDate(0, 0, (int(weekday)-int(day.Weekday())+7)%7)
+}
+
+// week returns the Monday-to-Sunday week offset weeks from the one containing today.
+func week(today time.Time, offset int) dateRange {
+	monday := today.AddDate(0, 0, -((int(today.Weekday())+6)%7)+7*offset)
+	return dateRange{Start: monday, End: monday.AddDate(0, 0, 7)}
+}
+
+func weekend(today time.Time, offset int) dateRange {
+	saturday := week(today, offset).Start.AddDate(0, 0, 5)
+	return dateRange{Start: saturday, End: saturday.AddDate(0, 0, 2)}
+}
+
+func month(today time.Time, offset int) dateRange {
+	first := time.Date(today.Year(), today.Month()+time.Month(offset), 1, 0, 0, 0, 0, today.Location())
+	return dateRange{Start: first, End: first.AddDate(0, 1, 0)}
+}
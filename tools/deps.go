@@ -0,0 +1,522 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/tenant"
+)
+
+const (
+	depsTimeout       = 20 * time.Second
+	maxDepsChecked    = 150 // Dependencies looked up upstream per run
+	depsWorkers       = 8
+	maxVulnDetails    = 30 // Advisories fetched for their summaries
+	maxOutdatedListed = 25
+)
+
+var (
+	requireLine     = regexp.MustCompile(`^([\w.~/-]+)\s+(v\S+)(\s*//\s*indirect)?`)
+	requirementLine = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(?:\[[^\]]*\])?\s*(==|>=|~=|<=|>|<|!=)?\s*([\w.*+!-]+)?`)
+	versionRange    = regexp.MustCompile(`^[\^~>=<v ]*(\d[\w.+-]*)`)
+)
+
+// dependency is one package a project declares.
+type dependency struct {
+	Name      string
+	Version   string // As declared, or the lower bound of a range
+	Ecosystem string // OSV's name: Go, PyPI, or npm
+	File      string
+	Exact     bool // Pinned to Version, not a range
+	Indirect  bool
+	Dev       bool
+
+	Latest string
+	Vulns  []advisory
+}
+
+// advisory is a known vulnerability affecting a dependency's version.
+type advisory struct {
+	ID       string
+	Aliases  []string // CVE IDs and the like
+	Summary  string
+	Fixed    string // The first version with the fix, if known
+	Severity string
+}
+
+// DepsTool checks a workspace project's dependencies for newer versions and
+// known vulnerabilities, from go.mod, requirements.txt, and package.json,
+// and generates SBOMs with syft to report on licenses.
+type DepsTool struct {
+	workspaceDir    string
+	allowedLicenses []string // SPDX IDs; nil uses defaultAllowedLicenses
+	goProxyURL      string
+	pypiURL         string
+	npmURL          string
+	osvURL          string
+	httpClient      *http.Client
+}
+
+// DepsOption customizes a DepsTool.
+type DepsOption func(*DepsTool)
+
+// WithAllowedLicenses sets the licenses an SBOM's packages may have, as
+// SPDX IDs.
+func WithAllowedLicenses(licenses []string) DepsOption {
+	return func(d *DepsTool) {
+		d.allowedLicenses = licenses
+	}
+}
+
+// NewDepsTool creates a dependency checker for projects in workspaceDir.
+func NewDepsTool(workspaceDir string, opts ...DepsOption) *DepsTool {
+	d := &DepsTool{
+		workspaceDir: workspaceDir,
+		goProxyURL:   "https://proxy.golang.org",
+		pypiURL:      "https://pypi.org",
+		npmURL:       "https://registry.npmjs.org",
+		osvURL:       "https://api.osv.dev",
+		httpClient:   &http.Client{Timeout: depsTimeout},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func (d *DepsTool) Name() string {
+	return "deps"
+}
+
+func (d *DepsTool) Description() string {
+	return `Check a project's dependencies for newer versions and known vulnerabilities (CVEs, from OSV),
+or report the licenses in its SBOM.
+
+operation=check (default) reads go.mod, requirements.txt, and package.json in path, a project directory
+in the workspace such as repos/api (default: the current project, or the workspace root). It lists
+vulnerable dependencies first, with the advisory and the version that fixes it, then outdated ones,
+marking major upgrades. indirect=true also checks Go's indirect and package.json's dev dependencies.
+operation=sbom generates an SBOM with syft for path, or for a container image from its registry, and
+reports how many packages have each license and which break the allowed-license policy. The SBOM is
+sent as a CycloneDX JSON document.`
+}
+
+func (d *DepsTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"check", "sbom"},
+				"description": "What to do (default check)",
+			},
+			"path": map[string]any{
+				"type":        "string",
+				"description": "The project directory in the workspace (default: the current project)",
+			},
+			"image": map[string]any{
+				"type":        "string",
+				"description": "For sbom: a container image instead of a project, e.g. docker.io/library/nginx:1.27",
+			},
+			"indirect": map[string]any{
+				"type":        "boolean",
+				"description": "Also check indirect and dev dependencies (default false)",
+			},
+		},
+	}
+}
+
+func (d *DepsTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+func (d *DepsTool) Requirements() []Requirement {
+	return []Requirement{
+		{Name: "syft", Command: "syft", Operations: []string{"sbom"}},
+	}
+}
+
+func (d *DepsTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	result, err := d.ExecuteRich(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+// ExecuteRich runs the operation, attaching the SBOM for sbom.
+func (d *DepsTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "sbom":
+		return d.sbom(ctx, args)
+	case "", "check":
+		text, err := d.check(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Text: text}, nil
+	}
+	return nil, fmt.Errorf("unknown operation: %s", operation)
+}
+
+// check reports a project's outdated and vulnerable dependencies.
+func (d *DepsTool) check(ctx context.Context, args map[string]any) (string, error) {
+	dir, workspace, err := d.projectDir(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	indirect, _ := args["indirect"].(bool)
+
+	deps, files, err := readDependencies(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no go.mod, requirements.txt, or package.json in %s", displayPath(workspace, dir))
+	}
+	if !indirect {
+		deps = slices.DeleteFunc(deps, func(dep *dependency) bool { return dep.Indirect || dep.Dev })
+	}
+	skipped := 0
+	if len(deps) > maxDepsChecked {
+		skipped = len(deps) - maxDepsChecked
+		deps = deps[:maxDepsChecked]
+	}
+
+	d.lookUpLatest(ctx, deps)
+	vulnErr := d.lookUpVulns(ctx, deps)
+	return depsReport(displayPath(workspace, dir), files, deps, skipped, vulnErr), nil
+}
+
+// projectDir resolves the path argument, or the current project, in the
+// user's workspace, and returns it with the workspace.
+func (d *DepsTool) projectDir(ctx context.Context, args map[string]any) (dir, workspace string, err error) {
+	workspace = tenant.Workspace(ctx, d.workspaceDir)
+	path, _ := args["path"].(string)
+	if path = strings.TrimSpace(path); path == "" {
+		path = ProjectFrom(ctx)
+	}
+	dir, err = safePath(workspace, path)
+	return dir, workspace, err
+}
+
+// displayPath names a directory for the reply, relative to the workspace.
+func displayPath(workspace, dir string) string {
+	rel, err := filepath.Rel(workspace, dir)
+	if err != nil || rel == "." {
+		return "the workspace root"
+	}
+	return filepath.ToSlash(rel) + "/"
+}
+
+// readDependencies reads the manifests the tool knows in dir.
+func readDependencies(dir string) ([]*dependency, []string, error) {
+	readers := []struct {
+		file string
+		read func([]byte) ([]*dependency, error)
+	}{
+		{"go.mod", parseGoMod},
+		{"requirements.txt", parseRequirements},
+		{"package.json", parsePackageJSON},
+	}
+	var deps []*dependency
+	var files []string
+	for _, r := range readers {
+		data, err := os.ReadFile(filepath.Join(dir, r.file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", r.file, err)
+		}
+		found, err := r.read(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %s: %w", r.file, err)
+		}
+		for _, dep := range found {
+			dep.File = r.file
+		}
+		deps = append(deps, found...)
+		files = append(files, r.file)
+	}
+	return deps, files, nil
+}
+
+func parseGoMod(data []byte) ([]*dependency, error) {
+	var deps []*dependency
+	inBlock := false
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "require (":
+			inBlock = true
+			continue
+		case inBlock && line == ")":
+			inBlock = false
+			continue
+		case strings.HasPrefix(line, "require "):
+			line = strings.TrimSpace(strings.TrimPrefix(line, "require "))
+		case !inBlock:
+			continue
+		}
+		if m := requireLine.FindStringSubmatch(line); m != nil {
+			deps = append(deps, &dependency{Name: m[1], Version: m[2], Ecosystem: "Go", Exact: true, Indirect: m[3] != ""})
+		}
+	}
+	return deps, scanner.Err()
+}
+
+func parseRequirements(data []byte) ([]*dependency, error) {
+	var deps []*dependency
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line, _, _ = strings.Cut(line, ";") // Environment markers
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-") || strings.Contains(line, "://") {
+			continue // Options, includes, and URLs
+		}
+		m := requirementLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		dep := &dependency{Name: strings.ToLower(m[1]), Ecosystem: "PyPI"}
+		if m[2] == "==" || m[2] == ">=" || m[2] == "~=" {
+			dep.Version = strings.TrimSuffix(m[3], ".*")
+			dep.Exact = m[2] == "==" && !strings.Contains(m[3], "*")
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+func parsePackageJSON(data []byte) ([]*dependency, error) {
+	var pkg struct {
+		Dependencies    map[string]string `json:"dependencies"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+	var deps []*dependency
+	add := func(list map[string]string, dev bool) {
+		names := make([]string, 0, len(list))
+		for name := range list {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			spec := strings.TrimSpace(list[name])
+			dep := &dependency{Name: name, Ecosystem: "npm", Dev: dev}
+			if m := versionRange.FindStringSubmatch(spec); m != nil && !strings.ContainsAny(spec, "|:/") {
+				dep.Version = m[1]
+				dep.Exact = strings.TrimLeft(spec, "=v ") == m[1]
+			}
+			deps = append(deps, dep)
+		}
+	}
+	add(pkg.Dependencies, false)
+	add(pkg.DevDependencies, true)
+	return deps, nil
+}
+
+// lookUpLatest fills in each dependency's latest release, a few at a time.
+// Packages that can't be looked up are left without one.
+func (d *DepsTool) lookUpLatest(ctx context.Context, deps []*dependency) {
+	work := make(chan *dependency)
+	var wg sync.WaitGroup
+	for range min(depsWorkers, len(deps)) {
+		wg.Go(func() {
+			for dep := range work {
+				dep.Latest, _ = d.latest(ctx, dep)
+			}
+		})
+	}
+	for _, dep := range deps {
+		work <- dep
+	}
+	close(work)
+	wg.Wait()
+}
+
+// depsReport summarizes the check: vulnerable dependencies, then outdated
+// ones, then how many are current.
+func depsReport(where string, files []string, deps []*dependency, skipped int, vulnErr error) string {
+	var vulnerable, outdated []*dependency
+	current, unknown := 0, 0
+	for _, dep := range deps {
+		isOutdated := dep.Latest != "" && dep.Version != "" && compareVersions(dep.Version, dep.Latest) < 0
+		switch {
+		case len(dep.Vulns) > 0:
+			vulnerable = append(vulnerable, dep)
+		case isOutdated:
+			outdated = append(outdated, dep)
+		case dep.Latest == "" || dep.Version == "":
+			unknown++
+		default:
+			current++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📦 %s: %d dependencies in %s\n", where, len(deps), strings.Join(files, ", "))
+	if len(vulnerable) > 0 {
+		fmt.Fprintf(&sb, "\n⚠️ Known vulnerabilities (%d packages):\n", len(vulnerable))
+		for _, dep := range vulnerable {
+			fmt.Fprintf(&sb, "• %s %s (%s)", dep.Name, versionLabel(dep), dep.File)
+			if dep.Latest != "" && compareVersions(dep.Version, dep.Latest) < 0 {
+				fmt.Fprintf(&sb, ", latest %s", dep.Latest)
+			}
+			sb.WriteString("\n")
+			for _, v := range dep.Vulns {
+				id := v.ID
+				if cves := slices.DeleteFunc(slices.Clone(v.Aliases), func(a string) bool { return !strings.HasPrefix(a, "CVE-") }); len(cves) > 0 {
+					id += " / " + strings.Join(cves, ", ")
+				}
+				fmt.Fprintf(&sb, "  - %s", id)
+				if v.Severity != "" {
+					fmt.Fprintf(&sb, " [%s]", v.Severity)
+				}
+				if v.Summary != "" {
+					fmt.Fprintf(&sb, ": %s", v.Summary)
+				}
+				if v.Fixed != "" {
+					fmt.Fprintf(&sb, " (fixed in %s)", v.Fixed)
+				}
+				sb.WriteString("\n")
+			}
+		}
+	}
+	if slices.ContainsFunc(vulnerable, func(dep *dependency) bool { return !dep.Exact }) {
+		sb.WriteString("Versions marked + are ranges, checked at their lowest version; a lock file may already use a fixed one.\n")
+	}
+	if len(outdated) > 0 {
+		// Major upgrades first, as they need the most care
+		slices.SortStableFunc(outdated, func(a, b *dependency) int {
+			return boolOrder(isMajorUpgrade(b)) - boolOrder(isMajorUpgrade(a))
+		})
+		fmt.Fprintf(&sb, "\n⬆️ Outdated (%d):\n", len(outdated))
+		for i, dep := range outdated {
+			if i == maxOutdatedListed {
+				fmt.Fprintf(&sb, "… and %d more\n", len(outdated)-i)
+				break
+			}
+			fmt.Fprintf(&sb, "• %s %s → %s", dep.Name, versionLabel(dep), dep.Latest)
+			if isMajorUpgrade(dep) {
+				sb.WriteString(" (major: check for breaking changes)")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(&sb, "\n✅ %d up to date", current)
+	if unknown > 0 {
+		fmt.Fprintf(&sb, "; %d couldn't be looked up or have no version", unknown)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(&sb, "; %d more weren't checked", skipped)
+	}
+	if vulnErr != nil {
+		fmt.Fprintf(&sb, "\n\n⚠️ The vulnerability check failed (%v), so none are listed.", vulnErr)
+	}
+	return sb.String()
+}
+
+func versionLabel(dep *dependency) string {
+	switch {
+	case dep.Version == "":
+		return "(any version)"
+	case dep.Exact:
+		return dep.Version
+	default:
+		return dep.Version + "+"
+	}
+}
+
+func boolOrder(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// isMajorUpgrade reports whether the latest release changes the major
+// version, or the minor one below 1.0.
+func isMajorUpgrade(dep *dependency) bool {
+	from, to := versionParts(dep.Version), versionParts(dep.Latest)
+	if len(from) == 0 || len(to) == 0 {
+		return false
+	}
+	if from[0] == 0 && to[0] == 0 && len(from) > 1 && len(to) > 1 {
+		return from[1] != to[1]
+	}
+	return from[0] != to[0]
+}
+
+// versionParts returns a version's leading numbers: v1.22.3-rc1 is 1 22 3.
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	var parts []int
+	for _, field := range strings.Split(v, ".") {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(field[:end])
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+		if end < len(field) {
+			break
+		}
+	}
+	return parts
+}
+
+// compareVersions orders two versions by their numbers; with equal numbers
+// a prerelease comes before the release.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := range max(len(pa), len(pb)) {
+		x, y := 0, 0
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	preA, preB := isPrerelease(a), isPrerelease(b)
+	switch {
+	case preA && !preB:
+		return -1
+	case preB && !preA:
+		return 1
+	}
+	return 0
+}
+
+var prerelease = regexp.MustCompile(`(?i)\d[-.]?(a|b|rc|alpha|beta|pre|dev)\.?\d*`)
+
+func isPrerelease(v string) bool {
+	v, _, _ = strings.Cut(v, "+") // Build metadata, and Go's +incompatible
+	return strings.Contains(v, "-") || prerelease.MatchString(v)
+}
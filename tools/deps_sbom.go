@@ -0,0 +1,348 @@
+package tools
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	depsLogPrefix       = "[deps]"
+	sbomTimeout         = 5 * time.Minute
+	maxLicensesListed   = 15
+	maxViolationsListed = 25
+	maxUnlicensedListed = 15
+)
+
+// defaultAllowedLicenses are permissive licenses, allowed when no list is
+// configured.
+var defaultAllowedLicenses = []string{
+	"MIT", "Apache-2.0", "BSD-2-Clause", "BSD-3-Clause", "ISC", "0BSD", "Unlicense",
+	"Zlib", "MPL-2.0", "Python-2.0", "PSF-2.0", "CC0-1.0", "BSL-1.0",
+}
+
+// licenseAliases map license names found in package metadata instead of
+// SPDX IDs to the IDs, lowercased.
+var licenseAliases = map[string]string{
+	"mit license":                 "mit",
+	"the mit license":             "mit",
+	"apache 2.0":                  "apache-2.0",
+	"apache-2":                    "apache-2.0",
+	"apache license 2.0":          "apache-2.0",
+	"apache license, version 2.0": "apache-2.0",
+	"apache software license":     "apache-2.0",
+	"the apache software license, version 2.0": "apache-2.0",
+	"bsd 3-clause":                       "bsd-3-clause",
+	"new bsd license":                    "bsd-3-clause",
+	"bsd 2-clause":                       "bsd-2-clause",
+	"simplified bsd license":             "bsd-2-clause",
+	"isc license":                        "isc",
+	"mozilla public license 2.0":         "mpl-2.0",
+	"python software foundation license": "psf-2.0",
+	"public domain":                      "unlicense",
+}
+
+// cycloneDX is the part of a CycloneDX SBOM the license report reads.
+type cycloneDX struct {
+	Components []struct {
+		Type     string `json:"type"`
+		Name     string `json:"name"`
+		Version  string `json:"version"`
+		PURL     string `json:"purl"`
+		Licenses []struct {
+			License struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"license"`
+			Expression string `json:"expression"`
+		} `json:"licenses"`
+	} `json:"components"`
+}
+
+// sbomPackage is one package in an SBOM, with its licenses as SPDX
+// expressions or names.
+type sbomPackage struct {
+	Name     string
+	Version  string
+	Type     string // The purl type, such as golang or npm
+	Licenses []string
+}
+
+// sbom generates an SBOM for a workspace project or container image with
+// syft and reports its licenses against the allowed list.
+func (d *DepsTool) sbom(ctx context.Context, args map[string]any) (*Result, error) {
+	image, _ := args["image"].(string)
+	image = strings.TrimSpace(image)
+	var source, subject, name string
+	if image != "" {
+		if strings.HasPrefix(image, "-") || strings.ContainsAny(image, " \t\n") {
+			return nil, fmt.Errorf("invalid image reference: %s", image)
+		}
+		// registry: pulls from the registry without a container daemon, and
+		// keeps the reference from naming another kind of source.
+		source, subject = "registry:"+image, image
+		name = repoName(repository(image))
+	} else {
+		dir, workspace, err := d.projectDir(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("no such project directory: %s", displayPath(workspace, dir))
+		}
+		source, subject = "dir:"+dir, displayPath(workspace, dir)
+		name = cmp.Or(filepath.Base(dir), "workspace")
+	}
+
+	data, err := runSyft(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	var doc cycloneDX
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing SBOM: %w", err)
+	}
+	packages := make([]sbomPackage, 0, len(doc.Components))
+	for _, c := range doc.Components {
+		if c.Type == "operating-system" || c.Type == "file" {
+			continue
+		}
+		p := sbomPackage{Name: c.Name, Version: c.Version, Type: purlType(c.PURL, c.Type)}
+		for _, l := range c.Licenses {
+			if license := cmp.Or(l.Expression, l.License.ID, l.License.Name); license != "" {
+				p.Licenses = append(p.Licenses, license)
+			}
+		}
+		packages = append(packages, p)
+	}
+
+	allowed := d.allowedLicenses
+	if len(allowed) == 0 {
+		allowed = defaultAllowedLicenses
+	}
+	return &Result{
+		Text:        licenseReport(subject, packages, allowed),
+		Attachments: []Attachment{{Name: name + "-sbom.cdx.json", Data: data, Kind: AttachDocument}},
+	}, nil
+}
+
+// runSyft scans source with syft and returns the SBOM as CycloneDX JSON.
+func runSyft(ctx context.Context, source string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, sbomTimeout)
+	defer cancel()
+
+	log.Printf("%s syft scan %s", depsLogPrefix, source)
+	cmd := exec.CommandContext(ctx, "syft", "scan", source, "--output", "cyclonedx-json", "--quiet")
+	isolate(ctx, cmd, "deps")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("syft timed out after %v", sbomTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("running syft: %w: %s", err, truncateText(msg, 500))
+		}
+		return nil, fmt.Errorf("running syft: %w", err)
+	}
+	log.Printf("%s syft OK (%v) %d bytes", depsLogPrefix, time.Since(start).Round(time.Millisecond), stdout.Len())
+	return stdout.Bytes(), nil
+}
+
+// purlType returns a package URL's type, such as npm for pkg:npm/left-pad,
+// or fallback when there is none.
+func purlType(purl, fallback string) string {
+	rest, ok := strings.CutPrefix(purl, "pkg:")
+	if !ok {
+		return fallback
+	}
+	kind, _, _ := strings.Cut(rest, "/")
+	return kind
+}
+
+// licenseReport lists an SBOM's package types and licenses and the
+// packages whose licenses aren't allowed or aren't known.
+func licenseReport(subject string, packages []sbomPackage, allowed []string) string {
+	if len(packages) == 0 {
+		return fmt.Sprintf("📦 syft found no packages in %s.", subject)
+	}
+	allow := make(map[string]bool, len(allowed))
+	for _, id := range allowed {
+		allow[normalizeLicense(id)] = true
+	}
+
+	types := map[string]int{}
+	licenses := map[string]int{}
+	var violations, unlicensed []sbomPackage
+	for _, p := range packages {
+		types[p.Type]++
+		if len(p.Licenses) == 0 {
+			unlicensed = append(unlicensed, p)
+			continue
+		}
+		licenses[strings.Join(p.Licenses, ", ")]++
+		// A package listing several licenses may need all of them.
+		if slices.ContainsFunc(p.Licenses, func(l string) bool { return !licenseAllowed(l, allow) }) {
+			violations = append(violations, p)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📦 SBOM for %s: %d packages (%s)\n", subject, len(packages), countList(types, 0))
+	if len(violations) == 0 {
+		sb.WriteString("✅ Every license found is allowed.\n")
+	}
+	fmt.Fprintf(&sb, "\nLicenses:\n%s", countList(licenses, maxLicensesListed))
+	if len(unlicensed) > 0 {
+		fmt.Fprintf(&sb, "\n• (none found): %d", len(unlicensed))
+	}
+	sb.WriteString("\n")
+
+	if len(violations) > 0 {
+		fmt.Fprintf(&sb, "\n⛔ Not allowed (%d):\n", len(violations))
+		for i, p := range violations {
+			if i == maxViolationsListed {
+				fmt.Fprintf(&sb, "… and %d more\n", len(violations)-i)
+				break
+			}
+			fmt.Fprintf(&sb, "• %s — %s\n", packageLabel(p), strings.Join(p.Licenses, ", "))
+		}
+	}
+	if len(unlicensed) > 0 {
+		fmt.Fprintf(&sb, "\n❓ No license found (%d): ", len(unlicensed))
+		var names []string
+		for i, p := range unlicensed {
+			if i == maxUnlicensedListed {
+				names = append(names, fmt.Sprintf("and %d more", len(unlicensed)-i))
+				break
+			}
+			names = append(names, packageLabel(p))
+		}
+		sb.WriteString(strings.Join(names, ", ") + "\n")
+	}
+	fmt.Fprintf(&sb, "\nAllowed: %s", strings.Join(allowed, ", "))
+	return sb.String()
+}
+
+func packageLabel(p sbomPackage) string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + "@" + p.Version
+}
+
+// countList formats counts, largest first, as "a 3, b 1", or one bullet a
+// line when limit is set, listing at most limit of them.
+func countList(counts map[string]int, limit int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(counts[b]-counts[a], strings.Compare(a, b))
+	})
+	if limit == 0 {
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = fmt.Sprintf("%s %d", k, counts[k])
+		}
+		return strings.Join(parts, ", ")
+	}
+	var sb strings.Builder
+	for i, k := range keys {
+		if i == limit {
+			fmt.Fprintf(&sb, "• … and %d more", len(keys)-i)
+			break
+		}
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "• %s: %d", k, counts[k])
+	}
+	return sb.String()
+}
+
+// normalizeLicense lowercases a license ID or name and maps common names to
+// their SPDX IDs. A trailing + ("or later") is dropped.
+func normalizeLicense(license string) string {
+	l := strings.ToLower(strings.TrimSpace(license))
+	if id, ok := licenseAliases[l]; ok {
+		return id
+	}
+	return strings.TrimSuffix(l, "+")
+}
+
+// licenseAllowed evaluates an SPDX license expression, such as
+// "(MIT OR GPL-2.0-only) AND BSD-3-Clause", against the allowed IDs: one
+// side of an OR must be allowed, and both sides of an AND. Exceptions
+// after WITH only grant permissions, so they're ignored. A plain license
+// name that isn't an expression is looked up as a whole.
+func licenseAllowed(expr string, allow map[string]bool) bool {
+	if allow[normalizeLicense(expr)] {
+		return true
+	}
+	p := &licenseParser{tokens: strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr))}
+	ok := p.or(allow)
+	return ok && p.pos == len(p.tokens)
+}
+
+type licenseParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *licenseParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToUpper(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *licenseParser) or(allow map[string]bool) bool {
+	ok := p.and(allow)
+	for p.peek() == "OR" {
+		p.pos++
+		ok = p.and(allow) || ok
+	}
+	return ok
+}
+
+func (p *licenseParser) and(allow map[string]bool) bool {
+	ok := p.term(allow)
+	for p.peek() == "AND" {
+		p.pos++
+		ok = p.term(allow) && ok
+	}
+	return ok
+}
+
+func (p *licenseParser) term(allow map[string]bool) bool {
+	switch p.peek() {
+	case "":
+		return false
+	case "(":
+		p.pos++
+		ok := p.or(allow)
+		if p.peek() != ")" {
+			return false
+		}
+		p.pos++
+		return ok
+	}
+	ok := allow[normalizeLicense(p.tokens[p.pos])]
+	p.pos++
+	if p.peek() == "WITH" {
+		p.pos += 2
+	}
+	return ok
+}
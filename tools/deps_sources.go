@@ -0,0 +1,204 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// latest looks up a dependency's newest release in its registry.
+func (d *DepsTool) latest(ctx context.Context, dep *dependency) (string, error) {
+	var endpoint string
+	switch dep.Ecosystem {
+	case "Go":
+		endpoint = d.goProxyURL + "/" + escapeModulePath(dep.Name) + "/@latest"
+	case "PyPI":
+		endpoint = d.pypiURL + "/pypi/" + url.PathEscape(dep.Name) + "/json"
+	case "npm":
+		endpoint = d.npmURL + "/-/package/" + strings.ReplaceAll(url.PathEscape(dep.Name), "%2F", "/") + "/dist-tags"
+	default:
+		return "", fmt.Errorf("unknown ecosystem %s", dep.Ecosystem)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	var result struct {
+		Version string `json:"Version"` // Go module proxy
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"` // PyPI
+		Latest string `json:"latest"` // npm dist-tags
+	}
+	if err := doJSON(d.httpClient, req, &result); err != nil {
+		return "", fmt.Errorf("looking up %s: %w", dep.Name, err)
+	}
+	for _, v := range []string{result.Version, result.Info.Version, result.Latest} {
+		if v != "" {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("no version found for %s", dep.Name)
+}
+
+// escapeModulePath escapes a Go module path for the module proxy, which
+// writes capital letters as ! and the lowercase letter.
+func escapeModulePath(path string) string {
+	var sb strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			sb.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// osvVersion is how OSV writes a dependency's version: Go's without the v.
+func osvVersion(dep *dependency) string {
+	if dep.Ecosystem == "Go" {
+		return strings.TrimPrefix(dep.Version, "v")
+	}
+	return dep.Version
+}
+
+// lookUpVulns fills in the advisories affecting each dependency's version
+// from OSV. Ranges are checked at their lowest version.
+func (d *DepsTool) lookUpVulns(ctx context.Context, deps []*dependency) error {
+	type query struct {
+		Package struct {
+			Name      string `json:"name"`
+			Ecosystem string `json:"ecosystem"`
+		} `json:"package"`
+		Version string `json:"version"`
+	}
+	var queries []query
+	var queried []*dependency
+	for _, dep := range deps {
+		if dep.Version == "" {
+			continue
+		}
+		var q query
+		q.Package.Name, q.Package.Ecosystem, q.Version = dep.Name, dep.Ecosystem, osvVersion(dep)
+		queries = append(queries, q)
+		queried = append(queried, dep)
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]any{"queries": queries})
+	if err != nil {
+		return fmt.Errorf("encoding request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.osvURL+"/v1/querybatch", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var batch struct {
+		Results []struct {
+			Vulns []struct {
+				ID string `json:"id"`
+			} `json:"vulns"`
+		} `json:"results"`
+	}
+	if err := doJSON(d.httpClient, req, &batch); err != nil {
+		return fmt.Errorf("querying OSV: %w", err)
+	}
+
+	details := make(map[string]*osvVuln)
+	for i, result := range batch.Results {
+		if i >= len(queried) {
+			break
+		}
+		dep := queried[i]
+		for _, v := range result.Vulns {
+			vuln, ok := details[v.ID]
+			if !ok && len(details) < maxVulnDetails {
+				vuln, _ = d.vuln(ctx, v.ID)
+				details[v.ID] = vuln
+			}
+			a := advisory{ID: v.ID}
+			if vuln != nil {
+				a = vuln.advisory(dep)
+			}
+			dep.Vulns = append(dep.Vulns, a)
+		}
+	}
+	return nil
+}
+
+// osvVuln is an OSV advisory.
+type osvVuln struct {
+	ID       string   `json:"id"`
+	Summary  string   `json:"summary"`
+	Details  string   `json:"details"`
+	Aliases  []string `json:"aliases"`
+	Affected []struct {
+		Package struct {
+			Name      string `json:"name"`
+			Ecosystem string `json:"ecosystem"`
+		} `json:"package"`
+		Ranges []struct {
+			Events []struct {
+				Fixed string `json:"fixed"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+func (d *DepsTool) vuln(ctx context.Context, id string) (*osvVuln, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.osvURL+"/v1/vulns/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	var vuln osvVuln
+	if err := doJSON(d.httpClient, req, &vuln); err != nil {
+		return nil, fmt.Errorf("looking up %s: %w", id, err)
+	}
+	return &vuln, nil
+}
+
+// advisory summarizes the advisory for one dependency, with the first fixed
+// version after the one it uses.
+func (v *osvVuln) advisory(dep *dependency) advisory {
+	a := advisory{ID: v.ID, Aliases: v.Aliases, Severity: strings.ToLower(v.DatabaseSpecific.Severity)}
+	a.Summary = v.Summary
+	if a.Summary == "" {
+		a.Summary, _, _ = strings.Cut(strings.TrimSpace(v.Details), "\n")
+	}
+	a.Summary = truncateText(a.Summary, 160)
+
+	var fixes []string
+	current := osvVersion(dep)
+	for _, affected := range v.Affected {
+		if !strings.EqualFold(affected.Package.Name, dep.Name) {
+			continue
+		}
+		for _, r := range affected.Ranges {
+			for _, e := range r.Events {
+				if e.Fixed != "" && compareVersions(e.Fixed, current) > 0 {
+					fixes = append(fixes, e.Fixed)
+				}
+			}
+		}
+	}
+	if len(fixes) > 0 {
+		a.Fixed = slices.MinFunc(fixes, compareVersions)
+		if dep.Ecosystem == "Go" {
+			a.Fixed = "v" + a.Fixed
+		}
+	}
+	return a
+}
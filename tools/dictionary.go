@@ -0,0 +1,404 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWiktionaryURL = "https://en.wiktionary.org"
+	dictionaryTimeout    = 15 * time.Second
+	maxSensesPerPart     = 4
+	maxDictionaryParts   = 4 // Parts of speech shown
+	maxSynonyms          = 15
+)
+
+// errNoEntry is returned when Wiktionary has no page for a word.
+var errNoEntry = errors.New("no entry")
+
+// DictionaryTool looks words up on Wiktionary, so definitions,
+// pronunciations, and etymologies come from a source rather than the
+// model's memory.
+type DictionaryTool struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewDictionaryTool creates a dictionary tool backed by the English
+// Wiktionary.
+func NewDictionaryTool() *DictionaryTool {
+	return &DictionaryTool{
+		baseURL:    defaultWiktionaryURL,
+		httpClient: &http.Client{Timeout: dictionaryTimeout},
+	}
+}
+
+func (d *DictionaryTool) Name() string {
+	return "dictionary"
+}
+
+func (d *DictionaryTool) Description() string {
+	return `Look up a word on Wiktionary. Use this instead of answering from memory.
+
+operation=define gives the pronunciation and definitions.
+operation=etymology gives the word's origin.
+operation=pronunciation gives IPA transcriptions by accent, rhymes, and homophones.
+operation=synonyms gives synonyms and antonyms.
+operation=all gives everything.
+language picks the entry for words in other languages (default English).
+Quote IPA exactly as returned, and cite the Wiktionary link.`
+}
+
+func (d *DictionaryTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"define", "etymology", "pronunciation", "synonyms", "all"},
+				"description": "What to look up",
+			},
+			"word": map[string]any{
+				"type":        "string",
+				"description": "The word or phrase",
+			},
+			"language": map[string]any{
+				"type":        "string",
+				"description": "The language of the word, e.g. French (default English)",
+			},
+		},
+		"required": []string{"operation", "word"},
+	}
+}
+
+func (d *DictionaryTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostLow}
+}
+
+func (d *DictionaryTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"operation": "define", "word": "dictionary"}, "Noun"
+}
+
+func (d *DictionaryTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	word, _ := args["word"].(string)
+	language, _ := args["language"].(string)
+	word = strings.TrimSpace(word)
+	language = cmp.Or(strings.TrimSpace(language), "English")
+	if word == "" {
+		return "", fmt.Errorf("word is required")
+	}
+	if !slices.Contains([]string{"define", "etymology", "pronunciation", "synonyms", "all"}, operation) {
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+
+	title, wikitext, err := d.page(ctx, word)
+	if errors.Is(err, errNoEntry) {
+		return fmt.Sprintf("Wiktionary has no entry for %q.", word), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	entry := languageSection(wikitext, language)
+	if entry == "" {
+		return fmt.Sprintf("Wiktionary has no %s entry for %q.", language, title), nil
+	}
+
+	var parts []string
+	if operation == "define" || operation == "pronunciation" || operation == "all" {
+		if p := pronunciation(entry, operation != "define"); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if operation == "define" || operation == "all" {
+		defs, err := d.definitions(ctx, title, language)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, defs)
+	}
+	if operation == "etymology" || operation == "all" {
+		parts = append(parts, cmp.Or(etymology(entry), "No etymology given."))
+	}
+	if operation == "synonyms" || operation == "all" {
+		parts = append(parts, cmp.Or(synonyms(entry), "No synonyms or antonyms listed."))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "No pronunciation given.")
+	}
+
+	link := d.baseURL + "/wiki/" + url.PathEscape(strings.ReplaceAll(title, " ", "_"))
+	if language != "English" {
+		link += "#" + url.PathEscape(strings.ReplaceAll(language, " ", "_"))
+	}
+	return fmt.Sprintf("%s\n\n%s\n\nSource: Wiktionary, %s", title, strings.Join(parts, "\n\n"), link), nil
+}
+
+// page fetches a word's wikitext, trying the lowercase form if the word as
+// written has no page ("Dictionary" at the start of a sentence).
+func (d *DictionaryTool) page(ctx context.Context, word string) (string, string, error) {
+	title, text, err := d.fetchWikitext(ctx, word)
+	if errors.Is(err, errNoEntry) && strings.ToLower(word) != word {
+		return d.fetchWikitext(ctx, strings.ToLower(word))
+	}
+	return title, text, err
+}
+
+func (d *DictionaryTool) fetchWikitext(ctx context.Context, word string) (string, string, error) {
+	query := url.Values{
+		"action":        {"parse"},
+		"page":          {word},
+		"prop":          {"wikitext"},
+		"redirects":     {"1"},
+		"format":        {"json"},
+		"formatversion": {"2"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.baseURL+"/w/api.php?"+query.Encode(), nil)
+	if err != nil {
+		return "", "", fmt.Errorf("creating request: %w", err)
+	}
+	var result struct {
+		Parse struct {
+			Title    string `json:"title"`
+			Wikitext string `json:"wikitext"`
+		} `json:"parse"`
+		Error struct {
+			Code string `json:"code"`
+			Info string `json:"info"`
+		} `json:"error"`
+	}
+	if err := doJSON(d.httpClient, req, &result); err != nil {
+		return "", "", fmt.Errorf("fetching Wiktionary entry: %w", err)
+	}
+	switch result.Error.Code {
+	case "":
+		return result.Parse.Title, result.Parse.Wikitext, nil
+	case "missingtitle", "invalidtitle":
+		return "", "", errNoEntry
+	default:
+		return "", "", fmt.Errorf("fetching Wiktionary entry: %s", result.Error.Info)
+	}
+}
+
+// definitions gets the senses from Wiktionary's REST API, which renders
+// the templates in them.
+func (d *DictionaryTool) definitions(ctx context.Context, title, language string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.baseURL+"/api/rest_v1/page/definition/"+url.PathEscape(title), nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	var result map[string][]struct {
+		PartOfSpeech string `json:"partOfSpeech"`
+		Language     string `json:"language"`
+		Definitions  []struct {
+			Definition string   `json:"definition"`
+			Examples   []string `json:"examples"`
+		} `json:"definitions"`
+	}
+	if err := doJSON(d.httpClient, req, &result); err != nil {
+		return "", fmt.Errorf("fetching definitions: %w", err)
+	}
+
+	// Entries are keyed by language code; sort them so replies are stable
+	codes := make([]string, 0, len(result))
+	for code := range result {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+
+	var b strings.Builder
+	parts := 0
+	for _, code := range codes {
+		for _, usage := range result[code] {
+			if !strings.EqualFold(usage.Language, language) || parts == maxDictionaryParts {
+				continue
+			}
+			parts++
+			b.WriteString(usage.PartOfSpeech + "\n")
+			n, more := 0, 0
+			for _, def := range usage.Definitions {
+				text := plainText(def.Definition)
+				if text == "" {
+					continue
+				}
+				if n == maxSensesPerPart {
+					more++
+					continue
+				}
+				n++
+				fmt.Fprintf(&b, "%d. %s\n", n, text)
+				if len(def.Examples) > 0 {
+					fmt.Fprintf(&b, "   “%s”\n", plainText(def.Examples[0]))
+				}
+			}
+			if more > 0 {
+				fmt.Fprintf(&b, "   (%d more senses on Wiktionary)\n", more)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if parts == 0 {
+		return "No definitions given.", nil
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// etymology renders the entry's etymology sections, numbered when the
+// word has several unrelated origins.
+func etymology(entry string) string {
+	sections := sectionsTitled(entry, "Etymology")
+	var lines []string
+	for _, s := range sections {
+		text := wikiPlain(ownText(s.Text))
+		if text == "" {
+			continue
+		}
+		if len(sections) > 1 {
+			text = s.Title + ": " + text
+		}
+		lines = append(lines, text)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "Etymology\n" + strings.Join(lines, "\n")
+}
+
+// pronunciation collects IPA transcriptions by accent. With extras, it
+// adds rhymes and homophones.
+func pronunciation(entry string, extras bool) string {
+	var ipa, more []string
+	for _, s := range sectionsTitled(entry, "Pronunciation") {
+		for _, line := range strings.Split(ownText(s.Text), "\n") {
+			if !strings.HasPrefix(line, "*") {
+				continue
+			}
+			var accents []string
+			eachTemplate(line, func(t wikiTemplate) string {
+				switch t.Name {
+				case "a", "accent":
+					// Newer entries give the language first: {{a|en|UK}}
+					accents = t.Args
+					if len(accents) > 1 && strings.ToLower(accents[0]) == accents[0] {
+						accents = accents[1:]
+					}
+				case "IPA":
+					transcriptions := t.Args[min(1, len(t.Args)):]
+					label := accents
+					if a := cmp.Or(t.Named["a"], t.Named["q"]); a != "" {
+						label = strings.Split(a, ",")
+					}
+					text := ipaForTelegram(strings.Join(transcriptions, ", "))
+					if len(label) > 0 {
+						text = strings.Join(label, ", ") + ": " + text
+					}
+					if !slices.Contains(ipa, text) {
+						ipa = append(ipa, text)
+					}
+				case "rhymes", "rhyme":
+					var rhymes []string
+					for _, r := range t.Args[min(1, len(t.Args)):] {
+						rhymes = append(rhymes, "-"+r)
+					}
+					more = append(more, "Rhymes: "+strings.Join(rhymes, ", "))
+				case "homophones", "homophone", "hmp":
+					more = append(more, "Homophones: "+strings.Join(t.Args[min(1, len(t.Args)):], ", "))
+				}
+				return ""
+			})
+		}
+	}
+	if len(ipa) == 0 && (!extras || len(more) == 0) {
+		return ""
+	}
+	lines := append([]string{"Pronunciation"}, ipa...)
+	if extras {
+		lines = append(lines, more...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// telegramCommand matches what Telegram would link as a bot command: a
+// slash starting a word, followed by Latin letters.
+var telegramCommand = regexp.MustCompile(`(^|[\s,(])/([A-Za-z0-9_])`)
+
+// ipaForTelegram keeps phonemic transcriptions like /wɜːd/ from being
+// shown as a tappable /w command, by putting a word joiner after the
+// opening slash. It is invisible and doesn't change the transcription.
+func ipaForTelegram(s string) string {
+	return telegramCommand.ReplaceAllString(s, "$1/⁠$2")
+}
+
+// synonyms gathers synonyms and antonyms from the sense lines ({{syn}} and
+// {{ant}}) and from Synonyms and Antonyms sections. Thesaurus pages are
+// mentioned rather than followed.
+func synonyms(entry string) string {
+	var syn, ant, thesaurus []string
+	add := func(list *[]string, words ...string) {
+		for _, w := range words {
+			w = wikiPlain(w)
+			if page, ok := strings.CutPrefix(w, "Thesaurus:"); ok {
+				if !slices.Contains(thesaurus, page) {
+					thesaurus = append(thesaurus, page)
+				}
+				continue
+			}
+			if w != "" && !slices.Contains(*list, w) && len(*list) < maxSynonyms {
+				*list = append(*list, w)
+			}
+		}
+	}
+	collect := func(text string, list *[]string) {
+		eachTemplate(text, func(t wikiTemplate) string {
+			switch t.Name {
+			case "syn", "synonyms", "ant", "antonyms":
+				target := list
+				if list == nil {
+					target = &syn
+					if strings.HasPrefix(t.Name, "ant") {
+						target = &ant
+					}
+				}
+				add(target, t.Args[min(1, len(t.Args)):]...)
+			case "l", "link", "ll", "col", "col2", "col3", "col4", "col-auto", "ws", "der3", "der4":
+				if list != nil {
+					add(list, t.Args[min(1, len(t.Args)):]...)
+				}
+			}
+			return ""
+		})
+		if list != nil {
+			for _, m := range wikiLink.FindAllStringSubmatch(text, -1) {
+				add(list, m[1])
+			}
+		}
+	}
+
+	collect(entry, nil)
+	for _, s := range sectionsTitled(entry, "Synonyms") {
+		collect(ownText(s.Text), &syn)
+	}
+	for _, s := range sectionsTitled(entry, "Antonyms") {
+		collect(ownText(s.Text), &ant)
+	}
+
+	var lines []string
+	if len(syn) > 0 {
+		lines = append(lines, "Synonyms: "+strings.Join(syn, ", "))
+	}
+	if len(ant) > 0 {
+		lines = append(lines, "Antonyms: "+strings.Join(ant, ", "))
+	}
+	if len(thesaurus) > 0 {
+		lines = append(lines, "More in the Wiktionary thesaurus: "+strings.Join(thesaurus, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
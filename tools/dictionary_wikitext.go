@@ -0,0 +1,252 @@
+package tools
+
+import (
+	"cmp"
+	"regexp"
+	"strings"
+)
+
+var (
+	wikiHeading = regexp.MustCompile(`(?m)^(={2,6})\s*([^=\n]+?)\s*={2,6}[ \t]*$`)
+	wikiLink    = regexp.MustCompile(`\[\[(?:[^\]|]*\|)?([^\]|]*)\]\]`)
+	wikiRef     = regexp.MustCompile(`(?s)<ref[^>/]*/>|<ref[^>]*>.*?</ref>`)
+	wikiComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// wikiSection is a heading and the text under it, up to the next heading
+// of the same or a higher level.
+type wikiSection struct {
+	Level int // 2 for ==Language==, 3 and deeper below it
+	Title string
+	Text  string
+}
+
+// wikiSections splits wikitext at its headings.
+func wikiSections(text string) []wikiSection {
+	matches := wikiHeading.FindAllStringSubmatchIndex(text, -1)
+	sections := make([]wikiSection, 0, len(matches))
+	for i, m := range matches {
+		level := m[3] - m[2]
+		end := len(text)
+		for _, next := range matches[i+1:] {
+			if next[3]-next[2] <= level {
+				end = next[0]
+				break
+			}
+		}
+		sections = append(sections, wikiSection{Level: level, Title: text[m[4]:m[5]], Text: text[m[1]:end]})
+	}
+	return sections
+}
+
+// languageSection returns the part of an entry for one language, such as
+// ==English==, or "" if the word isn't in that language.
+func languageSection(text, language string) string {
+	for _, s := range wikiSections(text) {
+		if s.Level == 2 && strings.EqualFold(s.Title, language) {
+			return s.Text
+		}
+	}
+	return ""
+}
+
+// sectionsTitled returns the sections whose titles start with prefix, so
+// "Etymology" finds "Etymology 1" and "Etymology 2" too.
+func sectionsTitled(text, prefix string) []wikiSection {
+	var found []wikiSection
+	for _, s := range wikiSections(text) {
+		if strings.HasPrefix(s.Title, prefix) {
+			found = append(found, s)
+		}
+	}
+	return found
+}
+
+// ownText is a section's text before its first subsection.
+func ownText(text string) string {
+	if loc := wikiHeading.FindStringIndex(text); loc != nil {
+		return text[:loc[0]]
+	}
+	return text
+}
+
+// wikiTemplate is a {{name|positional|key=value}} call.
+type wikiTemplate struct {
+	Name  string
+	Args  []string
+	Named map[string]string
+}
+
+func (t wikiTemplate) arg(i int) string {
+	if i < len(t.Args) {
+		return t.Args[i]
+	}
+	return ""
+}
+
+// eachTemplate calls fn for every top-level template in s and replaces the
+// template with what fn returns. Arguments are split at top-level pipes, so
+// links and nested templates inside them stay whole.
+func eachTemplate(s string, fn func(wikiTemplate) string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := matchingBraces(s, start)
+		if end < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:start])
+		b.WriteString(fn(parseTemplate(s[start+2 : end])))
+		s = s[end+2:]
+	}
+}
+
+// matchingBraces returns the index of the "}}" closing the "{{" at start,
+// or -1.
+func matchingBraces(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s)-1; i++ {
+		switch s[i : i+2] {
+		case "{{":
+			depth++
+			i++
+		case "}}":
+			depth--
+			if depth == 0 {
+				return i
+			}
+			i++
+		}
+	}
+	return -1
+}
+
+func parseTemplate(body string) wikiTemplate {
+	var parts []string
+	depth, last := 0, 0
+	for i := 0; i < len(body); i++ {
+		switch {
+		case strings.HasPrefix(body[i:], "{{"), strings.HasPrefix(body[i:], "[["):
+			depth++
+			i++
+		case strings.HasPrefix(body[i:], "}}"), strings.HasPrefix(body[i:], "]]"):
+			depth--
+			i++
+		case body[i] == '|' && depth == 0:
+			parts = append(parts, body[last:i])
+			last = i + 1
+		}
+	}
+	parts = append(parts, body[last:])
+
+	t := wikiTemplate{Name: strings.TrimSpace(parts[0]), Named: make(map[string]string)}
+	for _, part := range parts[1:] {
+		if key, value, ok := strings.Cut(part, "="); ok && !strings.ContainsAny(key, "{[") {
+			t.Named[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			continue
+		}
+		t.Args = append(t.Args, strings.TrimSpace(part))
+	}
+	return t
+}
+
+// wikiPlain renders wikitext as plain text: templates the dictionary knows
+// are written out, links become their text, and markup is dropped.
+func wikiPlain(s string) string {
+	s = wikiComment.ReplaceAllString(s, "")
+	s = wikiRef.ReplaceAllString(s, "")
+	s = eachTemplate(s, renderTemplate)
+	s = wikiLink.ReplaceAllString(s, "$1")
+	s = strings.NewReplacer("'''", "", "''", "").Replace(s)
+	s = plainText(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// renderTemplate writes out the templates used in etymologies. Others,
+// such as category and maintenance templates, are dropped.
+func renderTemplate(t wikiTemplate) string {
+	term := func(i int) string {
+		// The display form, if given, comes after the term
+		text := wikiPlain(cmp.Or(t.arg(i+1), t.arg(i)))
+		if gloss := cmp.Or(t.Named["t"], t.Named["gloss"], t.arg(i+2)); gloss != "" {
+			text += " (“" + wikiPlain(gloss) + "”)"
+		}
+		return text
+	}
+	fromLang := func(prefix string) string {
+		return strings.TrimSpace(prefix + " " + languageName(t.arg(1)) + " " + term(2))
+	}
+
+	switch t.Name {
+	case "inh", "inherited":
+		return fromLang("")
+	case "inh+":
+		return fromLang("Inherited from")
+	case "der", "derived", "uder", "calque", "cal", "sl", "semantic loan":
+		return fromLang("")
+	case "der+":
+		return fromLang("From")
+	case "bor", "borrowed", "lbor", "learned borrowing", "slbor", "ubor":
+		return fromLang("")
+	case "bor+":
+		return fromLang("Borrowed from")
+	case "cog", "cognate", "noncog", "noncognate":
+		return strings.TrimSpace(languageName(t.arg(0)) + " " + term(1))
+	case "m", "mention", "l", "link", "ll", "m+", "l-self":
+		return term(1)
+	case "af", "affix", "compound", "com", "prefix", "pre", "suffix", "suf", "confix", "con", "blend":
+		var parts []string
+		for _, a := range t.Args[min(1, len(t.Args)):] {
+			parts = append(parts, wikiPlain(a))
+		}
+		return strings.Join(parts, " + ")
+	case "doublet", "dbt":
+		return "Doublet of " + term(1)
+	case "clipping", "clip", "short for", "abbreviation of", "back-formation", "back-form", "bf":
+		return wikiRelations[t.Name] + " " + term(1)
+	case "gloss", "gl":
+		return "(“" + wikiPlain(t.arg(0)) + "”)"
+	case "q", "qual", "qualifier", "i", "qf":
+		return "(" + wikiPlain(strings.Join(t.Args, ", ")) + ")"
+	case "lang":
+		return wikiPlain(t.arg(1))
+	case "w", "pedia", "wp":
+		return wikiPlain(cmp.Or(t.arg(1), t.arg(0)))
+	case "IPAchar", "smallcaps", "sc", "ngd", "n-g", "non-gloss definition", "taxfmt":
+		return wikiPlain(t.arg(0))
+	}
+	return ""
+}
+
+// wikiRelations introduce the terms of templates for shortened forms.
+var wikiRelations = map[string]string{
+	"clipping": "Clipping of", "clip": "Clipping of", "short for": "Short for", "abbreviation of": "Abbreviation of",
+	"back-formation": "Back-formation from", "back-form": "Back-formation from", "bf": "Back-formation from",
+}
+
+// wiktionaryLanguages names the language codes etymologies use most.
+var wiktionaryLanguages = map[string]string{
+	"en": "English", "enm": "Middle English", "ang": "Old English", "sco": "Scots",
+	"la": "Latin", "LL.": "Late Latin", "ML.": "Medieval Latin", "NL.": "New Latin", "VL.": "Vulgar Latin",
+	"grc": "Ancient Greek", "el": "Greek", "fr": "French", "frm": "Middle French", "fro": "Old French",
+	"xno": "Anglo-Norman", "de": "German", "gmh": "Middle High German", "goh": "Old High German",
+	"nl": "Dutch", "dum": "Middle Dutch", "odt": "Old Dutch", "gml": "Middle Low German", "osx": "Old Saxon",
+	"non": "Old Norse", "is": "Icelandic", "sv": "Swedish", "da": "Danish", "no": "Norwegian", "got": "Gothic",
+	"gem-pro": "Proto-Germanic", "gmw-pro": "Proto-West Germanic", "ine-pro": "Proto-Indo-European",
+	"it": "Italian", "es": "Spanish", "pt": "Portuguese", "ca": "Catalan", "ro": "Romanian",
+	"ar": "Arabic", "fa": "Persian", "he": "Hebrew", "tr": "Turkish", "sa": "Sanskrit", "hi": "Hindi",
+	"ja": "Japanese", "zh": "Chinese", "ko": "Korean", "ru": "Russian", "pl": "Polish", "cs": "Czech",
+	"cy": "Welsh", "ga": "Irish", "gd": "Scottish Gaelic", "nah": "Nahuatl", "fi": "Finnish", "hu": "Hungarian",
+}
+
+func languageName(code string) string {
+	if name, ok := wiktionaryLanguages[code]; ok {
+		return name
+	}
+	return code
+}
@@ -0,0 +1,325 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	directionsTimeout     = 30 * time.Second
+	defaultOSRMURL        = "https://router.project-osrm.org"
+	defaultGoogleMapsURL  = "https://maps.googleapis.com/maps/api/directions/json"
+	maxRouteSummarySteps  = 8
+	leaveByRounding       = 5 * time.Minute
+	directionsTimeFormats = "15:04, 3pm, 3:30 PM, Mon Jan 2, 3:04 PM, 2006-01-02 15:04, or RFC3339"
+)
+
+// modeLabels describe travel modes after a duration, as in "20 min by car".
+var modeLabels = map[string]string{
+	"driving": "by car",
+	"walking": "on foot",
+	"cycling": "by bike",
+	"transit": "by transit",
+}
+
+// DirectionsConfig selects the routing service behind the directions tool.
+type DirectionsConfig struct {
+	Provider string // osrm (default), otp, or google
+	URL      string // The provider's endpoint; empty uses the public OSRM server or Google's API
+	APIKey   string // Google Maps API key, for the google provider
+}
+
+// trip is one routing question: how to get from one place to another, and
+// optionally when to be there or when to leave.
+type trip struct {
+	From, To         Location
+	FromName, ToName string // As the user gave them; Google routes by name
+	Mode             string // driving, walking, cycling, or transit
+	ArriveBy         time.Time
+	DepartAt         time.Time
+}
+
+// route is a provider's answer.
+type route struct {
+	Duration time.Duration
+	Distance float64 // meters
+	Summary  string  // Roads taken, or the transit lines and changes
+
+	// Depart and Arrive are set by providers that follow a timetable, for
+	// which leaving time isn't simply the arrival time minus the duration.
+	Depart, Arrive time.Time
+}
+
+// router is a routing service.
+type router interface {
+	// modes returns the travel modes the service supports.
+	modes() []string
+	// needsCoordinates reports whether places must be geocoded first.
+	needsCoordinates() bool
+	route(ctx context.Context, t trip) (*route, error)
+}
+
+// DirectionsTool reports travel time and a route summary between places,
+// and when to leave to arrive on time.
+type DirectionsTool struct {
+	router   router
+	geocoder *Geocoder
+}
+
+// NewDirectionsTool creates a directions tool using the configured routing
+// service. Place names are geocoded with geocoder when the service needs
+// coordinates.
+func NewDirectionsTool(cfg DirectionsConfig, geocoder *Geocoder) (*DirectionsTool, error) {
+	client := &http.Client{Timeout: directionsTimeout}
+	var r router
+	switch strings.ToLower(cfg.Provider) {
+	case "", "osrm":
+		r = &osrmRouter{url: strings.TrimSuffix(cmp.Or(cfg.URL, defaultOSRMURL), "/"), client: client}
+	case "otp":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("the otp provider needs DIRECTIONS_URL, e.g. http://localhost:8080/otp/routers/default")
+		}
+		r = &otpRouter{url: strings.TrimSuffix(cfg.URL, "/"), client: client}
+	case "google":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("the google provider needs GOOGLE_MAPS_API_KEY")
+		}
+		r = &googleRouter{url: cmp.Or(cfg.URL, defaultGoogleMapsURL), key: cfg.APIKey, client: client}
+	default:
+		return nil, fmt.Errorf("unknown directions provider %q (use osrm, otp, or google)", cfg.Provider)
+	}
+	return &DirectionsTool{router: r, geocoder: geocoder}, nil
+}
+
+func (d *DirectionsTool) Name() string {
+	return "directions"
+}
+
+func (d *DirectionsTool) Description() string {
+	return fmt.Sprintf(`Get travel time and a route summary between two places, by %s.
+
+from defaults to the location the user shared in Telegram. Set arrive_by to work out
+when to leave, e.g. for "when do I need to leave for my 3pm?": look the event up with
+get_calendar_events, then call directions with to=<the event's location> and
+arrive_by=<its start time>.`, strings.Join(d.router.modes(), ", "))
+}
+
+func (d *DirectionsTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"to": map[string]any{
+				"type":        "string",
+				"description": "Destination: an address or place name",
+			},
+			"from": map[string]any{
+				"type":        "string",
+				"description": "Starting point: an address or place name; leave empty for the user's shared location",
+			},
+			"mode": map[string]any{
+				"type":        "string",
+				"enum":        d.router.modes(),
+				"description": "How to travel (default " + d.router.modes()[0] + ")",
+			},
+			"arrive_by": map[string]any{
+				"type":        "string",
+				"description": "When to arrive, to work out when to leave: " + directionsTimeFormats,
+			},
+			"depart_at": map[string]any{
+				"type":        "string",
+				"description": "When leaving, if not now (same formats as arrive_by)",
+			},
+		},
+		"required": []string{"to"},
+	}
+}
+
+func (d *DirectionsTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+// FormFields asks where to, and where from when the user hasn't shared
+// their location, rather than letting the model guess.
+func (d *DirectionsTool) FormFields(ctx context.Context, args map[string]any) []FormField {
+	var fields []FormField
+	if missingArg(args, "to") {
+		fields = append(fields, schemaField(d, "to", "Where to?"))
+	}
+	if _, ok := LocationFrom(ctx); !ok && missingArg(args, "from") {
+		fields = append(fields, schemaField(d, "from", "Where from? (or share your location and ask again)"))
+	}
+	return fields
+}
+
+func (d *DirectionsTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"from": "Alexanderplatz, Berlin", "to": "Brandenburger Tor, Berlin"}, "min"
+}
+
+func (d *DirectionsTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	to, _ := args["to"].(string)
+	from, _ := args["from"].(string)
+	mode, _ := args["mode"].(string)
+	arriveBy, _ := args["arrive_by"].(string)
+	departAt, _ := args["depart_at"].(string)
+
+	t := trip{
+		FromName: strings.TrimSpace(from),
+		ToName:   strings.TrimSpace(to),
+		Mode:     strings.ToLower(strings.TrimSpace(mode)),
+	}
+	if t.ToName == "" {
+		return "", fmt.Errorf("to is required")
+	}
+	if t.Mode == "" {
+		t.Mode = d.router.modes()[0]
+	}
+	if !slices.Contains(d.router.modes(), t.Mode) {
+		return "", fmt.Errorf("mode %q is not supported by this routing service (use %s)", t.Mode, strings.Join(d.router.modes(), ", "))
+	}
+
+	now := time.Now()
+	var err error
+	if arriveBy != "" {
+		if t.ArriveBy, err = parseTripTime(arriveBy, now); err != nil {
+			return "", err
+		}
+	}
+	if departAt != "" {
+		if t.DepartAt, err = parseTripTime(departAt, now); err != nil {
+			return "", err
+		}
+	}
+
+	if err := d.locate(ctx, &t); err != nil {
+		return "", err
+	}
+	r, err := d.router.route(ctx, t)
+	if err != nil {
+		return "", err
+	}
+	return describeRoute(t, r, now), nil
+}
+
+// locate fills in the trip's end points: the user's shared location when
+// no starting point is given, and coordinates for names if the router
+// needs them.
+func (d *DirectionsTool) locate(ctx context.Context, t *trip) error {
+	if t.FromName == "" {
+		loc, ok := LocationFrom(ctx)
+		if !ok {
+			return fmt.Errorf("the user's location is unknown: ask them to share their location in Telegram (📎 → Location), or pass from")
+		}
+		t.From = loc
+		t.FromName = fmt.Sprintf("%.6f,%.6f", loc.Lat, loc.Lon)
+	} else if d.router.needsCoordinates() {
+		loc, _, err := d.geocoder.Geocode(ctx, t.FromName)
+		if err != nil {
+			return err
+		}
+		t.From = loc
+	}
+
+	if d.router.needsCoordinates() {
+		loc, _, err := d.geocoder.Geocode(ctx, t.ToName)
+		if err != nil {
+			return err
+		}
+		t.To = loc
+	}
+	return nil
+}
+
+// describeRoute writes the answer, leading with when to leave if the user
+// has somewhere to be.
+func describeRoute(t trip, r *route, now time.Time) string {
+	var b strings.Builder
+	travel := fmt.Sprintf("%s %s", formatTravelTime(r.Duration), modeLabels[t.Mode])
+	if r.Distance > 0 {
+		travel += fmt.Sprintf(", %s", formatDistance(r.Distance))
+	}
+
+	switch {
+	case !t.ArriveBy.IsZero():
+		leave := r.Depart
+		if leave.IsZero() {
+			leave = t.ArriveBy.Add(-r.Duration).Truncate(leaveByRounding)
+		}
+		fmt.Fprintf(&b, "🕒 Leave by %s to arrive by %s (%s).", clock(leave, now), clock(t.ArriveBy, now), travel)
+		if wait := leave.Sub(now); wait < 0 {
+			b.WriteString(" That's already passed, so you'll be late.")
+		} else if wait < time.Hour {
+			fmt.Fprintf(&b, " That's in %s.", formatTravelTime(wait))
+		}
+	case !t.DepartAt.IsZero() || !r.Depart.IsZero():
+		depart, arrive := cmp.Or(r.Depart, t.DepartAt), r.Arrive
+		if arrive.IsZero() {
+			arrive = depart.Add(r.Duration)
+		}
+		fmt.Fprintf(&b, "🕒 Leaving at %s, you'd arrive at %s (%s).", clock(depart, now), clock(arrive, now), travel)
+	default:
+		fmt.Fprintf(&b, "🕒 %s. Leaving now, you'd arrive at %s.", travel, clock(now.Add(r.Duration), now))
+	}
+	if r.Summary != "" {
+		fmt.Fprintf(&b, "\nRoute: %s", r.Summary)
+	}
+	return b.String()
+}
+
+// parseTripTime reads a time of day or a full date and time. A time of day
+// that has already passed today means tomorrow.
+func parseTripTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "Mon Jan 2, 3:04 PM", "Jan 2, 3:04 PM"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			if t.Year() == 0 {
+				t = t.AddDate(now.Year(), 0, 0)
+			}
+			return t, nil
+		}
+	}
+
+	upper := strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	for _, layout := range []string{"15:04", "3:04PM", "3PM"} {
+		clockTime, err := time.Parse(layout, upper)
+		if err != nil {
+			continue
+		}
+		t := time.Date(now.Year(), now.Month(), now.Day(), clockTime.Hour(), clockTime.Minute(), 0, 0, now.Location())
+		if t.Before(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q (use %s)", s, directionsTimeFormats)
+}
+
+// clock formats a time of day, with the day too when it isn't today.
+func clock(t, now time.Time) string {
+	t = t.In(now.Location())
+	if startOfDay(t).Equal(startOfDay(now)) {
+		return t.Format("3:04 PM")
+	}
+	return t.Format("Mon 3:04 PM")
+}
+
+func formatTravelTime(d time.Duration) string {
+	minutes := int(d.Round(time.Minute).Minutes())
+	switch {
+	case minutes < 1:
+		return "under a minute"
+	case minutes < 60:
+		return fmt.Sprintf("%d min", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d h", minutes/60)
+	default:
+		return fmt.Sprintf("%d h %d min", minutes/60, minutes%60)
+	}
+}
@@ -0,0 +1,312 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// osrmRouter routes with OSRM. The public demo server only has a car
+// profile; self-hosted servers may add foot and bike.
+type osrmRouter struct {
+	url    string
+	client *http.Client
+}
+
+// osrmProfiles maps travel modes to OSRM profile names.
+var osrmProfiles = map[string]string{
+	"driving": "driving",
+	"walking": "foot",
+	"cycling": "bike",
+}
+
+func (o *osrmRouter) modes() []string {
+	return []string{"driving", "walking", "cycling"}
+}
+
+func (o *osrmRouter) needsCoordinates() bool {
+	return true
+}
+
+func (o *osrmRouter) route(ctx context.Context, t trip) (*route, error) {
+	u := fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f?overview=false&steps=true",
+		o.url, osrmProfiles[t.Mode], t.From.Lon, t.From.Lat, t.To.Lon, t.To.Lat)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	var result struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Routes  []struct {
+			Duration float64 `json:"duration"`
+			Distance float64 `json:"distance"`
+			Legs     []struct {
+				Summary string `json:"summary"`
+				Steps   []struct {
+					Name     string  `json:"name"`
+					Ref      string  `json:"ref"`
+					Distance float64 `json:"distance"`
+				} `json:"steps"`
+			} `json:"legs"`
+		} `json:"routes"`
+	}
+	if err := doJSON(o.client, req, &result); err != nil {
+		return nil, fmt.Errorf("routing with OSRM: %w", err)
+	}
+	if result.Code != "Ok" || len(result.Routes) == 0 {
+		return nil, fmt.Errorf("OSRM found no route: %s %s", result.Code, result.Message)
+	}
+
+	best := result.Routes[0]
+	r := &route{
+		Duration: time.Duration(best.Duration * float64(time.Second)),
+		Distance: best.Distance,
+	}
+	// Name the roads the route spends most of its length on, in order
+	var roads []string
+	for _, leg := range best.Legs {
+		for _, step := range leg.Steps {
+			name := cmp.Or(step.Ref, step.Name)
+			if name == "" || step.Distance < best.Distance/20 || (len(roads) > 0 && roads[len(roads)-1] == name) {
+				continue
+			}
+			roads = append(roads, name)
+		}
+	}
+	if len(roads) > maxRouteSummarySteps {
+		roads = roads[:maxRouteSummarySteps]
+	}
+	if len(roads) > 0 {
+		r.Summary = "via " + strings.Join(roads, ", ")
+	}
+	return r, nil
+}
+
+// otpRouter plans public transit trips with an OpenTripPlanner server's
+// REST API, e.g. http://localhost:8080/otp/routers/default.
+type otpRouter struct {
+	url    string
+	client *http.Client
+}
+
+// otpModes maps travel modes to OpenTripPlanner mode lists.
+var otpModes = map[string]string{
+	"transit": "TRANSIT,WALK",
+	"walking": "WALK",
+	"cycling": "BICYCLE",
+	"driving": "CAR",
+}
+
+func (o *otpRouter) modes() []string {
+	return []string{"transit", "walking", "cycling", "driving"}
+}
+
+func (o *otpRouter) needsCoordinates() bool {
+	return true
+}
+
+func (o *otpRouter) route(ctx context.Context, t trip) (*route, error) {
+	when, arriveBy := time.Now(), false
+	switch {
+	case !t.ArriveBy.IsZero():
+		when, arriveBy = t.ArriveBy, true
+	case !t.DepartAt.IsZero():
+		when = t.DepartAt
+	}
+	query := url.Values{
+		"fromPlace": {fmt.Sprintf("%f,%f", t.From.Lat, t.From.Lon)},
+		"toPlace":   {fmt.Sprintf("%f,%f", t.To.Lat, t.To.Lon)},
+		"mode":      {otpModes[t.Mode]},
+		"date":      {when.Format("01-02-2006")},
+		"time":      {when.Format("3:04pm")},
+		"arriveBy":  {strconv.FormatBool(arriveBy)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url+"/plan?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	var result struct {
+		Plan struct {
+			Itineraries []struct {
+				Duration  float64 `json:"duration"`
+				StartTime int64   `json:"startTime"` // Milliseconds since the epoch
+				EndTime   int64   `json:"endTime"`
+				Legs      []struct {
+					Mode     string  `json:"mode"`
+					Route    string  `json:"route"`
+					Distance float64 `json:"distance"`
+					Duration float64 `json:"duration"`
+					From     struct {
+						Name string `json:"name"`
+					} `json:"from"`
+					To struct {
+						Name string `json:"name"`
+					} `json:"to"`
+				} `json:"legs"`
+			} `json:"itineraries"`
+		} `json:"plan"`
+		Error *struct {
+			Msg string `json:"msg"`
+		} `json:"error"`
+	}
+	if err := doJSON(o.client, req, &result); err != nil {
+		return nil, fmt.Errorf("planning with OpenTripPlanner: %w", err)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("OpenTripPlanner found no trip: %s", result.Error.Msg)
+	}
+	if len(result.Plan.Itineraries) == 0 {
+		return nil, fmt.Errorf("OpenTripPlanner found no trip")
+	}
+
+	best := result.Plan.Itineraries[0]
+	r := &route{
+		Duration: time.Duration(best.Duration * float64(time.Second)),
+		Depart:   time.UnixMilli(best.StartTime),
+		Arrive:   time.UnixMilli(best.EndTime),
+	}
+	var steps []string
+	for _, leg := range best.Legs {
+		r.Distance += leg.Distance
+		legTime := formatTravelTime(time.Duration(leg.Duration * float64(time.Second)))
+		if leg.Mode == "WALK" || leg.Mode == "BICYCLE" || leg.Mode == "CAR" {
+			steps = append(steps, fmt.Sprintf("%s %s", strings.ToLower(leg.Mode), legTime))
+			continue
+		}
+		steps = append(steps, fmt.Sprintf("%s %s from %s to %s (%s)", strings.ToLower(leg.Mode), leg.Route, leg.From.Name, leg.To.Name, legTime))
+	}
+	if len(steps) > maxRouteSummarySteps {
+		steps = steps[:maxRouteSummarySteps]
+	}
+	r.Summary = strings.Join(steps, " → ")
+	return r, nil
+}
+
+// googleRouter routes with the Google Directions API, which takes place
+// names directly and knows transit timetables and traffic.
+type googleRouter struct {
+	url    string
+	key    string
+	client *http.Client
+}
+
+// googleModes maps travel modes to the API's names.
+var googleModes = map[string]string{
+	"driving": "driving",
+	"walking": "walking",
+	"cycling": "bicycling",
+	"transit": "transit",
+}
+
+func (g *googleRouter) modes() []string {
+	return []string{"driving", "transit", "walking", "cycling"}
+}
+
+func (g *googleRouter) needsCoordinates() bool {
+	return false
+}
+
+func (g *googleRouter) route(ctx context.Context, t trip) (*route, error) {
+	query := url.Values{
+		"origin":      {t.FromName},
+		"destination": {t.ToName},
+		"mode":        {googleModes[t.Mode]},
+		"key":         {g.key},
+	}
+	switch {
+	case !t.ArriveBy.IsZero() && t.Mode == "transit":
+		query.Set("arrival_time", strconv.FormatInt(t.ArriveBy.Unix(), 10))
+	case !t.DepartAt.IsZero():
+		query.Set("departure_time", strconv.FormatInt(t.DepartAt.Unix(), 10))
+	case t.Mode == "driving" || t.Mode == "transit":
+		// Allows traffic-aware durations for driving
+		query.Set("departure_time", "now")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	type value struct {
+		Value float64 `json:"value"`
+	}
+	var result struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+		Routes       []struct {
+			Summary string `json:"summary"`
+			Legs    []struct {
+				Duration          value  `json:"duration"`
+				DurationInTraffic *value `json:"duration_in_traffic"`
+				Distance          value  `json:"distance"`
+				DepartureTime     *value `json:"departure_time"` // Transit only, seconds since the epoch
+				ArrivalTime       *value `json:"arrival_time"`
+				Steps             []struct {
+					TravelMode     string `json:"travel_mode"`
+					Duration       value  `json:"duration"`
+					TransitDetails *struct {
+						DepartureStop struct {
+							Name string `json:"name"`
+						} `json:"departure_stop"`
+						ArrivalStop struct {
+							Name string `json:"name"`
+						} `json:"arrival_stop"`
+						Line struct {
+							ShortName string `json:"short_name"`
+							Name      string `json:"name"`
+						} `json:"line"`
+					} `json:"transit_details"`
+				} `json:"steps"`
+			} `json:"legs"`
+		} `json:"routes"`
+	}
+	if err := doJSON(g.client, req, &result); err != nil {
+		return nil, fmt.Errorf("routing with Google: %w", err)
+	}
+	if result.Status != "OK" || len(result.Routes) == 0 || len(result.Routes[0].Legs) == 0 {
+		return nil, fmt.Errorf("Google found no route: %s %s", result.Status, result.ErrorMessage)
+	}
+
+	best := result.Routes[0]
+	leg := best.Legs[0]
+	r := &route{
+		Duration: time.Duration(leg.Duration.Value) * time.Second,
+		Distance: leg.Distance.Value,
+	}
+	if leg.DurationInTraffic != nil {
+		r.Duration = time.Duration(leg.DurationInTraffic.Value) * time.Second
+	}
+	if leg.DepartureTime != nil && leg.ArrivalTime != nil {
+		r.Depart = time.Unix(int64(leg.DepartureTime.Value), 0)
+		r.Arrive = time.Unix(int64(leg.ArrivalTime.Value), 0)
+	}
+
+	if t.Mode != "transit" {
+		if best.Summary != "" {
+			r.Summary = "via " + best.Summary
+		}
+		return r, nil
+	}
+	var steps []string
+	for _, step := range leg.Steps {
+		stepTime := formatTravelTime(time.Duration(step.Duration.Value) * time.Second)
+		if d := step.TransitDetails; d != nil {
+			steps = append(steps, fmt.Sprintf("%s from %s to %s (%s)", cmp.Or(d.Line.ShortName, d.Line.Name), d.DepartureStop.Name, d.ArrivalStop.Name, stepTime))
+		} else {
+			steps = append(steps, fmt.Sprintf("%s %s", strings.ToLower(step.TravelMode), stepTime))
+		}
+	}
+	if len(steps) > maxRouteSummarySteps {
+		steps = steps[:maxRouteSummarySteps]
+	}
+	r.Summary = strings.Join(steps, " → ")
+	return r, nil
+}
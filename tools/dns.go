@@ -0,0 +1,539 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+
+	"telegram-bot/auth"
+)
+
+const (
+	dnsLogPrefix          = "[dns]"
+	defaultDNSResolverURL = "https://cloudflare-dns.com/dns-query"
+	dnsTimeout            = 15 * time.Second
+	maxDNSRecordsListed   = 50
+	dnsApproval           = "Yes"
+)
+
+// dnsTypes are the record types by number, as DNS-over-HTTPS answers give
+// them, and the ones looked up by default.
+var (
+	dnsTypes = map[int]string{
+		1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 15: "MX", 16: "TXT",
+		28: "AAAA", 33: "SRV", 257: "CAA",
+	}
+	defaultLookupTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT", "CAA"}
+	// dnsWritableTypes are the record types set may create or change.
+	dnsWritableTypes = []string{"A", "AAAA", "CNAME", "TXT", "MX"}
+)
+
+// dnsRecord is a record in a provider's zone.
+type dnsRecord struct {
+	ID       string
+	Type     string
+	Name     string
+	Content  string
+	TTL      int // 1 is the provider's automatic TTL
+	Proxied  *bool
+	Priority *int // MX only
+}
+
+// dnsProvider manages the records of the zones an account hosts.
+type dnsProvider interface {
+	name() string
+	// zone returns the hosted zone a name belongs to, or "" if none.
+	zone(ctx context.Context, name string) (string, error)
+	records(ctx context.Context, zone, name, recordType string) ([]dnsRecord, error)
+	create(ctx context.Context, zone string, r dnsRecord) error
+	update(ctx context.Context, zone string, r dnsRecord) error
+}
+
+// dnsChange is one line of the DNS change audit log.
+type dnsChange struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	UserID   int64     `json:"user_id"`
+	Provider string    `json:"provider"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Old      string    `json:"old,omitempty"`
+	New      string    `json:"new"`
+	Success  bool      `json:"success"`
+	ErrorMsg string    `json:"error,omitempty"`
+}
+
+// DNSTool looks up any domain's records over DNS-over-HTTPS, and lists
+// and changes records in zones a configured provider hosts.
+type DNSTool struct {
+	resolverURL string
+	httpClient  *http.Client
+	provider    dnsProvider // nil allows lookups only
+	auditLog    string      // JSON-lines file recording every change; empty disables
+
+	auditMu sync.Mutex
+}
+
+// DNSOption configures a DNSTool.
+type DNSOption func(*DNSTool)
+
+// WithCloudflare manages the zones a Cloudflare API token can edit.
+func WithCloudflare(token string) DNSOption {
+	return func(d *DNSTool) {
+		d.provider = newCloudflare(token)
+	}
+}
+
+// WithDNSResolver looks records up with a DNS-over-HTTPS resolver that
+// answers in JSON, instead of Cloudflare's.
+func WithDNSResolver(resolverURL string) DNSOption {
+	return func(d *DNSTool) {
+		d.resolverURL = resolverURL
+	}
+}
+
+// WithDNSAuditLog records every record change in a JSON-lines file.
+func WithDNSAuditLog(path string) DNSOption {
+	return func(d *DNSTool) {
+		d.auditLog = path
+	}
+}
+
+// NewDNSTool creates a DNS tool.
+func NewDNSTool(opts ...DNSOption) *DNSTool {
+	d := &DNSTool{
+		resolverURL: defaultDNSResolverURL,
+		httpClient:  &http.Client{Timeout: dnsTimeout},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func (d *DNSTool) Name() string {
+	return "dns"
+}
+
+func (d *DNSTool) Description() string {
+	desc := `Look up the DNS records of any domain (operation=lookup): A, AAAA, CNAME, MX, NS, TXT, and CAA by
+default, or one type. Answers come from a public resolver, so they show what the world sees.`
+	if d.provider != nil {
+		desc += `
+
+The owner's zones are hosted on ` + d.provider.name() + `:
+- records: list the records in the zone name belongs to, or just name's with name set to a host
+- set: point name at content, creating the record of that type or changing the existing one. With several
+  records of the type, old says which to change. The user is asked to approve the change; don't ask them yourself.`
+	}
+	return desc
+}
+
+func (d *DNSTool) operations() []string {
+	if d.provider == nil {
+		return []string{"lookup"}
+	}
+	return []string{"lookup", "records", "set"}
+}
+
+func (d *DNSTool) Parameters() map[string]any {
+	properties := map[string]any{
+		"operation": map[string]any{
+			"type":        "string",
+			"enum":        d.operations(),
+			"description": "What to do (default lookup)",
+		},
+		"name": map[string]any{
+			"type":        "string",
+			"description": "The domain or host name, e.g. example.com or staging.example.com",
+		},
+		"type": map[string]any{
+			"type":        "string",
+			"description": "The record type, e.g. A or MX (lookup default: the common types; set default: A)",
+		},
+	}
+	if d.provider != nil {
+		properties["content"] = map[string]any{
+			"type":        "string",
+			"description": "For set: the record's new value, e.g. an IP address or a host name",
+		}
+		properties["old"] = map[string]any{
+			"type":        "string",
+			"description": "For set: the current value of the record to change, when name has several of the type",
+		}
+		properties["ttl"] = map[string]any{
+			"type":        "integer",
+			"description": "For set: the TTL in seconds (default: keep it, or automatic for a new record)",
+		}
+		properties["proxied"] = map[string]any{
+			"type":        "boolean",
+			"description": "For set on Cloudflare: whether traffic goes through Cloudflare (default: keep it, or off)",
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   []string{"name"},
+	}
+}
+
+func (d *DNSTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+// FormFields asks the owner to approve every change, whatever the model
+// passed, showing what the record holds now.
+func (d *DNSTool) FormFields(ctx context.Context, args map[string]any) []FormField {
+	if operation, _ := args["operation"].(string); operation != "set" || d.provider == nil {
+		return nil
+	}
+	if auth.RoleFrom(ctx) < auth.Owner {
+		return nil // Running the call reports why
+	}
+	change, err := d.plan(ctx, args)
+	if err != nil {
+		return []FormField{blockedField(err)}
+	}
+	prompt := fmt.Sprintf("Create %s %s → %s on %s?", change.record.Type, change.record.Name, change.record.Content, d.provider.name())
+	if change.old != "" {
+		prompt = fmt.Sprintf("Change %s %s from %s to %s on %s?", change.record.Type, change.record.Name, change.old, change.record.Content, d.provider.name())
+	}
+	return []FormField{approvalField(prompt, dnsApproval)}
+}
+
+func (d *DNSTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "", "lookup":
+		return d.lookup(ctx, args)
+	case "records", "set":
+		if d.provider == nil {
+			return "", fmt.Errorf("no DNS provider is configured, so records can only be looked up")
+		}
+		if auth.RoleFrom(ctx) < auth.Owner {
+			return "", fmt.Errorf("only the owner can manage DNS records")
+		}
+		if operation == "records" {
+			return d.listRecords(ctx, args)
+		}
+		return d.set(ctx, args)
+	}
+	return "", fmt.Errorf("unknown operation: %s", operation)
+}
+
+// hostArg returns the name argument as a lowercase ASCII host name, with
+// international names in punycode.
+func hostArg(args map[string]any) (string, error) {
+	name, _ := args["name"].(string)
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if u, err := url.Parse(name); err == nil && u.Host != "" {
+		name = u.Hostname() // A pasted link
+	}
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%q isn't a valid domain name: %w", name, err)
+	}
+	return ascii, nil
+}
+
+// lookup asks the resolver for each requested record type at once.
+func (d *DNSTool) lookup(ctx context.Context, args map[string]any) (string, error) {
+	name, err := hostArg(args)
+	if err != nil {
+		return "", err
+	}
+	types := defaultLookupTypes
+	if t, _ := args["type"].(string); t != "" {
+		types = []string{strings.ToUpper(strings.TrimSpace(t))}
+	}
+
+	type answer struct {
+		records []string
+		ttl     int
+		status  int
+		err     error
+	}
+	answers := make([]answer, len(types))
+	var wg sync.WaitGroup
+	for i, t := range types {
+		wg.Go(func() {
+			a := &answers[i]
+			a.records, a.ttl, a.status, a.err = d.resolve(ctx, name, t)
+		})
+	}
+	wg.Wait()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🌐 %s\n", name)
+	found := false
+	for i, t := range types {
+		a := answers[i]
+		switch {
+		case a.err != nil:
+			fmt.Fprintf(&sb, "%s: ⚠️ %v\n", t, a.err)
+		case a.status == 3:
+			return fmt.Sprintf("🌐 %s doesn't exist (NXDOMAIN).", name), nil
+		case len(a.records) > 0:
+			found = true
+			fmt.Fprintf(&sb, "%s (TTL %ds): %s\n", t, a.ttl, strings.Join(a.records, ", "))
+		}
+	}
+	if !found {
+		fmt.Fprintf(&sb, "No %s records.", strings.Join(types, ", "))
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// resolve looks up one record type, returning the answers of that type
+// (not the CNAMEs followed to them), their lowest TTL, and the DNS status.
+func (d *DNSTool) resolve(ctx context.Context, name, recordType string) ([]string, int, int, error) {
+	q := url.Values{"name": {name}, "type": {recordType}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.resolverURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/dns-json")
+	var result struct {
+		Status int `json:"Status"`
+		Answer []struct {
+			Type int    `json:"type"`
+			TTL  int    `json:"TTL"`
+			Data string `json:"data"`
+		} `json:"Answer"`
+	}
+	if err := doJSON(d.httpClient, req, &result); err != nil {
+		return nil, 0, 0, fmt.Errorf("looking up %s: %w", recordType, err)
+	}
+	var records []string
+	ttl := 0
+	for _, a := range result.Answer {
+		if dnsTypes[a.Type] != recordType {
+			continue
+		}
+		records = append(records, a.Data)
+		if ttl == 0 || a.TTL < ttl {
+			ttl = a.TTL
+		}
+	}
+	slices.Sort(records)
+	return records, ttl, result.Status, nil
+}
+
+// listRecords lists the records of name's zone, or just name's.
+func (d *DNSTool) listRecords(ctx context.Context, args map[string]any) (string, error) {
+	name, err := hostArg(args)
+	if err != nil {
+		return "", err
+	}
+	zone, err := d.zone(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	recordType, _ := args["type"].(string)
+	filter := name
+	if filter == zone && recordType == "" {
+		filter = "" // The whole zone
+	}
+	records, err := d.provider.records(ctx, zone, filter, strings.ToUpper(recordType))
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return fmt.Sprintf("No records for %s in %s.", name, zone), nil
+	}
+	slices.SortFunc(records, func(a, b dnsRecord) int {
+		return strings.Compare(a.Name+" "+a.Type, b.Name+" "+b.Type)
+	})
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📒 %s on %s: %d record(s)\n", zone, d.provider.name(), len(records))
+	for i, r := range records {
+		if i == maxDNSRecordsListed {
+			fmt.Fprintf(&sb, "… and %d more (ask for one name)\n", len(records)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "• %s %s %s", r.Name, r.Type, r.Content)
+		if r.Priority != nil {
+			fmt.Fprintf(&sb, " (priority %d)", *r.Priority)
+		}
+		if r.TTL == 1 {
+			sb.WriteString(", TTL auto")
+		} else {
+			fmt.Fprintf(&sb, ", TTL %ds", r.TTL)
+		}
+		if r.Proxied != nil && *r.Proxied {
+			sb.WriteString(", proxied")
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// zone finds the provider's zone for name.
+func (d *DNSTool) zone(ctx context.Context, name string) (string, error) {
+	zone, err := d.provider.zone(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if zone == "" {
+		return "", fmt.Errorf("%s isn't in a zone on %s that the token can manage", name, d.provider.name())
+	}
+	return zone, nil
+}
+
+// recordChange is a record set is about to create, or change from old.
+type recordChange struct {
+	zone   string
+	record dnsRecord
+	old    string // The current content; empty for a new record
+}
+
+// plan works out what set would do: create the record, or change which
+// existing one.
+func (d *DNSTool) plan(ctx context.Context, args map[string]any) (*recordChange, error) {
+	name, err := hostArg(args)
+	if err != nil {
+		return nil, err
+	}
+	recordType, _ := args["type"].(string)
+	recordType = strings.ToUpper(strings.TrimSpace(recordType))
+	if recordType == "" {
+		recordType = "A"
+	}
+	if !slices.Contains(dnsWritableTypes, recordType) {
+		return nil, fmt.Errorf("set can change %s records, not %s", strings.Join(dnsWritableTypes, ", "), recordType)
+	}
+	content, _ := args["content"].(string)
+	if content = strings.TrimSpace(content); content == "" {
+		return nil, fmt.Errorf("content is required for set")
+	}
+	zone, err := d.zone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := d.provider.records(ctx, zone, name, recordType)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &recordChange{zone: zone, record: dnsRecord{Type: recordType, Name: name, Content: content, TTL: 1}}
+	old, _ := args["old"].(string)
+	switch {
+	case old != "":
+		i := slices.IndexFunc(existing, func(r dnsRecord) bool { return r.Content == strings.TrimSpace(old) })
+		if i < 0 {
+			return nil, fmt.Errorf("%s has no %s record %s", name, recordType, old)
+		}
+		existing = existing[i : i+1]
+	case len(existing) > 1:
+		var values []string
+		for _, r := range existing {
+			values = append(values, r.Content)
+		}
+		return nil, fmt.Errorf("%s has %d %s records (%s); say which to change with old", name, len(existing), recordType, strings.Join(values, ", "))
+	}
+	if len(existing) == 1 {
+		current := existing[0]
+		change.old = current.Content
+		change.record.ID, change.record.TTL, change.record.Proxied, change.record.Priority = current.ID, current.TTL, current.Proxied, current.Priority
+	}
+	if ttl, ok := args["ttl"].(float64); ok && ttl > 0 {
+		change.record.TTL = int(ttl)
+	}
+	if proxied, ok := args["proxied"].(bool); ok {
+		change.record.Proxied = &proxied
+	}
+	if change.old == content && args["ttl"] == nil && args["proxied"] == nil {
+		return nil, fmt.Errorf("%s %s is already %s", name, recordType, content)
+	}
+	return change, nil
+}
+
+// set creates or changes a record once the owner has approved it, and
+// records the change in the audit log.
+func (d *DNSTool) set(ctx context.Context, args map[string]any) (string, error) {
+	if !approved(ctx, d.Name()) {
+		return "", fmt.Errorf("changing DNS records needs the user's approval, which the set call asks for")
+	}
+	change, err := d.plan(ctx, args)
+	if err != nil {
+		return "", err
+	}
+
+	user, _ := auth.UserFrom(ctx)
+	r := change.record
+	log.Printf("%s %s %s %s → %s by %s", dnsLogPrefix, d.provider.name(), r.Type, r.Name, r.Content, user.UserName)
+	if change.old == "" {
+		err = d.provider.create(ctx, change.zone, r)
+	} else {
+		err = d.provider.update(ctx, change.zone, r)
+	}
+
+	record := dnsChange{
+		Time:     time.Now().UTC(),
+		User:     user.UserName,
+		UserID:   user.ID,
+		Provider: d.provider.name(),
+		Name:     r.Name,
+		Type:     r.Type,
+		Old:      change.old,
+		New:      r.Content,
+		Success:  err == nil,
+	}
+	if err != nil {
+		record.ErrorMsg = err.Error()
+	}
+	if auditErr := d.writeAudit(record); auditErr != nil {
+		log.Printf("%s audit log: %v", dnsLogPrefix, auditErr)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ttl := "auto"
+	if r.TTL > 1 {
+		ttl = fmt.Sprintf("%ds", r.TTL)
+	}
+	if change.old == "" {
+		return fmt.Sprintf("✅ Created %s %s → %s (TTL %s).", r.Type, r.Name, r.Content, ttl), nil
+	}
+	return fmt.Sprintf("✅ Changed %s %s from %s to %s (TTL %s). Resolvers may keep the old value until its TTL runs out.",
+		r.Type, r.Name, change.old, r.Content, ttl), nil
+}
+
+// writeAudit appends the record to the audit log.
+func (d *DNSTool) writeAudit(record dnsChange) error {
+	if d.auditLog == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	d.auditMu.Lock()
+	defer d.auditMu.Unlock()
+
+	f, err := os.OpenFile(d.auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,230 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cloudflareAPI      = "https://api.cloudflare.com/client/v4"
+	cloudflareZonesTTL = 10 * time.Minute
+)
+
+// cloudflare manages records through Cloudflare's API with a token that
+// has Zone:Read and DNS:Edit on the zones to manage.
+type cloudflare struct {
+	token      string
+	apiURL     string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	zones     map[string]string // Zone name to ID
+	zonesTime time.Time
+}
+
+func newCloudflare(token string) *cloudflare {
+	return &cloudflare{
+		token:      token,
+		apiURL:     cloudflareAPI,
+		httpClient: &http.Client{Timeout: dnsTimeout},
+	}
+}
+
+func (c *cloudflare) name() string {
+	return "Cloudflare"
+}
+
+// cfRecord is a DNS record as Cloudflare's API has it.
+type cfRecord struct {
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Content  string `json:"content"`
+	TTL      int    `json:"ttl"`
+	Proxied  *bool  `json:"proxied,omitempty"`
+	Priority *int   `json:"priority,omitempty"`
+}
+
+// zone returns the longest zone name that name is in or equal to.
+func (c *cloudflare) zone(ctx context.Context, name string) (string, error) {
+	zones, err := c.zoneIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+	best := ""
+	for zone := range zones {
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	return best, nil
+}
+
+// zoneIDs lists the zones the token can see, cached for a few minutes.
+func (c *cloudflare) zoneIDs(ctx context.Context) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.zones != nil && time.Since(c.zonesTime) < cloudflareZonesTTL {
+		return c.zones, nil
+	}
+
+	zones := make(map[string]string)
+	for page := 1; ; page++ {
+		var result []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		info, err := c.call(ctx, http.MethodGet, fmt.Sprintf("/zones?per_page=50&page=%d", page), nil, &result)
+		if err != nil {
+			return nil, fmt.Errorf("listing zones: %w", err)
+		}
+		for _, z := range result {
+			zones[strings.ToLower(z.Name)] = z.ID
+		}
+		if info.TotalPages <= page {
+			break
+		}
+	}
+	c.zones, c.zonesTime = zones, time.Now()
+	return zones, nil
+}
+
+func (c *cloudflare) zoneID(ctx context.Context, zone string) (string, error) {
+	zones, err := c.zoneIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+	id, ok := zones[zone]
+	if !ok {
+		return "", fmt.Errorf("no Cloudflare zone %s", zone)
+	}
+	return id, nil
+}
+
+// records lists the zone's records, only name's and of recordType if
+// they're set.
+func (c *cloudflare) records(ctx context.Context, zone, name, recordType string) ([]dnsRecord, error) {
+	id, err := c.zoneID(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{"per_page": {"100"}}
+	if name != "" {
+		q.Set("name", name)
+	}
+	if recordType != "" {
+		q.Set("type", recordType)
+	}
+
+	var records []dnsRecord
+	for page := 1; ; page++ {
+		q.Set("page", fmt.Sprint(page))
+		var result []cfRecord
+		info, err := c.call(ctx, http.MethodGet, "/zones/"+id+"/dns_records?"+q.Encode(), nil, &result)
+		if err != nil {
+			return nil, fmt.Errorf("listing records: %w", err)
+		}
+		for _, r := range result {
+			records = append(records, dnsRecord(r))
+		}
+		if info.TotalPages <= page {
+			break
+		}
+	}
+	return records, nil
+}
+
+func (c *cloudflare) create(ctx context.Context, zone string, r dnsRecord) error {
+	id, err := c.zoneID(ctx, zone)
+	if err != nil {
+		return err
+	}
+	r.ID = ""
+	if _, err := c.call(ctx, http.MethodPost, "/zones/"+id+"/dns_records", cfRecord(r), nil); err != nil {
+		return fmt.Errorf("creating record: %w", err)
+	}
+	return nil
+}
+
+func (c *cloudflare) update(ctx context.Context, zone string, r dnsRecord) error {
+	id, err := c.zoneID(ctx, zone)
+	if err != nil {
+		return err
+	}
+	if _, err := c.call(ctx, http.MethodPatch, "/zones/"+id+"/dns_records/"+url.PathEscape(r.ID), cfRecord(r), nil); err != nil {
+		return fmt.Errorf("updating record: %w", err)
+	}
+	return nil
+}
+
+// cfResultInfo is the paging of a list response.
+type cfResultInfo struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+}
+
+// call sends a request to the API and decodes the result from its
+// envelope into v, reporting the API's own errors.
+func (c *cloudflare) call(ctx context.Context, method, path string, body, v any) (cfResultInfo, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return cfResultInfo{}, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reader)
+	if err != nil {
+		return cfResultInfo{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return cfResultInfo{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+	if err != nil {
+		return cfResultInfo{}, fmt.Errorf("reading response: %w", err)
+	}
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result     json.RawMessage `json:"result"`
+		ResultInfo cfResultInfo    `json:"result_info"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return cfResultInfo{}, fmt.Errorf("status %d: %s", resp.StatusCode, truncateText(string(data), 200))
+	}
+	if !envelope.Success {
+		var messages []string
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		if len(messages) == 0 {
+			messages = append(messages, fmt.Sprintf("status %d", resp.StatusCode))
+		}
+		return cfResultInfo{}, fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	if v != nil {
+		if err := json.Unmarshal(envelope.Result, v); err != nil {
+			return cfResultInfo{}, fmt.Errorf("parsing response: %w", err)
+		}
+	}
+	return envelope.ResultInfo, nil
+}
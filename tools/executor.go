@@ -0,0 +1,179 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	executorLogPrefix     = "[executor]"
+	defaultContainerImage = "python:3.12-slim" // Has python3 and bash
+	containerShell        = "bash"
+	containerPython       = "python3"
+)
+
+// Executor starts the commands the python tool's runs and the bash tool's
+// one-off commands are made of: on the host, or in a container.
+type Executor interface {
+	// Command returns the command for req, ready to have its output
+	// connected and be run.
+	Command(ctx context.Context, req ExecRequest) *exec.Cmd
+	// OnHost reports whether commands run among the host's programs, such
+	// as the workspace's virtualenv, rather than the container image's.
+	OnHost() bool
+}
+
+// ExecRequest is a command for an Executor to start.
+type ExecRequest struct {
+	Profile   string   // The isolation profile on the host, e.g. "bash"
+	Workspace string   // Mounted into containers at the same path
+	Dir       string   // The working directory, normally inside Workspace
+	Env       []string // NAME=value pairs added to the environment
+	Args      []string // The program and its arguments
+}
+
+// HostExecutor runs commands directly on the host, under the configured
+// isolation backend if there is one.
+type HostExecutor struct{}
+
+func (HostExecutor) Command(ctx context.Context, req ExecRequest) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, req.Args[0], req.Args[1:]...)
+	cmd.Dir = req.Dir
+	killTreeOnCancel(cmd)
+	cmd.Env = append(os.Environ(), req.Env...)
+	isolate(ctx, cmd, req.Profile)
+	return cmd
+}
+
+func (HostExecutor) OnHost() bool {
+	return true
+}
+
+// ContainerExecutor runs each command in a short-lived container that sees
+// the workspace and nothing else of the host.
+type ContainerExecutor struct {
+	Runtime string // "podman" or "docker"
+	Image   string // Needs bash and python3; defaults to defaultContainerImage
+	Memory  string // e.g. "512m"; empty for no limit
+	CPUs    string // e.g. "1.5"; empty for no limit
+	Network bool   // Let commands reach the network
+
+	// EgressNetwork is an internal container network whose only way out
+	// is the egress proxy. Networked commands join it instead of the
+	// runtime's default network, with the proxy's variables set.
+	EgressNetwork string
+
+	path string // The runtime's executable
+}
+
+// NewContainerExecutor checks the runtime is installed and returns an
+// executor using it.
+func NewContainerExecutor(runtime, image, memory, cpus string, network bool) (*ContainerExecutor, error) {
+	if runtime != "podman" && runtime != "docker" {
+		return nil, fmt.Errorf("unknown container runtime %q (use podman or docker)", runtime)
+	}
+	path, err := exec.LookPath(runtime)
+	if err != nil {
+		return nil, fmt.Errorf("finding %s: %w", runtime, err)
+	}
+	if image == "" {
+		image = defaultContainerImage
+	}
+	return &ContainerExecutor{Runtime: runtime, Image: image, Memory: memory, CPUs: cpus, Network: network, path: path}, nil
+}
+
+func (c *ContainerExecutor) Command(ctx context.Context, req ExecRequest) *exec.Cmd {
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	name := "telegram-bot-" + hex.EncodeToString(suffix)
+
+	args := []string{"run", "--rm", "--name", name,
+		"--cap-drop=all", "--security-opt=no-new-privileges"}
+	env := req.Env
+	switch {
+	case !c.Network || untrusted(ctx):
+		args = append(args, "--network=none")
+	case c.EgressNetwork != "":
+		isolationMu.RLock()
+		proxy := egressProxy
+		isolationMu.RUnlock()
+		args = append(args, "--network="+c.EgressNetwork)
+		if proxy != nil {
+			env = append(slices.Clip(env), proxy.Env(req.Profile)...)
+		}
+	}
+	if c.Memory != "" {
+		args = append(args, "--memory="+c.Memory)
+	}
+	if c.CPUs != "" {
+		args = append(args, "--cpus="+c.CPUs)
+	}
+	// Write files as the bot's user, so it can still read and delete them
+	switch {
+	case c.Runtime == "podman":
+		args = append(args, "--userns=keep-id")
+	case os.Getuid() >= 0:
+		args = append(args, fmt.Sprintf("--user=%d:%d", os.Getuid(), os.Getgid()))
+	}
+
+	// The workspace keeps its host path, so paths in output and in WORKSPACE
+	// mean the same inside and out
+	workspace, _ := filepath.Abs(req.Workspace)
+	dir, _ := filepath.Abs(req.Dir)
+	args = append(args, "--volume", workspace+":"+workspace)
+	if rel, err := filepath.Rel(workspace, dir); err != nil || strings.HasPrefix(rel, "..") {
+		args = append(args, "--volume", dir+":"+dir) // One of bash's allowed directories
+	}
+	args = append(args, "--workdir", dir, "--env", "HOME=/tmp")
+	for _, kv := range env {
+		args = append(args, "--env", kv)
+	}
+	args = append(append(args, c.Image), req.Args...)
+
+	cmd := exec.CommandContext(ctx, c.path, args...)
+	// Killing the client would leave the container running, so remove it
+	cmd.Cancel = func() error {
+		if err := exec.Command(c.path, "rm", "--force", name).Run(); err != nil {
+			log.Printf("%s removing %s: %v", executorLogPrefix, name, err)
+		}
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+func (c *ContainerExecutor) OnHost() bool {
+	return false
+}
+
+var (
+	executorMu sync.RWMutex
+	executor   Executor = HostExecutor{}
+)
+
+// SetExecutor makes the python and bash tools run code with e from now on.
+// Call it once at startup, before registering tools.
+func SetExecutor(e Executor) {
+	executorMu.Lock()
+	defer executorMu.Unlock()
+	executor = e
+	if c, ok := e.(*ContainerExecutor); ok {
+		log.Printf("%s running python and bash commands in %s containers of %s", executorLogPrefix, c.Runtime, c.Image)
+	}
+}
+
+func currentExecutor() Executor {
+	executorMu.RLock()
+	defer executorMu.RUnlock()
+	return executor
+}
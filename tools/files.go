@@ -0,0 +1,441 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"telegram-bot/tenant"
+)
+
+const (
+	maxGrepMatches = 200
+	maxTreeEntries = 500
+	defaultDepth   = 3
+)
+
+// FilesTool reads and changes files in the workspace natively, without a shell.
+type FilesTool struct {
+	workspaceDir string
+}
+
+// NewFilesTool creates a file tool confined to the given workspace.
+func NewFilesTool(workspaceDir string) *FilesTool {
+	if workspaceDir == "" {
+		workspaceDir = defaultWorkspace
+	}
+	return &FilesTool{workspaceDir: workspaceDir}
+}
+
+func (f *FilesTool) Name() string {
+	return "files"
+}
+
+func (f *FilesTool) Description() string {
+	return `Read and manage files in the workspace. Prefer this over bash for routine
+file work (cat, echo >, mv, rm, grep, find/tree).
+
+OPERATIONS:
+- read: Show a file (path)
+- write: Create or replace a file (path, content)
+- append: Add to the end of a file (path, content)
+- move: Move or rename (path, dest)
+- delete: Delete a file, or a directory with recursive=true (path)
+- grep: Search file contents with a regular expression (pattern, optional path)
+- tree: Show the directory structure (optional path, depth)
+
+All paths are relative to the workspace and cannot leave it.`
+}
+
+func (f *FilesTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"description": "The operation to perform",
+				"enum":        []string{"read", "write", "append", "move", "delete", "grep", "tree"},
+			},
+			"path": map[string]any{
+				"type":        "string",
+				"description": "File or directory path, relative to the workspace",
+			},
+			"content": map[string]any{
+				"type":        "string",
+				"description": "Content for write and append",
+			},
+			"dest": map[string]any{
+				"type":        "string",
+				"description": "Destination path for move",
+			},
+			"pattern": map[string]any{
+				"type":        "string",
+				"description": "Regular expression for grep",
+			},
+			"recursive": map[string]any{
+				"type":        "boolean",
+				"description": "Allow delete to remove a directory and its contents",
+			},
+			"depth": map[string]any{
+				"type":        "number",
+				"description": "Maximum depth for tree (default 3)",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (f *FilesTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+func (f *FilesTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"operation": "tree", "depth": float64(1)}, ""
+}
+
+func (f *FilesTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	f = &FilesTool{workspaceDir: tenant.Workspace(ctx, f.workspaceDir)}
+	operation, ok := args["operation"].(string)
+	if !ok || operation == "" {
+		return "", fmt.Errorf("operation is required")
+	}
+
+	if err := os.MkdirAll(f.workspaceDir, 0755); err != nil {
+		return "", fmt.Errorf("creating workspace: %w", err)
+	}
+
+	log.Printf("[files] operation=%s path=%v", operation, args["path"])
+
+	switch operation {
+	case "read":
+		return f.read(args)
+	case "write":
+		return f.write(args, false)
+	case "append":
+		return f.write(args, true)
+	case "move":
+		return f.move(args)
+	case "delete":
+		return f.delete(args)
+	case "grep":
+		return f.grep(args)
+	case "tree":
+		return f.tree(args)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// resolve maps a workspace-relative path to an absolute one, rejecting
+// anything outside the workspace.
+func (f *FilesTool) resolve(name string) (string, error) {
+	return safePath(f.workspaceDir, name)
+}
+
+// requirePath resolves a required path argument.
+func (f *FilesTool) requirePath(args map[string]any, key, operation string) (string, string, error) {
+	name, _ := args[key].(string)
+	if name == "" {
+		return "", "", fmt.Errorf("%s is required for %s", key, operation)
+	}
+	path, err := f.resolve(name)
+	return name, path, err
+}
+
+func (f *FilesTool) read(args map[string]any) (string, error) {
+	name, path, err := f.requirePath(args, "path", "read")
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("file not found: %s", name)
+		}
+		return "", fmt.Errorf("reading file: %w", err)
+	}
+	if !isText(content) {
+		return fmt.Sprintf("%s is a binary file (%d bytes)", name, len(content)), nil
+	}
+	if len(content) > maxOutputBytes {
+		return string(content[:maxOutputBytes]) + "\n... (file truncated)", nil
+	}
+	return string(content), nil
+}
+
+func (f *FilesTool) write(args map[string]any, appendMode bool) (string, error) {
+	operation := "write"
+	if appendMode {
+		operation = "append"
+	}
+	name, path, err := f.requirePath(args, "path", operation)
+	if err != nil {
+		return "", err
+	}
+	content, ok := args["content"].(string)
+	if !ok {
+		return "", fmt.Errorf("content is required for %s", operation)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("creating directory: %w", err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return "", fmt.Errorf("opening file: %w", err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return "", fmt.Errorf("writing file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("writing file: %w", err)
+	}
+
+	if appendMode {
+		return fmt.Sprintf("Appended %d bytes to %s", len(content), name), nil
+	}
+	return fmt.Sprintf("Saved to %s (%d bytes)", name, len(content)), nil
+}
+
+func (f *FilesTool) move(args map[string]any) (string, error) {
+	name, path, err := f.requirePath(args, "path", "move")
+	if err != nil {
+		return "", err
+	}
+	destName, dest, err := f.requirePath(args, "dest", "move")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", fmt.Errorf("file not found: %s", name)
+	}
+	// Moving onto a directory puts the file inside it, like mv
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = filepath.Join(dest, filepath.Base(path))
+		destName = filepath.Join(destName, filepath.Base(path))
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("creating directory: %w", err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("moving file: %w", err)
+	}
+	return fmt.Sprintf("Moved %s to %s", name, destName), nil
+}
+
+func (f *FilesTool) delete(args map[string]any) (string, error) {
+	name, path, err := f.requirePath(args, "path", "delete")
+	if err != nil {
+		return "", err
+	}
+	if root, _ := f.resolve("."); path == root {
+		return "", fmt.Errorf("refusing to delete the workspace itself")
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("file not found: %s", name)
+		}
+		return "", fmt.Errorf("checking file: %w", err)
+	}
+
+	if info.IsDir() {
+		if recursive, _ := args["recursive"].(bool); !recursive {
+			return "", fmt.Errorf("%s is a directory; set recursive=true to delete it and its contents", name)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return "", fmt.Errorf("deleting directory: %w", err)
+		}
+		return fmt.Sprintf("Deleted directory %s", name), nil
+	}
+
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("deleting file: %w", err)
+	}
+	return fmt.Sprintf("Deleted %s", name), nil
+}
+
+func (f *FilesTool) grep(args map[string]any) (string, error) {
+	pattern, _ := args["pattern"].(string)
+	if pattern == "" {
+		return "", fmt.Errorf("pattern is required for grep")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	name, _ := args["path"].(string)
+	start, err := f.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	root, _ := f.resolve(".")
+
+	var matches []string
+	truncated := false
+	err = filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != start && skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil || !isText(content) {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if !re.MatchString(scanner.Text()) {
+				continue
+			}
+			if len(matches) >= maxGrepMatches {
+				truncated = true
+				return filepath.SkipAll
+			}
+			text := scanner.Text()
+			if len(text) > 200 {
+				text = text[:200] + "..."
+			}
+			matches = append(matches, fmt.Sprintf("%s:%d: %s", rel, line, text))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("searching files: %w", err)
+	}
+
+	if len(matches) == 0 {
+		return "No matches.", nil
+	}
+	result := strings.Join(matches, "\n")
+	if truncated {
+		result += fmt.Sprintf("\n... (stopped after %d matches)", maxGrepMatches)
+	}
+	return result, nil
+}
+
+func (f *FilesTool) tree(args map[string]any) (string, error) {
+	name, _ := args["path"].(string)
+	start, err := f.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	depth := defaultDepth
+	if d, ok := args["depth"].(float64); ok && d > 0 {
+		depth = int(d)
+	}
+
+	info, err := os.Stat(start)
+	if err != nil {
+		return "", fmt.Errorf("path not found: %s", name)
+	}
+	if !info.IsDir() {
+		return fmt.Sprintf("%s (%d bytes)", name, info.Size()), nil
+	}
+
+	var b strings.Builder
+	if name == "" {
+		name = "."
+	}
+	b.WriteString(name + "/\n")
+	count := 0
+	writeTree(&b, start, "", depth, &count)
+	if count >= maxTreeEntries {
+		fmt.Fprintf(&b, "... (stopped after %d entries)\n", maxTreeEntries)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// writeTree prints dir's entries with box-drawing prefixes, directories first.
+func writeTree(b *strings.Builder, dir, indent string, depth int, count *int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IsDir() && !entries[j].IsDir()
+	})
+
+	for i, e := range entries {
+		if *count >= maxTreeEntries {
+			return
+		}
+		*count++
+
+		branch, next := "├── ", "│   "
+		if i == len(entries)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		if e.IsDir() {
+			b.WriteString(indent + branch + e.Name() + "/\n")
+			if depth > 1 && !skipDir(e.Name()) {
+				writeTree(b, filepath.Join(dir, e.Name()), indent+next, depth-1, count)
+			}
+			continue
+		}
+		size := ""
+		if info, err := e.Info(); err == nil {
+			size = fmt.Sprintf(" (%s)", formatSize(info.Size()))
+		}
+		b.WriteString(indent + branch + e.Name() + size + "\n")
+	}
+}
+
+// skipDir reports whether a directory is noise for grep and tree.
+func skipDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "__pycache__" || name == "node_modules"
+}
+
+// isText guesses whether content is text by looking for NUL bytes near the start.
+func isText(content []byte) bool {
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	return !bytes.Contains(head, []byte{0})
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<40:
+		return fmt.Sprintf("%.1f TB", float64(n)/(1<<40))
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
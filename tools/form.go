@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FormField is one argument a form asks the user for.
+type FormField struct {
+	Name     string   // The tool argument it fills
+	Prompt   string   // The question to ask
+	Choices  []string // Offered as buttons; nil asks for a typed answer
+	Optional bool     // May be skipped, leaving the argument out
+	Number   bool     // The answer must be a number
+	Approval bool     // Picking a choice approves the call; it's never an argument
+}
+
+// Form collects the arguments a tool call is missing from the user, one
+// field at a time, so the tool gets answers rather than the model's
+// guesses.
+type Form struct {
+	Tool   string
+	Args   map[string]any // Arguments so far, including answers
+	Fields []FormField    // Every field to ask, in order
+	next   int            // Index of the field being asked
+
+	approved bool // The user picked an approval field's choice
+}
+
+// approvalKey carries the tool whose call the user approved through a
+// form. Only Form.Context sets it, so a model can't approve its own call
+// by passing an argument.
+type approvalKey struct{}
+
+// Formable is implemented by tools whose calls need inputs only the user
+// can give. FormFields returns the fields args lacks, in the order to ask
+// them, or nil when the call can run as it is.
+type Formable interface {
+	FormFields(ctx context.Context, args map[string]any) []FormField
+}
+
+// FormFor returns a form for the fields a call is missing, or nil if the
+// call has what it needs or the tool isn't offered for this request, in
+// which case running it reports why.
+func FormFor(ctx context.Context, tool Tool, args map[string]any) *Form {
+	f, ok := As[Formable](tool)
+	if !ok || !isAvailable(ctx, tool) {
+		return nil
+	}
+	fields := f.FormFields(ctx, args)
+	if len(fields) == 0 {
+		return nil
+	}
+	known := make(map[string]any, len(args)+len(fields))
+	for k, v := range args {
+		known[k] = v
+	}
+	for _, field := range fields {
+		if field.Approval {
+			delete(known, field.Name)
+		}
+	}
+	return &Form{Tool: tool.Name(), Args: known, Fields: fields}
+}
+
+// Context returns ctx for running the completed call, carrying the user's
+// approval if the form asked for it and they gave it.
+func (f *Form) Context(ctx context.Context) context.Context {
+	if !f.approved {
+		return ctx
+	}
+	return context.WithValue(ctx, approvalKey{}, f.Tool)
+}
+
+// approved reports whether the user approved this call of tool through
+// its form.
+func approved(ctx context.Context, tool string) bool {
+	name, _ := ctx.Value(approvalKey{}).(string)
+	return name == tool
+}
+
+// Field returns the field to ask next; ok is false once every field has
+// been answered or skipped.
+func (f *Form) Field() (field FormField, ok bool) {
+	if f.next >= len(f.Fields) {
+		return FormField{}, false
+	}
+	return f.Fields[f.next], true
+}
+
+// Step returns the number of the field being asked, from 1, and how many
+// there are.
+func (f *Form) Step() (n, total int) {
+	return f.next + 1, len(f.Fields)
+}
+
+// Answer fills in the current field from the user's reply and moves on
+// to the next. A reply that doesn't fit the field is an error, and the
+// field is asked again.
+func (f *Form) Answer(text string) error {
+	field, ok := f.Field()
+	if !ok {
+		return fmt.Errorf("the form is already complete")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("please answer: %s", field.Prompt)
+	}
+
+	var value any = text
+	switch {
+	case field.Approval && len(field.Choices) == 0:
+		return fmt.Errorf("this can't go ahead; cancel it")
+	case len(field.Choices) > 0:
+		i := slices.IndexFunc(field.Choices, func(choice string) bool { return strings.EqualFold(choice, text) })
+		if i < 0 {
+			return fmt.Errorf("please pick one of: %s", strings.Join(field.Choices, ", "))
+		}
+		value = field.Choices[i]
+	case field.Number:
+		n, err := strconv.ParseFloat(strings.ReplaceAll(text, ",", ""), 64)
+		if err != nil {
+			return fmt.Errorf("please answer with a number")
+		}
+		value = n
+	}
+	if field.Approval {
+		f.approved = true
+	} else {
+		f.Args[field.Name] = value
+	}
+	f.next++
+	return nil
+}
+
+// Skip leaves the current field out, if it's optional.
+func (f *Form) Skip() error {
+	field, ok := f.Field()
+	if !ok {
+		return fmt.Errorf("the form is already complete")
+	}
+	if !field.Optional {
+		return fmt.Errorf("%s can't be skipped", field.Name)
+	}
+	f.next++
+	return nil
+}
+
+// approvalField asks the user to approve a call by picking choice.
+func approvalField(prompt, choice string) FormField {
+	return FormField{Name: "approval", Prompt: prompt, Choices: []string{choice}, Approval: true}
+}
+
+// blockedField stands in for the approval field when the call can't be
+// shown to the user, such as when its plan fails: it offers nothing to
+// approve, so the user can only cancel.
+func blockedField(err error) FormField {
+	return FormField{Name: "approval", Prompt: "⚠️ " + err.Error(), Approval: true}
+}
+
+// missingArg reports whether a call's argument is absent or blank.
+func missingArg(args map[string]any, name string) bool {
+	switch v := args[name].(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	default:
+		return false
+	}
+}
+
+// schemaField builds a form field for one of the tool's parameters, with
+// its enum as the choices.
+func schemaField(tool Tool, name, prompt string) FormField {
+	field := FormField{Name: name, Prompt: prompt}
+	properties, _ := tool.Parameters()["properties"].(map[string]any)
+	property, _ := properties[name].(map[string]any)
+	field.Choices, _ = property["enum"].([]string)
+	switch property["type"] {
+	case "number", "integer":
+		field.Number = true
+	}
+	return field
+}
@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// approvalTool asks for approval of every call, like the tools that change
+// things outside the bot.
+type approvalTool struct {
+	fields []FormField
+	ran    bool
+}
+
+func (a *approvalTool) Name() string               { return "approve-me" }
+func (a *approvalTool) Description() string        { return "" }
+func (a *approvalTool) Parameters() map[string]any { return map[string]any{} }
+func (a *approvalTool) Metadata() Metadata         { return Metadata{} }
+
+func (a *approvalTool) FormFields(ctx context.Context, args map[string]any) []FormField {
+	return a.fields
+}
+
+func (a *approvalTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if !approved(ctx, a.Name()) {
+		return "", errors.New("not approved")
+	}
+	a.ran = true
+	return "done", nil
+}
+
+func TestFormApproval(t *testing.T) {
+	tool := &approvalTool{fields: []FormField{approvalField("Go ahead?", "Yes")}}
+
+	// The model passing the approval itself approves nothing
+	args := map[string]any{"target": "x", "approval": "Yes"}
+	if _, err := Run(context.Background(), tool, args); err == nil {
+		t.Fatal("call approved by its own arguments")
+	}
+
+	form := FormFor(context.Background(), tool, args)
+	if form == nil {
+		t.Fatal("no form for a call that needs approval")
+	}
+	if _, ok := form.Args["approval"]; ok {
+		t.Error("form kept the model's approval argument")
+	}
+	if _, err := Run(form.Context(context.Background()), tool, form.Args); err == nil {
+		t.Fatal("call ran before the user approved it")
+	}
+
+	if err := form.Answer("yes"); err != nil {
+		t.Fatalf("Answer: %v", err)
+	}
+	if _, ok := form.Args["approval"]; ok {
+		t.Error("the user's approval was recorded as an argument")
+	}
+	if _, err := Run(form.Context(context.Background()), tool, form.Args); err != nil || !tool.ran {
+		t.Fatalf("approved call didn't run: %v", err)
+	}
+
+	// Approval of one tool doesn't carry over to another
+	if approved(form.Context(context.Background()), "other") {
+		t.Error("approval leaked to another tool")
+	}
+}
+
+func TestBlockedFieldCantBeApproved(t *testing.T) {
+	tool := &approvalTool{fields: []FormField{blockedField(errors.New("the plan failed"))}}
+	form := FormFor(context.Background(), tool, map[string]any{})
+	if form == nil {
+		t.Fatal("no form when planning failed")
+	}
+	for _, answer := range []string{"yes", "Yes", "approve"} {
+		if err := form.Answer(answer); err == nil {
+			t.Fatalf("Answer(%q) approved a call that couldn't be planned", answer)
+		}
+	}
+	if _, err := Run(form.Context(context.Background()), tool, form.Args); err == nil {
+		t.Fatal("blocked call ran")
+	}
+}
@@ -0,0 +1,122 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	geocodeTimeout    = 15 * time.Second
+	nominatimInterval = time.Second // Nominatim's usage policy allows one request per second
+	osmUserAgent      = "telegram-bot (https://github.com/joejstuart/telegram-bot)"
+)
+
+// Geocoder turns place names and addresses into coordinates with
+// OpenStreetMap's Nominatim. It is shared by the tools that need it, so
+// together they stay within the server's rate limit.
+type Geocoder struct {
+	url        string
+	httpClient *http.Client
+
+	mu   sync.Mutex // Spaces out requests
+	last time.Time
+}
+
+// NewGeocoder creates a geocoder using the Nominatim server at url.
+func NewGeocoder(url string) *Geocoder {
+	return &Geocoder{
+		url:        strings.TrimSuffix(url, "/"),
+		httpClient: &http.Client{Timeout: geocodeTimeout},
+	}
+}
+
+// Geocode looks a place up and returns its location and full name.
+func (g *Geocoder) Geocode(ctx context.Context, query string) (Location, string, error) {
+	if err := g.wait(ctx); err != nil {
+		return Location{}, "", err
+	}
+
+	u := g.url + "/search?" + url.Values{
+		"q":      {query},
+		"format": {"jsonv2"},
+		"limit":  {"1"},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Location{}, "", fmt.Errorf("creating request: %w", err)
+	}
+	var results []struct {
+		Lat         string `json:"lat"`
+		Lon         string `json:"lon"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := doJSON(g.httpClient, req, &results); err != nil {
+		return Location{}, "", fmt.Errorf("looking up %q: %w", query, err)
+	}
+	if len(results) == 0 {
+		return Location{}, "", fmt.Errorf("couldn't find a place called %q", query)
+	}
+
+	lat, err1 := strconv.ParseFloat(results[0].Lat, 64)
+	lon, err2 := strconv.ParseFloat(results[0].Lon, 64)
+	if err1 != nil || err2 != nil {
+		return Location{}, "", fmt.Errorf("Nominatim returned invalid coordinates for %q", query)
+	}
+	return Location{Lat: lat, Lon: lon}, results[0].DisplayName, nil
+}
+
+// wait keeps requests at least a second apart, as the public Nominatim
+// server asks.
+func (g *Geocoder) wait(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if wait := nominatimInterval - time.Since(g.last); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	g.last = time.Now()
+	return nil
+}
+
+// doJSON sends a request to a lookup API, such as OpenStreetMap or a
+// routing service, and decodes its JSON response into v. Transport errors
+// leave out the query string, which may hold an API key.
+func doJSON(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("User-Agent", osmUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			safe := *req.URL
+			safe.RawQuery = ""
+			uerr.URL = safe.String()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, truncateText(string(body), 200))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,544 @@
+package tools
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-bot/tenant"
+)
+
+const (
+	githubLogPrefix   = "[github]"
+	githubTimeout     = 30 * time.Second
+	maxIssueComments  = 20
+	maxIssueBodyChars = 6000
+	forkReadyAttempts = 5 // New forks take a few seconds before they accept pushes
+	forkReadyDelay    = 3 * time.Second
+)
+
+var (
+	// issueURL matches https://host/owner/repo/issues/123, and owner/repo#123
+	// for issues on the configured host.
+	issueURL      = regexp.MustCompile(`^https://([\w.-]+)/([\w.-]+)/([\w.-]+)/issues/(\d+)/?(?:[?#].*)?$`)
+	issueShortRef = regexp.MustCompile(`^([\w.-]+)/([\w.-]+)#(\d+)$`)
+	remoteURL     = regexp.MustCompile(`^(?:https://(?:[^@/]+@)?([\w.-]+)/|git@([\w.-]+):)([\w.-]+)/([\w.-]+?)(?:\.git)?/?$`)
+	branchName    = regexp.MustCompile(`^[\w][\w./-]*$`)
+)
+
+// Issue is a GitHub issue with its discussion.
+type Issue struct {
+	Host     string
+	Owner    string
+	Repo     string
+	Number   int
+	Title    string
+	State    string
+	Author   string
+	Labels   []string
+	Body     string
+	URL      string
+	Comments []IssueComment
+}
+
+// IssueComment is one comment on an issue.
+type IssueComment struct {
+	Author string
+	Body   string
+}
+
+// RepoURL returns the URL the issue's repository clones from.
+func (i *Issue) RepoURL() string {
+	return fmt.Sprintf("https://%s/%s/%s", i.Host, i.Owner, i.Repo)
+}
+
+// String formats the issue for the model and for chat.
+func (i *Issue) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s/%s#%d: %s (%s)\n", i.Owner, i.Repo, i.Number, i.Title, i.State)
+	fmt.Fprintf(&sb, "Opened by %s", i.Author)
+	if len(i.Labels) > 0 {
+		fmt.Fprintf(&sb, "; labels: %s", strings.Join(i.Labels, ", "))
+	}
+	fmt.Fprintf(&sb, "\n%s\n", i.URL)
+	if body := strings.TrimSpace(i.Body); body != "" {
+		sb.WriteString("\n" + truncateText(body, maxIssueBodyChars) + "\n")
+	}
+	for _, c := range i.Comments {
+		fmt.Fprintf(&sb, "\n— %s:\n%s\n", c.Author, truncateText(strings.TrimSpace(c.Body), maxIssueBodyChars/4))
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// PullRequest describes a pull request to open.
+type PullRequest struct {
+	Owner string // Of the repository the pull request is opened on
+	Repo  string
+	Head  string // The branch, as owner:branch when it's on a fork
+	Base  string // Empty uses the repository's default branch
+	Title string
+	Body  string
+	Draft bool
+}
+
+// GitHubTool reads issues, pushes branches from workspace clones, opens
+// pull requests, and drafts release notes, with the owner's token. The
+// token is sent to git in an environment variable, never in a URL or on a
+// command line.
+type GitHubTool struct {
+	scrape       *ScrapeTool // For drafting release notes
+	token        string
+	apiURL       string
+	webHost      string
+	workspaceDir string
+	httpClient   *http.Client
+}
+
+// NewGitHubTool creates a GitHub tool for the API at apiURL, pushing from
+// clones in workspaceDir and writing release notes with the scrape tool's
+// model.
+func NewGitHubTool(scrape *ScrapeTool, token, apiURL, workspaceDir string) *GitHubTool {
+	apiURL = strings.TrimSuffix(apiURL, "/")
+	webHost := "github.com"
+	if u, err := url.Parse(apiURL); err == nil && u.Host != "api.github.com" {
+		webHost = u.Host // GitHub Enterprise serves the API under /api/v3
+	}
+	return &GitHubTool{
+		scrape:       scrape,
+		token:        token,
+		apiURL:       apiURL,
+		webHost:      webHost,
+		workspaceDir: workspaceDir,
+		httpClient:   &http.Client{Timeout: githubTimeout},
+	}
+}
+
+func (g *GitHubTool) Name() string {
+	return "github"
+}
+
+func (g *GitHubTool) Description() string {
+	return `Work with GitHub issues and pull requests.
+
+operation=issue with issue (a URL or owner/repo#123) shows an issue with its labels and comments.
+operation=push with repo (a clone's folder in the workspace, e.g. repos/project), branch, and message
+commits all changes in the clone to the branch and pushes it, to a fork if the token can't push to the
+repository; it returns the head to open a pull request from.
+operation=create_pr with repo (owner/name), head, title, and body opens a pull request, as a draft
+unless draft is false; base defaults to the repository's default branch.
+operation=changelog with repo (owner/name), from, and to (tags) drafts release notes from the commits
+and pull requests between them, grouped by type, and sends them as a markdown document. Without from
+and to it covers the two latest tags; without to, from the tag to the default branch.`
+}
+
+func (g *GitHubTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"issue", "push", "create_pr", "changelog"},
+				"description": "What to do",
+			},
+			"issue": map[string]any{
+				"type":        "string",
+				"description": "For issue: https://github.com/owner/repo/issues/123 or owner/repo#123",
+			},
+			"repo": map[string]any{
+				"type":        "string",
+				"description": "For push: the clone's folder in the workspace. For create_pr and changelog: owner/name",
+			},
+			"branch": map[string]any{
+				"type":        "string",
+				"description": "For push: the branch to create, e.g. fix/issue-123",
+			},
+			"message": map[string]any{
+				"type":        "string",
+				"description": "For push: the commit message",
+			},
+			"head": map[string]any{
+				"type":        "string",
+				"description": "For create_pr: the branch with the changes, as returned by push",
+			},
+			"base": map[string]any{
+				"type":        "string",
+				"description": "For create_pr: the branch to merge into (default: the default branch)",
+			},
+			"title": map[string]any{
+				"type":        "string",
+				"description": "For create_pr: the pull request's title",
+			},
+			"body": map[string]any{
+				"type":        "string",
+				"description": "For create_pr: the pull request's description",
+			},
+			"from": map[string]any{
+				"type":        "string",
+				"description": "For changelog: the earlier tag, e.g. v1.2.0",
+			},
+			"to": map[string]any{
+				"type":        "string",
+				"description": "For changelog: the later tag (default: the default branch)",
+			},
+			"draft": map[string]any{
+				"type":        "boolean",
+				"description": "For create_pr: open it as a draft (default true)",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (g *GitHubTool) Metadata() Metadata {
+	return Metadata{Dangerous: true}
+}
+
+func (g *GitHubTool) Requirements() []Requirement {
+	return []Requirement{
+		{Name: "git", Command: "git", Operations: []string{"push"}},
+	}
+}
+
+func (g *GitHubTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	result, err := g.ExecuteRich(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+// ExecuteRich runs the operation, attaching release notes as a document.
+func (g *GitHubTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	operation, _ := args["operation"].(string)
+	if operation == "changelog" {
+		return g.changelog(ctx, args)
+	}
+	text, err := g.execute(ctx, operation, args)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Text: text}, nil
+}
+
+func (g *GitHubTool) execute(ctx context.Context, operation string, args map[string]any) (string, error) {
+	str := func(key string) string {
+		s, _ := args[key].(string)
+		return strings.TrimSpace(s)
+	}
+
+	switch operation {
+	case "issue":
+		issue, err := g.Issue(ctx, str("issue"))
+		if err != nil {
+			return "", err
+		}
+		return issue.String(), nil
+	case "push":
+		if str("repo") == "" || str("branch") == "" || str("message") == "" {
+			return "", fmt.Errorf("repo, branch, and message are required for push")
+		}
+		dir, err := safePath(tenant.Workspace(ctx, g.workspaceDir), str("repo"))
+		if err != nil {
+			return "", err
+		}
+		head, err := g.Push(ctx, dir, str("branch"), str("message"))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Pushed %s. Open a pull request with head=%s.", str("branch"), head), nil
+	case "create_pr":
+		owner, repo, ok := strings.Cut(str("repo"), "/")
+		if !ok || str("head") == "" || str("title") == "" {
+			return "", fmt.Errorf("repo (owner/name), head, and title are required for create_pr")
+		}
+		draft, ok := args["draft"].(bool)
+		if !ok {
+			draft = true
+		}
+		number, link, err := g.CreatePullRequest(ctx, PullRequest{
+			Owner: owner, Repo: repo, Head: str("head"), Base: str("base"),
+			Title: str("title"), Body: str("body"), Draft: draft,
+		})
+		if err != nil {
+			return "", err
+		}
+		kind := "pull request"
+		if draft {
+			kind = "draft pull request"
+		}
+		return fmt.Sprintf("Opened %s #%d: %s", kind, number, link), nil
+	}
+	return "", fmt.Errorf("unknown operation: %s", operation)
+}
+
+// ParseIssue splits an issue URL on this tool's host, or owner/repo#123,
+// into its parts.
+func (g *GitHubTool) ParseIssue(ref string) (owner, repo string, number int, ok bool) {
+	ref = strings.TrimSpace(ref)
+	if m := issueURL.FindStringSubmatch(ref); m != nil && strings.EqualFold(m[1], g.webHost) {
+		number, _ = strconv.Atoi(m[4])
+		return m[2], m[3], number, true
+	}
+	if m := issueShortRef.FindStringSubmatch(ref); m != nil {
+		number, _ = strconv.Atoi(m[3])
+		return m[1], m[2], number, true
+	}
+	return "", "", 0, false
+}
+
+// Issue fetches an issue and its first comments.
+func (g *GitHubTool) Issue(ctx context.Context, ref string) (*Issue, error) {
+	owner, repo, number, ok := g.ParseIssue(ref)
+	if !ok {
+		return nil, fmt.Errorf("%q is not an issue; give its URL or owner/repo#123", ref)
+	}
+	var raw struct {
+		Title   string `json:"title"`
+		State   string `json:"state"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+		PullRequest json.RawMessage `json:"pull_request"`
+	}
+	path := fmt.Sprintf("/repos/%s/%s/issues/%d", owner, repo, number)
+	if err := g.call(ctx, http.MethodGet, path, nil, &raw); err != nil {
+		return nil, fmt.Errorf("fetching %s/%s#%d: %w", owner, repo, number, err)
+	}
+	if raw.PullRequest != nil {
+		return nil, fmt.Errorf("%s/%s#%d is a pull request, not an issue", owner, repo, number)
+	}
+	issue := &Issue{
+		Host: g.webHost, Owner: owner, Repo: repo, Number: number,
+		Title: raw.Title, State: raw.State, Author: raw.User.Login, Body: raw.Body, URL: raw.HTMLURL,
+	}
+	for _, l := range raw.Labels {
+		issue.Labels = append(issue.Labels, l.Name)
+	}
+
+	var comments []struct {
+		Body string `json:"body"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	if err := g.call(ctx, http.MethodGet, path+"/comments?per_page="+strconv.Itoa(maxIssueComments), nil, &comments); err != nil {
+		log.Printf("%s fetching comments on %s/%s#%d: %v", githubLogPrefix, owner, repo, number, err)
+	}
+	for _, c := range comments {
+		issue.Comments = append(issue.Comments, IssueComment{Author: c.User.Login, Body: c.Body})
+	}
+	return issue, nil
+}
+
+// Push commits every change in the clone at dir to a new branch and pushes
+// it to the clone's GitHub repository, or to the token owner's fork of it
+// if the token can't push there. It returns the head to open a pull request
+// from: the branch, or owner:branch on a fork.
+func (g *GitHubTool) Push(ctx context.Context, dir, branch, message string) (string, error) {
+	if g.token == "" {
+		return "", fmt.Errorf("pushing needs a GitHub token (GITHUB_TOKEN)")
+	}
+	if !branchName.MatchString(branch) || strings.Contains(branch, "..") {
+		return "", fmt.Errorf("%q is not a valid branch name", branch)
+	}
+	origin, err := runGit(ctx, dir, "remote", "get-url", "origin")
+	if err != nil {
+		return "", fmt.Errorf("finding the clone's remote: %w", err)
+	}
+	m := remoteURL.FindStringSubmatch(origin)
+	if m == nil || !strings.EqualFold(m[1]+m[2], g.webHost) {
+		return "", fmt.Errorf("%s is not a %s repository", origin, g.webHost)
+	}
+	owner, repo := m[3], m[4]
+
+	var user struct {
+		Login string `json:"login"`
+		ID    int64  `json:"id"`
+		Name  string `json:"name"`
+	}
+	if err := g.call(ctx, http.MethodGet, "/user", nil, &user); err != nil {
+		return "", fmt.Errorf("checking the token's user: %w", err)
+	}
+	var info struct {
+		Permissions struct {
+			Push bool `json:"push"`
+		} `json:"permissions"`
+	}
+	if err := g.call(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s", owner, repo), nil, &info); err != nil {
+		return "", fmt.Errorf("looking up %s/%s: %w", owner, repo, err)
+	}
+	target, head := owner+"/"+repo, branch
+	if !info.Permissions.Push {
+		var fork struct {
+			FullName string `json:"full_name"`
+		}
+		if err := g.call(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/%s/forks", owner, repo), map[string]any{}, &fork); err != nil {
+			return "", fmt.Errorf("forking %s/%s: %w", owner, repo, err)
+		}
+		log.Printf("%s pushing to fork %s", githubLogPrefix, fork.FullName)
+		target, head = fork.FullName, user.Login+":"+branch
+	}
+
+	// Commit as the token's user, with GitHub's private email for them. The
+	// clone's config and hooks may be anyone's, so git only gets the token
+	// for the push itself
+	name := cmp.Or(user.Name, user.Login)
+	email := fmt.Sprintf("%d+%s@users.noreply.%s", user.ID, user.Login, g.webHost)
+	local := WithUntrustedCode(ctx)
+	if _, err := runGit(local, dir, "checkout", "-B", branch); err != nil {
+		return "", err
+	}
+	if _, err := runGit(local, dir, "add", "-A"); err != nil {
+		return "", err
+	}
+	if status, _ := runGit(local, dir, "status", "--porcelain"); status != "" {
+		if _, err := runGit(local, dir, "-c", "user.name="+name, "-c", "user.email="+email, "commit", "--quiet", "-m", message); err != nil {
+			return "", err
+		}
+	} else if ahead, _ := runGit(local, dir, "rev-list", "--count", "origin/HEAD..HEAD"); ahead == "0" {
+		return "", fmt.Errorf("there are no changes to push")
+	}
+
+	pushURL := fmt.Sprintf("https://%s/%s.git", g.webHost, target)
+	for attempt := 1; ; attempt++ {
+		err = g.gitPush(ctx, dir, pushURL, branch)
+		if err == nil || target == owner+"/"+repo || attempt == forkReadyAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(forkReadyDelay):
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("pushing to %s: %w", target, err)
+	}
+	log.Printf("%s pushed %s to %s", githubLogPrefix, branch, target)
+	return head, nil
+}
+
+// gitPush pushes HEAD to a branch, authenticating with the token through
+// git's environment config so it never appears in arguments or remotes.
+func (g *GitHubTool) gitPush(ctx context.Context, dir, pushURL, branch string) error {
+	ctx, cancel := context.WithTimeout(ctx, repoCloneTimeout)
+	defer cancel()
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + g.token))
+	// Hooks and helpers from the clone's config never run with the token
+	cmd := exec.CommandContext(ctx, "git", "-c", "core.hooksPath=/dev/null", "-c", "credential.helper=", "-c", "core.fsmonitor=false",
+		"push", "--quiet", "--force", pushURL, "HEAD:refs/heads/"+branch)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://"+g.webHost+"/.extraheader",
+		"GIT_CONFIG_VALUE_0=AUTHORIZATION: basic "+credentials,
+	)
+	isolate(ctx, cmd, "repo")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(truncateText(strings.ReplaceAll(strings.TrimSpace(string(out)), g.token, "***"), 300))
+	}
+	return nil
+}
+
+// CreatePullRequest opens a pull request and returns its number and URL.
+func (g *GitHubTool) CreatePullRequest(ctx context.Context, pr PullRequest) (int, string, error) {
+	if g.token == "" {
+		return 0, "", fmt.Errorf("opening pull requests needs a GitHub token (GITHUB_TOKEN)")
+	}
+	if pr.Base == "" {
+		var info struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := g.call(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s", pr.Owner, pr.Repo), nil, &info); err != nil {
+			return 0, "", fmt.Errorf("looking up %s/%s: %w", pr.Owner, pr.Repo, err)
+		}
+		pr.Base = info.DefaultBranch
+	}
+	var created struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	body := map[string]any{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base, "draft": pr.Draft}
+	if err := g.call(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/%s/pulls", pr.Owner, pr.Repo), body, &created); err != nil {
+		return 0, "", fmt.Errorf("opening a pull request on %s/%s: %w", pr.Owner, pr.Repo, err)
+	}
+	log.Printf("%s opened %s/%s#%d", githubLogPrefix, pr.Owner, pr.Repo, created.Number)
+	return created.Number, created.HTMLURL, nil
+}
+
+// call sends a request to the API and decodes its JSON response into v.
+func (g *GitHubTool) call(ctx context.Context, method, path string, body, v any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.apiURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			msg := apiErr.Message
+			for _, e := range apiErr.Errors {
+				if e.Message != "" {
+					msg += "; " + e.Message
+				}
+			}
+			return fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+		}
+		return fmt.Errorf("status %d: %s", resp.StatusCode, truncateText(string(data), 200))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	return nil
+}
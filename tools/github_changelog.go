@@ -0,0 +1,371 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxChangelogCommits = 500 // Commits read between two tags
+	maxChangelogPRs     = 60  // Pull requests looked up for their titles and labels
+	changelogPageSize   = 100
+	maxNotesPreview     = 1500 // Chars of the notes shown with the document
+)
+
+var (
+	mergedPR       = regexp.MustCompile(`^Merge pull request #(\d+)`)
+	squashedPR     = regexp.MustCompile(`\s*\(#(\d+)\)$`)
+	conventional   = regexp.MustCompile(`^(\w+)(?:\([^)]*\))?(!)?:\s*(.+)$`)
+	featureVerbs   = regexp.MustCompile(`(?i)^(add|adds|added|implement|introduce|support|allow|enable|new)\b`)
+	fixVerbs       = regexp.MustCompile(`(?i)^(fix|fixes|fixed|resolve|correct|handle|prevent|avoid)\b`)
+	dependencyBump = regexp.MustCompile(`(?i)^(bump|update|upgrade)\b.*\b(from|to) v?\d`)
+	docsWords      = regexp.MustCompile(`(?i)^(docs?|document|readme)\b`)
+	tagNameBad     = regexp.MustCompile(`[^\w.-]+`)
+)
+
+// changeSections are the release notes' sections, in order.
+var changeSections = []string{
+	"Breaking Changes", "Features", "Bug Fixes", "Performance", "Documentation",
+	"Refactoring", "Tests", "Maintenance", "Other Changes",
+}
+
+// conventionalTypes maps conventional commit types to sections.
+var conventionalTypes = map[string]string{
+	"feat": "Features", "feature": "Features", "fix": "Bug Fixes", "bugfix": "Bug Fixes",
+	"perf": "Performance", "docs": "Documentation", "doc": "Documentation",
+	"refactor": "Refactoring", "style": "Refactoring", "test": "Tests", "tests": "Tests",
+	"build": "Maintenance", "ci": "Maintenance", "chore": "Maintenance", "deps": "Maintenance",
+	"revert": "Other Changes",
+}
+
+// labelSections maps common pull request labels to sections; the first
+// label that maps wins.
+var labelSections = map[string]string{
+	"breaking": "Breaking Changes", "breaking change": "Breaking Changes", "breaking-change": "Breaking Changes",
+	"bug": "Bug Fixes", "bugfix": "Bug Fixes", "fix": "Bug Fixes",
+	"enhancement": "Features", "feature": "Features",
+	"performance": "Performance", "perf": "Performance",
+	"documentation": "Documentation", "docs": "Documentation",
+	"refactor": "Refactoring", "tests": "Tests", "test": "Tests",
+	"dependencies": "Maintenance", "ci": "Maintenance", "chore": "Maintenance",
+}
+
+// change is one entry in the release notes: a pull request, or a commit
+// made without one.
+type change struct {
+	Title   string
+	PR      int    // Zero for a commit without a pull request
+	Commit  string // Short SHA, for commits
+	Author  string
+	Labels  []string
+	Section string
+}
+
+// changelog drafts release notes for the changes between two tags.
+func (g *GitHubTool) changelog(ctx context.Context, args map[string]any) (*Result, error) {
+	str := func(key string) string {
+		s, _ := args[key].(string)
+		return strings.TrimSpace(s)
+	}
+	owner, repo, ok := splitRepo(str("repo"), g.webHost)
+	if !ok {
+		return nil, fmt.Errorf("repo (owner/name) is required for changelog")
+	}
+	from, to, err := g.changelogRange(ctx, owner, repo, str("from"), str("to"))
+	if err != nil {
+		return nil, err
+	}
+
+	changes, commits, err := g.changes(ctx, owner, repo, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return &Result{Text: fmt.Sprintf("There are no changes in %s/%s between %s and %s.", owner, repo, from, to)}, nil
+	}
+	prs := 0
+	for _, c := range changes {
+		if c.PR != 0 {
+			prs++
+		}
+	}
+
+	heading := fmt.Sprintf("# %s %s", repo, to)
+	list := changeList(changes)
+	notes := g.draftNotes(ctx, owner+"/"+repo, from, to, heading, list)
+	name := fmt.Sprintf("%s-%s-release-notes.md", repo, strings.Trim(tagNameBad.ReplaceAllString(to, "_"), "_"))
+	summary := fmt.Sprintf("📝 Release notes for %s/%s from %s to %s (%d commits, %d pull requests), attached as %s:\n\n%s",
+		owner, repo, from, to, commits, prs, name, truncateText(notes, maxNotesPreview))
+	return &Result{
+		Text:        summary,
+		Attachments: []Attachment{{Name: name, Data: []byte(notes), Kind: AttachDocument}},
+	}, nil
+}
+
+// splitRepo accepts owner/name or a repository URL on host.
+func splitRepo(s, host string) (owner, repo string, ok bool) {
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/"), ".git")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), host+"/")
+	owner, repo, ok = strings.Cut(s, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return "", "", false
+	}
+	return owner, repo, true
+}
+
+// changelogRange fills in the tags to compare: the two latest without
+// either, and the default branch without to.
+func (g *GitHubTool) changelogRange(ctx context.Context, owner, repo, from, to string) (string, string, error) {
+	if from == "" && to != "" {
+		return "", "", fmt.Errorf("from is required with to")
+	}
+	if from == "" {
+		var tags []struct {
+			Name string `json:"name"`
+		}
+		if err := g.call(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s/tags?per_page=2", owner, repo), nil, &tags); err != nil {
+			return "", "", fmt.Errorf("listing tags of %s/%s: %w", owner, repo, err)
+		}
+		switch len(tags) {
+		case 0:
+			return "", "", fmt.Errorf("%s/%s has no tags; give from and to", owner, repo)
+		case 1:
+			from = tags[0].Name
+		default:
+			from, to = tags[1].Name, tags[0].Name
+		}
+	}
+	if to == "" {
+		var info struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := g.call(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s", owner, repo), nil, &info); err != nil {
+			return "", "", fmt.Errorf("looking up %s/%s: %w", owner, repo, err)
+		}
+		to = info.DefaultBranch
+	}
+	return from, to, nil
+}
+
+// changes lists the pull requests and direct commits between two refs,
+// with the number of commits read. Commits merged with a pull request are
+// listed as the pull request, with its title and labels.
+func (g *GitHubTool) changes(ctx context.Context, owner, repo, from, to string) ([]change, int, error) {
+	type commit struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Message string `json:"message"`
+			Author  struct {
+				Name string `json:"name"`
+			} `json:"author"`
+		} `json:"commit"`
+		Author *struct {
+			Login string `json:"login"`
+		} `json:"author"`
+		Parents []struct {
+			SHA string `json:"sha"`
+		} `json:"parents"`
+	}
+	var commits []commit
+	total := 0
+	for page := 1; len(commits) < maxChangelogCommits; page++ {
+		var compared struct {
+			TotalCommits int      `json:"total_commits"`
+			Commits      []commit `json:"commits"`
+		}
+		path := fmt.Sprintf("/repos/%s/%s/compare/%s...%s?per_page=%d&page=%d",
+			owner, repo, url.PathEscape(from), url.PathEscape(to), changelogPageSize, page)
+		if err := g.call(ctx, http.MethodGet, path, nil, &compared); err != nil {
+			return nil, 0, fmt.Errorf("comparing %s...%s: %w", from, to, err)
+		}
+		total = compared.TotalCommits
+		commits = append(commits, compared.Commits...)
+		if len(compared.Commits) < changelogPageSize || len(commits) >= total {
+			break
+		}
+	}
+
+	// Follow first parents back from the newest commit: the others came in
+	// with a merge. If the range was cut short, every commit counts.
+	inRange := make(map[string]commit, len(commits))
+	for _, c := range commits {
+		inRange[c.SHA] = c
+	}
+	mainline := make(map[string]bool)
+	if len(commits) >= total && len(commits) > 0 {
+		for c, ok := commits[len(commits)-1], true; ok && !mainline[c.SHA]; {
+			mainline[c.SHA] = true
+			if len(c.Parents) == 0 {
+				break
+			}
+			c, ok = inRange[c.Parents[0].SHA]
+		}
+	}
+
+	var changes []change
+	seen := make(map[int]bool)
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := commits[i]
+		if len(mainline) > 0 && !mainline[c.SHA] {
+			continue
+		}
+		first, _, _ := strings.Cut(strings.TrimSpace(c.Commit.Message), "\n")
+		ch := change{Title: first, Commit: c.SHA[:min(7, len(c.SHA))], Author: c.Commit.Author.Name}
+		if c.Author != nil && c.Author.Login != "" {
+			ch.Author = "@" + c.Author.Login
+		}
+		if m := mergedPR.FindStringSubmatch(first); m != nil {
+			ch.PR, _ = strconv.Atoi(m[1])
+			if _, body, ok := strings.Cut(strings.TrimSpace(c.Commit.Message), "\n\n"); ok {
+				ch.Title, _, _ = strings.Cut(strings.TrimSpace(body), "\n")
+			}
+		} else if len(c.Parents) > 1 {
+			continue // Merges of other branches say nothing themselves
+		} else if m := squashedPR.FindStringSubmatch(first); m != nil {
+			ch.PR, _ = strconv.Atoi(m[1])
+			ch.Title = strings.TrimSpace(strings.TrimSuffix(first, m[0]))
+		}
+		if ch.PR != 0 {
+			if seen[ch.PR] {
+				continue
+			}
+			seen[ch.PR] = true
+		}
+		changes = append(changes, ch)
+	}
+
+	// Pull requests' own titles and labels are better than commit messages
+	looked := 0
+	for i := range changes {
+		if changes[i].PR == 0 || looked >= maxChangelogPRs {
+			continue
+		}
+		looked++
+		var pr struct {
+			Title string `json:"title"`
+			User  struct {
+				Login string `json:"login"`
+			} `json:"user"`
+			Labels []struct {
+				Name string `json:"name"`
+			} `json:"labels"`
+		}
+		if err := g.call(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, repo, changes[i].PR), nil, &pr); err != nil {
+			log.Printf("%s looking up %s/%s#%d: %v", githubLogPrefix, owner, repo, changes[i].PR, err)
+			continue
+		}
+		changes[i].Title = cmp.Or(strings.TrimSpace(pr.Title), changes[i].Title)
+		changes[i].Author = "@" + pr.User.Login
+		for _, l := range pr.Labels {
+			changes[i].Labels = append(changes[i].Labels, l.Name)
+		}
+	}
+	for i := range changes {
+		changes[i].Section, changes[i].Title = classifyChange(changes[i].Title, changes[i].Labels)
+	}
+	return changes, len(commits), nil
+}
+
+// classifyChange picks a change's section from its labels, a conventional
+// commit prefix, or the verb it starts with, and returns the title without
+// the prefix.
+func classifyChange(title string, labels []string) (section, cleaned string) {
+	cleaned = title
+	if m := conventional.FindStringSubmatch(title); m != nil {
+		if s, ok := conventionalTypes[strings.ToLower(m[1])]; ok {
+			section, cleaned = s, m[3]
+			if r, size := utf8.DecodeRuneInString(cleaned); unicode.IsLower(r) {
+				cleaned = string(unicode.ToUpper(r)) + cleaned[size:]
+			}
+			if m[2] == "!" {
+				section = "Breaking Changes"
+			}
+		}
+	}
+	for _, l := range labels {
+		if s, ok := labelSections[strings.ToLower(l)]; ok && (section == "" || s == "Breaking Changes") {
+			section = s
+			break
+		}
+	}
+	if section != "" {
+		return section, cleaned
+	}
+	switch {
+	case dependencyBump.MatchString(cleaned):
+		return "Maintenance", cleaned
+	case fixVerbs.MatchString(cleaned):
+		return "Bug Fixes", cleaned
+	case featureVerbs.MatchString(cleaned):
+		return "Features", cleaned
+	case docsWords.MatchString(cleaned):
+		return "Documentation", cleaned
+	}
+	return "Other Changes", cleaned
+}
+
+// changeList renders changes as markdown sections, in changeSections
+// order.
+func changeList(changes []change) string {
+	var sb strings.Builder
+	for _, section := range changeSections {
+		var lines []string
+		for _, c := range changes {
+			if c.Section != section {
+				continue
+			}
+			ref := c.Commit
+			if c.PR != 0 {
+				ref = "#" + strconv.Itoa(c.PR)
+			}
+			line := fmt.Sprintf("- %s (%s)", c.Title, ref)
+			if c.Author != "" {
+				line += " " + c.Author
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "## %s\n\n%s\n\n", section, strings.Join(lines, "\n"))
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// draftNotes has the model write release notes from the grouped changes.
+// Without a model, or if it fails, the grouped list is the notes.
+func (g *GitHubTool) draftNotes(ctx context.Context, repo, from, to, heading, list string) string {
+	plain := heading + "\n\n" + list + "\n"
+	if g.scrape == nil {
+		return plain
+	}
+	prompt := fmt.Sprintf(`Write release notes in Markdown for %s %s, covering the changes since %s listed below.
+
+Start with the heading "%s", then two or three sentences on the highlights for users. Then keep the sections below in the same order, rewriting each entry as one short line a user would understand. Keep each entry's (#123) or commit reference and author. Merge entries that describe the same change. Don't add changes that aren't listed, and don't add a closing remark.
+
+%s
+
+Release notes:`, repo, to, from, heading, list)
+
+	notes, err := g.scrape.generate(ctx, prompt)
+	notes = strings.TrimSpace(notes)
+	if err != nil || !slices.ContainsFunc(strings.Split(notes, "\n"), func(line string) bool { return strings.HasPrefix(line, "#") }) {
+		if err != nil {
+			log.Printf("%s drafting release notes for %s: %v", githubLogPrefix, repo, err)
+		}
+		return plain
+	}
+	// Models sometimes fence the whole answer
+	notes = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(notes, "```markdown"), "```"), "```"))
+	return notes + "\n"
+}
@@ -0,0 +1,661 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/tenant"
+)
+
+const (
+	defaultHealthDir    = "uploads"
+	maxHealthListed     = 20
+	workoutDedupeWindow = 5 * time.Minute // Same workout synced to two services
+)
+
+// HealthTool answers questions about workouts and steps from Garmin,
+// Strava, and Apple Health exports uploaded to the workspace, and charts
+// trends with the python tool.
+type HealthTool struct {
+	python   *PythonTool
+	dir      string // Where exports are read from, relative to the workspace
+	imperial bool   // Miles instead of kilometers
+
+	mu    sync.Mutex
+	cache map[string]cachedHealthFile // By path
+
+	tenants *tenantCopies[*HealthTool] // Nil on the copies themselves
+}
+
+// cachedHealthFile is a parsed export, reused until the file changes.
+type cachedHealthFile struct {
+	modTime time.Time
+	size    int64
+	data    *healthData
+}
+
+// NewHealthTool creates a health tool that reads exports from dir inside
+// the python tool's workspace.
+func NewHealthTool(python *PythonTool, dir string, imperial bool) *HealthTool {
+	if dir == "" {
+		dir = defaultHealthDir
+	}
+	return &HealthTool{
+		python:   python,
+		dir:      filepath.Clean(dir),
+		imperial: imperial,
+		cache:    make(map[string]cachedHealthFile),
+		tenants:  newTenantCopies[*HealthTool](),
+	}
+}
+
+// forTenant returns the health tool for the request's tenant, reading the
+// exports in its workspace.
+func (h *HealthTool) forTenant(ctx context.Context) *HealthTool {
+	return h.tenants.get(ctx, h, func(t *tenant.Tenant) *HealthTool {
+		return &HealthTool{python: h.python.forTenant(ctx), dir: h.dir, imperial: h.imperial, cache: make(map[string]cachedHealthFile)}
+	})
+}
+
+func (h *HealthTool) Name() string {
+	return "health"
+}
+
+func (h *HealthTool) Description() string {
+	return fmt.Sprintf(`Answer questions about the user's workouts and steps, from Garmin or Strava
+activity CSVs and Apple Health exports they uploaded to %s/ in the workspace.
+
+operation=summary totals distance, time, and pace over a range ("this week", "last
+month", "last 30 days", "2026-03-01 to 2026-03-31"; default this week), optionally
+for one type (run, ride, walk, hike, swim).
+operation=list shows the individual workouts in a range, newest first.
+operation=chart draws metric (distance, duration, count, calories, steps) per period
+(day, week, month) over a range (default last 12 weeks) and sends the image.
+operation=files lists the exports found.`, h.dir)
+}
+
+func (h *HealthTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"summary", "list", "chart", "files"},
+				"description": "What to do",
+			},
+			"range": map[string]any{
+				"type":        "string",
+				"description": `Dates to cover, e.g. "this week", "last month", "last 6 months", "this year"`,
+			},
+			"type": map[string]any{
+				"type":        "string",
+				"description": "Only this kind of workout: run, ride, walk, hike, swim, or another activity name",
+			},
+			"metric": map[string]any{
+				"type":        "string",
+				"enum":        []string{"distance", "duration", "count", "calories", "steps"},
+				"description": "For chart: what to plot (default distance)",
+			},
+			"period": map[string]any{
+				"type":        "string",
+				"enum":        []string{"day", "week", "month"},
+				"description": "For chart: one bar per day, week, or month (default week)",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (h *HealthTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostLow}
+}
+
+// Requirements turns off charts when matplotlib can't be imported.
+func (h *HealthTool) Requirements() []Requirement {
+	return []Requirement{
+		{Name: "matplotlib", Command: hostPython(), Check: pythonModule(h.python.interpreter, "matplotlib"), Operations: []string{"chart"}},
+	}
+}
+
+func (h *HealthTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	result, err := h.ExecuteRich(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+// ExecuteRich runs the operation, attaching the image for charts.
+func (h *HealthTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	h = h.forTenant(ctx)
+	operation, _ := args["operation"].(string)
+	if operation == "files" {
+		text, err := h.files()
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Text: text}, nil
+	}
+
+	workouts, steps, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+	defaultRange := "this week"
+	if operation == "chart" {
+		defaultRange = "last 12 weeks"
+	}
+	phrase, _ := args["range"].(string)
+	phrase = cmp.Or(strings.TrimSpace(phrase), defaultRange)
+	r, err := parseDateRange(phrase, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	kind, _ := args["type"].(string)
+	workouts = filterWorkouts(workouts, r, kind)
+
+	switch operation {
+	case "summary":
+		return &Result{Text: h.summary(workouts, steps, r, phrase, kind)}, nil
+	case "list":
+		return &Result{Text: h.list(workouts, phrase, kind)}, nil
+	case "chart":
+		metric, _ := args["metric"].(string)
+		period, _ := args["period"].(string)
+		return h.chart(ctx, workouts, steps, r, cmp.Or(metric, "distance"), cmp.Or(period, "week"), kind)
+	default:
+		return nil, fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+func (h *HealthTool) root() string {
+	return filepath.Join(h.python.workspaceDir, h.dir)
+}
+
+// exports lists the files in the upload folder that might be exports.
+func (h *HealthTool) exports() ([]string, error) {
+	entries, err := os.ReadDir(h.root())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", h.dir, err)
+	}
+	var paths []string
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".csv", ".xml", ".zip":
+			if !e.IsDir() {
+				paths = append(paths, filepath.Join(h.root(), e.Name()))
+			}
+		}
+	}
+	return paths, nil
+}
+
+// parse reads an export, or returns the cached result if the file hasn't
+// changed. Apple Health exports in particular are slow to read.
+func (h *HealthTool) parse(path string) (*healthData, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	cached, ok := h.cache[path]
+	h.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.data, nil
+	}
+
+	data, err := parseHealthFile(path, h.imperial)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.cache[path] = cachedHealthFile{modTime: info.ModTime(), size: info.Size(), data: data}
+	h.mu.Unlock()
+	return data, nil
+}
+
+// load reads every export, merging workouts that were recorded once but
+// synced to more than one service.
+func (h *HealthTool) load() ([]workout, map[string]float64, error) {
+	paths, err := h.exports()
+	if err != nil {
+		return nil, nil, err
+	}
+	var workouts []workout
+	steps := make(map[string]float64)
+	found := false
+	for _, path := range paths {
+		data, err := h.parse(path)
+		if errors.Is(err, errNotHealthData) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[health] Skipping %s: %v", filepath.Base(path), err)
+			continue
+		}
+		found = true
+		workouts = append(workouts, data.Workouts...)
+		for day, n := range data.Steps {
+			steps[day] = max(steps[day], n)
+		}
+	}
+	if !found {
+		return nil, nil, fmt.Errorf("no health exports in %s/; send a Garmin or Strava activities CSV or an Apple Health export.zip to the bot first", h.dir)
+	}
+
+	// Oldest first, so a duplicate is always compared with the copy kept
+	slices.SortFunc(workouts, func(a, b workout) int { return a.Start.Compare(b.Start) })
+	lastKept := make(map[string]time.Time) // By type
+	var merged []workout
+	for _, w := range workouts {
+		if last, ok := lastKept[w.Type]; ok && w.Start.Sub(last) < workoutDedupeWindow {
+			continue
+		}
+		lastKept[w.Type] = w.Start
+		merged = append(merged, w)
+	}
+	slices.Reverse(merged)
+	return merged, steps, nil
+}
+
+func filterWorkouts(workouts []workout, r dateRange, kind string) []workout {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind != "" {
+		kind = workoutType(kind)
+	}
+	var matched []workout
+	for _, w := range workouts {
+		if w.Start.Before(r.Start) || !w.Start.Before(r.End) {
+			continue
+		}
+		if kind != "" && w.Type != kind {
+			continue
+		}
+		matched = append(matched, w)
+	}
+	return matched
+}
+
+func (h *HealthTool) summary(workouts []workout, steps map[string]float64, r dateRange, phrase, kind string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s to %s)\n", capitalize(phrase), r.Start.Format("Mon Jan 2"), r.End.Add(-time.Nanosecond).Format("Mon Jan 2"))
+
+	if len(workouts) == 0 {
+		if kind != "" {
+			fmt.Fprintf(&b, "No %s workouts.\n", workoutType(kind))
+		} else {
+			b.WriteString("No workouts.\n")
+		}
+	}
+
+	byType := make(map[string][]workout)
+	for _, w := range workouts {
+		byType[w.Type] = append(byType[w.Type], w)
+	}
+	types := make([]string, 0, len(byType))
+	for t := range byType {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	slices.SortStableFunc(types, func(a, b string) int { return cmp.Compare(len(byType[b]), len(byType[a])) })
+
+	for _, t := range types {
+		group := byType[t]
+		var distance, calories float64
+		var duration time.Duration
+		for _, w := range group {
+			distance += w.Distance
+			duration += w.Duration
+			calories += w.Calories
+		}
+		fmt.Fprintf(&b, "%s: %d, %s", capitalize(workoutLabel(t, len(group))), len(group), formatTravelTime(duration))
+		if distance > 0 {
+			fmt.Fprintf(&b, ", %s", h.formatDistance(t, distance))
+			if pace := h.formatPace(t, distance, duration); pace != "" {
+				fmt.Fprintf(&b, ", %s", pace)
+			}
+		}
+		if calories > 0 {
+			fmt.Fprintf(&b, ", %s kcal", formatCount(calories))
+		}
+		b.WriteString("\n")
+	}
+
+	if kind == "" && len(steps) > 0 {
+		var total float64
+		days := 0
+		for day := r.Start; day.Before(r.End) && !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+			if n, ok := steps[day.Format(time.DateOnly)]; ok {
+				total += n
+				days++
+			}
+		}
+		if days > 0 {
+			fmt.Fprintf(&b, "Steps: %s (%s a day over %d days)\n", formatCount(total), formatCount(total/float64(days)), days)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (h *HealthTool) list(workouts []workout, phrase, kind string) string {
+	if len(workouts) == 0 {
+		if kind != "" {
+			return fmt.Sprintf("No %s workouts %s.", workoutType(kind), phrase)
+		}
+		return fmt.Sprintf("No workouts %s.", phrase)
+	}
+	var b strings.Builder
+	for i, w := range workouts {
+		if i == maxHealthListed {
+			fmt.Fprintf(&b, "... and %d more\n", len(workouts)-i)
+			break
+		}
+		fmt.Fprintf(&b, "%s  %s", w.Start.In(time.Local).Format("Mon Jan 2 15:04"), w.Type)
+		if w.Name != "" && !strings.EqualFold(w.Name, w.Type) {
+			fmt.Fprintf(&b, " %q", w.Name)
+		}
+		fmt.Fprintf(&b, ": %s", formatTravelTime(w.Duration))
+		if w.Distance > 0 {
+			fmt.Fprintf(&b, ", %s", h.formatDistance(w.Type, w.Distance))
+			if pace := h.formatPace(w.Type, w.Distance, w.Duration); pace != "" {
+				fmt.Fprintf(&b, ", %s", pace)
+			}
+		}
+		if w.HeartRate > 0 {
+			fmt.Fprintf(&b, ", %d bpm", int(w.HeartRate))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// chart totals the metric per period and plots it with matplotlib in the
+// python tool's sandbox.
+func (h *HealthTool) chart(ctx context.Context, workouts []workout, steps map[string]float64, r dateRange, metric, period, kind string) (*Result, error) {
+	var labels []string
+	var values []float64
+	index := make(map[time.Time]int)
+	for start := periodStart(r.Start, period); start.Before(r.End); start = nextPeriod(start, period) {
+		index[start] = len(values)
+		labels = append(labels, periodLabel(start, period))
+		values = append(values, 0)
+	}
+
+	unit := ""
+	switch metric {
+	case "steps":
+		if len(steps) == 0 {
+			return nil, fmt.Errorf("no step counts in the exports; steps come from Apple Health")
+		}
+		for day, n := range steps {
+			t, err := time.ParseInLocation(time.DateOnly, day, time.Local)
+			if err != nil || t.Before(r.Start) || !t.Before(r.End) {
+				continue
+			}
+			if i, ok := index[periodStart(t, period)]; ok {
+				values[i] += n
+			}
+		}
+	case "distance", "duration", "count", "calories":
+		for _, w := range workouts {
+			i, ok := index[periodStart(w.Start.In(time.Local), period)]
+			if !ok {
+				continue
+			}
+			switch metric {
+			case "distance":
+				values[i] += h.distanceIn(w.Distance)
+			case "duration":
+				values[i] += w.Duration.Hours()
+			case "count":
+				values[i]++
+			case "calories":
+				values[i] += w.Calories
+			}
+		}
+		switch metric {
+		case "distance":
+			unit = "km"
+			if h.imperial {
+				unit = "mi"
+			}
+		case "duration":
+			unit = "hours"
+		case "calories":
+			unit = "kcal"
+		}
+	default:
+		return nil, fmt.Errorf("unknown metric: %s", metric)
+	}
+
+	subject := "Workouts"
+	if kind != "" && metric != "steps" {
+		subject = capitalize(workoutLabel(workoutType(kind), 2))
+	}
+	title := fmt.Sprintf("%s: %s per %s", subject, metric, period)
+	if metric == "steps" {
+		title = "Steps per " + period
+	}
+	ylabel := metric
+	if unit != "" {
+		ylabel = fmt.Sprintf("%s (%s)", metric, unit)
+	}
+
+	relDir := filepath.Join(h.dir, "charts")
+	if err := os.MkdirAll(filepath.Join(h.python.workspaceDir, relDir), 0755); err != nil {
+		return nil, fmt.Errorf("creating chart folder: %w", err)
+	}
+	file := filepath.Join(relDir, fmt.Sprintf("%s-%s-%s.png", metric, period, time.Now().Format("20060102-150405")))
+	spec, err := json.Marshal(map[string]any{
+		"labels": labels, "values": values, "title": title, "ylabel": ylabel, "file": file,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding chart data: %w", err)
+	}
+
+	result, err := h.python.ExecuteRich(ctx, map[string]any{
+		"operation": "run",
+		"code":      fmt.Sprintf(healthChartScript, strconv.Quote(string(spec))),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("drawing chart: %w", err)
+	}
+	if len(result.Attachments) == 0 {
+		return nil, fmt.Errorf("drawing chart: %s", truncateText(result.Text, 500))
+	}
+
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	text := fmt.Sprintf("%s, %s to %s. Total %s", title, labels[0], labels[len(labels)-1], strconv.FormatFloat(math.Round(total*10)/10, 'f', -1, 64))
+	if unit != "" {
+		text += " " + unit
+	}
+	return &Result{Text: text + ". The chart image is attached.", Attachments: result.Attachments}, nil
+}
+
+// healthChartScript draws a bar chart from JSON with labels, values,
+// title, ylabel, and the file to save to.
+const healthChartScript = `import json
+import matplotlib.pyplot as plt
+
+spec = json.loads(%s)
+fig, ax = plt.subplots(figsize=(10, 5))
+ax.bar(range(len(spec["values"])), spec["values"], color="#4c72b0")
+step = max(1, len(spec["labels"]) // 16)
+ax.set_xticks(range(0, len(spec["labels"]), step))
+ax.set_xticklabels(spec["labels"][::step], rotation=45, ha="right")
+ax.set_ylabel(spec["ylabel"])
+ax.set_title(spec["title"])
+ax.grid(axis="y", alpha=0.3)
+fig.tight_layout()
+fig.savefig(spec["file"], dpi=120)
+print("saved", spec["file"])
+`
+
+func (h *HealthTool) files() (string, error) {
+	paths, err := h.exports()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, path := range paths {
+		data, err := h.parse(path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s, %d workouts", filepath.Base(path), data.Format, len(data.Workouts))
+		if len(data.Workouts) > 0 {
+			first, last := data.Workouts[0].Start, data.Workouts[0].Start
+			for _, w := range data.Workouts {
+				first, last = minTime(first, w.Start), maxTime(last, w.Start)
+			}
+			fmt.Fprintf(&b, " (%s to %s)", first.Format(time.DateOnly), last.Format(time.DateOnly))
+		}
+		if len(data.Steps) > 0 {
+			fmt.Fprintf(&b, ", steps for %d days", len(data.Steps))
+		}
+		b.WriteString("\n")
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("No health exports in %s/. Send a Garmin or Strava activities CSV or an Apple Health export.zip to the bot.", h.dir), nil
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (h *HealthTool) distanceIn(meters float64) float64 {
+	if h.imperial {
+		return meters / metersPerMile
+	}
+	return meters / metersPerKm
+}
+
+// formatDistance writes swims in meters or yards and everything else in
+// kilometers or miles.
+func (h *HealthTool) formatDistance(kind string, meters float64) string {
+	switch {
+	case kind == "swim" && h.imperial:
+		return fmt.Sprintf("%s yd", formatCount(meters/metersPerYard))
+	case kind == "swim":
+		return fmt.Sprintf("%s m", formatCount(meters))
+	case h.imperial:
+		return fmt.Sprintf("%.1f mi", meters/metersPerMile)
+	}
+	return fmt.Sprintf("%.1f km", meters/metersPerKm)
+}
+
+// formatPace gives rides a speed, swims a time per 100 m (or yd), and
+// everything on foot a time per km (or mile).
+func (h *HealthTool) formatPace(kind string, meters float64, d time.Duration) string {
+	if meters <= 0 || d <= 0 {
+		return ""
+	}
+	switch kind {
+	case "ride":
+		if h.imperial {
+			return fmt.Sprintf("%.1f mph", meters/metersPerMile/d.Hours())
+		}
+		return fmt.Sprintf("%.1f km/h", meters/metersPerKm/d.Hours())
+	case "swim":
+		if h.imperial {
+			return formatMinutes(d.Seconds()/(meters/metersPerYard/100)) + " /100 yd"
+		}
+		return formatMinutes(d.Seconds()/(meters/100)) + " /100 m"
+	case "run", "walk", "hike":
+		if h.imperial {
+			return formatMinutes(d.Seconds()/(meters/metersPerMile)) + " /mi"
+		}
+		return formatMinutes(d.Seconds()/(meters/metersPerKm)) + " /km"
+	}
+	return ""
+}
+
+// formatMinutes writes seconds as m:ss.
+func formatMinutes(seconds float64) string {
+	s := int(math.Round(seconds))
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func formatCount(n float64) string {
+	s := strconv.Itoa(int(math.Round(n)))
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// workoutLabel names a type of workout, in the plural when n isn't 1.
+func workoutLabel(kind string, n int) string {
+	plural := map[string]string{"run": "runs", "ride": "rides", "walk": "walks", "hike": "hikes", "swim": "swims"}
+	if p, ok := plural[kind]; ok && n != 1 {
+		return p
+	}
+	return kind
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func periodStart(t time.Time, period string) time.Time {
+	day := startOfDay(t)
+	switch period {
+	case "week":
+		return week(day, 0).Start
+	case "month":
+		return month(day, 0).Start
+	default:
+		return day
+	}
+}
+
+func nextPeriod(t time.Time, period string) time.Time {
+	switch period {
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+func periodLabel(t time.Time, period string) string {
+	if period == "month" {
+		return t.Format("Jan 2006")
+	}
+	return t.Format("Jan 2")
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,371 @@
+package tools
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lengths in meters, for the units exports use.
+const (
+	metersPerKm   = 1000.0
+	metersPerMile = 1609.344
+	metersPerYard = 0.9144
+	metersPerFoot = 0.3048
+)
+
+// errNotHealthData marks files that aren't an export the health tool knows.
+var errNotHealthData = errors.New("not a recognized health export")
+
+// workout is one recorded activity, whichever service it came from.
+type workout struct {
+	Start     time.Time
+	Type      string // run, ride, walk, hike, swim, or the service's own name
+	Name      string
+	Distance  float64 // Meters
+	Duration  time.Duration
+	Calories  float64
+	HeartRate float64 // Average bpm; zero if unknown
+	Source    string  // garmin, strava, or apple
+}
+
+// healthData is what one export file holds.
+type healthData struct {
+	Format   string // Garmin, Strava, or Apple Health
+	Workouts []workout
+	// Steps maps days (2006-01-02) to step counts. Apple Health records
+	// steps from each device separately, so the device that counted the
+	// most is used for each day.
+	Steps map[string]float64
+}
+
+// parseHealthFile reads a Garmin or Strava activities CSV, an Apple Health
+// export.xml, or a zip of one of them as the services export it.
+func parseHealthFile(path string, imperial bool) (*healthData, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		return parseHealthZip(path, imperial)
+	case ".csv", ".xml":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseHealthReader(f, filepath.Base(path), imperial)
+	default:
+		return nil, errNotHealthData
+	}
+}
+
+func parseHealthZip(path string, imperial bool) (*healthData, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening zip: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		name := filepath.Base(f.Name)
+		if name != "export.xml" && name != "activities.csv" && !strings.HasSuffix(strings.ToLower(name), ".csv") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", f.Name, err)
+		}
+		data, err := parseHealthReader(r, name, imperial)
+		r.Close()
+		if errors.Is(err, errNotHealthData) {
+			continue
+		}
+		return data, err
+	}
+	return nil, errNotHealthData
+}
+
+func parseHealthReader(r io.Reader, name string, imperial bool) (*healthData, error) {
+	if strings.HasSuffix(strings.ToLower(name), ".xml") {
+		return parseAppleHealth(r)
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, errNotHealthData
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	switch {
+	case slices.Contains(header, "Activity ID") && slices.Contains(header, "Activity Date"):
+		return parseStravaCSV(cr, header)
+	case slices.Contains(header, "Activity Type") && slices.Contains(header, "Date") && slices.Contains(header, "Time"):
+		return parseGarminCSV(cr, header, imperial)
+	}
+	return nil, errNotHealthData
+}
+
+// csvColumns finds columns by name. Strava repeats some names (Distance
+// in km, then in meters), so the first one wins.
+type csvColumns map[string]int
+
+func newCSVColumns(header []string) csvColumns {
+	cols := make(csvColumns)
+	for i, name := range header {
+		if _, ok := cols[name]; !ok {
+			cols[name] = i
+		}
+	}
+	return cols
+}
+
+func (c csvColumns) get(record []string, name string) string {
+	if i, ok := c[name]; ok && i < len(record) {
+		return strings.TrimSpace(record[i])
+	}
+	return ""
+}
+
+// number parses numbers as exports write them: "1,234.5", with "--" or
+// blank for none.
+func (c csvColumns) number(record []string, name string) float64 {
+	n, _ := strconv.ParseFloat(strings.ReplaceAll(c.get(record, name), ",", ""), 64)
+	return n
+}
+
+// parseStravaCSV reads activities.csv from a Strava bulk export. Dates are
+// UTC, distances km, and times seconds.
+func parseStravaCSV(cr *csv.Reader, header []string) (*healthData, error) {
+	cols := newCSVColumns(header)
+	data := &healthData{Format: "Strava"}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading Strava CSV: %w", err)
+		}
+		start, err := time.Parse("Jan 2, 2006, 3:04:05 PM", cols.get(record, "Activity Date"))
+		if err != nil {
+			continue
+		}
+		seconds := cols.number(record, "Moving Time")
+		if seconds == 0 {
+			seconds = cols.number(record, "Elapsed Time")
+		}
+		data.Workouts = append(data.Workouts, workout{
+			Start:     start,
+			Type:      workoutType(cols.get(record, "Activity Type")),
+			Name:      cols.get(record, "Activity Name"),
+			Distance:  cols.number(record, "Distance") * metersPerKm,
+			Duration:  time.Duration(seconds * float64(time.Second)),
+			Calories:  cols.number(record, "Calories"),
+			HeartRate: cols.number(record, "Average Heart Rate"),
+			Source:    "strava",
+		})
+	}
+	return data, nil
+}
+
+// parseGarminCSV reads the activities list exported from Garmin Connect.
+// Distances are in the account's display units, except pool swims, which
+// are in meters or yards.
+func parseGarminCSV(cr *csv.Reader, header []string, imperial bool) (*healthData, error) {
+	cols := newCSVColumns(header)
+	unit, swimUnit := metersPerKm, 1.0
+	if imperial {
+		unit, swimUnit = metersPerMile, metersPerYard
+	}
+	data := &healthData{Format: "Garmin"}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading Garmin CSV: %w", err)
+		}
+		start, err := time.ParseInLocation("2006-01-02 15:04:05", cols.get(record, "Date"), time.Local)
+		if err != nil {
+			continue
+		}
+		w := workout{
+			Start:     start,
+			Type:      workoutType(cols.get(record, "Activity Type")),
+			Name:      cols.get(record, "Title"),
+			Duration:  parseClockDuration(cols.get(record, "Time")),
+			Calories:  cols.number(record, "Calories"),
+			HeartRate: cols.number(record, "Avg HR"),
+			Source:    "garmin",
+		}
+		w.Distance = cols.number(record, "Distance") * unit
+		if w.Type == "swim" {
+			w.Distance = cols.number(record, "Distance") * swimUnit
+		}
+		data.Workouts = append(data.Workouts, w)
+	}
+	return data, nil
+}
+
+// parseClockDuration parses "1:02:03", "02:03", or "01:02:03.4".
+func parseClockDuration(s string) time.Duration {
+	var d time.Duration
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		d = d*60 + time.Duration(n*float64(time.Second))
+	}
+	return d
+}
+
+// parseAppleHealth streams an Apple Health export.xml, which can be
+// hundreds of megabytes, picking out workouts and step counts.
+func parseAppleHealth(r io.Reader) (*healthData, error) {
+	dec := xml.NewDecoder(r)
+	data := &healthData{Format: "Apple Health", Steps: make(map[string]float64)}
+	stepsBySource := make(map[[2]string]float64) // day, device
+	var current *workout
+	seenRoot := false
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !seenRoot {
+				return nil, errNotHealthData
+			}
+			return nil, fmt.Errorf("reading Apple Health export: %w", err)
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			attrs := make(map[string]string, len(el.Attr))
+			for _, a := range el.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			switch el.Name.Local {
+			case "HealthData":
+				seenRoot = true
+			case "Record":
+				if attrs["type"] != "HKQuantityTypeIdentifierStepCount" {
+					continue
+				}
+				start, err := time.Parse(appleTimeLayout, attrs["startDate"])
+				if err != nil {
+					continue
+				}
+				n, _ := strconv.ParseFloat(attrs["value"], 64)
+				stepsBySource[[2]string{start.In(time.Local).Format(time.DateOnly), attrs["sourceName"]}] += n
+			case "Workout":
+				start, err := time.Parse(appleTimeLayout, attrs["startDate"])
+				if err != nil {
+					continue
+				}
+				name := strings.TrimPrefix(attrs["workoutActivityType"], "HKWorkoutActivityType")
+				current = &workout{Start: start, Type: workoutType(name), Name: name, Source: "apple"}
+				current.Duration = appleDuration(attrs["duration"], attrs["durationUnit"])
+				current.Distance = appleDistance(attrs["totalDistance"], attrs["totalDistanceUnit"])
+				current.Calories, _ = strconv.ParseFloat(attrs["totalEnergyBurned"], 64)
+			case "WorkoutStatistics":
+				// Newer exports put totals here instead of on the workout
+				if current == nil {
+					continue
+				}
+				sum, _ := strconv.ParseFloat(attrs["sum"], 64)
+				switch t := attrs["type"]; {
+				case strings.HasPrefix(t, "HKQuantityTypeIdentifierDistance") && current.Distance == 0:
+					current.Distance = appleDistance(attrs["sum"], attrs["unit"])
+				case t == "HKQuantityTypeIdentifierActiveEnergyBurned" && current.Calories == 0:
+					current.Calories = sum
+				case t == "HKQuantityTypeIdentifierHeartRate":
+					current.HeartRate, _ = strconv.ParseFloat(attrs["average"], 64)
+				}
+			}
+		case xml.EndElement:
+			if el.Name.Local == "Workout" && current != nil {
+				data.Workouts = append(data.Workouts, *current)
+				current = nil
+			}
+		}
+		if !seenRoot {
+			if _, ok := tok.(xml.StartElement); ok {
+				return nil, errNotHealthData
+			}
+		}
+	}
+
+	for key, n := range stepsBySource {
+		data.Steps[key[0]] = max(data.Steps[key[0]], n)
+	}
+	return data, nil
+}
+
+const appleTimeLayout = "2006-01-02 15:04:05 -0700"
+
+func appleDuration(value, unit string) time.Duration {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "s":
+		return time.Duration(n * float64(time.Second))
+	case "hr", "h":
+		return time.Duration(n * float64(time.Hour))
+	default:
+		return time.Duration(n * float64(time.Minute))
+	}
+}
+
+func appleDistance(value, unit string) float64 {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "km":
+		return n * metersPerKm
+	case "mi":
+		return n * metersPerMile
+	case "yd":
+		return n * metersPerYard
+	case "ft":
+		return n * metersPerFoot
+	default:
+		return n
+	}
+}
+
+// workoutType groups the services' activity names into a few kinds, so
+// "Trail Running", "Run", and "Running" are all runs.
+func workoutType(name string) string {
+	lower := strings.ToLower(name)
+	for _, kind := range []struct{ match, kind string }{
+		{"run", "run"}, {"walk", "walk"}, {"hik", "hike"}, {"swim", "swim"},
+		{"cycl", "ride"}, {"bik", "ride"}, {"ride", "ride"},
+	} {
+		if strings.Contains(lower, kind.match) {
+			return kind.kind
+		}
+	}
+	if lower == "" {
+		return "other"
+	}
+	return lower
+}
@@ -0,0 +1,328 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"telegram-bot/egress"
+)
+
+const isolationLogPrefix = "[isolation]"
+
+// IsolationProfile is how the commands of one tool are isolated.
+type IsolationProfile struct {
+	Off       bool // Run the tool's commands directly
+	Network   bool // Let them reach the network
+	NoSeccomp bool // Skip firejail's seccomp filter, for programs that sandbox themselves
+}
+
+// defaultIsolationProfiles give network access to the tools whose commands
+// need it, and keep code the model wrote offline. The keys are the
+// profiles commands are started under: one per tool, plus pip for package
+// installs, which unlike the python runs they serve need the network.
+var defaultIsolationProfiles = map[string]IsolationProfile{
+	"bash":   {Network: true}, // curl, git, and the like
+	"python": {},
+	"pip":    {Network: true},
+	"review": {}, // Runs code from the patch under review
+	"repo":   {Network: true},
+	"oci":    {Network: true},
+	"deps":   {Network: true},                  // syft pulls images it scans
+	"scrape": {Network: true, NoSeccomp: true}, // Chromium has its own seccomp sandbox
+}
+
+// Isolation runs the commands tools start inside firejail or gVisor's
+// runsc, for deployments that can't run them in containers.
+type Isolation struct {
+	Backend  string                      // "firejail" or "runsc"; empty runs commands directly
+	Profiles map[string]IsolationProfile // Overrides of defaultIsolationProfiles, by profile
+	Hidden   []string                    // Paths commands must not see, such as the state directory (firejail only)
+	Tenants  string                      // Directory of tenants' workspaces; each command sees only its own (firejail only)
+}
+
+var (
+	isolationMu   sync.RWMutex
+	isolation     Isolation
+	isolationPath string // The backend's executable
+	egressProxy   *egress.Proxy
+	egressBridge  string            // Bridge firejail attaches networked commands to, so the proxy is their only way out
+	egressRules   map[string]string // The netfilter files that make it so, by profile; "" for the rest
+	browserProxy  string            // The proxy's port for Chromium, which can't send credentials
+)
+
+// SetIsolation makes every command tools start from now on run under the
+// backend. Call it once at startup, before registering tools.
+func SetIsolation(iso Isolation) error {
+	if iso.Backend != "" && iso.Backend != "firejail" && iso.Backend != "runsc" {
+		return fmt.Errorf("unknown isolation backend %q (use firejail or runsc)", iso.Backend)
+	}
+	var path string
+	if iso.Backend != "" {
+		var err error
+		if path, err = exec.LookPath(iso.Backend); err != nil {
+			return fmt.Errorf("finding %s: %w", iso.Backend, err)
+		}
+	}
+
+	isolationMu.Lock()
+	defer isolationMu.Unlock()
+	isolation, isolationPath = iso, path
+	if path != "" {
+		log.Printf("%s running tool commands under %s", isolationLogPrefix, iso.Backend)
+	}
+	return nil
+}
+
+// SetEgress sends the network traffic of every command tools start from
+// now on through the proxy, which applies the policy of the command's
+// isolation profile. Chromium ignores credentials in proxy URLs, so it
+// gets a port of its own under the scrape policy. With a bridge, firejail
+// gives networked commands a network namespace on it whose firewall only
+// lets them reach the proxy, so programs that ignore the proxy variables
+// can't go around it; the proxy must listen on the bridge. Call it once at
+// startup, before registering tools and running the proxy.
+func SetEgress(p *egress.Proxy, bridge string) error {
+	browser, err := p.Listen(browserProfile)
+	if err != nil {
+		return err
+	}
+	rules := make(map[string]string)
+	if bridge != "" {
+		for _, profile := range []string{"", browserProfile} {
+			if rules[profile], err = writeNetfilter(p.Netfilter(profile)); err != nil {
+				return err
+			}
+		}
+	}
+
+	isolationMu.Lock()
+	defer isolationMu.Unlock()
+	egressProxy, egressBridge, egressRules, browserProxy = p, bridge, rules, browser
+	return nil
+}
+
+// browserProfile is the profile headless Chromium runs under.
+const browserProfile = "scrape"
+
+func writeNetfilter(rules []byte) (string, error) {
+	f, err := os.CreateTemp("", "telegram-bot-egress-*.net")
+	if err != nil {
+		return "", fmt.Errorf("writing egress firewall: %w", err)
+	}
+	_, err = f.Write(rules)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("writing egress firewall: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// egressBrowserProxy returns the proxy address to start Chromium with, or
+// "" without a proxy.
+func egressBrowserProxy() string {
+	isolationMu.RLock()
+	defer isolationMu.RUnlock()
+	return browserProxy
+}
+
+// egressTransport returns a transport that sends the requests the bot
+// makes itself for a tool through the egress proxy, under the tool's
+// policy, or nil without a proxy.
+func egressTransport(profile string) http.RoundTripper {
+	isolationMu.RLock()
+	proxy := egressProxy
+	isolationMu.RUnlock()
+	if proxy == nil {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxy.URL(profile))
+	return transport
+}
+
+// ParseIsolationProfiles reads overrides such as "bash=nonet,oci=off,
+// scrape=net+noseccomp". The options are net, nonet, noseccomp, and off.
+func ParseIsolationProfiles(specs []string) (map[string]IsolationProfile, error) {
+	profiles := make(map[string]IsolationProfile)
+	for _, spec := range specs {
+		name, opts, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("isolation profile %q should be tool=options", spec)
+		}
+		p := defaultIsolationProfiles[name]
+		for _, opt := range strings.Split(opts, "+") {
+			switch strings.TrimSpace(opt) {
+			case "net":
+				p.Network = true
+			case "nonet":
+				p.Network = false
+			case "noseccomp":
+				p.NoSeccomp = true
+			case "off":
+				p.Off = true
+			default:
+				return nil, fmt.Errorf("unknown isolation option %q for %s (use net, nonet, noseccomp, or off)", opt, name)
+			}
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+type untrustedKey struct{}
+
+// WithUntrustedCode returns a context whose commands run code nobody has
+// vetted, such as a repository a GitHub issue pointed at. They get none of
+// the bot's credentials in their environment, and no network, whatever
+// their tool's profile says.
+func WithUntrustedCode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, untrustedKey{}, true)
+}
+
+func untrusted(ctx context.Context) bool {
+	u, _ := ctx.Value(untrustedKey{}).(bool)
+	return u
+}
+
+// untrustedEnvVars are the variables of the bot's own environment that
+// untrusted commands keep: enough to find programs and write temporary
+// files, and nothing that might hold a token.
+var untrustedEnvVars = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LANG": true, "LC_ALL": true,
+	"TERM": true, "TMPDIR": true, "TZ": true,
+}
+
+// withoutCredentials drops the variables env inherited from the bot's
+// environment, except untrustedEnvVars. Ones the tool added itself, like
+// WORKSPACE, are kept.
+func withoutCredentials(env []string) []string {
+	inherited := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		inherited[kv] = true
+	}
+	var kept []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if untrustedEnvVars[name] || !inherited[kv] {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// isolate rewrites cmd to run under the configured backend with the
+// profile's restrictions, and through the egress proxy. Commands of
+// untrusted code lose the bot's credentials and the network. Call it once
+// cmd's Dir and Env are set.
+func isolate(ctx context.Context, cmd *exec.Cmd, profile string) {
+	isolationMu.RLock()
+	iso, backend, proxy, bridge := isolation, isolationPath, egressProxy, egressBridge
+	rules, ok := egressRules[profile]
+	if !ok {
+		rules = egressRules[""]
+	}
+	isolationMu.RUnlock()
+	offline := untrusted(ctx)
+	if offline {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = withoutCredentials(cmd.Env)
+	}
+	if proxy != nil && !offline {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, proxy.Env(profile)...)
+	}
+	if backend == "" {
+		return
+	}
+	p, ok := iso.Profiles[profile]
+	if !ok {
+		p = defaultIsolationProfiles[profile]
+	}
+	if offline {
+		p.Off, p.Network = false, false
+	}
+	if p.Off {
+		return
+	}
+
+	target := cmd.Path
+	if cmd.Err != nil {
+		target = cmd.Args[0] // Not found on PATH; let the backend report it
+	}
+	var args []string
+	switch iso.Backend {
+	case "firejail":
+		args = []string{"firejail", "--quiet", "--noprofile", "--nonewprivs", "--noroot",
+			"--caps.drop=all", "--private-tmp", "--private-dev"}
+		if !p.NoSeccomp {
+			args = append(args, "--seccomp")
+		}
+		switch {
+		case !p.Network:
+			args = append(args, "--net=none")
+		case proxy != nil && bridge != "":
+			args = append(args, "--net="+bridge, "--netfilter="+rules)
+		}
+		for _, path := range iso.Hidden {
+			args = append(args, "--blacklist="+path)
+		}
+		for _, path := range otherTenants(iso.Tenants, cmd.Dir) {
+			args = append(args, "--blacklist="+path)
+		}
+		args = append(args, "--")
+	case "runsc":
+		// gVisor intercepts every system call itself, so there's no seccomp
+		// filter to choose; writes go straight to the host's files
+		network := "none"
+		if p.Network {
+			network = "host"
+		}
+		args = []string{"runsc", "--rootless", "--network=" + network, "--overlay2=none", "do"}
+		if dir, err := filepath.Abs(cmd.Dir); err == nil {
+			args = append(args, "--cwd", dir)
+		}
+		args = append(args, "--")
+	}
+	cmd.Path = backend
+	cmd.Args = append(append(args, target), cmd.Args[1:]...)
+	cmd.Err = nil
+}
+
+// otherTenants returns the workspaces under root other than the one dir is
+// in: all of them if dir isn't in one.
+func otherTenants(root, dir string) []string {
+	if root == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	var own string
+	if abs, err := filepath.Abs(dir); err == nil {
+		if rel, err := filepath.Rel(root, abs); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			own, _, _ = strings.Cut(rel, string(filepath.Separator))
+		}
+	}
+	var others []string
+	for _, e := range entries {
+		if e.Name() != own {
+			others = append(others, filepath.Join(root, e.Name()))
+		}
+	}
+	return others
+}
@@ -0,0 +1,328 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxMathInput = 2000
+
+var (
+	// mathChars are the characters expressions may use. Quotes, colons,
+	// and backslashes are left out so input can't build Python strings or
+	// lambdas.
+	mathChars = regexp.MustCompile(`^[A-Za-z0-9_+\-*/^().,=<>!\[\];\s]*$`)
+	// mathUnsafe finds attribute access, dunder names, and Python keywords,
+	// none of which math needs.
+	mathUnsafe = regexp.MustCompile(`__|[A-Za-z0-9_)\]]\s*\.\s*[A-Za-z_]|\b(?:for|in|if|else|lambda|import|while|yield|not|and|or|is)\b`)
+)
+
+// MathTool does exact symbolic and big-number math with SymPy in the
+// python tool's sandbox, so the model doesn't have to do arithmetic.
+type MathTool struct {
+	python *PythonTool
+}
+
+// NewMathTool creates a math tool that runs SymPy with the python tool.
+func NewMathTool(python *PythonTool) *MathTool {
+	return &MathTool{python: python}
+}
+
+func (m *MathTool) Name() string {
+	return "math"
+}
+
+func (m *MathTool) Description() string {
+	return `Exact math with SymPy. Use this for any arithmetic beyond the trivial, big numbers,
+fractions, algebra, and calculus instead of working it out yourself.
+
+Expressions use Python/SymPy syntax: ^ or ** for powers, 2x for 2*x, sqrt(2), pi, E,
+I, oo, factorial(50), binomial(10, 3), sin(x), log(x), Matrix([[1, 2], [3, 4]]).
+operation=evaluate computes an expression exactly, with a decimal approximation.
+operation=simplify, expand, or factor rewrites it (factor on an integer gives its prime factors).
+operation=solve solves an equation ("x^2 - 2 = 0"), inequality, or system separated
+by semicolons, for variables (default: all unknowns).
+operation=diff differentiates by variable (order for higher derivatives).
+operation=integrate integrates by variable, between lower and upper if given.
+operation=limit takes the limit as variable approaches point (direction + or -).`
+}
+
+func (m *MathTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"evaluate", "simplify", "expand", "factor", "solve", "diff", "integrate", "limit"},
+				"description": "What to do with the expression",
+			},
+			"expression": map[string]any{
+				"type":        "string",
+				"description": "The expression or equation, e.g. 2^100 / 3, x^2 - 5x + 6 = 0",
+			},
+			"variables": map[string]any{
+				"type":        "string",
+				"description": "For solve, diff, integrate, and limit: the variable, or comma-separated variables for solve",
+			},
+			"order": map[string]any{
+				"type":        "number",
+				"description": "For diff: which derivative (default 1)",
+			},
+			"lower": map[string]any{
+				"type":        "string",
+				"description": "For integrate: the lower bound of a definite integral, e.g. 0 or -oo",
+			},
+			"upper": map[string]any{
+				"type":        "string",
+				"description": "For integrate: the upper bound",
+			},
+			"point": map[string]any{
+				"type":        "string",
+				"description": "For limit: the value the variable approaches, e.g. 0 or oo",
+			},
+			"direction": map[string]any{
+				"type":        "string",
+				"enum":        []string{"+", "-", "+-"},
+				"description": "For limit: approach from above (+), below (-), or both (+-, default)",
+			},
+			"digits": map[string]any{
+				"type":        "number",
+				"description": "Significant digits in decimal approximations (default 15)",
+			},
+		},
+		"required": []string{"operation", "expression"},
+	}
+}
+
+func (m *MathTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostLow}
+}
+
+func (m *MathTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"operation": "evaluate", "expression": "2^100"}, "1267650600228229401496703205376"
+}
+
+func (m *MathTool) Requirements() []Requirement {
+	return []Requirement{
+		{Name: "python3", Command: hostPython()},
+		{Name: "sympy", Check: pythonModule(m.python.interpreter, "sympy")},
+	}
+}
+
+func (m *MathTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	spec := map[string]any{"direction": "+-", "order": 1, "digits": 15}
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "evaluate", "simplify", "expand", "factor", "solve", "diff", "integrate", "limit":
+		spec["operation"] = operation
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+
+	for _, name := range []string{"expression", "variables", "lower", "upper", "point"} {
+		value, _ := args[name].(string)
+		value = strings.TrimSpace(value)
+		if err := checkMathInput(value); err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		spec[name] = value
+	}
+	if spec["expression"] == "" {
+		return "", fmt.Errorf("expression is required")
+	}
+	if (spec["lower"] == "") != (spec["upper"] == "") {
+		return "", fmt.Errorf("a definite integral needs both lower and upper")
+	}
+	if operation == "limit" && spec["point"] == "" {
+		return "", fmt.Errorf("point is required for limit")
+	}
+	if direction, _ := args["direction"].(string); direction != "" {
+		if direction != "+" && direction != "-" && direction != "+-" {
+			return "", fmt.Errorf("direction must be +, -, or +-")
+		}
+		spec["direction"] = direction
+	}
+	if order, ok := args["order"].(float64); ok {
+		if order < 1 || order > 20 {
+			return "", fmt.Errorf("order must be from 1 to 20")
+		}
+		spec["order"] = int(order)
+	}
+	if digits, ok := args["digits"].(float64); ok {
+		if digits < 1 || digits > 1000 {
+			return "", fmt.Errorf("digits must be from 1 to 1000")
+		}
+		spec["digits"] = int(digits)
+	}
+
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("encoding request: %w", err)
+	}
+	output, err := m.python.Execute(ctx, map[string]any{
+		"operation": "run",
+		"code":      fmt.Sprintf(mathScript, strconv.Quote(string(encoded))),
+	})
+	if err != nil {
+		return "", fmt.Errorf("running SymPy: %w", err)
+	}
+	return parseMathOutput(output)
+}
+
+// checkMathInput rejects anything that isn't plain math, since SymPy
+// parses expressions by evaluating them as Python.
+func checkMathInput(s string) error {
+	if len(s) > maxMathInput {
+		return fmt.Errorf("too long (over %d characters)", maxMathInput)
+	}
+	if !mathChars.MatchString(s) {
+		return fmt.Errorf("only letters, digits, spaces, and + - * / ^ ( ) [ ] . , ; = < > ! are allowed")
+	}
+	if bad := mathUnsafe.FindString(s); bad != "" {
+		return fmt.Errorf("%q isn't allowed in math expressions", strings.TrimSpace(bad))
+	}
+	return nil
+}
+
+// parseMathOutput reads the JSON line the script prints last. Anything
+// else is output from a failed run, such as SymPy missing.
+func parseMathOutput(output string) (string, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(lines[i], "{") {
+			continue
+		}
+		var result struct {
+			Lines []string `json:"lines"`
+			Error string   `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &result); err != nil {
+			continue
+		}
+		if result.Error != "" {
+			return "", fmt.Errorf("%s", result.Error)
+		}
+		return strings.Join(result.Lines, "\n"), nil
+	}
+	return "", fmt.Errorf("SymPy failed: %s", truncateText(output, 500))
+}
+
+// mathScript parses the request's expressions with SymPy and prints one
+// JSON line with the result. Names resolve to SymPy's only: Python's
+// builtins are removed, and unknown names become symbols.
+const mathScript = `import json
+import re
+
+import sympy
+from sympy.parsing.sympy_parser import (
+    convert_xor,
+    implicit_multiplication,
+    parse_expr,
+    standard_transformations,
+)
+
+spec = json.loads(%s)
+names = {name: getattr(sympy, name) for name in dir(sympy) if not name.startswith("_")}
+names["__builtins__"] = {}
+for name in ("preview", "init_printing", "init_session", "lambdify", "sympify", "var"):
+    names.pop(name, None)
+transformations = standard_transformations + (implicit_multiplication, convert_xor)
+MAX_CHARS = 3000
+
+
+def parse(text):
+    sides = re.split(r"(?<![<>!=])=(?!=)", text)
+    if len(sides) == 2:
+        return sympy.Eq(parse(sides[0]), parse(sides[1]))
+    if len(sides) > 2:
+        raise ValueError("more than one = in " + text)
+    return parse_expr(text, global_dict=names, transformations=transformations)
+
+
+def fmt(value):
+    text = str(value).replace("**", "^")
+    if len(text) > MAX_CHARS:
+        text = "%%s ... %%s (%%d characters)" %% (text[:1500], text[-100:], len(text))
+    return text
+
+
+def approx(value):
+    if not isinstance(value, sympy.Expr) or value.free_symbols or value.is_Rational:
+        return None
+    try:
+        number = sympy.N(value, spec["digits"])
+    except Exception:
+        return None
+    text = fmt(number)
+    return text if text != fmt(value) else None
+
+
+def show(value, label=""):
+    line = label + fmt(value)
+    decimal = approx(value)
+    if decimal:
+        line += " ≈ " + decimal
+    return line
+
+
+def variables(expr):
+    if spec["variables"]:
+        return [sympy.Symbol(v.strip()) for v in spec["variables"].split(",") if v.strip()]
+    found = sorted(expr.free_symbols, key=lambda s: s.name)
+    if not found:
+        raise ValueError("the expression has no variable")
+    return found
+
+
+def run():
+    op = spec["operation"]
+    if op == "solve":
+        exprs = [parse(part) for part in spec["expression"].split(";") if part.strip()]
+        if spec["variables"]:
+            unknowns = variables(None)
+        else:
+            unknowns = sorted(set().union(*(e.free_symbols for e in exprs)), key=lambda s: s.name)
+        if any(isinstance(e, sympy.core.relational.Relational) and not isinstance(e, sympy.Eq) for e in exprs):
+            return [fmt(sympy.reduce_inequalities(exprs, unknowns))]
+        solutions = sympy.solve(exprs, unknowns, dict=True)
+        if not solutions:
+            return ["No solution"]
+        lines = []
+        for solution in solutions:
+            lines.append(", ".join(show(value, "%%s = " %% name) for name, value in solution.items()))
+        return lines
+
+    expr = parse(spec["expression"])
+    if op == "evaluate":
+        return [show(expr.doit() if hasattr(expr, "doit") else expr)]
+    if op == "simplify":
+        return [show(sympy.simplify(expr))]
+    if op == "expand":
+        return [show(sympy.expand(expr))]
+    if op == "factor":
+        if isinstance(expr, sympy.Integer):
+            factors = sympy.factorint(expr)
+            return [" * ".join(fmt(p) if k == 1 else "%%s^%%d" %% (p, k) for p, k in factors.items()) or fmt(expr)]
+        return [show(sympy.factor(expr))]
+
+    var = variables(expr)[0]
+    if op == "diff":
+        return [show(sympy.diff(expr, var, spec["order"]))]
+    if op == "integrate":
+        if spec["lower"]:
+            return [show(sympy.integrate(expr, (var, parse(spec["lower"]), parse(spec["upper"]))))]
+        return [fmt(sympy.integrate(expr, var)) + " + C"]
+    if op == "limit":
+        return [show(sympy.limit(expr, var, parse(spec["point"]), spec["direction"]))]
+    raise ValueError("unknown operation " + op)
+
+
+try:
+    print(json.dumps({"lines": run()}))
+except Exception as err:
+    print(json.dumps({"error": "%%s: %%s" %% (type(err).__name__, err)}))
+`
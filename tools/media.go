@@ -0,0 +1,589 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mediaStoreKey        = "media_list"
+	mediaLogPrefix       = "[media]"
+	mediaTimeout         = 20 * time.Second
+	defaultMediaRegion   = "US"
+	maxMediaResults      = 5
+	maxMediaItemsPerChat = 500
+	maxMediaListed       = 20
+	maxMediaSeeds        = 3 // Entries recommendations are based on
+)
+
+// mediaItem is a book, film, or show on a chat's list.
+type mediaItem struct {
+	ID      int       `json:"id"`
+	ChatID  int64     `json:"chat_id"`
+	Ref     string    `json:"ref"` // As in search results, e.g. movie:550
+	Kind    string    `json:"kind"`
+	Title   string    `json:"title"`
+	Creator string    `json:"creator,omitempty"`
+	Year    string    `json:"year,omitempty"`
+	Genres  []string  `json:"genres,omitempty"`
+	Added   time.Time `json:"added"`
+	Done    time.Time `json:"done,omitempty"`   // When it was read or watched
+	Rating  int       `json:"rating,omitempty"` // The chat's 1-5 rating, if given
+}
+
+func (item *mediaItem) done() bool {
+	return !item.Done.IsZero()
+}
+
+type mediaState struct {
+	NextID int         `json:"next_id"`
+	Items  []mediaItem `json:"items"`
+}
+
+// MediaTool looks up books on Open Library and films and shows on TMDB,
+// and keeps a per-chat list of things to read or watch, with
+// recommendations based on what is on it.
+type MediaTool struct {
+	tmdbKey        string // Empty limits the tool to books
+	region         string // Country code for streaming availability
+	openLibraryURL string
+	tmdbURL        string
+	httpClient     *http.Client
+
+	host  *Host // Set by Start; nil when the list is unavailable
+	mu    sync.Mutex
+	state mediaState
+}
+
+// NewMediaTool creates a media tool. Without a TMDB key it only knows
+// books. region is the ISO 3166 country whose streaming services are
+// listed.
+func NewMediaTool(tmdbKey, region string) *MediaTool {
+	return &MediaTool{
+		tmdbKey:        tmdbKey,
+		region:         strings.ToUpper(cmp.Or(region, defaultMediaRegion)),
+		openLibraryURL: defaultOpenLibraryURL,
+		tmdbURL:        defaultTMDBURL,
+		httpClient:     &http.Client{Timeout: mediaTimeout},
+	}
+}
+
+func (m *MediaTool) Name() string {
+	return "media"
+}
+
+func (m *MediaTool) Description() string {
+	kinds := "books (Open Library)"
+	if m.tmdbKey != "" {
+		kinds = "books (Open Library), films, and TV shows (TMDB)"
+	}
+	return fmt.Sprintf(`Look up %s, and keep this chat's list of things to read or watch.
+
+operation=search with query (and kind: book, movie, or tv) finds titles with their ids.
+operation=get with id shows details, ratings, and where to read or stream it.
+operation=add with id (or query) puts it on the to read/watch list.
+operation=list shows the list (kind to filter, include_done=true for finished ones).
+operation=done with item_id marks one read or watched, with an optional rating from 1 to 5.
+operation=remove with item_id deletes one.
+operation=recommend suggests what to read or watch next based on the list.`, kinds)
+}
+
+func (m *MediaTool) Parameters() map[string]any {
+	kinds := []string{"book"}
+	if m.tmdbKey != "" {
+		kinds = append(kinds, "movie", "tv")
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"search", "get", "add", "list", "done", "remove", "recommend"},
+				"description": "What to do",
+			},
+			"query": map[string]any{
+				"type":        "string",
+				"description": "For search and add: a title, author, or both",
+			},
+			"kind": map[string]any{
+				"type":        "string",
+				"enum":        kinds,
+				"description": "For search, add, list, and recommend: books, films, or shows only",
+			},
+			"id": map[string]any{
+				"type":        "string",
+				"description": "For get and add: the id from search results, e.g. book:OL45804W or movie:550",
+			},
+			"item_id": map[string]any{
+				"type":        "number",
+				"description": "For done and remove: the number on the list",
+			},
+			"rating": map[string]any{
+				"type":        "number",
+				"description": "For done: the user's rating from 1 to 5",
+			},
+			"include_done": map[string]any{
+				"type":        "boolean",
+				"description": "For list: include things already read or watched",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (m *MediaTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+func (m *MediaTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"operation": "search", "query": "Dune", "kind": "book"}, "Dune"
+}
+
+// Start loads the lists.
+func (m *MediaTool) Start(host Host) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.host = &host
+	if _, err := host.Store.Get(mediaStoreKey, &m.state); err != nil {
+		return fmt.Errorf("loading media list: %w", err)
+	}
+	log.Printf("%s %d items on to read/watch lists", mediaLogPrefix, len(m.state.Items))
+	return nil
+}
+
+func (m *MediaTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	query, _ := args["query"].(string)
+	kind, _ := args["kind"].(string)
+	ref, _ := args["id"].(string)
+	query = strings.TrimSpace(query)
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind != "" && kind != "book" && kind != "movie" && kind != "tv" {
+		return "", fmt.Errorf("kind must be book, movie, or tv")
+	}
+	if kind != "" && kind != "book" && m.tmdbKey == "" {
+		return "", fmt.Errorf("film and TV lookups need a TMDB API key; only books are available")
+	}
+
+	switch operation {
+	case "search":
+		if query == "" {
+			return "", fmt.Errorf("query is required for search")
+		}
+		return m.search(ctx, query, kind)
+	case "get":
+		if ref == "" {
+			return "", fmt.Errorf("id is required for get (see operation=search)")
+		}
+		info, err := m.lookup(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		return formatMediaInfo(*info, true), nil
+	}
+
+	// The rest use the chat's list
+	if m.host == nil {
+		return "", fmt.Errorf("the to read/watch list is not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("the to read/watch list needs a chat")
+	}
+	switch operation {
+	case "add":
+		return m.add(ctx, chatID, ref, query, kind)
+	case "list":
+		includeDone, _ := args["include_done"].(bool)
+		return m.list(chatID, kind, includeDone), nil
+	case "done", "remove":
+		id, ok := args["item_id"].(float64)
+		if !ok {
+			return "", fmt.Errorf("item_id is required for %s (see operation=list)", operation)
+		}
+		rating, _ := args["rating"].(float64)
+		if rating != 0 && (rating < 1 || rating > 5) {
+			return "", fmt.Errorf("rating must be from 1 to 5")
+		}
+		return m.update(chatID, int(id), operation == "remove", int(rating))
+	case "recommend":
+		return m.recommend(ctx, chatID, kind)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// search finds books, films, and shows. Without a kind, books and screen
+// results are both shown when there's a TMDB key.
+func (m *MediaTool) search(ctx context.Context, query, kind string) (string, error) {
+	var found []mediaInfo
+	if kind == "" || kind == "book" {
+		books, err := m.searchBooks(ctx, url.Values{"q": {query}}, maxMediaResults)
+		if err != nil {
+			return "", err
+		}
+		found = append(found, books...)
+	}
+	if kind != "book" && m.tmdbKey != "" {
+		screen, err := m.searchScreen(ctx, query, kind, maxMediaResults)
+		if err != nil {
+			return "", err
+		}
+		found = append(found, screen...)
+	}
+	if len(found) == 0 {
+		return fmt.Sprintf("Nothing found for %q.", query), nil
+	}
+
+	var b strings.Builder
+	for _, info := range found {
+		b.WriteString(formatMediaInfo(info, false) + "\n\n")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// lookup fetches the details of a search result.
+func (m *MediaTool) lookup(ctx context.Context, ref string) (*mediaInfo, error) {
+	kind, id, err := parseMediaRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "book" {
+		return m.book(ctx, id)
+	}
+	if m.tmdbKey == "" {
+		return nil, fmt.Errorf("film and TV lookups need a TMDB API key")
+	}
+	return m.screen(ctx, kind, id)
+}
+
+// add puts a search result on the chat's list. Given a query instead of an
+// id, it adds the best match.
+func (m *MediaTool) add(ctx context.Context, chatID int64, ref, query, kind string) (string, error) {
+	var info *mediaInfo
+	switch {
+	case ref != "":
+		var err error
+		if info, err = m.lookup(ctx, ref); err != nil {
+			return "", err
+		}
+	case query != "":
+		var found []mediaInfo
+		var err error
+		if kind == "" || kind == "book" {
+			found, err = m.searchBooks(ctx, url.Values{"q": {query}}, 1)
+		} else {
+			found, err = m.searchScreen(ctx, query, kind, 1)
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(found) == 0 {
+			return fmt.Sprintf("Nothing found for %q.", query), nil
+		}
+		info = &found[0]
+	default:
+		return "", fmt.Errorf("id or query is required for add")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, item := range m.state.Items {
+		if item.ChatID != chatID {
+			continue
+		}
+		if item.Ref == info.Ref {
+			return fmt.Sprintf("Already on the list as #%d: %s", item.ID, item.Title), nil
+		}
+		count++
+	}
+	if count >= maxMediaItemsPerChat {
+		return "", fmt.Errorf("the list already has %d entries; remove some first", count)
+	}
+
+	m.state.NextID++
+	item := mediaItem{
+		ID:      m.state.NextID,
+		ChatID:  chatID,
+		Ref:     info.Ref,
+		Kind:    info.Kind,
+		Title:   info.Title,
+		Creator: info.Creator,
+		Year:    info.Year,
+		Genres:  info.Genres,
+		Added:   time.Now().UTC(),
+	}
+	m.state.Items = append(m.state.Items, item)
+	if err := m.host.Store.Save(mediaStoreKey, m.state); err != nil {
+		return "", err
+	}
+	log.Printf("%s added #%d for chat %d: %s", mediaLogPrefix, item.ID, chatID, item.Ref)
+	return fmt.Sprintf("%s Added to your %s list\n\n%s", mediaEmoji(item.Kind), mediaVerb(item.Kind), formatMediaItem(item)), nil
+}
+
+// list shows the chat's entries, oldest first, so the next one up is at
+// the top.
+func (m *MediaTool) list(chatID int64, kind string, includeDone bool) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	shown, total := 0, 0
+	for _, item := range m.state.Items {
+		if item.ChatID != chatID || (kind != "" && item.Kind != kind) || (!includeDone && item.done()) {
+			continue
+		}
+		total++
+		if shown < maxMediaListed {
+			b.WriteString(formatMediaItem(item) + "\n")
+			shown++
+		}
+	}
+	if total == 0 {
+		return "Nothing on the to read/watch list."
+	}
+	if total > shown {
+		fmt.Fprintf(&b, "...and %d more", total-shown)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (m *MediaTool) update(chatID int64, id int, remove bool, rating int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.state.Items, func(item mediaItem) bool {
+		return item.ID == id && item.ChatID == chatID
+	})
+	if i < 0 {
+		return "", fmt.Errorf("no #%d on this chat's list", id)
+	}
+
+	item := &m.state.Items[i]
+	var reply string
+	if remove {
+		reply = fmt.Sprintf("Removed #%d: %s", id, item.Title)
+		m.state.Items = slices.Delete(m.state.Items, i, i+1)
+	} else {
+		item.Done = time.Now().UTC()
+		if rating > 0 {
+			item.Rating = rating
+		}
+		reply = fmt.Sprintf("✅ Marked #%d as %s: %s", id, mediaPast(item.Kind), item.Title)
+		if item.Rating > 0 {
+			reply += " " + strings.Repeat("★", item.Rating)
+		}
+	}
+	if err := m.host.Store.Save(mediaStoreKey, m.state); err != nil {
+		return "", err
+	}
+	return reply, nil
+}
+
+// recommend suggests titles like the chat's best-liked entries: TMDB's
+// recommendations for films and shows, and highly rated books sharing
+// the most common subjects for books. Titles already on the list are
+// left out.
+func (m *MediaTool) recommend(ctx context.Context, chatID int64, kind string) (string, error) {
+	m.mu.Lock()
+	var items []mediaItem
+	onList := make(map[string]bool)
+	for _, item := range m.state.Items {
+		if item.ChatID == chatID {
+			onList[item.Ref] = true
+			if (kind == "" || item.Kind == kind) && (item.Rating == 0 || item.Rating >= 3) {
+				items = append(items, item)
+			}
+		}
+	}
+	m.mu.Unlock()
+	if len(items) == 0 {
+		return "", fmt.Errorf("add some books or films to the list first (or rate them above 2), so there is something to go on")
+	}
+
+	// Rated favourites first, then finished ones, then the newest additions
+	slices.SortStableFunc(items, func(a, b mediaItem) int {
+		if c := cmp.Compare(b.Rating, a.Rating); c != 0 {
+			return c
+		}
+		if a.done() != b.done() {
+			if a.done() {
+				return -1
+			}
+			return 1
+		}
+		return b.Added.Compare(a.Added)
+	})
+
+	type suggestion struct {
+		info    mediaInfo
+		because string
+		score   int
+	}
+	suggestions := make(map[string]*suggestion)
+	var order []string
+	suggest := func(info mediaInfo, because string) {
+		if onList[info.Ref] {
+			return
+		}
+		if s, ok := suggestions[info.Ref]; ok {
+			s.score++
+			return
+		}
+		suggestions[info.Ref] = &suggestion{info: info, because: because, score: 1}
+		order = append(order, info.Ref)
+	}
+
+	seeds := 0
+	var books []mediaItem
+	for _, item := range items {
+		if item.Kind == "book" {
+			books = append(books, item)
+			continue
+		}
+		if m.tmdbKey == "" || seeds == maxMediaSeeds {
+			continue
+		}
+		seeds++
+		_, id, err := parseMediaRef(item.Ref)
+		if err != nil {
+			continue
+		}
+		found, err := m.screenRecommendations(ctx, item.Kind, id)
+		if err != nil {
+			log.Printf("%s recommendations for %s failed: %v", mediaLogPrefix, item.Ref, err)
+			continue
+		}
+		for _, info := range found {
+			suggest(info, item.Title)
+		}
+	}
+	if subject := commonSubject(books); subject != "" {
+		found, err := m.searchBooks(ctx, url.Values{"subject": {subject}, "sort": {"rating"}}, 2*maxMediaResults)
+		if err != nil {
+			log.Printf("%s book recommendations for %q failed: %v", mediaLogPrefix, subject, err)
+		}
+		for _, info := range found {
+			suggest(info, "your interest in "+strings.ToLower(subject))
+		}
+	}
+	if len(order) == 0 {
+		return "No recommendations found for what's on the list.", nil
+	}
+
+	slices.SortStableFunc(order, func(a, b string) int { return cmp.Compare(suggestions[b].score, suggestions[a].score) })
+	var b strings.Builder
+	for _, ref := range order[:min(len(order), maxMediaResults)] {
+		s := suggestions[ref]
+		fmt.Fprintf(&b, "%s\nBecause of %s\n\n", formatMediaInfo(s.info, false), s.because)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// commonSubject returns the subject shared by the most books, weighting
+// the first books (the favourites) higher. Open Library lists a book's
+// main subjects first, so those break ties.
+func commonSubject(books []mediaItem) string {
+	counts := make(map[string]int)
+	for i, book := range books {
+		weight := max(1, maxMediaSeeds-i) * maxMediaGenres
+		for j, g := range book.Genres {
+			counts[g] += weight - j
+		}
+	}
+	best := ""
+	for subject, n := range counts {
+		if n > counts[best] || (n == counts[best] && subject < best) {
+			best = subject
+		}
+	}
+	return best
+}
+
+func formatMediaInfo(info mediaInfo, details bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", mediaEmoji(info.Kind), info.Title)
+	if info.Year != "" {
+		fmt.Fprintf(&b, " (%s)", info.Year)
+	}
+	if info.Creator != "" {
+		fmt.Fprintf(&b, " by %s", info.Creator)
+	}
+	fmt.Fprintf(&b, "\nid: %s", info.Ref)
+	if info.RatingCount > 0 {
+		fmt.Fprintf(&b, " · ★ %.1f/%d (%d ratings)", info.Rating, info.RatingScale, info.RatingCount)
+	}
+	if !details {
+		return b.String()
+	}
+
+	if info.Length != "" {
+		fmt.Fprintf(&b, " · %s", info.Length)
+	}
+	if len(info.Genres) > 0 {
+		fmt.Fprintf(&b, "\n%s", strings.Join(info.Genres, ", "))
+	}
+	if info.Summary != "" {
+		fmt.Fprintf(&b, "\n\n%s", truncateText(info.Summary, 600))
+	}
+	if info.Availability != "" {
+		fmt.Fprintf(&b, "\n\n%s", info.Availability)
+	}
+	fmt.Fprintf(&b, "\n%s", info.URL)
+	return b.String()
+}
+
+func formatMediaItem(item mediaItem) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s %s", item.ID, mediaEmoji(item.Kind), item.Title)
+	if item.Year != "" {
+		fmt.Fprintf(&b, " (%s)", item.Year)
+	}
+	if item.Creator != "" {
+		fmt.Fprintf(&b, " by %s", item.Creator)
+	}
+	if item.done() {
+		b.WriteString(" ✓")
+	}
+	if item.Rating > 0 {
+		b.WriteString(" " + strings.Repeat("★", item.Rating))
+	}
+	return b.String()
+}
+
+func mediaEmoji(kind string) string {
+	switch kind {
+	case "book":
+		return "📖"
+	case "tv":
+		return "📺"
+	default:
+		return "🎬"
+	}
+}
+
+// mediaVerb is what the list is for, for a kind of item.
+func mediaVerb(kind string) string {
+	if kind == "book" {
+		return "to read"
+	}
+	return "to watch"
+}
+
+func mediaPast(kind string) string {
+	if kind == "book" {
+		return "read"
+	}
+	return "watched"
+}
@@ -0,0 +1,333 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultOpenLibraryURL = "https://openlibrary.org"
+	defaultTMDBURL        = "https://api.themoviedb.org/3"
+	openLibraryFields     = "key,title,author_name,first_publish_year,ratings_average,ratings_count,ebook_access,subject"
+	maxMediaGenres        = 8 // Subjects kept per book; Open Library lists dozens
+)
+
+// mediaInfo is a book, film, or TV show from Open Library or TMDB.
+type mediaInfo struct {
+	Ref          string // book:OL45804W, movie:550, or tv:1399
+	Kind         string // book, movie, or tv
+	Title        string
+	Creator      string // Author, director, or show creator
+	Year         string
+	Length       string // Running time or number of seasons
+	Rating       float64
+	RatingCount  int
+	RatingScale  int // 5 for Open Library, 10 for TMDB
+	Genres       []string
+	Summary      string
+	Availability string // Where it can be read or streamed; empty if unknown
+	URL          string
+}
+
+// parseMediaRef splits a reference like "movie:550" into its kind and ID.
+func parseMediaRef(ref string) (kind, id string, err error) {
+	kind, id, ok := strings.Cut(strings.TrimSpace(ref), ":")
+	kind = strings.ToLower(kind)
+	if !ok || id == "" || (kind != "book" && kind != "movie" && kind != "tv") {
+		return "", "", fmt.Errorf("invalid id %q; use the id from search results, e.g. book:OL45804W or movie:550", ref)
+	}
+	return kind, id, nil
+}
+
+// openLibraryDoc is a search result from Open Library.
+type openLibraryDoc struct {
+	Key            string   `json:"key"` // /works/OL45804W
+	Title          string   `json:"title"`
+	Authors        []string `json:"author_name"`
+	Year           int      `json:"first_publish_year"`
+	RatingsAverage float64  `json:"ratings_average"`
+	RatingsCount   int      `json:"ratings_count"`
+	EbookAccess    string   `json:"ebook_access"`
+	Subjects       []string `json:"subject"`
+}
+
+func (d openLibraryDoc) info() mediaInfo {
+	id := strings.TrimPrefix(d.Key, "/works/")
+	info := mediaInfo{
+		Ref:         "book:" + id,
+		Kind:        "book",
+		Title:       d.Title,
+		Rating:      d.RatingsAverage,
+		RatingCount: d.RatingsCount,
+		RatingScale: 5,
+		URL:         "https://openlibrary.org/works/" + id,
+	}
+	if len(d.Authors) > 0 {
+		info.Creator = strings.Join(d.Authors[:min(len(d.Authors), 2)], ", ")
+	}
+	if d.Year > 0 {
+		info.Year = strconv.Itoa(d.Year)
+	}
+	for _, s := range d.Subjects {
+		if len(info.Genres) == maxMediaGenres {
+			break
+		}
+		// Skip bookkeeping subjects like "nyt:hardcover-fiction=2011-01-01"
+		if !strings.ContainsAny(s, ":=") {
+			info.Genres = append(info.Genres, s)
+		}
+	}
+	switch d.EbookAccess {
+	case "public":
+		info.Availability = "Free to read on Open Library"
+	case "borrowable":
+		info.Availability = "Can be borrowed on Open Library"
+	case "printdisabled":
+		info.Availability = "Open Library ebook for print-disabled readers only"
+	}
+	return info
+}
+
+// searchBooks searches Open Library. query is passed through, so it may
+// use fields like subject:"science fiction".
+func (m *MediaTool) searchBooks(ctx context.Context, query url.Values, limit int) ([]mediaInfo, error) {
+	query.Set("fields", openLibraryFields)
+	query.Set("limit", strconv.Itoa(limit))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.openLibraryURL+"/search.json?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	var result struct {
+		Docs []openLibraryDoc `json:"docs"`
+	}
+	if err := doJSON(m.httpClient, req, &result); err != nil {
+		return nil, fmt.Errorf("searching Open Library: %w", err)
+	}
+	books := make([]mediaInfo, 0, len(result.Docs))
+	for _, doc := range result.Docs {
+		books = append(books, doc.info())
+	}
+	return books, nil
+}
+
+// book looks up a work by ID, with its description.
+func (m *MediaTool) book(ctx context.Context, id string) (*mediaInfo, error) {
+	books, err := m.searchBooks(ctx, url.Values{"q": {"key:/works/" + id}}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, fmt.Errorf("no book %s on Open Library", id)
+	}
+	info := books[0]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.openLibraryURL+"/works/"+url.PathEscape(id)+".json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	var work struct {
+		Description json.RawMessage `json:"description"` // A string, or {"type": ..., "value": ...}
+	}
+	if err := doJSON(m.httpClient, req, &work); err != nil {
+		// The search result is enough to go on
+		return &info, nil
+	}
+	var text string
+	if json.Unmarshal(work.Description, &text) != nil {
+		var typed struct {
+			Value string `json:"value"`
+		}
+		json.Unmarshal(work.Description, &typed)
+		text = typed.Value
+	}
+	info.Summary = strings.TrimSpace(text)
+	return &info, nil
+}
+
+// tmdbResult is a film or show in TMDB's search and recommendation
+// results, and the base of its detail responses.
+type tmdbResult struct {
+	ID           int     `json:"id"`
+	MediaType    string  `json:"media_type"` // Only in multi searches
+	Title        string  `json:"title"`      // Films
+	Name         string  `json:"name"`       // Shows
+	ReleaseDate  string  `json:"release_date"`
+	FirstAirDate string  `json:"first_air_date"`
+	VoteAverage  float64 `json:"vote_average"`
+	VoteCount    int     `json:"vote_count"`
+	Overview     string  `json:"overview"`
+}
+
+func (r tmdbResult) info(kind string) mediaInfo {
+	info := mediaInfo{
+		Ref:         fmt.Sprintf("%s:%d", kind, r.ID),
+		Kind:        kind,
+		Title:       r.Title,
+		Rating:      r.VoteAverage,
+		RatingCount: r.VoteCount,
+		RatingScale: 10,
+		Summary:     r.Overview,
+		URL:         fmt.Sprintf("https://www.themoviedb.org/%s/%d", kind, r.ID),
+	}
+	date := r.ReleaseDate
+	if kind == "tv" {
+		info.Title, date = r.Name, r.FirstAirDate
+	}
+	if len(date) >= 4 {
+		info.Year = date[:4]
+	}
+	return info
+}
+
+// tmdbGet calls the TMDB API with either kind of credential it issues: a
+// v3 API key, or a v4 read access token.
+func (m *MediaTool) tmdbGet(ctx context.Context, path string, query url.Values, v any) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	if !strings.HasPrefix(m.tmdbKey, "eyJ") {
+		query.Set("api_key", m.tmdbKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.tmdbURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	if strings.HasPrefix(m.tmdbKey, "eyJ") {
+		req.Header.Set("Authorization", "Bearer "+m.tmdbKey)
+	}
+	if err := doJSON(m.httpClient, req, v); err != nil {
+		return fmt.Errorf("calling TMDB: %w", err)
+	}
+	return nil
+}
+
+// searchScreen searches TMDB for films, shows, or both when kind is empty.
+func (m *MediaTool) searchScreen(ctx context.Context, query, kind string, limit int) ([]mediaInfo, error) {
+	path := "/search/multi"
+	if kind != "" {
+		path = "/search/" + kind
+	}
+	var result struct {
+		Results []tmdbResult `json:"results"`
+	}
+	if err := m.tmdbGet(ctx, path, url.Values{"query": {query}}, &result); err != nil {
+		return nil, err
+	}
+	var found []mediaInfo
+	for _, r := range result.Results {
+		k := cmp.Or(r.MediaType, kind)
+		if k != "movie" && k != "tv" {
+			continue // People in multi searches
+		}
+		found = append(found, r.info(k))
+		if len(found) == limit {
+			break
+		}
+	}
+	return found, nil
+}
+
+// screen looks up a film or show with its genres, director or creator, and
+// where it can be streamed, rented, or bought in the tool's region.
+func (m *MediaTool) screen(ctx context.Context, kind, id string) (*mediaInfo, error) {
+	var detail struct {
+		tmdbResult
+		Genres []struct {
+			Name string `json:"name"`
+		} `json:"genres"`
+		Runtime   int `json:"runtime"`
+		Seasons   int `json:"number_of_seasons"`
+		CreatedBy []struct {
+			Name string `json:"name"`
+		} `json:"created_by"`
+		Credits struct {
+			Crew []struct {
+				Name string `json:"name"`
+				Job  string `json:"job"`
+			} `json:"crew"`
+		} `json:"credits"`
+		Providers struct {
+			Results map[string]struct {
+				Link     string         `json:"link"`
+				Flatrate []tmdbProvider `json:"flatrate"`
+				Free     []tmdbProvider `json:"free"`
+				Rent     []tmdbProvider `json:"rent"`
+				Buy      []tmdbProvider `json:"buy"`
+			} `json:"results"`
+		} `json:"watch/providers"`
+	}
+	query := url.Values{"append_to_response": {"credits,watch/providers"}}
+	if err := m.tmdbGet(ctx, "/"+kind+"/"+url.PathEscape(id), query, &detail); err != nil {
+		return nil, err
+	}
+
+	info := detail.info(kind)
+	for _, g := range detail.Genres {
+		info.Genres = append(info.Genres, g.Name)
+	}
+	for _, c := range detail.Credits.Crew {
+		if c.Job == "Director" {
+			info.Creator = c.Name
+			break
+		}
+	}
+	if len(detail.CreatedBy) > 0 {
+		info.Creator = detail.CreatedBy[0].Name
+	}
+	switch {
+	case detail.Runtime > 0:
+		info.Length = fmt.Sprintf("%d min", detail.Runtime)
+	case detail.Seasons == 1:
+		info.Length = "1 season"
+	case detail.Seasons > 1:
+		info.Length = fmt.Sprintf("%d seasons", detail.Seasons)
+	}
+
+	where, ok := detail.Providers.Results[m.region]
+	if !ok {
+		info.Availability = fmt.Sprintf("Not streaming, for rent, or for sale in %s", m.region)
+		return &info, nil
+	}
+	var lines []string
+	for _, offer := range []struct {
+		label     string
+		providers []tmdbProvider
+	}{{"Stream", where.Flatrate}, {"Free", where.Free}, {"Rent", where.Rent}, {"Buy", where.Buy}} {
+		if len(offer.providers) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(offer.providers))
+		for _, p := range offer.providers {
+			names = append(names, p.Name)
+		}
+		lines = append(lines, offer.label+": "+strings.Join(names, ", "))
+	}
+	info.Availability = strings.Join(lines, "\n")
+	return &info, nil
+}
+
+type tmdbProvider struct {
+	Name string `json:"provider_name"`
+}
+
+// screenRecommendations returns TMDB's recommendations for viewers of a
+// film or show.
+func (m *MediaTool) screenRecommendations(ctx context.Context, kind, id string) ([]mediaInfo, error) {
+	var result struct {
+		Results []tmdbResult `json:"results"`
+	}
+	if err := m.tmdbGet(ctx, "/"+kind+"/"+url.PathEscape(id)+"/recommendations", nil, &result); err != nil {
+		return nil, err
+	}
+	found := make([]mediaInfo, 0, len(result.Results))
+	for _, r := range result.Results {
+		found = append(found, r.info(kind))
+	}
+	return found, nil
+}
@@ -0,0 +1,286 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"telegram-bot/auth"
+	"telegram-bot/quota"
+	"telegram-bot/snapshot"
+	"telegram-bot/tenant"
+)
+
+const snapshotTimeout = 30 * time.Second
+
+// Middleware wraps a tool to add behavior around it, such as access checks.
+type Middleware func(Tool) Tool
+
+// Conditional is implemented by tools that are only offered to the model
+// for some requests.
+type Conditional interface {
+	Available(ctx context.Context) bool
+}
+
+// As finds the first tool in a middleware chain that implements T, so
+// optional interfaces stay reachable through wrappers.
+func As[T any](tool Tool) (T, bool) {
+	for tool != nil {
+		if t, ok := tool.(T); ok {
+			return t, true
+		}
+		u, ok := tool.(interface{ Unwrap() Tool })
+		if !ok {
+			break
+		}
+		tool = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// Permissions returns a middleware that only lets users whose role allows
+// a tool execute it, and hides the tool from everyone else.
+func Permissions(perms auth.Permissions) Middleware {
+	return func(next Tool) Tool {
+		return &permissionTool{Tool: next, perms: perms}
+	}
+}
+
+type permissionTool struct {
+	Tool
+	perms auth.Permissions
+}
+
+func (p *permissionTool) Unwrap() Tool {
+	return p.Tool
+}
+
+func (p *permissionTool) Available(ctx context.Context) bool {
+	if !p.perms.Allows(auth.RoleFrom(ctx), p.Name()) {
+		return false
+	}
+	return isAvailable(ctx, p.Tool)
+}
+
+func (p *permissionTool) check(ctx context.Context) error {
+	role := auth.RoleFrom(ctx)
+	if !p.perms.Allows(role, p.Name()) {
+		return fmt.Errorf("permission denied: %s users cannot use %s", role, p.Name())
+	}
+	return nil
+}
+
+func (p *permissionTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if err := p.check(ctx); err != nil {
+		return "", err
+	}
+	return p.Tool.Execute(ctx, args)
+}
+
+func (p *permissionTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	if err := p.check(ctx); err != nil {
+		return nil, err
+	}
+	return Run(ctx, p.Tool, args)
+}
+
+func (p *permissionTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	if err := p.check(ctx); err != nil {
+		return nil, err
+	}
+	return Stream(ctx, p.Tool, args, chunks)
+}
+
+// Quota returns a middleware that refuses tool calls once the user's daily
+// quota is exhausted and records the time spent executing each tool.
+func Quota(tracker *quota.Tracker) Middleware {
+	return func(next Tool) Tool {
+		return &quotaTool{Tool: next, tracker: tracker}
+	}
+}
+
+type quotaTool struct {
+	Tool
+	tracker *quota.Tracker
+}
+
+func (q *quotaTool) Unwrap() Tool {
+	return q.Tool
+}
+
+func (q *quotaTool) Available(ctx context.Context) bool {
+	return isAvailable(ctx, q.Tool)
+}
+
+// begin checks the quota and returns a context for the call plus a
+// function that records the time spent.
+func (q *quotaTool) begin(ctx context.Context) (context.Context, func(), error) {
+	if err := q.tracker.Check(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	ctx = quota.WithTracker(ctx, q.tracker)
+	start := time.Now()
+	return ctx, func() { q.tracker.AddToolTime(ctx, time.Since(start)) }, nil
+}
+
+func (q *quotaTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	ctx, done, err := q.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	return q.Tool.Execute(ctx, args)
+}
+
+func (q *quotaTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	ctx, done, err := q.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return Run(ctx, q.Tool, args)
+}
+
+func (q *quotaTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	ctx, done, err := q.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return Stream(ctx, q.Tool, args, chunks)
+}
+
+// Snapshots returns a middleware that records the workspace in repo after
+// every call to a tool that isn't read-only, labeled with the chat, tool,
+// and request, so changes can be reviewed and files restored later.
+func Snapshots(repo *snapshot.Repo) Middleware {
+	return func(next Tool) Tool {
+		if MetadataOf(next).ReadOnly {
+			return next
+		}
+		return &snapshotTool{Tool: next, repo: repo}
+	}
+}
+
+type snapshotTool struct {
+	Tool
+	repo *snapshot.Repo
+}
+
+func (s *snapshotTool) Unwrap() Tool {
+	return s.Tool
+}
+
+func (s *snapshotTool) Available(ctx context.Context) bool {
+	return isAvailable(ctx, s.Tool)
+}
+
+// record commits whatever the call changed. It runs even if the call failed
+// or was cancelled, since files may have changed anyway.
+func (s *snapshotTool) record(ctx context.Context) {
+	chatID, _ := ChatFrom(ctx)
+	message := fmt.Sprintf("Chat %d: %s", chatID, s.Name())
+	if request := RequestFrom(ctx); request != "" {
+		title, _, _ := strings.Cut(request, "\n")
+		message += fmt.Sprintf(" for %q", truncateText(title, 60))
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), snapshotTimeout)
+	defer cancel()
+	if _, err := s.repo.Commit(ctx, message); err != nil {
+		log.Printf("Workspace snapshot after %s failed: %v", s.Name(), err)
+	}
+}
+
+func (s *snapshotTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	defer s.record(ctx)
+	return s.Tool.Execute(ctx, args)
+}
+
+func (s *snapshotTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	defer s.record(ctx)
+	return Run(ctx, s.Tool, args)
+}
+
+func (s *snapshotTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	defer s.record(ctx)
+	return Stream(ctx, s.Tool, args, chunks)
+}
+
+// Audit returns a middleware that records every call in the audit trail of
+// the tenant it was made for. Calls without a tenant aren't recorded.
+func Audit() Middleware {
+	return func(next Tool) Tool {
+		return &auditTool{Tool: next}
+	}
+}
+
+type auditTool struct {
+	Tool
+}
+
+func (a *auditTool) Unwrap() Tool {
+	return a.Tool
+}
+
+func (a *auditTool) Available(ctx context.Context) bool {
+	return isAvailable(ctx, a.Tool)
+}
+
+// begin returns a function that records the call once it's done.
+func (a *auditTool) begin(ctx context.Context, args map[string]any) func(error) {
+	t, ok := tenant.From(ctx)
+	if !ok {
+		return func(error) {}
+	}
+	start := time.Now()
+	return func(err error) {
+		event := tenant.Event{Time: start, Tool: a.Name(), Duration: time.Since(start)}
+		if encoded, jsonErr := json.Marshal(args); jsonErr == nil {
+			event.Args = string(encoded)
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		if err := t.Audit(event); err != nil {
+			log.Printf("Audit of %s for tenant %s failed: %v", a.Name(), t.ID, err)
+		}
+	}
+}
+
+func (a *auditTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	done := a.begin(ctx, args)
+	out, err := a.Tool.Execute(ctx, args)
+	done(err)
+	return out, err
+}
+
+func (a *auditTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	done := a.begin(ctx, args)
+	result, err := Run(ctx, a.Tool, args)
+	done(err)
+	return result, err
+}
+
+func (a *auditTool) ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error) {
+	done := a.begin(ctx, args)
+	result, err := Stream(ctx, a.Tool, args, chunks)
+	done(err)
+	return result, err
+}
+
+// isAvailable reports whether a tool should be offered for this request.
+func isAvailable(ctx context.Context, tool Tool) bool {
+	if !inToolset(ctx, tool.Name()) {
+		return false
+	}
+	if c, ok := tool.(Conditional); ok {
+		return c.Available(ctx)
+	}
+	return true
+}
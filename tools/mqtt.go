@@ -0,0 +1,514 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"telegram-bot/mqtt"
+)
+
+const (
+	mqttStoreKey           = "mqtt_subscriptions"
+	mqttLogPrefix          = "[mqtt]"
+	mqttTimeout            = 10 * time.Second
+	mqttReadWait           = 2 * time.Second // For retained messages to arrive
+	mqttReconnect          = 30 * time.Second
+	maxMQTTSubsPerChat     = 50
+	maxMQTTTopicsPerSub    = 100 // Topics a wildcard subscription remembers values of
+	maxMQTTMessagesRead    = 20
+	maxMQTTPayloadNotified = 500
+)
+
+// mqttSubscription is a chat's subscription to a topic filter. The chat is
+// messaged when a topic's payload changes.
+type mqttSubscription struct {
+	ID      int                  `json:"id"`
+	ChatID  int64                `json:"chat_id"`
+	Topic   string               `json:"topic"` // May have + and # wildcards
+	Name    string               `json:"name,omitempty"`
+	Every   time.Duration        `json:"every,omitempty"`  // At most one message per topic this often
+	Values  map[string]string    `json:"values,omitempty"` // The last payload by topic
+	Sent    map[string]time.Time `json:"sent,omitempty"`   // When each topic last messaged the chat
+	Created time.Time            `json:"created"`
+}
+
+func (s *mqttSubscription) String() string {
+	if s.Name != "" {
+		return fmt.Sprintf("%s (%s)", s.Name, s.Topic)
+	}
+	return s.Topic
+}
+
+type mqttState struct {
+	NextID        int                `json:"next_id"`
+	Subscriptions []mqttSubscription `json:"subscriptions"`
+}
+
+// MQTTTool publishes to and reads topics on an MQTT broker, and keeps one
+// connection subscribed to the chats' topics, so DIY sensors and switches
+// can be driven and heard from.
+type MQTTTool struct {
+	opts mqtt.Options
+
+	host    *Host // Set by Start; nil when background work is unavailable
+	mu      sync.Mutex
+	state   mqttState
+	client  *mqtt.Client // The subscribed connection, while it's up
+	connErr error        // Why it's down
+}
+
+// NewMQTTTool creates an MQTT tool for the broker in opts.
+func NewMQTTTool(opts mqtt.Options) *MQTTTool {
+	return &MQTTTool{opts: opts}
+}
+
+func (m *MQTTTool) Name() string {
+	return "mqtt"
+}
+
+func (m *MQTTTool) Description() string {
+	return `Talk to devices over the MQTT broker, e.g. DIY sensors and switches.
+operation=publish sends payload to topic (retain=true keeps it as the topic's state, e.g. for a switch's set topic).
+operation=read returns the current retained messages of topic, which may use + and # wildcards.
+operation=subscribe with topic and optional name messages this chat whenever a matching topic's payload
+changes; every_minutes limits sensors that report often. operation=status lists this chat's subscriptions
+and their last values; operation=unsubscribe with subscription_id stops one.`
+}
+
+func (m *MQTTTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"publish", "read", "subscribe", "status", "unsubscribe"},
+				"description": "What to do",
+			},
+			"topic": map[string]any{
+				"type":        "string",
+				"description": "The topic, e.g. home/garage/door; for read and subscribe + matches one level and # the rest, e.g. home/+/temperature",
+			},
+			"payload": map[string]any{
+				"type":        "string",
+				"description": "For publish: the message, e.g. ON or {\"state\": \"open\"}",
+			},
+			"retain": map[string]any{
+				"type":        "boolean",
+				"description": "For publish: have the broker keep the message for later subscribers",
+			},
+			"name": map[string]any{
+				"type":        "string",
+				"description": "For subscribe: a short name for the device, e.g. Garage door",
+			},
+			"every_minutes": map[string]any{
+				"type":        "number",
+				"description": "For subscribe: message at most this often per topic; 0 for every change",
+			},
+			"subscription_id": map[string]any{
+				"type":        "number",
+				"description": "For unsubscribe: the subscription number",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (m *MQTTTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+func (m *MQTTTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	topic, _ := args["topic"].(string)
+	topic = strings.TrimSpace(topic)
+	switch operation {
+	case "publish":
+		payload, _ := args["payload"].(string)
+		retain, _ := args["retain"].(bool)
+		return m.publish(ctx, topic, payload, retain)
+	case "read":
+		return m.read(ctx, topic)
+	case "subscribe", "status", "unsubscribe":
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+
+	if m.host == nil {
+		return "", fmt.Errorf("MQTT subscriptions are not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("MQTT subscriptions need a chat to notify")
+	}
+	switch operation {
+	case "subscribe":
+		name, _ := args["name"].(string)
+		every, _ := args["every_minutes"].(float64)
+		return m.subscribe(ctx, chatID, topic, strings.TrimSpace(name), time.Duration(every*float64(time.Minute)))
+	case "unsubscribe":
+		id, ok := args["subscription_id"].(float64)
+		if !ok {
+			return "", fmt.Errorf("subscription_id is required for unsubscribe (see operation=status)")
+		}
+		return m.unsubscribe(ctx, chatID, int(id))
+	default:
+		return m.status(chatID), nil
+	}
+}
+
+// Start loads saved subscriptions and keeps a connection subscribed to
+// them, reconnecting when it drops.
+func (m *MQTTTool) Start(host Host) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.host = &host
+	if _, err := host.Store.Get(mqttStoreKey, &m.state); err != nil {
+		return fmt.Errorf("loading MQTT subscriptions: %w", err)
+	}
+	host.Scheduler.Every("mqtt subscriptions", mqttReconnect, m.listen)
+	log.Printf("%s %d subscriptions on %s", mqttLogPrefix, len(m.state.Subscriptions), m.opts.URL)
+	return nil
+}
+
+// dial opens a connection for a single request.
+func (m *MQTTTool) dial(ctx context.Context) (*mqtt.Client, error) {
+	client, err := mqtt.Dial(ctx, m.opts)
+	if err != nil {
+		return nil, Unavailable(err)
+	}
+	return client, nil
+}
+
+func (m *MQTTTool) publish(ctx context.Context, topic, payload string, retain bool) (string, error) {
+	if err := mqtt.ValidTopic(topic); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, mqttTimeout)
+	defer cancel()
+	client, err := m.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	if err := client.Publish(ctx, topic, []byte(payload), retain); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s published %d bytes to %s", mqttLogPrefix, len(payload), topic)
+	if retain {
+		return fmt.Sprintf("Published %q to %s, retained", payload, topic), nil
+	}
+	return fmt.Sprintf("Published %q to %s", payload, topic), nil
+}
+
+// read subscribes briefly to collect the retained messages of topics
+// matching the filter, which brokers send at once.
+func (m *MQTTTool) read(ctx context.Context, filter string) (string, error) {
+	if err := mqtt.ValidFilter(filter); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, mqttTimeout)
+	defer cancel()
+	client, err := m.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	if err := client.Subscribe(ctx, filter); err != nil {
+		return "", err
+	}
+
+	values := make(map[string]string)
+	wait := time.NewTimer(mqttReadWait)
+	defer wait.Stop()
+collect:
+	for len(values) < maxMQTTMessagesRead {
+		select {
+		case msg, ok := <-client.Messages():
+			if !ok {
+				return "", Unavailable(client.Err())
+			}
+			values[msg.Topic] = mqttPayload(msg.Payload)
+		case <-wait.C:
+			break collect
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	if len(values) == 0 {
+		return fmt.Sprintf("Nothing is retained on %s. The device may not keep its state on the broker; subscribe to hear its next message.", filter), nil
+	}
+
+	topics := make([]string, 0, len(values))
+	for topic := range values {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	var sb strings.Builder
+	for _, topic := range topics {
+		fmt.Fprintf(&sb, "%s: %s\n", topic, values[topic])
+	}
+	if len(values) == maxMQTTMessagesRead {
+		fmt.Fprintf(&sb, "(the first %d topics)\n", maxMQTTMessagesRead)
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+func (m *MQTTTool) subscribe(ctx context.Context, chatID int64, filter, name string, every time.Duration) (string, error) {
+	if err := mqtt.ValidFilter(filter); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	count := 0
+	for _, s := range m.state.Subscriptions {
+		if s.ChatID != chatID {
+			continue
+		}
+		if s.Topic == filter {
+			m.mu.Unlock()
+			return fmt.Sprintf("Already subscribed to %s (subscription #%d)", s.String(), s.ID), nil
+		}
+		count++
+	}
+	if count >= maxMQTTSubsPerChat {
+		m.mu.Unlock()
+		return "", fmt.Errorf("this chat already has %d subscriptions; remove one first", count)
+	}
+	client := m.client
+	m.mu.Unlock()
+
+	// Subscribe the live connection now, so a refused topic shows up at once
+	if client != nil {
+		subCtx, cancel := context.WithTimeout(ctx, mqttTimeout)
+		err := client.Subscribe(subCtx, filter)
+		cancel()
+		if err != nil && client.Err() == nil {
+			return "", err
+		}
+	}
+
+	m.mu.Lock()
+	m.state.NextID++
+	s := mqttSubscription{ID: m.state.NextID, ChatID: chatID, Topic: filter, Name: name, Every: every, Created: time.Now().UTC()}
+	m.state.Subscriptions = append(m.state.Subscriptions, s)
+	err := m.host.Store.Save(mqttStoreKey, m.state)
+	m.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("%s subscription #%d for chat %d: %s", mqttLogPrefix, s.ID, chatID, s.String())
+	reply := fmt.Sprintf("📡 Subscription #%d: %s\nI'll message this chat when its payload changes", s.ID, s.String())
+	if every > 0 {
+		reply += fmt.Sprintf(", at most every %v per topic", every)
+	}
+	return reply + ".", nil
+}
+
+func (m *MQTTTool) unsubscribe(ctx context.Context, chatID int64, id int) (string, error) {
+	m.mu.Lock()
+	i := slices.IndexFunc(m.state.Subscriptions, func(s mqttSubscription) bool { return s.ID == id && s.ChatID == chatID })
+	if i < 0 {
+		m.mu.Unlock()
+		return "", fmt.Errorf("no subscription #%d in this chat", id)
+	}
+	s := m.state.Subscriptions[i]
+	m.state.Subscriptions = slices.Delete(m.state.Subscriptions, i, i+1)
+	err := m.host.Store.Save(mqttStoreKey, m.state)
+	shared := slices.ContainsFunc(m.state.Subscriptions, func(o mqttSubscription) bool { return o.Topic == s.Topic })
+	client := m.client
+	m.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if client != nil && !shared {
+		ctx, cancel := context.WithTimeout(ctx, mqttTimeout)
+		defer cancel()
+		if err := client.Unsubscribe(ctx, s.Topic); err != nil {
+			log.Printf("%s unsubscribing from %s: %v", mqttLogPrefix, s.Topic, err)
+		}
+	}
+	return fmt.Sprintf("Unsubscribed from %s", s.String()), nil
+}
+
+// status lists a chat's subscriptions with the last value of each topic.
+func (m *MQTTTool) status(chatID int64) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sb strings.Builder
+	for _, s := range m.state.Subscriptions {
+		if s.ChatID != chatID {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n#%d %s", s.ID, s.String())
+		if s.Every > 0 {
+			fmt.Fprintf(&sb, ", at most every %v", s.Every)
+		}
+		topics := make([]string, 0, len(s.Values))
+		for topic := range s.Values {
+			topics = append(topics, topic)
+		}
+		slices.Sort(topics)
+		for _, topic := range topics {
+			fmt.Fprintf(&sb, "\n  %s: %s", topic, truncateText(s.Values[topic], 100))
+		}
+	}
+	if sb.Len() == 0 {
+		return "No MQTT subscriptions in this chat. Ask me to subscribe to a topic."
+	}
+
+	header := "📡 Connected to " + m.opts.URL
+	switch {
+	case m.connErr != nil:
+		header = fmt.Sprintf("⚠️ Not connected to %s: %v", m.opts.URL, m.connErr)
+	case m.client == nil:
+		header = "📡 Connecting to " + m.opts.URL
+	}
+	return header + sb.String()
+}
+
+// topics returns the distinct topic filters subscribed to.
+func (m *MQTTTool) topics() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var filters []string
+	for _, s := range m.state.Subscriptions {
+		if !slices.Contains(filters, s.Topic) {
+			filters = append(filters, s.Topic)
+		}
+	}
+	return filters
+}
+
+// listen connects, subscribes to every chat's topics, and handles their
+// messages until the connection drops or the bot stops. The scheduler
+// runs it again to reconnect.
+func (m *MQTTTool) listen(ctx context.Context) error {
+	filters := m.topics()
+	if len(filters) == 0 {
+		return nil
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, mqttTimeout)
+	client, err := mqtt.Dial(dialCtx, m.opts)
+	if err == nil {
+		if err = client.Subscribe(dialCtx, filters...); err != nil {
+			client.Close()
+		}
+	}
+	cancel()
+	if err != nil {
+		if ctx.Err() == nil {
+			m.down(err)
+		}
+		return nil // Logged; retried on the next run
+	}
+
+	m.mu.Lock()
+	m.client, m.connErr = client, nil
+	m.mu.Unlock()
+	log.Printf("%s subscribed to %d topics on %s", mqttLogPrefix, len(filters), m.opts.URL)
+
+	for {
+		select {
+		case <-ctx.Done():
+			client.Close()
+			return nil
+		case msg, ok := <-client.Messages():
+			if !ok {
+				m.down(client.Err())
+				return nil
+			}
+			m.handle(msg)
+		}
+	}
+}
+
+// down records that the subscribed connection is down, logging it once.
+func (m *MQTTTool) down(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.connErr == nil {
+		log.Printf("%s connection to %s lost: %v", mqttLogPrefix, m.opts.URL, err)
+	}
+	m.client, m.connErr = nil, err
+}
+
+// handle messages the chats subscribed to a topic when its payload changes.
+// Retained messages, sent on connecting, only update the values, so
+// reconnecting doesn't repeat old news.
+func (m *MQTTTool) handle(msg mqtt.Message) {
+	payload := mqttPayload(msg.Payload)
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	type notice struct {
+		chatID int64
+		text   string
+	}
+	var notices []notice
+	changed := false
+	for i := range m.state.Subscriptions {
+		s := &m.state.Subscriptions[i]
+		if !mqtt.Match(s.Topic, msg.Topic) {
+			continue
+		}
+		last, seen := s.Values[msg.Topic]
+		if seen && last == payload {
+			continue
+		}
+		if !seen && len(s.Values) >= maxMQTTTopicsPerSub {
+			continue
+		}
+		if s.Values == nil {
+			s.Values = make(map[string]string)
+		}
+		if s.Sent == nil {
+			s.Sent = make(map[string]time.Time)
+		}
+		s.Values[msg.Topic] = payload
+		changed = true
+		if msg.Retained || now.Sub(s.Sent[msg.Topic]) < s.Every {
+			continue
+		}
+		s.Sent[msg.Topic] = now
+		name := s.Name
+		if name == "" || msg.Topic != s.Topic {
+			name = strings.TrimSpace(s.Name + " " + msg.Topic)
+		}
+		notices = append(notices, notice{s.ChatID, fmt.Sprintf("📡 %s: %s", name, truncateText(payload, maxMQTTPayloadNotified))})
+	}
+	var err error
+	if changed {
+		err = m.host.Store.Save(mqttStoreKey, m.state)
+	}
+	m.mu.Unlock()
+	if err != nil {
+		log.Printf("%s saving subscriptions: %v", mqttLogPrefix, err)
+	}
+
+	for _, n := range notices {
+		m.host.Send(n.chatID, n.text)
+	}
+}
+
+// mqttPayload shows a payload as text, or its size if it's binary.
+func mqttPayload(payload []byte) string {
+	if !utf8.Valid(payload) {
+		return fmt.Sprintf("(%d bytes of binary data)", len(payload))
+	}
+	if len(payload) == 0 {
+		return "(empty)"
+	}
+	return strings.TrimSpace(string(payload))
+}
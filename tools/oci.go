@@ -3,26 +3,53 @@ package tools
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
+
+	"telegram-bot/oci"
 )
 
 const (
 	ociTimeout   = 120 * time.Second
 	ociLogPrefix = "[oci]"
 	maxOCIOutput = 100000 // Max output bytes
+
+	// Longer manifest/inspect output is attached as a file
+	maxInlineJSON = 3000
 )
 
 // OCITool provides operations for interacting with container registries.
-// Uses oras, skopeo, and podman CLI tools.
-type OCITool struct{}
+// It talks to registries directly, except for pulls into local storage
+// (podman) and artifact pushes (oras), or with skopeo and oras for
+// everything when configured to use the CLIs.
+type OCITool struct {
+	cli        bool // Use skopeo and oras instead of the registry client
+	clientOnce sync.Once
+	client     *oci.Client
+
+	promotion PromotionConfig
+	auditMu   sync.Mutex // Serializes audit log appends
+
+	host          *Host // Set by Start; nil when background work is unavailable
+	watchInterval time.Duration
+	watchMu       sync.Mutex
+	watches       watchState
+}
 
 // NewOCITool creates a new OCI registry tool.
-func NewOCITool() *OCITool {
-	return &OCITool{}
+func NewOCITool(opts ...OCIOption) *OCITool {
+	o := &OCITool{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
 }
 
 func (o *OCITool) Name() string {
@@ -41,6 +68,18 @@ OPERATIONS:
 - annotate: Add or modify annotations on an image
 - delete: Delete an image tag from a registry
 - push: Push a local artifact to a registry
+- resolve: Get the digest (sha256:...) a tag currently points to
+- layers: List layers with digests, sizes, and media types
+- blob: Fetch a blob by digest (digest=config for the image config); layers are listed as files
+- extract: Show one file from the image filesystem (path), without pulling the image
+- promote: Promote an image between configured environments (image relative to the
+  environment, e.g. app:1.2; from; optional to, default the next environment).
+  Resolves and pins the digest, copies all platforms, optionally annotates, signs,
+  and writes an audit record. Use this instead of copy for dev/staging/prod moves.
+- watch: Message this chat when a repository gets new tags matching pattern (glob,
+  e.g. 3.*), or when a tag (image=alpine:3, no pattern) points to a new digest
+- watches: List this chat's watches
+- unwatch: Stop a watch (watch_id)
 
 EXAMPLES:
 - Inspect image: operation=inspect, image=docker.io/library/alpine:latest
@@ -48,15 +87,42 @@ EXAMPLES:
 - List tags: operation=list-tags, image=docker.io/library/nginx
 - Copy with annotations: operation=copy, source=src:tag, dest=dst:tag, annotations={"key": "value"}
 - Pull image: operation=pull, image=quay.io/repo/image:tag
+- Resolve tag: operation=resolve, image=ghcr.io/org/app:v1.0
+- Read a file: operation=extract, image=alpine:3.19, path=/etc/os-release
+- Image config: operation=blob, image=alpine:3.19, digest=config
+- Promote exactly what was tested: operation=copy, source=ghcr.io/org/app:rc1, dest=ghcr.io/org/app:v1, pin_digest=true
+- Promote to the next environment: operation=promote, image=app:1.2, from=staging
+- Notify on new releases: operation=watch, image=alpine, pattern=3.*
+
+MULTI-ARCH:
+Many images are an index (manifest list) with one image per platform.
+manifest shows the index entries with per-platform digests and sizes; pass
+os and arch (e.g. os=linux, arch=arm64) to inspect, manifest, layers, blob,
+extract, or pull to pick a platform, and to copy to copy only that platform
+(all=true copies every platform).
+
+DIGESTS:
+Any image reference may use a digest instead of a tag (repo@sha256:...).
+Use pin_digest=true on copy to resolve the source tag once and copy that
+exact digest, so a tag pushed mid-copy cannot change what is promoted.
 
 TOOLS USED:
-- skopeo: For inspect, manifest, list-tags, copy, delete
-- oras: For push artifacts, annotate
-- podman: For local image operations when needed
+` + o.toolsUsed() + `
 
 All image references should be fully qualified (registry/repo:tag).`
 }
 
+func (o *OCITool) toolsUsed() string {
+	if o.cli {
+		return `- skopeo: For inspect, manifest, list-tags, copy, delete
+- oras: For push artifacts, annotate, blob fetches (blob, extract)
+- podman: For local image operations when needed`
+	}
+	return `- Registries are reached directly, with the logins of docker login or podman login
+- oras: For push artifacts
+- podman: For pull into local storage`
+}
+
 func (o *OCITool) Parameters() map[string]any {
 	return map[string]any{
 		"type": "object",
@@ -64,11 +130,11 @@ func (o *OCITool) Parameters() map[string]any {
 			"operation": map[string]any{
 				"type":        "string",
 				"description": "The operation to perform",
-				"enum":        []string{"inspect", "manifest", "list-tags", "pull", "copy", "annotate", "delete", "push"},
+				"enum":        []string{"inspect", "manifest", "list-tags", "pull", "copy", "annotate", "delete", "push", "resolve", "layers", "blob", "extract", "promote", "watch", "watches", "unwatch"},
 			},
 			"image": map[string]any{
 				"type":        "string",
-				"description": "Image reference (registry/repo:tag) for inspect, manifest, list-tags, pull, delete",
+				"description": "Image reference (registry/repo:tag or registry/repo@sha256:...) for inspect, manifest, list-tags, pull, delete, resolve",
 			},
 			"source": map[string]any{
 				"type":        "string",
@@ -98,11 +164,79 @@ func (o *OCITool) Parameters() map[string]any {
 				"type":        "boolean",
 				"description": "For pull/copy: copy all architectures (multi-arch)",
 			},
+			"os": map[string]any{
+				"type":        "string",
+				"description": "Platform OS for multi-arch images (default linux)",
+			},
+			"arch": map[string]any{
+				"type":        "string",
+				"description": "Platform architecture for multi-arch images, e.g. amd64, arm64",
+			},
+			"variant": map[string]any{
+				"type":        "string",
+				"description": "Platform variant, e.g. v8 for arm64 or v7 for arm",
+			},
+			"digest": map[string]any{
+				"type":        "string",
+				"description": "For blob: sha256:... digest of the blob, or 'config'",
+			},
+			"path": map[string]any{
+				"type":        "string",
+				"description": "For extract: absolute path of the file inside the image, e.g. /etc/os-release",
+			},
+			"from": map[string]any{
+				"type":        "string",
+				"description": "For promote: source environment, e.g. staging",
+			},
+			"to": map[string]any{
+				"type":        "string",
+				"description": "For promote: target environment (default: the next one)",
+			},
+			"pattern": map[string]any{
+				"type":        "string",
+				"description": "For watch: tag glob to watch for, e.g. 3.* or v1.*",
+			},
+			"watch_id": map[string]any{
+				"type":        "number",
+				"description": "For unwatch: the watch number",
+			},
+			"pin_digest": map[string]any{
+				"type":        "boolean",
+				"description": "For copy: resolve the source tag to a digest first and copy exactly that digest",
+			},
 		},
 		"required": []string{"operation"},
 	}
 }
 
+func (o *OCITool) Metadata() Metadata {
+	// copy, annotate, delete, and push modify registries
+	return Metadata{Dangerous: true, Cost: CostHigh}
+}
+
+func (o *OCITool) BenchArgs() (map[string]any, string) {
+	return map[string]any{
+		"operation": "inspect",
+		"image":     "docker.io/library/alpine:latest",
+	}, "Digest"
+}
+
+// Requirements maps each CLI to the operations that run it. With the CLIs,
+// manifests are read with skopeo, so blob and extract need both.
+func (o *OCITool) Requirements() []Requirement {
+	if !o.cli {
+		return []Requirement{
+			{Name: "oras", Command: "oras", Operations: []string{"push"}},
+			{Name: "podman", Command: "podman", Operations: []string{"pull"}},
+		}
+	}
+	return []Requirement{
+		{Name: "skopeo", Command: "skopeo", Operations: []string{"inspect", "manifest", "list-tags", "copy", "delete", "resolve", "layers", "blob", "extract", "promote", "watch"}},
+		{Name: "oras", Command: "oras", Operations: []string{"annotate", "push", "blob", "extract"}},
+		{Name: "podman", Command: "podman", Operations: []string{"pull"}},
+	}
+}
+
 func (o *OCITool) Execute(ctx context.Context, args map[string]any) (string, error) {
 	operation, _ := args["operation"].(string)
 	if operation == "" {
@@ -121,18 +255,56 @@ func (o *OCITool) Execute(ctx context.Context, args map[string]any) (string, err
 	case "pull":
 		return o.pull(ctx, args)
 	case "copy":
-		return o.copyImage(ctx, args)
+		return o.copy(ctx, args)
 	case "annotate":
 		return o.annotate(ctx, args)
 	case "delete":
 		return o.delete(ctx, args)
 	case "push":
 		return o.push(ctx, args)
+	case "resolve":
+		return o.resolve(ctx, args)
+	case "layers":
+		return o.layers(ctx, args)
+	case "blob":
+		return o.blob(ctx, args)
+	case "extract":
+		return o.extract(ctx, args)
+	case "promote":
+		return o.promote(ctx, args)
+	case "watch":
+		return o.watch(ctx, args)
+	case "watches":
+		return o.listWatches(ctx)
+	case "unwatch":
+		return o.unwatch(ctx, args)
 	default:
 		return "", fmt.Errorf("unknown operation: %s", operation)
 	}
 }
 
+// ExecuteRich runs the operation, sending long manifest and inspect output
+// as a JSON document instead of a wall of text.
+func (o *OCITool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	text, err := o.Execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	operation, _ := args["operation"].(string)
+	if (operation != "manifest" && operation != "inspect") || len(text) <= maxInlineJSON {
+		return &Result{Text: text}, nil
+	}
+
+	image, _ := args["image"].(string)
+	name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image) + "-" + operation + ".json"
+	return &Result{
+		Text: fmt.Sprintf("Full %s output (%d bytes) attached as %s. First part:\n%s",
+			operation, len(text), name, text[:maxInlineJSON]),
+		Attachments: []Attachment{{Name: name, Data: []byte(text), Kind: AttachDocument}},
+	}, nil
+}
+
 func (o *OCITool) inspect(ctx context.Context, args map[string]any) (string, error) {
 	image, _ := args["image"].(string)
 	if image == "" {
@@ -140,10 +312,122 @@ func (o *OCITool) inspect(ctx context.Context, args map[string]any) (string, err
 	}
 
 	ref := o.normalizeRef(image)
-	log.Printf("%s inspect %s", ociLogPrefix, ref)
+	platform := platformArg(args)
+	log.Printf("%s inspect %s (platform=%s)", ociLogPrefix, ref, platform)
+
+	var output string
+	var err error
+	if o.cli {
+		output, err = o.inspectCLI(ctx, ref, platform)
+	} else {
+		output, err = o.inspectImage(ctx, ref, platform)
+	}
+	if err != nil {
+		return output, err
+	}
 
-	// Use skopeo inspect
-	return o.runCommand(ctx, "skopeo", "inspect", "docker://"+ref)
+	// Say which platforms exist so the model knows it saw only one
+	if data, err := o.rawManifest(ctx, ref); err == nil {
+		var m ociManifest
+		if json.Unmarshal(data, &m) == nil && m.isIndex() {
+			shown := platform
+			if shown == "" && o.cli {
+				shown = "the host platform"
+			} else if shown == "" {
+				shown = defaultPlatform
+			}
+			output = fmt.Sprintf("Multi-arch image with platforms: %s\nShowing %s; pass os/arch to choose another.\n\n%s",
+				strings.Join(indexPlatforms(&m), ", "), shown, output)
+		}
+	}
+	return output, nil
+}
+
+// ociInspection is what inspect shows, in the shape of skopeo inspect's
+// output.
+type ociInspection struct {
+	Name          string
+	Digest        string
+	RepoTags      []string
+	Created       *time.Time `json:",omitempty"`
+	DockerVersion string     `json:",omitempty"`
+	Labels        map[string]string
+	Architecture  string
+	Variant       string `json:",omitempty"`
+	Os            string
+	Layers        []string
+	LayersData    []ociLayerData
+	Env           []string
+}
+
+type ociLayerData struct {
+	MIMEType    string
+	Digest      string
+	Size        int64
+	Annotations map[string]string
+}
+
+// inspectImage describes one platform's image from its manifest and
+// config blob, and lists the repository's tags, as skopeo inspect does.
+func (o *OCITool) inspectImage(ctx context.Context, ref, platform string) (string, error) {
+	raw, err := o.rawManifest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	m, _, err := o.imageManifest(ctx, ref, platform)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := o.fetchBlob(ctx, repository(ref), m.Config.Digest)
+	if err != nil {
+		return "", fmt.Errorf("fetching image config: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var config struct {
+		Created       *time.Time `json:"created"`
+		DockerVersion string     `json:"docker_version"`
+		Architecture  string     `json:"architecture"`
+		Variant       string     `json:"variant"`
+		OS            string     `json:"os"`
+		Config        struct {
+			Labels map[string]string `json:"Labels"`
+			Env    []string          `json:"Env"`
+		} `json:"config"`
+	}
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return "", fmt.Errorf("parsing image config: %w", err)
+	}
+
+	info := ociInspection{
+		Name:          repository(ref),
+		Digest:        oci.Digest(raw),
+		RepoTags:      []string{},
+		Created:       config.Created,
+		DockerVersion: config.DockerVersion,
+		Labels:        config.Config.Labels,
+		Architecture:  config.Architecture,
+		Variant:       config.Variant,
+		Os:            config.OS,
+		Layers:        []string{},
+		LayersData:    []ociLayerData{},
+		Env:           config.Config.Env,
+	}
+	// Some registries don't let everyone who can pull list tags
+	if tags, err := o.tags(ctx, repository(ref)); err == nil {
+		info.RepoTags = tags
+	}
+	for _, l := range m.Layers {
+		info.Layers = append(info.Layers, l.Digest)
+		info.LayersData = append(info.LayersData, ociLayerData{MIMEType: l.MediaType, Digest: l.Digest, Size: l.Size, Annotations: l.Annotations})
+	}
+
+	out, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return truncateOCI(string(out)), nil
 }
 
 func (o *OCITool) manifest(ctx context.Context, args map[string]any) (string, error) {
@@ -153,27 +437,45 @@ func (o *OCITool) manifest(ctx context.Context, args map[string]any) (string, er
 	}
 
 	ref := o.normalizeRef(image)
-	log.Printf("%s manifest %s", ociLogPrefix, ref)
+	platform := platformArg(args)
+	log.Printf("%s manifest %s (platform=%s)", ociLogPrefix, ref, platform)
 
 	raw, _ := args["raw"].(bool)
 
-	cmdArgs := []string{"inspect", "--raw"}
-	if !raw {
-		// Pipe through jq for formatting if available
-		cmdArgs = append(cmdArgs, "docker://"+ref)
-		output, err := o.runCommand(ctx, "skopeo", cmdArgs...)
+	data, err := o.rawManifest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	var m ociManifest
+	json.Unmarshal(data, &m)
+
+	// Follow the index to one platform's manifest if asked
+	if m.isIndex() && platform != "" {
+		entry, err := selectPlatform(m.Manifests, platform)
 		if err != nil {
-			return output, err
+			return "", err
 		}
-		// Try to format with jq
-		formatted, fmtErr := o.runCommandInput(ctx, output, "jq", ".")
-		if fmtErr == nil {
-			return formatted, nil
+		ref = repository(ref) + "@" + entry.Digest
+		if data, err = o.rawManifest(ctx, ref); err != nil {
+			return "", err
 		}
-		return output, nil
+		m = ociManifest{}
+		json.Unmarshal(data, &m)
+	}
+
+	if raw {
+		return truncateOCI(string(data)), nil
 	}
 
-	return o.runCommand(ctx, "skopeo", append(cmdArgs, "docker://"+ref)...)
+	output := string(data)
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, data, "", "  ") == nil {
+		output = pretty.String()
+	}
+	if m.isIndex() {
+		output = o.renderIndex(ctx, ref, data, &m) + "\n\n" + output
+	}
+	return truncateOCI(output), nil
 }
 
 func (o *OCITool) listTags(ctx context.Context, args map[string]any) (string, error) {
@@ -182,15 +484,27 @@ func (o *OCITool) listTags(ctx context.Context, args map[string]any) (string, er
 		return "", fmt.Errorf("image is required for list-tags")
 	}
 
-	// Remove tag if present for list-tags
-	ref := o.normalizeRef(image)
-	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
-		ref = ref[:idx]
-	}
+	// Remove tag or digest if present for list-tags
+	ref := repository(o.normalizeRef(image))
 
 	log.Printf("%s list-tags %s", ociLogPrefix, ref)
 
-	return o.runCommand(ctx, "skopeo", "list-tags", "docker://"+ref)
+	if o.cli {
+		return o.runCommand(ctx, "skopeo", "list-tags", "docker://"+ref)
+	}
+	tags, err := o.tags(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	// The same shape as skopeo list-tags
+	out, err := json.MarshalIndent(struct {
+		Repository string
+		Tags       []string
+	}{ref, tags}, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return truncateOCI(string(out)), nil
 }
 
 func (o *OCITool) pull(ctx context.Context, args map[string]any) (string, error) {
@@ -209,12 +523,15 @@ func (o *OCITool) pull(ctx context.Context, args map[string]any) (string, error)
 	if all {
 		cmdArgs = append(cmdArgs, "--all-tags")
 	}
+	if platform := platformArg(args); platform != "" {
+		cmdArgs = append(cmdArgs, "--platform", platform)
+	}
 	cmdArgs = append(cmdArgs, ref)
 
 	return o.runCommand(ctx, "podman", cmdArgs...)
 }
 
-func (o *OCITool) copyImage(ctx context.Context, args map[string]any) (string, error) {
+func (o *OCITool) copy(ctx context.Context, args map[string]any) (string, error) {
 	source, _ := args["source"].(string)
 	dest, _ := args["dest"].(string)
 	if source == "" || dest == "" {
@@ -225,63 +542,191 @@ func (o *OCITool) copyImage(ctx context.Context, args map[string]any) (string, e
 	dstRef := o.normalizeRef(dest)
 	all, _ := args["all"].(bool)
 
+	// Pin the source to the digest its tag points to right now
+	var pinned string
+	if pin, _ := args["pin_digest"].(bool); pin && !strings.Contains(srcRef, "@") {
+		digest, err := o.digest(ctx, srcRef)
+		if err != nil {
+			return "", fmt.Errorf("resolving %s: %w", srcRef, err)
+		}
+		pinned = fmt.Sprintf("Pinned %s to %s\n", srcRef, digest)
+		srcRef = repository(srcRef) + "@" + digest
+	}
+
+	annotations, err := parseAnnotations(args)
+	if err != nil {
+		return "", err
+	}
+
 	log.Printf("%s copy %s -> %s", ociLogPrefix, srcRef, dstRef)
 
-	cmdArgs := []string{"copy"}
-	if all {
-		cmdArgs = append(cmdArgs, "--all")
+	platform := platformArg(args)
+	var output string
+	if o.cli {
+		output, err = o.copyCLI(ctx, srcRef, dstRef, all, platform)
+	} else {
+		output, err = o.copyImage(ctx, srcRef, dstRef, all, platform)
+	}
+	if err != nil {
+		return output, err
+	}
+
+	// Copies keep the manifest as it is, so annotating rewrites it afterwards
+	if len(annotations) > 0 {
+		annotated, err := o.annotateImage(ctx, dstRef, annotations)
+		if err != nil {
+			return pinned + output + "\n" + annotated, err
+		}
+		output += "\n" + annotated
+	}
+	return pinned + output, nil
+}
+
+// copyImage copies with the registry client. Without all, only one image
+// of a multi-arch index is copied, for the platform given or linux/amd64.
+func (o *OCITool) copyImage(ctx context.Context, srcRef, dstRef string, all bool, platform string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+
+	src, err := oci.ParseReference(srcRef)
+	if err != nil {
+		return "", err
+	}
+	dst, err := oci.ParseReference(dstRef)
+	if err != nil {
+		return "", err
+	}
+
+	if !all {
+		raw, err := o.rawManifest(ctx, srcRef)
+		if err != nil {
+			return "", err
+		}
+		var m ociManifest
+		if json.Unmarshal(raw, &m) == nil && m.isIndex() {
+			entry, err := selectPlatform(m.Manifests, platform)
+			if err != nil {
+				return "", err
+			}
+			log.Printf("%s copy: platform %s only", ociLogPrefix, entry.Platform)
+			src = src.WithDigest(entry.Digest)
+		}
+	}
+
+	digest, err := o.registry().Copy(ctx, src, dst)
+	if err != nil {
+		return "", fmt.Errorf("copying %s to %s: %w", src, dst, registryError(err))
+	}
+	return fmt.Sprintf("Copied %s to %s\nDigest: %s", src, dst, digest), nil
+}
+
+// copyAll copies an image with every platform, so an index keeps its
+// digest.
+func (o *OCITool) copyAll(ctx context.Context, srcRef, dstRef string) error {
+	if o.cli {
+		_, err := o.runCommand(ctx, "skopeo", "copy", "--all", "docker://"+srcRef, "docker://"+dstRef)
+		return err
 	}
+	_, err := o.copyImage(ctx, srcRef, dstRef, true, "")
+	return err
+}
 
-	// Handle annotations if provided
-	annotations, _ := args["annotations"].(string)
-	if annotations != "" {
-		// Parse annotations and add them
-		// skopeo doesn't support annotations directly, so we note this
-		log.Printf("%s note: annotations will be added via manifest modification", ociLogPrefix)
+func (o *OCITool) resolve(ctx context.Context, args map[string]any) (string, error) {
+	image, _ := args["image"].(string)
+	if image == "" {
+		return "", fmt.Errorf("image is required for resolve")
 	}
 
-	cmdArgs = append(cmdArgs, "docker://"+srcRef, "docker://"+dstRef)
+	ref := o.normalizeRef(image)
+	log.Printf("%s resolve %s", ociLogPrefix, ref)
 
-	return o.runCommand(ctx, "skopeo", cmdArgs...)
+	digest, err := o.digest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\nPinned reference: %s@%s", digest, repository(ref), digest), nil
+}
+
+// digest returns the registry digest of ref: the sha256 of its raw manifest
+// (the index, for multi-arch images), which is what repo@digest refers to.
+func (o *OCITool) digest(ctx context.Context, ref string) (string, error) {
+	raw, err := o.rawManifest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return oci.Digest(raw), nil
+}
+
+// rawManifest fetches the manifest bytes exactly as the registry serves them.
+func (o *OCITool) rawManifest(ctx context.Context, ref string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+
+	if o.cli {
+		return o.rawManifestCLI(ctx, ref)
+	}
+	r, err := oci.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%s fetching manifest %s", ociLogPrefix, r)
+	raw, _, err := o.registry().Manifest(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest: %w", registryError(err))
+	}
+	return raw, nil
 }
 
 func (o *OCITool) annotate(ctx context.Context, args map[string]any) (string, error) {
 	image, _ := args["image"].(string)
-	annotations, _ := args["annotations"].(string)
 	if image == "" {
 		return "", fmt.Errorf("image is required for annotate")
 	}
-	if annotations == "" {
+	annotations, err := parseAnnotations(args)
+	if err != nil {
+		return "", err
+	}
+	if len(annotations) == 0 {
 		return "", fmt.Errorf("annotations JSON is required for annotate")
 	}
 
 	ref := o.normalizeRef(image)
-	log.Printf("%s annotate %s with %s", ociLogPrefix, ref, annotations)
-
-	// Use oras for annotation
-	// oras manifest annotate <ref> --annotation key=value
-	// Parse the JSON annotations and convert to --annotation flags
-	cmdArgs := []string{"manifest", "annotate", ref}
-
-	// Simple parsing of JSON object
-	annotations = strings.TrimSpace(annotations)
-	annotations = strings.TrimPrefix(annotations, "{")
-	annotations = strings.TrimSuffix(annotations, "}")
-
-	// Split by comma and add each annotation
-	for _, pair := range strings.Split(annotations, ",") {
-		pair = strings.TrimSpace(pair)
-		if pair == "" {
-			continue
-		}
-		// Remove quotes and convert to key=value format
-		pair = strings.ReplaceAll(pair, "\"", "")
-		pair = strings.ReplaceAll(pair, ": ", "=")
-		pair = strings.ReplaceAll(pair, ":", "=")
-		cmdArgs = append(cmdArgs, "--annotation", pair)
+	log.Printf("%s annotate %s with %d annotations", ociLogPrefix, ref, len(annotations))
+	return o.annotateImage(ctx, ref, annotations)
+}
+
+// annotateImage adds annotations to the manifest ref's tag points to. The
+// manifest gets a new digest.
+func (o *OCITool) annotateImage(ctx context.Context, ref string, annotations map[string]string) (string, error) {
+	if o.cli {
+		return o.annotateCLI(ctx, ref, annotations)
 	}
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
 
-	return o.runCommand(ctx, "oras", cmdArgs...)
+	r, err := oci.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	digest, err := o.registry().Annotate(ctx, r, annotations)
+	if err != nil {
+		return "", fmt.Errorf("annotating %s: %w", r, registryError(err))
+	}
+	return fmt.Sprintf("Annotated %s\nNew digest: %s", r, digest), nil
+}
+
+// parseAnnotations reads the annotations argument, a JSON object of
+// strings.
+func parseAnnotations(args map[string]any) (map[string]string, error) {
+	raw, _ := args["annotations"].(string)
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var annotations map[string]string
+	if err := json.Unmarshal([]byte(raw), &annotations); err != nil {
+		return nil, fmt.Errorf("annotations should be a JSON object of strings, e.g. {\"key\": \"value\"}: %w", err)
+	}
+	return annotations, nil
 }
 
 func (o *OCITool) delete(ctx context.Context, args map[string]any) (string, error) {
@@ -293,7 +738,20 @@ func (o *OCITool) delete(ctx context.Context, args map[string]any) (string, erro
 	ref := o.normalizeRef(image)
 	log.Printf("%s delete %s", ociLogPrefix, ref)
 
-	return o.runCommand(ctx, "skopeo", "delete", "docker://"+ref)
+	if o.cli {
+		return o.runCommand(ctx, "skopeo", "delete", "docker://"+ref)
+	}
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+	r, err := oci.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	digest, err := o.registry().Delete(ctx, r)
+	if err != nil {
+		return "", fmt.Errorf("deleting %s: %w", r, registryError(err))
+	}
+	return fmt.Sprintf("Deleted %s (%s)", r, digest), nil
 }
 
 func (o *OCITool) push(ctx context.Context, args map[string]any) (string, error) {
@@ -316,23 +774,11 @@ func (o *OCITool) push(ctx context.Context, args map[string]any) (string, error)
 
 	cmdArgs := []string{"push", dstRef, artifact}
 
-	// Add annotations if provided
-	annotations, _ := args["annotations"].(string)
-	if annotations != "" {
-		annotations = strings.TrimSpace(annotations)
-		annotations = strings.TrimPrefix(annotations, "{")
-		annotations = strings.TrimSuffix(annotations, "}")
-		for _, pair := range strings.Split(annotations, ",") {
-			pair = strings.TrimSpace(pair)
-			if pair == "" {
-				continue
-			}
-			pair = strings.ReplaceAll(pair, "\"", "")
-			pair = strings.ReplaceAll(pair, ": ", "=")
-			pair = strings.ReplaceAll(pair, ":", "=")
-			cmdArgs = append(cmdArgs, "--annotation", pair)
-		}
+	annotations, err := parseAnnotations(args)
+	if err != nil {
+		return "", err
 	}
+	cmdArgs = append(cmdArgs, annotationFlags(annotations)...)
 
 	return o.runCommand(ctx, "oras", cmdArgs...)
 }
@@ -343,74 +789,55 @@ func (o *OCITool) normalizeRef(ref string) string {
 	ref = strings.TrimPrefix(ref, "oci://")
 
 	// If no registry specified, assume docker.io
+	first, _, _ := strings.Cut(ref, "/")
 	if !strings.Contains(ref, "/") {
 		ref = "docker.io/library/" + ref
-	} else if !strings.Contains(strings.Split(ref, "/")[0], ".") {
-		// No dot in first segment, assume docker.io
+	} else if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		// No dot or port in first segment, assume docker.io
 		ref = "docker.io/" + ref
 	}
 
 	return ref
 }
 
-func (o *OCITool) runCommand(ctx context.Context, name string, args ...string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
-	defer cancel()
-
-	log.Printf("%s exec: %s %s", ociLogPrefix, name, strings.Join(args, " "))
-
-	cmd := exec.CommandContext(ctx, name, args...)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	start := time.Now()
-	err := cmd.Run()
-	duration := time.Since(start)
-
-	output := stdout.String()
-	errOutput := stderr.String()
-
-	if len(output) > maxOCIOutput {
-		output = output[:maxOCIOutput] + "\n... (truncated)"
-	}
-
-	if err != nil {
-		log.Printf("%s FAILED (%v) - %v", ociLogPrefix, duration, err)
-		if errOutput != "" {
-			log.Printf("%s stderr: %s", ociLogPrefix, errOutput)
-			return fmt.Sprintf("Error: %s\n%s", err.Error(), errOutput), err
-		}
-		return fmt.Sprintf("Error: %s", err.Error()), err
+// repository strips the tag and digest from a reference.
+func repository(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		ref = ref[:idx]
 	}
+	return ref
+}
 
-	log.Printf("%s OK (%v) stdout=%d stderr=%d", ociLogPrefix, duration, len(output), len(errOutput))
+// registry returns the registry client, sending its requests through the
+// egress proxy under the oci policy.
+func (o *OCITool) registry() *oci.Client {
+	o.clientOnce.Do(func() {
+		o.client = oci.NewClient(oci.Options{Transport: egressTransport("oci")})
+	})
+	return o.client
+}
 
-	if output != "" {
-		return output, nil
+// registryError marks registry outages and rate limits as Unavailable, so
+// they count toward the circuit breaker, and says how to log in when the
+// registry refuses access.
+func registryError(err error) error {
+	var regErr *oci.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &regErr) && regErr.Temporary():
+		return Unavailable(err)
+	case errors.As(err, &regErr) && regErr.Denied():
+		return fmt.Errorf("%w (log in on the bot's host with docker login or podman login)", err)
+	case regErr == nil && errors.As(err, &urlErr):
+		return Unavailable(err) // Couldn't reach the registry
 	}
-	if errOutput != "" {
-		return errOutput, nil
-	}
-	return "Command completed successfully", nil
+	return err
 }
 
-func (o *OCITool) runCommandInput(ctx context.Context, input string, name string, args ...string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, name, args...)
-	cmd.Stdin = strings.NewReader(input)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-	if err != nil {
-		return stderr.String(), err
+func truncateOCI(output string) string {
+	if len(output) > maxOCIOutput {
+		return output[:maxOCIOutput] + "\n... (truncated)"
 	}
-
-	return stdout.String(), nil
+	return output
 }
@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WithCLI runs the registry operations with skopeo and oras instead of the
+// built-in registry client, for logins only they support, such as
+// credential helpers.
+func WithCLI(enabled bool) OCIOption {
+	return func(o *OCITool) {
+		o.cli = enabled
+	}
+}
+
+func (o *OCITool) inspectCLI(ctx context.Context, ref, platform string) (string, error) {
+	// skopeo inspect picks one platform out of a multi-arch index
+	cmdArgs := append(skopeoPlatformFlags(platform), "inspect", "docker://"+ref)
+	return o.runCommand(ctx, "skopeo", cmdArgs...)
+}
+
+func (o *OCITool) copyCLI(ctx context.Context, srcRef, dstRef string, all bool, platform string) (string, error) {
+	// A platform copies just that image out of a multi-arch index
+	cmdArgs := []string{"copy"}
+	if all {
+		cmdArgs = append(cmdArgs, "--all")
+	} else if platform != "" {
+		cmdArgs = append(skopeoPlatformFlags(platform), cmdArgs...)
+	}
+	cmdArgs = append(cmdArgs, "docker://"+srcRef, "docker://"+dstRef)
+	return o.runCommand(ctx, "skopeo", cmdArgs...)
+}
+
+func (o *OCITool) annotateCLI(ctx context.Context, ref string, annotations map[string]string) (string, error) {
+	cmdArgs := append([]string{"manifest", "annotate", ref}, annotationFlags(annotations)...)
+	return o.runCommand(ctx, "oras", cmdArgs...)
+}
+
+// annotationFlags turns annotations into oras --annotation flags.
+func annotationFlags(annotations map[string]string) []string {
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var flags []string
+	for _, k := range keys {
+		flags = append(flags, "--annotation", k+"="+annotations[k])
+	}
+	return flags
+}
+
+// rawManifestCLI fetches the manifest bytes with skopeo.
+func (o *OCITool) rawManifestCLI(ctx context.Context, ref string) ([]byte, error) {
+	log.Printf("%s exec: skopeo inspect --raw docker://%s", ociLogPrefix, ref)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "skopeo", "inspect", "--raw", "docker://"+ref)
+	cmd.Stderr = &stderr
+	isolate(ctx, cmd, "oci")
+	raw, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return raw, nil
+}
+
+// fetchBlobCLI writes a blob to w with oras.
+func (o *OCITool) fetchBlobCLI(ctx context.Context, repo, digest string, w io.Writer) error {
+	log.Printf("%s exec: oras blob fetch --output - %s@%s", ociLogPrefix, repo, digest)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "oras", "blob", "fetch", "--output", "-", repo+"@"+digest)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	isolate(ctx, cmd, "oci")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("fetching blob: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// tagsCLI lists every tag of a repository with skopeo.
+func (o *OCITool) tagsCLI(ctx context.Context, repo string) ([]string, error) {
+	log.Printf("%s exec: skopeo list-tags docker://%s", ociLogPrefix, repo)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "skopeo", "list-tags", "docker://"+repo)
+	cmd.Stderr = &stderr
+	isolate(ctx, cmd, "oci")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing tags of %s: %w: %s", repo, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		Tags []string `json:"Tags"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("parsing tags of %s: %w", repo, err)
+	}
+	return result.Tags, nil
+}
+
+// skopeoPlatformFlags selects a platform for skopeo's global options.
+func skopeoPlatformFlags(platform string) []string {
+	if platform == "" {
+		return nil
+	}
+	parts := strings.Split(platform, "/")
+	flags := []string{"--override-os", parts[0], "--override-arch", parts[1]}
+	if len(parts) > 2 {
+		flags = append(flags, "--override-variant", parts[2])
+	}
+	return flags
+}
+
+func (o *OCITool) runCommand(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+
+	log.Printf("%s exec: %s %s", ociLogPrefix, name, strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	isolate(ctx, cmd, "oci")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	duration := time.Since(start)
+
+	output := stdout.String()
+	errOutput := stderr.String()
+
+	output = truncateOCI(output)
+
+	if err != nil {
+		log.Printf("%s FAILED (%v) - %v", ociLogPrefix, duration, err)
+		if errOutput != "" {
+			log.Printf("%s stderr: %s", ociLogPrefix, errOutput)
+			return fmt.Sprintf("Error: %s\n%s", err.Error(), errOutput), err
+		}
+		return fmt.Sprintf("Error: %s", err.Error()), err
+	}
+
+	log.Printf("%s OK (%v) stdout=%d stderr=%d", ociLogPrefix, duration, len(output), len(errOutput))
+
+	if output != "" {
+		return output, nil
+	}
+	if errOutput != "" {
+		return errOutput, nil
+	}
+	return "Command completed successfully", nil
+}
@@ -0,0 +1,507 @@
+package tools
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"telegram-bot/oci"
+)
+
+const (
+	maxExtractBytes = 1 << 20 // Largest file extract returns
+	maxBlobEntries  = 200     // Entries listed for a layer blob
+	maxSymlinkHops  = 8
+	maxIndexSizes   = 16 // Index entries whose image size manifest looks up
+	defaultPlatform = "linux/amd64"
+	whiteoutPrefix  = ".wh."
+	whiteoutOpaque  = ".wh..wh..opq"
+	gzipMagic       = "\x1f\x8b"
+	zstdMagic       = "\x28\xb5\x2f\xfd"
+)
+
+// ociDescriptor points at a manifest, config, or layer blob.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p *ociPlatform) String() string {
+	if p == nil {
+		return "unknown"
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// ociManifest covers both image manifests and indexes (manifest lists) in
+// the OCI and Docker v2 formats, which share these field names.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+func (m *ociManifest) isIndex() bool {
+	return len(m.Manifests) > 0
+}
+
+// imageManifest fetches the manifest for ref, following an index to the
+// entry for the requested platform.
+func (o *OCITool) imageManifest(ctx context.Context, ref, platform string) (*ociManifest, string, error) {
+	raw, err := o.rawManifest(ctx, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	var m ociManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, "", fmt.Errorf("parsing manifest: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if !m.isIndex() {
+		return &m, digest, nil
+	}
+
+	entry, err := selectPlatform(m.Manifests, platform)
+	if err != nil {
+		return nil, "", err
+	}
+	return o.imageManifest(ctx, repository(ref)+"@"+entry.Digest, platform)
+}
+
+// selectPlatform picks the index entry matching os/arch[/variant].
+func selectPlatform(entries []ociDescriptor, platform string) (*ociDescriptor, error) {
+	if platform == "" {
+		platform = defaultPlatform
+	}
+	want := strings.Split(platform, "/")
+
+	var available []string
+	for i, e := range entries {
+		if e.Platform == nil || e.Platform.OS == "unknown" {
+			continue
+		}
+		available = append(available, e.Platform.String())
+		if e.Platform.OS != want[0] || (len(want) > 1 && e.Platform.Architecture != want[1]) {
+			continue
+		}
+		if len(want) > 2 && e.Platform.Variant != want[2] {
+			continue
+		}
+		return &entries[i], nil
+	}
+	return nil, fmt.Errorf("no %s image in this index (available: %s)", platform, strings.Join(available, ", "))
+}
+
+// platformArg builds os/arch[/variant] from the tool arguments, or returns ""
+// if neither os nor arch was given.
+func platformArg(args map[string]any) string {
+	osName, _ := args["os"].(string)
+	arch, _ := args["arch"].(string)
+	variant, _ := args["variant"].(string)
+	if osName == "" && arch == "" {
+		return ""
+	}
+	if osName == "" {
+		osName = "linux"
+	}
+	if arch == "" {
+		arch = "amd64"
+	}
+	if variant != "" {
+		return osName + "/" + arch + "/" + variant
+	}
+	return osName + "/" + arch
+}
+
+// indexPlatforms lists the platforms in an index, skipping attestations.
+func indexPlatforms(m *ociManifest) []string {
+	var platforms []string
+	for _, e := range m.Manifests {
+		if e.Platform != nil && e.Platform.OS != "unknown" {
+			platforms = append(platforms, e.Platform.String())
+		}
+	}
+	return platforms
+}
+
+// renderIndex summarizes a multi-arch index: each platform's manifest digest
+// and the compressed size of its image.
+func (o *OCITool) renderIndex(ctx context.Context, ref string, data []byte, m *ociManifest) string {
+	sum := sha256.Sum256(data)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Multi-arch index sha256:%s with %d entries:\n", hex.EncodeToString(sum[:]), len(m.Manifests))
+
+	for i, e := range m.Manifests {
+		size := "?"
+		if i < maxIndexSizes && e.Platform != nil && e.Platform.OS != "unknown" {
+			if img, _, err := o.imageManifest(ctx, repository(ref)+"@"+e.Digest, ""); err == nil {
+				var total int64
+				for _, l := range img.Layers {
+					total += l.Size
+				}
+				size = formatSize(total)
+			}
+		}
+
+		platform := e.Platform.String()
+		if e.Platform != nil && e.Platform.OS == "unknown" {
+			platform = "attestation"
+		}
+		fmt.Fprintf(&b, "- %-14s %s  image %s\n", platform, e.Digest, size)
+	}
+	b.WriteString("Pass os/arch to select one platform.")
+	return b.String()
+}
+
+func (o *OCITool) layers(ctx context.Context, args map[string]any) (string, error) {
+	image, _ := args["image"].(string)
+	if image == "" {
+		return "", fmt.Errorf("image is required for layers")
+	}
+	ref := o.normalizeRef(image)
+	log.Printf("%s layers %s", ociLogPrefix, ref)
+
+	m, digest, err := o.imageManifest(ctx, ref, platformArg(args))
+	if err != nil {
+		return "", err
+	}
+
+	var total int64
+	for _, l := range m.Layers {
+		total += l.Size
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Image: %s\nManifest: %s\n", ref, digest)
+	fmt.Fprintf(&b, "Config: %s (%s)\n", m.Config.Digest, formatSize(m.Config.Size))
+	fmt.Fprintf(&b, "Layers: %d, %s compressed\n", len(m.Layers), formatSize(total))
+	for i, l := range m.Layers {
+		fmt.Fprintf(&b, "%2d. %s  %s  %s\n", i+1, l.Digest, formatSize(l.Size), l.MediaType)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func (o *OCITool) blob(ctx context.Context, args map[string]any) (string, error) {
+	image, _ := args["image"].(string)
+	digest, _ := args["digest"].(string)
+	if image == "" || digest == "" {
+		return "", fmt.Errorf("image and digest are required for blob (use digest=config for the image config)")
+	}
+	ref := o.normalizeRef(image)
+
+	if digest == "config" {
+		m, _, err := o.imageManifest(ctx, ref, platformArg(args))
+		if err != nil {
+			return "", err
+		}
+		digest = m.Config.Digest
+	}
+	log.Printf("%s blob %s@%s", ociLogPrefix, repository(ref), digest)
+
+	f, err := o.fetchBlob(ctx, repository(ref), digest)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	// Layers: list the files instead of dumping a tarball
+	switch {
+	case bytes.HasPrefix(head, []byte(zstdMagic)):
+		return fmt.Sprintf("%s is a zstd-compressed layer; listing it is not supported", digest), nil
+	case bytes.HasPrefix(head, []byte(gzipMagic)) || isTar(head):
+		r, err := layerReader(f)
+		if err != nil {
+			return "", err
+		}
+		return listLayer(digest, r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, maxExtractBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("reading blob: %w", err)
+	}
+	if json.Valid(data) {
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, data, "", "  ") == nil {
+			return pretty.String(), nil
+		}
+	}
+	if utf8.Valid(data) && len(data) <= maxExtractBytes {
+		return string(data), nil
+	}
+	info, _ := f.Stat()
+	return fmt.Sprintf("%s is a binary blob (%s)", digest, formatSize(info.Size())), nil
+}
+
+// listLayer lists the entries of a layer tarball.
+func listLayer(digest string, r io.Reader) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Layer %s:\n", digest)
+	tr := tar.NewReader(r)
+	count := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading layer: %w", err)
+		}
+		if count++; count > maxBlobEntries {
+			fmt.Fprintf(&b, "... (more than %d entries)\n", maxBlobEntries)
+			break
+		}
+		name := "/" + strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			b.WriteString(name + "/\n")
+		case tar.TypeSymlink:
+			fmt.Fprintf(&b, "%s -> %s\n", name, hdr.Linkname)
+		default:
+			fmt.Fprintf(&b, "%s (%s)\n", name, formatSize(hdr.Size))
+		}
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func (o *OCITool) extract(ctx context.Context, args map[string]any) (string, error) {
+	image, _ := args["image"].(string)
+	target, _ := args["path"].(string)
+	if image == "" || target == "" {
+		return "", fmt.Errorf("image and path are required for extract")
+	}
+	ref := o.normalizeRef(image)
+	log.Printf("%s extract %s from %s", ociLogPrefix, target, ref)
+
+	m, _, err := o.imageManifest(ctx, ref, platformArg(args))
+	if err != nil {
+		return "", err
+	}
+
+	// Download each layer at most once while following symlinks
+	blobs := make(map[string]*os.File)
+	defer func() {
+		for _, f := range blobs {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	open := func(digest string) (io.Reader, error) {
+		f, ok := blobs[digest]
+		if !ok {
+			var err error
+			if f, err = o.fetchBlob(ctx, repository(ref), digest); err != nil {
+				return nil, err
+			}
+			blobs[digest] = f
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return layerReader(f)
+	}
+
+	want := path.Clean("/" + target)
+	for hop := 0; hop <= maxSymlinkHops; hop++ {
+		hdr, content, err := findInLayers(m.Layers, want, open)
+		if err != nil {
+			return "", err
+		}
+		if hdr == nil {
+			return "", fmt.Errorf("%s not found in %s", want, ref)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			link := hdr.Linkname
+			if !path.IsAbs(link) {
+				link = path.Join(path.Dir(want), link)
+			}
+			log.Printf("%s extract: %s is a symlink to %s", ociLogPrefix, want, link)
+			want = path.Clean(link)
+			continue
+		case tar.TypeLink:
+			want = path.Clean("/" + hdr.Linkname)
+			continue
+		case tar.TypeDir:
+			return "", fmt.Errorf("%s is a directory; use blob on a layer to list files", want)
+		}
+
+		if hdr.Size > maxExtractBytes {
+			return fmt.Sprintf("%s is %s, too large to show (limit %s)", want, formatSize(hdr.Size), formatSize(maxExtractBytes)), nil
+		}
+		if !utf8.Valid(content) {
+			return fmt.Sprintf("%s is a binary file (%s)", want, formatSize(hdr.Size)), nil
+		}
+		return fmt.Sprintf("%s:\n%s", want, content), nil
+	}
+	return "", fmt.Errorf("too many symlinks resolving %s", target)
+}
+
+// findInLayers searches layers from the top down for want, honouring
+// whiteouts. It returns a nil header if the file does not exist.
+func findInLayers(layers []ociDescriptor, want string, open func(string) (io.Reader, error)) (*tar.Header, []byte, error) {
+	dir, base := path.Split(want)
+	for i := len(layers) - 1; i >= 0; i-- {
+		r, err := open(layers[i].Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tr := tar.NewReader(r)
+		opaque := false
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading layer %s: %w", layers[i].Digest, err)
+			}
+
+			name := path.Clean("/" + hdr.Name)
+			switch {
+			case name == want:
+				var content []byte
+				if hdr.Typeflag == tar.TypeReg && hdr.Size <= maxExtractBytes {
+					if content, err = io.ReadAll(tr); err != nil {
+						return nil, nil, fmt.Errorf("reading %s: %w", want, err)
+					}
+				}
+				return hdr, content, nil
+			case name == path.Join(dir, whiteoutPrefix+base):
+				return nil, nil, nil // Deleted in this layer
+			case isOpaqueFor(name, want):
+				opaque = true
+			}
+		}
+		if opaque {
+			return nil, nil, nil // A directory above it was replaced in this layer
+		}
+	}
+	return nil, nil, nil
+}
+
+// isOpaqueFor reports whether name is an opaque whiteout for a directory containing want.
+func isOpaqueFor(name, want string) bool {
+	if path.Base(name) != whiteoutOpaque {
+		return false
+	}
+	dir := path.Dir(name)
+	return dir == "/" || strings.HasPrefix(want, dir+"/")
+}
+
+// layerReader returns an uncompressed reader for a layer blob.
+func layerReader(f *os.File) (io.Reader, error) {
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(head, []byte(gzipMagic)):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, []byte(zstdMagic)):
+		return nil, errors.New("zstd-compressed layers are not supported")
+	default:
+		return br, nil
+	}
+}
+
+// isTar checks for the ustar magic in the first tar header.
+func isTar(head []byte) bool {
+	return len(head) >= 262 && bytes.HasPrefix(head[257:], []byte("ustar"))
+}
+
+// fetchBlob downloads a blob into a temp file, verifying its digest. The
+// caller closes and removes the file.
+func (o *OCITool) fetchBlob(ctx context.Context, repo, digest string) (*os.File, error) {
+	algo, want, ok := strings.Cut(digest, ":")
+	if !ok || algo != "sha256" || len(want) != 64 {
+		return nil, fmt.Errorf("invalid digest %q (expected sha256:<64 hex chars>)", digest)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+
+	f, err := os.CreateTemp("", "oci-blob-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp file: %w", err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	hash := sha256.New()
+	if o.cli {
+		err = o.fetchBlobCLI(ctx, repo, digest, io.MultiWriter(f, hash))
+	} else {
+		err = o.downloadBlob(ctx, repo, digest, io.MultiWriter(f, hash))
+	}
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		cleanup()
+		return nil, fmt.Errorf("blob digest mismatch: got sha256:%s", got)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return f, nil
+}
+
+// downloadBlob writes a blob to w with the registry client.
+func (o *OCITool) downloadBlob(ctx context.Context, repo, digest string, w io.Writer) error {
+	ref, err := oci.ParseReference(repo + "@" + digest)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s fetching blob %s", ociLogPrefix, ref)
+	body, err := o.registry().Blob(ctx, ref, digest)
+	if err != nil {
+		return fmt.Errorf("fetching blob: %w", registryError(err))
+	}
+	defer body.Close()
+	if _, err := io.Copy(w, body); err != nil {
+		return fmt.Errorf("fetching blob: %w", Unavailable(err))
+	}
+	return nil
+}
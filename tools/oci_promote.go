@@ -0,0 +1,240 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"telegram-bot/auth"
+)
+
+// Environment is one stage of a promotion pipeline and the registry
+// namespace its images live under, e.g. staging=ghcr.io/org/staging.
+type Environment struct {
+	Name     string
+	Registry string
+}
+
+// ParseEnvironments parses "name=registry/namespace" pairs, in promotion order.
+func ParseEnvironments(pairs []string) ([]Environment, error) {
+	var envs []Environment
+	seen := make(map[string]bool)
+	for _, pair := range pairs {
+		name, registry, ok := strings.Cut(pair, "=")
+		name, registry = strings.TrimSpace(name), strings.TrimSuffix(strings.TrimSpace(registry), "/")
+		if !ok || name == "" || registry == "" {
+			return nil, fmt.Errorf("invalid environment %q (expected name=registry/namespace)", pair)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("environment %s is listed twice", name)
+		}
+		seen[name] = true
+		envs = append(envs, Environment{Name: name, Registry: registry})
+	}
+	return envs, nil
+}
+
+// PromotionConfig configures the OCI tool's promote operation.
+type PromotionConfig struct {
+	Environments []Environment // In promotion order, e.g. dev, staging, prod
+	SignKey      string        // cosign key reference; empty skips signing
+	AuditLog     string        // JSON-lines file recording every promotion; empty disables
+}
+
+// OCIOption configures an OCITool.
+type OCIOption func(*OCITool)
+
+// WithPromotion enables the promote operation.
+func WithPromotion(cfg PromotionConfig) OCIOption {
+	return func(o *OCITool) {
+		o.promotion = cfg
+	}
+}
+
+// promotionRecord is one line of the promotion audit log.
+type promotionRecord struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	UserID   int64     `json:"user_id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Source   string    `json:"source"`
+	Dest     string    `json:"dest"`
+	Digest   string    `json:"digest,omitempty"`
+	Steps    []string  `json:"steps"`
+	Success  bool      `json:"success"`
+	ErrorMsg string    `json:"error,omitempty"`
+}
+
+func (o *OCITool) environment(name string) (int, bool) {
+	for i, env := range o.promotion.Environments {
+		if env.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (o *OCITool) environmentNames() string {
+	names := make([]string, len(o.promotion.Environments))
+	for i, env := range o.promotion.Environments {
+		names[i] = env.Name
+	}
+	return strings.Join(names, " → ")
+}
+
+// promote copies an image from one environment to the next as a single
+// pipeline: resolve and pin the source digest, copy every platform,
+// optionally annotate, sign, and record the result in the audit log.
+func (o *OCITool) promote(ctx context.Context, args map[string]any) (string, error) {
+	if len(o.promotion.Environments) < 2 {
+		return "", fmt.Errorf("promotion is not configured (set OCI_ENVIRONMENTS, e.g. dev=ghcr.io/org/dev,prod=ghcr.io/org/prod)")
+	}
+
+	image, _ := args["image"].(string)
+	from, _ := args["from"].(string)
+	to, _ := args["to"].(string)
+	if image == "" || from == "" {
+		return "", fmt.Errorf("image (e.g. app:1.2) and from are required for promote; environments: %s", o.environmentNames())
+	}
+	if first, _, nested := strings.Cut(image, "/"); nested && strings.ContainsAny(first, ".:") {
+		return "", fmt.Errorf("image must be relative to the environment (e.g. app:1.2), not a full reference")
+	}
+
+	fromIdx, ok := o.environment(from)
+	if !ok {
+		return "", fmt.Errorf("unknown environment %s; environments: %s", from, o.environmentNames())
+	}
+	toIdx := fromIdx + 1
+	if to != "" {
+		if toIdx, ok = o.environment(to); !ok {
+			return "", fmt.Errorf("unknown environment %s; environments: %s", to, o.environmentNames())
+		}
+	}
+	if toIdx >= len(o.promotion.Environments) {
+		return "", fmt.Errorf("%s is the last environment; nothing to promote to", from)
+	}
+	if toIdx <= fromIdx {
+		return "", fmt.Errorf("promotion must move forward (%s)", o.environmentNames())
+	}
+
+	fromEnv, toEnv := o.promotion.Environments[fromIdx], o.promotion.Environments[toIdx]
+	source := fromEnv.Registry + "/" + image
+	dest := toEnv.Registry + "/" + image
+
+	user, _ := auth.UserFrom(ctx)
+	record := promotionRecord{
+		Time:   time.Now().UTC(),
+		User:   user.UserName,
+		UserID: user.ID,
+		From:   fromEnv.Name,
+		To:     toEnv.Name,
+		Source: source,
+		Dest:   dest,
+	}
+	log.Printf("%s promote %s (%s) -> %s (%s) by %s", ociLogPrefix, source, fromEnv.Name, dest, toEnv.Name, user.UserName)
+
+	err := o.runPromotion(ctx, &record, args)
+	record.Success = err == nil
+	if err != nil {
+		record.ErrorMsg = err.Error()
+	}
+	if auditErr := o.writeAudit(record); auditErr != nil {
+		log.Printf("%s audit log: %v", ociLogPrefix, auditErr)
+		record.Steps = append(record.Steps, "⚠️ audit log write failed: "+auditErr.Error())
+	}
+
+	var b strings.Builder
+	if err != nil {
+		fmt.Fprintf(&b, "❌ Promotion %s → %s failed\n\n", fromEnv.Name, toEnv.Name)
+	} else {
+		fmt.Fprintf(&b, "✅ Promoted %s from %s to %s\n\n", image, fromEnv.Name, toEnv.Name)
+	}
+	for _, step := range record.Steps {
+		b.WriteString(step + "\n")
+	}
+	if err != nil {
+		b.WriteString("\nError: " + err.Error())
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// runPromotion performs the pipeline steps, recording each in record.Steps.
+func (o *OCITool) runPromotion(ctx context.Context, record *promotionRecord, args map[string]any) error {
+	digest, err := o.digest(ctx, record.Source)
+	if err != nil {
+		record.Steps = append(record.Steps, "❌ resolve "+record.Source)
+		return err
+	}
+	record.Digest = digest
+	record.Steps = append(record.Steps, fmt.Sprintf("✅ resolve %s → %s", record.Source, digest))
+
+	// Copy the pinned digest with every platform so the index digest is preserved
+	pinned := repository(record.Source) + "@" + digest
+	if err := o.copyAll(ctx, pinned, record.Dest); err != nil {
+		record.Steps = append(record.Steps, "❌ copy to "+record.Dest)
+		return err
+	}
+	record.Steps = append(record.Steps, "✅ copy to "+record.Dest)
+
+	signed := repository(record.Dest) + "@" + digest
+	if annotations, _ := args["annotations"].(string); annotations != "" {
+		if _, err := o.annotate(ctx, map[string]any{"image": record.Dest, "annotations": annotations}); err != nil {
+			record.Steps = append(record.Steps, "❌ annotate")
+			return err
+		}
+		// Annotating rewrites the manifest, so sign what the tag points to now
+		newDigest, err := o.digest(ctx, record.Dest)
+		if err != nil {
+			record.Steps = append(record.Steps, "❌ resolve annotated manifest")
+			return err
+		}
+		record.Digest = newDigest
+		signed = repository(record.Dest) + "@" + newDigest
+		record.Steps = append(record.Steps, "✅ annotate (new digest "+newDigest+")")
+	}
+
+	if o.promotion.SignKey == "" {
+		record.Steps = append(record.Steps, "⏭️ sign (no OCI_SIGN_KEY configured)")
+		return nil
+	}
+	if _, err := exec.LookPath("cosign"); err != nil {
+		record.Steps = append(record.Steps, "❌ sign")
+		return fmt.Errorf("cosign is not installed")
+	}
+	if _, err := o.runCommand(ctx, "cosign", "sign", "--yes", "--key", o.promotion.SignKey, signed); err != nil {
+		record.Steps = append(record.Steps, "❌ sign "+signed)
+		return err
+	}
+	record.Steps = append(record.Steps, "✅ sign "+signed)
+	return nil
+}
+
+// writeAudit appends the record to the audit log.
+func (o *OCITool) writeAudit(record promotionRecord) error {
+	if o.promotion.AuditLog == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	o.auditMu.Lock()
+	defer o.auditMu.Unlock()
+
+	f, err := os.OpenFile(o.promotion.AuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,328 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"telegram-bot/oci"
+)
+
+const (
+	watchesStoreKey      = "oci_watches"
+	defaultWatchInterval = time.Hour
+	maxWatchesPerChat    = 20
+	maxNewTagsListed     = 20
+)
+
+// ociWatch is a subscription to a repository: either new tags matching a
+// pattern, or digest changes of a single tag.
+type ociWatch struct {
+	ID        int       `json:"id"`
+	ChatID    int64     `json:"chat_id"`
+	Repo      string    `json:"repo"`
+	Pattern   string    `json:"pattern,omitempty"` // Tag glob; empty when watching a tag's digest
+	Tag       string    `json:"tag,omitempty"`
+	Digest    string    `json:"digest,omitempty"`
+	Known     []string  `json:"known,omitempty"` // Tags matching Pattern seen so far
+	Created   time.Time `json:"created"`
+	Checked   time.Time `json:"checked"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func (w *ociWatch) String() string {
+	if w.Pattern != "" {
+		return fmt.Sprintf("new tags of %s matching %s", w.Repo, w.Pattern)
+	}
+	return fmt.Sprintf("digest changes of %s:%s", w.Repo, w.Tag)
+}
+
+// watchState is the persisted list of subscriptions.
+type watchState struct {
+	NextID  int        `json:"next_id"`
+	Watches []ociWatch `json:"watches"`
+}
+
+// WithWatchInterval sets how often watched repositories are polled.
+func WithWatchInterval(interval time.Duration) OCIOption {
+	return func(o *OCITool) {
+		o.watchInterval = interval
+	}
+}
+
+// Start loads saved subscriptions and schedules polling for them.
+func (o *OCITool) Start(host Host) error {
+	o.watchMu.Lock()
+	defer o.watchMu.Unlock()
+
+	o.host = &host
+	if _, err := host.Store.Get(watchesStoreKey, &o.watches); err != nil {
+		return fmt.Errorf("loading watches: %w", err)
+	}
+
+	interval := o.watchInterval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	host.Scheduler.Every("oci watches", interval, o.pollWatches)
+	log.Printf("%s watching %d repositories every %v", ociLogPrefix, len(o.watches.Watches), interval)
+	return nil
+}
+
+func (o *OCITool) watch(ctx context.Context, args map[string]any) (string, error) {
+	if o.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("watch needs a chat to notify")
+	}
+
+	image, _ := args["image"].(string)
+	pattern, _ := args["pattern"].(string)
+	if image == "" {
+		return "", fmt.Errorf("image is required for watch (e.g. alpine with pattern 3.*, or alpine:3 to watch its digest)")
+	}
+	if strings.Contains(image, "@") {
+		return "", fmt.Errorf("a digest reference never changes; watch a tag or a repository instead")
+	}
+
+	ref := o.normalizeRef(image)
+	w := ociWatch{ChatID: chatID, Repo: repository(ref), Pattern: pattern, Created: time.Now().UTC()}
+	if tag := strings.TrimPrefix(ref, w.Repo+":"); tag != ref && pattern == "" {
+		w.Tag = tag
+	} else if w.Pattern == "" {
+		w.Pattern = "*"
+	}
+	if _, err := path.Match(w.Pattern, ""); err != nil {
+		return "", fmt.Errorf("invalid pattern %q: %w", w.Pattern, err)
+	}
+
+	// Record the current state so only later changes are reported
+	if err := o.check(ctx, &w); err != nil {
+		return "", err
+	}
+
+	o.watchMu.Lock()
+	defer o.watchMu.Unlock()
+
+	count := 0
+	for _, existing := range o.watches.Watches {
+		if existing.ChatID != chatID {
+			continue
+		}
+		if existing.Repo == w.Repo && existing.Pattern == w.Pattern && existing.Tag == w.Tag {
+			return fmt.Sprintf("Already watching %s (watch #%d)", existing.String(), existing.ID), nil
+		}
+		count++
+	}
+	if count >= maxWatchesPerChat {
+		return "", fmt.Errorf("this chat already has %d watches; remove one with unwatch first", count)
+	}
+
+	o.watches.NextID++
+	w.ID = o.watches.NextID
+	o.watches.Watches = append(o.watches.Watches, w)
+	if err := o.host.Store.Save(watchesStoreKey, o.watches); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s watch #%d for chat %d: %s", ociLogPrefix, w.ID, chatID, w.String())
+	current := fmt.Sprintf("current digest %s", w.Digest)
+	if w.Pattern != "" {
+		current = fmt.Sprintf("%d matching tags so far", len(w.Known))
+	}
+	return fmt.Sprintf("👀 Watch #%d: %s (%s). I'll check every %v and message this chat when something changes.",
+		w.ID, w.String(), current, o.interval()), nil
+}
+
+func (o *OCITool) unwatch(ctx context.Context, args map[string]any) (string, error) {
+	if o.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, _ := ChatFrom(ctx)
+	id, ok := args["watch_id"].(float64)
+	if !ok {
+		return "", fmt.Errorf("watch_id is required for unwatch (see operation=watches)")
+	}
+
+	o.watchMu.Lock()
+	defer o.watchMu.Unlock()
+
+	for i, w := range o.watches.Watches {
+		if w.ID != int(id) || w.ChatID != chatID {
+			continue
+		}
+		o.watches.Watches = slices.Delete(o.watches.Watches, i, i+1)
+		if err := o.host.Store.Save(watchesStoreKey, o.watches); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Stopped watching %s", w.String()), nil
+	}
+	return "", fmt.Errorf("no watch #%d in this chat", int(id))
+}
+
+func (o *OCITool) listWatches(ctx context.Context) (string, error) {
+	if o.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, _ := ChatFrom(ctx)
+
+	o.watchMu.Lock()
+	defer o.watchMu.Unlock()
+
+	var b strings.Builder
+	for _, w := range o.watches.Watches {
+		if w.ChatID != chatID {
+			continue
+		}
+		fmt.Fprintf(&b, "#%d %s", w.ID, w.String())
+		if !w.Checked.IsZero() {
+			fmt.Fprintf(&b, " (checked %s)", w.Checked.Local().Format("Jan 2 15:04"))
+		}
+		if w.LastError != "" {
+			fmt.Fprintf(&b, "\n   ⚠️ last check failed: %s", w.LastError)
+		}
+		b.WriteString("\n")
+	}
+	if b.Len() == 0 {
+		return "No watches in this chat.", nil
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func (o *OCITool) interval() time.Duration {
+	if o.watchInterval <= 0 {
+		return defaultWatchInterval
+	}
+	return o.watchInterval
+}
+
+// pollWatches checks every subscription and notifies chats about changes.
+// Failures are recorded on the watch and shown by the watches operation
+// rather than reported to the owners, since a registry outage is not a bot failure.
+func (o *OCITool) pollWatches(ctx context.Context) error {
+	o.watchMu.Lock()
+	watches := slices.Clone(o.watches.Watches)
+	o.watchMu.Unlock()
+
+	for i := range watches {
+		if ctx.Err() != nil {
+			return nil
+		}
+		w := &watches[i]
+		before := *w
+		before.Known = slices.Clone(w.Known)
+
+		if err := o.check(ctx, w); err != nil {
+			log.Printf("%s watch #%d: %v", ociLogPrefix, w.ID, err)
+			w.LastError = err.Error()
+		} else if msg := changeMessage(&before, w); msg != "" {
+			o.host.Send(w.ChatID, msg)
+		}
+		o.updateWatch(*w)
+	}
+
+	o.watchMu.Lock()
+	defer o.watchMu.Unlock()
+	return o.host.Store.Save(watchesStoreKey, o.watches)
+}
+
+// updateWatch stores the result of a check, unless the watch was removed meanwhile.
+func (o *OCITool) updateWatch(w ociWatch) {
+	o.watchMu.Lock()
+	defer o.watchMu.Unlock()
+
+	for i := range o.watches.Watches {
+		if o.watches.Watches[i].ID == w.ID {
+			o.watches.Watches[i] = w
+			return
+		}
+	}
+}
+
+// check refreshes the watch's known tags or digest.
+func (o *OCITool) check(ctx context.Context, w *ociWatch) error {
+	w.Checked = time.Now().UTC()
+	w.LastError = ""
+
+	if w.Pattern == "" {
+		digest, err := o.digest(ctx, w.Repo+":"+w.Tag)
+		if err != nil {
+			return err
+		}
+		w.Digest = digest
+		return nil
+	}
+
+	tags, err := o.tags(ctx, w.Repo)
+	if err != nil {
+		return err
+	}
+	var matching []string
+	for _, tag := range tags {
+		if ok, _ := path.Match(w.Pattern, tag); ok {
+			matching = append(matching, tag)
+		}
+	}
+	slices.Sort(matching)
+	w.Known = matching
+	return nil
+}
+
+// changeMessage describes what changed between two checks of a watch, or
+// returns "" if nothing did.
+func changeMessage(before, after *ociWatch) string {
+	if after.Pattern == "" {
+		if before.Digest == "" || before.Digest == after.Digest {
+			return ""
+		}
+		return fmt.Sprintf("🔔 %s:%s was updated\n\nOld digest: %s\nNew digest: %s\n\n(watch #%d)",
+			after.Repo, after.Tag, before.Digest, after.Digest, after.ID)
+	}
+
+	var added []string
+	for _, tag := range after.Known {
+		if _, found := slices.BinarySearch(before.Known, tag); !found {
+			added = append(added, tag)
+		}
+	}
+	if len(added) == 0 {
+		return ""
+	}
+
+	listed := added
+	if len(listed) > maxNewTagsListed {
+		listed = listed[:maxNewTagsListed]
+	}
+	msg := fmt.Sprintf("🔔 New %s tags matching %s: %s", after.Repo, after.Pattern, strings.Join(listed, ", "))
+	if len(added) > len(listed) {
+		msg += fmt.Sprintf(" and %d more", len(added)-len(listed))
+	}
+	return msg + fmt.Sprintf("\n\n(watch #%d)", after.ID)
+}
+
+// tags lists every tag of a repository. Unlike list-tags, the output is
+// never truncated, so large repositories are compared completely.
+func (o *OCITool) tags(ctx context.Context, repo string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+
+	if o.cli {
+		return o.tagsCLI(ctx, repo)
+	}
+	ref, err := oci.ParseReference(repo)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%s listing tags of %s", ociLogPrefix, ref.Name())
+	tags, err := o.registry().Tags(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("listing tags of %s: %w", repo, registryError(err))
+	}
+	return tags, nil
+}
@@ -0,0 +1,350 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	placesTimeout       = 30 * time.Second
+	defaultPlacesRadius = 1000 // meters
+	maxPlacesRadius     = 5000
+	defaultPlacesLimit  = 5
+	maxPlacesLimit      = 15
+)
+
+// placeCategories maps the words people use for places to OpenStreetMap
+// tags. Anything else is searched for by name.
+var placeCategories = map[string]string{
+	"cafe":             `["amenity"="cafe"]`,
+	"coffee":           `["amenity"="cafe"]`,
+	"coffee shop":      `["amenity"="cafe"]`,
+	"restaurant":       `["amenity"="restaurant"]`,
+	"food":             `["amenity"~"^(restaurant|fast_food|food_court)$"]`,
+	"fast food":        `["amenity"="fast_food"]`,
+	"bar":              `["amenity"~"^(bar|pub)$"]`,
+	"pub":              `["amenity"="pub"]`,
+	"bakery":           `["shop"="bakery"]`,
+	"supermarket":      `["shop"="supermarket"]`,
+	"grocery":          `["shop"~"^(supermarket|convenience|greengrocer)$"]`,
+	"convenience":      `["shop"="convenience"]`,
+	"pharmacy":         `["amenity"="pharmacy"]`,
+	"drugstore":        `["amenity"="pharmacy"]`,
+	"hospital":         `["amenity"="hospital"]`,
+	"doctor":           `["amenity"="doctors"]`,
+	"dentist":          `["amenity"="dentist"]`,
+	"atm":              `["amenity"="atm"]`,
+	"bank":             `["amenity"="bank"]`,
+	"gas station":      `["amenity"="fuel"]`,
+	"petrol station":   `["amenity"="fuel"]`,
+	"fuel":             `["amenity"="fuel"]`,
+	"charging station": `["amenity"="charging_station"]`,
+	"ev charger":       `["amenity"="charging_station"]`,
+	"parking":          `["amenity"="parking"]`,
+	"toilet":           `["amenity"="toilets"]`,
+	"restroom":         `["amenity"="toilets"]`,
+	"post office":      `["amenity"="post_office"]`,
+	"library":          `["amenity"="library"]`,
+	"park":             `["leisure"="park"]`,
+	"playground":       `["leisure"="playground"]`,
+	"gym":              `["leisure"="fitness_centre"]`,
+	"hotel":            `["tourism"="hotel"]`,
+	"museum":           `["tourism"="museum"]`,
+	"cinema":           `["amenity"="cinema"]`,
+	"bus stop":         `["highway"="bus_stop"]`,
+	"train station":    `["railway"="station"]`,
+	"bike rental":      `["amenity"="bicycle_rental"]`,
+}
+
+// PlacesTool finds amenities near the user with OpenStreetMap: the geocoder
+// turns place names into coordinates and Overpass finds what is around them.
+type PlacesTool struct {
+	geocoder    *Geocoder
+	overpassURL string
+	httpClient  *http.Client
+}
+
+// NewPlacesTool creates a places tool that searches the given Overpass API
+// endpoint.
+func NewPlacesTool(geocoder *Geocoder, overpassURL string) *PlacesTool {
+	return &PlacesTool{
+		geocoder:    geocoder,
+		overpassURL: overpassURL,
+		httpClient:  &http.Client{Timeout: placesTimeout},
+	}
+}
+
+func (p *PlacesTool) Name() string {
+	return "places"
+}
+
+func (p *PlacesTool) Description() string {
+	return `Find places near the user, such as cafes, pharmacies, or ATMs, from OpenStreetMap.
+
+Searches around the location the user shared in Telegram, or around a named place
+given in near ("near Alexanderplatz, Berlin"). Returns names, distances, opening hours,
+and map links, nearest first. Set open_now=true for "open now" questions.`
+}
+
+func (p *PlacesTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"query": map[string]any{
+				"type":        "string",
+				"description": "What to look for: a kind of place (cafe, pharmacy, atm, supermarket, gas station, ...) or a name (\"Starbucks\")",
+			},
+			"near": map[string]any{
+				"type":        "string",
+				"description": "A place name or address to search around; leave empty to use the user's shared location",
+			},
+			"open_now": map[string]any{
+				"type":        "boolean",
+				"description": "Only list places whose opening hours say they are open now",
+			},
+			"radius": map[string]any{
+				"type":        "integer",
+				"description": "Search radius in meters (default 1000, max 5000)",
+			},
+			"limit": map[string]any{
+				"type":        "integer",
+				"description": "Maximum places to list (default 5, max 15)",
+			},
+		},
+		"required": []string{"query"},
+	}
+}
+
+func (p *PlacesTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+func (p *PlacesTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"query": "cafe", "near": "Alexanderplatz, Berlin"}, ""
+}
+
+// place is an OpenStreetMap element found by a search.
+type place struct {
+	Type     string // node, way, or relation
+	ID       int64
+	Name     string
+	Kind     string
+	Address  string
+	Hours    string
+	Loc      Location
+	Distance float64 // meters
+}
+
+func (p *PlacesTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	query, _ := args["query"].(string)
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", fmt.Errorf("query is required")
+	}
+	near, _ := args["near"].(string)
+	openNow, _ := args["open_now"].(bool)
+	radius := boundedInt(args, "radius", defaultPlacesRadius, maxPlacesRadius)
+	limit := boundedInt(args, "limit", defaultPlacesLimit, maxPlacesLimit)
+
+	center, where, err := p.center(ctx, strings.TrimSpace(near))
+	if err != nil {
+		return "", err
+	}
+
+	places, err := p.search(ctx, query, center, radius)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	listed := 0
+	for _, pl := range places {
+		open, known := openAt(pl.Hours, now)
+		if openNow && known && !open {
+			continue
+		}
+		if listed == limit {
+			break
+		}
+		listed++
+
+		fmt.Fprintf(&b, "\n%d. %s", listed, pl.Name)
+		if pl.Kind != "" {
+			fmt.Fprintf(&b, " (%s)", pl.Kind)
+		}
+		fmt.Fprintf(&b, " — %s", formatDistance(pl.Distance))
+		switch {
+		case pl.Hours == "":
+		case !known:
+			fmt.Fprintf(&b, ", hours: %s", pl.Hours)
+		case open:
+			fmt.Fprintf(&b, ", open now (%s)", pl.Hours)
+		default:
+			fmt.Fprintf(&b, ", closed now (%s)", pl.Hours)
+		}
+		if pl.Address != "" {
+			fmt.Fprintf(&b, "\n   %s", pl.Address)
+		}
+		fmt.Fprintf(&b, "\n   https://www.openstreetmap.org/%s/%d", pl.Type, pl.ID)
+	}
+
+	filter := ""
+	if openNow {
+		filter = " that are open now"
+	}
+	if listed == 0 {
+		return fmt.Sprintf("No %s found within %s of %s%s.", query, formatDistance(float64(radius)), where, filter), nil
+	}
+	header := fmt.Sprintf("📍 %d result(s) for %q within %s of %s%s:\n", listed, query, formatDistance(float64(radius)), where, filter)
+	if openNow {
+		header += "(Places without opening hours in OpenStreetMap are included.)\n"
+	}
+	return header + b.String(), nil
+}
+
+// center returns the point to search around and how to describe it: the
+// named place if one is given, else the user's shared location.
+func (p *PlacesTool) center(ctx context.Context, near string) (Location, string, error) {
+	if near != "" {
+		loc, name, err := p.geocoder.Geocode(ctx, near)
+		if err != nil {
+			return Location{}, "", err
+		}
+		return loc, name, nil
+	}
+	if loc, ok := LocationFrom(ctx); ok {
+		return loc, "your location", nil
+	}
+	return Location{}, "", fmt.Errorf("the user's location is unknown: ask them to share their location in Telegram (📎 → Location), or pass near with a place name")
+}
+
+// search asks Overpass for named places matching the query within radius
+// meters of center, nearest first.
+func (p *PlacesTool) search(ctx context.Context, query string, center Location, radius int) ([]place, error) {
+	filter := placeFilter(query)
+	if filter == "" {
+		return nil, fmt.Errorf("query must contain letters or digits")
+	}
+	ql := fmt.Sprintf(`[out:json][timeout:25];nwr%s["name"](around:%d,%f,%f);out center 100;`,
+		filter, radius, center.Lat, center.Lon)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.overpassURL, strings.NewReader(url.Values{"data": {ql}}.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct {
+		Elements []struct {
+			Type   string            `json:"type"`
+			ID     int64             `json:"id"`
+			Lat    float64           `json:"lat"`
+			Lon    float64           `json:"lon"`
+			Center *Location         `json:"center"`
+			Tags   map[string]string `json:"tags"`
+		} `json:"elements"`
+	}
+	if err := doJSON(p.httpClient, req, &result); err != nil {
+		return nil, fmt.Errorf("searching OpenStreetMap: %w", err)
+	}
+
+	places := make([]place, 0, len(result.Elements))
+	for _, el := range result.Elements {
+		loc := Location{Lat: el.Lat, Lon: el.Lon}
+		if el.Center != nil {
+			loc = *el.Center
+		}
+		places = append(places, place{
+			Type:     el.Type,
+			ID:       el.ID,
+			Name:     el.Tags["name"],
+			Kind:     placeKind(el.Tags),
+			Address:  placeAddress(el.Tags),
+			Hours:    el.Tags["opening_hours"],
+			Loc:      loc,
+			Distance: distance(center, loc),
+		})
+	}
+	slices.SortFunc(places, func(a, b place) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	return places, nil
+}
+
+// placeFilter returns the Overpass tag filter for a query: a known category,
+// or else a case-insensitive name match.
+func placeFilter(query string) string {
+	q := strings.ToLower(strings.Join(strings.Fields(query), " "))
+	plurals := []string{q, strings.TrimSuffix(q, "s"), strings.TrimSuffix(q, "es")}
+	if stem, ok := strings.CutSuffix(q, "ies"); ok {
+		plurals = append(plurals, stem+"y")
+	}
+	for _, candidate := range plurals {
+		if filter, ok := placeCategories[candidate]; ok {
+			return filter
+		}
+	}
+
+	// Keep only letters, digits, and spaces, so the name needs no escaping
+	// in the Overpass query or its regular expression
+	name := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '\'' {
+			return ' '
+		}
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r > 127 {
+			return r
+		}
+		return -1
+	}, q)
+	name = strings.Join(strings.Fields(name), ".")
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf(`["name"~"%s",i]`, name)
+}
+
+// placeKind describes an element by its main tag, e.g. "cafe".
+func placeKind(tags map[string]string) string {
+	for _, key := range []string{"amenity", "shop", "tourism", "leisure"} {
+		if v := tags[key]; v != "" {
+			return strings.ReplaceAll(v, "_", " ")
+		}
+	}
+	return ""
+}
+
+// placeAddress formats the street address from an element's addr:* tags.
+func placeAddress(tags map[string]string) string {
+	street := strings.TrimSpace(tags["addr:street"] + " " + tags["addr:housenumber"])
+	if city := tags["addr:city"]; city != "" && street != "" {
+		return street + ", " + city
+	}
+	return street
+}
+
+// distance returns the great-circle distance between two points in meters.
+func distance(a, b Location) float64 {
+	const earthRadius = 6371000
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func formatDistance(meters float64) string {
+	if meters < 1000 {
+		return fmt.Sprintf("%d m", int(math.Round(meters/10)*10))
+	}
+	return fmt.Sprintf("%.1f km", meters/1000)
+}
@@ -0,0 +1,202 @@
+package tools
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// weekdayNames are OpenStreetMap's day abbreviations, indexed like time.Weekday.
+var weekdayNames = []string{"Su", "Mo", "Tu", "We", "Th", "Fr", "Sa"}
+
+// hoursRule is one rule of an opening_hours value, such as "Mo-Fr 08:00-18:00".
+type hoursRule struct {
+	days   [7]bool
+	ranges [][2]int // Minutes since midnight; the end may pass midnight
+}
+
+// openAt reports whether a place with the given OpenStreetMap opening_hours
+// value is open at t. Only the common forms are understood: "24/7", weekday
+// ranges and lists, time ranges (including past midnight), and "off".
+// Public and school holiday rules are ignored. known is false for anything
+// else, such as months, week numbers, or sunrise.
+func openAt(hours string, t time.Time) (open, known bool) {
+	rules, ok := parseOpeningHours(hours)
+	if !ok {
+		return false, false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	yesterday := (today + 6) % 7
+
+	// Later rules replace earlier ones for the days they name
+	var todays, yesterdays *hoursRule
+	for i := range rules {
+		if rules[i].days[today] {
+			todays = &rules[i]
+		}
+		if rules[i].days[yesterday] {
+			yesterdays = &rules[i]
+		}
+	}
+
+	if todays != nil {
+		for _, r := range todays.ranges {
+			if r[0] <= minute && minute < min(r[1], 24*60) {
+				return true, true
+			}
+		}
+	}
+	if yesterdays != nil {
+		for _, r := range yesterdays.ranges {
+			if r[1] > 24*60 && minute < r[1]-24*60 {
+				return true, true
+			}
+		}
+	}
+	return false, true
+}
+
+// parseOpeningHours splits a value into rules. Rules are separated by ";",
+// or by "," when what follows starts a new day selector.
+func parseOpeningHours(hours string) ([]hoursRule, bool) {
+	hours = strings.TrimSpace(hours)
+	if hours == "" {
+		return nil, false
+	}
+
+	var texts []string
+	for _, part := range strings.Split(hours, ";") {
+		var current string
+		for _, piece := range strings.Split(part, ",") {
+			piece = strings.TrimSpace(piece)
+			// "Mo-Fr 08:00-12:00, Sa 09:00-12:00" is two rules, but
+			// "Mo,We 09:00-12:00" and "08:00-12:00,13:00-18:00" are one
+			if current != "" && startsWithDay(piece) && strings.ContainsAny(current, "0123456789") {
+				texts = append(texts, current)
+				current = piece
+			} else if current == "" {
+				current = piece
+			} else {
+				current += "," + piece
+			}
+		}
+		if current != "" {
+			texts = append(texts, current)
+		}
+	}
+
+	var rules []hoursRule
+	for _, text := range texts {
+		rule, ok := parseHoursRule(text)
+		if !ok {
+			return nil, false
+		}
+		rules = append(rules, rule)
+	}
+	return rules, len(rules) > 0
+}
+
+func parseHoursRule(text string) (hoursRule, bool) {
+	var rule hoursRule
+	if text == "24/7" {
+		rule.days = [7]bool{true, true, true, true, true, true, true}
+		rule.ranges = [][2]int{{0, 24 * 60}}
+		return rule, true
+	}
+
+	times := text
+	if startsWithDay(text) {
+		selector, rest, _ := strings.Cut(text, " ")
+		for _, item := range strings.Split(selector, ",") {
+			if item == "PH" || item == "SH" {
+				continue // Holidays aren't known, so their rules never apply
+			}
+			from, to, isRange := strings.Cut(item, "-")
+			first, ok1 := weekdayIndex(from)
+			last, ok2 := first, true
+			if isRange {
+				last, ok2 = weekdayIndex(to)
+			}
+			if !ok1 || !ok2 {
+				return rule, false
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				rule.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+		times = strings.TrimSpace(rest)
+	} else {
+		rule.days = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	switch times {
+	case "":
+		// A day selector alone means open all day
+		rule.ranges = [][2]int{{0, 24 * 60}}
+		return rule, true
+	case "off", "closed":
+		return rule, true
+	}
+
+	for _, span := range strings.Split(times, ",") {
+		span = strings.TrimSpace(span)
+		var start, end int
+		var ok bool
+		if from, open := strings.CutSuffix(span, "+"); open {
+			// "18:00+" is open until some time that isn't given
+			start, ok = parseClock(from)
+			end = 24 * 60
+		} else {
+			from, to, found := strings.Cut(span, "-")
+			if !found {
+				return rule, false
+			}
+			var ok2 bool
+			start, ok = parseClock(from)
+			end, ok2 = parseClock(to)
+			ok = ok && ok2
+			if end <= start {
+				end += 24 * 60 // Past midnight, e.g. 22:00-02:00
+			}
+		}
+		if !ok {
+			return rule, false
+		}
+		rule.ranges = append(rule.ranges, [2]int{start, end})
+	}
+	return rule, true
+}
+
+func startsWithDay(s string) bool {
+	prefix := s[:min(2, len(s))]
+	_, ok := weekdayIndex(prefix)
+	return ok || prefix == "PH" || prefix == "SH"
+}
+
+func weekdayIndex(name string) (int, bool) {
+	for i, day := range weekdayNames {
+		if day == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// parseClock parses "HH:MM" into minutes since midnight. Hours up to 48 are
+// allowed, since OpenStreetMap writes past-midnight closing as e.g. 26:00.
+func parseClock(s string) (int, bool) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, false
+	}
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hour < 0 || hour > 48 || minute < 0 || minute > 59 {
+		return 0, false
+	}
+	return hour*60 + minute, true
+}
@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// shell is the command interpreter the bash tool runs commands with.
+type shell struct {
+	Name string   // As told to the model, e.g. "bash" or "PowerShell"
+	Path string   // Resolved from PATH
+	Args []string // Before the command
+}
+
+// shellCandidates are tried in order: bash, or sh without it, on Unix;
+// PowerShell 7, Windows PowerShell, or cmd on Windows.
+var shellCandidates = map[bool][]shell{
+	false: {
+		{Name: "bash", Path: "bash", Args: []string{"-c"}},
+		{Name: "sh", Path: "sh", Args: []string{"-c"}},
+	},
+	true: {
+		{Name: "PowerShell", Path: "pwsh", Args: []string{"-NoLogo", "-NoProfile", "-NonInteractive", "-Command"}},
+		{Name: "PowerShell", Path: "powershell", Args: []string{"-NoLogo", "-NoProfile", "-NonInteractive", "-Command"}},
+		{Name: "cmd", Path: "cmd", Args: []string{"/C"}},
+	},
+}
+
+// hostShell is the first shell found on this host. Without any, it is the
+// first candidate unresolved, so running a command reports it's missing.
+var hostShell = sync.OnceValue(func() shell {
+	candidates := shellCandidates[runtime.GOOS == "windows"]
+	for _, sh := range candidates {
+		if path, err := exec.LookPath(sh.Path); err == nil {
+			sh.Path = path
+			return sh
+		}
+	}
+	return candidates[0]
+})
+
+// pythonCandidates are the names Python 3 goes by: python3 on Unix, and
+// python or the py launcher on Windows, where python3 is often a stub that
+// opens the Microsoft Store.
+var pythonCandidates = []string{"python3", "python", "py"}
+
+// hostPython is the Python 3 interpreter on PATH, or "python3" if none is
+// found, so running it reports it's missing.
+var hostPython = sync.OnceValue(func() string {
+	for _, name := range pythonCandidates {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		out, err := exec.Command(path, "--version").CombinedOutput()
+		if err == nil && strings.HasPrefix(strings.TrimSpace(string(out)), "Python 3") {
+			return path
+		}
+	}
+	return "python3"
+})
+
+// venvPython returns the interpreter inside a virtualenv, whose layout
+// differs on Windows.
+func venvPython(venv string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(venv, "Scripts", "python.exe")
+	}
+	return filepath.Join(venv, "bin", "python3")
+}
+
+// foldPaths is set where file names are usually case-insensitive, so the
+// workspace sandbox compares paths the way the filesystem does.
+var foldPaths = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
@@ -0,0 +1,508 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+)
+
+const (
+	pollStoreKey      = "polls"
+	pollLogPrefix     = "[poll]"
+	pollCheckInterval = time.Minute
+	maxPollOptions    = 10 // Telegram's limits
+	maxPollQuestion   = 300
+	maxPollOption     = 100
+	maxPollDuration   = 30 * 24 * time.Hour
+	pollKeep          = 30 * 24 * time.Hour // Closed polls are forgotten after this
+)
+
+// NewPoll is a native poll for the transport to post.
+type NewPoll struct {
+	Question  string
+	Options   []string
+	Multiple  bool // Voters may pick more than one option
+	Anonymous bool
+}
+
+// PollCounts are a poll's vote counts, one per option, as reported by the
+// transport.
+type PollCounts struct {
+	PollID string
+	Counts []int
+	Voters int
+	Closed bool
+}
+
+// pollRecord is a poll the bot posted.
+type pollRecord struct {
+	ID        int       `json:"id"`
+	ChatID    int64     `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	PollID    string    `json:"poll_id"` // The transport's ID, for vote updates
+	Question  string    `json:"question"`
+	Options   []string  `json:"options"`
+	Counts    []int     `json:"counts"`
+	Voters    int       `json:"voters"`
+	Requester string    `json:"requester,omitempty"`
+	Created   time.Time `json:"created"`
+	Closes    time.Time `json:"closes,omitempty"` // Zero until closed by hand
+	Closed    time.Time `json:"closed,omitempty"`
+}
+
+func (p *pollRecord) open() bool {
+	return p.Closed.IsZero()
+}
+
+type pollState struct {
+	NextID int          `json:"next_id"`
+	Polls  []pollRecord `json:"polls"`
+}
+
+// PollTool posts native polls in chats, keeps their vote counts, and
+// reports the results when they close, on schedule or when asked.
+type PollTool struct {
+	host  *Host // Set by Start; nil when background work is unavailable
+	mu    sync.Mutex
+	state pollState
+}
+
+// NewPollTool creates a poll tool.
+func NewPollTool() *PollTool {
+	return &PollTool{}
+}
+
+func (p *PollTool) Name() string {
+	return "poll"
+}
+
+func (p *PollTool) Description() string {
+	return `Native Telegram polls in this chat, e.g. "poll the group for a dinner time".
+
+operation=create with question and options posts a poll. close_in ("2h", "30m", "1d") or
+close_at (a time, e.g. "18:00" or "2026-03-02 09:00") closes it then and posts the results;
+without either it stays open until closed. multiple=true lets people pick several options,
+and anonymous=false shows who voted.
+operation=results shows the current counts (poll_id, default the latest poll in this chat).
+operation=close closes a poll now and posts the results; operation=list shows recent polls.`
+}
+
+func (p *PollTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"create", "results", "close", "list"},
+				"description": "What to do",
+			},
+			"question": map[string]any{
+				"type":        "string",
+				"description": "For create: the question, e.g. \"What time for dinner on Friday?\"",
+			},
+			"options": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "string"},
+				"description": "For create: 2 to 10 answers to choose from",
+			},
+			"close_in": map[string]any{
+				"type":        "string",
+				"description": "For create: how long until it closes, e.g. 2h, 30m, 1d",
+			},
+			"close_at": map[string]any{
+				"type":        "string",
+				"description": "For create: when it closes: " + directionsTimeFormats,
+			},
+			"multiple": map[string]any{
+				"type":        "boolean",
+				"description": "For create: allow picking more than one option",
+			},
+			"anonymous": map[string]any{
+				"type":        "boolean",
+				"description": "For create: hide who voted for what (default true)",
+			},
+			"poll_id": map[string]any{
+				"type":        "number",
+				"description": "For results and close: the poll number (default: the latest in this chat)",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (p *PollTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+// Start loads the polls and schedules closing them.
+func (p *PollTool) Start(host Host) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.host = &host
+	if _, err := host.Store.Get(pollStoreKey, &p.state); err != nil {
+		return fmt.Errorf("loading polls: %w", err)
+	}
+	host.Scheduler.Every("poll closing", pollCheckInterval, p.closeDue)
+
+	open := 0
+	for _, poll := range p.state.Polls {
+		if poll.open() {
+			open++
+		}
+	}
+	log.Printf("%s %d open polls", pollLogPrefix, open)
+	return nil
+}
+
+func (p *PollTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if p.host == nil || p.host.SendPoll == nil {
+		return "", fmt.Errorf("polls are not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("polls need a chat")
+	}
+
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "create":
+		return p.create(ctx, chatID, args)
+	case "list":
+		return p.list(chatID), nil
+	case "results", "close":
+		poll, err := p.find(chatID, args)
+		if err != nil {
+			return "", err
+		}
+		if operation == "close" {
+			if !poll.open() {
+				return fmt.Sprintf("Poll #%d is already closed.\n\n%s", poll.ID, formatPollResults(poll)), nil
+			}
+			// The results go to the chat as the reply
+			closed := p.close(poll.ID)
+			return formatPollResults(closed), nil
+		}
+		return formatPollResults(poll), nil
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+func (p *PollTool) create(ctx context.Context, chatID int64, args map[string]any) (string, error) {
+	question, _ := args["question"].(string)
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return "", fmt.Errorf("question is required for create")
+	}
+	if len(question) > maxPollQuestion {
+		return "", fmt.Errorf("the question is too long (at most %d characters)", maxPollQuestion)
+	}
+
+	var options []string
+	raw, _ := args["options"].([]any)
+	for _, o := range raw {
+		option := strings.TrimSpace(fmt.Sprint(o))
+		if option != "" && !slices.Contains(options, option) {
+			options = append(options, option)
+		}
+	}
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return "", fmt.Errorf("a poll needs 2 to %d different options", maxPollOptions)
+	}
+	for _, option := range options {
+		if len(option) > maxPollOption {
+			return "", fmt.Errorf("option %q is too long (at most %d characters)", truncateText(option, 30), maxPollOption)
+		}
+	}
+
+	now := time.Now()
+	closes, err := pollCloseTime(args, now)
+	if err != nil {
+		return "", err
+	}
+
+	multiple, _ := args["multiple"].(bool)
+	anonymous := true
+	if a, ok := args["anonymous"].(bool); ok {
+		anonymous = a
+	}
+	messageID, pollID, err := p.host.SendPoll(chatID, NewPoll{Question: question, Options: options, Multiple: multiple, Anonymous: anonymous})
+	if err != nil {
+		return "", fmt.Errorf("posting poll: %w", err)
+	}
+
+	var requester string
+	if user, ok := auth.UserFrom(ctx); ok {
+		requester = user.UserName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.NextID++
+	poll := pollRecord{
+		ID:        p.state.NextID,
+		ChatID:    chatID,
+		MessageID: messageID,
+		PollID:    pollID,
+		Question:  question,
+		Options:   options,
+		Counts:    make([]int, len(options)),
+		Requester: requester,
+		Created:   now.UTC(),
+		Closes:    closes,
+	}
+	p.state.Polls = append(p.state.Polls, poll)
+	p.prune(now)
+	if err := p.host.Store.Save(pollStoreKey, p.state); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s posted #%d in chat %d: %s", pollLogPrefix, poll.ID, chatID, question)
+	reply := fmt.Sprintf("📊 Posted poll #%d.", poll.ID)
+	if !closes.IsZero() {
+		reply += fmt.Sprintf(" It closes at %s, and I'll post the results then.", clock(closes, now))
+	} else {
+		reply += " Ask me to close it when everyone has voted."
+	}
+	return reply, nil
+}
+
+// pollCloseTime reads close_in or close_at; zero means no scheduled close.
+func pollCloseTime(args map[string]any, now time.Time) (time.Time, error) {
+	in, _ := args["close_in"].(string)
+	at, _ := args["close_at"].(string)
+	in, at = strings.TrimSpace(in), strings.TrimSpace(at)
+
+	var closes time.Time
+	switch {
+	case in != "":
+		d, err := parsePollDuration(in)
+		if err != nil {
+			return time.Time{}, err
+		}
+		closes = now.Add(d)
+	case at != "":
+		t, err := parseTripTime(at, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		closes = t
+	default:
+		return time.Time{}, nil
+	}
+	if !closes.After(now) {
+		return time.Time{}, fmt.Errorf("the closing time has already passed")
+	}
+	if closes.Sub(now) > maxPollDuration {
+		return time.Time{}, fmt.Errorf("polls can stay open for at most %d days", int(maxPollDuration.Hours()/24))
+	}
+	return closes.UTC(), nil
+}
+
+// parsePollDuration reads a Go duration, or a number of days such as "2d".
+func parsePollDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.ReplaceAll(s, " ", ""))
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err == nil && n > 0 {
+			return time.Duration(n * 24 * float64(time.Hour)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("unrecognized duration %q (use e.g. 30m, 2h, 1d)", s)
+	}
+	return d, nil
+}
+
+// find returns the poll named by poll_id, or the chat's latest.
+func (p *PollTool) find(chatID int64, args map[string]any) (pollRecord, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id, hasID := args["poll_id"].(float64)
+	for i := len(p.state.Polls) - 1; i >= 0; i-- {
+		poll := p.state.Polls[i]
+		if poll.ChatID == chatID && (!hasID || poll.ID == int(id)) {
+			return poll, nil
+		}
+	}
+	if hasID {
+		return pollRecord{}, fmt.Errorf("no poll #%d in this chat", int(id))
+	}
+	return pollRecord{}, fmt.Errorf("no polls in this chat yet")
+}
+
+func (p *PollTool) list(chatID int64) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	now := time.Now()
+	for i := len(p.state.Polls) - 1; i >= 0; i-- {
+		poll := p.state.Polls[i]
+		if poll.ChatID != chatID {
+			continue
+		}
+		status := "open"
+		switch {
+		case !poll.open():
+			status = "closed"
+		case !poll.Closes.IsZero():
+			status = "closes " + clock(poll.Closes, now)
+		}
+		fmt.Fprintf(&b, "#%d %s (%s, %d voted)\n", poll.ID, poll.Question, status, poll.Voters)
+	}
+	if b.Len() == 0 {
+		return "No polls in this chat yet."
+	}
+	return "📊 Polls:\n" + strings.TrimSpace(b.String())
+}
+
+// Update records new vote counts the transport reported for a poll.
+func (p *PollTool) Update(counts PollCounts) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := slices.IndexFunc(p.state.Polls, func(poll pollRecord) bool { return poll.PollID == counts.PollID })
+	if i < 0 || len(counts.Counts) != len(p.state.Polls[i].Options) {
+		return
+	}
+	p.state.Polls[i].Counts = counts.Counts
+	p.state.Polls[i].Voters = counts.Voters
+	if p.host != nil {
+		if err := p.host.Store.Save(pollStoreKey, p.state); err != nil {
+			log.Printf("%s saving counts: %v", pollLogPrefix, err)
+		}
+	}
+}
+
+// close stops a poll, taking the final counts from the transport when it
+// can, and returns it closed.
+func (p *PollTool) close(id int) pollRecord {
+	p.mu.Lock()
+	i := slices.IndexFunc(p.state.Polls, func(poll pollRecord) bool { return poll.ID == id })
+	poll := p.state.Polls[i]
+	p.mu.Unlock()
+
+	var final *PollCounts
+	if p.host.StopPoll != nil {
+		counts, err := p.host.StopPoll(poll.ChatID, poll.MessageID)
+		if err != nil {
+			// It may have been stopped already; keep the last counts
+			log.Printf("%s stopping #%d: %v", pollLogPrefix, id, err)
+		} else {
+			final = &counts
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i = slices.IndexFunc(p.state.Polls, func(poll pollRecord) bool { return poll.ID == id })
+	if final != nil && len(final.Counts) == len(p.state.Polls[i].Options) {
+		p.state.Polls[i].Counts = final.Counts
+		p.state.Polls[i].Voters = final.Voters
+	}
+	p.state.Polls[i].Closed = time.Now().UTC()
+	if err := p.host.Store.Save(pollStoreKey, p.state); err != nil {
+		log.Printf("%s saving #%d: %v", pollLogPrefix, id, err)
+	}
+	log.Printf("%s closed #%d with %d votes", pollLogPrefix, id, p.state.Polls[i].Voters)
+	return p.state.Polls[i]
+}
+
+// closeDue closes the polls whose time is up and posts their results in
+// the chat they were asked in.
+func (p *PollTool) closeDue(ctx context.Context) error {
+	now := time.Now()
+	p.mu.Lock()
+	var due []int
+	for _, poll := range p.state.Polls {
+		if poll.open() && !poll.Closes.IsZero() && !now.Before(poll.Closes) {
+			due = append(due, poll.ID)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, id := range due {
+		poll := p.close(id)
+		p.host.Send(poll.ChatID, formatPollResults(poll))
+	}
+	return nil
+}
+
+// prune forgets polls that closed long ago. Callers hold p.mu.
+func (p *PollTool) prune(now time.Time) {
+	p.state.Polls = slices.DeleteFunc(p.state.Polls, func(poll pollRecord) bool {
+		return !poll.open() && now.Sub(poll.Closed) > pollKeep
+	})
+}
+
+// formatPollResults lists a poll's options by votes, with the winner
+// marked once it's closed.
+func formatPollResults(poll pollRecord) string {
+	var b strings.Builder
+	if poll.open() {
+		fmt.Fprintf(&b, "📊 So far on \"%s\"", poll.Question)
+	} else {
+		fmt.Fprintf(&b, "📊 Results of \"%s\"", poll.Question)
+	}
+	if poll.Requester != "" {
+		fmt.Fprintf(&b, " (asked by @%s)", poll.Requester)
+	}
+	b.WriteString("\n")
+
+	order := make([]int, len(poll.Options))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, c int) int { return cmp.Compare(poll.Counts[c], poll.Counts[a]) })
+
+	total := 0
+	for _, n := range poll.Counts {
+		total += n
+	}
+	top := poll.Counts[order[0]]
+	var leaders []string
+	for _, i := range order {
+		n := poll.Counts[i]
+		line := fmt.Sprintf("\n• %s: %s", poll.Options[i], countNoun(n, "vote", "votes"))
+		if total > 0 {
+			line += fmt.Sprintf(" (%d%%)", n*100/total)
+		}
+		if n == top && n > 0 {
+			leaders = append(leaders, poll.Options[i])
+		}
+		b.WriteString(line)
+	}
+
+	fmt.Fprintf(&b, "\n\n%s voted.", countNoun(poll.Voters, "person", "people"))
+	if !poll.open() {
+		switch len(leaders) {
+		case 0:
+			b.WriteString(" Nobody voted.")
+		case 1:
+			fmt.Fprintf(&b, " 🏆 %s wins.", leaders[0])
+		default:
+			fmt.Fprintf(&b, " It's a tie between %s.", strings.Join(leaders, " and "))
+		}
+	}
+	return b.String()
+}
+
+// countNoun formats n with the singular or plural noun.
+func countNoun(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
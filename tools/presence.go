@@ -0,0 +1,432 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	presenceStoreKey         = "presence"
+	presenceLogPrefix        = "[presence]"
+	defaultPresenceInterval  = time.Minute
+	defaultPresenceAwayAfter = 10 * time.Minute
+	maxPresenceTriggers      = 50
+)
+
+// PresenceDevice is a device whose being on the network means its owner
+// is home, such as a phone.
+type PresenceDevice struct {
+	Person string
+	MAC    string
+}
+
+// ParsePresenceDevices reads devices such as "Sam=aa:bb:cc:dd:ee:ff,
+// Sam=11:22:33:44:55:66,Ava=...". A person with several devices is home
+// while any of them is.
+func ParsePresenceDevices(specs []string) ([]PresenceDevice, error) {
+	var devices []PresenceDevice
+	for _, spec := range specs {
+		person, mac, ok := strings.Cut(spec, "=")
+		person = strings.TrimSpace(person)
+		if !ok || person == "" {
+			return nil, fmt.Errorf("device %q should be name=MAC", spec)
+		}
+		mac, err := ParseMAC(mac)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, PresenceDevice{Person: person, MAC: mac})
+	}
+	return devices, nil
+}
+
+// PresenceConfig configures presence detection.
+type PresenceConfig struct {
+	Devices   []PresenceDevice
+	Subnets   []string      // IPv4 subnets to sweep; empty uses the host's private networks
+	Interval  time.Duration // How often the network is swept
+	AwayAfter time.Duration // How long devices must be gone before their person has left
+}
+
+// presencePerson is whether someone is home, as of the last sweep.
+type presencePerson struct {
+	Name     string    `json:"name"`
+	Home     bool      `json:"home"`
+	Since    time.Time `json:"since"` // When they arrived or left
+	LastSeen time.Time `json:"last_seen,omitempty"`
+}
+
+// presenceTrigger messages a chat when someone arrives or leaves, with a
+// reminder if it has one.
+type presenceTrigger struct {
+	ID      int       `json:"id"`
+	ChatID  int64     `json:"chat_id"`
+	Person  string    `json:"person"`
+	Event   string    `json:"event"` // arrive or leave
+	Message string    `json:"message,omitempty"`
+	Repeat  bool      `json:"repeat,omitempty"` // Fire every time rather than once
+	Created time.Time `json:"created"`
+}
+
+func (t *presenceTrigger) String() string {
+	s := "when " + t.Person + " gets home"
+	if t.Event == "leave" {
+		s = "when " + t.Person + " leaves"
+	}
+	if t.Repeat {
+		s = "every time" + strings.TrimPrefix(s, "when")
+	}
+	if t.Message != "" {
+		s += ": " + t.Message
+	}
+	return s
+}
+
+type presenceState struct {
+	People   []presencePerson  `json:"people"`
+	NextID   int               `json:"next_id"`
+	Triggers []presenceTrigger `json:"triggers"`
+}
+
+// PresenceTool tells who's home from which of their devices are on the
+// LAN, found by sweeping it for the devices' MAC addresses, and messages
+// chats when people arrive or leave.
+type PresenceTool struct {
+	cfg     PresenceConfig
+	subnets []netip.Prefix
+	people  []string // Configured, in order
+
+	host    *Host // Set by Start; nil when background work is unavailable
+	mu      sync.Mutex
+	state   presenceState
+	failing bool // The last sweep failed; only the first failure is reported
+}
+
+// NewPresenceTool creates a presence tool for the configured devices.
+func NewPresenceTool(cfg PresenceConfig) (*PresenceTool, error) {
+	if len(cfg.Devices) == 0 {
+		return nil, fmt.Errorf("no devices are configured")
+	}
+	subnets, err := presenceSubnets(cfg.Subnets)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultPresenceInterval
+	}
+	if cfg.AwayAfter <= 0 {
+		cfg.AwayAfter = defaultPresenceAwayAfter
+	}
+	p := &PresenceTool{cfg: cfg, subnets: subnets}
+	for _, d := range cfg.Devices {
+		if !slices.Contains(p.people, d.Person) {
+			p.people = append(p.people, d.Person)
+		}
+	}
+	return p, nil
+}
+
+func (p *PresenceTool) Name() string {
+	return "presence"
+}
+
+func (p *PresenceTool) Description() string {
+	return fmt.Sprintf(`Know who's home, from whether their phones are on the home network. People: %s.
+operation=status says who's home and since when. operation=notify with person and event (arrive or leave)
+messages this chat when it happens: with message it's a reminder ("remind me to call mum when I get home"),
+sent once unless repeat=true; without message it says they arrived or left, every time if repeat=true
+("tell me whenever the kids get home"). operation=list shows this chat's notifications; operation=cancel
+with trigger_id removes one.`, strings.Join(p.people, ", "))
+}
+
+func (p *PresenceTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"status", "notify", "list", "cancel"},
+				"description": "What to do",
+			},
+			"person": map[string]any{
+				"type":        "string",
+				"enum":        p.people,
+				"description": "For notify: whose arrival or departure",
+			},
+			"event": map[string]any{
+				"type":        "string",
+				"enum":        []string{"arrive", "leave"},
+				"description": "For notify: arriving home or leaving",
+			},
+			"message": map[string]any{
+				"type":        "string",
+				"description": "For notify: a reminder to send when it happens",
+			},
+			"repeat": map[string]any{
+				"type":        "boolean",
+				"description": "For notify: every time, not just the next time",
+			},
+			"trigger_id": map[string]any{
+				"type":        "number",
+				"description": "For cancel: the notification number",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (p *PresenceTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+func (p *PresenceTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if p.host == nil {
+		return "", fmt.Errorf("presence detection is not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("presence notifications need a chat")
+	}
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "status":
+		return p.status(), nil
+	case "notify":
+		person, _ := args["person"].(string)
+		event, _ := args["event"].(string)
+		message, _ := args["message"].(string)
+		repeat, _ := args["repeat"].(bool)
+		return p.notify(chatID, person, event, strings.TrimSpace(message), repeat)
+	case "list":
+		return p.list(chatID), nil
+	case "cancel":
+		id, ok := args["trigger_id"].(float64)
+		if !ok {
+			return "", fmt.Errorf("trigger_id is required for cancel (see operation=list)")
+		}
+		return p.cancel(chatID, int(id))
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// Start loads who was home and the triggers, and schedules the sweeps.
+func (p *PresenceTool) Start(host Host) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.host = &host
+	if _, err := host.Store.Get(presenceStoreKey, &p.state); err != nil {
+		return fmt.Errorf("loading presence: %w", err)
+	}
+	host.Scheduler.Every("presence sweep", p.cfg.Interval, p.sweep)
+	log.Printf("%s watching %d devices of %d people on %v every %v", presenceLogPrefix, len(p.cfg.Devices), len(p.people), p.subnets, p.cfg.Interval)
+	return nil
+}
+
+// person returns the configured person's name, matched case-insensitively.
+func (p *PresenceTool) person(name string) (string, error) {
+	for _, person := range p.people {
+		if strings.EqualFold(person, strings.TrimSpace(name)) {
+			return person, nil
+		}
+	}
+	return "", fmt.Errorf("unknown person %q (known: %s)", name, strings.Join(p.people, ", "))
+}
+
+func (p *PresenceTool) status() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var sb strings.Builder
+	for _, name := range p.people {
+		i := slices.IndexFunc(p.state.People, func(s presencePerson) bool { return s.Name == name })
+		switch {
+		case i < 0:
+			fmt.Fprintf(&sb, "❔ %s: not checked yet\n", name)
+		case p.state.People[i].Home:
+			fmt.Fprintf(&sb, "🏠 %s: home for %s\n", name, formatDowntime(now.Sub(p.state.People[i].Since)))
+		default:
+			s := p.state.People[i]
+			fmt.Fprintf(&sb, "🚶 %s: away for %s", name, formatDowntime(now.Sub(s.Since)))
+			if !s.LastSeen.IsZero() {
+				fmt.Fprintf(&sb, " (last seen %s)", s.LastSeen.Local().Format("Jan 2 15:04"))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func (p *PresenceTool) notify(chatID int64, name, event, message string, repeat bool) (string, error) {
+	person, err := p.person(name)
+	if err != nil {
+		return "", err
+	}
+	if event != "arrive" && event != "leave" {
+		return "", fmt.Errorf("event must be arrive or leave")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	count := 0
+	for _, t := range p.state.Triggers {
+		if t.ChatID == chatID {
+			count++
+		}
+	}
+	if count >= maxPresenceTriggers {
+		return "", fmt.Errorf("this chat already has %d presence notifications; cancel one first", count)
+	}
+	p.state.NextID++
+	t := presenceTrigger{ID: p.state.NextID, ChatID: chatID, Person: person, Event: event, Message: message, Repeat: repeat, Created: time.Now().UTC()}
+	p.state.Triggers = append(p.state.Triggers, t)
+	if err := p.host.Store.Save(presenceStoreKey, p.state); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s trigger #%d for chat %d: %s", presenceLogPrefix, t.ID, chatID, t.String())
+	reply := fmt.Sprintf("👀 Notification #%d: I'll message this chat %s.", t.ID, t.String())
+	if i := slices.IndexFunc(p.state.People, func(s presencePerson) bool { return s.Name == person }); i >= 0 {
+		if home := p.state.People[i].Home; home == (event == "arrive") {
+			state := "away"
+			if home {
+				state = "home"
+			}
+			reply += fmt.Sprintf(" (%s is already %s.)", person, state)
+		}
+	}
+	return reply, nil
+}
+
+func (p *PresenceTool) list(chatID int64) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sb strings.Builder
+	for _, t := range p.state.Triggers {
+		if t.ChatID == chatID {
+			fmt.Fprintf(&sb, "\n#%d %s", t.ID, t.String())
+		}
+	}
+	if sb.Len() == 0 {
+		return "No presence notifications in this chat."
+	}
+	return "👀 Presence notifications:" + sb.String()
+}
+
+func (p *PresenceTool) cancel(chatID int64, id int) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := slices.IndexFunc(p.state.Triggers, func(t presenceTrigger) bool { return t.ID == id && t.ChatID == chatID })
+	if i < 0 {
+		return "", fmt.Errorf("no presence notification #%d in this chat", id)
+	}
+	t := p.state.Triggers[i]
+	p.state.Triggers = slices.Delete(p.state.Triggers, i, i+1)
+	if err := p.host.Store.Save(presenceStoreKey, p.state); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Cancelled #%d, %s", t.ID, t.String()), nil
+}
+
+// sweep looks for the devices on the network. Only the first of a run of
+// failures is returned, so a broken sweep doesn't message the owners
+// every minute.
+func (p *PresenceTool) sweep(ctx context.Context) error {
+	seen, err := sweep(ctx, p.subnets)
+	if err != nil {
+		if p.failing {
+			return nil
+		}
+		p.failing = true
+		return err
+	}
+	p.failing = false
+	return p.observe(seen, time.Now().UTC())
+}
+
+// observe updates who's home from the MAC addresses on the network, and
+// fires the triggers of people who arrived or left. People arrive as soon
+// as a device shows up, and leave once all theirs have been gone for
+// AwayAfter, since phones drop off the network now and then while asleep.
+func (p *PresenceTool) observe(seen map[string]bool, now time.Time) error {
+	here := make(map[string]bool)
+	for _, d := range p.cfg.Devices {
+		if seen[d.MAC] {
+			here[d.Person] = true
+		}
+	}
+
+	type notice struct {
+		chatID int64
+		text   string
+	}
+	var notices []notice
+
+	p.mu.Lock()
+	for _, name := range p.people {
+		i := slices.IndexFunc(p.state.People, func(s presencePerson) bool { return s.Name == name })
+		if i < 0 {
+			// First sweep: a baseline, not news
+			p.state.People = append(p.state.People, presencePerson{Name: name, Home: here[name], Since: now})
+			if here[name] {
+				p.state.People[len(p.state.People)-1].LastSeen = now
+			}
+			continue
+		}
+		s := &p.state.People[i]
+		if here[name] {
+			s.LastSeen = now
+		}
+		var event string
+		switch {
+		case here[name] && !s.Home:
+			s.Home, s.Since, event = true, now, "arrive"
+			log.Printf("%s %s arrived", presenceLogPrefix, name)
+		case !here[name] && s.Home && now.Sub(s.LastSeen) >= p.cfg.AwayAfter:
+			s.Home, s.Since, event = false, s.LastSeen, "leave"
+			log.Printf("%s %s left", presenceLogPrefix, name)
+		default:
+			continue
+		}
+
+		kept := p.state.Triggers[:0]
+		for _, t := range p.state.Triggers {
+			if t.Person == name && t.Event == event {
+				notices = append(notices, notice{t.ChatID, presenceMessage(t)})
+				if !t.Repeat {
+					continue
+				}
+			}
+			kept = append(kept, t)
+		}
+		p.state.Triggers = kept
+	}
+	err := p.host.Store.Save(presenceStoreKey, p.state)
+	p.mu.Unlock()
+
+	for _, n := range notices {
+		p.host.Send(n.chatID, n.text)
+	}
+	return err
+}
+
+func presenceMessage(t presenceTrigger) string {
+	switch {
+	case t.Message != "" && t.Event == "arrive":
+		return fmt.Sprintf("⏰ %s is home: %s", t.Person, t.Message)
+	case t.Message != "":
+		return fmt.Sprintf("⏰ %s left: %s", t.Person, t.Message)
+	case t.Event == "arrive":
+		return fmt.Sprintf("🏠 %s is home", t.Person)
+	default:
+		return fmt.Sprintf("👋 %s left", t.Person)
+	}
+}
@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	presenceProbeWorkers = 64
+	presenceARPWait      = 2 * time.Second // For replies to the probes to fill the neighbor table
+	presenceMinPrefix    = 22              // Larger subnets take too long to sweep
+	presenceProbePort    = 9               // discard; nothing needs to answer, only ARP
+)
+
+// arpLine matches an entry of `arp -an`, as printed on macOS and the BSDs:
+// "? (192.168.1.10) at aa:bb:cc:dd:ee:ff on en0 ...".
+var arpLine = regexp.MustCompile(`\(([0-9.]+)\) at ([0-9a-fA-F:]+) `)
+
+// ParseMAC normalizes a MAC address to lowercase with colons.
+func ParseMAC(s string) (string, error) {
+	hw, err := net.ParseMAC(strings.TrimSpace(s))
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("invalid MAC address %q", s)
+	}
+	return hw.String(), nil
+}
+
+// presenceSubnets returns the subnets to sweep: the configured ones, or the
+// private IPv4 networks of the host's interfaces.
+func presenceSubnets(configured []string) ([]netip.Prefix, error) {
+	var subnets []netip.Prefix
+	for _, s := range configured {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil || !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("invalid IPv4 subnet %q", s)
+		}
+		if prefix.Bits() < presenceMinPrefix {
+			return nil, fmt.Errorf("subnet %s is too large to sweep; use /%d or smaller", s, presenceMinPrefix)
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+	if len(subnets) > 0 {
+		return subnets, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("listing network interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || !ipnet.IP.IsPrivate() {
+				continue
+			}
+			ones, _ := ipnet.Mask.Size()
+			addr, _ := netip.AddrFromSlice(ipnet.IP.To4())
+			prefix := netip.PrefixFrom(addr, max(ones, presenceMinPrefix)).Masked()
+			if ones > 0 && !containsPrefix(subnets, prefix) {
+				subnets = append(subnets, prefix)
+			}
+		}
+	}
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("no private IPv4 network found to sweep; set PRESENCE_SUBNETS")
+	}
+	return subnets, nil
+}
+
+func containsPrefix(prefixes []netip.Prefix, p netip.Prefix) bool {
+	for _, q := range prefixes {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep sends a UDP datagram to every address of the subnets, which makes
+// the kernel ARP for each, then returns the MAC addresses in the neighbor
+// table. Phones that ignore pings while asleep still answer ARP.
+func sweep(ctx context.Context, subnets []netip.Prefix) (map[string]bool, error) {
+	addrs := make(chan netip.Addr)
+	var wg sync.WaitGroup
+	for range presenceProbeWorkers {
+		wg.Go(func() {
+			for addr := range addrs {
+				probe(addr)
+			}
+		})
+	}
+	for _, subnet := range subnets {
+		for addr := subnet.Addr().Next(); subnet.Contains(addr); addr = addr.Next() {
+			select {
+			case addrs <- addr:
+			case <-ctx.Done():
+				close(addrs)
+				wg.Wait()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	close(addrs)
+	wg.Wait()
+
+	select {
+	case <-time.After(presenceARPWait):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return neighbors(ctx)
+}
+
+func probe(addr netip.Addr) {
+	conn, err := net.DialUDP("udp4", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, presenceProbePort)))
+	if err != nil {
+		return
+	}
+	conn.Write([]byte{0})
+	conn.Close()
+}
+
+// neighbors returns the MAC addresses the host has resolved recently, from
+// /proc/net/arp on Linux or `arp -an` elsewhere.
+func neighbors(ctx context.Context) (map[string]bool, error) {
+	macs := make(map[string]bool)
+	if data, err := os.ReadFile("/proc/net/arp"); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Scan() // Header
+		for scanner.Scan() {
+			// IP address, HW type, Flags, HW address, Mask, Device
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || fields[2] == "0x0" {
+				continue // Incomplete: nothing answered
+			}
+			if mac, err := ParseMAC(fields[3]); err == nil {
+				macs[mac] = true
+			}
+		}
+		return macs, nil
+	}
+
+	out, err := exec.CommandContext(ctx, "arp", "-an").Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading the ARP table: %w", err)
+	}
+	for _, m := range arpLine.FindAllStringSubmatch(string(out), -1) {
+		// macOS drops leading zeros, e.g. a:b:c:d:e:f
+		parts := strings.Split(m[2], ":")
+		for i, p := range parts {
+			if len(p) == 1 {
+				parts[i] = "0" + p
+			}
+		}
+		if mac, err := ParseMAC(strings.Join(parts, ":")); err == nil {
+			macs[mac] = true
+		}
+	}
+	return macs, nil
+}
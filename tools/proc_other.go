@@ -0,0 +1,26 @@
+//go:build !unix
+
+package tools
+
+import (
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// killTreeOnCancel makes cancelling cmd's context kill it and every process
+// it started on Windows, with taskkill /T. Elsewhere only cmd itself is
+// killed, and processes it started may outlive it.
+func killTreeOnCancel(cmd *exec.Cmd) {
+	if runtime.GOOS == "windows" {
+		cmd.Cancel = func() error {
+			err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+			if err != nil {
+				return cmd.Process.Kill()
+			}
+			return nil
+		}
+	}
+	cmd.WaitDelay = 5 * time.Second // For output still held open after the kill
+}
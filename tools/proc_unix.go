@@ -0,0 +1,20 @@
+//go:build unix
+
+package tools
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killTreeOnCancel runs cmd in its own process group and makes cancelling
+// its context kill the whole group, so processes it started in the
+// background can't outlive a stopped run or hold its output open.
+func killTreeOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second // For output still held open after the kill
+}
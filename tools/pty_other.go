@@ -0,0 +1,20 @@
+//go:build !linux
+
+package tools
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// startPTY is only implemented on Linux; persistent bash sessions are unavailable elsewhere.
+func startPTY(cmd *exec.Cmd) (*os.File, error) {
+	return nil, errors.New("persistent bash sessions are only supported on Linux")
+}
+
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
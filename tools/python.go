@@ -8,12 +8,18 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"telegram-bot/tenant"
 )
 
 const (
 	pythonTimeout    = 60 * time.Second
+	defaultCoverage  = 80    // Minimum coverage percent when develop measures coverage
 	maxOutputBytes   = 50000 // Limit output to prevent huge responses
 	defaultWorkspace = "workspace"
 	logPrefix        = "[python]"
@@ -22,14 +28,37 @@ const (
 // PythonTool provides a workspace for writing and executing Python code.
 type PythonTool struct {
 	workspaceDir string
+	packages     []string // Allowlist for on-demand installs
+
+	mu                    sync.Mutex // Serializes pip installs
+	requirementsInstalled time.Time  // Mod time of the last installed requirements.txt
+
+	tenants *tenantCopies[*PythonTool] // Nil on the copies themselves
 }
 
-// NewPythonTool creates a new Python workspace tool.
-func NewPythonTool(workspaceDir string) *PythonTool {
+// NewPythonTool creates a new Python workspace tool. Missing modules are
+// installed on demand if their package is in the allowlist, which defaults
+// to DefaultPythonPackages.
+func NewPythonTool(workspaceDir string, allowedPackages ...string) *PythonTool {
 	if workspaceDir == "" {
 		workspaceDir = defaultWorkspace
 	}
-	return &PythonTool{workspaceDir: workspaceDir}
+	if len(allowedPackages) == 0 {
+		allowedPackages = DefaultPythonPackages
+	}
+	return &PythonTool{workspaceDir: workspaceDir, packages: allowedPackages, tenants: newTenantCopies[*PythonTool]()}
+}
+
+// forTenant returns the python tool for the request's tenant, with its own
+// workspace and virtualenv.
+func (p *PythonTool) forTenant(ctx context.Context) *PythonTool {
+	return p.tenants.get(ctx, p, func(t *tenant.Tenant) *PythonTool {
+		scoped := &PythonTool{workspaceDir: tenant.Dir(p.workspaceDir, t.ID), packages: p.packages}
+		if err := scoped.Init(); err != nil {
+			log.Printf("%s creating workspace for tenant %s: %v", logPrefix, t.ID, err)
+		}
+		return scoped
+	})
 }
 
 // Init ensures the workspace directory exists.
@@ -63,7 +92,26 @@ DEVELOP PARAMS:
 - name: base filename (creates name.py and test_name.py)  
 - implementation: your Python code
 - tests: pytest test code
-- fix_implementation: fixed code when retrying after test failure`
+- fix_implementation: fixed code when retrying after test failure
+- files: instead of implementation/tests, a map of path -> content for a
+  multi-module project in the directory 'name' (e.g. {"pkg/__init__.py": "",
+  "pkg/utils.py": "...", "tests/test_utils.py": "..."}). The whole test suite
+  runs from the project directory. When fixing, send only the changed files.
+- coverage: true to measure test coverage (pytest --cov)
+- min_coverage: required coverage percent when coverage is on (default 80)
+- lint: true to check the code with ruff (or flake8)
+With coverage or lint on, develop is only done when tests pass AND the
+quality checks pass; otherwise fix the findings and call develop again.
+
+PACKAGES:
+Common packages (numpy, pandas, matplotlib, requests, ...) are installed
+automatically when an import fails, and requirements.txt in the workspace is
+installed when it changes. Other packages are not available.
+
+OUTPUT FILES:
+Images (.png, .jpg), PDFs, CSVs and similar files saved to the workspace during
+run/develop/test are sent to the user automatically. For charts, use
+plt.savefig("chart.png") instead of plt.show().`
 }
 
 func (p *PythonTool) Parameters() map[string]any {
@@ -99,12 +147,49 @@ func (p *PythonTool) Parameters() map[string]any {
 				"type":        "string",
 				"description": "Fixed implementation code when retrying after test failure",
 			},
+			"files": map[string]any{
+				"type":                 "object",
+				"description":          "For multi-module develop: map of path (relative to the project directory 'name') to file content",
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+			"coverage": map[string]any{
+				"type":        "boolean",
+				"description": "For develop: measure test coverage of the implementation",
+			},
+			"min_coverage": map[string]any{
+				"type":        "number",
+				"description": "For develop with coverage: minimum coverage percent (default 80)",
+			},
+			"lint": map[string]any{
+				"type":        "boolean",
+				"description": "For develop: lint implementation and tests with ruff or flake8",
+			},
 		},
 		"required": []string{"operation"},
 	}
 }
 
+func (p *PythonTool) Metadata() Metadata {
+	return Metadata{Cost: CostHigh}
+}
+
+func (p *PythonTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{
+		"operation": "run",
+		"code":      "import pytest\nprint('bench-ok')",
+	}, "bench-ok"
+}
+
+// Requirements turns off develop and test when pytest isn't installed.
+func (p *PythonTool) Requirements() []Requirement {
+	return []Requirement{
+		{Name: "python3", Command: hostPython()},
+		{Name: "pytest", Check: pythonModule(p.interpreter, "pytest"), Operations: []string{"develop", "test"}},
+	}
+}
+
 func (p *PythonTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	p = p.forTenant(ctx)
 	operation, ok := args["operation"].(string)
 	if !ok || operation == "" {
 		return "", fmt.Errorf("operation is required")
@@ -130,6 +215,27 @@ func (p *PythonTool) Execute(ctx context.Context, args map[string]any) (string,
 	}
 }
 
+// ExecuteRich runs the operation and attaches any plots, PDFs, or other
+// output files the code created in the workspace.
+func (p *PythonTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	p = p.forTenant(ctx)
+	operation, _ := args["operation"].(string)
+	if operation != "run" && operation != "develop" && operation != "test" {
+		text, err := p.Execute(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Text: text}, nil
+	}
+
+	before := snapshotWorkspace(p.workspaceDir)
+	text, err := p.Execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Text: text, Attachments: changedAttachments(p.workspaceDir, before)}, nil
+}
+
 func (p *PythonTool) runCode(ctx context.Context, args map[string]any) (string, error) {
 	code, _ := args["code"].(string)
 	filename, _ := args["filename"].(string)
@@ -138,7 +244,10 @@ func (p *PythonTool) runCode(ctx context.Context, args map[string]any) (string,
 
 	if filename != "" {
 		// Run an existing file - check it exists, but use relative path for execution
-		fullPath := p.safePath(filename)
+		fullPath, err := p.safePath(filename)
+		if err != nil {
+			return "", err
+		}
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
 			return "", fmt.Errorf("file not found: %s", filename)
 		}
@@ -166,7 +275,13 @@ func (p *PythonTool) runCode(ctx context.Context, args map[string]any) (string,
 		return "", fmt.Errorf("either 'code' or 'filename' is required for run")
 	}
 
-	return p.executeCommand(ctx, "python3", scriptPath)
+	if e := currentExecutor(); !e.OnHost() {
+		// The container has its image's Python and packages, not the venv's
+		return p.executeCommand(ctx, e, containerPython, scriptPath)
+	}
+	return p.withInstall(ctx, func() (string, error) {
+		return p.executeCommand(ctx, HostExecutor{}, p.interpreter(), scriptPath)
+	})
 }
 
 func (p *PythonTool) runTests(ctx context.Context, args map[string]any) (string, error) {
@@ -181,7 +296,10 @@ func (p *PythonTool) runTests(ctx context.Context, args map[string]any) (string,
 
 	if filename != "" {
 		// Test specific file - check it exists, but use relative path for execution
-		fullPath := p.safePath(filename)
+		fullPath, err := p.safePath(filename)
+		if err != nil {
+			return "", err
+		}
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
 			return "", fmt.Errorf("test file not found: %s", filename)
 		}
@@ -192,7 +310,9 @@ func (p *PythonTool) runTests(ctx context.Context, args map[string]any) (string,
 		log.Printf("%s test all (discovering test_*.py)", logPrefix)
 	}
 
-	return p.executeCommand(ctx, "pytest", pytestArgs...)
+	return p.withInstall(ctx, func() (string, error) {
+		return p.executeCommand(ctx, HostExecutor{}, p.interpreter(), append([]string{"-m", "pytest"}, pytestArgs...)...)
+	})
 }
 
 func (p *PythonTool) develop(ctx context.Context, args map[string]any) (string, error) {
@@ -201,12 +321,19 @@ func (p *PythonTool) develop(ctx context.Context, args map[string]any) (string,
 		return "", fmt.Errorf("name is required for develop operation")
 	}
 
+	if files, ok := args["files"].(map[string]any); ok && len(files) > 0 {
+		return p.developProject(ctx, name, files, args)
+	}
+
 	implementation, _ := args["implementation"].(string)
 	tests, _ := args["tests"].(string)
 	fixImplementation, _ := args["fix_implementation"].(string)
 
 	implFile := name + ".py"
 	testFile := "test_" + name + ".py"
+	if strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("name must be a plain module name; use 'files' for projects with directories")
+	}
 
 	// If fixing, use the fix_implementation
 	if fixImplementation != "" {
@@ -244,39 +371,214 @@ func (p *PythonTool) develop(ctx context.Context, args map[string]any) (string,
 		return "", fmt.Errorf("test file %s not found - provide 'tests' parameter", testFile)
 	}
 
+	return p.verify(ctx, developSpec{
+		name:      name,
+		dir:       ".",
+		tests:     []string{testFile},
+		cover:     []string{name},
+		coverFile: implFile,
+		lint:      []string{implFile, testFile},
+		files:     []string{implFile, testFile},
+		fixHint: fmt.Sprintf(`Fix the implementation and call python again with:
+- operation: "develop"
+- name: "%s"
+- fix_implementation: <your fixed code>`, name),
+		fixNote: `IMPORTANT: Only fix the implementation code. Keep the same tests.
+Make minimal changes to fix the specific errors shown above.`,
+	}, args)
+}
+
+// developSpec describes what develop tests and checks once its files are written.
+type developSpec struct {
+	name      string   // Module or project name
+	dir       string   // Directory pytest runs in, relative to the workspace
+	tests     []string // Test files or directories, relative to dir
+	cover     []string // Coverage targets, relative to dir
+	coverFile string   // Report row to use when there is no TOTAL
+	lint      []string // Paths to lint, relative to the workspace
+	files     []string // Files to list on success
+	fixHint   string   // How to resubmit after a failure
+	fixNote   string   // Extra guidance after a test failure
+}
+
+// verify runs the tests and, once they pass, any requested coverage and lint checks.
+func (p *PythonTool) verify(ctx context.Context, spec developSpec, args map[string]any) (string, error) {
+	coverage, _ := args["coverage"].(bool)
+	lint, _ := args["lint"].(bool)
+	minCoverage := defaultCoverage
+	if v, ok := args["min_coverage"].(float64); ok && v > 0 {
+		minCoverage = int(v)
+	}
+
 	// Run tests
-	log.Printf("%s develop: running tests %s", logPrefix, testFile)
-	output, err := p.runTestsInternal(ctx, testFile)
+	log.Printf("%s develop: running tests %s", logPrefix, strings.Join(spec.tests, " "))
+	var pytestArgs []string
+	if coverage {
+		for _, target := range spec.cover {
+			pytestArgs = append(pytestArgs, "--cov="+target)
+		}
+		pytestArgs = append(pytestArgs, "--cov-report=term-missing")
+	}
+	output, err := p.withInstall(ctx, func() (string, error) {
+		return p.runTestsInternal(ctx, spec.dir, spec.tests, pytestArgs...)
+	})
+	if coverage && strings.Contains(output, "unrecognized arguments: --cov") {
+		log.Printf("%s develop: pytest-cov not installed, running without coverage", logPrefix)
+		coverage = false
+		output, err = p.runTestsInternal(ctx, spec.dir, spec.tests)
+		output = "(coverage unavailable: pytest-cov is not installed)\n\n" + output
+	}
 	passed := err == nil && !strings.Contains(output, "FAILED")
 
-	if passed && strings.Contains(output, "passed") {
-		log.Printf("%s develop: TESTS PASSED", logPrefix)
-		return fmt.Sprintf("✅ ALL TESTS PASSED\n\nFiles created:\n- %s\n- %s\n\nTest output:\n%s", implFile, testFile, output), nil
+	if !passed || !strings.Contains(output, "passed") {
+		// Tests failed - return errors for model to fix
+		log.Printf("%s develop: TESTS FAILED", logPrefix)
+
+		return fmt.Sprintf(`❌ TESTS FAILED
+
+%s
+
+Errors:
+%s
+
+%s`, spec.fixHint, output, spec.fixNote), nil
 	}
 
-	// Tests failed - return errors for model to fix
-	log.Printf("%s develop: TESTS FAILED", logPrefix)
+	log.Printf("%s develop: TESTS PASSED", logPrefix)
 
-	return fmt.Sprintf(`❌ TESTS FAILED
+	// Quality checks only run once the tests pass
+	var quality []string
+	var problems []string
 
-Fix the implementation and call python again with:
-- operation: "develop"
-- name: "%s"
-- fix_implementation: <your fixed code>
+	if coverage {
+		percent, ok := parseCoverage(output, spec.coverFile)
+		switch {
+		case !ok:
+			quality = append(quality, "Coverage: could not be determined")
+		case percent < minCoverage:
+			quality = append(quality, fmt.Sprintf("Coverage: %d%% (minimum %d%%)", percent, minCoverage))
+			problems = append(problems, fmt.Sprintf("coverage %d%% is below %d%% - add tests for the missing lines listed above", percent, minCoverage))
+		default:
+			quality = append(quality, fmt.Sprintf("Coverage: %d%% (minimum %d%%)", percent, minCoverage))
+		}
+	}
+
+	if lint {
+		linter, findings, count := p.lint(ctx, spec.lint...)
+		switch {
+		case linter == "":
+			quality = append(quality, "Lint: skipped (neither ruff nor flake8 is installed)")
+		case count == 0:
+			quality = append(quality, fmt.Sprintf("Lint (%s): clean", linter))
+		default:
+			quality = append(quality, fmt.Sprintf("Lint (%s): %d findings\n%s", linter, count, findings))
+			problems = append(problems, fmt.Sprintf("%d lint findings - fix them", count))
+		}
+	}
+
+	summary := ""
+	if len(quality) > 0 {
+		summary = "\n\n" + strings.Join(quality, "\n")
+	}
+
+	if len(problems) > 0 {
+		log.Printf("%s develop: QUALITY CHECKS FAILED", logPrefix)
+		return fmt.Sprintf(`⚠️ TESTS PASSED, QUALITY CHECKS FAILED%s
+
+Problems:
+- %s
 
-Errors:
 %s
+Tests may be changed too, to raise coverage. Keep the same coverage/lint settings.
+
+Test output:
+%s`, summary, strings.Join(problems, "\n- "), spec.fixHint, output), nil
+	}
 
-IMPORTANT: Only fix the implementation code. Keep the same tests.
-Make minimal changes to fix the specific errors shown above.`, name, output), nil
+	return fmt.Sprintf("✅ ALL TESTS PASSED\n\nFiles created:\n- %s%s\n\nTest output:\n%s",
+		strings.Join(spec.files, "\n- "), summary, output), nil
 }
 
-func (p *PythonTool) runTestsInternal(ctx context.Context, testFile string) (string, error) {
+// coverageLine matches a row of pytest-cov's term report and captures the file and percent.
+var coverageLine = regexp.MustCompile(`(?m)^(\S+)\s+\d+\s+\d+\s+(?:\d+\s+\d+\s+)?(\d+)%`)
+
+// parseCoverage finds the TOTAL (or, for a single file, the implementation's)
+// coverage percentage in pytest-cov output.
+func parseCoverage(output, implFile string) (int, bool) {
+	percent, found := 0, false
+	for _, m := range coverageLine.FindAllStringSubmatch(output, -1) {
+		if m[1] != "TOTAL" && m[1] != implFile {
+			continue
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		percent, found = n, true
+		if m[1] == "TOTAL" {
+			break
+		}
+	}
+	return percent, found
+}
+
+// lint runs ruff, falling back to flake8, on the given workspace files.
+// It returns the linter used (empty if none is installed), its findings, and their count.
+func (p *PythonTool) lint(ctx context.Context, files ...string) (string, string, int) {
+	linter, args := pythonLinter()
+	if linter == "" {
+		return "", "", 0
+	}
+	args = append(args, files...)
+
 	ctx, cancel := context.WithTimeout(ctx, pythonTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "pytest", "-v", "--tb=short", testFile)
+	cmd := exec.CommandContext(ctx, linter, args...)
 	cmd.Dir = p.workspaceDir
+	isolate(ctx, cmd, "python")
+	out, _ := cmd.CombinedOutput() // Linters exit non-zero when they find problems
+
+	var findings []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if lintFinding.MatchString(line) {
+			findings = append(findings, line)
+		}
+	}
+	log.Printf("%s lint: %s found %d issues", logPrefix, linter, len(findings))
+
+	output := strings.Join(findings, "\n")
+	if len(output) > 3000 {
+		output = output[:3000] + "\n... (truncated)"
+	}
+	return linter, output, len(findings)
+}
+
+// pythonLinter returns the installed Python linter, preferring ruff to
+// flake8, and its arguments before the files to check.
+func pythonLinter() (string, []string) {
+	if _, err := exec.LookPath("ruff"); err == nil {
+		return "ruff", []string{"check", "--output-format=concise", "--no-cache"}
+	}
+	if _, err := exec.LookPath("flake8"); err == nil {
+		return "flake8", nil
+	}
+	return "", nil
+}
+
+// lintFinding matches ruff's concise and flake8's default "file:line:col: message" format.
+var lintFinding = regexp.MustCompile(`^\S+\.py:\d+:\d+: `)
+
+func (p *PythonTool) runTestsInternal(ctx context.Context, dir string, tests []string, extraArgs ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pythonTimeout)
+	defer cancel()
+
+	// python -m pytest puts dir on sys.path so tests can import project packages
+	args := append([]string{"-m", "pytest", "-v", "--tb=short"}, extraArgs...)
+	cmd := exec.CommandContext(ctx, p.interpreter(), append(args, tests...)...)
+	cmd.Dir = filepath.Join(p.workspaceDir, dir)
+	killTreeOnCancel(cmd)
+	isolate(ctx, cmd, "python")
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -299,12 +601,17 @@ func (p *PythonTool) runTestsInternal(ctx context.Context, testFile string) (str
 	return output, err
 }
 
-func (p *PythonTool) executeCommand(ctx context.Context, command string, args ...string) (string, error) {
+func (p *PythonTool) executeCommand(ctx context.Context, e Executor, command string, args ...string) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, pythonTimeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, command, args...)
-	cmd.Dir = p.workspaceDir
+	cmd := e.Command(ctx, ExecRequest{
+		Profile:   "python",
+		Workspace: p.workspaceDir,
+		Dir:       p.workspaceDir,
+		Env:       []string{"MPLBACKEND=Agg"}, // Render matplotlib figures to files; there is no display
+		Args:      append([]string{command}, args...),
+	})
 
 	log.Printf("%s exec: %s %s", logPrefix, command, strings.Join(args, " "))
 
@@ -378,13 +685,14 @@ func (p *PythonTool) writeFile(args map[string]any) (string, error) {
 	p.logCodePreview(code)
 
 	// Ensure we stay in workspace
-	filePath := p.safePath(filename)
+	filePath, err := p.safePath(filename)
+	if err != nil {
+		return "", err
+	}
 
 	// Create subdirectories if needed
-	if dir := filepath.Dir(filePath); dir != p.workspaceDir {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return "", fmt.Errorf("creating directory: %w", err)
-		}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", fmt.Errorf("creating directory: %w", err)
 	}
 
 	if err := os.WriteFile(filePath, []byte(code), 0644); err != nil {
@@ -402,7 +710,10 @@ func (p *PythonTool) readFile(args map[string]any) (string, error) {
 
 	log.Printf("%s read file=%s", logPrefix, filename)
 
-	filePath := p.safePath(filename)
+	filePath, err := p.safePath(filename)
+	if err != nil {
+		return "", err
+	}
 
 	content, err := os.ReadFile(filePath)
 	if err != nil {
@@ -430,6 +741,10 @@ func (p *PythonTool) listFiles() (string, error) {
 		if err != nil {
 			return err
 		}
+		// Skip the package venv and other hidden directories
+		if info.IsDir() && path != p.workspaceDir && (strings.HasPrefix(info.Name(), ".") || info.Name() == "__pycache__") {
+			return filepath.SkipDir
+		}
 		if !info.IsDir() {
 			relPath, _ := filepath.Rel(p.workspaceDir, path)
 			files = append(files, fmt.Sprintf("  %s (%d bytes)", relPath, info.Size()))
@@ -473,7 +788,7 @@ func (p *PythonTool) logCodePreview(code string) {
 func (p *PythonTool) logOutputPreview(output string) {
 	output = strings.TrimSpace(output)
 	if output == "" {
-		log.Printf("%s   (no output)")
+		log.Printf("%s   (no output)", logPrefix)
 		return
 	}
 
@@ -497,13 +812,6 @@ func (p *PythonTool) logOutputPreview(output string) {
 }
 
 // safePath ensures the path stays within the workspace directory.
-func (p *PythonTool) safePath(filename string) string {
-	// Clean and make absolute to prevent directory traversal
-	cleaned := filepath.Clean(filename)
-	// Remove any leading slashes or parent directory references
-	cleaned = strings.TrimPrefix(cleaned, "/")
-	for strings.HasPrefix(cleaned, "../") {
-		cleaned = strings.TrimPrefix(cleaned, "../")
-	}
-	return filepath.Join(p.workspaceDir, cleaned)
+func (p *PythonTool) safePath(filename string) (string, error) {
+	return safePath(p.workspaceDir, filename)
 }
@@ -0,0 +1,241 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const pipTimeout = 3 * time.Minute
+
+// DefaultPythonPackages are the packages the python tool may install on
+// demand when no allowlist is configured.
+var DefaultPythonPackages = []string{
+	"numpy", "pandas", "matplotlib", "scipy", "sympy", "seaborn",
+	"requests", "httpx", "beautifulsoup4", "lxml", "pyyaml", "toml",
+	"python-dateutil", "pytz", "tabulate", "pillow", "openpyxl",
+	"scikit-learn", "networkx", "rich", "pydantic",
+	"pytest", "pytest-cov", "ruff",
+}
+
+// moduleToPackage maps import names to PyPI names where they differ.
+var moduleToPackage = map[string]string{
+	"bs4":      "beautifulsoup4",
+	"yaml":     "pyyaml",
+	"dateutil": "python-dateutil",
+	"PIL":      "pillow",
+	"sklearn":  "scikit-learn",
+}
+
+// missingModule matches the error Python prints for an absent import.
+var missingModule = regexp.MustCompile(`ModuleNotFoundError: No module named '([A-Za-z0-9_.]+)'`)
+
+var (
+	// requirementSpec is a package name and optional version specifiers,
+	// e.g. "pandas>=2.0,<3". Anything else pip accepts on a requirements
+	// line (URLs, options, extras, environment markers) is rejected.
+	requirementSpec = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*((?:[<>=!~][^<>=!~]*)*)$`)
+	versionSpec     = regexp.MustCompile(`^(==|!=|<=|>=|~=|<|>)\s*([0-9][A-Za-z0-9.*+!_-]*)$`)
+)
+
+// parseRequirement reads a requirements.txt line into its package name and
+// the install spec rebuilt from it, so nothing but the name and validated
+// version specifiers reaches pip.
+func parseRequirement(line string) (name, spec string, err error) {
+	if i := strings.Index(line, " #"); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	m := requirementSpec.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", fmt.Errorf("%q is not a package name with optional version specifiers", line)
+	}
+	name, spec = m[1], m[1]
+	if strings.TrimSpace(m[2]) == "" {
+		return name, spec, nil
+	}
+	var specs []string
+	for _, part := range strings.Split(m[2], ",") {
+		v := versionSpec.FindStringSubmatch(strings.TrimSpace(part))
+		if v == nil {
+			return "", "", fmt.Errorf("%q has an invalid version specifier %q", line, strings.TrimSpace(part))
+		}
+		specs = append(specs, v[1]+v[2])
+	}
+	return name, spec + strings.Join(specs, ","), nil
+}
+
+// venvDir is the workspace virtualenv that on-demand installs go into.
+func (p *PythonTool) venvDir() string {
+	return filepath.Join(p.workspaceDir, ".venv")
+}
+
+// interpreter returns the workspace venv's python once it exists, else
+// Python 3 from PATH.
+func (p *PythonTool) interpreter() string {
+	python := venvPython(p.venvDir())
+	if _, err := os.Stat(python); err == nil {
+		if abs, err := filepath.Abs(python); err == nil {
+			return abs
+		}
+	}
+	return hostPython()
+}
+
+// allowed reports whether a package may be installed.
+func (p *PythonTool) allowed(pkg string) bool {
+	for _, a := range p.packages {
+		if strings.EqualFold(a, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// withInstall runs fn and, if it fails on a missing allowlisted module,
+// installs the module's package and runs fn once more. Requirements listed in
+// the workspace's requirements.txt are installed first if the file changed.
+func (p *PythonTool) withInstall(ctx context.Context, fn func() (string, error)) (string, error) {
+	notes := p.installRequirements(ctx)
+
+	output, err := fn()
+
+	m := missingModule.FindStringSubmatch(output)
+	if m == nil {
+		return prependNotes(notes, output), err
+	}
+
+	module := strings.SplitN(m[1], ".", 2)[0]
+	pkg := module
+	if mapped, ok := moduleToPackage[module]; ok {
+		pkg = mapped
+	}
+	if !p.allowed(pkg) {
+		log.Printf("%s module %s (package %s) is not on the install allowlist", logPrefix, module, pkg)
+		notes = append(notes, fmt.Sprintf("Module %q is not installed and %q is not on the install allowlist; use another approach.", module, pkg))
+		return prependNotes(notes, output), err
+	}
+
+	if installErr := p.pipInstall(ctx, pkg); installErr != nil {
+		notes = append(notes, fmt.Sprintf("Installing %s failed: %v", pkg, installErr))
+		return prependNotes(notes, output), err
+	}
+	notes = append(notes, fmt.Sprintf("Installed missing package %s and re-ran.", pkg))
+
+	output, err = fn()
+	return prependNotes(notes, output), err
+}
+
+// installRequirements installs allowlisted packages from requirements.txt
+// when the file is new or changed since the last install.
+func (p *PythonTool) installRequirements(ctx context.Context) []string {
+	path := filepath.Join(p.workspaceDir, "requirements.txt")
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	changed := info.ModTime().After(p.requirementsInstalled)
+	p.mu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var install, skipped, rejected []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, spec, err := parseRequirement(line)
+		switch {
+		case err != nil:
+			log.Printf("%s requirements.txt: %v", logPrefix, err)
+			rejected = append(rejected, line)
+		case p.allowed(name):
+			install = append(install, spec)
+		default:
+			skipped = append(skipped, name)
+		}
+	}
+
+	var notes []string
+	if len(skipped) > 0 {
+		notes = append(notes, "requirements.txt entries not on the install allowlist were skipped: "+strings.Join(skipped, ", "))
+	}
+	if len(rejected) > 0 {
+		notes = append(notes, "requirements.txt lines other than a package name and version (URLs, options, extras, markers) were skipped: "+strings.Join(rejected, "; "))
+	}
+	if len(install) > 0 {
+		if err := p.pipInstall(ctx, install...); err != nil {
+			return append(notes, fmt.Sprintf("Installing requirements.txt failed: %v", err))
+		}
+		notes = append(notes, "Installed requirements.txt: "+strings.Join(install, ", "))
+	}
+
+	p.mu.Lock()
+	p.requirementsInstalled = info.ModTime()
+	p.mu.Unlock()
+	return notes
+}
+
+// pipInstall installs packages into the workspace venv, creating it (with
+// access to system site-packages) on first use.
+func (p *PythonTool) pipInstall(ctx context.Context, packages ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, pipTimeout)
+	defer cancel()
+
+	venv := p.venvDir()
+	if _, err := os.Stat(venvPython(venv)); os.IsNotExist(err) {
+		log.Printf("%s creating venv %s", logPrefix, venv)
+		cmd := exec.CommandContext(ctx, hostPython(), "-m", "venv", "--system-site-packages", venv)
+		isolate(ctx, cmd, "pip")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("creating venv: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	log.Printf("%s pip install %s", logPrefix, strings.Join(packages, " "))
+	args := append([]string{"-m", "pip", "install", "--quiet", "--disable-pip-version-check"}, packages...)
+	cmd := exec.CommandContext(ctx, venvPython(venv), args...)
+	isolate(ctx, cmd, "pip")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pip install: %w: %s", err, lastLines(string(out), 5))
+	}
+	return nil
+}
+
+func prependNotes(notes []string, output string) string {
+	if len(notes) == 0 {
+		return output
+	}
+	return "(" + strings.Join(notes, "\n ") + ")\n\n" + output
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
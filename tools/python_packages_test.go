@@ -0,0 +1,42 @@
+package tools
+
+import "testing"
+
+func TestParseRequirement(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		spec string // Empty when the line is rejected
+	}{
+		{"requests", "requests", "requests"},
+		{"pandas>=2.0,<3", "pandas", "pandas>=2.0,<3"},
+		{"numpy == 1.26.4", "numpy", "numpy==1.26.4"},
+		{"scipy~=1.11  # for stats", "scipy", "scipy~=1.11"},
+		{"python-dateutil!=2.8.0", "python-dateutil", "python-dateutil!=2.8.0"},
+		{"requests @ https://evil.example/x.whl", "", ""},
+		{"--index-url https://evil.example/simple", "", ""},
+		{"-e git+https://evil.example/repo.git#egg=requests", "", ""},
+		{"requests --extra-index-url https://evil.example/simple", "", ""},
+		{"requests; python_version > '3'", "", ""},
+		{"requests[socks]", "", ""},
+		{"requests==2.31 --hash=sha256:abc", "", ""},
+		{"requests>=", "", ""},
+		{"requests===2.31", "", ""},
+		{"./local/package", "", ""},
+		{"https://evil.example/requests.tar.gz", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			name, spec, err := parseRequirement(tt.line)
+			if tt.spec == "" {
+				if err == nil {
+					t.Fatalf("parseRequirement(%q) = %q, %q; want an error", tt.line, name, spec)
+				}
+				return
+			}
+			if err != nil || name != tt.name || spec != tt.spec {
+				t.Fatalf("parseRequirement(%q) = %q, %q, %v; want %q, %q", tt.line, name, spec, err, tt.name, tt.spec)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const maxProjectFiles = 50
+
+// developProject writes a multi-module project into the directory name and
+// runs its whole test suite. Files not in the map are left as they are, so a
+// fix only needs to resend what changed.
+func (p *PythonTool) developProject(ctx context.Context, name string, files map[string]any, args map[string]any) (string, error) {
+	if len(files) > maxProjectFiles {
+		return "", fmt.Errorf("too many files (%d), the limit is %d", len(files), maxProjectFiles)
+	}
+
+	root, err := filepath.Abs(p.workspaceDir)
+	if err != nil {
+		return "", fmt.Errorf("resolving workspace path: %w", err)
+	}
+	projectDir, err := p.safePath(name)
+	if err != nil {
+		return "", err
+	}
+	if projectDir == root {
+		return "", fmt.Errorf("name must be a project directory, not the workspace root")
+	}
+	project, _ := filepath.Rel(root, projectDir)
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		content, ok := files[path].(string)
+		if !ok {
+			return "", fmt.Errorf("content for %s must be a string", path)
+		}
+
+		fullPath, err := safePath(projectDir, path)
+		if err != nil || fullPath == projectDir {
+			return "", fmt.Errorf("file %s is outside the project directory", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return "", fmt.Errorf("creating directory: %w", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			return "", fmt.Errorf("writing %s: %w", path, err)
+		}
+		log.Printf("%s develop: wrote %s/%s (%d bytes)", logPrefix, project, path, len(content))
+	}
+
+	sources, hasTests, err := projectLayout(projectDir)
+	if err != nil {
+		return "", fmt.Errorf("reading project: %w", err)
+	}
+	if !hasTests {
+		return "", fmt.Errorf("no test_*.py files in project %s - include tests in 'files'", project)
+	}
+
+	if len(sources) == 0 {
+		sources = []string{"."}
+	}
+
+	var listed []string
+	for _, path := range paths {
+		listed = append(listed, filepath.Join(project, path))
+	}
+
+	return p.verify(ctx, developSpec{
+		name:  project,
+		dir:   project,
+		tests: []string{"."},
+		cover: sources,
+		lint:  []string{project},
+		files: listed,
+		fixHint: fmt.Sprintf(`Fix the code and call python again with:
+- operation: "develop"
+- name: "%s"
+- files: only the files you changed`, project),
+		fixNote: "Make minimal changes to fix the specific errors shown above.",
+	}, args)
+}
+
+// projectLayout finds the project's top-level source packages and modules
+// (the coverage targets) and whether it contains any tests.
+func projectLayout(dir string) ([]string, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var sources []string
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasPrefix(name, ".") || name == "__pycache__" || name == "tests" || name == "test":
+		case e.IsDir():
+			if _, err := os.Stat(filepath.Join(dir, name, "__init__.py")); err == nil {
+				sources = append(sources, name)
+			}
+		case strings.HasSuffix(name, ".py") && !isTestFile(name) && name != "conftest.py":
+			sources = append(sources, strings.TrimSuffix(name, ".py"))
+		}
+	}
+
+	hasTests := false
+	walkWorkspace(dir, func(path string, info os.FileInfo) {
+		if isTestFile(info.Name()) {
+			hasTests = true
+		}
+	})
+	return sources, hasTests, nil
+}
+
+func isTestFile(name string) bool {
+	return strings.HasSuffix(name, ".py") && (strings.HasPrefix(name, "test_") || strings.HasSuffix(name, "_test.py"))
+}
@@ -0,0 +1,601 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/embed"
+	"telegram-bot/tenant"
+)
+
+const (
+	readingStoreKey        = "reading_list"
+	readingLogPrefix       = "[reading]"
+	maxReadingItemsPerChat = 500
+	maxReadingTags         = 5
+	maxReadingListed       = 20 // Items shown by list and search
+	maxDigestItems         = 10
+	minReadingSimilarity   = 0.5 // How close an article must be to a query to match by meaning
+	digestInterval         = 7 * 24 * time.Hour
+	digestCheckInterval    = time.Hour
+	noReadingMatches       = "No matching articles on your reading list."
+)
+
+// readingItem is a saved article.
+type readingItem struct {
+	ID      int       `json:"id"`
+	ChatID  int64     `json:"chat_id"`
+	URL     string    `json:"url"`
+	Title   string    `json:"title"`
+	Summary string    `json:"summary"`
+	Tags    []string  `json:"tags,omitempty"`
+	Saved   time.Time `json:"saved"`
+	Read    time.Time `json:"read,omitempty"`
+}
+
+func (item *readingItem) unread() bool {
+	return item.Read.IsZero()
+}
+
+type readingState struct {
+	NextID int           `json:"next_id"`
+	Items  []readingItem `json:"items"`
+	// Digests maps chats that want a weekly digest to when they last got one.
+	Digests map[int64]time.Time `json:"digests,omitempty"`
+}
+
+// ReadingListTool keeps a per-chat read-later list of articles, each saved
+// with a short summary and tags, and can send a weekly digest of unread ones.
+type ReadingListTool struct {
+	scrape *ScrapeTool
+	embed  *embed.Client // Finds articles by meaning when words don't match; nil to disable
+
+	host  *Host // Set by Start; nil when background work is unavailable
+	mu    sync.Mutex
+	state readingState
+
+	tenants *tenantCopies[*ReadingListTool] // Nil on the copies themselves
+}
+
+// ReadingOption customizes a ReadingListTool.
+type ReadingOption func(*ReadingListTool)
+
+// WithReadingEmbeddings makes search fall back to finding articles similar
+// in meaning to the query when none contain its words.
+func WithReadingEmbeddings(client *embed.Client) ReadingOption {
+	return func(r *ReadingListTool) {
+		r.embed = client
+	}
+}
+
+// NewReadingListTool creates a reading list that fetches and summarizes
+// articles with the scrape tool.
+func NewReadingListTool(scrape *ScrapeTool, opts ...ReadingOption) *ReadingListTool {
+	r := &ReadingListTool{scrape: scrape, tenants: newTenantCopies[*ReadingListTool]()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *ReadingListTool) Name() string {
+	return "reading_list"
+}
+
+func (r *ReadingListTool) Description() string {
+	return `Read-later list of saved articles for this chat.
+
+operation=save with url stores an article with a short summary and tags.
+operation=list shows unread articles (include_read=true for all, tag to filter).
+operation=search with query finds saved articles by title, summary, tag, or URL.
+operation=read or remove with item_id marks an article read or deletes it.
+operation=digest shows a digest of unread articles now; with enabled=true/false
+it turns the weekly digest for this chat on or off.`
+}
+
+func (r *ReadingListTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"save", "list", "search", "read", "remove", "digest"},
+				"description": "What to do with the reading list",
+			},
+			"url": map[string]any{
+				"type":        "string",
+				"description": "For save: the article URL",
+			},
+			"query": map[string]any{
+				"type":        "string",
+				"description": "For search: words to look for",
+			},
+			"tag": map[string]any{
+				"type":        "string",
+				"description": "For list: only show articles with this tag",
+			},
+			"item_id": map[string]any{
+				"type":        "number",
+				"description": "For read and remove: the article number",
+			},
+			"include_read": map[string]any{
+				"type":        "boolean",
+				"description": "For list and search: include articles already marked read",
+			},
+			"enabled": map[string]any{
+				"type":        "boolean",
+				"description": "For digest: turn the weekly digest on (true) or off (false)",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (r *ReadingListTool) Metadata() Metadata {
+	return Metadata{Cost: CostMedium}
+}
+
+// Start loads the reading list and schedules the weekly digests. With
+// tenants, each tenant's list is loaded too, so their digests go out
+// without waiting for them to use the list first.
+func (r *ReadingListTool) Start(host Host) error {
+	if err := r.load(host); err != nil {
+		return err
+	}
+	if host.Tenants != nil {
+		all, err := host.Tenants.All()
+		if err != nil {
+			return err
+		}
+		for _, t := range all {
+			if _, err := r.tenants.of(t, r.tenantCopy); err != nil {
+				log.Printf("%s loading the reading list of tenant %s: %v", readingLogPrefix, t.ID, err)
+			}
+		}
+	}
+	// Check often so a digest goes out close to a week after the last one,
+	// even across restarts
+	host.Scheduler.Every("reading digests", digestCheckInterval, r.sendDigests)
+	return nil
+}
+
+func (r *ReadingListTool) load(host Host) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.host = &host
+	if _, err := host.Store.Get(readingStoreKey, &r.state); err != nil {
+		return fmt.Errorf("loading reading list: %w", err)
+	}
+	log.Printf("%s %d saved articles, %d weekly digests", readingLogPrefix, len(r.state.Items), len(r.state.Digests))
+	return nil
+}
+
+// forTenant returns the reading list of the request's tenant, kept in
+// their encrypted store.
+func (r *ReadingListTool) forTenant(ctx context.Context) (*ReadingListTool, error) {
+	return r.tenants.load(ctx, r, r.tenantCopy)
+}
+
+func (r *ReadingListTool) tenantCopy(t *tenant.Tenant) (*ReadingListTool, error) {
+	host, err := tenantHost(*r.host, t)
+	if err != nil {
+		return nil, err
+	}
+	scoped := &ReadingListTool{scrape: r.scrape, embed: r.embed}
+	if err := scoped.load(host); err != nil {
+		return nil, err
+	}
+	return scoped, nil
+}
+
+func (r *ReadingListTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if r.host == nil {
+		return "", fmt.Errorf("the reading list is not available in this mode")
+	}
+	r, err := r.forTenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("the reading list needs a chat")
+	}
+
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "save":
+		url, err := urlArg(args)
+		if err != nil {
+			return "", err
+		}
+		return r.save(ctx, chatID, url)
+	case "list":
+		tag, _ := args["tag"].(string)
+		includeRead, _ := args["include_read"].(bool)
+		return r.list(chatID, tag, includeRead), nil
+	case "search":
+		query, _ := args["query"].(string)
+		if strings.TrimSpace(query) == "" {
+			return "", fmt.Errorf("query is required for search")
+		}
+		return r.search(ctx, chatID, query), nil
+	case "read", "remove":
+		id, ok := args["item_id"].(float64)
+		if !ok {
+			return "", fmt.Errorf("item_id is required for %s (see operation=list)", operation)
+		}
+		return r.update(chatID, int(id), operation == "remove")
+	case "digest":
+		if enabled, ok := args["enabled"].(bool); ok {
+			return r.setDigest(chatID, enabled)
+		}
+		if digest := r.digest(chatID); digest != "" {
+			return digest, nil
+		}
+		return "Nothing unread on your reading list.", nil
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// save fetches an article, summarizes and tags it, and adds it to the
+// chat's reading list.
+func (r *ReadingListTool) save(ctx context.Context, chatID int64, url string) (string, error) {
+	r.mu.Lock()
+	count := 0
+	for _, item := range r.state.Items {
+		if item.ChatID != chatID {
+			continue
+		}
+		if item.URL == url {
+			r.mu.Unlock()
+			return fmt.Sprintf("Already saved as #%d: %s", item.ID, item.Title), nil
+		}
+		count++
+	}
+	r.mu.Unlock()
+	if count >= maxReadingItemsPerChat {
+		return "", fmt.Errorf("the reading list already has %d articles; remove some first", count)
+	}
+
+	page, _, err := r.scrape.readPage(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if page.text == "" {
+		return "", fmt.Errorf("could not extract any text from %s", url)
+	}
+	summary, tags := r.describe(ctx, page)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state.NextID++
+	item := readingItem{
+		ID:      r.state.NextID,
+		ChatID:  chatID,
+		URL:     url,
+		Title:   page.title,
+		Summary: summary,
+		Tags:    tags,
+		Saved:   time.Now().UTC(),
+	}
+	r.state.Items = append(r.state.Items, item)
+	if err := r.host.Store.Save(readingStoreKey, r.state); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s saved #%d for chat %d: %s", readingLogPrefix, item.ID, chatID, url)
+	return "📚 Saved to your reading list\n\n" + formatReadingItem(item), nil
+}
+
+// describe asks the model for a summary and tags. If it fails, the start
+// of the text stands in for the summary.
+func (r *ReadingListTool) describe(ctx context.Context, page crawledPage) (string, []string) {
+	prompt := fmt.Sprintf(`Describe this article for a reading list.
+
+Title: %s
+URL: %s
+
+%s
+
+Reply in exactly this format and nothing else:
+SUMMARY: <one or two sentences on what the article is about>
+TAGS: <up to %d short lowercase topic tags, comma-separated>`,
+		page.title, page.url, truncateText(page.text, maxContentLen), maxReadingTags)
+
+	response, err := r.scrape.generate(ctx, prompt)
+	if err != nil {
+		log.Printf("%s summarizing %s failed: %v", readingLogPrefix, page.url, err)
+		return truncateText(page.text, 200), nil
+	}
+
+	var summary string
+	var tags []string
+	for _, line := range strings.Split(response, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(strings.Trim(key, "* ")) {
+		case "SUMMARY":
+			summary = strings.TrimSpace(value)
+		case "TAGS":
+			tags = parseTags(value)
+		}
+	}
+	if summary == "" {
+		// The model ignored the format; keep whatever it wrote
+		summary = truncateText(strings.TrimSpace(response), 300)
+	}
+	return summary, tags
+}
+
+// parseTags normalizes a comma-separated tag list.
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.Trim(strings.ToLower(strings.TrimSpace(tag)), "#.")
+		tag = strings.Join(strings.Fields(tag), "-")
+		if tag != "" && !slices.Contains(tags, tag) && len(tags) < maxReadingTags {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (r *ReadingListTool) list(chatID int64, tag string, includeRead bool) string {
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	return r.find(chatID, includeRead, func(item *readingItem) bool {
+		return tag == "" || slices.Contains(item.Tags, tag)
+	})
+}
+
+// search matches articles containing every word of the query. If none do,
+// and embeddings are configured, it lists the articles closest in meaning.
+func (r *ReadingListTool) search(ctx context.Context, chatID int64, query string) string {
+	words := strings.Fields(strings.ToLower(query))
+	found := r.find(chatID, true, func(item *readingItem) bool {
+		text := strings.ToLower(readingText(*item) + " " + item.URL)
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+		return true
+	})
+	if r.embed == nil || found != noReadingMatches {
+		return found
+	}
+
+	similar, err := r.similar(ctx, chatID, query)
+	if err != nil {
+		log.Printf("%s similarity search failed: %v", readingLogPrefix, err)
+		return found
+	}
+	if len(similar) == 0 {
+		return found
+	}
+	var b strings.Builder
+	b.WriteString("No articles contain those words; these are closest in meaning:\n\n")
+	for _, item := range similar {
+		b.WriteString(formatReadingItem(item) + "\n\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// similar returns the chat's articles closest in meaning to the query,
+// best first.
+func (r *ReadingListTool) similar(ctx context.Context, chatID int64, query string) ([]readingItem, error) {
+	r.mu.Lock()
+	var items []readingItem
+	for _, item := range r.state.Items {
+		if item.ChatID == chatID {
+			items = append(items, item)
+		}
+	}
+	r.mu.Unlock()
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	// Articles' vectors come from the cache after the first search
+	texts := []string{query}
+	for _, item := range items {
+		texts = append(texts, readingText(item))
+	}
+	vectors, err := r.embed.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[int]float64)
+	var matches []readingItem
+	for i, item := range items {
+		if score := embed.Cosine(vectors[0], vectors[i+1]); score >= minReadingSimilarity {
+			scores[item.ID] = score
+			matches = append(matches, item)
+		}
+	}
+	slices.SortFunc(matches, func(a, b readingItem) int {
+		return cmp.Compare(scores[b.ID], scores[a.ID])
+	})
+	if len(matches) > maxReadingListed {
+		matches = matches[:maxReadingListed]
+	}
+	return matches, nil
+}
+
+// readingText is the text an article is searched and embedded by.
+func readingText(item readingItem) string {
+	return strings.Join([]string{item.Title, item.Summary, strings.Join(item.Tags, " ")}, "\n")
+}
+
+// find lists the chat's matching articles, newest first.
+func (r *ReadingListTool) find(chatID int64, includeRead bool, match func(*readingItem) bool) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	shown, total := 0, 0
+	for i := len(r.state.Items) - 1; i >= 0; i-- {
+		item := &r.state.Items[i]
+		if item.ChatID != chatID || (!includeRead && !item.unread()) || !match(item) {
+			continue
+		}
+		total++
+		if shown < maxReadingListed {
+			b.WriteString(formatReadingItem(*item) + "\n\n")
+			shown++
+		}
+	}
+	if total == 0 {
+		return noReadingMatches
+	}
+	if total > shown {
+		fmt.Fprintf(&b, "...and %d more", total-shown)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (r *ReadingListTool) update(chatID int64, id int, remove bool) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.state.Items, func(item readingItem) bool {
+		return item.ID == id && item.ChatID == chatID
+	})
+	if i < 0 {
+		return "", fmt.Errorf("no article #%d on this chat's reading list", id)
+	}
+
+	item := r.state.Items[i]
+	var reply string
+	if remove {
+		r.state.Items = slices.Delete(r.state.Items, i, i+1)
+		reply = fmt.Sprintf("Removed #%d: %s", id, item.Title)
+	} else {
+		r.state.Items[i].Read = time.Now().UTC()
+		reply = fmt.Sprintf("✅ Marked #%d as read: %s", id, item.Title)
+	}
+	if err := r.host.Store.Save(readingStoreKey, r.state); err != nil {
+		return "", err
+	}
+	return reply, nil
+}
+
+func (r *ReadingListTool) setDigest(chatID int64, enabled bool) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if enabled {
+		if r.state.Digests == nil {
+			r.state.Digests = make(map[int64]time.Time)
+		}
+		if _, ok := r.state.Digests[chatID]; !ok {
+			r.state.Digests[chatID] = time.Now().UTC()
+		}
+	} else {
+		delete(r.state.Digests, chatID)
+	}
+	if err := r.host.Store.Save(readingStoreKey, r.state); err != nil {
+		return "", err
+	}
+
+	if enabled {
+		return "📬 Weekly reading digest enabled for this chat.", nil
+	}
+	return "Weekly reading digest disabled.", nil
+}
+
+// digest lists the chat's unread articles, newest first, or returns ""
+// if there are none.
+func (r *ReadingListTool) digest(chatID int64) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unread []readingItem
+	for i := len(r.state.Items) - 1; i >= 0; i-- {
+		if item := r.state.Items[i]; item.ChatID == chatID && item.unread() {
+			unread = append(unread, item)
+		}
+	}
+	if len(unread) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📚 Reading digest: %d unread\n\n", len(unread))
+	for _, item := range unread[:min(len(unread), maxDigestItems)] {
+		b.WriteString(formatReadingItem(item) + "\n\n")
+	}
+	if len(unread) > maxDigestItems {
+		fmt.Fprintf(&b, "...and %d more. ", len(unread)-maxDigestItems)
+	}
+	b.WriteString("Mark one done with \"mark #N as read\".")
+	return b.String()
+}
+
+// sendDigests sends the weekly digest to every chat that is due one, from
+// the shared list and every tenant's.
+func (r *ReadingListTool) sendDigests(ctx context.Context) error {
+	var errs []error
+	for _, scoped := range append([]*ReadingListTool{r}, r.tenants.all()...) {
+		errs = append(errs, scoped.sendDue())
+	}
+	return errors.Join(errs...)
+}
+
+// sendDue sends the list's digests that are due. Chats with nothing unread
+// are skipped until the next week.
+func (r *ReadingListTool) sendDue() error {
+	now := time.Now().UTC()
+
+	r.mu.Lock()
+	var due []int64
+	for chatID, last := range r.state.Digests {
+		if now.Sub(last) >= digestInterval {
+			due = append(due, chatID)
+		}
+	}
+	r.mu.Unlock()
+	if len(due) == 0 {
+		return nil
+	}
+
+	for _, chatID := range due {
+		if digest := r.digest(chatID); digest != "" {
+			r.host.Send(chatID, digest)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, chatID := range due {
+		if _, ok := r.state.Digests[chatID]; ok {
+			r.state.Digests[chatID] = now
+		}
+	}
+	return r.host.Store.Save(readingStoreKey, r.state)
+}
+
+func formatReadingItem(item readingItem) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s", item.ID, item.Title)
+	if !item.unread() {
+		b.WriteString(" ✓")
+	}
+	fmt.Fprintf(&b, "\n%s\n%s", item.URL, item.Summary)
+	if len(item.Tags) > 0 {
+		b.WriteString("\n🏷 #" + strings.Join(item.Tags, " #"))
+	}
+	return b.String()
+}
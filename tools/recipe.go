@@ -0,0 +1,582 @@
+package tools
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	recipeTimeout         = 20 * time.Second
+	defaultRecipeAPIURL   = "https://www.themealdb.com/api/json/v1/1" // Free test key
+	defaultRecipeServings = 4                                         // Assumed when a recipe doesn't say
+	maxRecipeResults      = 8
+)
+
+// recipe is a recipe from the API or a cooking site, in one shape.
+type recipe struct {
+	Title       string
+	Source      string // Where it came from: a URL, or the API's ID
+	Servings    int    // Zero when the recipe doesn't say
+	TotalTime   string
+	Ingredients []string
+	Steps       []string
+}
+
+// RecipeTool finds recipes in a recipe API or on allowlisted cooking sites,
+// scales and converts their ingredients, and can put them on the shopping
+// list.
+type RecipeTool struct {
+	apiURL     string
+	sites      []string // Hosts whose recipe pages may be read; subdomains match too
+	scrape     *ScrapeTool
+	shopping   *ShoppingListTool // nil when the shopping list isn't enabled
+	httpClient *http.Client
+}
+
+// RecipeOption customizes a RecipeTool.
+type RecipeOption func(*RecipeTool)
+
+// WithRecipeAPI searches a TheMealDB-compatible API other than the public one.
+func WithRecipeAPI(apiURL string) RecipeOption {
+	return func(r *RecipeTool) {
+		r.apiURL = strings.TrimSuffix(apiURL, "/")
+	}
+}
+
+// WithRecipeSites allows reading recipes from pages on these sites, which
+// are fetched with the scrape tool.
+func WithRecipeSites(hosts []string) RecipeOption {
+	return func(r *RecipeTool) {
+		for _, host := range hosts {
+			r.sites = append(r.sites, strings.ToLower(strings.TrimPrefix(host, "www.")))
+		}
+	}
+}
+
+// WithRecipeShoppingList lets the tool put ingredients on the shared list.
+func WithRecipeShoppingList(shopping *ShoppingListTool) RecipeOption {
+	return func(r *RecipeTool) {
+		r.shopping = shopping
+	}
+}
+
+// NewRecipeTool creates a recipe tool that reads cooking sites with the
+// scrape tool.
+func NewRecipeTool(scrape *ScrapeTool, opts ...RecipeOption) *RecipeTool {
+	r := &RecipeTool{
+		apiURL:     defaultRecipeAPIURL,
+		scrape:     scrape,
+		httpClient: &http.Client{Timeout: recipeTimeout},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *RecipeTool) Name() string {
+	return "recipes"
+}
+
+func (r *RecipeTool) Description() string {
+	var b strings.Builder
+	b.WriteString(`Find recipes, scale them, and convert units.
+
+operation=search with query (a dish or main ingredient) lists matching recipes with their IDs.
+operation=get with recipe_id shows the ingredients and steps; servings scales the
+ingredients, and units=metric or units=us converts them.
+operation=convert with amount, from, and to converts one quantity (cups to ml, oz to g,
+F to C, ...).`)
+	if len(r.sites) > 0 {
+		fmt.Fprintf(&b, "\nget also takes url, a recipe page on %s.", strings.Join(r.sites, ", "))
+	}
+	if r.shopping != nil {
+		b.WriteString("\noperation=shop with recipe_id or url (and servings) puts the ingredients on the shared shopping list.")
+	}
+	return b.String()
+}
+
+func (r *RecipeTool) Parameters() map[string]any {
+	operations := []string{"search", "get", "convert"}
+	if r.shopping != nil {
+		operations = append(operations, "shop")
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        operations,
+				"description": "What to do",
+			},
+			"query": map[string]any{
+				"type":        "string",
+				"description": "For search: a dish or ingredient, e.g. lasagne or chicken",
+			},
+			"recipe_id": map[string]any{
+				"type":        "string",
+				"description": "For get and shop: the ID from search results",
+			},
+			"url": map[string]any{
+				"type":        "string",
+				"description": "For get and shop: a recipe page on an allowed cooking site",
+			},
+			"servings": map[string]any{
+				"type":        "number",
+				"description": "For get and shop: how many people to cook for",
+			},
+			"units": map[string]any{
+				"type":        "string",
+				"enum":        []string{"metric", "us"},
+				"description": "For get: convert ingredient quantities",
+			},
+			"amount": map[string]any{
+				"type":        "number",
+				"description": "For convert: the quantity",
+			},
+			"from": map[string]any{
+				"type":        "string",
+				"description": "For convert: the unit to convert from (cup, tbsp, oz, lb, g, ml, F, C, ...)",
+			},
+			"to": map[string]any{
+				"type":        "string",
+				"description": "For convert: the unit to convert to",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (r *RecipeTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+func (r *RecipeTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"operation": "search", "query": "pancakes"}, "Pancakes"
+}
+
+func (r *RecipeTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "search":
+		query, _ := args["query"].(string)
+		return r.search(ctx, strings.TrimSpace(query))
+	case "get", "shop":
+		rec, err := r.load(ctx, args)
+		if err != nil {
+			return "", err
+		}
+		servings, _ := args["servings"].(float64)
+		units, _ := args["units"].(string)
+		ingredients, note := scaleRecipe(rec, servings, units)
+		if operation == "shop" {
+			return r.shop(ctx, rec, ingredients)
+		}
+		return formatRecipe(rec, ingredients, note), nil
+	case "convert":
+		amount, ok := args["amount"].(float64)
+		from, _ := args["from"].(string)
+		to, _ := args["to"].(string)
+		if !ok || from == "" || to == "" {
+			return "", fmt.Errorf("amount, from, and to are required for convert")
+		}
+		return convertUnits(amount, from, to)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// mealDBMeal is a meal from TheMealDB. Ingredients come as numbered
+// fields, strIngredient1 to strIngredient20 with matching strMeasure fields.
+type mealDBMeal map[string]any
+
+func (m mealDBMeal) field(name string) string {
+	s, _ := m[name].(string)
+	return strings.TrimSpace(s)
+}
+
+func (r *RecipeTool) callMealDB(ctx context.Context, endpoint string, query url.Values) ([]mealDBMeal, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.apiURL+"/"+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	var result struct {
+		Meals []mealDBMeal `json:"meals"` // null when nothing matches
+	}
+	if err := doJSON(r.httpClient, req, &result); err != nil {
+		return nil, fmt.Errorf("searching recipes: %w", err)
+	}
+	return result.Meals, nil
+}
+
+func (r *RecipeTool) search(ctx context.Context, query string) (string, error) {
+	if query == "" {
+		return "", fmt.Errorf("query is required for search")
+	}
+	meals, err := r.callMealDB(ctx, "search.php", url.Values{"s": {query}})
+	if err != nil {
+		return "", err
+	}
+	if len(meals) == 0 {
+		// Names didn't match; try it as a main ingredient
+		if meals, err = r.callMealDB(ctx, "filter.php", url.Values{"i": {strings.ReplaceAll(query, " ", "_")}}); err != nil {
+			return "", err
+		}
+	}
+	if len(meals) == 0 {
+		return fmt.Sprintf("No recipes found for %q.", query), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Recipes for %q:\n", query)
+	for _, m := range meals[:min(len(meals), maxRecipeResults)] {
+		var about []string
+		for _, field := range []string{"strCategory", "strArea"} {
+			if v := m.field(field); v != "" {
+				about = append(about, v)
+			}
+		}
+		line := "• " + m.field("strMeal")
+		if len(about) > 0 {
+			line += " (" + strings.Join(about, ", ") + ")"
+		}
+		fmt.Fprintf(&b, "%s — recipe_id %s\n", line, m.field("idMeal"))
+	}
+	if len(meals) > maxRecipeResults {
+		fmt.Fprintf(&b, "...and %d more\n", len(meals)-maxRecipeResults)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// load fetches the recipe named by recipe_id or url.
+func (r *RecipeTool) load(ctx context.Context, args map[string]any) (*recipe, error) {
+	id, _ := args["recipe_id"].(string)
+	if v, ok := args["recipe_id"].(float64); ok {
+		id = strconv.Itoa(int(v))
+	}
+	pageURL, _ := args["url"].(string)
+
+	switch {
+	case pageURL != "":
+		return r.fromSite(ctx, strings.TrimSpace(pageURL))
+	case id != "":
+		meals, err := r.callMealDB(ctx, "lookup.php", url.Values{"i": {strings.TrimSpace(id)}})
+		if err != nil {
+			return nil, err
+		}
+		if len(meals) == 0 {
+			return nil, fmt.Errorf("no recipe with ID %s", id)
+		}
+		return recipeFromMealDB(meals[0]), nil
+	default:
+		return nil, fmt.Errorf("recipe_id or url is required")
+	}
+}
+
+func recipeFromMealDB(m mealDBMeal) *recipe {
+	rec := &recipe{Title: m.field("strMeal"), Source: cmp.Or(m.field("strSource"), "TheMealDB #"+m.field("idMeal"))}
+	for i := 1; i <= 20; i++ {
+		name := m.field(fmt.Sprintf("strIngredient%d", i))
+		if name == "" {
+			continue
+		}
+		rec.Ingredients = append(rec.Ingredients, strings.TrimSpace(m.field(fmt.Sprintf("strMeasure%d", i))+" "+name))
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(m.field("strInstructions"), "\r", ""), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !isStepNumber(line) {
+			rec.Steps = append(rec.Steps, line)
+		}
+	}
+	return rec
+}
+
+// isStepNumber matches the "STEP 1" and "1." lines some recipes put before
+// each step.
+func isStepNumber(line string) bool {
+	return stepNumberPattern.MatchString(line)
+}
+
+var stepNumberPattern = regexp.MustCompile(`(?i)^(step\s*)?\d+\.?$`)
+
+// fromSite reads the schema.org Recipe data that cooking sites embed for
+// search engines.
+func (r *RecipeTool) fromSite(ctx context.Context, pageURL string) (*recipe, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid URL: %s", pageURL)
+	}
+	if !r.siteAllowed(u.Hostname()) {
+		if len(r.sites) == 0 {
+			return nil, fmt.Errorf("reading recipes from websites is not enabled")
+		}
+		return nil, fmt.Errorf("%s is not an allowed recipe site (allowed: %s)", u.Hostname(), strings.Join(r.sites, ", "))
+	}
+
+	body, finalURL, err := r.scrape.fetch(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := parseRecipePage(body)
+	if !ok {
+		return nil, fmt.Errorf("no recipe found on %s", pageURL)
+	}
+	rec.Source = finalURL
+	return rec, nil
+}
+
+func (r *RecipeTool) siteAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, site := range r.sites {
+		if host == site || strings.HasSuffix(host, "."+site) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRecipePage finds a Recipe in a page's JSON-LD scripts.
+func parseRecipePage(body []byte) (*recipe, bool) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	var found *recipe
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if found != nil {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "script" && n.FirstChild != nil && scriptType(n) == "application/ld+json" {
+			var data any
+			if json.Unmarshal([]byte(n.FirstChild.Data), &data) == nil {
+				if node := findRecipeNode(data); node != nil {
+					found = recipeFromSchema(node)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+	return found, found != nil && len(found.Ingredients) > 0
+}
+
+func scriptType(n *html.Node) string {
+	for _, a := range n.Attr {
+		if a.Key == "type" {
+			return strings.ToLower(strings.TrimSpace(a.Val))
+		}
+	}
+	return ""
+}
+
+// findRecipeNode looks through JSON-LD, which may be one object, a list, or
+// an @graph of objects, for one whose @type is or includes Recipe.
+func findRecipeNode(data any) map[string]any {
+	switch v := data.(type) {
+	case []any:
+		for _, item := range v {
+			if node := findRecipeNode(item); node != nil {
+				return node
+			}
+		}
+	case map[string]any:
+		switch t := v["@type"].(type) {
+		case string:
+			if t == "Recipe" {
+				return v
+			}
+		case []any:
+			for _, item := range t {
+				if item == "Recipe" {
+					return v
+				}
+			}
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findRecipeNode(graph)
+		}
+	}
+	return nil
+}
+
+func recipeFromSchema(node map[string]any) *recipe {
+	rec := &recipe{}
+	rec.Title, _ = node["name"].(string)
+	rec.Servings = parseServings(node["recipeYield"])
+	if total, _ := node["totalTime"].(string); total != "" {
+		rec.TotalTime = formatISODuration(total)
+	}
+	if list, ok := node["recipeIngredient"].([]any); ok {
+		for _, item := range list {
+			if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+				rec.Ingredients = append(rec.Ingredients, html.UnescapeString(strings.TrimSpace(s)))
+			}
+		}
+	}
+	rec.Steps = schemaSteps(node["recipeInstructions"])
+	return rec
+}
+
+// schemaSteps flattens recipeInstructions: plain text, a list of strings,
+// HowToSteps, or HowToSections of steps.
+func schemaSteps(v any) []string {
+	var steps []string
+	switch v := v.(type) {
+	case string:
+		for _, line := range strings.Split(plainText(strings.ReplaceAll(v, "\n", "<br>")), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				steps = append(steps, line)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			steps = append(steps, schemaSteps(item)...)
+		}
+	case map[string]any:
+		if list, ok := v["itemListElement"]; ok {
+			return schemaSteps(list)
+		}
+		if text, ok := v["text"].(string); ok {
+			if text = plainText(text); text != "" {
+				steps = append(steps, text)
+			}
+		}
+	}
+	return steps
+}
+
+// parseServings reads recipeYield, such as 4, "4", "Serves 4-6", or
+// ["4", "4 servings"].
+func parseServings(v any) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case string:
+		if m := firstNumber.FindString(v); m != "" {
+			n, _ := strconv.Atoi(m)
+			return n
+		}
+	case []any:
+		for _, item := range v {
+			if n := parseServings(item); n > 0 {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+var firstNumber = regexp.MustCompile(`\d+`)
+
+// formatISODuration turns schema.org durations like PT1H30M into "1 h 30 min".
+func formatISODuration(s string) string {
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil {
+		return s
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute} {
+		if n, err := strconv.Atoi(m[i+1]); err == nil {
+			d += time.Duration(n) * unit
+		}
+	}
+	if d == 0 {
+		return ""
+	}
+	return formatTravelTime(d)
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:\d+S)?)?$`)
+
+// scaleRecipe scales and converts the ingredients. note explains the
+// scaling, or is empty when the recipe is unchanged.
+func scaleRecipe(rec *recipe, servings float64, units string) ([]string, string) {
+	factor, note := 1.0, ""
+	if servings > 0 {
+		base := rec.Servings
+		if base == 0 {
+			base = defaultRecipeServings
+			note = fmt.Sprintf("Scaled from an assumed %d servings to %s.", base, formatAmount(servings, false))
+		} else if float64(base) != servings {
+			note = fmt.Sprintf("Scaled from %d to %s servings.", base, formatAmount(servings, false))
+		}
+		factor = servings / float64(base)
+	}
+	if factor == 1 && units == "" {
+		return rec.Ingredients, note
+	}
+
+	scaled := make([]string, len(rec.Ingredients))
+	for i, line := range rec.Ingredients {
+		ing := parseIngredient(line).scale(factor)
+		if units != "" {
+			ing = ing.convertTo(units)
+		}
+		scaled[i] = ing.String()
+	}
+	return scaled, note
+}
+
+func formatRecipe(rec *recipe, ingredients []string, note string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🍳 %s\n", rec.Title)
+	var about []string
+	if rec.Servings > 0 {
+		about = append(about, fmt.Sprintf("serves %d", rec.Servings))
+	}
+	if rec.TotalTime != "" {
+		about = append(about, rec.TotalTime)
+	}
+	if len(about) > 0 {
+		fmt.Fprintf(&b, "%s\n", strings.Join(about, ", "))
+	}
+	if note != "" {
+		fmt.Fprintf(&b, "%s\n", note)
+	}
+
+	b.WriteString("\nIngredients:\n")
+	for _, ing := range ingredients {
+		fmt.Fprintf(&b, "- %s\n", ing)
+	}
+	if len(rec.Steps) > 0 {
+		b.WriteString("\nSteps:\n")
+		for i, step := range rec.Steps {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, step)
+		}
+	}
+	fmt.Fprintf(&b, "\nSource: %s", rec.Source)
+	return b.String()
+}
+
+// shop puts the ingredients on the shopping list, without preparation
+// notes like ", finely chopped".
+func (r *RecipeTool) shop(ctx context.Context, rec *recipe, ingredients []string) (string, error) {
+	if r.shopping == nil {
+		return "", fmt.Errorf("the shopping list is not enabled")
+	}
+	items := make([]string, 0, len(ingredients))
+	for _, ing := range ingredients {
+		name, _, _ := strings.Cut(ing, ",")
+		if name = strings.TrimSpace(name); name != "" {
+			items = append(items, name)
+		}
+	}
+	result, err := r.shopping.Execute(ctx, map[string]any{"operation": "add", "items": strings.Join(items, "\n")})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Ingredients for %s: %s", rec.Title, result), nil
+}
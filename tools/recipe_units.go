@@ -0,0 +1,335 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// cookingUnit is a unit of volume or mass, measured in milliliters or grams.
+type cookingUnit struct {
+	Name   string // As displayed, singular
+	Plural string
+	Kind   string // volume or mass
+	Size   float64
+}
+
+var (
+	milliliter = cookingUnit{"ml", "ml", "volume", 1}
+	centiliter = cookingUnit{"cl", "cl", "volume", 10}
+	deciliter  = cookingUnit{"dl", "dl", "volume", 100}
+	liter      = cookingUnit{"l", "l", "volume", 1000}
+	teaspoon   = cookingUnit{"tsp", "tsp", "volume", 4.92892}
+	tablespoon = cookingUnit{"tbsp", "tbsp", "volume", 14.7868}
+	fluidOunce = cookingUnit{"fl oz", "fl oz", "volume", 29.5735}
+	cup        = cookingUnit{"cup", "cups", "volume", 236.588}
+	pint       = cookingUnit{"pint", "pints", "volume", 473.176}
+	quart      = cookingUnit{"quart", "quarts", "volume", 946.353}
+	gallon     = cookingUnit{"gallon", "gallons", "volume", 3785.41}
+	milligram  = cookingUnit{"mg", "mg", "mass", 0.001}
+	gram       = cookingUnit{"g", "g", "mass", 1}
+	kilogram   = cookingUnit{"kg", "kg", "mass", 1000}
+	ounce      = cookingUnit{"oz", "oz", "mass", 28.3495}
+	pound      = cookingUnit{"lb", "lb", "mass", 453.592}
+)
+
+// cookingUnits maps the ways recipes write units to the units themselves.
+var cookingUnits = map[string]cookingUnit{
+	"ml": milliliter, "milliliter": milliliter, "millilitre": milliliter,
+	"cl": centiliter, "dl": deciliter,
+	"l": liter, "liter": liter, "litre": liter,
+	"tsp": teaspoon, "teaspoon": teaspoon,
+	"tbsp": tablespoon, "tbs": tablespoon, "tbl": tablespoon, "tablespoon": tablespoon,
+	"fl oz": fluidOunce, "fluid ounce": fluidOunce,
+	"cup": cup, "c": cup,
+	"pint": pint, "pt": pint, "quart": quart, "qt": quart, "gallon": gallon,
+	"mg": milligram, "g": gram, "gram": gram, "gramme": gram,
+	"kg": kilogram, "kilogram": kilogram,
+	"oz": ounce, "ounce": ounce,
+	"lb": pound, "pound": pound, "lbs": pound,
+}
+
+func (u cookingUnit) metric() bool {
+	switch u.Name {
+	case "ml", "cl", "dl", "l", "mg", "g", "kg":
+		return true
+	}
+	return false
+}
+
+// lookupUnit finds a unit by any of its spellings, ignoring case, plurals,
+// and a trailing period.
+func lookupUnit(word string) (cookingUnit, bool) {
+	word = strings.TrimSuffix(strings.ToLower(word), ".")
+	if u, ok := cookingUnits[word]; ok {
+		return u, true
+	}
+	if singular, ok := strings.CutSuffix(word, "s"); ok {
+		u, ok := cookingUnits[singular]
+		return u, ok
+	}
+	return cookingUnit{}, false
+}
+
+// unicodeFractions are the vulgar fractions recipes use.
+var unicodeFractions = map[rune]float64{
+	'¼': 0.25, '½': 0.5, '¾': 0.75, '⅓': 1.0 / 3, '⅔': 2.0 / 3,
+	'⅛': 0.125, '⅜': 0.375, '⅝': 0.625, '⅞': 0.875,
+}
+
+// ingredient is a recipe line split into its amount, unit, and the rest.
+type ingredient struct {
+	Amount    float64 // Zero when the line has no amount, e.g. "salt to taste"
+	AmountMax float64 // The upper end of a range like "2-3", or zero
+	Unit      *cookingUnit
+	Name      string
+}
+
+// parseIngredient reads lines like "1 1/2 cups flour", "200g butter",
+// "½ tsp salt", or "2-3 cloves garlic".
+func parseIngredient(line string) ingredient {
+	line = strings.TrimSpace(line)
+	amount, rest, ok := parseAmount(line)
+	if !ok {
+		return ingredient{Name: line}
+	}
+	ing := ingredient{Amount: amount}
+
+	rest = strings.TrimLeft(rest, " ")
+	for _, sep := range []string{"-", "–", "to "} {
+		if after, found := strings.CutPrefix(rest, sep); found {
+			if upper, r, ok := parseAmount(strings.TrimLeft(after, " ")); ok && upper > amount {
+				ing.AmountMax, rest = upper, strings.TrimLeft(r, " ")
+			}
+			break
+		}
+	}
+
+	// Two-word units first ("fl oz"), then one word, which may be attached
+	// to the number ("200g")
+	words := strings.Fields(rest)
+	switch {
+	case len(words) >= 2:
+		if u, ok := lookupUnit(words[0] + " " + words[1]); ok {
+			ing.Unit, ing.Name = &u, strings.Join(words[2:], " ")
+			return ing
+		}
+		fallthrough
+	case len(words) == 1:
+		if u, ok := lookupUnit(words[0]); ok {
+			ing.Unit, ing.Name = &u, strings.Join(words[1:], " ")
+			return ing
+		}
+	}
+	ing.Name = rest
+	return ing
+}
+
+// parseAmount reads a number at the start of s: "2", "1.5", "1/2",
+// "1 1/2", "½", or "1½". It returns what follows the number.
+func parseAmount(s string) (float64, string, bool) {
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	var total float64
+	found := false
+	if i > 0 {
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, s, false
+		}
+		total, found = n, true
+		// A fraction: "1/2"
+		if i < len(s) && s[i] == '/' {
+			j := i + 1
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			d, err := strconv.ParseFloat(s[i+1:j], 64)
+			if err != nil || d == 0 {
+				return 0, s, false
+			}
+			return total / d, s[j:], true
+		}
+		s = s[i:]
+		// A mixed number: "1 1/2"
+		if rest := strings.TrimLeft(s, " "); rest != s && len(rest) > 2 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == '/' {
+			if frac, after, ok := parseAmount(rest); ok && frac < 1 {
+				return total + frac, after, true
+			}
+		}
+	}
+	// A unicode fraction, alone or after a whole number: "½", "1½", "1 ½"
+	trimmed := strings.TrimLeft(s, " ")
+	if r, size := firstRune(trimmed); size > 0 {
+		if frac, ok := unicodeFractions[r]; ok {
+			return total + frac, trimmed[size:], true
+		}
+	}
+	return total, s, found
+}
+
+func firstRune(s string) (rune, int) {
+	for _, r := range s {
+		return r, len(string(r))
+	}
+	return 0, 0
+}
+
+// scale multiplies the amount, leaving lines without one as they are.
+func (ing ingredient) scale(factor float64) ingredient {
+	ing.Amount *= factor
+	ing.AmountMax *= factor
+	return ing
+}
+
+// convertTo expresses the amount in metric or US units. Units of another
+// system, and lines without units, are left as they are.
+func (ing ingredient) convertTo(system string) ingredient {
+	if ing.Unit == nil || ing.Amount == 0 {
+		return ing
+	}
+	amount := ing.Amount * ing.Unit.Size
+	var target cookingUnit
+	switch {
+	case system == "metric" && ing.Unit.Kind == "volume":
+		target = milliliter
+		if amount >= 1000 {
+			target = liter
+		}
+	case system == "metric":
+		target = gram
+		if amount >= 1000 {
+			target = kilogram
+		}
+	case system == "us" && ing.Unit.Kind == "volume":
+		target = teaspoon
+		if amount >= cup.Size/4 {
+			target = cup
+		} else if amount >= tablespoon.Size {
+			target = tablespoon
+		}
+	case system == "us":
+		target = ounce
+		if amount >= pound.Size {
+			target = pound
+		}
+	default:
+		return ing
+	}
+	ratio := ing.Unit.Size / target.Size
+	ing.Amount *= ratio
+	ing.AmountMax *= ratio
+	ing.Unit = &target
+	return ing
+}
+
+func (ing ingredient) String() string {
+	if ing.Amount == 0 {
+		return ing.Name
+	}
+	metric := ing.Unit != nil && ing.Unit.metric()
+	text := formatAmount(ing.Amount, metric)
+	if ing.AmountMax > 0 {
+		text += "-" + formatAmount(ing.AmountMax, metric)
+	}
+	if ing.Unit != nil {
+		name := ing.Unit.Name
+		if max(ing.Amount, ing.AmountMax) > 1 {
+			name = ing.Unit.Plural
+		}
+		text += " " + name
+	}
+	if ing.Name != "" {
+		text += " " + ing.Name
+	}
+	return text
+}
+
+// formatAmount writes kitchen amounts the way recipes do: fractions like
+// 1½ for cups and spoons, and rounded decimals for metric units.
+func formatAmount(x float64, metric bool) string {
+	if metric {
+		switch {
+		case x >= 100:
+			return strconv.Itoa(int(math.Round(x/5) * 5))
+		case x >= 10:
+			return strconv.Itoa(int(math.Round(x)))
+		default:
+			return strconv.FormatFloat(math.Round(x*10)/10, 'f', -1, 64)
+		}
+	}
+
+	whole, frac := math.Modf(x)
+	if whole >= 10 {
+		return strconv.Itoa(int(math.Round(x)))
+	}
+	best, bestDiff := "", 1.0
+	for _, f := range []struct {
+		text  string
+		value float64
+	}{{"", 0}, {"⅛", 0.125}, {"¼", 0.25}, {"⅓", 1.0 / 3}, {"½", 0.5}, {"⅔", 2.0 / 3}, {"¾", 0.75}, {"", 1}} {
+		if diff := math.Abs(frac - f.value); diff < bestDiff {
+			best, bestDiff = f.text, diff
+			if f.value == 1 {
+				whole++
+			}
+		}
+	}
+	if bestDiff > 0.04 {
+		return strconv.FormatFloat(math.Round(x*10)/10, 'f', -1, 64)
+	}
+	if whole == 0 && best != "" {
+		return best
+	}
+	return strconv.Itoa(int(whole)) + best
+}
+
+// convertUnits converts an amount between two units of the same kind, or
+// between Celsius and Fahrenheit.
+func convertUnits(amount float64, from, to string) (string, error) {
+	if f, t := temperatureUnit(from), temperatureUnit(to); f != "" || t != "" {
+		switch {
+		case f == "C" && t == "F":
+			return fmt.Sprintf("%s°C = %s°F", formatDegrees(amount), formatDegrees(amount*9/5+32)), nil
+		case f == "F" && t == "C":
+			return fmt.Sprintf("%s°F = %s°C", formatDegrees(amount), formatDegrees((amount-32)*5/9)), nil
+		case f == t:
+			return fmt.Sprintf("%s°%s", formatDegrees(amount), f), nil
+		}
+		return "", fmt.Errorf("can only convert temperatures between C and F")
+	}
+
+	fromUnit, ok := lookupUnit(from)
+	if !ok {
+		return "", fmt.Errorf("unknown unit %q", from)
+	}
+	toUnit, ok := lookupUnit(to)
+	if !ok {
+		return "", fmt.Errorf("unknown unit %q", to)
+	}
+	if fromUnit.Kind != toUnit.Kind {
+		return "", fmt.Errorf("converting %s to %s depends on the ingredient's density; weigh it or use a conversion chart for that ingredient", fromUnit.Kind, toUnit.Kind)
+	}
+	result := ingredient{Amount: amount * fromUnit.Size / toUnit.Size, Unit: &toUnit}
+	source := ingredient{Amount: amount, Unit: &fromUnit}
+	return fmt.Sprintf("%s = %s", source, result), nil
+}
+
+func temperatureUnit(s string) string {
+	s = strings.ToLower(strings.TrimLeftFunc(strings.TrimSpace(s), func(r rune) bool { return r == '°' || unicode.IsSpace(r) }))
+	switch s {
+	case "c", "celsius", "centigrade":
+		return "C"
+	case "f", "fahrenheit":
+		return "F"
+	}
+	return ""
+}
+
+func formatDegrees(x float64) string {
+	return strconv.Itoa(int(math.Round(x)))
+}
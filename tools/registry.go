@@ -1,8 +1,11 @@
 package tools
 
+import "context"
+
 // Registry holds all registered tools
 type Registry struct {
-	tools map[string]Tool
+	tools      map[string]Tool
+	middleware []Middleware
 }
 
 // NewRegistry creates a new tool registry
@@ -17,25 +20,44 @@ func (r *Registry) Register(tool Tool) {
 	r.tools[tool.Name()] = tool
 }
 
+// Use adds middleware that wraps every tool returned by the registry.
+// Middleware added first is outermost.
+func (r *Registry) Use(mw Middleware) {
+	r.middleware = append(r.middleware, mw)
+}
+
 // Get retrieves a tool by name
 func (r *Registry) Get(name string) (Tool, bool) {
 	tool, ok := r.tools[name]
-	return tool, ok
+	if !ok {
+		return nil, false
+	}
+	return r.wrap(tool), true
+}
+
+// Available reports whether the named tool is registered and offered for
+// this request, e.g. allowed for the user's role.
+func (r *Registry) Available(ctx context.Context, name string) bool {
+	tool, ok := r.Get(name)
+	return ok && isAvailable(ctx, tool)
 }
 
 // All returns all registered tools
 func (r *Registry) All() []Tool {
 	result := make([]Tool, 0, len(r.tools))
 	for _, tool := range r.tools {
-		result = append(result, tool)
+		result = append(result, r.wrap(tool))
 	}
 	return result
 }
 
-// ToOllamaFormat converts all tools to Ollama's expected format
-func (r *Registry) ToOllamaFormat() []map[string]any {
+// ToOllamaFormat converts the tools available for this request to Ollama's expected format
+func (r *Registry) ToOllamaFormat(ctx context.Context) []map[string]any {
 	result := make([]map[string]any, 0, len(r.tools))
-	for _, tool := range r.tools {
+	for _, tool := range r.All() {
+		if !isAvailable(ctx, tool) {
+			continue
+		}
 		result = append(result, map[string]any{
 			"type": "function",
 			"function": map[string]any{
@@ -47,3 +69,10 @@ func (r *Registry) ToOllamaFormat() []map[string]any {
 	}
 	return result
 }
+
+func (r *Registry) wrap(tool Tool) Tool {
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		tool = r.middleware[i](tool)
+	}
+	return tool
+}
@@ -0,0 +1,577 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/embed"
+	"telegram-bot/tenant"
+)
+
+const (
+	repoStoreKey     = "repos"
+	repoLogPrefix    = "[repo]"
+	reposDir         = "repos" // Clones go here in the workspace
+	repoCloneTimeout = 5 * time.Minute
+	repoAskPassages  = 6    // Passages the model answers from
+	maxPassageChars  = 4000 // Chars of each passage in the prompt
+	maxRepoListed    = 10   // Definitions and passages listed by find
+)
+
+var (
+	// repoURL accepts https and scp-style SSH remotes; local paths and
+	// file:// URLs could copy anything on the machine into the workspace.
+	repoURL     = regexp.MustCompile(`^(?:https://[\w.-]+(?::\d+)?/[\w.~/-]+|git@[\w.-]+:[\w.~/-]+)$`)
+	repoNameBad = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// repoInfo is a cloned repository.
+type repoInfo struct {
+	Name    string    `json:"name"` // Folder under repos/ in the workspace
+	URL     string    `json:"url"`
+	Commit  string    `json:"commit"`
+	Updated time.Time `json:"updated"`
+	Tenant  string    `json:"tenant,omitempty"` // Whose workspace it's in, with tenants
+}
+
+// key identifies the clone among every tenant's.
+func (repo repoInfo) key() string {
+	return repo.Tenant + "/" + repo.Name
+}
+
+type repoState struct {
+	Repos  []repoInfo       `json:"repos"`
+	Active map[int64]string `json:"active"` // Each chat's current repository
+}
+
+// RepoTool clones git repositories into the workspace and answers
+// questions about their code from an index of definitions and passages,
+// citing files and lines.
+type RepoTool struct {
+	scrape       *ScrapeTool
+	embed        *embed.Client // Nil searches by words only
+	workspaceDir string
+
+	host    *Host
+	mu      sync.Mutex
+	state   repoState
+	indexes map[string]*repoIndex // Built on first use after a restart
+}
+
+// RepoOption customizes a RepoTool.
+type RepoOption func(*RepoTool)
+
+// WithRepoEmbeddings makes searches match passages by meaning as well as
+// by words.
+func WithRepoEmbeddings(client *embed.Client) RepoOption {
+	return func(r *RepoTool) {
+		r.embed = client
+	}
+}
+
+// NewRepoTool creates a repository tool that clones into workspaceDir and
+// writes answers with the scrape tool's model.
+func NewRepoTool(scrape *ScrapeTool, workspaceDir string, opts ...RepoOption) *RepoTool {
+	r := &RepoTool{
+		scrape:       scrape,
+		workspaceDir: workspaceDir,
+		indexes:      make(map[string]*repoIndex),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *RepoTool) Name() string {
+	return "repo"
+}
+
+func (r *RepoTool) Description() string {
+	return `Answer questions about the code of a git repository, citing files and lines.
+
+operation=clone with url clones a repository (https or git@) into the workspace's repos/
+folder and indexes it; it becomes this chat's current repository, as with /repo <url>.
+operation=ask with question answers from the relevant code, e.g. "where is retry logic
+implemented?" or "how are config files loaded?". Keep the file:line citations it returns.
+operation=find with query lists definitions named like it and the passages that mention it.
+operation=list shows cloned repositories; use switches this chat to another (repo);
+update pulls the latest commit and re-indexes; remove deletes a clone.
+repo defaults to this chat's current repository.`
+}
+
+func (r *RepoTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"clone", "ask", "find", "list", "use", "update", "remove"},
+				"description": "What to do",
+			},
+			"url": map[string]any{
+				"type":        "string",
+				"description": "For clone: the repository URL, e.g. https://github.com/org/project",
+			},
+			"question": map[string]any{
+				"type":        "string",
+				"description": "For ask: the question about the code",
+			},
+			"query": map[string]any{
+				"type":        "string",
+				"description": "For find: a function, type, or word to look for",
+			},
+			"repo": map[string]any{
+				"type":        "string",
+				"description": "The repository's name, as listed (default: this chat's current one)",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (r *RepoTool) Metadata() Metadata {
+	return Metadata{Cost: CostHigh}
+}
+
+func (r *RepoTool) Start(host Host) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.host = &host
+	if _, err := host.Store.Get(repoStoreKey, &r.state); err != nil {
+		return fmt.Errorf("loading repositories: %w", err)
+	}
+	if r.state.Active == nil {
+		r.state.Active = make(map[int64]string)
+	}
+	log.Printf("%s %d repositories cloned", repoLogPrefix, len(r.state.Repos))
+	return nil
+}
+
+func (r *RepoTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if r.host == nil {
+		return "", fmt.Errorf("repositories are not available in this mode")
+	}
+	chatID, _ := ChatFrom(ctx)
+	operation, _ := args["operation"].(string)
+	name, _ := args["repo"].(string)
+	name = strings.TrimSpace(name)
+	var owner string
+	if t, ok := tenant.From(ctx); ok {
+		owner = t.ID
+	}
+
+	switch operation {
+	case "clone":
+		url, _ := args["url"].(string)
+		return r.clone(ctx, owner, chatID, strings.TrimSpace(url))
+	case "list":
+		return r.list(owner, chatID), nil
+	}
+
+	repo, err := r.repo(owner, chatID, name)
+	if err != nil {
+		return "", err
+	}
+	switch operation {
+	case "ask":
+		question, _ := args["question"].(string)
+		if strings.TrimSpace(question) == "" {
+			return "", fmt.Errorf("question is required for ask")
+		}
+		return r.ask(ctx, repo, question)
+	case "find":
+		query, _ := args["query"].(string)
+		if strings.TrimSpace(query) == "" {
+			return "", fmt.Errorf("query is required for find")
+		}
+		return r.find(ctx, repo, strings.TrimSpace(query))
+	case "use":
+		r.mu.Lock()
+		r.state.Active[chatID] = repo.Name
+		err := r.host.Store.Save(repoStoreKey, r.state)
+		r.mu.Unlock()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("📦 Questions about code now go to %s.", repo.Name), nil
+	case "update":
+		return r.update(ctx, repo)
+	case "remove":
+		return r.remove(repo)
+	}
+	return "", fmt.Errorf("unknown operation: %s", operation)
+}
+
+// repo finds one of the tenant's cloned repositories by name, or the
+// chat's current one.
+func (r *RepoTool) repo(owner string, chatID int64, name string) (repoInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repos := r.reposOf(owner)
+	if name == "" {
+		name = r.state.Active[chatID]
+	}
+	if name == "" {
+		if len(repos) == 1 {
+			return repos[0], nil
+		}
+		return repoInfo{}, fmt.Errorf("no repository chosen; clone one with /repo <url>, or pick one with operation=use")
+	}
+	for _, repo := range repos {
+		if strings.EqualFold(repo.Name, name) {
+			return repo, nil
+		}
+	}
+	return repoInfo{}, fmt.Errorf("no repository named %s; operation=list shows the cloned ones", name)
+}
+
+// reposOf returns the tenant's repositories. Must be called with r.mu held.
+func (r *RepoTool) reposOf(owner string) []repoInfo {
+	var repos []repoInfo
+	for _, repo := range r.state.Repos {
+		if repo.Tenant == owner {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+func (r *RepoTool) dir(repo repoInfo) string {
+	root := r.workspaceDir
+	if repo.Tenant != "" {
+		root = tenant.Dir(root, repo.Tenant)
+	}
+	return filepath.Join(root, reposDir, repo.Name)
+}
+
+func (r *RepoTool) clone(ctx context.Context, owner string, chatID int64, url string) (string, error) {
+	if strings.HasPrefix(url, "github.com/") || strings.HasPrefix(url, "gitlab.com/") {
+		url = "https://" + url
+	}
+	url = strings.TrimSuffix(url, "/")
+	if !repoURL.MatchString(url) || strings.Contains(url, "..") {
+		return "", fmt.Errorf("%q is not a repository URL; use https://host/org/project or git@host:org/project", url)
+	}
+	name := repoName(url)
+	if name == "" {
+		return "", fmt.Errorf("can't name a folder after %s", url)
+	}
+
+	repo := repoInfo{Name: name, URL: url, Tenant: owner}
+	r.mu.Lock()
+	i := slices.IndexFunc(r.state.Repos, func(other repoInfo) bool { return other.key() == repo.key() })
+	var existing repoInfo
+	if i >= 0 {
+		existing = r.state.Repos[i]
+	}
+	r.mu.Unlock()
+	if i >= 0 {
+		if existing.URL != url {
+			return "", fmt.Errorf("repos/%s is already a clone of %s; remove it first", name, existing.URL)
+		}
+		r.setActive(chatID, name)
+		return r.update(ctx, existing)
+	}
+
+	dir := r.dir(repo)
+	if _, err := os.Stat(dir); err == nil {
+		return "", fmt.Errorf("repos/%s already exists in the workspace", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", reposDir, err)
+	}
+	log.Printf("%s cloning %s", repoLogPrefix, url)
+	if _, err := runGit(ctx, "", "clone", "--depth", "1", "--quiet", "--", url, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("cloning %s: %w", url, err)
+	}
+
+	repo.Updated = time.Now()
+	repo.Commit, _ = runGit(ctx, dir, "rev-parse", "HEAD")
+	summary, err := r.reindex(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.state.Repos = append(r.state.Repos, repo)
+	r.state.Active[chatID] = name
+	err = r.host.Store.Save(repoStoreKey, r.state)
+	r.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("📦 Cloned %s into %s/%s at %s.\n%s\n\nAsk about its code, e.g. \"where is retry logic implemented?\"",
+		url, reposDir, name, shortCommit(repo.Commit), summary), nil
+}
+
+// repoName names a clone's folder after the last part of its URL.
+func repoName(url string) string {
+	base := url[strings.LastIndexAny(url, "/:")+1:]
+	return strings.Trim(repoNameBad.ReplaceAllString(strings.TrimSuffix(base, ".git"), "_"), "._")
+}
+
+// RepoFolder returns the workspace folder operation=clone puts a
+// repository in, relative to the workspace.
+func RepoFolder(url string) string {
+	return reposDir + "/" + repoName(strings.TrimSuffix(url, "/"))
+}
+
+func (r *RepoTool) setActive(chatID int64, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.Active[chatID] = name
+}
+
+// update fetches the latest commit of the clone's branch and re-indexes it.
+func (r *RepoTool) update(ctx context.Context, repo repoInfo) (string, error) {
+	dir := r.dir(repo)
+	if _, err := runGit(ctx, dir, "fetch", "--depth", "1", "--quiet", "origin"); err != nil {
+		return "", fmt.Errorf("fetching %s: %w", repo.Name, err)
+	}
+	if _, err := runGit(ctx, dir, "reset", "--hard", "--quiet", "FETCH_HEAD"); err != nil {
+		return "", fmt.Errorf("updating %s: %w", repo.Name, err)
+	}
+	commit, _ := runGit(ctx, dir, "rev-parse", "HEAD")
+	summary, err := r.reindex(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	for i := range r.state.Repos {
+		if r.state.Repos[i].key() == repo.key() {
+			r.state.Repos[i].Commit = commit
+			r.state.Repos[i].Updated = time.Now()
+		}
+	}
+	err = r.host.Store.Save(repoStoreKey, r.state)
+	r.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if commit == repo.Commit {
+		return fmt.Sprintf("📦 %s is up to date at %s.\n%s", repo.Name, shortCommit(commit), summary), nil
+	}
+	return fmt.Sprintf("📦 Updated %s from %s to %s.\n%s", repo.Name, shortCommit(repo.Commit), shortCommit(commit), summary), nil
+}
+
+func (r *RepoTool) remove(repo repoInfo) (string, error) {
+	if err := os.RemoveAll(r.dir(repo)); err != nil {
+		return "", fmt.Errorf("removing %s: %w", repo.Name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.Repos = slices.DeleteFunc(r.state.Repos, func(other repoInfo) bool { return other.key() == repo.key() })
+	for chat, name := range r.state.Active {
+		if name == repo.Name {
+			delete(r.state.Active, chat)
+		}
+	}
+	delete(r.indexes, repo.key())
+	if err := r.host.Store.Save(repoStoreKey, r.state); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("🗑 Removed %s.", repo.Name), nil
+}
+
+// reindex rebuilds a repository's index and describes it.
+func (r *RepoTool) reindex(ctx context.Context, repo repoInfo) (string, error) {
+	start := time.Now()
+	idx, err := buildRepoIndex(ctx, r.dir(repo), r.embed)
+	if err != nil {
+		return "", fmt.Errorf("indexing %s: %w", repo.Name, err)
+	}
+	r.mu.Lock()
+	r.indexes[repo.key()] = idx
+	r.mu.Unlock()
+	log.Printf("%s indexed %s: %d files, %d passages, %d definitions in %v",
+		repoLogPrefix, repo.Name, idx.files, len(idx.chunks), len(idx.symbols), time.Since(start).Round(time.Millisecond))
+
+	summary := fmt.Sprintf("Indexed %d files and %d definitions", idx.files, len(idx.symbols))
+	if idx.vectors != nil {
+		summary += ", searchable by meaning"
+	}
+	summary += "."
+	if idx.skipped > 0 {
+		summary += fmt.Sprintf(" %d large or excess files were left out.", idx.skipped)
+	}
+	return summary, nil
+}
+
+// index returns a repository's index, building it if the bot restarted
+// since it was cloned.
+func (r *RepoTool) index(ctx context.Context, repo repoInfo) (*repoIndex, error) {
+	r.mu.Lock()
+	idx, ok := r.indexes[repo.key()]
+	r.mu.Unlock()
+	if ok {
+		return idx, nil
+	}
+	if _, err := r.reindex(ctx, repo); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.indexes[repo.key()], nil
+}
+
+// ask answers a question from the passages that best match it, and lists
+// them as sources.
+func (r *RepoTool) ask(ctx context.Context, repo repoInfo, question string) (string, error) {
+	idx, err := r.index(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	hits, err := idx.search(ctx, r.embed, question, repoAskPassages)
+	if err != nil {
+		return "", err
+	}
+	if len(hits) == 0 {
+		return fmt.Sprintf("Nothing in %s matches that question. Try naming a function, file, or term from the code.", repo.Name), nil
+	}
+
+	var passages strings.Builder
+	for _, h := range hits {
+		fmt.Fprintf(&passages, "--- %s ---\n%s\n\n", chunkHeader(*h.chunk), truncateText(numberLines(h.chunk.Text, h.chunk.Start), maxPassageChars))
+	}
+	prompt := fmt.Sprintf(`Answer a question about the %s repository using only the code passages below. Each passage is headed by its file and line range, and each line starts with its line number.
+
+Cite the file and line for every point you make, as path:line or path:start-end. If the passages don't answer the question, say so and name the files that look closest.
+
+Question: %s
+
+%s
+Answer concisely, with citations:`, repo.Name, question, passages.String())
+
+	answer, err := r.scrape.generate(ctx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("answering: %w", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(answer)
+	b.WriteString("\n\nSources:")
+	for _, h := range hits {
+		b.WriteString("\n• " + r.cite(repo, h.chunk.Path, h.chunk.Start, h.chunk.End))
+	}
+	return b.String(), nil
+}
+
+// find lists definitions named like the query and passages that mention it.
+func (r *RepoTool) find(ctx context.Context, repo repoInfo, query string) (string, error) {
+	idx, err := r.index(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if symbols := idx.findSymbols(query, maxRepoListed); len(symbols) > 0 {
+		b.WriteString("Definitions:\n")
+		for _, s := range symbols {
+			kind := ""
+			if s.Kind != "" {
+				kind = " (" + s.Kind + ")"
+			}
+			fmt.Fprintf(&b, "• %s%s — %s\n", s.Name, kind, r.cite(repo, s.Path, s.Line, s.Line))
+		}
+	}
+
+	hits, err := idx.search(ctx, r.embed, query, maxRepoListed)
+	if err != nil {
+		return "", err
+	}
+	if len(hits) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("Passages:\n")
+		for _, h := range hits {
+			fmt.Fprintf(&b, "• %s\n", r.cite(repo, h.chunk.Path, h.chunk.Start, h.chunk.End))
+			if len(h.chunk.Symbols) > 0 {
+				fmt.Fprintf(&b, "  defines %s\n", strings.Join(h.chunk.Symbols[:min(len(h.chunk.Symbols), 6)], ", "))
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("Nothing in %s matches %q.", repo.Name, query), nil
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (r *RepoTool) list(owner string, chatID int64) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repos := r.reposOf(owner)
+	if len(repos) == 0 {
+		return "No repositories cloned yet. Clone one with /repo <url>."
+	}
+	var b strings.Builder
+	b.WriteString("📦 Repositories:\n")
+	for _, repo := range repos {
+		marker := ""
+		if r.state.Active[chatID] == repo.Name {
+			marker = " (current)"
+		}
+		fmt.Fprintf(&b, "\n• %s%s — %s at %s, updated %s", repo.Name, marker, repo.URL, shortCommit(repo.Commit), repo.Updated.Format("Jan 2"))
+	}
+	return b.String()
+}
+
+// cite formats a file and line range, with a link to it on GitHub.
+func (r *RepoTool) cite(repo repoInfo, path string, start, end int) string {
+	where := fmt.Sprintf("%s:%d", path, start)
+	anchor := fmt.Sprintf("#L%d", start)
+	if end > start {
+		where += fmt.Sprintf("-%d", end)
+		anchor += fmt.Sprintf("-L%d", end)
+	}
+	web := strings.TrimSuffix(repo.URL, ".git")
+	if after, ok := strings.CutPrefix(web, "git@github.com:"); ok {
+		web = "https://github.com/" + after
+	}
+	if !strings.HasPrefix(web, "https://github.com/") || repo.Commit == "" {
+		return where
+	}
+	return where + " " + web + "/blob/" + repo.Commit + "/" + path + anchor
+}
+
+// numberLines prefixes each line with its line number.
+func numberLines(text string, first int) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf("%d: %s", first+i, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func shortCommit(commit string) string {
+	return commit[:min(len(commit), 7)]
+}
+
+// runGit runs git without prompting for credentials, and returns its
+// trimmed output.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, repoCloneTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	isolate(ctx, cmd, "repo")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s", args[0], truncateText(strings.TrimSpace(string(out)), 300))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
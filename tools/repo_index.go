@@ -0,0 +1,429 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"telegram-bot/embed"
+)
+
+const (
+	repoChunkLines   = 60 // Lines per indexed passage
+	repoChunkOverlap = 15 // Lines each passage shares with the next
+	maxRepoFileBytes = 512 << 10
+	maxRepoFiles     = 5000
+	maxRepoChunks    = 8000 // Files beyond this many passages aren't indexed
+	maxEmbedChars    = 2000 // Chars of a passage that are embedded
+	repoSymbolWeight = 3.0  // Keyword score for a query word in a symbol name
+)
+
+// repoSkipDirs are folders of dependencies and build output, which answer
+// few questions about the repository's own code.
+var repoSkipDirs = map[string]bool{
+	"node_modules": true, "vendor": true, "third_party": true, "dist": true, "build": true,
+	"target": true, "__pycache__": true, "venv": true, "site-packages": true,
+}
+
+// repoSkipFiles are generated files that only add noise.
+var repoSkipFiles = map[string]bool{
+	"go.sum": true, "package-lock.json": true, "yarn.lock": true, "pnpm-lock.yaml": true,
+	"Cargo.lock": true, "poetry.lock": true, "Gemfile.lock": true, "composer.lock": true,
+}
+
+// symbolPatterns find definitions when ctags isn't installed, by file
+// extension. Each pattern's first group is the name.
+var symbolPatterns = map[string][]*regexp.Regexp{
+	".go": {
+		regexp.MustCompile(`^func (?:\([^)]*\)\s*)?(\w+)`),
+		regexp.MustCompile(`^type (\w+)`),
+	},
+	".py": {
+		regexp.MustCompile(`^\s*(?:async\s+)?def (\w+)`),
+		regexp.MustCompile(`^\s*class (\w+)`),
+	},
+	".js": jsSymbolPatterns, ".jsx": jsSymbolPatterns, ".ts": jsSymbolPatterns, ".tsx": jsSymbolPatterns, ".mjs": jsSymbolPatterns,
+	".rs": {
+		regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?fn (\w+)`),
+		regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:struct|enum|trait) (\w+)`),
+	},
+	".java": {
+		regexp.MustCompile(`^\s*(?:public |private |protected |abstract |final |static )*(?:class|interface|enum|record) (\w+)`),
+	},
+	".rb": {
+		regexp.MustCompile(`^\s*def (?:self\.)?(\w+[?!]?)`),
+		regexp.MustCompile(`^\s*(?:class|module) (\w+)`),
+	},
+}
+
+var jsSymbolPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\*?\s+(\w+)`),
+	regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class (\w+)`),
+	regexp.MustCompile(`^\s*(?:export\s+)?(?:const|let|var)\s+(\w+)\s*=\s*(?:async\s*)?(?:\([^)]*\)|\w+)\s*=>`),
+}
+
+// repoSymbol is a definition in the repository.
+type repoSymbol struct {
+	Name string
+	Kind string // function, type, class, ... when known
+	Path string
+	Line int
+}
+
+// repoChunk is a passage of a file: the unit that is searched and cited.
+type repoChunk struct {
+	Path    string
+	Start   int // First line, from 1
+	End     int // Last line
+	Text    string
+	Symbols []string // Names defined in the passage
+
+	terms       map[string]int  // Search terms and their counts
+	symbolTerms map[string]bool // Search terms in Symbols
+}
+
+// repoIndex is a repository's searchable form. It is rebuilt from the
+// clone when first needed; embeddings come from the embedding cache.
+type repoIndex struct {
+	files   int
+	chunks  []repoChunk
+	symbols []repoSymbol
+	df      map[string]int // Passages each term appears in
+	vectors [][]float32    // One per passage, if embeddings are enabled
+	skipped int            // Files left out for size or count limits
+}
+
+// repoHit is a passage that matches a search.
+type repoHit struct {
+	chunk *repoChunk
+	score float64
+}
+
+// buildRepoIndex reads the clone in dir into passages and symbols, and
+// embeds the passages if client is set.
+func buildRepoIndex(ctx context.Context, dir string, client *embed.Client) (*repoIndex, error) {
+	idx := &repoIndex{df: make(map[string]int)}
+	symbols, err := ctagsSymbols(ctx, dir)
+	useCtags := err == nil
+	if useCtags {
+		idx.symbols = symbols
+	}
+
+	var walkErr error
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(name, ".") || repoSkipDirs[name]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			walkErr = err
+			return filepath.SkipAll
+		}
+		if !d.Type().IsRegular() || repoSkipFiles[name] || strings.Contains(name, ".min.") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxRepoFileBytes || idx.files >= maxRepoFiles || len(idx.chunks) >= maxRepoChunks {
+			idx.skipped++
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(data, 0) >= 0 {
+			return nil // Binary
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		idx.files++
+		lines := strings.Split(string(data), "\n")
+		if !useCtags {
+			idx.symbols = append(idx.symbols, matchSymbols(rel, lines)...)
+		}
+		idx.chunks = append(idx.chunks, chunkLines(rel, lines)...)
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	// Name each passage's definitions, which search weighs more than its text
+	byPath := make(map[string][]repoSymbol)
+	for _, s := range idx.symbols {
+		byPath[s.Path] = append(byPath[s.Path], s)
+	}
+	for i := range idx.chunks {
+		c := &idx.chunks[i]
+		for _, s := range byPath[c.Path] {
+			if s.Line >= c.Start && s.Line <= c.End && !slices.Contains(c.Symbols, s.Name) {
+				c.Symbols = append(c.Symbols, s.Name)
+			}
+		}
+		c.terms = make(map[string]int)
+		for _, t := range searchTerms(c.Path + " " + c.Text) {
+			c.terms[t]++
+		}
+		c.symbolTerms = make(map[string]bool)
+		for _, t := range searchTerms(strings.Join(c.Symbols, " ")) {
+			c.symbolTerms[t] = true
+		}
+		for t := range c.terms {
+			idx.df[t]++
+		}
+	}
+
+	if client != nil && len(idx.chunks) > 0 {
+		texts := make([]string, len(idx.chunks))
+		for i, c := range idx.chunks {
+			texts[i] = truncateText(chunkHeader(c)+"\n"+c.Text, maxEmbedChars)
+		}
+		vectors, err := client.Embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("embedding passages: %w", err)
+		}
+		idx.vectors = vectors
+	}
+	return idx, nil
+}
+
+// chunkLines splits a file into overlapping passages.
+func chunkLines(path string, lines []string) []repoChunk {
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	var chunks []repoChunk
+	for start := 0; start < len(lines); start += repoChunkLines - repoChunkOverlap {
+		end := min(start+repoChunkLines, len(lines))
+		text := strings.Join(lines[start:end], "\n")
+		if strings.TrimSpace(text) != "" {
+			chunks = append(chunks, repoChunk{Path: path, Start: start + 1, End: end, Text: text})
+		}
+		if end == len(lines) {
+			break
+		}
+	}
+	return chunks
+}
+
+// ctagsSymbols lists definitions with Universal Ctags, which knows far
+// more languages than symbolPatterns.
+func ctagsSymbols(ctx context.Context, dir string) ([]repoSymbol, error) {
+	if !installed("ctags") {
+		return nil, fmt.Errorf("ctags is not installed")
+	}
+	args := []string{"-R", "--output-format=json", "--fields=+nK", "-f", "-"}
+	for name := range repoSkipDirs {
+		args = append(args, "--exclude="+name)
+	}
+	cmd := exec.CommandContext(ctx, "ctags", append(args, "--exclude=.*", ".")...)
+	cmd.Dir = dir
+	isolate(ctx, cmd, "repo")
+	out, err := cmd.Output()
+	if err != nil {
+		// Exuberant Ctags has no JSON output
+		return nil, fmt.Errorf("running ctags: %w", err)
+	}
+
+	var symbols []repoSymbol
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var tag struct {
+			Type string `json:"_type"`
+			Name string `json:"name"`
+			Path string `json:"path"`
+			Line int    `json:"line"`
+			Kind string `json:"kind"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &tag) != nil || tag.Type != "tag" || tag.Line == 0 {
+			continue
+		}
+		symbols = append(symbols, repoSymbol{
+			Name: tag.Name,
+			Kind: tag.Kind,
+			Path: strings.TrimPrefix(filepath.ToSlash(tag.Path), "./"),
+			Line: tag.Line,
+		})
+	}
+	return symbols, nil
+}
+
+// matchSymbols finds definitions in a file with symbolPatterns.
+func matchSymbols(path string, lines []string) []repoSymbol {
+	patterns := symbolPatterns[strings.ToLower(filepath.Ext(path))]
+	if patterns == nil {
+		return nil
+	}
+	var symbols []repoSymbol
+	for i, line := range lines {
+		for _, p := range patterns {
+			if m := p.FindStringSubmatch(line); m != nil {
+				symbols = append(symbols, repoSymbol{Name: m[1], Path: path, Line: i + 1})
+				break
+			}
+		}
+	}
+	return symbols
+}
+
+// search ranks passages by the query's words, weighted by how rare they
+// are, by words in the names they define, and by closeness in meaning
+// when embeddings are available. Overlapping passages of one file are
+// returned once.
+func (idx *repoIndex) search(ctx context.Context, client *embed.Client, query string, limit int) ([]repoHit, error) {
+	terms := searchTerms(query)
+	scores := make([]float64, len(idx.chunks))
+	n := float64(len(idx.chunks))
+	best := 0.0
+	for i := range idx.chunks {
+		c := &idx.chunks[i]
+		for _, t := range terms {
+			idf := math.Log(1 + n/float64(1+idx.df[t]))
+			if tf := c.terms[t]; tf > 0 {
+				scores[i] += (1 + math.Log(float64(tf))) * idf
+			}
+			if c.symbolTerms[t] {
+				scores[i] += repoSymbolWeight * idf
+			}
+		}
+		best = max(best, scores[i])
+	}
+
+	// Blend in meaning, with both parts scaled to 0-1
+	if client != nil && len(idx.vectors) == len(idx.chunks) {
+		vectors, err := client.Embed(ctx, []string{query})
+		if err != nil {
+			return nil, fmt.Errorf("embedding the question: %w", err)
+		}
+		for i := range scores {
+			if best > 0 {
+				scores[i] /= best
+			}
+			scores[i] = scores[i]/2 + embed.Cosine(vectors[0], idx.vectors[i])/2
+		}
+	}
+
+	order := make([]int, len(idx.chunks))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+
+	var hits []repoHit
+	for _, i := range order {
+		if len(hits) == limit || scores[i] <= 0 {
+			break
+		}
+		c := &idx.chunks[i]
+		overlaps := slices.ContainsFunc(hits, func(h repoHit) bool {
+			return h.chunk.Path == c.Path && h.chunk.Start <= c.End && c.Start <= h.chunk.End
+		})
+		if !overlaps {
+			hits = append(hits, repoHit{chunk: c, score: scores[i]})
+		}
+	}
+	return hits, nil
+}
+
+// findSymbols returns definitions whose names contain name, exact and
+// case-sensitive matches first.
+func (idx *repoIndex) findSymbols(name string, limit int) []repoSymbol {
+	lower := strings.ToLower(name)
+	var found []repoSymbol
+	for _, s := range idx.symbols {
+		if strings.Contains(strings.ToLower(s.Name), lower) {
+			found = append(found, s)
+		}
+	}
+	rank := func(s repoSymbol) int {
+		switch {
+		case s.Name == name:
+			return 0
+		case strings.EqualFold(s.Name, name):
+			return 1
+		}
+		return 2
+	}
+	slices.SortStableFunc(found, func(a, b repoSymbol) int {
+		return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(len(a.Name), len(b.Name)))
+	})
+	return found[:min(len(found), limit)]
+}
+
+// searchTerms splits text into lowercase words for search, breaking
+// identifiers like retryWithBackoff and max_retries into their parts and
+// trimming plurals and -ing and -ed endings, so "retries" finds "retry".
+func searchTerms(text string) []string {
+	var terms []string
+	add := func(word string) {
+		word = strings.ToLower(word)
+		if len(word) < 3 || searchStopwords[word] {
+			return
+		}
+		terms = append(terms, stem(word))
+	}
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		start := 0
+		runes := []rune(field)
+		for i := 1; i < len(runes); i++ {
+			if unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1]) {
+				add(string(runes[start:i]))
+				start = i
+			}
+		}
+		if start > 0 {
+			add(string(runes[start:]))
+		}
+		add(field)
+	}
+	return terms
+}
+
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		return word[:len(word)-3]
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+var searchStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "where": true, "what": true, "how": true, "does": true,
+	"this": true, "that": true, "with": true, "from": true, "which": true, "are": true, "is": true,
+	"implemented": true, "defined": true, "code": true, "handled": true, "happen": true, "happens": true,
+	"there": true, "who": true, "when": true, "why": true, "can": true, "used": true, "use": true,
+}
+
+// chunkHeader names a passage and what it defines.
+func chunkHeader(c repoChunk) string {
+	header := fmt.Sprintf("%s:%d-%d", c.Path, c.Start, c.End)
+	if len(c.Symbols) > 0 {
+		header += " (" + strings.Join(c.Symbols[:min(len(c.Symbols), 6)], ", ") + ")"
+	}
+	return header
+}
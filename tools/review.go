@@ -0,0 +1,360 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	maxReviewHunks    = 40      // Hunks beyond this many aren't reviewed
+	maxReviewHunkLen  = 8000    // Chars of a hunk sent to the model
+	maxPatchBytes     = 1 << 20 // Largest patch file read from the workspace
+	reviewParallelism = 4       // Hunks reviewed at once
+	reviewLogPrefix   = "[review]"
+)
+
+// reviewSeverities orders findings from most to least serious.
+var reviewSeverities = []string{"critical", "high", "medium", "low", "info"}
+
+var severityIcons = map[string]string{
+	"critical": "🟥", "high": "🔴", "medium": "🟠", "low": "🟡", "info": "🔵",
+}
+
+var reviewCategories = []string{"bug", "security", "style", "performance", "tests"}
+
+// reviewFinding is a problem the model found in a hunk.
+type reviewFinding struct {
+	File       string `json:"-"`
+	Line       int    `json:"line"`
+	Severity   string `json:"severity"`
+	Category   string `json:"category"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// ReviewTool reviews pasted or uploaded diffs hunk by hunk with the model,
+// after running the linters and tests of the workspace project the patch
+// is for against a patched copy.
+type ReviewTool struct {
+	scrape *ScrapeTool
+	python *PythonTool
+}
+
+// NewReviewTool creates a review tool that prompts the model through the
+// scrape tool and finds projects and runs checks in the python tool's
+// workspace.
+func NewReviewTool(scrape *ScrapeTool, python *PythonTool) *ReviewTool {
+	return &ReviewTool{scrape: scrape, python: python}
+}
+
+func (r *ReviewTool) Name() string {
+	return "review"
+}
+
+func (r *ReviewTool) Description() string {
+	return `Review a code change given as a unified diff (git diff, git format-patch, or diff -u).
+
+Each hunk is reviewed for bugs, security problems, and style, and findings come back with
+a severity (critical, high, medium, low, info), a file and line, and a suggested fix.
+Pass the diff text as diff, or a workspace file such as uploads/fix.patch as file.
+If the patch is for a project in the workspace (found automatically, or set project),
+it is applied to a copy of the project and its linters and tests are run too.
+focus says what to pay most attention to, e.g. "security" or "error handling".`
+}
+
+func (r *ReviewTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"diff": map[string]any{
+				"type":        "string",
+				"description": "The diff to review",
+			},
+			"file": map[string]any{
+				"type":        "string",
+				"description": "A .patch or .diff file in the workspace to review instead, e.g. uploads/fix.patch",
+			},
+			"project": map[string]any{
+				"type":        "string",
+				"description": "The workspace folder the patch is for, to run its linters and tests (default: found automatically)",
+			},
+			"focus": map[string]any{
+				"type":        "string",
+				"description": "What to pay most attention to, e.g. 'security' or 'is the locking right?'",
+			},
+		},
+	}
+}
+
+func (r *ReviewTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostHigh}
+}
+
+func (r *ReviewTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	r = &ReviewTool{scrape: r.scrape, python: r.python.forTenant(ctx)}
+	patch, _ := args["diff"].(string)
+	if name, _ := args["file"].(string); name != "" {
+		path, err := safePath(r.python.workspaceDir, name)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", name, err)
+		}
+		if info.Size() > maxPatchBytes {
+			return "", fmt.Errorf("%s is over %d MB; review it in parts", name, maxPatchBytes>>20)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", name, err)
+		}
+		patch = string(data)
+	}
+	if strings.TrimSpace(patch) == "" {
+		return "", fmt.Errorf("diff or file is required")
+	}
+	files, err := parseDiff(patch)
+	if err != nil {
+		return "", fmt.Errorf("reading the diff: %w", err)
+	}
+	focus, _ := args["focus"].(string)
+	project, _ := args["project"].(string)
+
+	var report strings.Builder
+	report.WriteString(diffStats(files))
+
+	// Checks run first so the model sees what the linters said about each hunk
+	var checks []reviewCheck
+	if project == "" {
+		project = r.findProject(ctx, patch, files)
+	}
+	if project != "" {
+		checks, err = r.checkPatch(ctx, project, patch, files)
+		switch {
+		case err != nil:
+			fmt.Fprintf(&report, "\n⚠️ Checks didn't run: %v\n", err)
+		case len(checks) == 0:
+			fmt.Fprintf(&report, "\nNo linters or tests found for these files in %s.\n", project)
+		default:
+			fmt.Fprintf(&report, "\nChecks on %s with the patch applied:\n", project)
+			for _, c := range checks {
+				report.WriteString(formatCheck(c))
+			}
+		}
+	}
+
+	findings, skipped, failed, err := r.reviewHunks(ctx, files, checks, strings.TrimSpace(focus))
+	if err != nil {
+		return "", err
+	}
+	report.WriteString("\n" + formatFindings(findings))
+	if skipped > 0 {
+		fmt.Fprintf(&report, "\n\n%d more hunks weren't reviewed; send the rest separately.", skipped)
+	}
+	if failed > 0 {
+		fmt.Fprintf(&report, "\n\n⚠️ %d hunks couldn't be reviewed.", failed)
+	}
+	return report.String(), nil
+}
+
+// reviewHunks reviews up to maxReviewHunks hunks concurrently. It fails only
+// if none of them could be reviewed.
+func (r *ReviewTool) reviewHunks(ctx context.Context, files []diffFile, checks []reviewCheck, focus string) (findings []reviewFinding, skipped, failed int, err error) {
+	type job struct {
+		file diffFile
+		hunk diffHunk
+	}
+	var jobs []job
+	for _, f := range files {
+		for _, h := range f.Hunks {
+			if len(jobs) == maxReviewHunks {
+				skipped++
+				continue
+			}
+			jobs = append(jobs, job{f, h})
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, skipped, 0, nil
+	}
+	log.Printf("%s reviewing %d hunks in %d files", reviewLogPrefix, len(jobs), len(files))
+
+	results := make([][]reviewFinding, len(jobs))
+	errs := make([]error, len(jobs))
+	sem := make(chan struct{}, reviewParallelism)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = r.reviewHunk(ctx, j.file, j.hunk, checkNotes(checks, j.file, j.hunk), focus)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("%s hunk %d of %s failed: %v", reviewLogPrefix, i+1, jobs[i].file.Path(), err)
+			failed++
+			continue
+		}
+		findings = append(findings, results[i]...)
+	}
+	if failed == len(jobs) {
+		return nil, 0, 0, fmt.Errorf("reviewing the diff: %w", firstError(errs))
+	}
+	slices.SortStableFunc(findings, func(a, b reviewFinding) int {
+		return slices.Index(reviewSeverities, a.Severity) - slices.Index(reviewSeverities, b.Severity)
+	})
+	return findings, skipped, failed, nil
+}
+
+func (r *ReviewTool) reviewHunk(ctx context.Context, file diffFile, hunk diffHunk, notes []string, focus string) ([]reviewFinding, error) {
+	name := cmp.Or(file.Path(), "(unnamed file)")
+	if hunk.Context != "" {
+		name += ", in " + hunk.Context
+	}
+	var linted string
+	if len(notes) > 0 {
+		linted = "\nLinters and tests reported these problems on the hunk's lines:\n" + strings.Join(notes, "\n") + "\n"
+	}
+	if focus != "" {
+		focus = "\nPay particular attention to this request: " + focus
+	}
+
+	prompt := fmt.Sprintf(`You are an experienced code reviewer. Review this hunk of a change to %s.
+Lines starting with + were added and lines starting with - were removed; the number before a line is its line number in the new file.
+
+%s%s
+Look for bugs, security problems, and style issues in the added lines, using the unchanged lines as context. Only report real problems, not matters of taste or code that was removed.%s
+
+Reply with only a JSON array, one object per problem, or [] if there are none:
+[{"line": <line number>, "severity": "critical|high|medium|low|info", "category": "bug|security|style|performance|tests", "message": "<what is wrong and why>", "suggestion": "<how to fix it>"}]`,
+		name, truncateText(hunk.numbered(), maxReviewHunkLen), linted, focus)
+
+	response, err := r.scrape.generate(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := parseFindings(response)
+	if err != nil {
+		return nil, err
+	}
+	for i := range findings {
+		f := &findings[i]
+		f.File = file.Path()
+		f.Severity = strings.ToLower(strings.TrimSpace(f.Severity))
+		if !slices.Contains(reviewSeverities, f.Severity) {
+			f.Severity = "low"
+		}
+		f.Category = strings.ToLower(strings.TrimSpace(f.Category))
+		if !slices.Contains(reviewCategories, f.Category) {
+			f.Category = "bug"
+		}
+		if !hunk.covers(f.Line) {
+			f.Line = hunk.NewStart
+		}
+	}
+	return findings, nil
+}
+
+// parseFindings reads the model's JSON array of findings, allowing for
+// prose or a code fence around it, or an object wrapping it.
+func parseFindings(response string) ([]reviewFinding, error) {
+	start, end := strings.Index(response, "["), strings.LastIndex(response, "]")
+	if start >= 0 && end > start {
+		var findings []reviewFinding
+		if err := json.Unmarshal([]byte(response[start:end+1]), &findings); err == nil {
+			return keepFindings(findings), nil
+		}
+	}
+	start, end = strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start >= 0 && end > start {
+		var wrapped struct {
+			Findings []reviewFinding `json:"findings"`
+		}
+		if err := json.Unmarshal([]byte(response[start:end+1]), &wrapped); err == nil {
+			return keepFindings(wrapped.Findings), nil
+		}
+	}
+	return nil, fmt.Errorf("the model didn't reply with findings: %s", truncateText(response, 200))
+}
+
+// keepFindings drops findings without a message.
+func keepFindings(findings []reviewFinding) []reviewFinding {
+	return slices.DeleteFunc(findings, func(f reviewFinding) bool {
+		return strings.TrimSpace(f.Message) == ""
+	})
+}
+
+// diffStats summarizes what a diff changes.
+func diffStats(files []diffFile) string {
+	hunks, added, removed := 0, 0, 0
+	for _, f := range files {
+		hunks += len(f.Hunks)
+		for _, h := range f.Hunks {
+			a, r := h.counts()
+			added += a
+			removed += r
+		}
+	}
+	plural := func(n int, word string) string {
+		if n == 1 {
+			return "1 " + word
+		}
+		return fmt.Sprintf("%d %ss", n, word)
+	}
+	return fmt.Sprintf("🔍 Reviewed %s, %s (+%d −%d).\n", plural(len(files), "file"), plural(hunks, "hunk"), added, removed)
+}
+
+func formatCheck(c reviewCheck) string {
+	if c.Passed {
+		return "✅ " + c.Name + "\n"
+	}
+	var b strings.Builder
+	b.WriteString("❌ " + c.Name + "\n")
+	for _, line := range strings.Split(truncateText(c.Output, maxCheckOutput), "\n") {
+		b.WriteString("   " + line + "\n")
+	}
+	return b.String()
+}
+
+func formatFindings(findings []reviewFinding) string {
+	if len(findings) == 0 {
+		return "No problems found."
+	}
+	counts := make(map[string]int)
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	var summary []string
+	for _, s := range reviewSeverities {
+		if counts[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("Findings: " + strings.Join(summary, ", "))
+	for _, f := range findings {
+		where := "line "
+		if f.File != "" {
+			where = f.File + ":"
+		}
+		fmt.Fprintf(&b, "\n\n%s %s %s — %s%d\n%s", severityIcons[f.Severity], strings.ToUpper(f.Severity), f.Category, where, f.Line, strings.TrimSpace(f.Message))
+		if s := strings.TrimSpace(f.Suggestion); s != "" {
+			b.WriteString("\nFix: " + s)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,271 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	reviewCheckTimeout = 2 * time.Minute
+	maxReviewCopyBytes = 200 << 20 // Larger projects aren't copied to be checked
+	maxCheckOutput     = 1500      // Chars of each check's output in the reply
+	uploadsDir         = "uploads" // Where the bot saves files users send; never a project
+)
+
+// checkLocation matches the "file:line:" that compilers, linters, and test
+// runners start their messages with.
+var checkLocation = regexp.MustCompile(`^\s*(?:\./)?([^\s:]+\.[A-Za-z]+):(\d+):(?:\d+:)?\s*(.*)$`)
+
+// reviewCheck is one linter or test run on the patched project.
+type reviewCheck struct {
+	Name   string
+	Passed bool
+	Output string
+}
+
+// findProject looks for the workspace folder a patch is for: the
+// workspace itself or a folder in it that has the files the patch changes
+// and that the patch applies to cleanly. It returns "" if none fits.
+func (r *ReviewTool) findProject(ctx context.Context, patch string, files []diffFile) string {
+	workspace := r.python.workspaceDir
+	candidates := []string{"."}
+	entries, _ := os.ReadDir(workspace)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() && !strings.HasPrefix(name, ".") && name != uploadsDir && name != "node_modules" && name != "__pycache__" {
+			candidates = append(candidates, name)
+		}
+	}
+	for _, c := range candidates {
+		dir := filepath.Join(workspace, c)
+		if hasChangedFiles(dir, files) && applyPatch(ctx, dir, patch, files, true) == nil {
+			return c
+		}
+	}
+	return ""
+}
+
+// hasChangedFiles reports whether dir has every file the patch modifies
+// or deletes. A patch that only adds files could apply anywhere, so it
+// matches nothing.
+func hasChangedFiles(dir string, files []diffFile) bool {
+	found := false
+	for _, f := range files {
+		if f.created() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.OldPath))); err != nil {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// applyPatch applies a patch in dir with git apply, or patch if git isn't
+// installed. With check set it only reports whether the patch applies.
+func applyPatch(ctx context.Context, dir, patch string, files []diffFile, check bool) error {
+	strip := 0
+	for _, f := range files {
+		if unsafeDiffPath(f.OldPath) || unsafeDiffPath(f.NewPath) {
+			return fmt.Errorf("the patch changes %s, outside the project", f.Path())
+		}
+		if f.Prefixed {
+			strip = 1
+		}
+	}
+
+	command, args := "git", []string{"apply", "-p" + strconv.Itoa(strip), "--whitespace=nowarn"}
+	if check {
+		args = append(args, "--check")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		command, args = "patch", []string{"-p" + strconv.Itoa(strip), "--batch", "--forward", "--silent"}
+		if check {
+			args = append(args, "--dry-run")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reviewCheckTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(patch)
+	// Apply relative to dir, not to a repository the workspace happens to be in
+	if abs, err := filepath.Abs(dir); err == nil {
+		cmd.Env = append(os.Environ(), "GIT_CEILING_DIRECTORIES="+filepath.Dir(abs))
+	}
+	isolate(ctx, cmd, "review")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", command, truncateText(strings.TrimSpace(string(out)), 500))
+	}
+	return nil
+}
+
+// checkPatch applies the patch to a copy of the project and runs the
+// linters and tests available for the languages it changes. The workspace
+// itself is left as it is.
+func (r *ReviewTool) checkPatch(ctx context.Context, project, patch string, files []diffFile) ([]reviewCheck, error) {
+	dir, err := safePath(r.python.workspaceDir, project)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("no folder %s in the workspace", project)
+	}
+
+	tmp, err := os.MkdirTemp("", "review-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := copyProject(dir, tmp); err != nil {
+		return nil, fmt.Errorf("copying %s: %w", project, err)
+	}
+	if err := applyPatch(ctx, tmp, patch, files, false); err != nil {
+		return nil, fmt.Errorf("the patch doesn't apply to %s: %w", project, err)
+	}
+
+	changed := make(map[string][]string) // Changed files by extension
+	for _, f := range files {
+		if f.NewPath != "/dev/null" && !f.Binary {
+			ext := filepath.Ext(f.NewPath)
+			changed[ext] = append(changed[ext], f.NewPath)
+		}
+	}
+
+	var checks []reviewCheck
+	run := func(name, command string, args ...string) {
+		check := runCheck(ctx, tmp, name, command, args...)
+		checks = append(checks, check)
+	}
+	if len(changed[".go"]) > 0 && installed("go") && exists(filepath.Join(tmp, "go.mod")) {
+		run("go vet", "go", "vet", "./...")
+		run("go test", "go", "test", "-count=1", "./...")
+	}
+	if py := changed[".py"]; len(py) > 0 {
+		if linter, args := pythonLinter(); linter != "" {
+			run(linter, linter, append(args, py...)...)
+		}
+		if _, hasTests, _ := projectLayout(tmp); hasTests {
+			check := runCheck(ctx, tmp, "pytest", r.python.interpreter(), "-m", "pytest", "-q", "--tb=short", "-p", "no:cacheprovider")
+			if !strings.Contains(check.Output, "No module named pytest") {
+				checks = append(checks, check)
+			}
+		}
+	}
+	if sh := append(changed[".sh"], changed[".bash"]...); len(sh) > 0 && installed("shellcheck") {
+		run("shellcheck", "shellcheck", append([]string{"--format=gcc"}, sh...)...)
+	}
+	return checks, nil
+}
+
+// runCheck runs a linter or test command in dir. Paths in its output are
+// made relative to dir, which is a temporary copy.
+func runCheck(ctx context.Context, dir, name, command string, args ...string) reviewCheck {
+	ctx, cancel := context.WithTimeout(ctx, reviewCheckTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "MPLBACKEND=Agg")
+	killTreeOnCancel(cmd)
+	isolate(ctx, cmd, "review")
+	out, err := cmd.CombinedOutput()
+
+	output := strings.ReplaceAll(strings.TrimSpace(string(out)), dir+string(filepath.Separator), "")
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		output += fmt.Sprintf("\n(timed out after %v)", reviewCheckTimeout)
+	}
+	log.Printf("%s %s: passed=%v", reviewLogPrefix, name, err == nil)
+	return reviewCheck{Name: name, Passed: err == nil, Output: output}
+}
+
+// checkNotes returns the lines of check output about lines in the hunk.
+func checkNotes(checks []reviewCheck, file diffFile, hunk diffHunk) []string {
+	var notes []string
+	for _, c := range checks {
+		for _, line := range strings.Split(c.Output, "\n") {
+			m := checkLocation.FindStringSubmatch(line)
+			if m == nil || !samePath(m[1], file.Path()) {
+				continue
+			}
+			if n, _ := strconv.Atoi(m[2]); hunk.covers(n) {
+				notes = append(notes, c.Name+": "+strings.TrimSpace(line))
+			}
+		}
+	}
+	return notes
+}
+
+// samePath reports whether two paths name the same file when one is
+// relative to a subfolder, as go test reports test files.
+func samePath(a, b string) bool {
+	a, b = filepath.ToSlash(filepath.Clean(a)), filepath.ToSlash(filepath.Clean(b))
+	return a == b || strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+// copyProject copies the regular files under src to dst, skipping the
+// folders walkWorkspace skips, such as .git, .venv, and node_modules.
+func copyProject(src, dst string) error {
+	var total int64
+	var copyErr error
+	walkWorkspace(src, func(path string, info os.FileInfo) {
+		if copyErr != nil {
+			return
+		}
+		if total += info.Size(); total > maxReviewCopyBytes {
+			copyErr = fmt.Errorf("the project is over %d MB", maxReviewCopyBytes>>20)
+			return
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			copyErr = err
+			return
+		}
+		copyErr = copyFile(path, filepath.Join(dst, rel), info.Mode().Perm())
+	})
+	return copyErr
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func installed(command string) bool {
+	_, err := exec.LookPath(command)
+	return err == nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,242 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotDiff = errors.New("no unified diff hunks found")
+
+	hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+)
+
+// diffFile is one file's changes in a unified diff.
+type diffFile struct {
+	OldPath  string // Without the a/ prefix; /dev/null for new files
+	NewPath  string // Without the b/ prefix; /dev/null for deleted files
+	Prefixed bool   // Paths had git's a/ and b/ prefixes
+	Binary   bool
+	Hunks    []diffHunk
+}
+
+// Path is the file's name after the change, or before it if deleted.
+func (f diffFile) Path() string {
+	if f.NewPath == "" || f.NewPath == "/dev/null" {
+		return f.OldPath
+	}
+	return f.NewPath
+}
+
+// created reports whether the diff adds the file.
+func (f diffFile) created() bool {
+	return f.OldPath == "/dev/null"
+}
+
+// diffHunk is one @@ section of a file's diff.
+type diffHunk struct {
+	Context  string // Function or section named after the @@ header
+	OldStart int
+	NewStart int
+	NewLines int
+	Lines    []string // With their " ", "+", or "-" prefix
+}
+
+// covers reports whether line in the new file falls within the hunk.
+func (h diffHunk) covers(line int) bool {
+	return line >= h.NewStart && line < h.NewStart+max(h.NewLines, 1)
+}
+
+// counts returns the number of added and removed lines.
+func (h diffHunk) counts() (added, removed int) {
+	for _, line := range h.Lines {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// numbered renders the hunk with each added and unchanged line's number in
+// the new file, so findings can refer to lines.
+func (h diffHunk) numbered() string {
+	var b strings.Builder
+	n := h.NewStart
+	for _, line := range h.Lines {
+		switch {
+		case strings.HasPrefix(line, "-"), strings.HasPrefix(line, `\`):
+			fmt.Fprintf(&b, "%6s %s\n", "", line)
+		default:
+			fmt.Fprintf(&b, "%6d %s\n", n, line)
+			n++
+		}
+	}
+	return b.String()
+}
+
+// parseDiff reads unified diffs as made by git diff, git format-patch, and
+// diff -u. Text around the diff, such as a commit message or the rest of
+// a chat message, is skipped, and a hunk pasted without file headers is
+// kept under an empty path.
+func parseDiff(text string) ([]diffFile, error) {
+	var files []diffFile
+	var file *diffFile
+	var oldLeft, newLeft int // Lines still expected in the current hunk
+
+	startFile := func() {
+		files = append(files, diffFile{})
+		file = &files[len(files)-1]
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if oldLeft > 0 || newLeft > 0 {
+			if hunkLine(line, &oldLeft, &newLeft) {
+				hunk := &file.Hunks[len(file.Hunks)-1]
+				hunk.Lines = append(hunk.Lines, line)
+				continue
+			}
+			// A truncated hunk; what follows isn't part of it
+			oldLeft, newLeft = 0, 0
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			startFile()
+			if a, b, ok := strings.Cut(strings.TrimPrefix(line, "diff --git "), " b/"); ok {
+				file.OldPath, file.NewPath, file.Prefixed = strings.TrimPrefix(a, "a/"), b, true
+			}
+		case strings.HasPrefix(line, "--- "):
+			// A new file, unless this follows a diff --git line that hasn't had hunks yet
+			if file == nil || len(file.Hunks) > 0 || file.OldPath != "" && !file.Prefixed {
+				startFile()
+			}
+			file.OldPath, file.Prefixed = diffPath(line[4:], "a/", file.Prefixed)
+		case strings.HasPrefix(line, "+++ ") && file != nil && len(file.Hunks) == 0:
+			file.NewPath, file.Prefixed = diffPath(line[4:], "b/", file.Prefixed)
+		case strings.HasPrefix(line, "new file mode") && file != nil:
+			file.OldPath = "/dev/null"
+		case strings.HasPrefix(line, "deleted file mode") && file != nil:
+			file.NewPath = "/dev/null"
+		case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+			if file != nil {
+				file.Binary = true
+			}
+		default:
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if file == nil {
+				startFile()
+			}
+			hunk := diffHunk{Context: strings.TrimSpace(m[5])}
+			hunk.OldStart, _ = strconv.Atoi(m[1])
+			hunk.NewStart, _ = strconv.Atoi(m[3])
+			oldLeft, newLeft = 1, 1
+			if m[2] != "" {
+				oldLeft, _ = strconv.Atoi(m[2])
+			}
+			if m[4] != "" {
+				newLeft, _ = strconv.Atoi(m[4])
+			}
+			hunk.NewLines = newLeft
+			file.Hunks = append(file.Hunks, hunk)
+		}
+	}
+
+	var found []diffFile
+	for _, f := range files {
+		if len(f.Hunks) > 0 || f.Binary {
+			found = append(found, f)
+		}
+	}
+	if len(found) == 0 {
+		return nil, errNotDiff
+	}
+	return found, nil
+}
+
+// hunkLine reports whether line belongs to a hunk, counting it against
+// the old and new lines the hunk still expects.
+func hunkLine(line string, oldLeft, newLeft *int) bool {
+	switch {
+	case strings.HasPrefix(line, "+"):
+		*newLeft--
+	case strings.HasPrefix(line, "-"):
+		*oldLeft--
+	case strings.HasPrefix(line, `\`):
+		// "\ No newline at end of file"
+	case strings.HasPrefix(line, " "), line == "":
+		// Chat apps and editors often strip the space from blank context lines
+		*oldLeft--
+		*newLeft--
+	default:
+		return false
+	}
+	return true
+}
+
+// diffPath cleans a path from a ---/+++ line: diff -u appends a timestamp
+// after a tab, and git prefixes a/ or b/.
+func diffPath(s, prefix string, prefixed bool) (string, bool) {
+	s, _, _ = strings.Cut(s, "\t")
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return s, prefixed
+	}
+	if strings.HasPrefix(s, prefix) {
+		return s[len(prefix):], true
+	}
+	return s, prefixed
+}
+
+// unsafeDiffPath reports whether a patch path could write outside the
+// directory it is applied in.
+func unsafeDiffPath(p string) bool {
+	if p == "" || p == "/dev/null" {
+		return false
+	}
+	if path.IsAbs(p) {
+		return true
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// LooksLikeDiff reports whether a message is mostly a unified diff, and
+// returns the text before it, which usually says what to look for.
+func LooksLikeDiff(text string) (intro string, ok bool) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	first, diffLines, other := -1, 0, 0
+	for i, line := range lines {
+		switch {
+		case hunkHeader.MatchString(line), strings.HasPrefix(line, "diff --git "),
+			strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+			if first < 0 {
+				first = i
+			}
+			diffLines++
+		case first >= 0 && (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, " ")):
+			diffLines++
+		case strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "```"):
+			other++
+		}
+	}
+	files, err := parseDiff(text)
+	if err != nil || len(files) == 0 || first < 0 || diffLines < other {
+		return "", false
+	}
+	intro = strings.Trim(strings.TrimSpace(strings.Join(lines[:first], "\n")), "`:")
+	return strings.TrimSpace(intro), true
+}
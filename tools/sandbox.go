@@ -0,0 +1,301 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	sandboxLogPrefix     = "[sandbox]"
+	defaultSandboxMemory = 128 // MiB
+	defaultSandboxTime   = 10 * time.Second
+	wasmPageSize         = 64 * 1024
+)
+
+// SandboxConfig configures the WebAssembly sandbox. Each interpreter is a
+// WASI build; either may be left empty.
+type SandboxConfig struct {
+	PythonWasm string // CPython compiled to WASI, e.g. python-3.12.0.wasm
+	PythonHome string // Its standard library prefix (containing lib/python3.x), mounted read-only at /usr/local
+	JSWasm     string // QuickJS compiled to WASI
+	MemoryMB   int    // Per run; defaultSandboxMemory if zero
+	Timeout    time.Duration
+	CacheDir   string // Compiled modules are kept here across restarts, if set
+}
+
+// sandboxLanguage is an interpreter the sandbox can run.
+type sandboxLanguage struct {
+	wasm   string
+	file   string   // The code is written to this file in the sandbox
+	args   []string // Command line, with the file last
+	module wazero.CompiledModule
+}
+
+// SandboxTool runs untrusted Python or JavaScript in a WebAssembly sandbox
+// compiled into the bot, with wazero. Code can't reach the network, the
+// host's files, or its processes: it sees a fresh, empty directory and gets
+// a fixed amount of memory and time.
+type SandboxTool struct {
+	cfg       SandboxConfig
+	languages map[string]*sandboxLanguage
+
+	mu      sync.Mutex // Guards compiling
+	runtime wazero.Runtime
+}
+
+// NewSandboxTool creates a sandbox with the configured interpreters.
+// Modules are compiled on first use.
+func NewSandboxTool(cfg SandboxConfig) *SandboxTool {
+	if cfg.MemoryMB <= 0 {
+		cfg.MemoryMB = defaultSandboxMemory
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultSandboxTime
+	}
+	s := &SandboxTool{cfg: cfg, languages: make(map[string]*sandboxLanguage)}
+	if cfg.PythonWasm != "" {
+		s.languages["python"] = &sandboxLanguage{wasm: cfg.PythonWasm, file: "main.py", args: []string{"python", "-B", "/main.py"}}
+	}
+	if cfg.JSWasm != "" {
+		s.languages["javascript"] = &sandboxLanguage{wasm: cfg.JSWasm, file: "main.js", args: []string{"qjs", "--std", "/main.js"}}
+	}
+	return s
+}
+
+func (s *SandboxTool) Name() string {
+	return "sandbox"
+}
+
+func (s *SandboxTool) Description() string {
+	return fmt.Sprintf(`Run a short %s program in an isolated WebAssembly sandbox.
+
+Use it for calculations, text processing, and trying out code. The program
+has no network, no access to the workspace or other files, and no packages
+beyond the standard library. It starts in an empty directory each time;
+files it writes there are sent back. Limits: %d MB of memory and %v.
+Print the results you want to see.`, strings.Join(s.languageNames(), " or "), s.cfg.MemoryMB, s.cfg.Timeout)
+}
+
+func (s *SandboxTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"language": map[string]any{
+				"type":        "string",
+				"enum":        s.languageNames(),
+				"description": "The program's language",
+			},
+			"code": map[string]any{
+				"type":        "string",
+				"description": "The program",
+			},
+			"stdin": map[string]any{
+				"type":        "string",
+				"description": "Input for the program to read from standard input",
+			},
+		},
+		"required": []string{"language", "code"},
+	}
+}
+
+func (s *SandboxTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+func (s *SandboxTool) BenchArgs() (map[string]any, string) {
+	if _, ok := s.languages["python"]; ok {
+		return map[string]any{"language": "python", "code": "print('bench-' + 'ok')"}, "bench-ok"
+	}
+	return map[string]any{"language": "javascript", "code": "print('bench-' + 'ok')"}, "bench-ok"
+}
+
+func (s *SandboxTool) languageNames() []string {
+	var names []string
+	for _, name := range []string{"python", "javascript"} {
+		if _, ok := s.languages[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *SandboxTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	result, err := s.ExecuteRich(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+// ExecuteRich runs the program, attaching the files it wrote.
+func (s *SandboxTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	language, _ := args["language"].(string)
+	code, _ := args["code"].(string)
+	stdin, _ := args["stdin"].(string)
+	lang, ok := s.languages[language]
+	if !ok {
+		return nil, fmt.Errorf("the sandbox can't run %q; it runs %s", language, strings.Join(s.languageNames(), " and "))
+	}
+	if strings.TrimSpace(code) == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+
+	module, err := s.compile(ctx, lang)
+	if err != nil {
+		return nil, Unavailable(err)
+	}
+
+	dir, err := os.MkdirTemp("", "sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("creating sandbox directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, lang.file), []byte(code), 0644); err != nil {
+		return nil, fmt.Errorf("writing program: %w", err)
+	}
+	before := snapshotWorkspace(dir)
+
+	fsConfig := wazero.NewFSConfig().WithDirMount(dir, "/")
+	if language == "python" && s.cfg.PythonHome != "" {
+		fsConfig = fsConfig.WithReadOnlyDirMount(s.cfg.PythonHome, "/usr/local")
+	}
+	stdout := &cappedBuffer{limit: maxOutputBytes}
+	stderr := &cappedBuffer{limit: maxOutputBytes}
+	config := wazero.NewModuleConfig().
+		WithName(""). // Anonymous, so runs don't collide
+		WithArgs(lang.args...).
+		WithEnv("HOME", "/").
+		WithStdin(strings.NewReader(stdin)).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+
+	runCtx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+	mod, err := s.runtime.InstantiateModule(runCtx, module, config)
+	if mod != nil {
+		mod.Close(context.Background())
+	}
+	log.Printf("%s ran %s (%d bytes) in %v", sandboxLogPrefix, language, len(code), time.Since(start).Round(time.Millisecond))
+
+	var exitErr *sys.ExitError
+	exitCode := 0
+	switch {
+	case err == nil:
+	case runCtx.Err() != nil && ctx.Err() == nil:
+		return &Result{Text: sandboxOutput(stdout, stderr) + fmt.Sprintf("\n\nStopped: the program ran longer than %v", s.cfg.Timeout)}, nil
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case errors.As(err, &exitErr):
+		exitCode = int(exitErr.ExitCode())
+	default:
+		// Traps, such as running out of memory
+		return &Result{Text: sandboxOutput(stdout, stderr) + fmt.Sprintf("\n\nThe program crashed: %v", err)}, nil
+	}
+
+	text := sandboxOutput(stdout, stderr)
+	if exitCode != 0 {
+		text += fmt.Sprintf("\n\nExit code: %d", exitCode)
+	}
+	return &Result{Text: strings.TrimSpace(text), Attachments: changedAttachments(dir, before)}, nil
+}
+
+// compile loads and compiles an interpreter the first time it's used,
+// which takes a few seconds for CPython, or is read back from the cache.
+func (s *SandboxTool) compile(ctx context.Context, lang *sandboxLanguage) (wazero.CompiledModule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lang.module != nil {
+		return lang.module, nil
+	}
+
+	if s.runtime == nil {
+		config := wazero.NewRuntimeConfig().
+			WithMemoryLimitPages(uint32(s.cfg.MemoryMB * 1024 * 1024 / wasmPageSize)).
+			WithCloseOnContextDone(true)
+		if s.cfg.CacheDir != "" {
+			if cache, err := wazero.NewCompilationCacheWithDir(s.cfg.CacheDir); err != nil {
+				log.Printf("%s compilation cache: %v", sandboxLogPrefix, err)
+			} else {
+				config = config.WithCompilationCache(cache)
+			}
+		}
+		// Not tied to the request: the runtime outlives it
+		runtime := wazero.NewRuntimeWithConfig(context.Background(), config)
+		if _, err := wasi_snapshot_preview1.Instantiate(context.Background(), runtime); err != nil {
+			runtime.Close(context.Background())
+			return nil, fmt.Errorf("setting up WASI: %w", err)
+		}
+		s.runtime = runtime
+	}
+
+	wasm, err := os.ReadFile(lang.wasm)
+	if err != nil {
+		return nil, fmt.Errorf("reading interpreter: %w", err)
+	}
+	start := time.Now()
+	module, err := s.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, fmt.Errorf("compiling %s: %w", filepath.Base(lang.wasm), err)
+	}
+	log.Printf("%s compiled %s in %v", sandboxLogPrefix, filepath.Base(lang.wasm), time.Since(start).Round(time.Millisecond))
+	lang.module = module
+	return module, nil
+}
+
+func sandboxOutput(stdout, stderr *cappedBuffer) string {
+	var b strings.Builder
+	b.WriteString(stdout.String())
+	if stderr.Len() > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("STDERR:\n" + stderr.String())
+	}
+	if b.Len() == 0 {
+		return "(no output)"
+	}
+	return b.String()
+}
+
+// cappedBuffer keeps the first limit bytes written to it and drops the
+// rest, so a program printing in a loop can't exhaust the bot's memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.limit - c.Buffer.Len(); room < len(p) {
+		c.Buffer.Write(p[:max(room, 0)])
+		c.truncated = true
+		return len(p), nil
+	}
+	return c.Buffer.Write(p)
+}
+
+func (c *cappedBuffer) String() string {
+	if c.truncated {
+		return c.Buffer.String() + "\n... (output truncated)"
+	}
+	return c.Buffer.String()
+}
@@ -1,42 +1,61 @@
 package tools
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/html"
+
+	"telegram-bot/quota"
 )
 
 const (
-	scrapeTimeout  = 30 * time.Second
-	maxContentLen  = 50000 // Max chars to send to summarizer
+	scrapeTimeout   = 30 * time.Second
+	maxContentLen   = 50000 // Max chars to send to summarizer
 	scrapeLogPrefix = "[scrape]"
 )
 
+// Completer answers a single prompt with the bot's chat model, whichever
+// provider serves it. *agent.Agent implements it.
+type Completer interface {
+	Complete(ctx context.Context, prompt string) (string, error)
+}
+
 // ScrapeTool fetches web pages, extracts main content, and summarizes them.
 type ScrapeTool struct {
-	ollamaURL   string
-	ollamaModel string
-	httpClient  *http.Client
-}
-
-// NewScrapeTool creates a new scrape tool.
-func NewScrapeTool(ollamaURL, ollamaModel string) *ScrapeTool {
-	return &ScrapeTool{
-		ollamaURL:   ollamaURL,
-		ollamaModel: ollamaModel,
-		httpClient: &http.Client{
-			Timeout: scrapeTimeout,
-		},
+	llm        Completer
+	httpClient *http.Client
+	siteAuth   map[string]SiteAuth // Credentials for configured private sites, by host
+
+	workspaceDir string // Where captured pages are saved; empty disables saving
+	browser      string // Headless browser for screenshots; empty searches the PATH
+
+	host          *Host // Set by Start; nil when background work is unavailable
+	watchInterval time.Duration
+	watchMu       sync.Mutex
+	watches       pageWatchState
+}
+
+// NewScrapeTool creates a new scrape tool that summarizes with llm. The
+// tools built on it (reading list, review, repo, recipes, and GitHub) use
+// it for their prompts too.
+func NewScrapeTool(llm Completer, opts ...ScrapeOption) *ScrapeTool {
+	s := &ScrapeTool{llm: llm}
+	s.httpClient = &http.Client{
+		Timeout:       scrapeTimeout,
+		CheckRedirect: s.checkRedirect,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 func (s *ScrapeTool) Name() string {
@@ -49,7 +68,21 @@ func (s *ScrapeTool) Description() string {
 Input: A URL
 Output: A concise summary of the main topics/ideas on the page
 
-Use this to quickly understand what a webpage is about without reading the whole thing.`
+Use this to quickly understand what a webpage is about without reading the whole thing.
+Match the summary to the request: style=detailed for "explain this in depth",
+key-quotes for "what does it actually say about X", eli5 for "explain simply",
+instructions for a specific focus, and language when the user wants a translation.
+
+Set crawl=true to summarize a whole site or documentation section ("summarize this
+project's docs"): pages under the URL on the same site are read from sitemap.xml or
+by following links, bounded by max_pages and max_depth.
+
+Set save_html=true to archive the page's HTML in the workspace, or screenshot=true
+for a picture of the rendered page, e.g. for visual checks.
+
+Set operation=watch to re-check the page periodically and message this chat with a
+summary of what changed (prices, changelogs, status pages). operation=watches lists
+this chat's watched pages; operation=unwatch with watch_id stops one.`
 }
 
 func (s *ScrapeTool) Parameters() map[string]any {
@@ -60,72 +93,177 @@ func (s *ScrapeTool) Parameters() map[string]any {
 				"type":        "string",
 				"description": "The URL of the webpage to scrape and summarize",
 			},
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"summarize", "watch", "watches", "unwatch"},
+				"description": "summarize the page (default), watch it for changes, list watched pages, or unwatch",
+			},
+			"watch_id": map[string]any{
+				"type":        "number",
+				"description": "For unwatch: the watch number",
+			},
+			"style": map[string]any{
+				"type":        "string",
+				"enum":        []string{"brief", "detailed", "key-quotes", "eli5"},
+				"description": "How to summarize: brief bullets (default), detailed, key-quotes (verbatim passages), or eli5 (plain language)",
+			},
+			"instructions": map[string]any{
+				"type":        "string",
+				"description": "What the user wants from the page, e.g. 'only the pricing' or 'what changed in v2'",
+			},
+			"language": map[string]any{
+				"type":        "string",
+				"description": "Language to write the summary in, e.g. German (default: the page's language or English)",
+			},
+			"save_html": map[string]any{
+				"type":        "boolean",
+				"description": "Also save the page's HTML (scripts removed) to the workspace and send it as a file",
+			},
+			"screenshot": map[string]any{
+				"type":        "boolean",
+				"description": "Also send a screenshot of the page as a photo (needs a headless browser)",
+			},
+			"crawl": map[string]any{
+				"type":        "boolean",
+				"description": "Summarize the whole site under this URL instead of one page (reads sitemap.xml or follows links)",
+			},
+			"max_pages": map[string]any{
+				"type":        "integer",
+				"description": "For crawl: maximum pages to read (default 10, max 30)",
+			},
+			"max_depth": map[string]any{
+				"type":        "integer",
+				"description": "For crawl: how many links deep to follow from the start page (default 2, max 3)",
+			},
 		},
-		"required": []string{"url"},
+		"required": []string{},
 	}
 }
 
+func (s *ScrapeTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostMedium}
+}
+
+func (s *ScrapeTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{"url": "https://example.com"}, ""
+}
+
 func (s *ScrapeTool) Execute(ctx context.Context, args map[string]any) (string, error) {
-	url, ok := args["url"].(string)
-	if !ok || url == "" {
-		return "", fmt.Errorf("url is required")
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "watches":
+		return s.listWatches(ctx)
+	case "unwatch":
+		return s.unwatch(ctx, args)
+	case "", "summarize", "watch":
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
 	}
 
-	// Ensure URL has scheme
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		url = "https://" + url
+	url, err := urlArg(args)
+	if err != nil {
+		return "", err
 	}
 
-	log.Printf("%s fetching %s", scrapeLogPrefix, url)
-
-	// Fetch the page
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("creating request: %w", err)
+	if operation == "watch" {
+		return s.watch(ctx, url)
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; telegram-bot/1.0)")
 
-	resp, err := s.httpClient.Do(req)
+	opts, err := summaryOptionsFrom(args)
 	if err != nil {
-		return "", fmt.Errorf("fetching URL: %w", err)
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	if crawl, _ := args["crawl"].(bool); crawl {
+		return s.crawl(ctx, url, args, opts)
 	}
 
-	// Read body
-	body, err := io.ReadAll(resp.Body)
+	body, _, err := s.fetch(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("reading response: %w", err)
+		return "", err
 	}
+	return s.summarizeBody(ctx, body, url, opts), nil
+}
 
-	log.Printf("%s fetched %d bytes", scrapeLogPrefix, len(body))
+// urlArg returns the url argument, adding https:// if no scheme was given.
+func urlArg(args map[string]any) (string, error) {
+	url, ok := args["url"].(string)
+	if !ok || url == "" {
+		return "", fmt.Errorf("url is required")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "https://" + url
+	}
+	return url, nil
+}
 
-	// Extract text content
+// summarizeBody extracts a fetched page's text and summarizes it, falling
+// back to the text itself if the summarizer fails.
+func (s *ScrapeTool) summarizeBody(ctx context.Context, body []byte, url string, opts summaryOptions) string {
 	text := s.extractText(string(body))
 	if text == "" {
-		return "Could not extract text content from the page.", nil
+		return "Could not extract text content from the page."
 	}
 
 	log.Printf("%s extracted %d chars of text", scrapeLogPrefix, len(text))
 
-	// Truncate if too long
+	// Summarize with the model, in parts if the page is too long for one prompt
+	var summary string
+	var err error
 	if len(text) > maxContentLen {
-		text = text[:maxContentLen] + "..."
+		summary, err = s.summarizeLong(ctx, text, url, opts)
+	} else {
+		summary, err = s.summarize(ctx, text, url, opts)
 	}
-
-	// Summarize using Ollama
-	summary, err := s.summarize(ctx, text, url)
 	if err != nil {
 		log.Printf("%s summarization failed: %v", scrapeLogPrefix, err)
 		// Return extracted text if summarization fails
-		return fmt.Sprintf("Failed to summarize, here's the extracted text:\n\n%s", truncateText(text, 2000)), nil
+		return fmt.Sprintf("Failed to summarize, here's the extracted text:\n\n%s", truncateText(text, 2000))
 	}
 
 	log.Printf("%s summary: %s", scrapeLogPrefix, truncateText(summary, 100))
-	return summary, nil
+	return summary
+}
+
+// fetch downloads a page, returning its body and the URL it was finally
+// served from after redirects.
+func (s *ScrapeTool) fetch(ctx context.Context, url string) ([]byte, string, error) {
+	log.Printf("%s fetching %s", scrapeLogPrefix, url)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; telegram-bot/1.0)")
+	s.applyAuth(req, nil)
+
+	_, authenticated := s.authFor(req.URL.Hostname())
+	authenticated = authenticated && authAllowed(req) && req.URL.Scheme == "https"
+	if authenticated {
+		log.Printf("%s using configured credentials for %s", scrapeLogPrefix, req.URL.Hostname())
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("fetching URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if authenticated && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return nil, "", fmt.Errorf("HTTP %d: %s (the configured credentials for %s were rejected)", resp.StatusCode, resp.Status, req.URL.Hostname())
+		}
+		return nil, "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading response: %w", err)
+	}
+
+	log.Printf("%s fetched %d bytes", scrapeLogPrefix, len(body))
+	quota.RecordScrapeBytes(ctx, int64(len(body)))
+	return body, resp.Request.URL.String(), nil
 }
 
 func (s *ScrapeTool) extractText(htmlContent string) string {
@@ -176,59 +314,69 @@ func (s *ScrapeTool) stripTags(html string) string {
 	return strings.TrimSpace(text)
 }
 
-func (s *ScrapeTool) summarize(ctx context.Context, text, url string) (string, error) {
-	prompt := fmt.Sprintf(`Summarize the main topics and ideas from this webpage in 2-3 concise bullet points.
-
-URL: %s
-
-Content:
-%s
+// summaryStyles are the ways a summary can be written, keyed by the style parameter.
+var summaryStyles = map[string]string{
+	"brief":      "Summarize the main topics and ideas in 2-3 concise bullet points.",
+	"detailed":   "Write a detailed summary: a short overview, then the main points as bullets with their supporting details, facts, and figures.",
+	"key-quotes": "List the 3-5 most important passages, quoted verbatim, each followed by a one-line note on why it matters.",
+	"eli5":       "Explain what this is about in a short paragraph of plain language that someone new to the topic would understand, without jargon.",
+}
 
-Provide only the summary, no preamble:`, url, text)
+// summaryOptions tailor a summary to what the user asked for.
+type summaryOptions struct {
+	style        string // A key of summaryStyles; empty uses the default for the content
+	instructions string // Free-form focus, e.g. "only the pricing"
+	language     string // Language to write in; empty keeps the model's default
+}
 
-	reqBody := map[string]any{
-		"model":  s.ollamaModel,
-		"prompt": prompt,
-		"stream": false,
+func summaryOptionsFrom(args map[string]any) (summaryOptions, error) {
+	var opts summaryOptions
+	opts.style, _ = args["style"].(string)
+	opts.instructions, _ = args["instructions"].(string)
+	opts.language, _ = args["language"].(string)
+	if _, ok := summaryStyles[opts.style]; opts.style != "" && !ok {
+		return opts, fmt.Errorf("unknown style %q (use brief, detailed, key-quotes, or eli5)", opts.style)
 	}
+	return opts, nil
+}
 
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("marshaling request: %w", err)
+// directive returns the instructions for the summarizer, using fallback
+// when no style was requested.
+func (o summaryOptions) directive(fallback string) string {
+	d := fallback
+	if o.style != "" {
+		d = summaryStyles[o.style]
 	}
-
-	// Use generate endpoint for simple completion
-	generateURL := strings.Replace(s.ollamaURL, "/api/chat", "/api/generate", 1)
-	
-	req, err := http.NewRequestWithContext(ctx, "POST", generateURL, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", fmt.Errorf("creating request: %w", err)
+	if o.instructions != "" {
+		d += "\nThe user asked specifically: " + o.instructions
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("calling Ollama: %w", err)
+	if o.language != "" {
+		d += "\nWrite the summary in " + o.language + "."
 	}
-	defer resp.Body.Close()
+	return d
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("reading response: %w", err)
-	}
+func (s *ScrapeTool) summarize(ctx context.Context, text, url string, opts summaryOptions) (string, error) {
+	prompt := fmt.Sprintf(`%s
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Ollama error %d: %s", resp.StatusCode, string(body))
-	}
+URL: %s
 
-	var result struct {
-		Response string `json:"response"`
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("parsing response: %w", err)
-	}
+Content:
+%s
+
+Provide only the summary, no preamble:`, opts.directive(summaryStyles["brief"]), url, text)
 
-	return strings.TrimSpace(result.Response), nil
+	return s.generate(ctx, prompt)
+}
+
+// generate sends a single prompt to the model and returns the completion.
+func (s *ScrapeTool) generate(ctx context.Context, prompt string) (string, error) {
+	answer, err := s.llm.Complete(ctx, prompt)
+	if err != nil && ctx.Err() == nil {
+		// The summarizer is down, whatever the page
+		return "", Unavailable(err)
+	}
+	return answer, err
 }
 
 func truncateText(s string, maxLen int) string {
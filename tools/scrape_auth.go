@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"telegram-bot/auth"
+	"telegram-bot/redact"
+)
+
+// SiteAuth holds the credentials sent to one site. It comes from the
+// operator's configuration and is never influenced by the model.
+type SiteAuth struct {
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+}
+
+// LoadSiteAuth reads per-site credentials from a JSON file mapping hosts to
+// headers and cookies. A host of "*.example.com" also matches subdomains.
+// Values may reference environment variables ($WIKI_TOKEN) so secrets can
+// stay out of the file. Credentials are registered with the redactor.
+func LoadSiteAuth(path string) (map[string]SiteAuth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading site auth: %w", err)
+	}
+
+	var sites map[string]SiteAuth
+	if err := json.Unmarshal(data, &sites); err != nil {
+		return nil, fmt.Errorf("parsing site auth: %w", err)
+	}
+
+	result := make(map[string]SiteAuth, len(sites))
+	for host, site := range sites {
+		for name, value := range site.Headers {
+			site.Headers[name] = os.ExpandEnv(value)
+			redact.RegisterHeader(name, site.Headers[name])
+		}
+		for name, value := range site.Cookies {
+			site.Cookies[name] = os.ExpandEnv(value)
+			redact.Register(site.Cookies[name])
+		}
+		result[strings.ToLower(host)] = site
+	}
+	return result, nil
+}
+
+// ScrapeOption configures a ScrapeTool.
+type ScrapeOption func(*ScrapeTool)
+
+// WithSiteAuth sends the configured headers and cookies to matching sites
+// when a trusted user asks. Guests and every other site are fetched
+// anonymously.
+func WithSiteAuth(sites map[string]SiteAuth) ScrapeOption {
+	return func(s *ScrapeTool) {
+		s.siteAuth = sites
+	}
+}
+
+// authFor returns the credentials configured for host, if any. An exact
+// match wins over a wildcard.
+func (s *ScrapeTool) authFor(host string) (SiteAuth, bool) {
+	host = strings.ToLower(host)
+	if site, ok := s.siteAuth[host]; ok {
+		return site, true
+	}
+	for pattern, site := range s.siteAuth {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(host, "."+suffix) {
+			return site, true
+		}
+	}
+	return SiteAuth{}, false
+}
+
+// authAllowed reports whether the request may carry the operator's site
+// credentials. Scrape is open to guests, who mustn't read the private pages
+// the credentials unlock.
+func authAllowed(req *http.Request) bool {
+	return auth.RoleFrom(req.Context()) >= auth.Trusted
+}
+
+// applyAuth sets the credentials for the request's host, if the requesting
+// user may use them. Any credentials left from an earlier hop of a redirect
+// are removed first, so a redirect to another site never carries them along.
+func (s *ScrapeTool) applyAuth(req *http.Request, previous *http.Request) {
+	if previous != nil {
+		if old, ok := s.authFor(previous.URL.Hostname()); ok {
+			for name := range old.Headers {
+				req.Header.Del(name)
+			}
+			if len(old.Cookies) > 0 {
+				req.Header.Del("Cookie")
+			}
+		}
+	}
+
+	if !authAllowed(req) {
+		return
+	}
+	site, ok := s.authFor(req.URL.Hostname())
+	if !ok {
+		return
+	}
+	if req.URL.Scheme != "https" {
+		return // Never send credentials in the clear
+	}
+	for name, value := range site.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, value := range site.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+}
+
+// checkRedirect re-applies site credentials on every redirect hop.
+func (s *ScrapeTool) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	s.applyAuth(req, via[len(via)-1])
+	return nil
+}
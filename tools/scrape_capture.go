@@ -0,0 +1,280 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"telegram-bot/auth"
+	"telegram-bot/tenant"
+)
+
+const (
+	capturesDir       = "scrapes" // Under the workspace
+	screenshotTimeout = 60 * time.Second
+	screenshotWidth   = 1280
+	screenshotHeight  = 3000 // Tall enough to show most pages in full
+)
+
+// Browsers tried for screenshots, in order, when none is configured.
+var browserCandidates = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "headless-shell"}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// WithWorkspace lets the scrape tool save captured pages to the workspace.
+func WithWorkspace(dir string) ScrapeOption {
+	return func(s *ScrapeTool) {
+		s.workspaceDir = dir
+	}
+}
+
+// WithBrowser sets the headless browser used for screenshots. By default
+// the first Chromium-based browser found on the PATH is used.
+func WithBrowser(path string) ScrapeOption {
+	return func(s *ScrapeTool) {
+		s.browser = path
+	}
+}
+
+// ExecuteRich summarizes the page like Execute and, when asked, also
+// saves its HTML to the workspace and captures a screenshot.
+func (s *ScrapeTool) ExecuteRich(ctx context.Context, args map[string]any) (*Result, error) {
+	saveHTML, _ := args["save_html"].(bool)
+	screenshot, _ := args["screenshot"].(bool)
+	operation, _ := args["operation"].(string)
+	crawl, _ := args["crawl"].(bool)
+	if (!saveHTML && !screenshot) || crawl || (operation != "" && operation != "summarize") {
+		text, err := s.Execute(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Text: text}, nil
+	}
+
+	// Captures write files and start a browser, so they need more than guest access
+	if auth.RoleFrom(ctx) < auth.Trusted {
+		return nil, fmt.Errorf("only trusted users can save pages or take screenshots")
+	}
+
+	pageURL, err := urlArg(args)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := summaryOptionsFrom(args)
+	if err != nil {
+		return nil, err
+	}
+
+	body, finalURL, err := s.fetch(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Text: s.summarizeBody(ctx, body, pageURL, opts)}
+	name := captureName(finalURL)
+
+	if saveHTML {
+		if note, att, err := s.saveHTML(ctx, body, finalURL, name+".html"); err != nil {
+			result.Text += "\n\n⚠️ Could not save HTML: " + err.Error()
+		} else {
+			result.Text += "\n\n" + note
+			result.Attachments = append(result.Attachments, att)
+		}
+	}
+	if screenshot {
+		if note, att, err := s.screenshot(ctx, finalURL, name+".png"); err != nil {
+			result.Text += "\n\n⚠️ Could not take a screenshot: " + err.Error()
+		} else {
+			result.Text += "\n\n" + note
+			result.Attachments = append(result.Attachments, att)
+		}
+	}
+	return result, nil
+}
+
+// captureName builds a file name from the page's host and path and the time.
+func captureName(pageURL string) string {
+	name := pageURL
+	if u, err := url.Parse(pageURL); err == nil {
+		name = u.Host + u.Path
+	}
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_.")
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return name + "-" + time.Now().Format("20060102-150405")
+}
+
+// saveHTML stores a sanitized copy of the page in the workspace.
+func (s *ScrapeTool) saveHTML(ctx context.Context, body []byte, pageURL, name string) (string, Attachment, error) {
+	if s.workspaceDir == "" {
+		return "", Attachment{}, fmt.Errorf("no workspace configured")
+	}
+	clean, err := sanitizeHTML(body, pageURL)
+	if err != nil {
+		return "", Attachment{}, err
+	}
+
+	path, err := s.capturePath(ctx, name)
+	if err != nil {
+		return "", Attachment{}, err
+	}
+	if err := os.WriteFile(path, clean, 0644); err != nil {
+		return "", Attachment{}, fmt.Errorf("writing %s: %w", name, err)
+	}
+
+	log.Printf("%s saved HTML of %s to %s", scrapeLogPrefix, pageURL, path)
+	return fmt.Sprintf("📄 Saved HTML to %s/%s", capturesDir, name),
+		Attachment{Name: name, Data: clean, Kind: AttachDocument}, nil
+}
+
+// screenshot renders the page in a headless browser.
+func (s *ScrapeTool) screenshot(ctx context.Context, pageURL, name string) (string, Attachment, error) {
+	browser, err := s.findBrowser()
+	if err != nil {
+		return "", Attachment{}, err
+	}
+
+	// Save into the workspace if there is one, otherwise to a temporary file
+	path := filepath.Join(os.TempDir(), name)
+	saved := false
+	if s.workspaceDir != "" {
+		if p, err := s.capturePath(ctx, name); err == nil {
+			path, saved = p, true
+		}
+	}
+	if !saved {
+		defer os.Remove(path)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, screenshotTimeout)
+	defer cancel()
+
+	args := []string{
+		"--headless", "--disable-gpu", "--hide-scrollbars", "--mute-audio",
+		fmt.Sprintf("--window-size=%d,%d", screenshotWidth, screenshotHeight),
+		"--screenshot=" + path,
+	}
+	if os.Geteuid() == 0 {
+		// Chromium refuses to start its sandbox as root
+		args = append(args, "--no-sandbox")
+	}
+	if proxy := egressBrowserProxy(); proxy != "" {
+		args = append(args, "--proxy-server=http://"+proxy)
+	}
+	args = append(args, pageURL)
+
+	log.Printf("%s exec: %s %s", scrapeLogPrefix, browser, strings.Join(args, " "))
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, browser, args...)
+	cmd.Dir = filepath.Dir(path)
+	cmd.Stderr = &stderr
+	isolate(ctx, cmd, "scrape")
+	if err := cmd.Run(); err != nil {
+		return "", Attachment{}, fmt.Errorf("%s: %w: %s", filepath.Base(browser), err, lastLines(stderr.String(), 3))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", Attachment{}, fmt.Errorf("reading screenshot: %w", err)
+	}
+
+	note := "🖼 Screenshot attached"
+	if saved {
+		note = fmt.Sprintf("🖼 Screenshot saved to %s/%s", capturesDir, name)
+	}
+	return note, Attachment{Name: name, Data: data, Kind: AttachPhoto}, nil
+}
+
+func (s *ScrapeTool) findBrowser() (string, error) {
+	if s.browser != "" {
+		return s.browser, nil
+	}
+	for _, name := range browserCandidates {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no headless browser found (install chromium or set SCRAPE_BROWSER)")
+}
+
+// capturePath returns where to save a capture in the workspace (the
+// tenant's part of it, if any), creating the captures directory if needed.
+func (s *ScrapeTool) capturePath(ctx context.Context, name string) (string, error) {
+	path, err := safePath(tenant.Workspace(ctx, s.workspaceDir), filepath.Join(capturesDir, name))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", capturesDir, err)
+	}
+	return path, nil
+}
+
+// sanitizeHTML removes scripts, embedded frames, and event handlers so the
+// saved page is inert, and adds a <base> so relative links still resolve.
+func sanitizeHTML(body []byte, pageURL string) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("parsing HTML: %w", err)
+	}
+
+	var clean func(n *html.Node)
+	clean = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.ElementNode {
+				switch c.Data {
+				case "script", "noscript", "iframe", "frame", "object", "embed", "base":
+					n.RemoveChild(c)
+					c = next
+					continue
+				}
+				attrs := c.Attr[:0]
+				for _, attr := range c.Attr {
+					value := strings.ToLower(strings.TrimSpace(attr.Val))
+					if strings.HasPrefix(strings.ToLower(attr.Key), "on") || strings.HasPrefix(value, "javascript:") {
+						continue
+					}
+					attrs = append(attrs, attr)
+				}
+				c.Attr = attrs
+			}
+			clean(c)
+			c = next
+		}
+	}
+	clean(doc)
+
+	if head := findElement(doc, "head"); head != nil {
+		base := &html.Node{Type: html.ElementNode, Data: "base", Attr: []html.Attribute{{Key: "href", Val: pageURL}}}
+		head.InsertBefore(base, head.FirstChild)
+	}
+
+	var out bytes.Buffer
+	if err := html.Render(&out, doc); err != nil {
+		return nil, fmt.Errorf("rendering HTML: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package tools
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultCrawlPages = 10
+	maxCrawlPages     = 30
+	defaultCrawlDepth = 2
+	maxCrawlDepth     = 3
+	maxSitemaps       = 3 // Child sitemaps read from a sitemap index
+)
+
+// Links to these are never pages worth summarizing.
+var skipCrawlExts = map[string]bool{
+	".pdf": true, ".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".zip": true, ".gz": true, ".tar": true, ".css": true, ".js": true, ".xml": true,
+	".mp4": true, ".mp3": true, ".webp": true, ".ico": true,
+}
+
+type crawledPage struct {
+	url   string
+	title string
+	text  string
+}
+
+// crawlScope limits a crawl to one site and the section of it under the start URL.
+type crawlScope struct {
+	host   string
+	prefix string
+}
+
+func newCrawlScope(start *url.URL) crawlScope {
+	prefix := start.Path
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = path.Dir(prefix)
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+	}
+	return crawlScope{host: strings.ToLower(start.Host), prefix: prefix}
+}
+
+func (c crawlScope) contains(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	if strings.ToLower(u.Host) != c.host || !strings.HasPrefix(u.Path+"/", c.prefix) {
+		return false
+	}
+	return !skipCrawlExts[strings.ToLower(path.Ext(u.Path))]
+}
+
+// crawl reads a bounded set of same-site pages under start, preferring the
+// site's sitemap and falling back to following links, and summarizes them
+// together.
+func (s *ScrapeTool) crawl(ctx context.Context, start string, args map[string]any, opts summaryOptions) (string, error) {
+	maxPages := boundedInt(args, "max_pages", defaultCrawlPages, maxCrawlPages)
+	maxDepth := boundedInt(args, "max_depth", defaultCrawlDepth, maxCrawlDepth)
+
+	startURL, err := url.Parse(start)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	startURL.Fragment = ""
+	scope := newCrawlScope(startURL)
+
+	pages, method := s.crawlSitemap(ctx, startURL, scope, maxPages)
+	if len(pages) == 0 {
+		pages, method = s.crawlLinks(ctx, startURL, scope, maxPages, maxDepth), "following links"
+	}
+	if len(pages) == 0 {
+		return "", fmt.Errorf("could not read any pages under %s", start)
+	}
+	log.Printf("%s crawled %d pages of %s by %s", scrapeLogPrefix, len(pages), start, method)
+
+	// Give every page an equal share of the summarizer's input
+	budget := maxContentLen / len(pages)
+	var content strings.Builder
+	var index strings.Builder
+	for _, page := range pages {
+		fmt.Fprintf(&content, "## %s (%s)\n%s\n\n", page.title, page.url, truncateText(page.text, budget))
+		fmt.Fprintf(&index, "- %s — %s\n", page.title, page.url)
+	}
+
+	directive := opts.directive(`Summarize the site as a whole: what it is for, how it is organized,
+and the most important topics, in 4-6 concise bullet points.`)
+	prompt := fmt.Sprintf(`These are %d pages from the site %s. Treat them as one site, not separate pages.
+%s
+
+%s
+Provide only the summary, no preamble:`, len(pages), start, directive, content.String())
+
+	footer := fmt.Sprintf("\n\nRead %d pages (%s):\n%s", len(pages), method, strings.TrimRight(index.String(), "\n"))
+	summary, err := s.generate(ctx, prompt)
+	if err != nil {
+		log.Printf("%s site summarization failed: %v", scrapeLogPrefix, err)
+		return "Failed to summarize the site." + footer, nil
+	}
+	return summary + footer, nil
+}
+
+// crawlSitemap reads the pages the site's sitemap lists under the scope.
+func (s *ScrapeTool) crawlSitemap(ctx context.Context, start *url.URL, scope crawlScope, maxPages int) ([]crawledPage, string) {
+	sitemap := &url.URL{Scheme: start.Scheme, Host: start.Host, Path: "/sitemap.xml"}
+	urls := s.sitemapURLs(ctx, sitemap.String(), scope, maxPages, true)
+	if len(urls) == 0 {
+		return nil, ""
+	}
+
+	// Always include the page the user asked about, first
+	ordered := []string{start.String()}
+	for _, u := range urls {
+		if u != start.String() && len(ordered) < maxPages {
+			ordered = append(ordered, u)
+		}
+	}
+
+	var pages []crawledPage
+	for _, u := range ordered {
+		if ctx.Err() != nil {
+			break
+		}
+		page, _, err := s.readPage(ctx, u)
+		if err != nil {
+			log.Printf("%s skipping %s: %v", scrapeLogPrefix, u, err)
+			continue
+		}
+		pages = append(pages, page)
+	}
+	return pages, "from sitemap.xml"
+}
+
+// sitemapURLs lists in-scope page URLs from a sitemap, following a sitemap
+// index one level down.
+func (s *ScrapeTool) sitemapURLs(ctx context.Context, sitemap string, scope crawlScope, limit int, followIndex bool) []string {
+	body, _, err := s.fetch(ctx, sitemap)
+	if err != nil {
+		return nil
+	}
+
+	// A urlset lists pages; a sitemapindex lists more sitemaps
+	type entry struct {
+		Loc string `xml:"loc"`
+	}
+	var doc struct {
+		URLs     []entry `xml:"url"`
+		Sitemaps []entry `xml:"sitemap"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		log.Printf("%s ignoring unparseable sitemap %s: %v", scrapeLogPrefix, sitemap, err)
+		return nil
+	}
+
+	var urls []string
+	for _, entry := range doc.URLs {
+		u, err := url.Parse(strings.TrimSpace(entry.Loc))
+		if err == nil && scope.contains(u) {
+			urls = append(urls, u.String())
+		}
+		if len(urls) >= limit {
+			return urls
+		}
+	}
+	if followIndex {
+		for i, child := range doc.Sitemaps {
+			if i >= maxSitemaps || len(urls) >= limit {
+				break
+			}
+			urls = append(urls, s.sitemapURLs(ctx, strings.TrimSpace(child.Loc), scope, limit-len(urls), false)...)
+		}
+	}
+	return urls
+}
+
+// crawlLinks reads pages breadth-first by following in-scope links.
+func (s *ScrapeTool) crawlLinks(ctx context.Context, start *url.URL, scope crawlScope, maxPages, maxDepth int) []crawledPage {
+	type queued struct {
+		url   string
+		depth int
+	}
+	queue := []queued{{start.String(), 0}}
+	seen := map[string]bool{start.String(): true}
+
+	var pages []crawledPage
+	for len(queue) > 0 && len(pages) < maxPages && ctx.Err() == nil {
+		next := queue[0]
+		queue = queue[1:]
+
+		page, links, err := s.readPage(ctx, next.url)
+		if err != nil {
+			log.Printf("%s skipping %s: %v", scrapeLogPrefix, next.url, err)
+			continue
+		}
+		pages = append(pages, page)
+
+		if next.depth >= maxDepth {
+			continue
+		}
+		for _, link := range links {
+			if !seen[link.String()] && scope.contains(link) {
+				seen[link.String()] = true
+				queue = append(queue, queued{link.String(), next.depth + 1})
+			}
+		}
+	}
+	return pages
+}
+
+// readPage fetches a page and returns its title, text, and outgoing links.
+func (s *ScrapeTool) readPage(ctx context.Context, pageURL string) (crawledPage, []*url.URL, error) {
+	body, finalURL, err := s.fetch(ctx, pageURL)
+	if err != nil {
+		return crawledPage{}, nil, err
+	}
+	base, err := url.Parse(finalURL)
+	if err != nil {
+		return crawledPage{}, nil, err
+	}
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return crawledPage{}, nil, fmt.Errorf("parsing HTML: %w", err)
+	}
+
+	var text strings.Builder
+	s.extractTextFromNode(doc, &text)
+	page := crawledPage{url: pageURL, text: strings.Join(strings.Fields(text.String()), " ")}
+
+	var links []*url.URL
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				if page.title == "" && n.FirstChild != nil {
+					page.title = strings.TrimSpace(n.FirstChild.Data)
+				}
+			case "a":
+				for _, attr := range n.Attr {
+					if attr.Key != "href" {
+						continue
+					}
+					if link, err := base.Parse(attr.Val); err == nil {
+						link.Fragment = ""
+						links = append(links, link)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if page.title == "" {
+		page.title = base.Path
+	}
+	return page, links, nil
+}
+
+// boundedInt reads an integer argument, applying a default and an upper limit.
+func boundedInt(args map[string]any, key string, def, max int) int {
+	v, ok := args[key].(float64)
+	if !ok || v < 1 {
+		return def
+	}
+	return min(int(v), max)
+}
@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	summaryChunkLen    = 12000 // Chars per chunk in map-reduce summarization
+	maxSummaryChunks   = 24    // Text beyond this many chunks is not summarized
+	summaryParallelism = 4     // Chunks summarized at once
+)
+
+// summarizeLong summarizes text too long for one prompt: it summarizes
+// chunks concurrently, then combines the chunk summaries into one.
+func (s *ScrapeTool) summarizeLong(ctx context.Context, text, url string, opts summaryOptions) (string, error) {
+	chunks := splitText(text, summaryChunkLen)
+	omitted := 0
+	if len(chunks) > maxSummaryChunks {
+		omitted = len(chunks) - maxSummaryChunks
+		chunks = chunks[:maxSummaryChunks]
+	}
+	log.Printf("%s summarizing %d chars in %d parts", scrapeLogPrefix, len(text), len(chunks))
+
+	summaries := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, summaryParallelism)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			prompt := fmt.Sprintf(`This is part %d of %d of the webpage %s.
+Summarize the key points of this part in a few bullet points, keeping important facts, figures, names, and quotes.%s
+
+Content:
+%s
+
+Provide only the summary, no preamble:`, i+1, len(chunks), url, focus(opts), chunk)
+			summaries[i], errs[i] = s.generate(ctx, prompt)
+		}()
+	}
+	wg.Wait()
+
+	// Tolerate a few failed parts, but not a summary made mostly of gaps
+	var parts strings.Builder
+	failed := 0
+	for i, summary := range summaries {
+		if errs[i] != nil {
+			log.Printf("%s part %d failed: %v", scrapeLogPrefix, i+1, errs[i])
+			failed++
+			continue
+		}
+		fmt.Fprintf(&parts, "Part %d:\n%s\n\n", i+1, summary)
+	}
+	if failed*2 > len(chunks) {
+		return "", fmt.Errorf("summarizing %d of %d parts failed: %w", failed, len(chunks), firstError(errs))
+	}
+
+	prompt := fmt.Sprintf(`These are summaries of consecutive parts of the webpage %s.
+Combine them into a single summary of the whole page. %s
+
+%s
+Provide only the summary, no preamble:`, url, opts.directive(summaryStyles["brief"]), truncateText(parts.String(), maxContentLen))
+	summary, err := s.generate(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+
+	var notes []string
+	if failed > 0 {
+		notes = append(notes, fmt.Sprintf("%d of %d parts could not be summarized", failed, len(chunks)))
+	}
+	if omitted > 0 {
+		notes = append(notes, fmt.Sprintf("the last %d parts of this very long page were skipped", omitted))
+	}
+	if len(notes) > 0 {
+		summary += "\n\n(Note: " + strings.Join(notes, "; ") + ".)"
+	}
+	return summary, nil
+}
+
+// focus passes the user's instructions to chunk summaries, so details the
+// final summary needs aren't dropped early.
+func focus(opts summaryOptions) string {
+	if opts.instructions == "" {
+		return ""
+	}
+	return "\nKeep anything relevant to: " + opts.instructions
+}
+
+// splitText splits text into chunks of at most size bytes, preferring to
+// break after a sentence and never splitting a UTF-8 character.
+func splitText(text string, size int) []string {
+	var chunks []string
+	for len(text) > size {
+		cut := size
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		// Back up to the end of a sentence in the last fifth of the chunk
+		if i := strings.LastIndexAny(text[size*4/5:cut], ".!?"); i >= 0 {
+			cut = size*4/5 + i + 1
+		}
+		chunks = append(chunks, strings.TrimSpace(text[:cut]))
+		text = text[cut:]
+	}
+	if text = strings.TrimSpace(text); text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
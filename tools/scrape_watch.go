@@ -0,0 +1,405 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"telegram-bot/auth"
+)
+
+const (
+	pageWatchesStoreKey   = "scrape_watches"
+	defaultPageInterval   = time.Hour
+	maxPageWatchesPerChat = 20
+	maxSnapshotLines      = 3000 // Longer pages are compared on their first lines only
+	maxDiffLines          = 40   // Changed lines shown to the summarizer and in fallbacks
+	maxLCSLines           = 1000 // Larger changed regions are compared as sets of lines
+)
+
+// pageWatch is a subscription to changes in a page's text. The last
+// snapshot is stored separately under snapshotKey, since it can be large.
+type pageWatch struct {
+	ID        int       `json:"id"`
+	ChatID    int64     `json:"chat_id"`
+	URL       string    `json:"url"`
+	Created   time.Time `json:"created"`
+	Checked   time.Time `json:"checked"`
+	Changed   time.Time `json:"changed,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func (w *pageWatch) snapshotKey() string {
+	return fmt.Sprintf("scrape_snapshot_%d", w.ID)
+}
+
+type pageWatchState struct {
+	NextID  int         `json:"next_id"`
+	Watches []pageWatch `json:"watches"`
+}
+
+// WithPageWatchInterval sets how often watched pages are re-fetched.
+func WithPageWatchInterval(interval time.Duration) ScrapeOption {
+	return func(s *ScrapeTool) {
+		s.watchInterval = interval
+	}
+}
+
+// Start loads saved page watches and schedules re-fetching them.
+func (s *ScrapeTool) Start(host Host) error {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	s.host = &host
+	if _, err := host.Store.Get(pageWatchesStoreKey, &s.watches); err != nil {
+		return fmt.Errorf("loading page watches: %w", err)
+	}
+	host.Scheduler.Every("page watches", s.interval(), s.pollWatches)
+	log.Printf("%s watching %d pages every %v", scrapeLogPrefix, len(s.watches.Watches), s.interval())
+	return nil
+}
+
+func (s *ScrapeTool) interval() time.Duration {
+	if s.watchInterval <= 0 {
+		return defaultPageInterval
+	}
+	return s.watchInterval
+}
+
+func (s *ScrapeTool) watch(ctx context.Context, url string) (string, error) {
+	if s.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("watch needs a chat to notify")
+	}
+	// Watches keep fetching on the bot's behalf, so guests can't create them
+	if auth.RoleFrom(ctx) < auth.Trusted {
+		return "", fmt.Errorf("only trusted users can watch pages")
+	}
+
+	// Take the first snapshot now so the URL is known to work
+	lines, err := s.snapshot(ctx, url)
+	if err != nil {
+		return "", err
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	count := 0
+	for _, w := range s.watches.Watches {
+		if w.ChatID != chatID {
+			continue
+		}
+		if w.URL == url {
+			return fmt.Sprintf("Already watching %s (watch #%d)", url, w.ID), nil
+		}
+		count++
+	}
+	if count >= maxPageWatchesPerChat {
+		return "", fmt.Errorf("this chat already watches %d pages; remove one with unwatch first", count)
+	}
+
+	s.watches.NextID++
+	w := pageWatch{ID: s.watches.NextID, ChatID: chatID, URL: url, Created: time.Now().UTC(), Checked: time.Now().UTC()}
+	if err := s.host.Store.Save(w.snapshotKey(), lines); err != nil {
+		return "", err
+	}
+	s.watches.Watches = append(s.watches.Watches, w)
+	if err := s.host.Store.Save(pageWatchesStoreKey, s.watches); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s watch #%d for chat %d: %s", scrapeLogPrefix, w.ID, chatID, url)
+	return fmt.Sprintf("👀 Watch #%d: %s (%d lines of text). I'll check every %v and message this chat with a summary of any changes.",
+		w.ID, url, len(lines), s.interval()), nil
+}
+
+func (s *ScrapeTool) unwatch(ctx context.Context, args map[string]any) (string, error) {
+	if s.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, _ := ChatFrom(ctx)
+	id, ok := args["watch_id"].(float64)
+	if !ok {
+		return "", fmt.Errorf("watch_id is required for unwatch (see operation=watches)")
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	for i, w := range s.watches.Watches {
+		if w.ID != int(id) || w.ChatID != chatID {
+			continue
+		}
+		s.watches.Watches = slices.Delete(s.watches.Watches, i, i+1)
+		if err := s.host.Store.Save(pageWatchesStoreKey, s.watches); err != nil {
+			return "", err
+		}
+		// Leave an empty snapshot rather than a stale one
+		if err := s.host.Store.Save(w.snapshotKey(), []string{}); err != nil {
+			log.Printf("%s clearing snapshot of watch #%d: %v", scrapeLogPrefix, w.ID, err)
+		}
+		return fmt.Sprintf("Stopped watching %s", w.URL), nil
+	}
+	return "", fmt.Errorf("no watch #%d in this chat", int(id))
+}
+
+func (s *ScrapeTool) listWatches(ctx context.Context) (string, error) {
+	if s.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, _ := ChatFrom(ctx)
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	var b strings.Builder
+	for _, w := range s.watches.Watches {
+		if w.ChatID != chatID {
+			continue
+		}
+		fmt.Fprintf(&b, "#%d %s (checked %s", w.ID, w.URL, w.Checked.Local().Format("Jan 2 15:04"))
+		if !w.Changed.IsZero() {
+			fmt.Fprintf(&b, ", last changed %s", w.Changed.Local().Format("Jan 2 15:04"))
+		}
+		b.WriteString(")\n")
+		if w.LastError != "" {
+			fmt.Fprintf(&b, "   ⚠️ last check failed: %s\n", w.LastError)
+		}
+	}
+	if b.Len() == 0 {
+		return "No watched pages in this chat.", nil
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// pollWatches re-fetches every watched page and notifies chats about changes.
+// Fetch failures are recorded on the watch rather than reported to the owners.
+func (s *ScrapeTool) pollWatches(ctx context.Context) error {
+	s.watchMu.Lock()
+	watches := slices.Clone(s.watches.Watches)
+	s.watchMu.Unlock()
+
+	for _, w := range watches {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.checkWatch(ctx, w)
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	return s.host.Store.Save(pageWatchesStoreKey, s.watches)
+}
+
+func (s *ScrapeTool) checkWatch(ctx context.Context, w pageWatch) {
+	// Only trusted users create watches, so their pages are fetched with
+	// the same site credentials as when the watch was made
+	ctx = auth.WithUser(ctx, auth.User{UserName: "page watch", Role: auth.Trusted})
+	w.Checked = time.Now().UTC()
+	w.LastError = ""
+	defer s.updateWatch(&w)
+
+	lines, err := s.snapshot(ctx, w.URL)
+	if err != nil {
+		log.Printf("%s watch #%d: %v", scrapeLogPrefix, w.ID, err)
+		w.LastError = err.Error()
+		return
+	}
+
+	var previous []string
+	if _, err := s.host.Store.Get(w.snapshotKey(), &previous); err != nil {
+		log.Printf("%s watch #%d: %v", scrapeLogPrefix, w.ID, err)
+	}
+	if err := s.host.Store.Save(w.snapshotKey(), lines); err != nil {
+		w.LastError = err.Error()
+		return
+	}
+	if len(previous) == 0 {
+		return // No baseline to compare against; this snapshot becomes it
+	}
+	removed, added := diffLines(previous, lines)
+	if len(removed) == 0 && len(added) == 0 {
+		return
+	}
+
+	w.Changed = w.Checked
+	log.Printf("%s watch #%d: %s changed (-%d +%d lines)", scrapeLogPrefix, w.ID, w.URL, len(removed), len(added))
+	s.host.Send(w.ChatID, fmt.Sprintf("🔔 %s changed\n\n%s\n\n(watch #%d)", w.URL, s.summarizeChange(ctx, w.URL, removed, added), w.ID))
+}
+
+// updateWatch stores the result of a check, unless the watch was removed meanwhile.
+func (s *ScrapeTool) updateWatch(w *pageWatch) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	for i := range s.watches.Watches {
+		if s.watches.Watches[i].ID == w.ID {
+			s.watches.Watches[i] = *w
+			return
+		}
+	}
+}
+
+// snapshot fetches a page and returns its text one block per line, which
+// keeps diffs readable where the summarizer's single-line text would not.
+func (s *ScrapeTool) snapshot(ctx context.Context, url string) ([]string, error) {
+	body, _, err := s.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing HTML: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(textLines(doc), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > maxSnapshotLines {
+		lines = lines[:maxSnapshotLines]
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no text found on %s", url)
+	}
+	return lines, nil
+}
+
+// textLines renders the page's visible text with a line break after each
+// block-level element.
+func textLines(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "script", "style", "nav", "footer", "header", "aside", "noscript":
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "p", "div", "li", "tr", "br", "h1", "h2", "h3", "h4", "h5", "h6",
+				"section", "article", "main", "pre", "blockquote", "dt", "dd", "table", "ul", "ol":
+				sb.WriteString("\n")
+			case "td", "th":
+				sb.WriteString(" ")
+			}
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+// diffLines returns the lines removed from old and added in new. Unchanged
+// leading and trailing lines are skipped, and the rest is compared by
+// longest common subsequence so unchanged text between edits isn't reported.
+func diffLines(old, new []string) (removed, added []string) {
+	for len(old) > 0 && len(new) > 0 && old[0] == new[0] {
+		old, new = old[1:], new[1:]
+	}
+	for len(old) > 0 && len(new) > 0 && old[len(old)-1] == new[len(new)-1] {
+		old, new = old[:len(old)-1], new[:len(new)-1]
+	}
+	if len(old) > maxLCSLines || len(new) > maxLCSLines {
+		return setDiff(old, new), setDiff(new, old)
+	}
+
+	// lcs[i][j] is the LCS length of old[i:] and new[j:]
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(old) && j < len(new) {
+		switch {
+		case old[i] == new[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, old[i])
+			i++
+		default:
+			added = append(added, new[j])
+			j++
+		}
+	}
+	removed = append(removed, old[i:]...)
+	added = append(added, new[j:]...)
+	return removed, added
+}
+
+// setDiff returns the lines of a that don't appear anywhere in b.
+func setDiff(a, b []string) []string {
+	present := make(map[string]bool, len(b))
+	for _, line := range b {
+		present[line] = true
+	}
+	var result []string
+	for _, line := range a {
+		if !present[line] {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// summarizeChange describes a change in a sentence or two, falling back to
+// the changed lines themselves if the summarizer is unavailable.
+func (s *ScrapeTool) summarizeChange(ctx context.Context, url string, removed, added []string) string {
+	var diff strings.Builder
+	for i, line := range removed {
+		if i == maxDiffLines {
+			fmt.Fprintf(&diff, "... and %d more removed lines\n", len(removed)-i)
+			break
+		}
+		diff.WriteString("- " + truncateText(line, 300) + "\n")
+	}
+	for i, line := range added {
+		if i == maxDiffLines {
+			fmt.Fprintf(&diff, "... and %d more added lines\n", len(added)-i)
+			break
+		}
+		diff.WriteString("+ " + truncateText(line, 300) + "\n")
+	}
+
+	prompt := fmt.Sprintf(`The web page %s changed. These lines were removed (-) and added (+):
+
+%s
+Summarize what changed in 1-3 concise bullet points (e.g. a new price, a new release, a status change). Ignore trivial changes such as timestamps.
+Provide only the summary, no preamble:`, url, diff.String())
+
+	summary, err := s.generate(ctx, prompt)
+	if err != nil {
+		log.Printf("%s change summarization failed: %v", scrapeLogPrefix, err)
+		return strings.TrimRight(diff.String(), "\n")
+	}
+	return summary
+}
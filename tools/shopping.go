@@ -0,0 +1,371 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+)
+
+const (
+	shoppingStoreKey     = "shopping_list"
+	shoppingLogPrefix    = "[shopping]"
+	maxShoppingItems     = 100
+	maxShoppingItemChars = 100
+	memberCacheTTL       = 10 * time.Minute
+)
+
+// ShoppingItem is one entry on the shared list.
+type ShoppingItem struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	AddedBy   string    `json:"added_by,omitempty"`
+	Added     time.Time `json:"added"`
+	Checked   bool      `json:"checked,omitempty"`
+	CheckedBy string    `json:"checked_by,omitempty"`
+}
+
+type shoppingState struct {
+	NextID int            `json:"next_id"`
+	Items  []ShoppingItem `json:"items"`
+}
+
+type membership struct {
+	member  bool
+	checked time.Time
+}
+
+// ShoppingListTool keeps one list shared by a group chat. Anyone in the
+// group can use it there, and members can also use it from their private
+// chats with the bot.
+type ShoppingListTool struct {
+	groupID int64
+
+	host    *Host // Set by Start; nil when background work is unavailable
+	mu      sync.Mutex
+	state   shoppingState
+	members map[int64]membership // Cached answers from Host.IsMember
+}
+
+// NewShoppingListTool creates a shopping list shared by the group chat.
+func NewShoppingListTool(groupChatID int64) *ShoppingListTool {
+	return &ShoppingListTool{groupID: groupChatID, members: make(map[int64]membership)}
+}
+
+func (s *ShoppingListTool) Name() string {
+	return "shopping_list"
+}
+
+func (s *ShoppingListTool) Description() string {
+	return `Shared shopping list for the family group chat.
+
+operation=add with items (comma-separated) puts things on the list.
+operation=list shows what's on the list.
+operation=check or uncheck with item (name or number) marks it bought or not.
+operation=remove with item deletes it; operation=clear removes everything checked off.`
+}
+
+func (s *ShoppingListTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"add", "list", "check", "uncheck", "remove", "clear"},
+				"description": "What to do with the shopping list",
+			},
+			"items": map[string]any{
+				"type":        "string",
+				"description": "For add: comma-separated items, e.g. \"milk, 2 lemons, bread\"",
+			},
+			"item": map[string]any{
+				"type":        "string",
+				"description": "For check, uncheck, and remove: the item's name or number",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (s *ShoppingListTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+// Available hides the list from chats and users outside the group.
+func (s *ShoppingListTool) Available(ctx context.Context) bool {
+	return s.Allowed(ctx)
+}
+
+// Start loads the shared list.
+func (s *ShoppingListTool) Start(host Host) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.host = &host
+	if _, err := host.Store.Get(shoppingStoreKey, &s.state); err != nil {
+		return fmt.Errorf("loading shopping list: %w", err)
+	}
+	log.Printf("%s %d items on the list for chat %d", shoppingLogPrefix, len(s.state.Items), s.groupID)
+	return nil
+}
+
+// Allowed reports whether the request may use the list: it comes from the
+// group itself, from an owner, or from a member of the group.
+func (s *ShoppingListTool) Allowed(ctx context.Context) bool {
+	if chatID, ok := ChatFrom(ctx); ok && chatID == s.groupID {
+		return true
+	}
+	user, ok := auth.UserFrom(ctx)
+	if !ok {
+		return false
+	}
+	return user.Role == auth.Owner || s.isMember(user.ID)
+}
+
+// isMember asks the transport whether the user is in the group, caching
+// the answer for a while since every request checks it.
+func (s *ShoppingListTool) isMember(userID int64) bool {
+	s.mu.Lock()
+	cached, ok := s.members[userID]
+	host := s.host
+	s.mu.Unlock()
+	if ok && time.Since(cached.checked) < memberCacheTTL {
+		return cached.member
+	}
+	if host == nil || host.IsMember == nil {
+		return false
+	}
+
+	member, err := host.IsMember(s.groupID, userID)
+	if err != nil {
+		log.Printf("%s checking membership of %d: %v", shoppingLogPrefix, userID, err)
+		return false
+	}
+	s.mu.Lock()
+	s.members[userID] = membership{member: member, checked: time.Now()}
+	s.mu.Unlock()
+	return member
+}
+
+func (s *ShoppingListTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if s.host == nil {
+		return "", fmt.Errorf("the shopping list is not available in this mode")
+	}
+	if !s.Allowed(ctx) {
+		return "", fmt.Errorf("the shopping list is only for members of the family group")
+	}
+
+	operation, _ := args["operation"].(string)
+	item, _ := args["item"].(string)
+	switch operation {
+	case "add":
+		items, _ := args["items"].(string)
+		if items == "" {
+			items = item
+		}
+		return s.add(ctx, items)
+	case "list":
+		return s.list(), nil
+	case "check", "uncheck":
+		found, err := s.find(item)
+		if err != nil {
+			return "", err
+		}
+		if found.Checked == (operation == "check") {
+			return fmt.Sprintf("%s is already %sed.", found.Name, operation), nil
+		}
+		updated, err := s.Toggle(ctx, found.ID)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s", checkMark(updated.Checked), updated.Name), nil
+	case "remove":
+		found, err := s.find(item)
+		if err != nil {
+			return "", err
+		}
+		if err := s.remove(found.ID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Removed %s from the list.", found.Name), nil
+	case "clear":
+		n, err := s.ClearChecked()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Cleared %d checked-off item(s).", n), nil
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// add puts comma- or line-separated items on the list, skipping ones
+// already on it and not yet bought.
+func (s *ShoppingListTool) add(ctx context.Context, items string) (string, error) {
+	var names []string
+	for _, name := range strings.FieldsFunc(items, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, truncateText(name, maxShoppingItemChars))
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("items is required for add")
+	}
+	user, _ := auth.UserFrom(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var added, skipped []string
+	for _, name := range names {
+		if slices.ContainsFunc(s.state.Items, func(item ShoppingItem) bool {
+			return !item.Checked && strings.EqualFold(item.Name, name)
+		}) {
+			skipped = append(skipped, name)
+			continue
+		}
+		if len(s.state.Items) >= maxShoppingItems {
+			return "", fmt.Errorf("the list already has %d items; clear some first", maxShoppingItems)
+		}
+		s.state.NextID++
+		s.state.Items = append(s.state.Items, ShoppingItem{
+			ID:      s.state.NextID,
+			Name:    name,
+			AddedBy: user.UserName,
+			Added:   time.Now().UTC(),
+		})
+		added = append(added, name)
+	}
+	if err := s.save(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if len(added) > 0 {
+		fmt.Fprintf(&b, "🛒 Added %s.", strings.Join(added, ", "))
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(&b, " Already on the list: %s.", strings.Join(skipped, ", "))
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (s *ShoppingListTool) list() string {
+	items := s.Items()
+	if len(items) == 0 {
+		return "The shopping list is empty."
+	}
+	var b strings.Builder
+	b.WriteString("🛒 Shopping list:\n")
+	for _, item := range items {
+		fmt.Fprintf(&b, "\n%s #%d %s", checkMark(item.Checked), item.ID, item.Name)
+		if item.AddedBy != "" {
+			fmt.Fprintf(&b, " (@%s)", item.AddedBy)
+		}
+	}
+	return b.String()
+}
+
+// find looks an item up by number or, failing that, by name.
+func (s *ShoppingListTool) find(ref string) (ShoppingItem, error) {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "#")
+	if ref == "" {
+		return ShoppingItem{}, fmt.Errorf("item is required")
+	}
+	items := s.Items()
+	if id, err := strconv.Atoi(ref); err == nil {
+		if i := slices.IndexFunc(items, func(item ShoppingItem) bool { return item.ID == id }); i >= 0 {
+			return items[i], nil
+		}
+	}
+	for _, item := range items {
+		if strings.EqualFold(item.Name, ref) {
+			return item, nil
+		}
+	}
+	for _, item := range items {
+		if strings.Contains(strings.ToLower(item.Name), strings.ToLower(ref)) {
+			return item, nil
+		}
+	}
+	return ShoppingItem{}, fmt.Errorf("%q is not on the shopping list", ref)
+}
+
+// Items returns the list: items still to buy first, then checked-off ones,
+// each in the order they were added.
+func (s *ShoppingListTool) Items() []ShoppingItem {
+	s.mu.Lock()
+	items := slices.Clone(s.state.Items)
+	s.mu.Unlock()
+
+	slices.SortStableFunc(items, func(a, b ShoppingItem) int {
+		switch {
+		case a.Checked == b.Checked:
+			return 0
+		case b.Checked:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return items
+}
+
+// Toggle checks an item off, or puts it back if it was already checked.
+func (s *ShoppingListTool) Toggle(ctx context.Context, id int) (ShoppingItem, error) {
+	user, _ := auth.UserFrom(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.Items, func(item ShoppingItem) bool { return item.ID == id })
+	if i < 0 {
+		return ShoppingItem{}, fmt.Errorf("item #%d is no longer on the list", id)
+	}
+	item := &s.state.Items[i]
+	item.Checked = !item.Checked
+	item.CheckedBy = ""
+	if item.Checked {
+		item.CheckedBy = user.UserName
+	}
+	return *item, s.save()
+}
+
+func (s *ShoppingListTool) remove(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.Items = slices.DeleteFunc(s.state.Items, func(item ShoppingItem) bool { return item.ID == id })
+	return s.save()
+}
+
+// ClearChecked removes every checked-off item and returns how many there were.
+func (s *ShoppingListTool) ClearChecked() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := len(s.state.Items)
+	s.state.Items = slices.DeleteFunc(s.state.Items, func(item ShoppingItem) bool { return item.Checked })
+	return before - len(s.state.Items), s.save()
+}
+
+// save stores the list immediately. The caller must hold s.mu.
+func (s *ShoppingListTool) save() error {
+	if s.host == nil {
+		return fmt.Errorf("the shopping list is not available in this mode")
+	}
+	return s.host.Store.Save(shoppingStoreKey, s.state)
+}
+
+func checkMark(checked bool) string {
+	if checked {
+		return "✅"
+	}
+	return "⬜"
+}
@@ -0,0 +1,612 @@
+package tools
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/embed"
+	"telegram-bot/tenant"
+)
+
+const (
+	snippetStoreKey        = "snippets"
+	snippetLogPrefix       = "[snippets]"
+	maxSnippetsPerChat     = 1000
+	maxSnippetChars        = 20000
+	maxSnippetsListed      = 15 // Snippets shown by list and search
+	maxSnippetPreviewLines = 3  // Lines of code shown for each listed snippet
+	minSnippetSimilarity   = 0.5
+	noSnippetMatches       = "No matching snippets."
+)
+
+// snippet is a saved piece of code.
+type snippet struct {
+	ID       int       `json:"id"`
+	ChatID   int64     `json:"chat_id"`
+	Title    string    `json:"title"`
+	Language string    `json:"language"`
+	Tags     []string  `json:"tags,omitempty"`
+	Code     string    `json:"code"`
+	Saved    time.Time `json:"saved"`
+}
+
+type snippetState struct {
+	NextID   int       `json:"next_id"`
+	Snippets []snippet `json:"snippets"`
+}
+
+// languageExtensions gives the file extension inserted snippets get when
+// no path is given.
+var languageExtensions = map[string]string{
+	"bash": ".sh", "sh": ".sh", "shell": ".sh", "zsh": ".sh", "python": ".py", "go": ".go",
+	"javascript": ".js", "typescript": ".ts", "sql": ".sql", "rust": ".rs", "ruby": ".rb",
+	"java": ".java", "c": ".c", "cpp": ".cpp", "yaml": ".yaml", "json": ".json", "toml": ".toml",
+	"html": ".html", "css": ".css", "dockerfile": ".dockerfile", "makefile": ".mk", "powershell": ".ps1",
+}
+
+// extensionLanguages names the language of a saved workspace file.
+var extensionLanguages = map[string]string{
+	".sh": "bash", ".bash": "bash", ".py": "python", ".go": "go", ".js": "javascript", ".mjs": "javascript",
+	".ts": "typescript", ".sql": "sql", ".rs": "rust", ".rb": "ruby", ".java": "java", ".c": "c", ".h": "c",
+	".cpp": "cpp", ".yaml": "yaml", ".yml": "yaml", ".json": "json", ".toml": "toml", ".html": "html",
+	".css": "css", ".ps1": "powershell",
+}
+
+// snippetNameChars are the characters kept from a title in a file name.
+var snippetNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// languageHints guess a snippet's language when none is given, checked in
+// order against its first lines.
+var languageHints = []struct {
+	language string
+	pattern  *regexp.Regexp
+}{
+	{"python", regexp.MustCompile(`^#!.*python`)},
+	{"bash", regexp.MustCompile(`^#!.*\b(?:ba|z)?sh\b`)},
+	{"go", regexp.MustCompile(`(?m)^(?:package \w+|func \w+\(|import \(\s*$)`)},
+	{"python", regexp.MustCompile(`(?m)^\s*(?:def \w+\(.*\):|class \w+.*:|from [\w.]+ import |import \w+$)`)},
+	{"sql", regexp.MustCompile(`(?i)^\s*(?:select|insert into|update|delete from|create table|with)\b`)},
+	{"dockerfile", regexp.MustCompile(`(?m)^FROM \S+`)},
+	{"javascript", regexp.MustCompile(`(?m)^\s*(?:const|let) \w+ = |=> \{|console\.log\(`)},
+	{"bash", regexp.MustCompile(`^\s*(?:\$ )?(?:sudo |ffmpeg|curl|git|docker|kubectl|find|grep|awk|sed|tar|ssh|rsync|for \w+ in)\b`)},
+}
+
+// SnippetsTool keeps a per-chat library of code snippets with a language
+// and tags, which can be searched and written into the workspace.
+type SnippetsTool struct {
+	workspaceDir string
+	embed        *embed.Client // Finds snippets by meaning when words don't match; nil to disable
+
+	host  *Host // Set by Start; nil when background work is unavailable
+	mu    sync.Mutex
+	state snippetState
+
+	tenants *tenantCopies[*SnippetsTool] // Nil on the copies themselves
+}
+
+// SnippetOption customizes a SnippetsTool.
+type SnippetOption func(*SnippetsTool)
+
+// WithSnippetEmbeddings makes search fall back to finding snippets similar
+// in meaning to the query when none contain its words.
+func WithSnippetEmbeddings(client *embed.Client) SnippetOption {
+	return func(s *SnippetsTool) {
+		s.embed = client
+	}
+}
+
+// NewSnippetsTool creates a snippet library that inserts into workspaceDir.
+func NewSnippetsTool(workspaceDir string, opts ...SnippetOption) *SnippetsTool {
+	s := &SnippetsTool{workspaceDir: workspaceDir, tenants: newTenantCopies[*SnippetsTool]()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *SnippetsTool) Name() string {
+	return "snippets"
+}
+
+func (s *SnippetsTool) Description() string {
+	return `Library of saved code snippets for this chat.
+
+operation=save stores code (or the workspace file named by path) with a title, language, and tags;
+use it when the user asks to keep a command, script, or function from the conversation.
+operation=search with query finds snippets by title, tag, language, or code, e.g. "ffmpeg";
+range limits it to when they were saved, e.g. "last month".
+operation=list shows recent snippets (tag or language to filter).
+operation=get with snippet_id shows a snippet's full code.
+operation=insert with snippet_id writes it into the workspace at path (default: a file named
+after its title); append=true adds it to the end of an existing file.
+operation=remove with snippet_id deletes one.`
+}
+
+func (s *SnippetsTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"save", "search", "list", "get", "insert", "remove"},
+				"description": "What to do with the snippet library",
+			},
+			"code": map[string]any{
+				"type":        "string",
+				"description": "For save: the code, exactly as it should be kept",
+			},
+			"title": map[string]any{
+				"type":        "string",
+				"description": "For save: a short description, e.g. \"Trim a video without re-encoding\"",
+			},
+			"language": map[string]any{
+				"type":        "string",
+				"description": "For save: the language, e.g. bash, python, go, sql (guessed if omitted). For list and search: only this language",
+			},
+			"tags": map[string]any{
+				"type":        "string",
+				"description": "For save: comma-separated topic tags, e.g. \"ffmpeg, video\"",
+			},
+			"tag": map[string]any{
+				"type":        "string",
+				"description": "For list and search: only snippets with this tag",
+			},
+			"query": map[string]any{
+				"type":        "string",
+				"description": "For search: words to look for",
+			},
+			"range": map[string]any{
+				"type":        "string",
+				"description": `For list and search: when the snippet was saved, e.g. "last 30 days", "last month"`,
+			},
+			"snippet_id": map[string]any{
+				"type":        "number",
+				"description": "For get, insert, and remove: the snippet number",
+			},
+			"path": map[string]any{
+				"type":        "string",
+				"description": "For save: a workspace file to save instead of code. For insert: where to write it in the workspace",
+			},
+			"append": map[string]any{
+				"type":        "boolean",
+				"description": "For insert: add to the end of the file at path instead of requiring a new file",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (s *SnippetsTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+// Start loads the snippet library.
+func (s *SnippetsTool) Start(host Host) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.host = &host
+	if _, err := host.Store.Get(snippetStoreKey, &s.state); err != nil {
+		return fmt.Errorf("loading snippets: %w", err)
+	}
+	log.Printf("%s %d saved snippets", snippetLogPrefix, len(s.state.Snippets))
+	return nil
+}
+
+// forTenant returns the snippet library of the request's tenant, kept in
+// their encrypted store.
+func (s *SnippetsTool) forTenant(ctx context.Context) (*SnippetsTool, error) {
+	return s.tenants.load(ctx, s, func(t *tenant.Tenant) (*SnippetsTool, error) {
+		host, err := tenantHost(*s.host, t)
+		if err != nil {
+			return nil, err
+		}
+		scoped := &SnippetsTool{workspaceDir: s.workspaceDir, embed: s.embed}
+		if err := scoped.Start(host); err != nil {
+			return nil, err
+		}
+		return scoped, nil
+	})
+}
+
+func (s *SnippetsTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if s.host == nil {
+		return "", fmt.Errorf("snippets are not available in this mode")
+	}
+	s, err := s.forTenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("snippets need a chat")
+	}
+
+	operation, _ := args["operation"].(string)
+	path, _ := args["path"].(string)
+	path = strings.TrimSpace(path)
+	workspace := tenant.Workspace(ctx, s.workspaceDir)
+	switch operation {
+	case "save":
+		return s.save(chatID, args, workspace, path)
+	case "search", "list":
+		query, _ := args["query"].(string)
+		if operation == "search" && strings.TrimSpace(query) == "" {
+			return "", fmt.Errorf("query is required for search")
+		}
+		filter, err := snippetFilter(args)
+		if err != nil {
+			return "", err
+		}
+		if operation == "list" {
+			return s.find(chatID, filter), nil
+		}
+		return s.search(ctx, chatID, query, filter), nil
+	case "get", "insert", "remove":
+		id, ok := args["snippet_id"].(float64)
+		if !ok {
+			return "", fmt.Errorf("snippet_id is required for %s (see operation=list)", operation)
+		}
+		switch operation {
+		case "get":
+			return s.get(chatID, int(id))
+		case "insert":
+			appendTo, _ := args["append"].(bool)
+			return s.insert(chatID, int(id), workspace, path, appendTo)
+		default:
+			return s.remove(chatID, int(id))
+		}
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+func (s *SnippetsTool) save(chatID int64, args map[string]any, workspace, path string) (string, error) {
+	code, _ := args["code"].(string)
+	title, _ := args["title"].(string)
+	language, _ := args["language"].(string)
+	tags, _ := args["tags"].(string)
+	title = strings.TrimSpace(title)
+	language = strings.ToLower(strings.TrimSpace(language))
+
+	if strings.TrimSpace(code) == "" && path != "" {
+		full, err := safePath(workspace, path)
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(full)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", path, err)
+		}
+		code = string(data)
+		title = cmp.Or(title, filepath.Base(path))
+		if language == "" {
+			language = extensionLanguages[strings.ToLower(filepath.Ext(path))]
+		}
+	}
+	code = strings.Trim(code, "\n")
+	if strings.TrimSpace(code) == "" {
+		return "", fmt.Errorf("code is required for save")
+	}
+	if len(code) > maxSnippetChars {
+		return "", fmt.Errorf("the snippet is %d characters; snippets can be at most %d", len(code), maxSnippetChars)
+	}
+	language = cmp.Or(language, guessLanguage(code))
+	if title == "" {
+		// Name it after its first line, skipping any #! line
+		lines := strings.Split(strings.TrimSpace(code), "\n")
+		if len(lines) > 1 && strings.HasPrefix(lines[0], "#!") {
+			lines = lines[1:]
+		}
+		title = truncateText(strings.TrimSpace(lines[0]), 60)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, sn := range s.state.Snippets {
+		if sn.ChatID != chatID {
+			continue
+		}
+		if sn.Code == code {
+			return fmt.Sprintf("Already saved as #%d: %s", sn.ID, sn.Title), nil
+		}
+		count++
+	}
+	if count >= maxSnippetsPerChat {
+		return "", fmt.Errorf("the library already has %d snippets; remove some first", count)
+	}
+
+	s.state.NextID++
+	sn := snippet{
+		ID:       s.state.NextID,
+		ChatID:   chatID,
+		Title:    title,
+		Language: language,
+		Tags:     parseTags(tags),
+		Code:     code,
+		Saved:    time.Now().UTC(),
+	}
+	s.state.Snippets = append(s.state.Snippets, sn)
+	if err := s.host.Store.Save(snippetStoreKey, s.state); err != nil {
+		return "", err
+	}
+	log.Printf("%s saved #%d for chat %d: %s", snippetLogPrefix, sn.ID, chatID, title)
+	return "📌 Saved snippet\n\n" + formatSnippet(sn, maxSnippetPreviewLines), nil
+}
+
+// guessLanguage names the language code is most likely in, or "text".
+func guessLanguage(code string) string {
+	head := code[:min(len(code), 2000)]
+	for _, hint := range languageHints {
+		if hint.pattern.MatchString(head) {
+			return hint.language
+		}
+	}
+	return "text"
+}
+
+// snippetFilter builds a match for the tag, language, and range arguments.
+func snippetFilter(args map[string]any) (func(*snippet) bool, error) {
+	tag, _ := args["tag"].(string)
+	language, _ := args["language"].(string)
+	phrase, _ := args["range"].(string)
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	language = strings.ToLower(strings.TrimSpace(language))
+
+	var saved *dateRange
+	if strings.TrimSpace(phrase) != "" {
+		r, err := parseDateRange(phrase, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		saved = &r
+	}
+	return func(sn *snippet) bool {
+		if tag != "" && !slices.Contains(sn.Tags, tag) {
+			return false
+		}
+		if language != "" && sn.Language != language {
+			return false
+		}
+		if saved != nil && (sn.Saved.Before(saved.Start) || !sn.Saved.Before(saved.End)) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// search matches snippets containing every word of the query. If none do,
+// and embeddings are configured, it lists the snippets closest in meaning.
+func (s *SnippetsTool) search(ctx context.Context, chatID int64, query string, filter func(*snippet) bool) string {
+	words := strings.Fields(strings.ToLower(query))
+	found := s.find(chatID, func(sn *snippet) bool {
+		if !filter(sn) {
+			return false
+		}
+		text := strings.ToLower(snippetText(*sn))
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+		return true
+	})
+	if s.embed == nil || found != noSnippetMatches {
+		return found
+	}
+
+	similar, err := s.similar(ctx, chatID, query, filter)
+	if err != nil {
+		log.Printf("%s similarity search failed: %v", snippetLogPrefix, err)
+		return found
+	}
+	if len(similar) == 0 {
+		return found
+	}
+	var b strings.Builder
+	b.WriteString("No snippets contain those words; these are closest in meaning:\n\n")
+	for _, sn := range similar {
+		b.WriteString(formatSnippet(sn, maxSnippetPreviewLines) + "\n\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// similar returns the chat's matching snippets closest in meaning to the
+// query, best first.
+func (s *SnippetsTool) similar(ctx context.Context, chatID int64, query string, filter func(*snippet) bool) ([]snippet, error) {
+	s.mu.Lock()
+	var snippets []snippet
+	for i := range s.state.Snippets {
+		if sn := &s.state.Snippets[i]; sn.ChatID == chatID && filter(sn) {
+			snippets = append(snippets, *sn)
+		}
+	}
+	s.mu.Unlock()
+	if len(snippets) == 0 {
+		return nil, nil
+	}
+
+	texts := []string{query}
+	for _, sn := range snippets {
+		texts = append(texts, truncateText(snippetText(sn), maxEmbedChars))
+	}
+	vectors, err := s.embed.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[int]float64)
+	var matches []snippet
+	for i, sn := range snippets {
+		if score := embed.Cosine(vectors[0], vectors[i+1]); score >= minSnippetSimilarity {
+			scores[sn.ID] = score
+			matches = append(matches, sn)
+		}
+	}
+	slices.SortFunc(matches, func(a, b snippet) int {
+		return cmp.Compare(scores[b.ID], scores[a.ID])
+	})
+	return matches[:min(len(matches), maxSnippetsListed)], nil
+}
+
+// snippetText is the text a snippet is searched and embedded by.
+func snippetText(sn snippet) string {
+	return strings.Join([]string{sn.Title, sn.Language, strings.Join(sn.Tags, " "), sn.Code}, "\n")
+}
+
+// find lists the chat's matching snippets, newest first.
+func (s *SnippetsTool) find(chatID int64, match func(*snippet) bool) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	shown, total := 0, 0
+	for i := len(s.state.Snippets) - 1; i >= 0; i-- {
+		sn := &s.state.Snippets[i]
+		if sn.ChatID != chatID || !match(sn) {
+			continue
+		}
+		total++
+		if shown < maxSnippetsListed {
+			b.WriteString(formatSnippet(*sn, maxSnippetPreviewLines) + "\n\n")
+			shown++
+		}
+	}
+	if total == 0 {
+		return noSnippetMatches
+	}
+	if total > shown {
+		fmt.Fprintf(&b, "...and %d more", total-shown)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// lookup returns one of the chat's snippets.
+func (s *SnippetsTool) lookup(chatID int64, id int) (snippet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sn := range s.state.Snippets {
+		if sn.ID == id && sn.ChatID == chatID {
+			return sn, nil
+		}
+	}
+	return snippet{}, fmt.Errorf("no snippet #%d in this chat's library", id)
+}
+
+func (s *SnippetsTool) get(chatID int64, id int) (string, error) {
+	sn, err := s.lookup(chatID, id)
+	if err != nil {
+		return "", err
+	}
+	return formatSnippet(sn, 0), nil
+}
+
+// insert writes a snippet into the workspace, refusing to replace a file
+// unless appending to it.
+func (s *SnippetsTool) insert(chatID int64, id int, workspace, path string, appendTo bool) (string, error) {
+	sn, err := s.lookup(chatID, id)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		path = snippetFileName(sn)
+	}
+	full, err := safePath(workspace, path)
+	if err != nil {
+		return "", err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	perm := os.FileMode(0644)
+	if strings.HasPrefix(sn.Code, "#!") {
+		perm = 0755
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return "", fmt.Errorf("creating folder for %s: %w", path, err)
+	}
+	f, err := os.OpenFile(full, flags, perm)
+	if os.IsExist(err) {
+		return "", fmt.Errorf("%s already exists; pick another path or set append=true", path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	code := sn.Code + "\n"
+	if appendTo {
+		if info, err := f.Stat(); err == nil && info.Size() > 0 {
+			code = "\n" + code
+		}
+	}
+	if _, err := f.WriteString(code); err != nil {
+		f.Close()
+		return "", fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	log.Printf("%s inserted #%d into %s for chat %d", snippetLogPrefix, id, path, chatID)
+	if appendTo {
+		return fmt.Sprintf("📌 Appended #%d (%s) to %s", id, sn.Title, path), nil
+	}
+	return fmt.Sprintf("📌 Wrote #%d (%s) to %s", id, sn.Title, path), nil
+}
+
+// snippetFileName names a file after a snippet's title and language.
+func snippetFileName(sn snippet) string {
+	name := snippetNameChars.ReplaceAllString(strings.ToLower(sn.Title), "_")
+	name = strings.Trim(name[:min(len(name), 40)], "_")
+	name = cmp.Or(name, fmt.Sprintf("snippet_%d", sn.ID))
+	return name + cmp.Or(languageExtensions[sn.Language], ".txt")
+}
+
+func (s *SnippetsTool) remove(chatID int64, id int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.Snippets, func(sn snippet) bool {
+		return sn.ID == id && sn.ChatID == chatID
+	})
+	if i < 0 {
+		return "", fmt.Errorf("no snippet #%d in this chat's library", id)
+	}
+	sn := s.state.Snippets[i]
+	s.state.Snippets = slices.Delete(s.state.Snippets, i, i+1)
+	if err := s.host.Store.Save(snippetStoreKey, s.state); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Removed #%d: %s", id, sn.Title), nil
+}
+
+// formatSnippet shows a snippet's title, language, tags, and date, then its
+// code: all of it, or its first lines when lines is positive.
+func formatSnippet(sn snippet, lines int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s (%s)", sn.ID, sn.Title, sn.Language)
+	for _, tag := range sn.Tags {
+		b.WriteString(" #" + tag)
+	}
+	fmt.Fprintf(&b, "\nSaved %s\n", sn.Saved.Local().Format("Jan 2, 2006"))
+
+	code := strings.Split(sn.Code, "\n")
+	if lines > 0 && len(code) > lines {
+		code = append(code[:lines], fmt.Sprintf("... (%d more lines)", len(code)-lines))
+	}
+	b.WriteString(strings.Join(code, "\n"))
+	return b.String()
+}
@@ -0,0 +1,478 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/spotify"
+)
+
+const (
+	spotifyAPIURL     = "https://api.spotify.com/v1"
+	spotifyTimeout    = 15 * time.Second
+	maxPlaylistTracks = 100 // Spotify's limit per request
+)
+
+// spotifyScopes are what the tool needs: reading and controlling playback,
+// and creating playlists.
+var spotifyScopes = []string{
+	"user-read-currently-playing",
+	"user-read-playback-state",
+	"user-modify-playback-state",
+	"playlist-modify-private",
+	"playlist-modify-public",
+}
+
+// SpotifyTool shows and controls what's playing on the owner's Spotify
+// account, queues tracks, and creates playlists.
+type SpotifyTool struct {
+	config    *oauth2.Config
+	tokenFile string
+
+	mu     sync.RWMutex
+	client *http.Client // nil until authenticated
+}
+
+// NewSpotifyTool creates a Spotify tool with OAuth credentials. redirectURL
+// must be registered with the Spotify app; it doesn't need to be reachable,
+// since the code is copied from the browser's address bar.
+func NewSpotifyTool(clientID, clientSecret, redirectURL, tokenFile string) *SpotifyTool {
+	return &SpotifyTool{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       spotifyScopes,
+			Endpoint:     spotify.Endpoint,
+		},
+		tokenFile: tokenFile,
+	}
+}
+
+// Init loads a saved token. Returns an auth URL if the user needs to
+// authenticate, empty string if already authenticated.
+func (s *SpotifyTool) Init(ctx context.Context) (authURL string, err error) {
+	if s.config.ClientID == "" || s.config.ClientSecret == "" {
+		return "", fmt.Errorf("SPOTIFY_CLIENT_ID and SPOTIFY_CLIENT_SECRET are required")
+	}
+
+	token, err := s.tokenFromFile()
+	if err != nil {
+		// No token, need to authenticate
+		return s.config.AuthCodeURL("state-token"), nil
+	}
+	s.useToken(token)
+	return "", nil
+}
+
+// CompleteAuth finishes the OAuth flow with the authorization code, or the
+// whole redirect URL it was copied from.
+func (s *SpotifyTool) CompleteAuth(ctx context.Context, authCode string) error {
+	if u, err := url.Parse(authCode); err == nil && u.Query().Get("code") != "" {
+		authCode = u.Query().Get("code")
+	}
+	token, err := s.config.Exchange(ctx, authCode)
+	if err != nil {
+		return fmt.Errorf("exchanging auth code: %w", err)
+	}
+	if err := s.saveToken(token); err != nil {
+		return fmt.Errorf("saving token: %w", err)
+	}
+	s.useToken(token)
+	return nil
+}
+
+// useToken sets up the API client. Spotify may hand out a new refresh token
+// when refreshing, so refreshed tokens are saved.
+func (s *SpotifyTool) useToken(token *oauth2.Token) {
+	source := &savingTokenSource{
+		base: s.config.TokenSource(context.Background(), token),
+		last: token,
+		save: s.saveToken,
+	}
+	client := oauth2.NewClient(context.Background(), oauth2.ReuseTokenSource(token, source))
+	client.Timeout = spotifyTimeout
+
+	s.mu.Lock()
+	s.client = client
+	s.mu.Unlock()
+}
+
+func (s *SpotifyTool) Name() string {
+	return "spotify"
+}
+
+func (s *SpotifyTool) Description() string {
+	return `Control the user's Spotify: see what's playing, play, pause, skip, queue tracks, and create playlists.
+Tracks are given as searches like "bohemian rhapsody queen" or as Spotify links.
+operation=play with query starts that track; without it, resumes playback.
+operation=create_playlist with name and tracks (a list of searches) creates a private playlist.
+Playback control needs Spotify open on some device and a Premium account.`
+}
+
+func (s *SpotifyTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"now_playing", "play", "pause", "next", "previous", "queue", "create_playlist"},
+				"description": "What to do",
+			},
+			"query": map[string]any{
+				"type":        "string",
+				"description": "For play and queue: the track to find, e.g. \"take five dave brubeck\", or a Spotify link",
+			},
+			"name": map[string]any{
+				"type":        "string",
+				"description": "For create_playlist: the playlist's name",
+			},
+			"tracks": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "string"},
+				"description": "For create_playlist: the tracks, each a search or Spotify link",
+			},
+			"description": map[string]any{
+				"type":        "string",
+				"description": "For create_playlist: an optional description",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (s *SpotifyTool) Metadata() Metadata {
+	return Metadata{Cost: CostMedium}
+}
+
+func (s *SpotifyTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+
+	if client == nil {
+		return "Spotify not connected. Please use /spotify to connect your account.", nil
+	}
+
+	operation, _ := args["operation"].(string)
+	query, _ := args["query"].(string)
+	query = strings.TrimSpace(query)
+
+	switch operation {
+	case "", "now_playing":
+		return s.nowPlaying(ctx, client)
+	case "play":
+		if query == "" {
+			return s.control(ctx, client, http.MethodPut, "/me/player/play", nil, "▶️ Resumed.")
+		}
+		track, err := s.findTrack(ctx, client, query)
+		if err != nil {
+			return "", err
+		}
+		body := map[string]any{"uris": []string{track.URI}}
+		return s.control(ctx, client, http.MethodPut, "/me/player/play", body, "▶️ Playing "+track.String()+".")
+	case "pause":
+		return s.control(ctx, client, http.MethodPut, "/me/player/pause", nil, "⏸️ Paused.")
+	case "next":
+		return s.control(ctx, client, http.MethodPost, "/me/player/next", nil, "⏭️ Skipped to the next track.")
+	case "previous":
+		return s.control(ctx, client, http.MethodPost, "/me/player/previous", nil, "⏮️ Back to the previous track.")
+	case "queue":
+		if query == "" {
+			return "", fmt.Errorf("query is required for queue")
+		}
+		track, err := s.findTrack(ctx, client, query)
+		if err != nil {
+			return "", err
+		}
+		return s.control(ctx, client, http.MethodPost, "/me/player/queue?uri="+url.QueryEscape(track.URI), nil, "➕ Queued "+track.String()+".")
+	case "create_playlist":
+		return s.createPlaylist(ctx, client, args)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// spotifyTrack is the part of a track object the tool uses.
+type spotifyTrack struct {
+	URI     string `json:"uri"`
+	Name    string `json:"name"`
+	Artists []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		Name string `json:"name"`
+	} `json:"album"`
+	DurationMS int `json:"duration_ms"`
+}
+
+func (t *spotifyTrack) String() string {
+	names := make([]string, len(t.Artists))
+	for i, a := range t.Artists {
+		names[i] = a.Name
+	}
+	if len(names) == 0 {
+		return t.Name
+	}
+	return fmt.Sprintf("%s by %s", t.Name, strings.Join(names, ", "))
+}
+
+func (s *SpotifyTool) nowPlaying(ctx context.Context, client *http.Client) (string, error) {
+	var playing struct {
+		IsPlaying  bool          `json:"is_playing"`
+		ProgressMS int           `json:"progress_ms"`
+		Item       *spotifyTrack `json:"item"`
+		Device     *struct {
+			Name string `json:"name"`
+		} `json:"device"`
+	}
+	found, err := s.call(ctx, client, http.MethodGet, "/me/player", nil, &playing)
+	if err != nil {
+		return "", err
+	}
+	if !found || playing.Item == nil {
+		return "Nothing is playing on Spotify.", nil
+	}
+
+	state := "▶️ Playing"
+	if !playing.IsPlaying {
+		state = "⏸️ Paused"
+	}
+	reply := fmt.Sprintf("%s: %s", state, playing.Item.String())
+	if playing.Item.Album.Name != "" {
+		reply += fmt.Sprintf(" (%s)", playing.Item.Album.Name)
+	}
+	reply += fmt.Sprintf("\n%s / %s", trackTime(playing.ProgressMS), trackTime(playing.Item.DurationMS))
+	if playing.Device != nil && playing.Device.Name != "" {
+		reply += " on " + playing.Device.Name
+	}
+	return reply, nil
+}
+
+// control sends a playback command and returns done if it worked.
+func (s *SpotifyTool) control(ctx context.Context, client *http.Client, method, path string, body any, done string) (string, error) {
+	if _, err := s.call(ctx, client, method, path, body, nil); err != nil {
+		return "", err
+	}
+	return done, nil
+}
+
+// findTrack resolves a Spotify link or URI, or searches for the best match.
+func (s *SpotifyTool) findTrack(ctx context.Context, client *http.Client, query string) (*spotifyTrack, error) {
+	if id, ok := spotifyTrackID(query); ok {
+		var track spotifyTrack
+		if _, err := s.call(ctx, client, http.MethodGet, "/tracks/"+id, nil, &track); err != nil {
+			return nil, err
+		}
+		return &track, nil
+	}
+
+	var result struct {
+		Tracks struct {
+			Items []spotifyTrack `json:"items"`
+		} `json:"tracks"`
+	}
+	search := url.Values{"q": {query}, "type": {"track"}, "limit": {"1"}}
+	if _, err := s.call(ctx, client, http.MethodGet, "/search?"+search.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Tracks.Items) == 0 {
+		return nil, fmt.Errorf("no track found for %q", query)
+	}
+	return &result.Tracks.Items[0], nil
+}
+
+func (s *SpotifyTool) createPlaylist(ctx context.Context, client *http.Client, args map[string]any) (string, error) {
+	name, _ := args["name"].(string)
+	description, _ := args["description"].(string)
+	if name = strings.TrimSpace(name); name == "" {
+		return "", fmt.Errorf("name is required for create_playlist")
+	}
+	queries, _ := args["tracks"].([]any)
+	if len(queries) > maxPlaylistTracks {
+		return "", fmt.Errorf("at most %d tracks can be added at once", maxPlaylistTracks)
+	}
+
+	var uris, added, missing []string
+	for _, q := range queries {
+		query, _ := q.(string)
+		if query = strings.TrimSpace(query); query == "" {
+			continue
+		}
+		track, err := s.findTrack(ctx, client, query)
+		if err != nil {
+			missing = append(missing, query)
+			continue
+		}
+		uris = append(uris, track.URI)
+		added = append(added, track.String())
+	}
+
+	var me struct {
+		ID string `json:"id"`
+	}
+	if _, err := s.call(ctx, client, http.MethodGet, "/me", nil, &me); err != nil {
+		return "", err
+	}
+	var playlist struct {
+		ID           string `json:"id"`
+		ExternalURLs struct {
+			Spotify string `json:"spotify"`
+		} `json:"external_urls"`
+	}
+	body := map[string]any{"name": name, "description": description, "public": false}
+	if _, err := s.call(ctx, client, http.MethodPost, "/users/"+url.PathEscape(me.ID)+"/playlists", body, &playlist); err != nil {
+		return "", err
+	}
+	if len(uris) > 0 {
+		if _, err := s.call(ctx, client, http.MethodPost, "/playlists/"+playlist.ID+"/tracks", map[string]any{"uris": uris}, nil); err != nil {
+			return "", fmt.Errorf("created playlist %q but couldn't add tracks: %w", name, err)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🎵 Created playlist %q with %d tracks: %s\n", name, len(uris), playlist.ExternalURLs.Spotify)
+	for _, t := range added {
+		fmt.Fprintf(&b, "- %s\n", t)
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(&b, "Not found: %s\n", strings.Join(missing, "; "))
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// call makes a Web API request and decodes the response into v if given.
+// found is false when Spotify answers 204 No Content, e.g. when nothing is
+// playing.
+func (s *SpotifyTool) call(ctx context.Context, client *http.Client, method, path string, body, v any) (found bool, err error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return false, fmt.Errorf("marshaling request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, spotifyAPIURL+path, reader)
+	if err != nil {
+		return false, fmt.Errorf("creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("calling Spotify: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+				Reason  string `json:"reason"`
+			} `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+		switch {
+		case apiErr.Error.Reason == "NO_ACTIVE_DEVICE" || (resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/me/player")):
+			return false, fmt.Errorf("no active Spotify device: open Spotify on a phone, computer, or speaker first")
+		case apiErr.Error.Reason == "PREMIUM_REQUIRED":
+			return false, fmt.Errorf("Spotify only allows controlling playback on Premium accounts")
+		case resp.StatusCode == http.StatusUnauthorized:
+			return false, fmt.Errorf("Spotify rejected the token: use /spotify to reconnect")
+		}
+		return false, fmt.Errorf("Spotify returned %s: %s", resp.Status, apiErr.Error.Message)
+	}
+	if resp.StatusCode == http.StatusNoContent || v == nil {
+		return resp.StatusCode != http.StatusNoContent, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("decoding response: %w", err)
+	}
+	return true, nil
+}
+
+// spotifyTrackID extracts the ID from an open.spotify.com track link or a
+// spotify:track: URI.
+func spotifyTrackID(s string) (string, bool) {
+	if id, ok := strings.CutPrefix(s, "spotify:track:"); ok {
+		return id, true
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host != "open.spotify.com" {
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	// Links may carry a locale first, e.g. /intl-de/track/<id>
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "track" {
+			return parts[i+1], true
+		}
+	}
+	return "", false
+}
+
+// trackTime formats a position in a track as m:ss.
+func trackTime(ms int) string {
+	seconds := ms / 1000
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// savingTokenSource saves tokens whenever they are refreshed.
+type savingTokenSource struct {
+	base oauth2.TokenSource
+	save func(*oauth2.Token) error
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil || token.AccessToken != s.last.AccessToken {
+		s.last = token
+		if err := s.save(token); err != nil {
+			log.Printf("[spotify] saving refreshed token: %v", err)
+		}
+	}
+	return token, nil
+}
+
+func (s *SpotifyTool) tokenFromFile() (*oauth2.Token, error) {
+	f, err := os.Open(s.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	token := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(token)
+	return token, err
+}
+
+func (s *SpotifyTool) saveToken(token *oauth2.Token) error {
+	f, err := os.OpenFile(s.tokenFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(token)
+}
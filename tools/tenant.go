@@ -0,0 +1,80 @@
+package tools
+
+import (
+	"context"
+	"sync"
+
+	"telegram-bot/tenant"
+)
+
+// tenantCopies keeps a copy of a tool for each tenant, made on first use,
+// for tools whose state lives in their workspace, such as virtualenvs and
+// shell sessions.
+type tenantCopies[T any] struct {
+	mu     sync.Mutex
+	copies map[string]T
+}
+
+func newTenantCopies[T any]() *tenantCopies[T] {
+	return &tenantCopies[T]{copies: make(map[string]T)}
+}
+
+// get returns the copy of self for the context's tenant, made by create.
+// Without a tenant, or on a copy (whose tenantCopies are nil), it returns
+// self.
+func (c *tenantCopies[T]) get(ctx context.Context, self T, create func(t *tenant.Tenant) T) T {
+	scoped, _ := c.load(ctx, self, func(t *tenant.Tenant) (T, error) {
+		return create(t), nil
+	})
+	return scoped
+}
+
+// load is get for copies that can fail to be made, such as ones reading
+// the tenant's store. A failed copy isn't kept, so the next call tries again.
+func (c *tenantCopies[T]) load(ctx context.Context, self T, create func(t *tenant.Tenant) (T, error)) (T, error) {
+	t, ok := tenant.From(ctx)
+	if c == nil || !ok {
+		return self, nil
+	}
+	return c.of(t, create)
+}
+
+// of returns the tenant's copy, making it with create on first use.
+func (c *tenantCopies[T]) of(t *tenant.Tenant, create func(t *tenant.Tenant) (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if scoped, ok := c.copies[t.ID]; ok {
+		return scoped, nil
+	}
+	scoped, err := create(t)
+	if err != nil {
+		return scoped, err
+	}
+	c.copies[t.ID] = scoped
+	return scoped, nil
+}
+
+// all returns the copies made so far.
+func (c *tenantCopies[T]) all() []T {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	all := make([]T, 0, len(c.copies))
+	for _, scoped := range c.copies {
+		all = append(all, scoped)
+	}
+	return all
+}
+
+// tenantHost returns host with the tenant's encrypted store in place of the
+// shared one.
+func tenantHost(host Host, t *tenant.Tenant) (Host, error) {
+	st, err := t.Store()
+	if err != nil {
+		return host, err
+	}
+	host.Store = st
+	return host, nil
+}
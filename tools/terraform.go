@@ -0,0 +1,423 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+	"telegram-bot/tenant"
+)
+
+const (
+	terraformLogPrefix = "[terraform]"
+	terraformTimeout   = 15 * time.Minute
+	terraformPlanTTL   = time.Hour // Plans older than this can't be applied
+	maxTerraformOutput = 30        // Lines of apply output or errors shown
+	terraformApproval  = "Apply"
+)
+
+// TerraformDir is a Terraform configuration the tool may plan and apply,
+// e.g. prod=/srv/infra/prod.
+type TerraformDir struct {
+	Name string
+	Path string
+}
+
+// ParseTerraformDirs parses "name=path" pairs, or bare paths named after
+// their last element.
+func ParseTerraformDirs(entries []string) ([]TerraformDir, error) {
+	var dirs []TerraformDir
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, path = "", entry
+		}
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return nil, fmt.Errorf("invalid Terraform directory %q (expected name=path or a path)", entry)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", path, err)
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			name = filepath.Base(abs)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Terraform directory %s is listed twice", name)
+		}
+		seen[name] = true
+		dirs = append(dirs, TerraformDir{Name: name, Path: abs})
+	}
+	return dirs, nil
+}
+
+// terraformPlan is a saved plan waiting to be applied.
+type terraformPlan struct {
+	ID      int
+	Dir     TerraformDir
+	File    string // The saved plan, which apply runs exactly
+	Summary planSummary
+	Created time.Time
+}
+
+// applyRecord is one line of the apply audit log.
+type applyRecord struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	UserID   int64     `json:"user_id"`
+	Dir      string    `json:"dir"`
+	Plan     int       `json:"plan"`
+	Changes  string    `json:"changes"`
+	Success  bool      `json:"success"`
+	ErrorMsg string    `json:"error,omitempty"`
+}
+
+// TerraformTool plans Terraform configurations in allowlisted directories
+// and summarizes the plans, and applies a plan once the user approves it.
+type TerraformTool struct {
+	dirs         []TerraformDir
+	workspaceDir string
+	auditLog     string // JSON-lines file recording every apply; empty disables
+
+	mu     sync.Mutex
+	nextID int
+	plans  map[int]*terraformPlan
+
+	auditMu sync.Mutex
+}
+
+// TerraformOption configures a TerraformTool.
+type TerraformOption func(*TerraformTool)
+
+// WithTerraformAuditLog records every apply in a JSON-lines file.
+func WithTerraformAuditLog(path string) TerraformOption {
+	return func(t *TerraformTool) {
+		t.auditLog = path
+	}
+}
+
+// NewTerraformTool creates a tool for the Terraform configurations in dirs.
+// Uploaded plans are read from workspaceDir.
+func NewTerraformTool(dirs []TerraformDir, workspaceDir string, opts ...TerraformOption) *TerraformTool {
+	t := &TerraformTool{dirs: dirs, workspaceDir: workspaceDir, plans: make(map[int]*terraformPlan)}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *TerraformTool) Name() string {
+	return "terraform"
+}
+
+func (t *TerraformTool) Description() string {
+	return `Plan infrastructure changes with Terraform and summarize them, or apply a plan the user approves.
+
+Operations:
+- plan: run terraform plan in dir and summarize what it adds, changes, replaces, and destroys, calling out
+  risks such as destroyed databases, access control changes, and drift. The plan is saved with an ID.
+- summarize: summarize a plan the user uploaded, as JSON from terraform show -json, at path in the workspace
+- apply: apply a saved plan by plan_id. The user is asked to approve it first; don't ask them yourself.
+
+Only the configured directories can be planned: ` + t.dirNames() + `.`
+}
+
+func (t *TerraformTool) Parameters() map[string]any {
+	names := make([]string, len(t.dirs))
+	for i, dir := range t.dirs {
+		names[i] = dir.Name
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"plan", "summarize", "apply"},
+				"description": "What to do",
+			},
+			"dir": map[string]any{
+				"type":        "string",
+				"enum":        names,
+				"description": "For plan: the Terraform directory (default: the only one, if there is one)",
+			},
+			"path": map[string]any{
+				"type":        "string",
+				"description": "For summarize: the plan JSON in the workspace, e.g. uploads/plan.json",
+			},
+			"plan_id": map[string]any{
+				"type":        "integer",
+				"description": "For apply: the ID plan gave the plan",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (t *TerraformTool) Metadata() Metadata {
+	return Metadata{Dangerous: true, Cost: CostHigh}
+}
+
+func (t *TerraformTool) Requirements() []Requirement {
+	return []Requirement{
+		{Name: "terraform", Command: "terraform", Operations: []string{"plan", "apply"}},
+	}
+}
+
+// FormFields asks the user to approve every apply, whatever the model
+// passed, so a plan only runs once they've pressed Apply.
+func (t *TerraformTool) FormFields(ctx context.Context, args map[string]any) []FormField {
+	if operation, _ := args["operation"].(string); operation != "apply" {
+		return nil
+	}
+	plan, err := t.plan(args)
+	if err != nil {
+		return []FormField{blockedField(err)}
+	}
+	prompt := fmt.Sprintf("Apply plan #%d to %s: %s?", plan.ID, plan.Dir.Name, plan.Summary.counts())
+	return []FormField{approvalField(prompt, terraformApproval)}
+}
+
+func (t *TerraformTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "plan":
+		return t.runPlan(ctx, args)
+	case "summarize":
+		return t.summarize(ctx, args)
+	case "apply":
+		return t.apply(ctx, args)
+	}
+	return "", fmt.Errorf("unknown operation: %s", operation)
+}
+
+// runPlan plans a directory, saves the plan for apply, and summarizes it.
+func (t *TerraformTool) runPlan(ctx context.Context, args map[string]any) (string, error) {
+	dir, err := t.dir(args)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir.Path, ".terraform")); os.IsNotExist(err) {
+		if _, err := runTerraform(ctx, dir, "init", "-input=false", "-no-color"); err != nil {
+			return "", err
+		}
+	}
+
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.mu.Unlock()
+	file := filepath.Join(dir.Path, ".terraform", fmt.Sprintf("bot-plan-%d.tfplan", id))
+	if _, err := runTerraform(ctx, dir, "plan", "-input=false", "-no-color", "-lock-timeout=60s", "-out="+file); err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	out, err := runTerraform(ctx, dir, "show", "-json", "-no-color", file)
+	if err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	summary, err := parsePlan(out)
+	if err != nil {
+		os.Remove(file)
+		return "", err
+	}
+
+	text := summary.report(fmt.Sprintf("Plan #%d for %s", id, dir.Name))
+	if summary.empty() {
+		os.Remove(file)
+		return text, nil
+	}
+	t.mu.Lock()
+	t.prune()
+	t.plans[id] = &terraformPlan{ID: id, Dir: dir, File: file, Summary: summary, Created: time.Now()}
+	t.mu.Unlock()
+	return text + fmt.Sprintf("\n\nTo apply it, ask to apply plan #%d within the hour; you'll be asked to approve it.", id), nil
+}
+
+// summarize summarizes an uploaded plan in JSON.
+func (t *TerraformTool) summarize(ctx context.Context, args map[string]any) (string, error) {
+	path, _ := args["path"].(string)
+	if strings.TrimSpace(path) == "" {
+		return "", fmt.Errorf("path is required for summarize")
+	}
+	workspace := tenant.Workspace(ctx, t.workspaceDir)
+	full, err := safePath(workspace, path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	summary, err := parsePlan(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w (upload the output of terraform show -json)", path, err)
+	}
+	return summary.report("Plan in " + displayPath(workspace, full)), nil
+}
+
+// apply applies a saved plan the user approved, and records it in the
+// audit log.
+func (t *TerraformTool) apply(ctx context.Context, args map[string]any) (string, error) {
+	if !approved(ctx, t.Name()) {
+		return "", fmt.Errorf("applying a plan needs the user's approval, which the apply call asks for")
+	}
+	plan, err := t.plan(args)
+	if err != nil {
+		return "", err
+	}
+	// Claim the plan, so it can only be applied once
+	t.mu.Lock()
+	if t.plans[plan.ID] != plan {
+		t.mu.Unlock()
+		return "", fmt.Errorf("plan #%d was already applied", plan.ID)
+	}
+	delete(t.plans, plan.ID)
+	t.mu.Unlock()
+	defer os.Remove(plan.File)
+
+	user, _ := auth.UserFrom(ctx)
+	log.Printf("%s apply plan #%d to %s by %s", terraformLogPrefix, plan.ID, plan.Dir.Name, user.UserName)
+	out, err := runTerraform(ctx, plan.Dir, "apply", "-input=false", "-no-color", "-lock-timeout=60s", plan.File)
+
+	record := applyRecord{
+		Time:    time.Now().UTC(),
+		User:    user.UserName,
+		UserID:  user.ID,
+		Dir:     plan.Dir.Name,
+		Plan:    plan.ID,
+		Changes: plan.Summary.counts(),
+		Success: err == nil,
+	}
+	if err != nil {
+		record.ErrorMsg = err.Error()
+	}
+	if auditErr := t.writeAudit(record); auditErr != nil {
+		log.Printf("%s audit log: %v", terraformLogPrefix, auditErr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("applying plan #%d to %s: %w", plan.ID, plan.Dir.Name, err)
+	}
+	return fmt.Sprintf("✅ Applied plan #%d to %s\n\n%s", plan.ID, plan.Dir.Name, lastLines(string(out), maxTerraformOutput)), nil
+}
+
+// dir returns the directory named by the dir argument, or the only one.
+func (t *TerraformTool) dir(args map[string]any) (TerraformDir, error) {
+	name, _ := args["dir"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" && len(t.dirs) == 1 {
+		return t.dirs[0], nil
+	}
+	for _, dir := range t.dirs {
+		if dir.Name == name || dir.Path == name {
+			return dir, nil
+		}
+	}
+	if name == "" {
+		return TerraformDir{}, fmt.Errorf("dir is required (one of %s)", t.dirNames())
+	}
+	return TerraformDir{}, fmt.Errorf("%s is not a configured Terraform directory (use %s)", name, t.dirNames())
+}
+
+func (t *TerraformTool) dirNames() string {
+	names := make([]string, len(t.dirs))
+	for i, dir := range t.dirs {
+		names[i] = dir.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// plan returns the saved plan named by the plan_id argument.
+func (t *TerraformTool) plan(args map[string]any) (*terraformPlan, error) {
+	id, ok := args["plan_id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("plan_id is required for apply")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune()
+	plan, ok := t.plans[int(id)]
+	if !ok {
+		return nil, fmt.Errorf("there's no plan #%d to apply; plans expire after an hour and are applied once, so plan again", int(id))
+	}
+	return plan, nil
+}
+
+// prune drops expired plans. The caller must hold t.mu.
+func (t *TerraformTool) prune() {
+	for id, plan := range t.plans {
+		if time.Since(plan.Created) > terraformPlanTTL {
+			os.Remove(plan.File)
+			delete(t.plans, id)
+		}
+	}
+}
+
+// writeAudit appends the record to the audit log.
+func (t *TerraformTool) writeAudit(record applyRecord) error {
+	if t.auditLog == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	t.auditMu.Lock()
+	defer t.auditMu.Unlock()
+
+	f, err := os.OpenFile(t.auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runTerraform runs a terraform command in dir and returns its output. A
+// failure's error carries the end of what terraform printed.
+func runTerraform(ctx context.Context, dir TerraformDir, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, terraformTimeout)
+	defer cancel()
+
+	log.Printf("%s %s: terraform %s", terraformLogPrefix, dir.Name, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, "terraform", args...)
+	cmd.Dir = dir.Path
+	cmd.Env = append(os.Environ(), "TF_IN_AUTOMATION=1", "TF_INPUT=0")
+	isolate(ctx, cmd, "terraform")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	if err != nil {
+		log.Printf("%s %s FAILED (%v) - %v", terraformLogPrefix, args[0], time.Since(start).Round(time.Millisecond), err)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("terraform %s timed out after %v", args[0], terraformTimeout)
+		}
+		output := strings.TrimSpace(stderr.String())
+		if output == "" {
+			output = strings.TrimSpace(stdout.String())
+		}
+		if output == "" {
+			return nil, fmt.Errorf("terraform %s: %w", args[0], err)
+		}
+		return nil, fmt.Errorf("terraform %s: %w\n%s", args[0], err, lastLines(output, maxTerraformOutput))
+	}
+	log.Printf("%s %s OK (%v)", terraformLogPrefix, args[0], time.Since(start).Round(time.Millisecond))
+	return stdout.Bytes(), nil
+}
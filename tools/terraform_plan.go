@@ -0,0 +1,278 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	maxPlanChangesListed = 20 // Per kind of change
+	maxPlanAttributes    = 5  // Changed attributes named per resource
+	manyDestroys         = 5  // Destroying at least this many is a risk of its own
+)
+
+// statefulTypes are parts of resource types that hold data, which is lost
+// when they're destroyed or replaced.
+var statefulTypes = []string{
+	"_db_", "database", "rds_", "sql", "s3_bucket", "storage_bucket", "storage_account",
+	"_disk", "volume", "ebs_", "efs_", "file_system", "filesystem", "dynamodb", "bigquery",
+	"bigtable", "spanner", "cosmosdb", "mongodb", "redis", "elasticache", "opensearch",
+	"elasticsearch", "kms_", "key_vault", "secret", "snapshot", "backup",
+}
+
+// accessTypes are parts of resource types that control who or what can
+// reach something.
+var accessTypes = []string{
+	"iam", "security_group", "firewall", "_policy", "_role", "acl", "network_security",
+	"permission", "access", "_grant", "key_pair",
+}
+
+// tfPlan is the part of terraform show -json's output for a plan the
+// summary reads.
+type tfPlan struct {
+	FormatVersion   string `json:"format_version"`
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Mode    string `json:"mode"`
+		Type    string `json:"type"`
+		Change  struct {
+			Actions      []string       `json:"actions"`
+			Before       map[string]any `json:"before"`
+			After        map[string]any `json:"after"`
+			AfterUnknown map[string]any `json:"after_unknown"`
+			ReplacePaths [][]any        `json:"replace_paths"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+	ResourceDrift []json.RawMessage `json:"resource_drift"`
+	OutputChanges map[string]struct {
+		Actions []string `json:"actions"`
+	} `json:"output_changes"`
+	Errored bool `json:"errored"`
+}
+
+// planChange is one resource a plan changes.
+type planChange struct {
+	Address    string
+	Type       string
+	Action     string   // create, update, replace, or delete
+	Attributes []string // Changed attributes, or those forcing a replacement
+}
+
+// planSummary is what a plan does, by kind of change.
+type planSummary struct {
+	Changes []planChange
+	Drift   int      // Resources changed outside Terraform
+	Outputs []string // Outputs that change
+	Errored bool     // The plan is incomplete
+}
+
+// parsePlan reads a plan from terraform show -json.
+func parsePlan(data []byte) (planSummary, error) {
+	var plan tfPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return planSummary{}, fmt.Errorf("parsing plan: %w", err)
+	}
+	if plan.FormatVersion == "" {
+		return planSummary{}, fmt.Errorf("not a Terraform plan")
+	}
+
+	summary := planSummary{Drift: len(plan.ResourceDrift), Errored: plan.Errored}
+	for _, rc := range plan.ResourceChanges {
+		if rc.Mode == "data" {
+			continue
+		}
+		change := planChange{Address: rc.Address, Type: rc.Type, Action: planAction(rc.Change.Actions)}
+		switch change.Action {
+		case "":
+			continue
+		case "replace":
+			for _, path := range rc.Change.ReplacePaths {
+				if len(path) > 0 {
+					change.Attributes = append(change.Attributes, fmt.Sprint(path[0]))
+				}
+			}
+		case "update":
+			change.Attributes = changedAttributes(rc.Change.Before, rc.Change.After, rc.Change.AfterUnknown)
+		}
+		slices.Sort(change.Attributes)
+		change.Attributes = slices.Compact(change.Attributes)
+		summary.Changes = append(summary.Changes, change)
+	}
+	for name, oc := range plan.OutputChanges {
+		if planAction(oc.Actions) != "" {
+			summary.Outputs = append(summary.Outputs, name)
+		}
+	}
+	slices.Sort(summary.Outputs)
+	return summary, nil
+}
+
+// planAction names a change's actions: a delete with a create is a
+// replacement, in either order. No-ops and reads are empty.
+func planAction(actions []string) string {
+	switch {
+	case slices.Contains(actions, "delete") && slices.Contains(actions, "create"):
+		return "replace"
+	case slices.Contains(actions, "delete"):
+		return "delete"
+	case slices.Contains(actions, "create"):
+		return "create"
+	case slices.Contains(actions, "update"):
+		return "update"
+	}
+	return ""
+}
+
+// changedAttributes lists the top-level attributes an update changes,
+// including those only known after applying.
+func changedAttributes(before, after, unknown map[string]any) []string {
+	var names []string
+	for name, value := range after {
+		if old, ok := before[name]; !ok || fmt.Sprint(old) != fmt.Sprint(value) {
+			names = append(names, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name, value := range unknown {
+		if value == true {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// count returns how many changes have the action.
+func (s planSummary) count(action string) int {
+	n := 0
+	for _, c := range s.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (s planSummary) empty() bool {
+	return len(s.Changes) == 0 && len(s.Outputs) == 0
+}
+
+// counts summarizes the plan in a phrase like terraform's own.
+func (s planSummary) counts() string {
+	parts := []string{
+		fmt.Sprintf("%d to add", s.count("create")),
+		fmt.Sprintf("%d to change", s.count("update")),
+	}
+	if n := s.count("replace"); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d to replace", n))
+	}
+	parts = append(parts, fmt.Sprintf("%d to destroy", s.count("delete")))
+	return strings.Join(parts, ", ")
+}
+
+// risks calls out the changes worth a second look: data that may be lost,
+// access control that changes, many destroys, and drift.
+func (s planSummary) risks() []string {
+	var risks []string
+	for _, c := range s.Changes {
+		destroys := c.Action == "delete" || c.Action == "replace"
+		switch {
+		case destroys && typeMatches(c.Type, statefulTypes):
+			verb := "destroyed"
+			if c.Action == "replace" {
+				verb = "replaced"
+				if len(c.Attributes) > 0 {
+					verb += " (" + attributeList(c.Attributes) + " forces it)"
+				}
+			}
+			risks = append(risks, fmt.Sprintf("🔥 %s will be %s; it may hold data", c.Address, verb))
+		case typeMatches(c.Type, accessTypes):
+			risks = append(risks, fmt.Sprintf("🔐 %s changes access control (%s)", c.Address, c.Action))
+		}
+	}
+	if n := s.count("delete") + s.count("replace"); n >= manyDestroys {
+		risks = append(risks, fmt.Sprintf("🗑 %d resources are destroyed or replaced", n))
+	}
+	if s.Drift > 0 {
+		risks = append(risks, fmt.Sprintf("🔀 %d resource(s) changed outside Terraform since the last apply", s.Drift))
+	}
+	if s.Errored {
+		risks = append(risks, "❌ The plan errored and is incomplete")
+	}
+	return risks
+}
+
+func typeMatches(resourceType string, parts []string) bool {
+	return slices.ContainsFunc(parts, func(part string) bool { return strings.Contains(resourceType, part) })
+}
+
+func attributeList(names []string) string {
+	if len(names) > maxPlanAttributes {
+		return strings.Join(names[:maxPlanAttributes], ", ") + fmt.Sprintf(" and %d more", len(names)-maxPlanAttributes)
+	}
+	return strings.Join(names, ", ")
+}
+
+// report formats the summary under a title such as "Plan #3 for prod".
+func (s planSummary) report(title string) string {
+	var sb strings.Builder
+	if s.empty() {
+		fmt.Fprintf(&sb, "✅ %s: no changes; the infrastructure matches the configuration.", title)
+		if s.Drift > 0 {
+			fmt.Fprintf(&sb, "\n🔀 %d resource(s) changed outside Terraform since the last apply.", s.Drift)
+		}
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "🏗 %s: %s.\n", title, s.counts())
+	if risks := s.risks(); len(risks) > 0 {
+		sb.WriteString("\n⚠️ Risks:\n")
+		for _, risk := range risks {
+			sb.WriteString("• " + risk + "\n")
+		}
+	}
+
+	sections := []struct {
+		action, heading string
+	}{
+		{"create", "➕ Add"},
+		{"update", "🔧 Change"},
+		{"replace", "♻️ Replace"},
+		{"delete", "🗑 Destroy"},
+	}
+	for _, section := range sections {
+		n := s.count(section.action)
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n%s (%d):\n", section.heading, n)
+		listed := 0
+		for _, c := range s.Changes {
+			if c.Action != section.action {
+				continue
+			}
+			if listed == maxPlanChangesListed {
+				fmt.Fprintf(&sb, "… and %d more\n", n-listed)
+				break
+			}
+			listed++
+			switch {
+			case len(c.Attributes) == 0:
+				fmt.Fprintf(&sb, "• %s\n", c.Address)
+			case c.Action == "replace":
+				fmt.Fprintf(&sb, "• %s (forced by %s)\n", c.Address, attributeList(c.Attributes))
+			default:
+				fmt.Fprintf(&sb, "• %s: %s\n", c.Address, attributeList(c.Attributes))
+			}
+		}
+	}
+	if len(s.Outputs) > 0 {
+		fmt.Fprintf(&sb, "\nOutputs changing: %s\n", attributeList(s.Outputs))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
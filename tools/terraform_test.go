@@ -0,0 +1,47 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTerraformApplyNeedsFormApproval(t *testing.T) {
+	tool := NewTerraformTool(nil, t.TempDir())
+	tool.plans[1] = &terraformPlan{ID: 1, Dir: TerraformDir{Name: "prod"}, Created: time.Now()}
+	args := map[string]any{"operation": "apply", "plan_id": float64(1), "approval": terraformApproval}
+
+	_, err := tool.Execute(context.Background(), args)
+	if err == nil || !strings.Contains(err.Error(), "approval") {
+		t.Fatalf("apply on the model's own approval: %v, want an approval error", err)
+	}
+	if tool.plans[1] == nil {
+		t.Fatal("unapproved apply claimed the plan")
+	}
+
+	form := FormFor(context.Background(), tool, args)
+	if form == nil {
+		t.Fatal("no approval form for an apply")
+	}
+	if field, _ := form.Field(); len(field.Choices) != 1 || field.Choices[0] != terraformApproval {
+		t.Errorf("approval field offers %v, want [%s]", field.Choices, terraformApproval)
+	}
+}
+
+func TestTerraformFormBlocksUnknownPlan(t *testing.T) {
+	tool := NewTerraformTool(nil, t.TempDir())
+	args := map[string]any{"operation": "apply", "plan_id": float64(7)}
+
+	form := FormFor(context.Background(), tool, args)
+	if form == nil {
+		t.Fatal("no form for an apply of a missing plan")
+	}
+	field, _ := form.Field()
+	if len(field.Choices) != 0 || !strings.Contains(field.Prompt, "no plan #7") {
+		t.Errorf("field = %+v, want the plan error with nothing to approve", field)
+	}
+	if err := form.Answer(terraformApproval); err == nil {
+		t.Error("a missing plan was approved")
+	}
+}
@@ -24,6 +24,14 @@ func (t *TimeTool) Parameters() map[string]any {
 	}
 }
 
+func (t *TimeTool) Metadata() Metadata {
+	return Metadata{ReadOnly: true, Cost: CostLow}
+}
+
 func (t *TimeTool) Execute(_ context.Context, _ map[string]any) (string, error) {
 	return time.Now().Format("Monday, January 2, 2006 at 3:04 PM MST"), nil
 }
+
+func (t *TimeTool) BenchArgs() (map[string]any, string) {
+	return map[string]any{}, ""
+}
@@ -1,7 +1,13 @@
 // Package tools provides the tool interface and implementations for the agent.
 package tools
 
-import "context"
+import (
+	"context"
+
+	"telegram-bot/schedule"
+	"telegram-bot/store"
+	"telegram-bot/tenant"
+)
 
 // Tool defines the interface that all tools must implement.
 type Tool interface {
@@ -18,3 +24,149 @@ type Tool interface {
 	// The context should be used for cancellation and timeouts.
 	Execute(ctx context.Context, args map[string]any) (string, error)
 }
+
+// Benchmarkable is implemented by tools that can be exercised with a canned,
+// side-effect-free payload to verify their environment is healthy.
+type Benchmarkable interface {
+	// BenchArgs returns the arguments to execute with and a substring the
+	// output must contain for the run to count as a success ("" accepts any output).
+	BenchArgs() (args map[string]any, expect string)
+}
+
+// Cost is a rough indication of how expensive a tool call is.
+type Cost int
+
+const (
+	CostLow    Cost = iota // Local and instant, e.g. reading the clock
+	CostMedium             // Network calls or short computations
+	CostHigh               // Long-running processes or heavy compute
+)
+
+// Metadata describes a tool's cost and side effects.
+type Metadata struct {
+	// ReadOnly tools never change state outside the bot.
+	ReadOnly bool
+	// Dangerous tools can cause damage that is hard to undo (deleting files,
+	// running arbitrary commands, modifying registries).
+	Dangerous bool
+	Cost      Cost
+}
+
+// Describer is implemented by tools that report their metadata.
+type Describer interface {
+	Metadata() Metadata
+}
+
+// AttachmentKind says how an attachment should be presented.
+type AttachmentKind int
+
+const (
+	AttachDocument AttachmentKind = iota
+	AttachPhoto
+)
+
+// Attachment is a file produced by a tool for the user.
+type Attachment struct {
+	Name string
+	Data []byte
+	Kind AttachmentKind
+}
+
+// Result is a tool result with optional attachments.
+type Result struct {
+	Text        string
+	Attachments []Attachment
+
+	// Form is set instead of running the tool when the call is missing
+	// arguments the user has to fill in (see Formable).
+	Form *Form
+}
+
+// RichTool is implemented by tools that can return attachments.
+type RichTool interface {
+	ExecuteRich(ctx context.Context, args map[string]any) (*Result, error)
+}
+
+// StreamingTool is implemented by tools that can report output while they run.
+// Chunks are sent on the channel as they become available; the tool never
+// closes it. The returned result holds the complete output.
+type StreamingTool interface {
+	ExecuteStream(ctx context.Context, args map[string]any, chunks chan<- string) (*Result, error)
+}
+
+// Host gives tools access to the bot services they need for background work.
+type Host struct {
+	Store     *store.Store
+	Scheduler *schedule.Scheduler
+
+	// Tenants hands out users' private spaces, for tools that keep each
+	// tenant's state in the tenant's encrypted store. It is nil unless users
+	// are isolated from each other.
+	Tenants *tenant.Manager
+
+	// Send delivers a message to a chat outside of any request. During the
+	// chat's quiet hours it is held until they end. SendUrgent is for
+	// messages that can't wait, such as flight changes; they are only held
+	// if the chat asked for that too.
+	Send       func(chatID int64, text string)
+	SendUrgent func(chatID int64, text string)
+
+	// IsMember reports whether a user belongs to a group chat. It is nil
+	// when the transport can't tell.
+	IsMember func(chatID, userID int64) (bool, error)
+
+	// SendPoll posts a native poll and returns its message and poll IDs;
+	// StopPoll closes it and returns the final counts. Both are nil when
+	// the transport has no polls.
+	SendPoll func(chatID int64, poll NewPoll) (messageID int, pollID string, err error)
+	StopPoll func(chatID int64, messageID int) (PollCounts, error)
+
+	// ChatAdmin pins messages and changes chats' info. It is nil when the
+	// transport can't.
+	ChatAdmin ChatAdmin
+}
+
+// Background is implemented by tools that do work outside of requests,
+// such as polling for changes and notifying chats. The bot calls Start
+// once before it begins handling messages.
+type Background interface {
+	Start(host Host) error
+}
+
+// MetadataOf returns the tool's metadata, assuming an unknown tool is a
+// mutating, medium-cost one.
+func MetadataOf(tool Tool) Metadata {
+	if d, ok := As[Describer](tool); ok {
+		return d.Metadata()
+	}
+	return Metadata{Cost: CostMedium}
+}
+
+// Run executes any tool and returns a rich result, adapting plain tools.
+func Run(ctx context.Context, tool Tool, args map[string]any) (*Result, error) {
+	if rt, ok := tool.(RichTool); ok {
+		return rt.ExecuteRich(ctx, args)
+	}
+	text, err := tool.Execute(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Text: text}, nil
+}
+
+// Stream executes any tool, sending output chunks as they arrive. Tools
+// that can't stream send their whole output as a single chunk when done.
+func Stream(ctx context.Context, tool Tool, args map[string]any, chunks chan<- string) (*Result, error) {
+	if st, ok := tool.(StreamingTool); ok {
+		return st.ExecuteStream(ctx, args, chunks)
+	}
+	result, err := Run(ctx, tool, args)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case chunks <- result.Text:
+	case <-ctx.Done():
+	}
+	return result, nil
+}
@@ -0,0 +1,462 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"telegram-bot/auth"
+)
+
+const (
+	trackingStoreKey          = "tracking_watches"
+	trackingLogPrefix         = "[tracking]"
+	trackingTimeout           = 30 * time.Second
+	defaultTrackingInterval   = 30 * time.Minute
+	maxTrackingWatchesPerChat = 20
+	defaultFlightAPIURL       = "http://api.aviationstack.com/v1/flights" // The free plan is HTTP only
+	defaultParcelAPIURL       = "https://api.17track.net/track/v2.2"
+)
+
+// TrackingConfig selects the services behind the tracking tool. Either
+// kind of lookup is offered only if its service is configured.
+type TrackingConfig struct {
+	FlightKey string // aviationstack access key
+	FlightURL string // Empty uses aviationstack's API
+	ParcelKey string // 17TRACK API key
+	ParcelURL string // Empty uses 17TRACK's v2.2 API
+
+	// Carriers are tracked with their own APIs instead of 17TRACK, keyed by
+	// lowercase carrier name.
+	Carriers map[string]CarrierAPI
+
+	WatchInterval time.Duration // How often watched flights and parcels are checked
+}
+
+// trackingStatus is the latest state of a flight or parcel.
+type trackingStatus struct {
+	Summary string // One line that changes whenever the status does
+	Details string // Shown in replies below the summary
+	Done    bool   // Landed, cancelled, or delivered: nothing more to watch
+}
+
+// trackingWatch is a subscription to status changes of a flight or parcel.
+type trackingWatch struct {
+	ID        int       `json:"id"`
+	ChatID    int64     `json:"chat_id"`
+	Kind      string    `json:"kind"` // flight or parcel
+	Number    string    `json:"number"`
+	Carrier   string    `json:"carrier,omitempty"`
+	Status    string    `json:"status"` // Last summary seen
+	Created   time.Time `json:"created"`
+	Checked   time.Time `json:"checked"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func (w *trackingWatch) String() string {
+	if w.Kind == "flight" {
+		return "flight " + w.Number
+	}
+	if w.Carrier != "" {
+		return fmt.Sprintf("parcel %s (%s)", w.Number, w.Carrier)
+	}
+	return "parcel " + w.Number
+}
+
+type trackingState struct {
+	NextID  int             `json:"next_id"`
+	Watches []trackingWatch `json:"watches"`
+}
+
+// TrackingTool looks up flight status by flight number and parcel status by
+// tracking number, and can watch either and message the chat when the
+// status changes.
+type TrackingTool struct {
+	cfg        TrackingConfig
+	httpClient *http.Client
+
+	host    *Host // Set by Start; nil when background work is unavailable
+	watchMu sync.Mutex
+	watches trackingState
+}
+
+// NewTrackingTool creates a tracking tool. It fails if neither flights nor
+// parcels can be looked up.
+func NewTrackingTool(cfg TrackingConfig) (*TrackingTool, error) {
+	if cfg.FlightKey == "" && cfg.ParcelKey == "" && len(cfg.Carriers) == 0 {
+		return nil, fmt.Errorf("no flight or parcel tracking API is configured")
+	}
+	if cfg.FlightURL == "" {
+		cfg.FlightURL = defaultFlightAPIURL
+	}
+	if cfg.ParcelURL == "" {
+		cfg.ParcelURL = defaultParcelAPIURL
+	}
+	if cfg.WatchInterval <= 0 {
+		cfg.WatchInterval = defaultTrackingInterval
+	}
+	return &TrackingTool{cfg: cfg, httpClient: &http.Client{Timeout: trackingTimeout}}, nil
+}
+
+func (t *TrackingTool) Name() string {
+	return "tracking"
+}
+
+func (t *TrackingTool) Description() string {
+	var kinds []string
+	if t.flights() {
+		kinds = append(kinds, `operation=flight with number (e.g. "LH400") and optional date shows a flight's status, gates, and delays.`)
+	}
+	if t.parcels() {
+		kinds = append(kinds, `operation=parcel with number (the tracking number) and optional carrier shows where a package is.`)
+	}
+	return fmt.Sprintf(`Track flights and packages.
+
+%s
+operation=watch with kind (flight or parcel) and number messages this chat whenever the
+status changes, until it lands or is delivered. operation=watches lists this chat's watches;
+operation=unwatch with watch_id stops one.`, strings.Join(kinds, "\n"))
+}
+
+func (t *TrackingTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"flight", "parcel", "watch", "watches", "unwatch"},
+				"description": "What to do",
+			},
+			"number": map[string]any{
+				"type":        "string",
+				"description": "Flight number (LH400, UA 90) or parcel tracking number",
+			},
+			"kind": map[string]any{
+				"type":        "string",
+				"enum":        []string{"flight", "parcel"},
+				"description": "For watch: whether number is a flight or a parcel",
+			},
+			"carrier": map[string]any{
+				"type":        "string",
+				"description": "For parcels: the carrier (dhl, ups, ...), if known",
+			},
+			"date": map[string]any{
+				"type":        "string",
+				"description": "For flights: the date of departure (YYYY-MM-DD); default today",
+			},
+			"watch_id": map[string]any{
+				"type":        "number",
+				"description": "For unwatch: the watch number",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (t *TrackingTool) Metadata() Metadata {
+	return Metadata{Cost: CostMedium}
+}
+
+// FormFields asks for the flight or tracking number, and for a watch
+// whether it's a flight or a parcel, rather than letting the model guess.
+func (t *TrackingTool) FormFields(ctx context.Context, args map[string]any) []FormField {
+	operation, _ := args["operation"].(string)
+	var fields []FormField
+	switch operation {
+	case "watch":
+		if missingArg(args, "kind") {
+			fields = append(fields, schemaField(t, "kind", "Is it a flight or a parcel?"))
+		}
+		if missingArg(args, "number") {
+			fields = append(fields, schemaField(t, "number", "What's the flight or tracking number?"))
+		}
+	case "flight":
+		if missingArg(args, "number") {
+			fields = append(fields, schemaField(t, "number", "Which flight? (e.g. LH400)"))
+		}
+	case "parcel":
+		if missingArg(args, "number") {
+			fields = append(fields, schemaField(t, "number", "What's the tracking number?"))
+		}
+	}
+	return fields
+}
+
+func (t *TrackingTool) flights() bool {
+	return t.cfg.FlightKey != ""
+}
+
+func (t *TrackingTool) parcels() bool {
+	return t.cfg.ParcelKey != "" || len(t.cfg.Carriers) > 0
+}
+
+func (t *TrackingTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	operation, _ := args["operation"].(string)
+	number, _ := args["number"].(string)
+	carrier, _ := args["carrier"].(string)
+	number = normalizeTrackingNumber(number)
+	carrier = strings.ToLower(strings.TrimSpace(carrier))
+
+	switch operation {
+	case "flight", "parcel":
+		if number == "" {
+			return "", fmt.Errorf("number is required")
+		}
+		date, _ := args["date"].(string)
+		status, err := t.lookup(ctx, operation, number, carrier, strings.TrimSpace(date))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(status.Summary + "\n" + status.Details), nil
+	case "watch":
+		kind, _ := args["kind"].(string)
+		return t.watch(ctx, kind, number, carrier)
+	case "watches":
+		return t.listWatches(ctx)
+	case "unwatch":
+		return t.unwatch(ctx, args)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// lookup fetches the current status of a flight or parcel.
+func (t *TrackingTool) lookup(ctx context.Context, kind, number, carrier, date string) (*trackingStatus, error) {
+	switch kind {
+	case "flight":
+		if !t.flights() {
+			return nil, fmt.Errorf("flight tracking is not configured")
+		}
+		return t.flightStatus(ctx, number, date)
+	case "parcel":
+		if api, ok := t.cfg.Carriers[carrier]; ok {
+			return t.carrierStatus(ctx, carrier, api, number)
+		}
+		if t.cfg.ParcelKey == "" {
+			names := make([]string, 0, len(t.cfg.Carriers))
+			for name := range t.cfg.Carriers {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("set carrier to one of: %s", strings.Join(names, ", "))
+		}
+		return t.parcelStatus(ctx, number, carrier)
+	default:
+		return nil, fmt.Errorf("kind must be flight or parcel")
+	}
+}
+
+// Start loads saved watches and schedules checking them.
+func (t *TrackingTool) Start(host Host) error {
+	t.watchMu.Lock()
+	defer t.watchMu.Unlock()
+
+	t.host = &host
+	if _, err := host.Store.Get(trackingStoreKey, &t.watches); err != nil {
+		return fmt.Errorf("loading tracking watches: %w", err)
+	}
+	host.Scheduler.Every("tracking watches", t.cfg.WatchInterval, t.pollWatches)
+	log.Printf("%s watching %d flights and parcels every %v", trackingLogPrefix, len(t.watches.Watches), t.cfg.WatchInterval)
+	return nil
+}
+
+func (t *TrackingTool) watch(ctx context.Context, kind, number, carrier string) (string, error) {
+	if t.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("watch needs a chat to notify")
+	}
+	// Watches keep calling paid APIs on the bot's behalf, so guests can't create them
+	if auth.RoleFrom(ctx) < auth.Trusted {
+		return "", fmt.Errorf("only trusted users can watch flights and parcels")
+	}
+	if number == "" {
+		return "", fmt.Errorf("number is required")
+	}
+
+	// Look it up now so the number is known to work, and as the baseline
+	status, err := t.lookup(ctx, kind, number, carrier, "")
+	if err != nil {
+		return "", err
+	}
+	if status.Done {
+		return fmt.Sprintf("%s\n\nNothing to watch: it has already finished.", status.Summary), nil
+	}
+
+	t.watchMu.Lock()
+	defer t.watchMu.Unlock()
+
+	count := 0
+	for _, w := range t.watches.Watches {
+		if w.ChatID != chatID {
+			continue
+		}
+		if w.Kind == kind && w.Number == number {
+			return fmt.Sprintf("Already watching %s (watch #%d)", w.String(), w.ID), nil
+		}
+		count++
+	}
+	if count >= maxTrackingWatchesPerChat {
+		return "", fmt.Errorf("this chat already has %d tracking watches; remove one with unwatch first", count)
+	}
+
+	now := time.Now().UTC()
+	t.watches.NextID++
+	w := trackingWatch{
+		ID:      t.watches.NextID,
+		ChatID:  chatID,
+		Kind:    kind,
+		Number:  number,
+		Carrier: carrier,
+		Status:  status.Summary,
+		Created: now,
+		Checked: now,
+	}
+	t.watches.Watches = append(t.watches.Watches, w)
+	if err := t.host.Store.Save(trackingStoreKey, t.watches); err != nil {
+		return "", err
+	}
+
+	log.Printf("%s watch #%d for chat %d: %s", trackingLogPrefix, w.ID, chatID, w.String())
+	return fmt.Sprintf("👀 Watch #%d: %s\n%s\n\nI'll check every %v and message this chat when it changes.",
+		w.ID, w.String(), status.Summary, t.cfg.WatchInterval), nil
+}
+
+func (t *TrackingTool) unwatch(ctx context.Context, args map[string]any) (string, error) {
+	if t.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, _ := ChatFrom(ctx)
+	id, ok := args["watch_id"].(float64)
+	if !ok {
+		return "", fmt.Errorf("watch_id is required for unwatch (see operation=watches)")
+	}
+
+	t.watchMu.Lock()
+	defer t.watchMu.Unlock()
+
+	for i, w := range t.watches.Watches {
+		if w.ID != int(id) || w.ChatID != chatID {
+			continue
+		}
+		t.watches.Watches = slices.Delete(t.watches.Watches, i, i+1)
+		if err := t.host.Store.Save(trackingStoreKey, t.watches); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Stopped watching %s", w.String()), nil
+	}
+	return "", fmt.Errorf("no watch #%d in this chat", int(id))
+}
+
+func (t *TrackingTool) listWatches(ctx context.Context) (string, error) {
+	if t.host == nil {
+		return "", fmt.Errorf("watching is not available in this mode")
+	}
+	chatID, _ := ChatFrom(ctx)
+
+	t.watchMu.Lock()
+	defer t.watchMu.Unlock()
+
+	var b strings.Builder
+	for _, w := range t.watches.Watches {
+		if w.ChatID != chatID {
+			continue
+		}
+		fmt.Fprintf(&b, "#%d %s: %s (checked %s)\n", w.ID, w.String(), w.Status, w.Checked.Local().Format("Jan 2 15:04"))
+		if w.LastError != "" {
+			fmt.Fprintf(&b, "   ⚠️ last check failed: %s\n", w.LastError)
+		}
+	}
+	if b.Len() == 0 {
+		return "No watched flights or parcels in this chat.", nil
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// pollWatches checks every watch and notifies chats about status changes.
+// Watches that have landed or been delivered are removed after notifying.
+func (t *TrackingTool) pollWatches(ctx context.Context) error {
+	t.watchMu.Lock()
+	watches := slices.Clone(t.watches.Watches)
+	t.watchMu.Unlock()
+
+	var finished []int
+	for _, w := range watches {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if t.checkWatch(ctx, &w) {
+			finished = append(finished, w.ID)
+		}
+		t.updateWatch(&w)
+	}
+
+	t.watchMu.Lock()
+	defer t.watchMu.Unlock()
+	t.watches.Watches = slices.DeleteFunc(t.watches.Watches, func(w trackingWatch) bool {
+		return slices.Contains(finished, w.ID)
+	})
+	return t.host.Store.Save(trackingStoreKey, t.watches)
+}
+
+// checkWatch looks the watch up again and reports whether it is finished.
+// Lookup failures are recorded on the watch rather than reported to the owners.
+func (t *TrackingTool) checkWatch(ctx context.Context, w *trackingWatch) bool {
+	w.Checked = time.Now().UTC()
+	w.LastError = ""
+
+	status, err := t.lookup(ctx, w.Kind, w.Number, w.Carrier, "")
+	if err != nil {
+		log.Printf("%s watch #%d: %v", trackingLogPrefix, w.ID, err)
+		w.LastError = err.Error()
+		return false
+	}
+	if status.Summary == w.Status {
+		return false
+	}
+
+	w.Status = status.Summary
+	log.Printf("%s watch #%d: %s is now %q", trackingLogPrefix, w.ID, w.String(), status.Summary)
+	text := "🔔 " + status.Summary
+	if status.Details != "" {
+		text += "\n" + status.Details
+	}
+	if status.Done {
+		text += fmt.Sprintf("\n\n(watch #%d finished)", w.ID)
+	} else {
+		text += fmt.Sprintf("\n\n(watch #%d)", w.ID)
+	}
+	// Flight changes can't wait for the morning; parcels can
+	send := t.host.Send
+	if w.Kind == "flight" && t.host.SendUrgent != nil {
+		send = t.host.SendUrgent
+	}
+	send(w.ChatID, text)
+	return status.Done
+}
+
+// updateWatch stores the result of a check, unless the watch was removed meanwhile.
+func (t *TrackingTool) updateWatch(w *trackingWatch) {
+	t.watchMu.Lock()
+	defer t.watchMu.Unlock()
+
+	for i := range t.watches.Watches {
+		if t.watches.Watches[i].ID == w.ID {
+			t.watches.Watches[i] = *w
+			return
+		}
+	}
+}
+
+// normalizeTrackingNumber removes the spaces people type into flight and
+// tracking numbers ("LH 400") and upper-cases them.
+func normalizeTrackingNumber(number string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(number), ""))
+}
@@ -0,0 +1,351 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"telegram-bot/redact"
+)
+
+// flightStatus looks a flight up with aviationstack. Without a date it
+// picks today's flight, or else the latest one the API returns.
+func (t *TrackingTool) flightStatus(ctx context.Context, number, date string) (*trackingStatus, error) {
+	query := url.Values{
+		"access_key":  {t.cfg.FlightKey},
+		"flight_iata": {number},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.cfg.FlightURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	type endpoint struct {
+		Airport   string `json:"airport"`
+		IATA      string `json:"iata"`
+		Terminal  string `json:"terminal"`
+		Gate      string `json:"gate"`
+		Baggage   string `json:"baggage"`
+		Delay     int    `json:"delay"` // Minutes
+		Scheduled string `json:"scheduled"`
+		Estimated string `json:"estimated"`
+		Actual    string `json:"actual"`
+	}
+	var result struct {
+		Data []struct {
+			FlightDate string   `json:"flight_date"`
+			Status     string   `json:"flight_status"`
+			Departure  endpoint `json:"departure"`
+			Arrival    endpoint `json:"arrival"`
+			Airline    struct {
+				Name string `json:"name"`
+			} `json:"airline"`
+		} `json:"data"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := doJSON(t.httpClient, req, &result); err != nil {
+		return nil, fmt.Errorf("looking up flight %s: %w", number, err)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("looking up flight %s: %s", number, result.Error.Message)
+	}
+
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+	found := -1
+	for i, f := range result.Data {
+		if f.FlightDate == date {
+			found = i
+			break
+		}
+		if found < 0 || f.FlightDate > result.Data[found].FlightDate {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("no flight %s found", number)
+	}
+	f := result.Data[found]
+
+	describe := func(label string, e endpoint) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s (%s)", label, e.Airport, e.IATA)
+		if e.Terminal != "" {
+			fmt.Fprintf(&b, ", terminal %s", e.Terminal)
+		}
+		if e.Gate != "" {
+			fmt.Fprintf(&b, ", gate %s", e.Gate)
+		}
+		if e.Baggage != "" {
+			fmt.Fprintf(&b, ", baggage belt %s", e.Baggage)
+		}
+		fmt.Fprintf(&b, ": scheduled %s", flightClock(e.Scheduled))
+		switch {
+		case e.Actual != "":
+			fmt.Fprintf(&b, ", actual %s", flightClock(e.Actual))
+		case e.Estimated != "" && e.Estimated != e.Scheduled:
+			fmt.Fprintf(&b, ", expected %s", flightClock(e.Estimated))
+		}
+		if e.Delay > 0 {
+			fmt.Fprintf(&b, " (%d min late)", e.Delay)
+		}
+		return b.String()
+	}
+
+	status := &trackingStatus{
+		Details: describe("🛫 From", f.Departure) + "\n" + describe("🛬 To", f.Arrival) + "\n(Local airport times)",
+		Done:    f.Status == "landed" || f.Status == "cancelled" || f.Status == "diverted",
+	}
+	// The summary changes when the status, a gate, or a delay does, which
+	// is what a watch should report
+	summary := fmt.Sprintf("✈️ %s %s on %s: %s", f.Airline.Name, number, f.FlightDate, f.Status)
+	if f.Departure.Gate != "" {
+		summary += ", departure gate " + f.Departure.Gate
+	}
+	if delay := max(f.Departure.Delay, f.Arrival.Delay); delay > 0 {
+		summary += fmt.Sprintf(", %d min late", delay)
+	}
+	status.Summary = summary
+	return status, nil
+}
+
+// flightClock shows the time of day of an aviationstack timestamp. The API
+// gives local airport times marked as UTC, so they are shown as they are.
+func flightClock(s string) string {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Format("Jan 2 15:04")
+	}
+	return s
+}
+
+// parcelStatus tracks a parcel with 17TRACK. Numbers must be registered
+// before they can be tracked; registering one twice is harmless.
+func (t *TrackingTool) parcelStatus(ctx context.Context, number, carrier string) (*trackingStatus, error) {
+	entry := map[string]any{"number": number}
+	if code, err := strconv.Atoi(carrier); err == nil {
+		entry["carrier"] = code // 17TRACK's numeric carrier code; names are detected automatically
+	}
+	if err := t.call17Track(ctx, "register", []any{entry}, nil); err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Accepted []struct {
+			Number    string `json:"number"`
+			TrackInfo *struct {
+				LatestStatus struct {
+					Status    string `json:"status"`
+					SubStatus string `json:"sub_status"`
+				} `json:"latest_status"`
+				LatestEvent *struct {
+					Time        string `json:"time_iso"`
+					Description string `json:"description"`
+					Location    string `json:"location"`
+				} `json:"latest_event"`
+				TimeMetrics struct {
+					EstimatedDelivery struct {
+						From string `json:"from"`
+					} `json:"estimated_delivery_date"`
+				} `json:"time_metrics"`
+			} `json:"track_info"`
+		} `json:"accepted"`
+		Rejected []struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"rejected"`
+	}
+	if err := t.call17Track(ctx, "gettrackinfo", []any{map[string]any{"number": number}}, &data); err != nil {
+		return nil, err
+	}
+	if len(data.Rejected) > 0 {
+		return nil, fmt.Errorf("tracking %s: %s", number, data.Rejected[0].Error.Message)
+	}
+	if len(data.Accepted) == 0 || data.Accepted[0].TrackInfo == nil {
+		return &trackingStatus{Summary: fmt.Sprintf("📦 %s: no tracking information yet (newly added numbers can take a few minutes)", number)}, nil
+	}
+
+	info := data.Accepted[0].TrackInfo
+	status := &trackingStatus{
+		Summary: fmt.Sprintf("📦 %s: %s", number, splitCamelCase(info.LatestStatus.Status)),
+		Done:    info.LatestStatus.Status == "Delivered",
+	}
+	if e := info.LatestEvent; e != nil {
+		status.Summary += " — " + e.Description
+		var details []string
+		if e.Location != "" {
+			details = append(details, "📍 "+e.Location)
+		}
+		if e.Time != "" {
+			details = append(details, "🕒 "+flightClock(e.Time))
+		}
+		status.Details = strings.Join(details, "\n")
+	}
+	if eta := info.TimeMetrics.EstimatedDelivery.From; eta != "" && !status.Done {
+		status.Details = strings.TrimSpace(status.Details + "\n🚚 Expected " + flightClock(eta))
+	}
+	return status, nil
+}
+
+// call17Track posts to one of 17TRACK's endpoints and decodes its data.
+func (t *TrackingTool) call17Track(ctx context.Context, endpoint string, body any, data any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshaling request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(t.cfg.ParcelURL, "/")+"/"+endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("17token", t.cfg.ParcelKey)
+
+	var result struct {
+		Code int             `json:"code"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := doJSON(t.httpClient, req, &result); err != nil {
+		return fmt.Errorf("calling 17TRACK %s: %w", endpoint, err)
+	}
+	if result.Code != 0 {
+		return fmt.Errorf("17TRACK %s returned code %d", endpoint, result.Code)
+	}
+	if data == nil {
+		return nil
+	}
+	if err := json.Unmarshal(result.Data, data); err != nil {
+		return fmt.Errorf("parsing 17TRACK %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// CarrierAPI describes a carrier's own tracking API: where to send the
+// number, and where in the JSON response to find the status. Paths are
+// dotted keys with numeric array indexes, e.g. "shipments.0.status.description".
+type CarrierAPI struct {
+	URL      string            `json:"url"` // {number} is replaced with the tracking number
+	Headers  map[string]string `json:"headers"`
+	Status   string            `json:"status"`
+	Location string            `json:"location"`
+	Time     string            `json:"time"`
+
+	// Delivered is "path=value": the parcel is delivered when the value at
+	// path equals value, ignoring case.
+	Delivered string `json:"delivered"`
+}
+
+// LoadCarriers reads carrier APIs from a JSON file mapping carrier names to
+// their settings. Header values may reference environment variables
+// ($DHL_API_KEY) so keys can stay out of the file; keys are registered
+// with the redactor.
+func LoadCarriers(path string) (map[string]CarrierAPI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading carriers: %w", err)
+	}
+	var carriers map[string]CarrierAPI
+	if err := json.Unmarshal(data, &carriers); err != nil {
+		return nil, fmt.Errorf("parsing carriers: %w", err)
+	}
+
+	result := make(map[string]CarrierAPI, len(carriers))
+	for name, api := range carriers {
+		if api.URL == "" || api.Status == "" {
+			return nil, fmt.Errorf("carrier %s needs a url and a status path", name)
+		}
+		for header, value := range api.Headers {
+			api.Headers[header] = os.ExpandEnv(value)
+			redact.RegisterHeader(header, api.Headers[header])
+		}
+		result[strings.ToLower(name)] = api
+	}
+	return result, nil
+}
+
+// carrierStatus tracks a parcel with a carrier's own API.
+func (t *TrackingTool) carrierStatus(ctx context.Context, carrier string, api CarrierAPI, number string) (*trackingStatus, error) {
+	u := strings.ReplaceAll(api.URL, "{number}", url.QueryEscape(number))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	for name, value := range api.Headers {
+		req.Header.Set(name, value)
+	}
+
+	var body any
+	if err := doJSON(t.httpClient, req, &body); err != nil {
+		return nil, fmt.Errorf("tracking with %s: %w", carrier, err)
+	}
+	state := jsonPath(body, api.Status)
+	if state == "" {
+		return nil, fmt.Errorf("%s returned no status for %s", carrier, number)
+	}
+
+	status := &trackingStatus{Summary: fmt.Sprintf("📦 %s (%s): %s", number, carrier, state)}
+	var details []string
+	if loc := jsonPath(body, api.Location); loc != "" {
+		details = append(details, "📍 "+loc)
+	}
+	if when := jsonPath(body, api.Time); when != "" {
+		details = append(details, "🕒 "+flightClock(when))
+	}
+	status.Details = strings.Join(details, "\n")
+	if path, want, ok := strings.Cut(api.Delivered, "="); ok {
+		status.Done = strings.EqualFold(jsonPath(body, path), want)
+	}
+	return status, nil
+}
+
+// jsonPath returns the value at a dotted path in decoded JSON as text, or
+// "" if there is nothing there.
+func jsonPath(v any, path string) string {
+	if path == "" {
+		return ""
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return ""
+			}
+			v = node[i]
+		default:
+			return ""
+		}
+	}
+	switch leaf := v.(type) {
+	case string:
+		return leaf
+	case float64, bool:
+		return fmt.Sprint(leaf)
+	default:
+		return ""
+	}
+}
+
+// splitCamelCase turns 17TRACK's status names ("InTransit") into words.
+func splitCamelCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && 'A' <= r && r <= 'Z' {
+			b.WriteByte(' ')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
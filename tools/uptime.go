@@ -0,0 +1,443 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uptimeStoreKey           = "uptime_monitors"
+	uptimeLogPrefix          = "[uptime]"
+	uptimeTimeout            = 10 * time.Second
+	uptimeWorkers            = 8
+	defaultUptimeInterval    = time.Minute
+	maxUptimeMonitorsPerChat = 50
+
+	// uptimeFailures is how many checks in a row must fail before a monitor
+	// counts as down, so a single dropped packet doesn't page anyone.
+	uptimeFailures = 2
+)
+
+// uptimeMonitor is a URL or TCP port checked on an interval.
+type uptimeMonitor struct {
+	ID       int           `json:"id"`
+	ChatID   int64         `json:"chat_id"`
+	Name     string        `json:"name"`
+	Target   string        `json:"target"` // http(s) URL, or host:port for TCP
+	Up       bool          `json:"up"`
+	Since    time.Time     `json:"since"` // When it last went up or down
+	Failures int           `json:"failures"`
+	LastErr  string        `json:"last_error,omitempty"`
+	Latency  time.Duration `json:"latency"` // Of the last successful check
+	Checked  time.Time     `json:"checked"`
+	Checks   int           `json:"checks"`
+	UpChecks int           `json:"up_checks"`
+	Created  time.Time     `json:"created"`
+}
+
+func (m *uptimeMonitor) String() string {
+	if m.Name != "" && m.Name != m.Target {
+		return fmt.Sprintf("%s (%s)", m.Name, m.Target)
+	}
+	return m.Target
+}
+
+type uptimeState struct {
+	NextID   int             `json:"next_id"`
+	Monitors []uptimeMonitor `json:"monitors"`
+}
+
+// UptimeTool checks personal services, web pages or TCP ports, on an
+// interval, and messages the chat that added them when one goes down or
+// comes back.
+type UptimeTool struct {
+	interval   time.Duration
+	httpClient *http.Client
+
+	host     *Host // Set by Start; nil when background work is unavailable
+	mu       sync.Mutex
+	monitors uptimeState
+}
+
+// NewUptimeTool creates an uptime monitor that checks every interval, or
+// every minute if it's zero.
+func NewUptimeTool(interval time.Duration) *UptimeTool {
+	if interval <= 0 {
+		interval = defaultUptimeInterval
+	}
+	return &UptimeTool{interval: interval, httpClient: &http.Client{Timeout: uptimeTimeout}}
+}
+
+func (u *UptimeTool) Name() string {
+	return "uptime"
+}
+
+func (u *UptimeTool) Description() string {
+	return fmt.Sprintf(`Monitor that services are up, like a small UptimeRobot. Targets are URLs (up when they answer
+with a status below 400) or host:port (up when the port accepts connections), checked every %v.
+operation=add with target and optional name starts monitoring and messages this chat when it goes
+down or comes back. operation=status summarizes this chat's monitors; operation=remove with
+monitor_id stops one.`, u.interval)
+}
+
+func (u *UptimeTool) Parameters() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"operation": map[string]any{
+				"type":        "string",
+				"enum":        []string{"add", "status", "remove"},
+				"description": "What to do",
+			},
+			"target": map[string]any{
+				"type":        "string",
+				"description": "For add: a URL (https://nas.home/health) or host:port (192.168.1.10:22)",
+			},
+			"name": map[string]any{
+				"type":        "string",
+				"description": "For add: a short name for the service, e.g. Home Assistant",
+			},
+			"monitor_id": map[string]any{
+				"type":        "number",
+				"description": "For remove: the monitor number",
+			},
+		},
+		"required": []string{"operation"},
+	}
+}
+
+func (u *UptimeTool) Metadata() Metadata {
+	return Metadata{Cost: CostLow}
+}
+
+// FormFields asks what to monitor rather than letting the model guess.
+func (u *UptimeTool) FormFields(ctx context.Context, args map[string]any) []FormField {
+	operation, _ := args["operation"].(string)
+	if operation == "add" && missingArg(args, "target") {
+		return []FormField{schemaField(u, "target", "What should I monitor? (a URL or host:port)")}
+	}
+	return nil
+}
+
+func (u *UptimeTool) Execute(ctx context.Context, args map[string]any) (string, error) {
+	if u.host == nil {
+		return "", fmt.Errorf("uptime monitoring is not available in this mode")
+	}
+	chatID, ok := ChatFrom(ctx)
+	if !ok {
+		return "", fmt.Errorf("uptime monitoring needs a chat to notify")
+	}
+	operation, _ := args["operation"].(string)
+	switch operation {
+	case "add":
+		target, _ := args["target"].(string)
+		name, _ := args["name"].(string)
+		return u.add(ctx, chatID, target, strings.TrimSpace(name))
+	case "status":
+		return u.status(chatID), nil
+	case "remove":
+		id, ok := args["monitor_id"].(float64)
+		if !ok {
+			return "", fmt.Errorf("monitor_id is required for remove (see operation=status)")
+		}
+		return u.remove(chatID, int(id))
+	default:
+		return "", fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// Start loads saved monitors and schedules checking them.
+func (u *UptimeTool) Start(host Host) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.host = &host
+	if _, err := host.Store.Get(uptimeStoreKey, &u.monitors); err != nil {
+		return fmt.Errorf("loading uptime monitors: %w", err)
+	}
+	host.Scheduler.Every("uptime monitors", u.interval, u.poll)
+	log.Printf("%s checking %d monitors every %v", uptimeLogPrefix, len(u.monitors.Monitors), u.interval)
+	return nil
+}
+
+// uptimeTarget normalizes what to check: URLs are kept, with https://
+// assumed for bare domains, and host:port means a TCP check.
+func uptimeTarget(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", fmt.Errorf("target is required")
+	}
+	if rest, ok := strings.CutPrefix(target, "tcp://"); ok {
+		target = rest
+	}
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return "", fmt.Errorf("%q isn't an http(s) URL", target)
+		}
+		return target, nil
+	}
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		if host == "" || port == "" {
+			return "", fmt.Errorf("%q needs both a host and a port", target)
+		}
+		return target, nil
+	}
+	return "https://" + target, nil
+}
+
+// checkTarget checks a target once, returning how long it took to answer.
+func (u *UptimeTool) checkTarget(ctx context.Context, target string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, uptimeTimeout)
+	defer cancel()
+	start := time.Now()
+
+	if !strings.Contains(target, "://") {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", target)
+		if err != nil {
+			return 0, fmt.Errorf("connecting: %w", err)
+		}
+		conn.Close()
+		return time.Since(start), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("User-Agent", "telegram-bot uptime monitor")
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+func (u *UptimeTool) add(ctx context.Context, chatID int64, target, name string) (string, error) {
+	target, err := uptimeTarget(target)
+	if err != nil {
+		return "", err
+	}
+
+	u.mu.Lock()
+	count := 0
+	for _, m := range u.monitors.Monitors {
+		if m.ChatID != chatID {
+			continue
+		}
+		if m.Target == target {
+			u.mu.Unlock()
+			return fmt.Sprintf("Already monitoring %s (monitor #%d)", m.String(), m.ID), nil
+		}
+		count++
+	}
+	u.mu.Unlock()
+	if count >= maxUptimeMonitorsPerChat {
+		return "", fmt.Errorf("this chat already has %d monitors; remove one first", count)
+	}
+
+	// Check it now, as the baseline and so typos show up at once
+	latency, checkErr := u.checkTarget(ctx, target)
+	now := time.Now().UTC()
+	m := uptimeMonitor{
+		ChatID:  chatID,
+		Name:    name,
+		Target:  target,
+		Up:      checkErr == nil,
+		Since:   now,
+		Latency: latency,
+		Checked: now,
+		Checks:  1,
+		Created: now,
+	}
+	if checkErr == nil {
+		m.UpChecks = 1
+	} else {
+		m.Failures = uptimeFailures
+		m.LastErr = checkErr.Error()
+	}
+
+	u.mu.Lock()
+	u.monitors.NextID++
+	m.ID = u.monitors.NextID
+	u.monitors.Monitors = append(u.monitors.Monitors, m)
+	err = u.host.Store.Save(uptimeStoreKey, u.monitors)
+	u.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("%s monitor #%d for chat %d: %s", uptimeLogPrefix, m.ID, chatID, m.String())
+	state := fmt.Sprintf("✅ It's up (%d ms).", latency.Milliseconds())
+	if checkErr != nil {
+		state = fmt.Sprintf("🔴 It's down right now: %v", checkErr)
+	}
+	return fmt.Sprintf("👀 Monitor #%d: %s\n%s\n\nI'll check every %v and message this chat when it goes down or comes back.",
+		m.ID, m.String(), state, u.interval), nil
+}
+
+func (u *UptimeTool) remove(chatID int64, id int) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for i, m := range u.monitors.Monitors {
+		if m.ID != id || m.ChatID != chatID {
+			continue
+		}
+		u.monitors.Monitors = slices.Delete(u.monitors.Monitors, i, i+1)
+		if err := u.host.Store.Save(uptimeStoreKey, u.monitors); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Stopped monitoring %s", m.String()), nil
+	}
+	return "", fmt.Errorf("no monitor #%d in this chat", id)
+}
+
+// status summarizes a chat's monitors, the ones that are down first.
+func (u *UptimeTool) status(chatID int64) string {
+	u.mu.Lock()
+	var monitors []uptimeMonitor
+	for _, m := range u.monitors.Monitors {
+		if m.ChatID == chatID {
+			monitors = append(monitors, m)
+		}
+	}
+	u.mu.Unlock()
+	if len(monitors) == 0 {
+		return "No monitors in this chat. Ask me to monitor a URL or host:port."
+	}
+	slices.SortStableFunc(monitors, func(a, b uptimeMonitor) int {
+		switch {
+		case a.Up == b.Up:
+			return 0
+		case !a.Up:
+			return -1
+		}
+		return 1
+	})
+
+	now := time.Now()
+	down := 0
+	var sb strings.Builder
+	for _, m := range monitors {
+		uptime := float64(m.UpChecks) / float64(max(m.Checks, 1)) * 100
+		if m.Up {
+			fmt.Fprintf(&sb, "✅ #%d %s: up for %s, %d ms", m.ID, m.String(), formatDowntime(now.Sub(m.Since)), m.Latency.Milliseconds())
+		} else {
+			down++
+			fmt.Fprintf(&sb, "🔴 #%d %s: down for %s: %s", m.ID, m.String(), formatDowntime(now.Sub(m.Since)), m.LastErr)
+		}
+		fmt.Fprintf(&sb, " (%.1f%% up since %s)\n", uptime, m.Created.Local().Format("Jan 2"))
+	}
+	header := fmt.Sprintf("📈 All %d monitors up", len(monitors))
+	if down > 0 {
+		header = fmt.Sprintf("📉 %d of %d monitors down", down, len(monitors))
+	}
+	return header + ":\n" + strings.TrimRight(sb.String(), "\n")
+}
+
+// poll checks every monitor and notifies chats about the ones that went
+// down or came back.
+func (u *UptimeTool) poll(ctx context.Context) error {
+	u.mu.Lock()
+	monitors := slices.Clone(u.monitors.Monitors)
+	u.mu.Unlock()
+	if len(monitors) == 0 {
+		return nil
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(uptimeWorkers, len(monitors)) {
+		wg.Go(func() {
+			for i := range jobs {
+				u.check(ctx, &monitors[i])
+			}
+		})
+	}
+	for i := range monitors {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// Keep only the results of monitors that weren't removed meanwhile
+	for i := range u.monitors.Monitors {
+		j := slices.IndexFunc(monitors, func(m uptimeMonitor) bool { return m.ID == u.monitors.Monitors[i].ID })
+		if j >= 0 {
+			u.monitors.Monitors[i] = monitors[j]
+		}
+	}
+	return u.host.Store.Save(uptimeStoreKey, u.monitors)
+}
+
+// check checks a monitor once, and messages its chat if that changes
+// whether it's up.
+func (u *UptimeTool) check(ctx context.Context, m *uptimeMonitor) {
+	latency, err := u.checkTarget(ctx, m.Target)
+	if ctx.Err() != nil {
+		return // Shutting down, which says nothing about the service
+	}
+	now := time.Now().UTC()
+	m.Checked = now
+	m.Checks++
+
+	if err == nil {
+		m.UpChecks++
+		m.Latency, m.Failures, m.LastErr = latency, 0, ""
+		if !m.Up {
+			log.Printf("%s #%d %s is back up", uptimeLogPrefix, m.ID, m.String())
+			u.host.Send(m.ChatID, fmt.Sprintf("✅ %s is back up after %s down (monitor #%d)",
+				m.String(), formatDowntime(now.Sub(m.Since)), m.ID))
+			m.Up, m.Since = true, now
+		}
+		return
+	}
+
+	m.Failures++
+	m.LastErr = err.Error()
+	if !m.Up || m.Failures < uptimeFailures {
+		return
+	}
+	// It went down at the first of the failed checks
+	m.Up, m.Since = false, now.Add(-time.Duration(uptimeFailures-1)*u.interval)
+	log.Printf("%s #%d %s is down: %v", uptimeLogPrefix, m.ID, m.String(), err)
+	send := u.host.Send
+	if u.host.SendUrgent != nil {
+		send = u.host.SendUrgent
+	}
+	send(m.ChatID, fmt.Sprintf("🔴 %s is down: %v (monitor #%d)", m.String(), err, m.ID))
+}
+
+// formatDowntime describes how long a state has lasted, to the minute.
+func formatDowntime(d time.Duration) string {
+	minutes := int(d.Round(time.Minute).Minutes())
+	switch {
+	case minutes < 1:
+		return "under a minute"
+	case minutes < 60:
+		return fmt.Sprintf("%d min", minutes)
+	case minutes < 48*60:
+		return fmt.Sprintf("%d h %d min", minutes/60, minutes%60)
+	default:
+		return fmt.Sprintf("%d days %d h", minutes/(24*60), minutes%(24*60)/60)
+	}
+}
@@ -0,0 +1,112 @@
+package tools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const maxPathSymlinks = 40 // Linux's limit when resolving a path
+
+// safePath resolves name (relative to root, or absolute) to an absolute path
+// inside root. It rejects paths that leave root lexically, such as nested
+// "../" sequences, and paths that leave it through a symlink, such as one
+// created by code the bot ran. The returned path is not symlink-resolved.
+func safePath(root, name string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("resolving workspace path: %w", err)
+	}
+
+	path := name
+	if !filepath.IsAbs(path) && filepath.VolumeName(path) != "" {
+		// Windows resolves C:name against that drive's current directory
+		return "", fmt.Errorf("path %s names a drive; give it relative to the workspace", name)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(absRoot, path)
+	}
+	path = filepath.Clean(path)
+	if !withinDir(absRoot, path) {
+		return "", fmt.Errorf("path %s is outside the workspace", name)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return path, nil // Nothing inside can be a symlink yet
+		}
+		return "", fmt.Errorf("resolving workspace path: %w", err)
+	}
+	real, err := evalExisting(path)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", name, err)
+	}
+	if !withinDir(realRoot, real) {
+		return "", fmt.Errorf("path %s resolves outside the workspace", name)
+	}
+	return path, nil
+}
+
+// evalExisting resolves every symlink in path, component by component,
+// including links whose targets don't exist yet, and appends the part that
+// doesn't exist. A write through a dangling link creates its target, so
+// the target has to be checked too.
+func evalExisting(path string) (string, error) {
+	vol := filepath.VolumeName(path)
+	resolved := vol + string(filepath.Separator)
+	rest := splitPath(path[len(vol):])
+	links := 0
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+		switch name {
+		case ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) || (err == nil && info.Mode()&os.ModeSymlink == 0) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if links++; links > maxPathSymlinks {
+			return "", fmt.Errorf("too many symlinks")
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		// Follow the target's components as written: cleaning it first
+		// would undo ".." lexically rather than after resolving links
+		if filepath.IsAbs(target) {
+			vol := filepath.VolumeName(target)
+			resolved = vol + string(filepath.Separator)
+			target = target[len(vol):]
+		}
+		rest = append(splitPath(target), rest...)
+	}
+	return resolved, nil
+}
+
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return os.IsPathSeparator(uint8(r)) })
+}
+
+// withinDir reports whether path is root or inside it.
+func withinDir(root, path string) bool {
+	if foldPaths {
+		root, path = strings.ToLower(root), strings.ToLower(path)
+	}
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
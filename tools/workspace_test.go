@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafePath(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "workspace")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{root, outside, filepath.Join(root, "sub")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"dangling-out": filepath.Join(outside, "missing"),
+		"dir-out":      outside,
+		"rel-out":      "../outside/file",
+		"dangling-in":  filepath.Join(root, "sub", "new"),
+		"rel-in":       "sub",
+		"chain":        "dangling-out",
+		"up-through":   "sub/../../outside",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		path string
+		ok   bool
+	}{
+		{"plain file", "file.txt", true},
+		{"new nested file", "a/b/c.txt", true},
+		{"lexical escape", "../outside/file", false},
+		{"absolute outside", outside, false},
+		{"dangling symlink outside", "dangling-out", false},
+		{"file under dangling symlink outside", "dangling-out/x/y", false},
+		{"symlink to outside dir", "dir-out", false},
+		{"file under symlink to outside dir", "dir-out/new.txt", false},
+		{"relative symlink outside", "rel-out", false},
+		{"chain to dangling outside", "chain", false},
+		{"dot-dot in link target", "up-through", false},
+		{"dangling symlink inside", "dangling-in", true},
+		{"relative symlink inside", "rel-in/file", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := safePath(root, tt.path)
+			if tt.ok && err != nil {
+				t.Errorf("safePath(%q) = %v, want ok", tt.path, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("safePath(%q) succeeded, want an error", tt.path)
+			}
+		})
+	}
+}
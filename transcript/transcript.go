@@ -0,0 +1,341 @@
+// Package transcript indexes chat history in SQLite for full-text search
+// with FTS5, and optionally by meaning with embeddings, so earlier
+// exchanges can be found after they've scrolled out of the conversation
+// the model sees.
+package transcript
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"telegram-bot/embed"
+)
+
+const (
+	logPrefix     = "[transcript]"
+	rrfK          = 60 // Damps reciprocal rank fusion, as in the original paper
+	minSimilarity = 0.35
+	excerptWords  = 24
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS turns (
+	id INTEGER PRIMARY KEY,
+	chat_id INTEGER NOT NULL,
+	message_id INTEGER NOT NULL,
+	time INTEGER NOT NULL,
+	user TEXT NOT NULL,
+	assistant TEXT NOT NULL,
+	vector BLOB,
+	UNIQUE (chat_id, message_id)
+);
+CREATE VIRTUAL TABLE IF NOT EXISTS turns_fts USING fts5(
+	user, assistant, content='turns', content_rowid='id', tokenize='porter unicode61'
+);
+CREATE TRIGGER IF NOT EXISTS turns_ai AFTER INSERT ON turns BEGIN
+	INSERT INTO turns_fts(rowid, user, assistant) VALUES (new.id, new.user, new.assistant);
+END;
+CREATE TRIGGER IF NOT EXISTS turns_ad AFTER DELETE ON turns BEGIN
+	INSERT INTO turns_fts(turns_fts, rowid, user, assistant) VALUES ('delete', old.id, old.user, old.assistant);
+END;
+`
+
+var words = regexp.MustCompile(`[\pL\pN_]+`)
+
+// stopWords are left out of queries, so asking a question matches on what
+// it's about rather than on "what" and "did".
+var stopWords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "can": true, "did": true, "do": true, "does": true, "for": true,
+	"from": true, "had": true, "has": true, "have": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "me": true, "my": true, "of": true, "on": true, "or": true,
+	"our": true, "so": true, "that": true, "the": true, "this": true, "to": true, "us": true,
+	"was": true, "we": true, "were": true, "what": true, "when": true, "where": true,
+	"which": true, "who": true, "why": true, "with": true, "you": true, "your": true,
+}
+
+// queryTerms returns the words of a query worth matching on, or all of
+// them if every one is a stop word.
+func queryTerms(query string) []string {
+	all := words.FindAllString(strings.ToLower(query), -1)
+	terms := slices.DeleteFunc(slices.Clone(all), func(w string) bool { return stopWords[w] })
+	if len(terms) == 0 {
+		return all
+	}
+	return terms
+}
+
+// Turn is one exchange to index.
+type Turn struct {
+	MessageID int
+	Time      time.Time
+	User      string
+	Assistant string
+}
+
+// Hit is an exchange matching a search, with an excerpt around the match.
+type Hit struct {
+	MessageID int
+	Time      time.Time
+	Excerpt   string
+}
+
+// Index is the searchable history of every chat.
+type Index struct {
+	db    *sql.DB
+	embed *embed.Client // Nil searches by words only
+}
+
+// Open creates or opens the index in the SQLite database at path.
+// Embeddings are used if embedder is non-nil.
+func Open(path string, embedder *embed.Client) (*Index, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("opening transcript index: %w", err)
+	}
+	db.SetMaxOpenConns(1) // SQLite allows one writer; this keeps callers from tripping over it
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating transcript index: %w", err)
+	}
+	return &Index{db: db, embed: embedder}, nil
+}
+
+// Close closes the database.
+func (x *Index) Close() error {
+	return x.db.Close()
+}
+
+// Add indexes exchanges from a chat. Ones already indexed are skipped, so
+// a chat's stored history can be added again to fill gaps.
+func (x *Index) Add(ctx context.Context, chatID int64, turns ...Turn) error {
+	var added []int64
+	var texts []string
+	for _, t := range turns {
+		res, err := x.db.ExecContext(ctx,
+			`INSERT OR IGNORE INTO turns (chat_id, message_id, time, user, assistant) VALUES (?, ?, ?, ?, ?)`,
+			chatID, t.MessageID, t.Time.Unix(), t.User, t.Assistant)
+		if err != nil {
+			return fmt.Errorf("indexing turn: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			id, _ := res.LastInsertId()
+			added = append(added, id)
+			texts = append(texts, t.User+"\n"+t.Assistant)
+		}
+	}
+	if x.embed == nil || len(added) == 0 {
+		return nil
+	}
+
+	// A failure only leaves these turns findable by words
+	vectors, err := x.embed.Embed(ctx, texts)
+	if err != nil {
+		log.Printf("%s embedding %d turns: %v", logPrefix, len(texts), err)
+		return nil
+	}
+	for i, id := range added {
+		if _, err := x.db.ExecContext(ctx, `UPDATE turns SET vector = ? WHERE id = ?`, encodeVector(vectors[i]), id); err != nil {
+			return fmt.Errorf("saving embedding: %w", err)
+		}
+	}
+	return nil
+}
+
+// Search returns up to limit of the chat's exchanges that best match the
+// query, best first: by words, and by meaning if embeddings are on, with
+// the two rankings fused.
+func (x *Index) Search(ctx context.Context, chatID int64, query string, limit int) ([]Hit, error) {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// Every word first; if nothing has them all, any of them
+	byWords, err := x.matchWords(ctx, chatID, terms, " ", limit*2)
+	if err != nil {
+		return nil, err
+	}
+	if len(byWords) == 0 && len(terms) > 1 {
+		if byWords, err = x.matchWords(ctx, chatID, terms, " OR ", limit*2); err != nil {
+			return nil, err
+		}
+	}
+
+	var byMeaning []int64
+	if x.embed != nil {
+		if byMeaning, err = x.matchMeaning(ctx, chatID, query, limit*2); err != nil {
+			log.Printf("%s searching by meaning: %v", logPrefix, err)
+		}
+	}
+
+	// Reciprocal rank fusion: a turn ranked high by either list comes first
+	scores := make(map[int64]float64)
+	for _, ranking := range [][]int64{byWords, byMeaning} {
+		for rank, id := range ranking {
+			scores[id] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	ids := make([]int64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b int64) int {
+		if scores[a] != scores[b] {
+			if scores[a] > scores[b] {
+				return -1
+			}
+			return 1
+		}
+		return int(b - a) // Newer first on ties
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	hits := make([]Hit, 0, len(ids))
+	for _, id := range ids {
+		var h Hit
+		var unix int64
+		var user, assistant string
+		err := x.db.QueryRowContext(ctx, `SELECT message_id, time, user, assistant FROM turns WHERE id = ?`, id).
+			Scan(&h.MessageID, &unix, &user, &assistant)
+		if err != nil {
+			return nil, fmt.Errorf("reading turn: %w", err)
+		}
+		h.Time = time.Unix(unix, 0)
+		h.Excerpt = excerpt(user, assistant, terms)
+		hits = append(hits, h)
+	}
+	return hits, nil
+}
+
+// matchWords ranks the chat's turns containing the terms, joined by op,
+// with BM25.
+func (x *Index) matchWords(ctx context.Context, chatID int64, terms []string, op string, limit int) ([]int64, error) {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + t + `"`
+	}
+	rows, err := x.db.QueryContext(ctx, `
+		SELECT turns.id FROM turns_fts JOIN turns ON turns.id = turns_fts.rowid
+		WHERE turns_fts MATCH ? AND turns.chat_id = ?
+		ORDER BY bm25(turns_fts) LIMIT ?`, strings.Join(quoted, op), chatID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("searching transcript: %w", err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// matchMeaning ranks the chat's embedded turns by cosine similarity to the
+// query, leaving out weak matches.
+func (x *Index) matchMeaning(ctx context.Context, chatID int64, query string, limit int) ([]int64, error) {
+	vectors, err := x.embed.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	q := vectors[0]
+
+	rows, err := x.db.QueryContext(ctx, `SELECT id, vector FROM turns WHERE chat_id = ? AND vector IS NOT NULL`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("reading embeddings: %w", err)
+	}
+	defer rows.Close()
+	type scored struct {
+		id  int64
+		sim float64
+	}
+	var matches []scored
+	for rows.Next() {
+		var id int64
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			return nil, err
+		}
+		if sim := embed.Cosine(q, decodeVector(blob)); sim >= minSimilarity {
+			matches = append(matches, scored{id, sim})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(matches, func(a, b scored) int {
+		if a.sim > b.sim {
+			return -1
+		}
+		if a.sim < b.sim {
+			return 1
+		}
+		return 0
+	})
+	ids := make([]int64, 0, min(limit, len(matches)))
+	for _, m := range matches[:min(limit, len(matches))] {
+		ids = append(ids, m.id)
+	}
+	return ids, nil
+}
+
+// excerpt returns the words around the first of the terms in the
+// exchange, or its opening words if none appears literally.
+func excerpt(user, assistant string, terms []string) string {
+	for _, side := range []struct{ who, text string }{{"You", user}, {"Bot", assistant}} {
+		fields := strings.Fields(side.text)
+		for i, f := range fields {
+			word := strings.ToLower(words.FindString(f))
+			if word == "" || !slices.ContainsFunc(terms, func(t string) bool { return strings.HasPrefix(word, t) }) {
+				continue
+			}
+			start := max(0, i-excerptWords/3)
+			end := min(len(fields), start+excerptWords)
+			text := strings.Join(fields[start:end], " ")
+			if start > 0 {
+				text = "…" + text
+			}
+			if end < len(fields) {
+				text += "…"
+			}
+			return side.who + ": " + text
+		}
+	}
+	fields := strings.Fields(user)
+	text := strings.Join(fields[:min(len(fields), excerptWords)], " ")
+	if len(fields) > excerptWords {
+		text += "…"
+	}
+	return "You: " + text
+}
+
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}